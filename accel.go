@@ -0,0 +1,178 @@
+package qemuctl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// Accelerators.
+const (
+	AccelKVM = "kvm"
+	AccelHVF = "hvf"
+	AccelTCG = "tcg"
+)
+
+// Accelerator fallback policies, for VMConfig.AccelFallback.
+const (
+	// AccelFallbackAuto runs the guest with hvf on macOS hosts of the guest
+	// architecture and with tcg otherwise.
+	AccelFallbackAuto = ""
+
+	// AccelFallbackTCG always falls back to tcg emulation.
+	AccelFallbackTCG = "tcg"
+
+	// AccelFallbackNone fails with an AccelUnavailableError.
+	AccelFallbackNone = "none"
+)
+
+// kvmDevice is the KVM device node.
+var kvmDevice = "/dev/kvm"
+
+// AccelUnavailableError is returned by StartVM when KVM cannot run the
+// guest and the configuration does not allow a fallback.
+type AccelUnavailableError struct {
+	// Accel is the accelerator that was asked for.
+	Accel string
+
+	// Reason tells why it cannot be used.
+	Reason string
+}
+
+func (e *AccelUnavailableError) Error() string {
+	return fmt.Sprintf("accelerator %s unavailable: %s", e.Accel, e.Reason)
+}
+
+// hostAccel returns the hardware accelerator of the host platform: hvf on
+// macOS, kvm elsewhere.
+func hostAccel() string {
+	if runtime.GOOS == "darwin" {
+		return AccelHVF
+	}
+	return AccelKVM
+}
+
+// probeAccel returns why a hardware accelerator cannot run guests of arch,
+// or an empty string if it can or is not a hardware accelerator.
+func probeAccel(accel, arch string) string {
+	switch accel {
+	case AccelKVM:
+		return probeKVM(arch)
+	case AccelHVF:
+		return probeHVF(arch)
+	}
+	return ""
+}
+
+// hostArch reports whether arch, a GOARCH value, runs natively on the host.
+// An empty arch is the host's.
+func hostArch(arch string) bool {
+	return arch == "" || arch == runtime.GOARCH || (arch == "386" && runtime.GOARCH == "amd64")
+}
+
+// probeHVF returns why the macOS Hypervisor framework cannot run guests of
+// arch, or an empty string if it can.
+func probeHVF(arch string) string {
+	switch {
+	case runtime.GOOS != "darwin":
+		return "hvf is only available on macOS"
+	case !hostArch(arch):
+		return fmt.Sprintf("%s guests cannot run on a %s host", arch, runtime.GOARCH)
+	}
+	return ""
+}
+
+// probeKVM returns why KVM cannot run guests of arch, or an empty string if
+// it can. KVM needs a guest of the host architecture and read-write access
+// to /dev/kvm.
+func probeKVM(arch string) string {
+	if !hostArch(arch) {
+		return fmt.Sprintf("%s guests cannot run on a %s host", arch, runtime.GOARCH)
+	}
+
+	f, err := os.OpenFile(kvmDevice, os.O_RDWR, 0)
+	switch {
+	case err == nil:
+		f.Close()
+		return ""
+	case errors.Is(err, os.ErrNotExist):
+		return kvmDevice + " does not exist; is the kvm module loaded?"
+	case errors.Is(err, os.ErrPermission):
+		return "no permission to open " + kvmDevice + "; is the user in the kvm group?"
+	}
+	return err.Error()
+}
+
+// requestedAccel returns the accelerator the configuration asks for. The
+// default machine runs with the host's accelerator.
+func (cfg *VMConfig) requestedAccel() string {
+	if cfg.Machine == nil {
+		if profileFor(cfg.Arch).machine == "" {
+			return ""
+		}
+		return hostAccel()
+	}
+	return cfg.Machine.Accel
+}
+
+// resolveAccel checks that KVM or hvf can run a configuration asking for
+// it. If it cannot, resolveAccel returns a copy of the configuration using the
+// fallback accelerator, with the "host" CPU model, also the default,
+// replaced by "max" under tcg, which has no host CPU to pass through. The
+// accelerator that will run the guest is returned with the configuration.
+func (cfg *VMConfig) resolveAccel() (*VMConfig, string, error) {
+	accel := cfg.requestedAccel()
+	reason := probeAccel(accel, cfg.Arch)
+	if reason == "" {
+		return cfg, accel, nil
+	}
+
+	switch {
+	case cfg.TDX != nil:
+		return nil, "", &AccelUnavailableError{Accel: accel, Reason: reason + " (TDX guests need KVM)"}
+	case cfg.AccelFallback == AccelFallbackNone:
+		return nil, "", &AccelUnavailableError{Accel: accel, Reason: reason}
+	case cfg.AccelFallback == AccelFallbackAuto && accel != AccelHVF && probeHVF(cfg.Arch) == "":
+		accel = AccelHVF
+	default:
+		accel = AccelTCG
+	}
+
+	c := *cfg
+	machine := MachineConfig{Type: profileFor(cfg.Arch).machine}
+	if cfg.Machine != nil {
+		machine = *cfg.Machine
+	}
+	machine.Accel = accel
+	c.Machine = &machine
+
+	if accel == AccelTCG && (cfg.CPU == nil || cfg.CPU.Model == "host") {
+		cpu := CPUConfig{}
+		if cfg.CPU != nil {
+			cpu = *cfg.CPU
+		}
+		cpu.Model = "max"
+		c.CPU = &cpu
+	}
+	return &c, accel, nil
+}
+
+// resolveAccel drops hardware acceleration from a configuration that
+// leaves it to availability (KVM unset) when the host's accelerator cannot
+// run the guest. QEMU then runs the guest with tcg and its default CPU
+// model. The accelerator that will run the guest is returned with the
+// configuration.
+func (c *Config) resolveAccel() (*Config, string) {
+	if profileFor(c.Arch).machine == "" || (c.KVM != nil && !*c.KVM) {
+		return c, ""
+	}
+	if c.KVM != nil || probeAccel(hostAccel(), c.Arch) == "" {
+		return c, hostAccel()
+	}
+
+	cp := *c
+	kvm := false
+	cp.KVM = &kvm
+	return &cp, AccelTCG
+}
@@ -0,0 +1,71 @@
+package qemuctl
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestResolveAccel(t *testing.T) {
+	dev := filepath.Join(t.TempDir(), "kvm")
+	old := kvmDevice
+	kvmDevice = dev
+	defer func() { kvmDevice = old }()
+
+	// Without /dev/kvm the guest falls back to emulation
+	fallback := AccelTCG
+	if runtime.GOOS == "darwin" {
+		fallback = AccelHVF
+	}
+	kvm := func() *MachineConfig { return &MachineConfig{Type: profileFor("").machine, Accel: AccelKVM} }
+	cfg := &VMConfig{Machine: kvm()}
+	resolved, accel, err := cfg.resolveAccel()
+	if err != nil {
+		t.Fatalf("resolveAccel failed: %v", err)
+	}
+	if accel != fallback || resolved.Machine.Accel != fallback || cfg.Machine.Accel != AccelKVM {
+		t.Errorf("accel = %q, machine = %+v, want %s on a copy", accel, resolved.Machine, fallback)
+	}
+	argsStr := strings.Join(NewVMBuilder(resolved).Build("test-vm", ""), " ")
+	if fallback == AccelTCG && (!strings.Contains(argsStr, "accel=tcg") || !strings.Contains(argsStr, "-cpu max")) {
+		t.Errorf("unexpected fallback arguments: %s", argsStr)
+	}
+
+	_, _, err = (&VMConfig{Machine: kvm(), AccelFallback: AccelFallbackNone}).resolveAccel()
+	var aerr *AccelUnavailableError
+	if !errors.As(err, &aerr) || aerr.Accel != AccelKVM || !strings.Contains(aerr.Reason, "does not exist") {
+		t.Errorf("expected AccelUnavailableError, got %v", err)
+	}
+	if _, _, err := (&VMConfig{Machine: kvm(), TDX: &TDXConfig{}}).resolveAccel(); !errors.As(err, &aerr) {
+		t.Errorf("TDX guest fell back: %v", err)
+	}
+
+	// A usable /dev/kvm keeps the configuration
+	os.WriteFile(dev, nil, 0o600)
+	if resolved, accel, err := cfg.resolveAccel(); err != nil || accel != AccelKVM || resolved != cfg {
+		t.Errorf("resolveAccel = %q, %v, want kvm", accel, err)
+	}
+
+	// Other architectures cannot use KVM
+	foreign := "s390x"
+	if runtime.GOARCH == foreign {
+		foreign = "amd64"
+	}
+	resolved, accel, err = (&VMConfig{Arch: foreign, AccelFallback: AccelFallbackTCG}).resolveAccel()
+	if err != nil || accel != AccelTCG || resolved.Machine.Type != profileFor(foreign).machine {
+		t.Errorf("resolveAccel(%s) = %q, %+v, %v", foreign, accel, resolved.Machine, err)
+	}
+
+	// Guests not asking for KVM are left alone
+	tcg := &VMConfig{Machine: &MachineConfig{Type: "q35", Accel: AccelTCG}}
+	if resolved, accel, _ := tcg.resolveAccel(); resolved != tcg || accel != AccelTCG {
+		t.Errorf("tcg guest changed: %q", accel)
+	}
+
+	if err := (&VMConfig{AccelFallback: "kvm"}).Validate(); err == nil {
+		t.Error("Validate accepted an unknown fallback")
+	}
+}
@@ -0,0 +1,395 @@
+package qemuctl
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// guestAgentChannel is the virtio-serial port name of the QEMU guest agent.
+const guestAgentChannel = "org.qemu.guest_agent.0"
+
+// defaultAgentTimeout bounds agent calls when the context has no deadline.
+const defaultAgentTimeout = 30 * time.Second
+
+// Timing of reconnection to a guest agent that went away.
+const (
+	// agentSyncInterval is how long a sync waits before it is resent. An
+	// agent that is not running yet drops it.
+	agentSyncInterval = 2 * time.Second
+
+	// agentRetryDelay is the pause between reconnection attempts.
+	agentRetryDelay = 250 * time.Millisecond
+)
+
+// idempotentAgentCommands are the agent commands that only read guest state
+// or can safely run twice. They are retried when the connection is lost
+// before their response arrives.
+var idempotentAgentCommands = map[string]bool{
+	"guest-ping":                   true,
+	"guest-info":                   true,
+	"guest-get-osinfo":             true,
+	"guest-get-host-name":          true,
+	"guest-get-time":               true,
+	"guest-get-timezone":           true,
+	"guest-get-users":              true,
+	"guest-get-vcpus":              true,
+	"guest-get-fsinfo":             true,
+	"guest-get-disks":              true,
+	"guest-get-devices":            true,
+	"guest-get-memory-blocks":      true,
+	"guest-get-memory-block-info":  true,
+	"guest-get-cpustats":           true,
+	"guest-get-diskstats":          true,
+	"guest-network-get-interfaces": true,
+	"guest-network-get-route":      true,
+	"guest-fsfreeze-status":        true,
+	"guest-exec-status":            true,
+	"guest-fstrim":                 true,
+}
+
+// GuestAgent is a client for the QEMU guest agent (qemu-ga) running inside
+// the guest, reached through the host side of its virtio-serial chardev.
+type GuestAgent struct {
+	socketPath string
+
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+
+	// active is conn, guarded by its own lock so that interrupt can close
+	// it while a call holds mu.
+	activeMu sync.Mutex
+	active   net.Conn
+}
+
+// GuestNetworkInterface is a guest network interface reported by the agent.
+type GuestNetworkInterface struct {
+	Name            string `json:"name"`
+	HardwareAddress string `json:"hardware-address,omitempty"`
+	IPAddresses     []struct {
+		Type    string `json:"ip-address-type"`
+		Address string `json:"ip-address"`
+		Prefix  int    `json:"prefix"`
+	} `json:"ip-addresses,omitempty"`
+}
+
+// NewGuestAgent returns a client for the guest agent chardev socket at
+// socketPath. The connection is established on first use.
+func NewGuestAgent(socketPath string) *GuestAgent {
+	return &GuestAgent{socketPath: socketPath}
+}
+
+// SocketPath returns the path to the guest agent socket.
+func (g *GuestAgent) SocketPath() string {
+	return g.socketPath
+}
+
+// Execute sends a command to the guest agent and waits for the response.
+//
+// The agent goes away when it restarts or the guest reboots. If that happens
+// while a read-only command such as guest-ping is in flight, Execute
+// reconnects once the agent is back and sends the command again, until the
+// context deadline. Other commands fail with ErrGuestAgentDisconnected, as
+// they may already have run.
+func (g *GuestAgent) Execute(ctx context.Context, command string, args map[string]any) (json.RawMessage, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultAgentTimeout)
+	}
+
+	retrying := false
+	for {
+		if g.conn == nil {
+			if err := g.connect(ctx, deadline); err != nil {
+				if !retrying || !g.waitRetry(ctx, deadline) {
+					return nil, err
+				}
+				continue
+			}
+		}
+
+		g.conn.SetDeadline(deadline)
+		result, err := g.roundTrip(command, args)
+		if err == nil {
+			return result, nil
+		}
+		var qerr *QMPError
+		if errors.As(err, &qerr) {
+			return nil, err
+		}
+
+		// The stream may be out of sync; start over
+		g.closeLocked()
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return nil, err
+		}
+		if !idempotentAgentCommands[command] {
+			return nil, fmt.Errorf("%w during %s: %w", ErrGuestAgentDisconnected, command, err)
+		}
+		if !g.waitRetry(ctx, deadline) {
+			return nil, err
+		}
+		retrying = true
+	}
+}
+
+// waitRetry pauses before a reconnection attempt. It returns false when the
+// call has run out of time.
+func (g *GuestAgent) waitRetry(ctx context.Context, deadline time.Time) bool {
+	if time.Until(deadline) < agentRetryDelay {
+		return false
+	}
+	select {
+	case <-time.After(agentRetryDelay):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// notify sends a command that has no response on success, such as
+// guest-shutdown. The connection is closed afterwards, so an error response
+// cannot be mistaken for the reply to a later command.
+func (g *GuestAgent) notify(ctx context.Context, command string, args map[string]any) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultAgentTimeout)
+	}
+
+	if g.conn == nil {
+		if err := g.connect(ctx, deadline); err != nil {
+			return err
+		}
+	}
+
+	g.conn.SetDeadline(deadline)
+	err := json.NewEncoder(g.conn).Encode(qmpCommand{Execute: command, Arguments: args})
+	g.closeLocked()
+	if err != nil {
+		return fmt.Errorf("failed to send guest agent command: %w", err)
+	}
+	return nil
+}
+
+// connect dials the agent socket and synchronizes the stream.
+func (g *GuestAgent) connect(ctx context.Context, deadline time.Time) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", g.socketPath)
+	if err != nil {
+		return fmt.Errorf("failed to connect to guest agent: %w", err)
+	}
+
+	g.conn = conn
+	g.reader = bufio.NewReader(conn)
+	g.activeMu.Lock()
+	g.active = conn
+	g.activeMu.Unlock()
+
+	if err := g.sync(deadline); err != nil {
+		g.closeLocked()
+		return err
+	}
+	return nil
+}
+
+// sync discards stale data with guest-sync-delimited, whose response is
+// preceded by a 0xFF marker byte. QEMU accepts the connection even when
+// the agent is not running, so the sync is resent every agentSyncInterval
+// until the agent answers or the deadline passes.
+func (g *GuestAgent) sync(deadline time.Time) error {
+	for {
+		attempt := time.Now().Add(agentSyncInterval)
+		if attempt.After(deadline) {
+			attempt = deadline
+		}
+		g.conn.SetDeadline(attempt)
+
+		err := g.syncOnce()
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, os.ErrDeadlineExceeded) || !time.Now().Before(deadline) {
+			return err
+		}
+	}
+}
+
+// syncOnce sends one guest-sync-delimited and waits for its response.
+// Responses to earlier syncs are skipped.
+func (g *GuestAgent) syncOnce() error {
+	id := rand.Int63n(1 << 31)
+	if err := json.NewEncoder(g.conn).Encode(qmpCommand{
+		Execute:   "guest-sync-delimited",
+		Arguments: map[string]any{"id": id},
+	}); err != nil {
+		return fmt.Errorf("failed to sync guest agent: %w", err)
+	}
+
+	for {
+		if _, err := g.reader.ReadBytes(0xff); err != nil {
+			return fmt.Errorf("failed to sync guest agent: %w", err)
+		}
+		line, err := g.reader.ReadBytes('\n')
+		if err != nil {
+			return fmt.Errorf("failed to sync guest agent: %w", err)
+		}
+
+		var resp struct {
+			Return int64 `json:"return"`
+		}
+		if json.Unmarshal(line, &resp) == nil && resp.Return == id {
+			return nil
+		}
+	}
+}
+
+// roundTrip writes a command and reads its response.
+func (g *GuestAgent) roundTrip(command string, args map[string]any) (json.RawMessage, error) {
+	if err := json.NewEncoder(g.conn).Encode(qmpCommand{Execute: command, Arguments: args}); err != nil {
+		return nil, fmt.Errorf("failed to send guest agent command: %w", err)
+	}
+
+	line, err := g.reader.ReadBytes('\n')
+	if err != nil {
+		return nil, fmt.Errorf("failed to read guest agent response: %w", err)
+	}
+
+	var resp qmpResponse
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return nil, fmt.Errorf("invalid guest agent response: %w", err)
+	}
+	if resp.Error != nil {
+		return nil, &QMPError{Class: resp.Error.Class, Description: resp.Error.Desc}
+	}
+	return resp.Return, nil
+}
+
+// Ping checks that the guest agent is responding.
+func (g *GuestAgent) Ping(ctx context.Context) error {
+	_, err := g.Execute(ctx, "guest-ping", nil)
+	return err
+}
+
+// NetworkInterfaces returns the guest network interfaces and addresses.
+func (g *GuestAgent) NetworkInterfaces(ctx context.Context) ([]GuestNetworkInterface, error) {
+	result, err := g.Execute(ctx, "guest-network-get-interfaces", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var ifaces []GuestNetworkInterface
+	if err := unmarshalJSON(result, &ifaces); err != nil {
+		return nil, err
+	}
+	return ifaces, nil
+}
+
+// Close closes the connection to the guest agent.
+func (g *GuestAgent) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.closeLocked()
+}
+
+func (g *GuestAgent) closeLocked() error {
+	if g.conn == nil {
+		return nil
+	}
+	g.activeMu.Lock()
+	g.active = nil
+	g.activeMu.Unlock()
+
+	err := g.conn.Close()
+	g.conn = nil
+	g.reader = nil
+	if errors.Is(err, net.ErrClosed) {
+		// Already closed by interrupt
+		err = nil
+	}
+	return err
+}
+
+// interrupt closes the connection under a call in flight, which then
+// fails or reconnects as if the agent had gone away.
+func (g *GuestAgent) interrupt() {
+	g.activeMu.Lock()
+	defer g.activeMu.Unlock()
+	if g.active != nil {
+		g.active.Close()
+	}
+}
+
+// GuestAgent returns a client for the instance's guest agent, or nil if the
+// configuration has no guest agent channel (see VMConfig.WithGuestAgent).
+func (i *Instance) GuestAgent() *GuestAgent {
+	i.agentMu.Lock()
+	defer i.agentMu.Unlock()
+
+	if i.agent == nil {
+		if path := guestAgentSocket(i.vmConfig); path != "" {
+			i.agent = NewGuestAgent(path)
+		}
+	}
+	return i.agent
+}
+
+// recordAgentPort interrupts guest agent calls when the guest closes the
+// agent port, which it does when the agent stops, so that they do not wait
+// for a response that will never come.
+func (i *Instance) recordAgentPort(event *Event) {
+	p, _ := event.Payload().(*VserportChangeEvent)
+	if p == nil || p.Open {
+		return
+	}
+	port, _ := guestAgentPort(i.vmConfig)
+	if port == nil || port.ID == "" || port.ID != p.ID {
+		return
+	}
+
+	i.agentMu.Lock()
+	agent := i.agent
+	i.agentMu.Unlock()
+	if agent != nil {
+		agent.interrupt()
+	}
+}
+
+// guestAgentSocket returns the host socket path of the guest agent channel.
+func guestAgentSocket(cfg *VMConfig) string {
+	_, path := guestAgentPort(cfg)
+	return path
+}
+
+// guestAgentPort returns the guest agent port and the host socket path of
+// its chardev.
+func guestAgentPort(cfg *VMConfig) (*VirtioSerialPortConfig, string) {
+	if cfg == nil || cfg.VirtioSerial == nil {
+		return nil, ""
+	}
+
+	for n, port := range cfg.VirtioSerial.Ports {
+		if port.Name != guestAgentChannel {
+			continue
+		}
+		for _, ch := range cfg.Chardevs {
+			if ch.ID == port.Chardev && ch.Backend == "socket" && ch.Server {
+				return &cfg.VirtioSerial.Ports[n], ch.Path
+			}
+		}
+	}
+	return nil, ""
+}
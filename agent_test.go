@@ -0,0 +1,282 @@
+package qemuctl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newFakeGuestAgent starts a fake qemu-ga answering on a socket in a
+// temporary directory. It returns the socket path. onShutdown, if given, is
+// called with the mode of guest-shutdown requests, which like the real
+// agent get no response.
+func newFakeGuestAgent(t *testing.T, ifaces []map[string]any, onShutdown ...func(mode string)) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "qga.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				// Stale output from a previous session
+				conn.Write([]byte(`{"return": {}}` + "\n"))
+
+				dec := json.NewDecoder(conn)
+				enc := json.NewEncoder(conn)
+				for {
+					var cmd qmpCommand
+					if err := dec.Decode(&cmd); err != nil {
+						return
+					}
+					switch cmd.Execute {
+					case "guest-sync-delimited":
+						conn.Write([]byte{0xff})
+						enc.Encode(map[string]any{"return": cmd.Arguments["id"]})
+					case "guest-ping":
+						enc.Encode(map[string]any{"return": map[string]any{}})
+					case "guest-network-get-interfaces":
+						enc.Encode(map[string]any{"return": ifaces})
+					case "guest-fstrim":
+						enc.Encode(map[string]any{"return": map[string]any{"paths": []map[string]any{
+							{"path": "/", "trimmed": 1 << 20, "minimum": 0},
+							{"path": "/boot", "error": "Operation not supported"},
+						}}})
+					case "guest-shutdown":
+						mode, _ := cmd.Arguments["mode"].(string)
+						for _, fn := range onShutdown {
+							fn(mode)
+						}
+					default:
+						enc.Encode(map[string]any{"error": map[string]any{"class": "CommandNotFound", "desc": "unknown command"}})
+					}
+				}
+			}()
+		}
+	}()
+
+	return path
+}
+
+func TestGuestAgent(t *testing.T) {
+	path := newFakeGuestAgent(t, []map[string]any{
+		{"name": "lo", "ip-addresses": []map[string]any{{"ip-address-type": "ipv4", "ip-address": "127.0.0.1", "prefix": 8}}},
+		{"name": "eth0", "ip-addresses": []map[string]any{{"ip-address-type": "ipv4", "ip-address": "10.0.2.15", "prefix": 24}}},
+	})
+
+	agent := NewGuestAgent(path)
+	defer agent.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := agent.Ping(ctx); err != nil {
+		t.Fatalf("Ping error: %v", err)
+	}
+
+	ifaces, err := agent.NetworkInterfaces(ctx)
+	if err != nil {
+		t.Fatalf("NetworkInterfaces error: %v", err)
+	}
+	if len(ifaces) != 2 || ifaces[1].IPAddresses[0].Address != "10.0.2.15" {
+		t.Errorf("unexpected interfaces: %+v", ifaces)
+	}
+	if !hasGuestIP(ifaces) || hasGuestIP(ifaces[:1]) {
+		t.Error("unexpected hasGuestIP result")
+	}
+
+	// Agent errors do not break the connection
+	if _, err := agent.Execute(ctx, "guest-unknown", nil); err == nil {
+		t.Error("expected error for unknown command")
+	}
+	if err := agent.Ping(ctx); err != nil {
+		t.Errorf("Ping after error: %v", err)
+	}
+}
+
+func TestInstanceGuestAgent(t *testing.T) {
+	if (&Instance{}).GuestAgent() != nil {
+		t.Error("expected no guest agent without configuration")
+	}
+
+	cfg := DefaultVMConfig().WithGuestAgent("/run/qemu/vm-qga.sock")
+	inst := &Instance{vmConfig: cfg}
+	agent := inst.GuestAgent()
+	if agent == nil || agent.SocketPath() != "/run/qemu/vm-qga.sock" {
+		t.Fatalf("unexpected guest agent: %+v", agent)
+	}
+	if inst.GuestAgent() != agent {
+		t.Error("expected the same guest agent client")
+	}
+}
+
+func TestBootTimings(t *testing.T) {
+	fake := newFakeQMP(t)
+	inst := fake.attach()
+	inst.timings.ProcessStart = time.Now().Add(-time.Second)
+	inst.qmp.addEventHook(inst.recordBootEvent)
+
+	// The initial status query reports the VM running
+	timings := inst.BootTimings()
+	if timings.FirstResume.IsZero() {
+		t.Error("expected FirstResume to be set")
+	}
+	if d := timings.Since(timings.FirstResume); d <= 0 {
+		t.Errorf("unexpected FirstResume delay: %v", d)
+	}
+
+	fake.sendEvent("RTC_CHANGE", map[string]any{"offset": 0})
+	deadline := time.Now().Add(5 * time.Second)
+	for inst.BootTimings().FirstRTCChange.IsZero() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if inst.BootTimings().FirstRTCChange.IsZero() {
+		t.Error("expected FirstRTCChange to be set")
+	}
+
+	// Guest agent phases
+	path := newFakeGuestAgent(t, []map[string]any{
+		{"name": "eth0", "ip-addresses": []map[string]any{{"ip-address-type": "ipv4", "ip-address": "192.168.1.10", "prefix": 24}}},
+	})
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		inst.trackGuestBoot(NewGuestAgent(path), done)
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		close(done)
+		t.Fatal("trackGuestBoot did not finish")
+	}
+
+	timings = inst.BootTimings()
+	if timings.GuestAgentReady.IsZero() || timings.FirstIP.IsZero() {
+		t.Errorf("expected guest agent timings, got %+v", timings)
+	}
+}
+
+// newRestartingGuestAgent starts a fake qemu-ga that goes away during the
+// first command after a sync, as when the agent restarts. With hang set,
+// the first command is left unanswered instead of closing the connection.
+// It returns the socket path and the number of commands received.
+func newRestartingGuestAgent(t *testing.T, hang bool) (string, *atomic.Int32) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "qga.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	var received atomic.Int32
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				dec := json.NewDecoder(conn)
+				enc := json.NewEncoder(conn)
+				for {
+					var cmd qmpCommand
+					if err := dec.Decode(&cmd); err != nil {
+						return
+					}
+					if cmd.Execute == "guest-sync-delimited" {
+						conn.Write([]byte{0xff})
+						enc.Encode(map[string]any{"return": cmd.Arguments["id"]})
+						continue
+					}
+					if received.Add(1) == 1 {
+						if hang {
+							io.Copy(io.Discard, conn)
+						}
+						return
+					}
+					enc.Encode(map[string]any{"return": map[string]any{}})
+				}
+			}()
+		}
+	}()
+
+	return path, &received
+}
+
+func TestGuestAgentReconnect(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Read-only commands are sent again on a new connection
+	path, received := newRestartingGuestAgent(t, false)
+	agent := NewGuestAgent(path)
+	defer agent.Close()
+	if err := agent.Ping(ctx); err != nil {
+		t.Fatalf("Ping error: %v", err)
+	}
+	if n := received.Load(); n != 2 {
+		t.Errorf("agent received %d commands, want 2", n)
+	}
+
+	// Others may have run, and are not repeated
+	path, received = newRestartingGuestAgent(t, false)
+	agent = NewGuestAgent(path)
+	defer agent.Close()
+	_, err := agent.Execute(ctx, "guest-exec", map[string]any{"path": "/bin/true"})
+	if !errors.Is(err, ErrGuestAgentDisconnected) {
+		t.Fatalf("expected ErrGuestAgentDisconnected, got %v", err)
+	}
+	if n := received.Load(); n != 1 {
+		t.Errorf("agent received %d commands, want 1", n)
+	}
+	if err := agent.Ping(ctx); err != nil {
+		t.Errorf("Ping after disconnect: %v", err)
+	}
+}
+
+func TestInstanceGuestAgentPortClosed(t *testing.T) {
+	fake := newFakeQMP(t)
+	inst := fake.attach()
+
+	path, received := newRestartingGuestAgent(t, true)
+	inst.vmConfig = DefaultVMConfig().WithGuestAgent(path)
+	agent := inst.GuestAgent()
+	defer agent.Close()
+
+	go func() {
+		for received.Load() == 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		fake.sendEvent("VSERPORT_CHANGE", map[string]any{"id": "qga0-port", "open": false})
+	}()
+
+	// Without the event the ping would wait for its deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := agent.Ping(ctx); err != nil {
+		t.Fatalf("Ping error: %v", err)
+	}
+	if n := received.Load(); n != 2 {
+		t.Errorf("agent received %d commands, want 2", n)
+	}
+}
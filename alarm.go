@@ -0,0 +1,246 @@
+package qemuctl
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Metric reads a value of an instance, for alarms.
+type Metric func(inst *Instance) (float64, error)
+
+// Alarm fires when a metric crosses a threshold for long enough, and
+// clears once it is back.
+type Alarm struct {
+	// Name identifies the alarm in notifications.
+	Name string
+
+	// Metric is the polled value, such as BlockErrors().
+	Metric Metric
+
+	// Rate compares the per-second rate of change of the metric instead of
+	// its value, for counters.
+	Rate bool
+
+	// Threshold is the limit the value must exceed, or stay under with
+	// Below set.
+	Threshold float64
+	Below     bool
+
+	// For is how long the threshold must be crossed before the alarm
+	// fires. Zero fires on the first crossing sample.
+	For time.Duration
+}
+
+// AlarmEvent notifies that an alarm fired or cleared.
+type AlarmEvent struct {
+	Alarm *Alarm
+	VM    string
+
+	// Firing is true when the alarm fires, false when it clears.
+	Firing bool
+
+	// Value is the sample that fired or cleared the alarm, Since when the
+	// threshold was first crossed.
+	Value float64
+	Since time.Time
+	Time  time.Time
+}
+
+// alarmState is the evaluation state of an alarm.
+type alarmState struct {
+	alarm  *Alarm
+	since  time.Time
+	firing bool
+
+	// Previous sample, for rates
+	last     float64
+	lastTime time.Time
+}
+
+// WatchAlarms polls the alarm metrics every interval and calls notify when
+// an alarm fires or clears, until ctx is done or the monitor connection is
+// lost. Samples whose metric fails are skipped.
+func (i *Instance) WatchAlarms(ctx context.Context, interval time.Duration, alarms []*Alarm, notify func(AlarmEvent)) error {
+	states := make([]*alarmState, len(alarms))
+	for idx, a := range alarms {
+		states[idx] = &alarmState{alarm: a}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		i.qmpMu.Lock()
+		qmp := i.qmp
+		i.qmpMu.Unlock()
+		if qmp == nil {
+			return ErrNotConnected
+		}
+
+		now := time.Now()
+		for _, s := range states {
+			v, err := s.alarm.Metric(i)
+			if err != nil {
+				continue
+			}
+			if event, ok := s.sample(v, now); ok {
+				event.VM = i.name
+				notify(event)
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-qmp.closeCh:
+			return qmp.closedErr()
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// sample evaluates the alarm with a new metric value, and returns an event
+// if the alarm fired or cleared.
+func (s *alarmState) sample(v float64, now time.Time) (AlarmEvent, bool) {
+	a := s.alarm
+	if a.Rate {
+		prev, prevTime := s.last, s.lastTime
+		s.last, s.lastTime = v, now
+		if prevTime.IsZero() || !now.After(prevTime) {
+			return AlarmEvent{}, false
+		}
+		v = (v - prev) / now.Sub(prevTime).Seconds()
+	}
+
+	crossed := v > a.Threshold
+	if a.Below {
+		crossed = v < a.Threshold
+	}
+	if !crossed {
+		since := s.since
+		s.since = time.Time{}
+		if s.firing {
+			s.firing = false
+			return AlarmEvent{Alarm: a, Value: v, Since: since, Time: now}, true
+		}
+		return AlarmEvent{}, false
+	}
+
+	if s.since.IsZero() {
+		s.since = now
+	}
+	if !s.firing && now.Sub(s.since) >= a.For {
+		s.firing = true
+		return AlarmEvent{Alarm: a, Firing: true, Value: v, Since: s.since, Time: now}, true
+	}
+	return AlarmEvent{}, false
+}
+
+// BlockErrors is the total number of failed read, write and flush
+// operations of all block devices (query-blockstats). Alarm on it with a
+// threshold of 0 to be told of any I/O error.
+func BlockErrors() Metric {
+	return func(inst *Instance) (float64, error) {
+		qmp := inst.QMP()
+		if qmp == nil {
+			return 0, ErrNotConnected
+		}
+		result, err := qmp.Execute("query-blockstats", nil)
+		if err != nil {
+			return 0, err
+		}
+
+		var devices []struct {
+			Stats struct {
+				FailedRd    int64 `json:"failed_rd_operations"`
+				FailedWr    int64 `json:"failed_wr_operations"`
+				FailedFlush int64 `json:"failed_flush_operations"`
+			} `json:"stats"`
+		}
+		if err := unmarshalJSON(result, &devices); err != nil {
+			return 0, err
+		}
+		total := int64(0)
+		for _, d := range devices {
+			total += d.Stats.FailedRd + d.Stats.FailedWr + d.Stats.FailedFlush
+		}
+		return float64(total), nil
+	}
+}
+
+// BalloonSize is the current guest memory size in bytes, as set by the
+// balloon (query-balloon). Alarm on it with Below set to the balloon target
+// to be told when the guest does not give memory back.
+func BalloonSize() Metric {
+	return func(inst *Instance) (float64, error) {
+		qmp := inst.QMP()
+		if qmp == nil {
+			return 0, ErrNotConnected
+		}
+		result, err := qmp.Execute("query-balloon", nil)
+		if err != nil {
+			return 0, err
+		}
+
+		var info struct {
+			Actual int64 `json:"actual"`
+		}
+		if err := unmarshalJSON(result, &info); err != nil {
+			return 0, err
+		}
+		return float64(info.Actual), nil
+	}
+}
+
+// CPUSteal is the total time in seconds the vCPU threads waited for a host
+// CPU, which guests see as steal time. It is a counter: alarm on its rate,
+// the number of vCPUs kept waiting on average. Linux only.
+func CPUSteal() Metric {
+	return func(inst *Instance) (float64, error) {
+		qmp := inst.QMP()
+		if qmp == nil {
+			return 0, ErrNotConnected
+		}
+		result, err := qmp.Execute("query-cpus-fast", nil)
+		if err != nil {
+			return 0, err
+		}
+
+		var cpus []struct {
+			ThreadID int `json:"thread-id"`
+		}
+		if err := unmarshalJSON(result, &cpus); err != nil {
+			return 0, err
+		}
+		total := 0.0
+		for _, cpu := range cpus {
+			wait, err := threadRunDelay(inst.PID(), cpu.ThreadID)
+			if err != nil {
+				return 0, err
+			}
+			total += wait
+		}
+		return total, nil
+	}
+}
+
+// threadRunDelay returns the time in seconds a thread spent waiting on a
+// run queue, from /proc/<pid>/task/<tid>/schedstat.
+func threadRunDelay(pid, tid int) (float64, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/task/%d/schedstat", pid, tid))
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 2 {
+		return 0, fmt.Errorf("unexpected schedstat %q", data)
+	}
+	ns, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return float64(ns) / 1e9, nil
+}
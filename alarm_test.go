@@ -0,0 +1,91 @@
+package qemuctl
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestAlarmSample(t *testing.T) {
+	start := time.Now()
+	at := func(minutes int) time.Time { return start.Add(time.Duration(minutes) * time.Minute) }
+
+	s := &alarmState{alarm: &Alarm{Threshold: 1 << 30, Below: true, For: 5 * time.Minute}}
+	for _, step := range []struct {
+		minute int
+		value  float64
+		event  bool
+		firing bool
+	}{
+		{0, 2 << 30, false, false},
+		{1, 512 << 20, false, false}, // below target, not for long enough yet
+		{4, 512 << 20, false, false},
+		{6, 512 << 20, true, true},
+		{7, 512 << 20, false, false}, // fires once
+		{8, 2 << 30, true, false},    // clears
+	} {
+		event, ok := s.sample(step.value, at(step.minute))
+		if ok != step.event || (ok && event.Firing != step.firing) {
+			t.Errorf("minute %d: event = %+v, %v", step.minute, event, ok)
+		}
+		if ok && step.firing && !event.Since.Equal(at(1)) {
+			t.Errorf("minute %d: since = %v", step.minute, event.Since)
+		}
+	}
+
+	rate := &alarmState{alarm: &Alarm{Rate: true, Threshold: 0.5}}
+	if _, ok := rate.sample(10, at(0)); ok {
+		t.Error("rate alarm fired without a previous sample")
+	}
+	if event, ok := rate.sample(100, start.Add(60*time.Second)); !ok || event.Value != 1.5 {
+		t.Errorf("rate event = %+v, %v", event, ok)
+	}
+}
+
+func TestWatchAlarms(t *testing.T) {
+	fake := newFakeQMP(t)
+	fake.handle("query-blockstats", func(map[string]any) (any, *qmpError) {
+		return []any{
+			map[string]any{"device": "disk0", "stats": map[string]any{"failed_rd_operations": 1, "failed_wr_operations": 2}},
+			map[string]any{"device": "disk1", "stats": map[string]any{"failed_flush_operations": 1}},
+		}, nil
+	})
+	fake.handle("query-balloon", func(map[string]any) (any, *qmpError) {
+		return map[string]any{"actual": 1 << 30}, nil
+	})
+	inst := fake.attach()
+
+	if v, err := BalloonSize()(inst); err != nil || v != 1<<30 {
+		t.Errorf("BalloonSize = %v, %v", v, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	alarm := &Alarm{Name: "io-errors", Metric: BlockErrors()}
+	events := make(chan AlarmEvent, 1)
+	err := inst.WatchAlarms(ctx, 10*time.Millisecond, []*Alarm{alarm}, func(e AlarmEvent) {
+		events <- e
+		cancel()
+	})
+	if err != context.Canceled {
+		t.Errorf("WatchAlarms = %v", err)
+	}
+	select {
+	case e := <-events:
+		if e.Alarm != alarm || !e.Firing || e.Value != 4 {
+			t.Errorf("event = %+v", e)
+		}
+	default:
+		t.Error("alarm did not fire")
+	}
+}
+
+func TestThreadRunDelay(t *testing.T) {
+	if _, err := os.Stat("/proc/self/schedstat"); err != nil {
+		t.Skip("schedstat not available")
+	}
+	if _, err := threadRunDelay(os.Getpid(), os.Getpid()); err != nil {
+		t.Errorf("threadRunDelay failed: %v", err)
+	}
+}
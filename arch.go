@@ -0,0 +1,158 @@
+package qemuctl
+
+import (
+	"runtime"
+	"strings"
+)
+
+// archProfile holds the machine and device defaults of an architecture.
+// The builder takes its architecture-specific choices from here, so that
+// supporting a new architecture means adding an entry to archProfiles.
+type archProfile struct {
+	// machine is the default machine type. Empty lets QEMU pick.
+	machine string
+
+	// pcieMachines are prefixes of the machine types whose root bus is
+	// PCIe (pcie.0). Other machines have a conventional PCI root bus.
+	pcieMachines []string
+
+	// ahci is the SATA controller that CD-ROMs attach to.
+	ahci string
+
+	// virtioTransport is the transport of virtio devices, VirtioPCI,
+	// VirtioMMIO or VirtioCCW.
+	virtioTransport string
+
+	// serial is the default serial port device.
+	serial string
+
+	// tpm is the default TPM device model.
+	tpm string
+}
+
+// archProfiles maps GOARCH values to their profiles.
+var archProfiles = map[string]*archProfile{
+	"amd64":   x86Profile,
+	"386":     x86Profile,
+	"arm64":   armProfile,
+	"arm":     armProfile,
+	"ppc64":   ppcProfile,
+	"ppc64le": ppcProfile,
+	"riscv64": riscvProfile,
+	"s390x":   s390Profile,
+}
+
+var (
+	x86Profile = &archProfile{
+		machine:         "q35",
+		pcieMachines:    []string{"q35", "pc-q35-"},
+		ahci:            "ich9-ahci",
+		virtioTransport: VirtioPCI,
+		serial:          "isa-serial",
+		tpm:             "tpm-tis",
+	}
+	armProfile = &archProfile{
+		machine:         "virt",
+		pcieMachines:    []string{"virt"},
+		ahci:            "ahci",
+		virtioTransport: VirtioPCI,
+		serial:          "isa-serial",
+		tpm:             "tpm-tis-device",
+	}
+	riscvProfile = &archProfile{
+		machine:         "virt",
+		pcieMachines:    []string{"virt"},
+		ahci:            "ahci",
+		virtioTransport: VirtioPCI,
+		serial:          "isa-serial",
+		tpm:             "tpm-tis-device",
+	}
+	ppcProfile = &archProfile{
+		machine:         "pseries",
+		ahci:            "ahci",
+		virtioTransport: VirtioPCI,
+		serial:          "isa-serial",
+		tpm:             "tpm-spapr",
+	}
+	s390Profile = &archProfile{
+		machine:         "s390-ccw-virtio",
+		virtioTransport: VirtioCCW,
+		serial:          "sclpconsole",
+	}
+
+	// genericProfile is used for architectures without a profile.
+	genericProfile = &archProfile{
+		ahci:            "ahci",
+		virtioTransport: VirtioPCI,
+		serial:          "isa-serial",
+		tpm:             "tpm-tis",
+	}
+)
+
+// profileFor returns the profile of a GOARCH value, the host architecture
+// when empty.
+func profileFor(arch string) *archProfile {
+	if arch == "" {
+		arch = runtime.GOARCH
+	}
+	if p, ok := archProfiles[arch]; ok {
+		return p
+	}
+	return genericProfile
+}
+
+// machineType returns the machine type a configuration runs, empty when
+// QEMU picks its own default.
+func (p *archProfile) machineType(machine *MachineConfig) string {
+	if machine == nil {
+		return p.machine
+	}
+	return machine.Type
+}
+
+// isPCIe reports whether a machine type has a PCIe root bus.
+func (p *archProfile) isPCIe(machineType string) bool {
+	for _, prefix := range p.pcieMachines {
+		if strings.HasPrefix(machineType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// withTransport returns the profile with the virtio transport chosen by
+// the machine configuration.
+func (p *archProfile) withTransport(machine *MachineConfig) *archProfile {
+	if machine == nil || machine.VirtioTransport == "" || machine.VirtioTransport == p.virtioTransport {
+		return p
+	}
+	cp := *p
+	cp.virtioTransport = machine.VirtioTransport
+	return &cp
+}
+
+// virtio returns the device type of a virtio device on the profile's
+// transport, such as "virtio-blk-pci" for "virtio-blk".
+func (p *archProfile) virtio(device string) string {
+	if p.virtioTransport == VirtioMMIO {
+		return device + "-device"
+	}
+	return device + "-" + p.virtioTransport
+}
+
+// virtioOnPCI reports whether virtio devices are PCI devices, which need
+// a PCI address.
+func (p *archProfile) virtioOnPCI() bool {
+	return p.virtioTransport == VirtioPCI
+}
+
+// virtioOnCCW reports whether virtio devices are channel devices, which
+// need a CCW device number.
+func (p *archProfile) virtioOnCCW() bool {
+	return p.virtioTransport == VirtioCCW
+}
+
+// isQ35 reports whether a machine type is an x86 q35 machine.
+func isQ35(machineType string) bool {
+	return x86Profile.isPCIe(machineType)
+}
@@ -0,0 +1,122 @@
+package qemuctl
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVMBuilderArchProfiles(t *testing.T) {
+	for _, tt := range []struct {
+		arch string
+		want []string
+	}{
+		{"amd64", []string{"-machine q35,accel=kvm", "ich9-ahci,id=sata0,bus=pcie.0,addr=0x3", "virtio-blk-pci", "tpm-tis,tpmdev=tpm0"}},
+		{"arm64", []string{"-machine virt,accel=kvm", "ahci,id=sata0,bus=pcie.0,addr=0x3", "virtio-blk-pci", "tpm-tis-device,tpmdev=tpm0"}},
+		{"riscv64", []string{"-machine virt,accel=kvm", "ahci,id=sata0,bus=pcie.0,addr=0x3", "virtio-blk-pci", "tpm-tis-device,tpmdev=tpm0"}},
+		{"ppc64le", []string{"-machine pseries,accel=kvm", "ahci,id=sata0,bus=pci.0,addr=0x3", "tpm-spapr,tpmdev=tpm0"}},
+		{"mips64", []string{"ahci,id=sata0,bus=pci.0", "tpm-tis,tpmdev=tpm0"}},
+	} {
+		cfg := &VMConfig{
+			Arch:   tt.arch,
+			Disks:  []*DiskConfig{{ID: "disk0", Backend: &FileDiskBackend{Path: "/disk.qcow2", Format: "qcow2"}}},
+			CDROMs: []*CDROMConfig{{Path: "/install.iso"}},
+			TPM:    &TPMConfig{StateDir: "/var/lib/tpm"},
+		}
+		argsStr := strings.Join(NewVMBuilder(cfg).Build("test-vm", "/tmp/test.sock"), " ")
+		for _, want := range tt.want {
+			if !strings.Contains(argsStr, want) {
+				t.Errorf("%s: expected %q, got: %s", tt.arch, want, argsStr)
+			}
+		}
+	}
+
+	// An explicit machine decides the root bus
+	cfg := &VMConfig{Arch: "amd64", Machine: &MachineConfig{Type: "pc"}, CDROMs: []*CDROMConfig{{Path: "/install.iso"}}}
+	if argsStr := strings.Join(NewVMBuilder(cfg).Build("test-vm", "/tmp/test.sock"), " "); !strings.Contains(argsStr, "bus=pci.0") {
+		t.Errorf("expected pci.0 bus for pc machine, got: %s", argsStr)
+	}
+}
+
+func TestVMBuilderVirtioMMIO(t *testing.T) {
+	cfg := &VMConfig{
+		Arch:    "riscv64",
+		Machine: &MachineConfig{Type: "virt", Accel: "tcg", VirtioTransport: VirtioMMIO},
+		Boot:    &BootConfig{Kernel: "/boot/Image", Append: "root=/dev/vda console=ttyS0", Firmware: "default"},
+		Disks:   []*DiskConfig{{ID: "disk0", Backend: &FileDiskBackend{Path: "/rootfs.raw", Format: "raw"}}},
+		Networks: []*NetworkConfig{
+			{ID: "net0", Backend: &UserNetBackend{}},
+		},
+		Balloon: &BalloonConfig{Enabled: true},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	argsStr := strings.Join(NewVMBuilder(cfg).Build("test-vm", "/tmp/test.sock"), " ")
+	for _, want := range []string{
+		"-bios default",
+		"-kernel /boot/Image",
+		"virtio-blk-device,drive=disk0-format,id=disk0-device ",
+		"virtio-net-device,netdev=net0,id=net0-device ",
+		"virtio-balloon-device,id=balloon0 ",
+		"virtio-rng-device,rng=rng0,id=rng-dev0",
+	} {
+		if !strings.Contains(argsStr+" ", want) {
+			t.Errorf("expected %q, got: %s", want, argsStr)
+		}
+	}
+	if strings.Contains(argsStr, "bus=pcie.0") {
+		t.Errorf("virtio-mmio devices got PCI addresses: %s", argsStr)
+	}
+
+	cfg.Machine.VirtioTransport = "vmbus"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate accepted an unknown transport")
+	}
+}
+
+func TestVMBuilderS390x(t *testing.T) {
+	cfg := &VMConfig{
+		Arch:  "s390x",
+		Disks: []*DiskConfig{{ID: "disk0", Backend: &FileDiskBackend{Path: "/disk.qcow2", Format: "qcow2"}}},
+		Networks: []*NetworkConfig{
+			{ID: "net0", Backend: &UserNetBackend{}},
+		},
+		Serials: []*SerialConfig{{Type: "pty"}},
+		Balloon: &BalloonConfig{Enabled: true},
+	}
+	cfg.WithGuestAgent("/run/qga.sock")
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	argsStr := strings.Join(NewVMBuilder(cfg).Build("test-vm", "/tmp/test.sock"), " ")
+	for _, want := range []string{
+		"-machine s390-ccw-virtio,accel=kvm",
+		"virtio-blk-ccw,drive=disk0-format,id=disk0-device,devno=fe.0.0000",
+		"virtio-net-ccw,netdev=net0,id=net0-device,devno=fe.0.0001",
+		"virtio-serial-ccw,id=virtio-serial0,devno=fe.0.0002",
+		"sclpconsole,chardev=serial0,id=serial0-device",
+		"virtio-balloon-ccw,id=balloon0,devno=fe.0.0003",
+		"virtio-rng-ccw,rng=rng0,id=rng-dev0,devno=fe.0.0004",
+	} {
+		if !strings.Contains(argsStr, want) {
+			t.Errorf("expected %q, got: %s", want, argsStr)
+		}
+	}
+	if strings.Contains(argsStr, "addr=") {
+		t.Errorf("virtio-ccw devices got PCI addresses: %s", argsStr)
+	}
+
+	for _, bad := range []*VMConfig{
+		{Arch: "s390x", CDROMs: []*CDROMConfig{{Path: "/install.iso"}}},
+		{Arch: "s390x", Disks: []*DiskConfig{{ID: "disk0", Interface: "nvme"}}},
+		{Arch: "s390x", Networks: []*NetworkConfig{{ID: "net0", Model: "e1000"}}},
+		{Arch: "s390x", TPM: &TPMConfig{StateDir: "/var/lib/tpm"}},
+		{Arch: "amd64", Machine: &MachineConfig{Type: "q35", VirtioTransport: VirtioCCW}},
+	} {
+		if err := bad.Validate(); err == nil {
+			t.Errorf("Validate accepted %+v", bad)
+		}
+	}
+}
@@ -0,0 +1,1121 @@
+package qemuctl
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// qemuOpts is a parsed QEMU "key=value,..." option string.
+type qemuOpts struct {
+	// First is the leading value without a key (e.g., "q35" in "q35,accel=kvm").
+	First string
+
+	// Values holds key/value pairs.
+	Values map[string]string
+
+	// Flags holds bare entries after the first one (e.g., "+aes" for -cpu).
+	Flags []string
+
+	// keys records key/value pairs in order, including repeated keys.
+	keys [][2]string
+}
+
+// get returns the value for key, or "" if unset.
+func (o *qemuOpts) get(key string) string {
+	return o.Values[key]
+}
+
+// bool returns true if the key is set to an "on" value.
+func (o *qemuOpts) bool(key string) bool {
+	switch o.Values[key] {
+	case "on", "yes", "true", "y":
+		return true
+	}
+	return false
+}
+
+// all returns every value given for key, in order.
+func (o *qemuOpts) all(key string) []string {
+	var values []string
+	for _, kv := range o.keys {
+		if kv[0] == key {
+			values = append(values, kv[1])
+		}
+	}
+	return values
+}
+
+// int returns the integer value for key, or 0.
+func (o *qemuOpts) int(key string) int {
+	n, _ := strconv.Atoi(o.Values[key])
+	return n
+}
+
+// reconnect returns the reconnect delay in seconds, accepting both the
+// reconnect and reconnect-ms (QEMU 9.2+) spellings.
+func (o *qemuOpts) reconnect() int {
+	if ms := o.int("reconnect-ms"); ms > 0 {
+		return (ms + 999) / 1000
+	}
+	return o.int("reconnect")
+}
+
+// splitOpts splits a QEMU option string on commas, honouring ",," escapes.
+func splitOpts(s string) []string {
+	var parts []string
+	var cur strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == ',' {
+			if i+1 < len(s) && s[i+1] == ',' {
+				cur.WriteByte(',')
+				i++
+				continue
+			}
+			parts = append(parts, cur.String())
+			cur.Reset()
+			continue
+		}
+		cur.WriteByte(s[i])
+	}
+	return append(parts, cur.String())
+}
+
+// parseOpts parses a QEMU option string.
+func parseOpts(s string) *qemuOpts {
+	o := &qemuOpts{Values: make(map[string]string)}
+	for idx, part := range splitOpts(s) {
+		key, value, ok := strings.Cut(part, "=")
+		switch {
+		case ok:
+			o.Values[key] = value
+			o.keys = append(o.keys, [2]string{key, value})
+		case idx == 0:
+			o.First = part
+		case part != "":
+			o.Flags = append(o.Flags, part)
+		}
+	}
+	return o
+}
+
+// parseBlockdev parses a -blockdev argument in JSON or keyval syntax.
+func parseBlockdev(s string) (map[string]any, error) {
+	if strings.HasPrefix(s, "{") {
+		var m map[string]any
+		if err := json.Unmarshal([]byte(s), &m); err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+
+	// Keyval syntax uses dotted keys for nested objects
+	m := make(map[string]any)
+	for key, value := range parseOpts(s).Values {
+		cur := m
+		path := strings.Split(key, ".")
+		for _, p := range path[:len(path)-1] {
+			next, ok := cur[p].(map[string]any)
+			if !ok {
+				next = make(map[string]any)
+				cur[p] = next
+			}
+			cur = next
+		}
+		cur[path[len(path)-1]] = value
+	}
+	return m, nil
+}
+
+// parseMemorySize parses a QEMU size ("2048", "2G", "512M") into megabytes.
+// A bare number is interpreted as megabytes, like QEMU's -m option.
+func parseMemorySize(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, fmt.Errorf("empty size")
+	}
+
+	mult := 1.0
+	switch suffix := strings.ToUpper(s[len(s)-1:]); suffix {
+	case "K":
+		mult = 1.0 / 1024
+	case "M":
+		mult = 1
+	case "G":
+		mult = 1024
+	case "T":
+		mult = 1024 * 1024
+	}
+	if mult != 1 || strings.ToUpper(s[len(s)-1:]) == "M" {
+		s = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int(n * mult), nil
+}
+
+// argStr returns a string value from a blockdev options map.
+func argStr(m map[string]any, key string) string {
+	switch v := m[key].(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return ""
+}
+
+// argBool returns a boolean value from a blockdev options map.
+func argBool(m map[string]any, key string) bool {
+	switch v := m[key].(type) {
+	case bool:
+		return v
+	case string:
+		return v == "on" || v == "true"
+	}
+	return false
+}
+
+// qemuFlagOptions lists QEMU options that take no value.
+var qemuFlagOptions = map[string]bool{
+	"-S":           true,
+	"-daemonize":   true,
+	"-no-reboot":   true,
+	"-no-shutdown": true,
+	"-nographic":   true,
+	"-snapshot":    true,
+	"-full-screen": true,
+	"-no-acpi":     true,
+	"-no-hpet":     true,
+	"-enable-fips": true,
+	"-preconfig":   true,
+	"-win2k-hack":  true,
+	"-old-param":   true,
+	"-semihosting": true,
+}
+
+// parsedDevice is a -device argument awaiting assignment.
+type parsedDevice struct {
+	driver string
+	opts   *qemuOpts
+}
+
+// ParseArgs reconstructs a VMConfig from a QEMU command line, such as the
+// one of a running process. args may start with the QEMU binary path.
+// Options without a VMConfig equivalent are preserved in ExtraArgs.
+func ParseArgs(args []string) (*VMConfig, error) {
+	cfg := &VMConfig{}
+
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cfg.QemuPath = args[0]
+		base := filepath.Base(args[0])
+		if qemuArch, ok := strings.CutPrefix(base, "qemu-system-"); ok {
+			cfg.Arch = goarchFromQemu(qemuArch)
+		}
+		args = args[1:]
+	}
+
+	monitor := findSocketFromArgs(args)
+	fdsets := make(map[string]string)
+	blockdevs := make(map[string]map[string]any)
+	drives := make(map[string]*qemuOpts)
+	netdevs := make(map[string]*qemuOpts)
+	captures := make(map[string]string)
+	chardevs := make(map[string]*ChardevConfig)
+	var devices []parsedDevice
+	var chardevOrder []string
+	var panicAction string
+
+	for i := 0; i < len(args); i++ {
+		opt := args[i]
+		if !strings.HasPrefix(opt, "-") {
+			cfg.ExtraArgs = append(cfg.ExtraArgs, opt)
+			continue
+		}
+
+		// Options without a value
+		switch opt {
+		case "-nodefaults":
+			cfg.NoDefaults = true
+			continue
+		case "-no-user-config":
+			continue
+		case "-only-migratable":
+			cfg.OnlyMigratable = true
+			continue
+		case "-enable-kvm":
+			if cfg.Machine == nil {
+				cfg.Machine = &MachineConfig{}
+			}
+			cfg.Machine.Accel = "kvm"
+			continue
+		}
+
+		if i+1 >= len(args) || qemuFlagOptions[opt] {
+			cfg.ExtraArgs = append(cfg.ExtraArgs, opt)
+			continue
+		}
+		value := args[i+1]
+		i++
+
+		switch opt {
+		case "-name":
+			o := parseOpts(value)
+			if g := o.get("guest"); g != "" {
+				cfg.Name = g
+			} else {
+				cfg.Name = o.First
+			}
+
+		case "-uuid":
+			cfg.UUID = value
+
+		case "-machine", "-M":
+			o := parseOpts(value)
+			if cfg.Machine == nil {
+				cfg.Machine = &MachineConfig{}
+			}
+			cfg.Machine.Type = o.First
+			if t := o.get("type"); t != "" {
+				cfg.Machine.Type = t
+			}
+			if a := o.get("accel"); a != "" {
+				cfg.Machine.Accel = a
+			}
+			if _, ok := o.Values["usb"]; ok {
+				usb := o.bool("usb")
+				cfg.Machine.USB = &usb
+			}
+			if _, ok := o.Values["dump-guest-core"]; ok {
+				dump := o.bool("dump-guest-core")
+				cfg.Machine.DumpGuestCore = &dump
+			}
+			cfg.Machine.Pflash0 = o.get("pflash0")
+			cfg.Machine.Pflash1 = o.get("pflash1")
+
+		case "-accel":
+			o := parseOpts(value)
+			if cfg.Machine == nil {
+				cfg.Machine = &MachineConfig{}
+			}
+			cfg.Machine.Accel = o.First
+			if o.bool("split-wx") {
+				if cfg.Hardening == nil {
+					cfg.Hardening = &HardeningConfig{}
+				}
+				cfg.Hardening.SplitWX = true
+			}
+
+		case "-compat":
+			o := parseOpts(value)
+			if cfg.Hardening == nil {
+				cfg.Hardening = &HardeningConfig{}
+			}
+			cfg.Hardening.DeprecatedInput = o.get("deprecated-input")
+			cfg.Hardening.DeprecatedOutput = o.get("deprecated-output")
+			cfg.Hardening.UnstableInput = o.get("unstable-input")
+			cfg.Hardening.UnstableOutput = o.get("unstable-output")
+
+		case "-icount":
+			if rr := replayFromOpts(parseOpts(value)); rr != nil {
+				cfg.Replay = rr
+			} else {
+				cfg.ExtraArgs = append(cfg.ExtraArgs, opt, value)
+			}
+
+		case "-D":
+			debugConfig(cfg).LogFile = value
+
+		case "-d":
+			debugConfig(cfg).Log = strings.Split(value, ",")
+
+		case "-trace", "--trace":
+			o := parseOpts(value)
+			switch {
+			case o.get("file") != "":
+				// The simple trace backend is not configured by DebugConfig
+				cfg.ExtraArgs = append(cfg.ExtraArgs, opt, value)
+			case o.get("events") != "":
+				debugConfig(cfg).TraceEvents = o.get("events")
+			case o.get("enable") != "":
+				debugConfig(cfg).Trace = append(debugConfig(cfg).Trace, o.get("enable"))
+			default:
+				debugConfig(cfg).Trace = append(debugConfig(cfg).Trace, o.First)
+			}
+
+		case "-cpu":
+			o := parseOpts(value)
+			if cfg.CPU == nil {
+				cfg.CPU = &CPUConfig{Sockets: 1, Cores: 1, Threads: 1}
+			}
+			cfg.CPU.Model = o.First
+			cfg.CPU.Features = append(cfg.CPU.Features, o.Flags...)
+			for _, part := range splitOpts(value)[1:] {
+				if strings.Contains(part, "=") {
+					cfg.CPU.Features = append(cfg.CPU.Features, part)
+				}
+			}
+
+		case "-m":
+			o := parseOpts(value)
+			size := o.First
+			if s := o.get("size"); s != "" {
+				size = s
+			}
+			mb, err := parseMemorySize(size)
+			if err != nil {
+				return nil, fmt.Errorf("-m: %w", err)
+			}
+			if cfg.Memory == nil {
+				cfg.Memory = &MemoryConfig{}
+			}
+			cfg.Memory.Size = mb
+
+		case "-smp":
+			o := parseOpts(value)
+			if cfg.CPU == nil {
+				cfg.CPU = &CPUConfig{Sockets: 1, Cores: 1, Threads: 1}
+			}
+			total, _ := strconv.Atoi(o.First)
+			if c := o.int("cpus"); c > 0 {
+				total = c
+			}
+			sockets, cores, threads := o.int("sockets"), o.int("cores"), o.int("threads")
+			if sockets > 0 || cores > 0 || threads > 0 {
+				cfg.CPU.Sockets, cfg.CPU.Cores, cfg.CPU.Threads = max(sockets, 1), max(cores, 1), max(threads, 1)
+			} else if total > 0 {
+				cfg.CPU.Sockets, cfg.CPU.Cores, cfg.CPU.Threads = 1, total, 1
+			}
+
+		case "-overcommit":
+			if parseOpts(value).get("mem-lock") == "on" {
+				if cfg.Memory == nil {
+					cfg.Memory = &MemoryConfig{}
+				}
+				cfg.Memory.MemLock = "on"
+			}
+
+		case "-action":
+			// The panic action belongs to VMConfig.Panic if there is a
+			// pvpanic device; other actions are kept as is
+			var rest []string
+			for _, kv := range parseOpts(value).keys {
+				if kv[0] == "panic" {
+					panicAction = kv[1]
+				} else {
+					rest = append(rest, kv[0]+"="+kv[1])
+				}
+			}
+			if len(rest) > 0 {
+				cfg.ExtraArgs = append(cfg.ExtraArgs, opt, strings.Join(rest, ","))
+			}
+
+		case "-rtc":
+			o := parseOpts(value)
+			cfg.RTC = &RTCConfig{Base: o.get("base"), Clock: o.get("clock"), DriftFix: o.get("driftfix")}
+
+		case "-boot":
+			o := parseOpts(value)
+			if cfg.Boot == nil {
+				cfg.Boot = &BootConfig{}
+			}
+			cfg.Boot.Order = o.get("order")
+			if cfg.Boot.Order == "" && !strings.Contains(o.First, "=") {
+				cfg.Boot.Order = o.First
+			}
+			cfg.Boot.Once = o.get("once")
+			if _, ok := o.Values["menu"]; ok {
+				menu := o.bool("menu")
+				cfg.Boot.Menu = &menu
+			}
+			cfg.Boot.Strict = o.bool("strict")
+
+		case "-kernel", "-initrd", "-append", "-bios":
+			if cfg.Boot == nil {
+				cfg.Boot = &BootConfig{}
+			}
+			switch opt {
+			case "-kernel":
+				cfg.Boot.Kernel = value
+			case "-initrd":
+				cfg.Boot.Initrd = value
+			case "-append":
+				cfg.Boot.Append = value
+			case "-bios":
+				cfg.Boot.Firmware = value
+			}
+
+		case "-run-with":
+			if c := parseOpts(value).get("chroot"); c != "" {
+				cfg.Chroot = c
+			} else {
+				cfg.ExtraArgs = append(cfg.ExtraArgs, opt, value)
+			}
+
+		case "-chroot":
+			cfg.Chroot = value
+
+		case "-incoming":
+			cfg.Incoming = value
+
+		case "-pidfile":
+			if monitor != "" && value == pidFilePath(monitor) {
+				cfg.Daemonize = true
+			} else {
+				cfg.ExtraArgs = append(cfg.ExtraArgs, opt, value)
+			}
+
+		case "-sandbox":
+			cfg.Sandbox = sandboxFromOpts(parseOpts(value))
+
+		case "-add-fd":
+			o := parseOpts(value)
+			if _, path, ok := strings.Cut(o.get("opaque"), ":"); ok {
+				fdsets["/dev/fdset/"+o.get("set")] = path
+			}
+
+		case "-object":
+			o := parseOpts(value)
+			switch o.First {
+			case "memory-backend-file", "memory-backend-memfd":
+				if cfg.Memory == nil {
+					cfg.Memory = &MemoryConfig{}
+				}
+				backend := &MemoryBackendConfig{
+					Type:     strings.TrimPrefix(o.First, "memory-backend-"),
+					Path:     o.get("mem-path"),
+					Share:    o.bool("share"),
+					Prealloc: o.bool("prealloc"),
+				}
+				if o.First == "memory-backend-memfd" && o.bool("hugetlb") {
+					backend.HugePages = true
+					backend.HugePageSize, _ = parseByteSize(o.get("hugetlbsize"))
+				}
+				cfg.Memory.Backend = backend
+				if cfg.Memory.Size == 0 {
+					cfg.Memory.Size, _ = parseMemorySize(o.get("size"))
+				}
+			case "secret":
+				cfg.Secrets = append(cfg.Secrets, &SecretConfig{
+					ID:     o.get("id"),
+					Data:   o.get("data"),
+					File:   o.get("file"),
+					Format: o.get("format"),
+				})
+			case "iothread":
+				cfg.IOThreads = append(cfg.IOThreads, ioThreadFromOpts(o))
+			case "rng-random":
+				// Added automatically by the builder
+			case "throttle-group":
+				// Reattached to disks through the throttle blockdev
+				blockdevs["throttle-group:"+o.get("id")] = map[string]any{"opts": o}
+			case "filter-replay":
+				// Added by the builder for record/replay
+			case "filter-dump":
+				captures[o.get("netdev")] = o.get("file")
+			default:
+				cfg.ExtraArgs = append(cfg.ExtraArgs, opt, value)
+			}
+
+		case "-numa":
+			if parseOpts(value).get("memdev") == "" {
+				cfg.ExtraArgs = append(cfg.ExtraArgs, opt, value)
+			}
+
+		case "-blockdev":
+			m, err := parseBlockdev(value)
+			if err != nil {
+				return nil, fmt.Errorf("-blockdev: %w", err)
+			}
+			if name := argStr(m, "node-name"); name != "" {
+				blockdevs[name] = m
+			}
+
+		case "-drive":
+			o := parseOpts(value)
+			if path, ok := fdsets[o.get("file")]; ok {
+				o.Values["file"] = path
+			}
+			if o.get("media") == "cdrom" || o.get("if") == "none" {
+				drives[o.get("id")] = o
+				continue
+			}
+			iface := o.get("if")
+			if iface == "" {
+				iface = "ide"
+			}
+			cfg.Disks = append(cfg.Disks, &DiskConfig{
+				ID:         o.get("id"),
+				Backend:    &FileDiskBackend{Path: o.get("file"), Format: o.get("format")},
+				Interface:  iface,
+				Cache:      o.get("cache"),
+				Discard:    o.get("discard"),
+				ReadOnly:   o.bool("readonly"),
+				WriteError: o.get("werror"),
+				ReadError:  o.get("rerror"),
+			})
+
+		case "-netdev":
+			o := parseOpts(value)
+			netdevs[o.get("id")] = o
+
+		case "-chardev":
+			o := parseOpts(value)
+			if o.get("path") == monitor && monitor != "" {
+				continue
+			}
+			ch := &ChardevConfig{
+				ID:        o.get("id"),
+				Backend:   o.First,
+				Path:      o.get("path"),
+				Server:    o.bool("server"),
+				Wait:      o.get("wait") != "off",
+				Host:      o.get("host"),
+				Port:      o.int("port"),
+				Reconnect: o.reconnect(),
+				Name:      o.get("name"),
+				Telnet:    o.bool("telnet"),
+				WebSocket: o.bool("websocket"),
+				NoDelay:   o.bool("nodelay"),
+				LocalAddr: o.get("localaddr"),
+				LocalPort: o.int("localport"),
+				Append:    o.bool("append"),
+				Clipboard: o.bool("clipboard"),
+				LogFile:   o.get("logfile"),
+			}
+			chardevs[ch.ID] = ch
+			chardevOrder = append(chardevOrder, ch.ID)
+
+		case "-mon":
+			// The QMP monitor is recreated by the builder
+			if parseOpts(value).get("mode") != "control" {
+				cfg.ExtraArgs = append(cfg.ExtraArgs, opt, value)
+			}
+
+		case "-device":
+			o := parseOpts(value)
+			driver := o.First
+			if d := o.get("driver"); d != "" {
+				driver = d
+			}
+			devices = append(devices, parsedDevice{driver: driver, opts: o})
+
+		case "-vnc":
+			o := parseOpts(value)
+			if cfg.Display == nil {
+				cfg.Display = &DisplayConfig{}
+			}
+			cfg.Display.Type = "vnc"
+			cfg.Display.VNC = &VNCConfig{
+				Listen:         o.First,
+				PasswordSecret: o.get("password-secret"),
+				Lossy:          o.bool("lossy"),
+				AudioDev:       o.get("audiodev"),
+				Websocket:      o.int("websocket"),
+			}
+
+		case "-spice":
+			o := parseOpts(value)
+			if cfg.Display == nil {
+				cfg.Display = &DisplayConfig{}
+			}
+			cfg.Display.Type = "spice"
+			cfg.Display.Spice = &SpiceDisplayConfig{
+				Unix:                  o.bool("unix") || o.First == "unix=on",
+				Port:                  o.int("port"),
+				PasswordSecret:        o.get("password-secret"),
+				DisableTicketing:      o.bool("disable-ticketing"),
+				ImageCompression:      o.get("image-compression"),
+				JpegWanCompression:    o.get("jpeg-wan-compression"),
+				ZlibGlzWanCompression: o.get("zlib-glz-wan-compression"),
+				PlaybackCompression:   o.bool("playback-compression"),
+				SeamlessMigration:     o.bool("seamless-migration"),
+				DisableCopyPaste:      o.bool("disable-copy-paste"),
+			}
+
+		case "-display":
+			o := parseOpts(value)
+			if cfg.Display == nil {
+				cfg.Display = &DisplayConfig{Type: o.First}
+			}
+
+		case "-audiodev":
+			o := parseOpts(value)
+			if cfg.Audio == nil {
+				cfg.Audio = &AudioConfig{}
+			}
+			cfg.Audio.Backend = o.First
+
+		default:
+			cfg.ExtraArgs = append(cfg.ExtraArgs, opt, value)
+		}
+	}
+
+	// Resolve devices against the backends collected above
+	usedChardevs := make(map[string]bool)
+	for _, dev := range devices {
+		o := dev.opts
+		switch {
+		case o.get("netdev") != "":
+			nd, ok := netdevs[o.get("netdev")]
+			if !ok {
+				return nil, fmt.Errorf("device %s references unknown netdev %q", dev.driver, o.get("netdev"))
+			}
+			cfg.Networks = append(cfg.Networks, &NetworkConfig{
+				ID:          o.get("netdev"),
+				Backend:     netBackendFromOpts(nd),
+				Model:       dev.driver,
+				MACAddr:     o.get("mac"),
+				BootIndex:   o.int("bootindex"),
+				MTU:         o.int("host_mtu"),
+				Offloads:    nicOffloadsFromOpts(o),
+				RxQueueSize: o.int("rx_queue_size"),
+				TxQueueSize: o.int("tx_queue_size"),
+				CaptureFile: captures[o.get("netdev")],
+			})
+
+		case dev.driver == "ide-cd" || dev.driver == "scsi-cd":
+			d, ok := drives[o.get("drive")]
+			if !ok {
+				continue
+			}
+			cfg.CDROMs = append(cfg.CDROMs, &CDROMConfig{Path: d.get("file"), BootIndex: o.int("bootindex")})
+
+		case o.get("drive") != "":
+			disk, err := diskFromBlockdevs(strings.TrimSuffix(o.get("id"), "-device"), o.get("drive"), blockdevs, drives, fdsets)
+			if err != nil {
+				return nil, err
+			}
+			disk.Interface = diskInterfaceForDevice(dev.driver)
+			disk.BootIndex = o.int("bootindex")
+			disk.Serial = o.get("serial")
+			if werror := o.get("werror"); werror != "" {
+				disk.WriteError = werror
+			}
+			if rerror := o.get("rerror"); rerror != "" {
+				disk.ReadError = rerror
+			}
+			disk.IOThread = o.get("iothread")
+			if o.get("write-cache") == "off" {
+				// The page cache settings are on the nodes
+				switch disk.Cache {
+				case "", "writeback":
+					disk.Cache = "writethrough"
+				case "none":
+					disk.Cache = "directsync"
+				}
+			}
+			if disk.Interface == "scsi" {
+				disk.SCSI = scsiAddressFromOpts(o)
+			}
+			cfg.Disks = append(cfg.Disks, disk)
+
+		case dev.driver == "virtio-scsi-pci" || dev.driver == "virtio-scsi-device" || dev.driver == "virtio-scsi-ccw":
+			cfg.SCSIControllers = append(cfg.SCSIControllers, &SCSIControllerConfig{
+				ID:        o.get("id"),
+				NumQueues: o.int("num_queues"),
+				IOThread:  o.get("iothread"),
+			})
+
+		case dev.driver == "virtio-serial-pci" || dev.driver == "virtio-serial":
+			if cfg.VirtioSerial == nil {
+				cfg.VirtioSerial = &VirtioSerialConfig{}
+			}
+			cfg.VirtioSerial.MaxPorts = o.int("max_ports")
+
+		case dev.driver == "virtserialport" || dev.driver == "virtconsole":
+			if cfg.VirtioSerial == nil {
+				cfg.VirtioSerial = &VirtioSerialConfig{}
+			}
+			cfg.VirtioSerial.Ports = append(cfg.VirtioSerial.Ports, VirtioSerialPortConfig{
+				ID:      o.get("id"),
+				Chardev: o.get("chardev"),
+				Name:    o.get("name"),
+				Type:    dev.driver,
+			})
+
+		case dev.driver == "isa-serial" || dev.driver == "pci-serial" || dev.driver == "usb-serial" && o.get("bus") == "":
+			ch, ok := chardevs[o.get("chardev")]
+			if !ok {
+				continue
+			}
+			usedChardevs[ch.ID] = true
+			cfg.Serials = append(cfg.Serials, &SerialConfig{
+				Type:   ch.Backend,
+				Path:   ch.Path,
+				Server: ch.Server,
+				Wait:   ch.Wait,
+				Device: dev.driver,
+			})
+
+		case dev.driver == "qemu-xhci" || dev.driver == "nec-usb-xhci" || strings.HasPrefix(dev.driver, "ich9-usb-") || dev.driver == "piix3-usb-uhci":
+			cfg.USB = &USBControllerConfig{Type: dev.driver}
+
+		case dev.driver == "usb-redir" && isUSBRedirChardev(chardevs[o.get("chardev")]):
+			if cfg.USBRedir == nil {
+				cfg.USBRedir = &USBRedirConfig{}
+			}
+			cfg.USBRedir.Channels++
+			usedChardevs[o.get("chardev")] = true
+
+		case dev.driver == "usb-host":
+			cfg.USBHostDevices = append(cfg.USBHostDevices, usbHostDeviceFromOpts(o))
+
+		case strings.HasPrefix(dev.driver, "usb-"):
+			cfg.USBDevices = append(cfg.USBDevices, &USBDeviceConfig{Type: dev.driver, Chardev: o.get("chardev")})
+
+		case dev.driver == "vfio-pci" && mdevFromOpts(o) != nil:
+			cfg.Mdevs = append(cfg.Mdevs, mdevFromOpts(o))
+
+		case dev.driver == "virtio-balloon-pci" || dev.driver == "virtio-balloon":
+			cfg.Balloon = &BalloonConfig{Enabled: true}
+
+		case dev.driver == "pvpanic-pci":
+			cfg.Panic = &PanicConfig{Device: "pvpanic-pci"}
+
+		case dev.driver == "pvpanic":
+			cfg.Panic = &PanicConfig{Device: "pvpanic-isa"}
+
+		case dev.driver == "vhost-vsock-pci" || dev.driver == "vhost-vsock-device":
+			cid, err := strconv.ParseUint(o.get("guest-cid"), 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid vsock guest-cid %q", o.get("guest-cid"))
+			}
+			cfg.Vsock = &VsockConfig{CID: uint32(cid)}
+
+		case dev.driver == "qxl-vga" || dev.driver == "virtio-vga" || dev.driver == "vga" || dev.driver == "VGA" ||
+			dev.driver == "cirrus-vga" || dev.driver == "bochs-display":
+			if cfg.Display == nil {
+				cfg.Display = &DisplayConfig{Type: "none"}
+			}
+			cfg.Display.Video = &VideoConfig{
+				Type:       dev.driver,
+				VgaMem:     o.int("vgamem_mb"),
+				Ram:        o.int("ram_size"),
+				Vram:       o.int("vram_size"),
+				MaxOutputs: o.int("max_outputs"),
+			}
+
+		case dev.driver == "intel-hda" || dev.driver == "ich9-intel-hda" || dev.driver == "ac97":
+			if cfg.Audio == nil {
+				cfg.Audio = &AudioConfig{}
+			}
+			cfg.Audio.Device = dev.driver
+
+		case strings.HasPrefix(dev.driver, "hda-"):
+			if cfg.Audio == nil {
+				cfg.Audio = &AudioConfig{}
+			}
+			cfg.Audio.Codec = dev.driver
+
+		case dev.driver == "virtio-rng-pci" || dev.driver == "ich9-ahci" || dev.driver == "ahci":
+			// Added automatically by the builder
+
+		default:
+			cfg.ExtraArgs = append(cfg.ExtraArgs, "-device", strings.Join(splitOptsRaw(o, dev.driver), ","))
+		}
+	}
+
+	if cfg.Panic != nil {
+		cfg.Panic.Action = panicAction
+	} else if panicAction != "" {
+		cfg.ExtraArgs = append(cfg.ExtraArgs, "-action", "panic="+panicAction)
+	}
+
+	// Remaining chardevs, in command-line order
+	for _, id := range chardevOrder {
+		if !usedChardevs[id] {
+			cfg.Chardevs = append(cfg.Chardevs, chardevs[id])
+		}
+	}
+
+	// Firmware
+	if cfg.Machine != nil && cfg.Machine.Pflash0 != "" {
+		cfg.EFI = &EFIConfig{Code: blockdevFilename(cfg.Machine.Pflash0, blockdevs, fdsets)}
+		if cfg.Machine.Pflash1 != "" {
+			cfg.EFI.Vars = blockdevFilename(cfg.Machine.Pflash1, blockdevs, fdsets)
+		}
+	}
+
+	return cfg, nil
+}
+
+// splitOptsRaw re-encodes parsed device options for ExtraArgs.
+func splitOptsRaw(o *qemuOpts, driver string) []string {
+	parts := []string{driver}
+	for _, kv := range o.keys {
+		if kv[0] != "driver" {
+			parts = append(parts, kv[0]+"="+strings.ReplaceAll(kv[1], ",", ",,"))
+		}
+	}
+	return append(parts, o.Flags...)
+}
+
+// diskInterfaceForDevice maps a block device driver to a DiskConfig interface.
+func diskInterfaceForDevice(driver string) string {
+	switch driver {
+	case "virtio-blk-pci", "virtio-blk":
+		return "virtio"
+	case "scsi-hd", "scsi-block":
+		return "scsi"
+	case "ide-hd":
+		return "ide"
+	case "nvme":
+		return "nvme"
+	}
+	return driver
+}
+
+// blockdevFilename follows a blockdev chain down to its file name.
+func blockdevFilename(node string, blockdevs map[string]map[string]any, fdsets map[string]string) string {
+	for depth := 0; depth < 8; depth++ {
+		m, ok := blockdevs[node]
+		if !ok {
+			return ""
+		}
+		if name := argStr(m, "filename"); name != "" {
+			if path, ok := fdsets[name]; ok {
+				return path
+			}
+			return name
+		}
+		node = argStr(m, "file")
+	}
+	return ""
+}
+
+// diskFromBlockdevs rebuilds a DiskConfig from the blockdev graph rooted at node.
+func diskFromBlockdevs(id, node string, blockdevs map[string]map[string]any, drives map[string]*qemuOpts, fdsets map[string]string) (*DiskConfig, error) {
+	disk := &DiskConfig{ID: id}
+
+	// Legacy -drive if=none
+	if d, ok := drives[node]; ok {
+		if disk.ID == "" {
+			disk.ID = node
+		}
+		disk.Backend = &FileDiskBackend{Path: d.get("file"), Format: d.get("format")}
+		disk.Cache = d.get("cache")
+		disk.Discard = d.get("discard")
+		disk.ReadOnly = d.bool("readonly")
+		disk.WriteError = d.get("werror")
+		disk.ReadError = d.get("rerror")
+		return disk, nil
+	}
+
+	m, ok := blockdevs[node]
+	if !ok {
+		return nil, fmt.Errorf("device references unknown block node %q", node)
+	}
+
+	// Record/replay filter, added by the builder
+	if argStr(m, "driver") == "blkreplay" {
+		node = argStr(m, "image")
+		if m, ok = blockdevs[node]; !ok {
+			return nil, fmt.Errorf("blkreplay node references unknown block node %q", node)
+		}
+	}
+
+	// Throttle filter
+	if argStr(m, "driver") == "throttle" {
+		group := argStr(m, "throttle-group")
+		disk.Throttle = &ThrottleConfig{Group: group}
+		if g, ok := blockdevs["throttle-group:"+group]; ok {
+			o := g["opts"].(*qemuOpts)
+			disk.Throttle.BPS, _ = strconv.ParseUint(o.get("x-bps-total"), 10, 64)
+			disk.Throttle.BPSRead, _ = strconv.ParseUint(o.get("x-bps-read"), 10, 64)
+			disk.Throttle.BPSWrite, _ = strconv.ParseUint(o.get("x-bps-write"), 10, 64)
+			disk.Throttle.IOPS, _ = strconv.ParseUint(o.get("x-iops-total"), 10, 64)
+			disk.Throttle.IOPSRead, _ = strconv.ParseUint(o.get("x-iops-read"), 10, 64)
+			disk.Throttle.IOPSWrite, _ = strconv.ParseUint(o.get("x-iops-write"), 10, 64)
+			disk.Throttle.BPSMax, _ = strconv.ParseUint(o.get("x-bps-total-max"), 10, 64)
+			disk.Throttle.IOPSMax, _ = strconv.ParseUint(o.get("x-iops-total-max"), 10, 64)
+			disk.Throttle.BurstLength = o.int("x-bps-total-max-length")
+		}
+		node = argStr(m, "file")
+		if m, ok = blockdevs[node]; !ok {
+			return nil, fmt.Errorf("throttle node references unknown block node %q", node)
+		}
+	}
+
+	if disk.ID == "" {
+		disk.ID = strings.TrimSuffix(strings.TrimSuffix(node, "-format"), "-iscsi")
+	}
+
+	// Cache, discard and zero detection are the same on all nodes, or
+	// only set on the top one
+	if c, ok := m["cache"].(map[string]any); ok {
+		disk.Cache = diskCacheModeName(diskCacheMode{direct: argBool(c, "direct"), noFlush: argBool(c, "no-flush"), writeCache: true})
+	}
+	disk.Discard = argStr(m, "discard")
+	disk.DetectZeroes = argStr(m, "detect-zeroes")
+
+	// Format layer
+	format := ""
+	switch argStr(m, "driver") {
+	case "raw", "qcow2", "vmdk", "vdi", "vhdx", "vpc", "qed", "luks":
+		format = argStr(m, "driver")
+		disk.ReadOnly = argBool(m, "read-only")
+		proto, ok := blockdevs[argStr(m, "file")]
+		if !ok {
+			return nil, fmt.Errorf("block node %q references unknown file node", node)
+		}
+		m = proto
+	}
+
+	switch argStr(m, "driver") {
+	case "file", "host_device":
+		disk.AIO = argStr(m, "aio")
+		path := argStr(m, "filename")
+		if p, ok := fdsets[path]; ok {
+			path = p
+		}
+		disk.Backend = &FileDiskBackend{Path: path, Format: format, AutoReadOnly: argBool(m, "auto-read-only")}
+		disk.ReadOnly = disk.ReadOnly || argBool(m, "read-only")
+	case "nbd":
+		b := &NBDDiskBackend{Export: argStr(m, "export"), TLSCreds: argStr(m, "tls-creds")}
+		b.TLS = b.TLSCreds != ""
+		if server, ok := m["server"].(map[string]any); ok {
+			if argStr(server, "type") == "unix" {
+				b.SocketPath = argStr(server, "path")
+			} else {
+				b.Host = argStr(server, "host")
+				b.Port, _ = strconv.Atoi(argStr(server, "port"))
+			}
+		}
+		disk.Backend = b
+	case "rbd":
+		b := &RBDDiskBackend{
+			Pool:      argStr(m, "pool"),
+			Image:     argStr(m, "image"),
+			Snapshot:  argStr(m, "snapshot"),
+			Conf:      argStr(m, "conf"),
+			User:      argStr(m, "user"),
+			KeySecret: argStr(m, "key-secret"),
+		}
+		if auth, ok := m["auth-client-required"].([]any); ok {
+			for _, a := range auth {
+				if s, ok := a.(string); ok {
+					b.AuthClientRequired = append(b.AuthClientRequired, s)
+				}
+			}
+		}
+		disk.Backend = b
+	case "iscsi":
+		b := &ISCSIDiskBackend{
+			Portal:         argStr(m, "portal"),
+			Target:         argStr(m, "target"),
+			User:           argStr(m, "user"),
+			PasswordSecret: argStr(m, "password-secret"),
+			InitiatorName:  argStr(m, "initiator-name"),
+		}
+		b.Lun, _ = strconv.Atoi(argStr(m, "lun"))
+		disk.Backend = b
+	default:
+		return nil, fmt.Errorf("unsupported block driver %q", argStr(m, "driver"))
+	}
+
+	return disk, nil
+}
+
+// netBackendFromOpts converts parsed -netdev options to a NetworkBackend.
+func netBackendFromOpts(o *qemuOpts) NetworkBackend {
+	switch o.First {
+	case "user":
+		b := &UserNetBackend{
+			Net:       o.get("net"),
+			Host:      o.get("host"),
+			DNS:       o.get("dns"),
+			DHCPStart: o.get("dhcpstart"),
+			Restrict:  o.bool("restrict"),
+		}
+		b.Hostfwd = o.all("hostfwd")
+		return b
+	case "tap":
+		b := &TapNetBackend{
+			Ifname:     o.get("ifname"),
+			Bridge:     o.get("br"),
+			Script:     o.get("script"),
+			DownScript: o.get("downscript"),
+			VHost:      o.bool("vhost"),
+			Queues:     o.int("queues"),
+		}
+		return b
+	case "socket":
+		b := &SocketNetBackend{}
+		if l := o.get("listen"); l != "" {
+			b.Path, b.Server = l, true
+		} else {
+			b.Path = o.get("connect")
+		}
+		return b
+	case "stream":
+		b := &StreamNetBackend{
+			Server:    o.bool("server"),
+			Path:      o.get("addr.path"),
+			Host:      o.get("addr.host"),
+			Port:      o.int("addr.port"),
+			Reconnect: o.reconnect(),
+		}
+		return b
+	case "vde":
+		return &VDENetBackend{Sock: o.get("sock"), Port: o.int("port"), Group: o.get("group"), Mode: o.get("mode")}
+	case "bridge":
+		return &BridgeNetBackend{Bridge: o.get("br"), Helper: o.get("helper")}
+	case "vmnet-shared":
+		return &VmnetSharedNetBackend{
+			StartAddress: o.get("start-address"),
+			EndAddress:   o.get("end-address"),
+			SubnetMask:   o.get("subnet-mask"),
+			NAT66Prefix:  o.get("nat66-prefix"),
+			Isolated:     o.bool("isolated"),
+		}
+	case "vmnet-bridged":
+		return &VmnetBridgedNetBackend{Ifname: o.get("ifname"), Isolated: o.bool("isolated")}
+	}
+	return &rawNetBackend{opts: o}
+}
+
+// nicOffloadsFromOpts reads the offload toggles of a virtio-net device,
+// nil if none is set.
+func nicOffloadsFromOpts(o *qemuOpts) *NICOffloads {
+	toggle := func(key string) *bool {
+		if _, ok := o.Values[key]; !ok {
+			return nil
+		}
+		v := o.bool(key)
+		return &v
+	}
+	offloads := &NICOffloads{
+		Checksum: toggle("csum"),
+		GSO:      toggle("gso"),
+		TSO:      toggle("host_tso4"),
+	}
+	if offloads.Checksum == nil && offloads.GSO == nil && offloads.TSO == nil {
+		return nil
+	}
+	return offloads
+}
+
+// rawNetBackend preserves a -netdev whose type has no dedicated backend.
+type rawNetBackend struct {
+	opts *qemuOpts
+}
+
+func (r *rawNetBackend) Type() string { return r.opts.First }
+
+func (r *rawNetBackend) BuildNetdevArgs(id string) []string {
+	parts := []string{r.opts.First, "id=" + id}
+	for _, kv := range r.opts.keys {
+		if kv[0] != "id" {
+			parts = append(parts, kv[0]+"="+strings.ReplaceAll(kv[1], ",", ",,"))
+		}
+	}
+	return []string{"-netdev", strings.Join(parts, ",")}
+}
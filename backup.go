@@ -0,0 +1,144 @@
+package qemuctl
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+// BackupOptions configures Instance.Backup.
+type BackupOptions struct {
+	// Format is the backup image format ("qcow2", "raw", "luks"). Defaults
+	// to "qcow2".
+	Format string
+
+	// Compress writes compressed clusters. Only qcow2 backups can be
+	// compressed.
+	Compress bool
+
+	// KeySecret is the ID of the secret object holding the LUKS passphrase
+	// the backup is encrypted with. Required for the "luks" format;
+	// qcow2 backups are LUKS-encrypted if set.
+	KeySecret string
+
+	// Sync is the backup mode ("full", "top", "incremental", "bitmap").
+	// Defaults to "full", or "incremental" if Bitmap is set.
+	Sync string
+
+	// Bitmap is the dirty bitmap of the source node selecting what to copy.
+	Bitmap string
+
+	// BitmapMode is what happens to Bitmap once the backup ends
+	// ("on-success", "never", "always").
+	BitmapMode string
+
+	// Speed limits the copy in bytes per second.
+	Speed int64
+
+	// ScratchDir holds the local backup image of BackupToTarget until it
+	// is uploaded, so it needs free space for the whole image: up to the
+	// virtual size of the node for a full backup, less when compressed.
+	// It must be writable by the QEMU process. Defaults to os.TempDir().
+	ScratchDir string
+}
+
+// Backup copies block node node of the running VM to a new image on
+// backend, created with the source's virtual size. The image is created by
+// QEMU itself (see CreateImage), so it can be compressed or encrypted as
+// it is written, with no post-processing of the backup. The job is
+// cancelled if ctx is done first.
+func (i *Instance) Backup(ctx context.Context, node string, backend DiskBackend, opts *BackupOptions) error {
+	if opts == nil {
+		opts = &BackupOptions{}
+	}
+	format := opts.Format
+	if format == "" {
+		format = "qcow2"
+	}
+	if opts.Compress && format != "qcow2" {
+		return fmt.Errorf("%s backups cannot be compressed", format)
+	}
+
+	size, err := i.nodeSize(node)
+	if err != nil {
+		return err
+	}
+
+	target := "backup" + strconv.FormatUint(jobCounter.Add(1), 10)
+	if err := i.CreateImage(ctx, target, backend, &LiveImageOptions{
+		Format:    format,
+		Size:      size,
+		KeySecret: opts.KeySecret,
+	}); err != nil {
+		return fmt.Errorf("failed to create backup image: %w", err)
+	}
+	defer i.removeImage(target, backend)
+
+	args := map[string]any{
+		"device": node,
+		"target": target,
+		"sync":   opts.sync(),
+	}
+	if opts.Compress {
+		args["compress"] = true
+	}
+	if opts.Bitmap != "" {
+		args["bitmap"] = opts.Bitmap
+	}
+	if opts.BitmapMode != "" {
+		args["bitmap-mode"] = opts.BitmapMode
+	}
+	if opts.Speed > 0 {
+		args["speed"] = opts.Speed
+	}
+
+	job, err := i.StartJob("blockdev-backup", args)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-job.Done():
+	case <-ctx.Done():
+		// The target can only be removed once the job is gone
+		if job.Cancel() == nil {
+			<-job.Done()
+		}
+		return ctx.Err()
+	}
+	return job.Err()
+}
+
+// sync returns the backup mode.
+func (o *BackupOptions) sync() string {
+	switch {
+	case o.Sync != "":
+		return o.Sync
+	case o.Bitmap != "":
+		return "incremental"
+	}
+	return "full"
+}
+
+// nodeSize returns the virtual size of a block node.
+func (i *Instance) nodeSize(node string) (int64, error) {
+	nodes, err := i.QueryNamedBlockNodes(true)
+	if err != nil {
+		return 0, err
+	}
+	for _, n := range nodes {
+		if n.NodeName == node && n.Image != nil {
+			return n.Image.VirtualSize, nil
+		}
+	}
+	return 0, fmt.Errorf("block node %q not found", node)
+}
+
+// removeImage detaches the format and protocol nodes added by CreateImage.
+func (i *Instance) removeImage(nodeName string, backend DiskBackend) {
+	i.BlockdevDel(nodeName)
+	if proto, err := protocolBlockdev(backend, nodeName); err == nil {
+		if protoNode, ok := proto["node-name"].(string); ok && protoNode != nodeName {
+			i.BlockdevDel(protoNode)
+		}
+	}
+}
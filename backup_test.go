@@ -0,0 +1,86 @@
+package qemuctl
+
+import (
+	"context"
+	"testing"
+)
+
+// handleBackupSource makes fake report a qcow2 node disk0-format of size.
+func handleBackupSource(fake *fakeQMP, size int64) {
+	fake.handle("query-named-block-nodes", func(map[string]any) (any, *qmpError) {
+		return []any{map[string]any{
+			"node-name": "disk0-format",
+			"drv":       "qcow2",
+			"image":     map[string]any{"filename": "disk0.qcow2", "format": "qcow2", "virtual-size": size},
+		}}, nil
+	})
+}
+
+func TestBackupCompressedEncrypted(t *testing.T) {
+	fake := newFakeQMP(t)
+	handleBlockJobs(fake)
+	handleBackupSource(fake, 8<<30)
+	inst := fake.attach()
+
+	err := inst.Backup(context.Background(), "disk0-format",
+		&FileDiskBackend{Path: "/backups/disk0.qcow2"},
+		&BackupOptions{Compress: true, KeySecret: "backupkey", Bitmap: "backup"})
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+
+	creates := fake.commands("blockdev-create")
+	if len(creates) != 2 {
+		t.Fatalf("expected 2 blockdev-create jobs, got %d", len(creates))
+	}
+	qcow2 := creates[1]["options"].(map[string]any)
+	encrypt, _ := qcow2["encrypt"].(map[string]any)
+	if qcow2["size"] != float64(8<<30) || encrypt["format"] != "luks" || encrypt["key-secret"] != "backupkey" {
+		t.Errorf("unexpected qcow2 creation: %v", qcow2)
+	}
+
+	backups := fake.commands("blockdev-backup")
+	if len(backups) != 1 {
+		t.Fatalf("expected 1 blockdev-backup job, got %d", len(backups))
+	}
+	backup := backups[0]
+	target, _ := backup["target"].(string)
+	if backup["device"] != "disk0-format" || backup["compress"] != true || backup["sync"] != "incremental" || backup["bitmap"] != "backup" {
+		t.Errorf("unexpected blockdev-backup: %v", backup)
+	}
+
+	// The target nodes are removed once the backup is done
+	dels := fake.commands("blockdev-del")
+	if len(dels) != 2 || dels[0]["node-name"] != target || dels[1]["node-name"] != target+"-file" {
+		t.Errorf("unexpected blockdev-del calls: %v", dels)
+	}
+}
+
+func TestBackupLUKS(t *testing.T) {
+	fake := newFakeQMP(t)
+	handleBlockJobs(fake)
+	handleBackupSource(fake, 1<<30)
+	inst := fake.attach()
+
+	backend := &FileDiskBackend{Path: "/backups/disk0.luks"}
+	if err := inst.Backup(context.Background(), "disk0-format", backend, &BackupOptions{Format: "luks", Compress: true, KeySecret: "k"}); err == nil {
+		t.Error("Backup accepted a compressed luks target")
+	}
+	if err := inst.Backup(context.Background(), "disk0-format", backend, &BackupOptions{Format: "luks", KeySecret: "k"}); err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+
+	creates := fake.commands("blockdev-create")
+	luks := creates[len(creates)-1]["options"].(map[string]any)
+	if luks["driver"] != "luks" || luks["key-secret"] != "k" {
+		t.Errorf("unexpected luks creation: %v", luks)
+	}
+	backups := fake.commands("blockdev-backup")
+	if len(backups) != 1 || backups[0]["sync"] != "full" || backups[0]["compress"] != nil {
+		t.Errorf("unexpected blockdev-backup calls: %v", backups)
+	}
+
+	if err := inst.Backup(context.Background(), "missing", backend, nil); err == nil {
+		t.Error("Backup accepted a missing node")
+	}
+}
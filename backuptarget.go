@@ -0,0 +1,156 @@
+package qemuctl
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"time"
+)
+
+// BackupTarget stores backups, such as an object storage bucket (see
+// S3Target) or a backup server.
+type BackupTarget interface {
+	// Put stores everything read from r as object key, replacing any
+	// object of the same key. Nothing is stored if Put fails.
+	Put(ctx context.Context, key string, r io.Reader) error
+}
+
+// BackupManifest describes a backup stored on a BackupTarget. It is stored
+// next to the backup as key + ".manifest.json".
+type BackupManifest struct {
+	Key         string    `json:"key"`
+	VM          string    `json:"vm,omitempty"`
+	Node        string    `json:"node,omitempty"`
+	Format      string    `json:"format"`
+	Compressed  bool      `json:"compressed,omitempty"`
+	Encrypted   bool      `json:"encrypted,omitempty"`
+	Sync        string    `json:"sync,omitempty"`
+	Bitmap      string    `json:"bitmap,omitempty"`
+	VirtualSize int64     `json:"virtual_size"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	Started     time.Time `json:"started"`
+	Finished    time.Time `json:"finished"`
+}
+
+// BackupToTarget backs up block node node with Backup into a scratch image
+// (see BackupOptions.ScratchDir), then streams the image to target as
+// object key, followed by its manifest. The scratch image is removed
+// afterwards. QEMU writes the image out of order, so the backup cannot be
+// streamed as it is made: the scratch directory needs room for the whole
+// image.
+func (i *Instance) BackupToTarget(ctx context.Context, node string, target BackupTarget, key string, opts *BackupOptions) (*BackupManifest, error) {
+	if opts == nil {
+		opts = &BackupOptions{}
+	}
+	format := opts.Format
+	if format == "" {
+		format = "qcow2"
+	}
+	manifest := &BackupManifest{
+		Key:        key,
+		VM:         i.name,
+		Node:       node,
+		Format:     format,
+		Compressed: opts.Compress,
+		Encrypted:  opts.KeySecret != "",
+		Sync:       opts.sync(),
+		Bitmap:     opts.Bitmap,
+		Started:    time.Now().UTC(),
+	}
+	size, err := i.nodeSize(node)
+	if err != nil {
+		return nil, err
+	}
+	manifest.VirtualSize = size
+
+	scratch, err := os.CreateTemp(opts.ScratchDir, "qemuctl-backup-*."+format)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create scratch image: %w", err)
+	}
+	scratch.Close()
+	defer os.Remove(scratch.Name())
+
+	if err := i.Backup(ctx, node, &FileDiskBackend{Path: scratch.Name()}, opts); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(scratch.Name())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := uploadBackup(ctx, target, f, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// UploadNBDExport streams an NBD export, such as a block node exported
+// with AddNBDExport, to target as a raw image of key, followed by its
+// manifest. The export must not change while it is read: export a paused
+// VM, a stopped image served by qemu-nbd or a snapshot node.
+func UploadNBDExport(ctx context.Context, target BackupTarget, key, socketPath, export string) (*BackupManifest, error) {
+	manifest := &BackupManifest{
+		Key:     key,
+		Format:  "raw",
+		Started: time.Now().UTC(),
+	}
+	client, err := DialNBD(ctx, socketPath, export)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	manifest.VirtualSize = client.Size()
+
+	if err := uploadBackup(ctx, target, io.NewSectionReader(client, 0, client.Size()), manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// uploadBackup stores the backup read from r and its manifest.
+func uploadBackup(ctx context.Context, target BackupTarget, r io.Reader, manifest *BackupManifest) error {
+	hr := &hashingReader{r: r, h: sha256.New()}
+	if err := target.Put(ctx, manifest.Key, hr); err != nil {
+		return fmt.Errorf("failed to upload backup: %w", err)
+	}
+	manifest.Size = hr.n
+	manifest.SHA256 = hex.EncodeToString(hr.h.Sum(nil))
+	manifest.Finished = time.Now().UTC()
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := target.Put(ctx, manifest.Key+".manifest.json", bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("failed to upload backup manifest: %w", err)
+	}
+	return nil
+}
+
+// hashingReader hashes and counts what is read through it.
+type hashingReader struct {
+	r io.Reader
+	h hash.Hash
+	n int64
+}
+
+// Size returns the size of the underlying reader, so targets can size
+// their uploads (see readerSize).
+func (r *hashingReader) Size() int64 {
+	return readerSize(r.r)
+}
+
+func (r *hashingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.h.Write(p[:n])
+	r.n += int64(n)
+	return n, err
+}
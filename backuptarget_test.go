@@ -0,0 +1,149 @@
+package qemuctl
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// memoryTarget is a BackupTarget keeping objects in memory.
+type memoryTarget struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (m *memoryTarget) Put(ctx context.Context, key string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.objects == nil {
+		m.objects = make(map[string][]byte)
+	}
+	m.objects[key] = data
+	return nil
+}
+
+// serveNBD serves disk as export name on a Unix socket, answering the
+// newstyle handshake and read commands.
+func serveNBD(t *testing.T, name string, disk []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "nbd.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		greeting := binary.BigEndian.AppendUint64(nil, nbdMagic)
+		greeting = binary.BigEndian.AppendUint64(greeting, nbdOptMagic)
+		greeting = binary.BigEndian.AppendUint16(greeting, nbdFlagFixed|nbdFlagNoZeroes)
+		conn.Write(greeting)
+
+		var opt struct {
+			ClientFlags uint32
+			Magic       uint64
+			Option      uint32
+			Length      uint32
+		}
+		if binary.Read(conn, binary.BigEndian, &opt) != nil {
+			return
+		}
+		export := make([]byte, opt.Length)
+		io.ReadFull(conn, export)
+		if string(export) != name {
+			return
+		}
+		reply := binary.BigEndian.AppendUint64(nil, uint64(len(disk)))
+		conn.Write(binary.BigEndian.AppendUint16(reply, 0))
+
+		for {
+			var req struct {
+				Magic  uint32
+				Flags  uint16
+				Type   uint16
+				Handle uint64
+				Offset uint64
+				Length uint32
+			}
+			if binary.Read(conn, binary.BigEndian, &req) != nil || req.Type != nbdCmdRead {
+				return
+			}
+			reply := binary.BigEndian.AppendUint32(nil, nbdReplyMagic)
+			reply = binary.BigEndian.AppendUint32(reply, 0)
+			reply = binary.BigEndian.AppendUint64(reply, req.Handle)
+			conn.Write(append(reply, disk[req.Offset:req.Offset+uint64(req.Length)]...))
+		}
+	}()
+	return path
+}
+
+func TestUploadNBDExport(t *testing.T) {
+	disk := bytes.Repeat([]byte("0123456789abcdef"), 1<<18) // 4 MiB
+	socket := serveNBD(t, "disk0", disk)
+
+	target := &memoryTarget{}
+	manifest, err := UploadNBDExport(context.Background(), target, "vm/disk0.raw", socket, "disk0")
+	if err != nil {
+		t.Fatalf("UploadNBDExport failed: %v", err)
+	}
+	if !bytes.Equal(target.objects["vm/disk0.raw"], disk) {
+		t.Errorf("uploaded %d bytes, want the %d byte disk", len(target.objects["vm/disk0.raw"]), len(disk))
+	}
+	if manifest.Size != int64(len(disk)) || manifest.VirtualSize != int64(len(disk)) || len(manifest.SHA256) != 64 {
+		t.Errorf("manifest = %+v", manifest)
+	}
+
+	var stored BackupManifest
+	if err := json.Unmarshal(target.objects["vm/disk0.raw.manifest.json"], &stored); err != nil || stored.SHA256 != manifest.SHA256 {
+		t.Errorf("stored manifest = %s (%v)", target.objects["vm/disk0.raw.manifest.json"], err)
+	}
+
+	if _, err := UploadNBDExport(context.Background(), target, "x", serveNBD(t, "disk0", disk), "other"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("UploadNBDExport of a missing export = %v", err)
+	}
+}
+
+func TestBackupToTarget(t *testing.T) {
+	fake := newFakeQMP(t)
+	handleBlockJobs(fake)
+	handleBackupSource(fake, 1<<30)
+	inst := fake.attach()
+
+	target := &memoryTarget{}
+	manifest, err := inst.BackupToTarget(context.Background(), "disk0-format", target, "vm/disk0.qcow2",
+		&BackupOptions{Compress: true, ScratchDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("BackupToTarget failed: %v", err)
+	}
+	if manifest.Format != "qcow2" || !manifest.Compressed || manifest.Sync != "full" || manifest.VirtualSize != 1<<30 {
+		t.Errorf("manifest = %+v", manifest)
+	}
+	if _, ok := target.objects["vm/disk0.qcow2"]; !ok {
+		t.Error("backup not uploaded")
+	}
+	if _, ok := target.objects["vm/disk0.qcow2.manifest.json"]; !ok {
+		t.Error("manifest not uploaded")
+	}
+
+	backups := fake.commands("blockdev-backup")
+	if len(backups) != 1 || backups[0]["compress"] != true {
+		t.Errorf("blockdev-backup calls = %v", backups)
+	}
+}
@@ -0,0 +1,252 @@
+package qemuctl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// DirtyBitmap describes a block dirty bitmap, which tracks the areas of a
+// block node written since the bitmap was created or last cleared, so
+// incremental backups only copy those.
+type DirtyBitmap struct {
+	Name string `json:"name"`
+
+	// Recording reports whether writes are being tracked.
+	Recording bool `json:"recording"`
+
+	// Busy reports whether the bitmap is in use by a job.
+	Busy bool `json:"busy"`
+
+	// Granularity is the tracking unit in bytes.
+	Granularity int64 `json:"granularity"`
+
+	// Count is the number of dirty bytes.
+	Count int64 `json:"count"`
+
+	// Persistent bitmaps are stored in the qcow2 image when the VM stops,
+	// and loaded when it starts.
+	Persistent bool `json:"persistent"`
+
+	// Inconsistent bitmaps were not saved properly, such as after a crash,
+	// and must be removed.
+	Inconsistent bool `json:"inconsistent,omitempty"`
+}
+
+// DirtyBitmapOptions configures Instance.AddDirtyBitmap.
+type DirtyBitmapOptions struct {
+	// Granularity is the tracking unit in bytes, a power of two. Defaults
+	// to the image cluster size.
+	Granularity int64
+
+	// Persistent stores the bitmap in the qcow2 image so it survives VM
+	// restarts.
+	Persistent bool
+
+	// Disabled creates the bitmap without recording writes.
+	Disabled bool
+}
+
+// AddDirtyBitmap adds a dirty bitmap to a block node.
+func (i *Instance) AddDirtyBitmap(node, name string, opts *DirtyBitmapOptions) error {
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return ErrNotConnected
+	}
+
+	_, err := qmp.Execute("block-dirty-bitmap-add", dirtyBitmapAddArgs(node, name, opts))
+	return err
+}
+
+// dirtyBitmapAddArgs builds the block-dirty-bitmap-add arguments.
+func dirtyBitmapAddArgs(node, name string, opts *DirtyBitmapOptions) map[string]any {
+	args := map[string]any{"node": node, "name": name}
+	if opts == nil {
+		return args
+	}
+	if opts.Granularity > 0 {
+		args["granularity"] = opts.Granularity
+	}
+	if opts.Persistent {
+		args["persistent"] = true
+	}
+	if opts.Disabled {
+		args["disabled"] = true
+	}
+	return args
+}
+
+// RemoveDirtyBitmap removes a dirty bitmap, from the qcow2 image too if it
+// is persistent.
+func (i *Instance) RemoveDirtyBitmap(node, name string) error {
+	return i.dirtyBitmapCommand("block-dirty-bitmap-remove", node, name)
+}
+
+// ClearDirtyBitmap marks the whole block node clean, typically once a
+// full backup has been taken.
+func (i *Instance) ClearDirtyBitmap(node, name string) error {
+	return i.dirtyBitmapCommand("block-dirty-bitmap-clear", node, name)
+}
+
+// dirtyBitmapCommand runs a command taking a node and bitmap name.
+func (i *Instance) dirtyBitmapCommand(command, node, name string) error {
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return ErrNotConnected
+	}
+
+	_, err := qmp.Execute(command, map[string]any{"node": node, "name": name})
+	return err
+}
+
+// PersistDirtyBitmap makes an existing bitmap persistent, keeping its
+// contents. QEMU cannot change the flag in place, so the bitmap is copied
+// to a temporary bitmap and recreated, in a single transaction.
+func (i *Instance) PersistDirtyBitmap(node, name string) error {
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return ErrNotConnected
+	}
+
+	bitmaps, err := i.DirtyBitmaps(node)
+	if err != nil {
+		return err
+	}
+	var bitmap *DirtyBitmap
+	for idx := range bitmaps {
+		if bitmaps[idx].Name == name {
+			bitmap = &bitmaps[idx]
+			break
+		}
+	}
+	if bitmap == nil {
+		return fmt.Errorf("dirty bitmap %q not found on node %q", name, node)
+	}
+	if bitmap.Persistent {
+		return nil
+	}
+
+	tmp := name + ".persist-tmp"
+	action := func(typ string, data map[string]any) map[string]any {
+		return map[string]any{"type": typ, "data": data}
+	}
+	ref := func(bitmap string) map[string]any {
+		return map[string]any{"node": node, "name": bitmap}
+	}
+	merge := func(src, dst string) map[string]any {
+		return map[string]any{"node": node, "target": dst, "bitmaps": []string{src}}
+	}
+	actions := []any{
+		action("block-dirty-bitmap-add", map[string]any{"node": node, "name": tmp, "granularity": bitmap.Granularity, "disabled": true}),
+		action("block-dirty-bitmap-merge", merge(name, tmp)),
+		action("block-dirty-bitmap-remove", ref(name)),
+		action("block-dirty-bitmap-add", map[string]any{"node": node, "name": name, "granularity": bitmap.Granularity,
+			"persistent": true, "disabled": !bitmap.Recording}),
+		action("block-dirty-bitmap-merge", merge(tmp, name)),
+		action("block-dirty-bitmap-remove", ref(tmp)),
+	}
+	_, err = qmp.Execute("transaction", map[string]any{"actions": actions})
+	return err
+}
+
+// DirtyBitmaps returns the dirty bitmaps of a block node.
+func (i *Instance) DirtyBitmaps(node string) ([]DirtyBitmap, error) {
+	nodes, err := i.QueryNamedBlockNodes(true)
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range nodes {
+		if n.NodeName == node {
+			return n.DirtyBitmaps, nil
+		}
+	}
+	return nil, fmt.Errorf("block node %q not found", node)
+}
+
+// ImageBitmap is a persistent dirty bitmap stored in a qcow2 image.
+type ImageBitmap struct {
+	Name        string `json:"name"`
+	Granularity int64  `json:"granularity"`
+
+	// Flags holds "auto" for bitmaps that record writes and "in-use" for
+	// bitmaps of images in use, or not closed properly.
+	Flags []string `json:"flags"`
+}
+
+// ImageBitmapOptions configures AddImageBitmap and RemoveImageBitmap.
+type ImageBitmapOptions struct {
+	// Granularity is the tracking unit in bytes for new bitmaps.
+	Granularity int64
+
+	// Disabled creates the bitmap without recording writes.
+	Disabled bool
+
+	// QemuImgPath overrides the qemu-img binary path.
+	QemuImgPath string
+}
+
+// ListImageBitmaps returns the persistent dirty bitmaps of a qcow2 image.
+func ListImageBitmaps(ctx context.Context, path string, opts *ImageInfoOptions) ([]ImageBitmap, error) {
+	info, err := ImageInfo(ctx, path, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(info.FormatSpecific) == 0 {
+		return nil, nil
+	}
+
+	var specific struct {
+		Type string `json:"type"`
+		Data struct {
+			Bitmaps []ImageBitmap `json:"bitmaps"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(info.FormatSpecific, &specific); err != nil {
+		return nil, fmt.Errorf("failed to parse qemu-img info bitmaps: %w", err)
+	}
+	return specific.Data.Bitmaps, nil
+}
+
+// AddImageBitmap adds a persistent dirty bitmap to a qcow2 image that is
+// not in use. Requires qemu-img 5.1+.
+func AddImageBitmap(ctx context.Context, path, name string, opts *ImageBitmapOptions) error {
+	if opts == nil {
+		opts = &ImageBitmapOptions{}
+	}
+	_, err := runQemuImg(ctx, opts.QemuImgPath, imageBitmapArgs("--add", path, name, opts))
+	return err
+}
+
+// RemoveImageBitmap removes a persistent dirty bitmap from a qcow2 image
+// that is not in use. Requires qemu-img 5.1+.
+func RemoveImageBitmap(ctx context.Context, path, name string, opts *ImageBitmapOptions) error {
+	if opts == nil {
+		opts = &ImageBitmapOptions{}
+	}
+	_, err := runQemuImg(ctx, opts.QemuImgPath, imageBitmapArgs("--remove", path, name, opts))
+	return err
+}
+
+// imageBitmapArgs builds the qemu-img bitmap arguments.
+func imageBitmapArgs(action, path, name string, opts *ImageBitmapOptions) []string {
+	args := []string{"bitmap", "-f", "qcow2", action}
+	if action == "--add" {
+		if opts.Granularity > 0 {
+			args = append(args, "-g", strconv.FormatInt(opts.Granularity, 10))
+		}
+		if opts.Disabled {
+			args = append(args, "--disable")
+		}
+	}
+	return append(args, path, name)
+}
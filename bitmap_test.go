@@ -0,0 +1,112 @@
+package qemuctl
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestDirtyBitmaps(t *testing.T) {
+	fake := newFakeQMP(t)
+	for _, cmd := range []string{"block-dirty-bitmap-add", "block-dirty-bitmap-clear", "transaction"} {
+		fake.handle(cmd, func(map[string]any) (any, *qmpError) { return map[string]any{}, nil })
+	}
+	fake.handle("query-named-block-nodes", func(map[string]any) (any, *qmpError) {
+		return []any{map[string]any{
+			"node-name": "disk0-format",
+			"drv":       "qcow2",
+			"dirty-bitmaps": []any{
+				map[string]any{"name": "backup", "recording": true, "granularity": 65536, "count": 1 << 20, "persistent": false},
+			},
+		}}, nil
+	})
+	inst := fake.attach()
+
+	if err := inst.AddDirtyBitmap("disk0-format", "backup", &DirtyBitmapOptions{Persistent: true, Granularity: 65536}); err != nil {
+		t.Fatalf("AddDirtyBitmap failed: %v", err)
+	}
+	add := fake.commands("block-dirty-bitmap-add")
+	if len(add) != 1 || add[0]["persistent"] != true || add[0]["granularity"] != float64(65536) {
+		t.Errorf("block-dirty-bitmap-add calls = %v", add)
+	}
+
+	bitmaps, err := inst.DirtyBitmaps("disk0-format")
+	if err != nil {
+		t.Fatalf("DirtyBitmaps failed: %v", err)
+	}
+	if len(bitmaps) != 1 || bitmaps[0].Name != "backup" || bitmaps[0].Count != 1<<20 || !bitmaps[0].Recording {
+		t.Errorf("bitmaps = %+v", bitmaps)
+	}
+	if _, err := inst.DirtyBitmaps("missing"); err == nil {
+		t.Error("DirtyBitmaps of a missing node succeeded")
+	}
+
+	if err := inst.PersistDirtyBitmap("disk0-format", "backup"); err != nil {
+		t.Fatalf("PersistDirtyBitmap failed: %v", err)
+	}
+	tx := fake.commands("transaction")
+	if len(tx) != 1 {
+		t.Fatalf("transaction calls = %v", tx)
+	}
+	actions := tx[0]["actions"].([]any)
+	if len(actions) != 6 {
+		t.Fatalf("transaction has %d actions, want 6", len(actions))
+	}
+	readd := actions[3].(map[string]any)["data"].(map[string]any)
+	if readd["name"] != "backup" || readd["persistent"] != true || readd["disabled"] != false {
+		t.Errorf("persistent bitmap re-creation = %v", readd)
+	}
+}
+
+func TestImageBitmaps(t *testing.T) {
+	path := fakeQemuImg(t, `{
+    "virtual-size": 10737418240,
+    "filename": "disk.qcow2",
+    "format": "qcow2",
+    "format-specific": {"type": "qcow2", "data": {"compat": "1.1", "bitmaps": [
+        {"flags": ["auto"], "name": "backup", "granularity": 65536}
+    ]}}
+}`, 0)
+
+	bitmaps, err := ListImageBitmaps(context.Background(), "disk.qcow2", &ImageInfoOptions{QemuImgPath: path})
+	if err != nil {
+		t.Fatalf("ListImageBitmaps failed: %v", err)
+	}
+	if len(bitmaps) != 1 || bitmaps[0].Name != "backup" || bitmaps[0].Granularity != 65536 || bitmaps[0].Flags[0] != "auto" {
+		t.Errorf("bitmaps = %+v", bitmaps)
+	}
+
+	args := strings.Join(imageBitmapArgs("--add", "disk.qcow2", "backup", &ImageBitmapOptions{Granularity: 65536, Disabled: true}), " ")
+	if args != "bitmap -f qcow2 --add -g 65536 --disable disk.qcow2 backup" {
+		t.Errorf("bitmap args = %q", args)
+	}
+}
+
+func TestMigrationCapabilities(t *testing.T) {
+	fake := newFakeQMP(t)
+	fake.handle("migrate-set-capabilities", func(map[string]any) (any, *qmpError) { return map[string]any{}, nil })
+	fake.handle("query-migrate-capabilities", func(map[string]any) (any, *qmpError) {
+		return []any{
+			map[string]any{"capability": "dirty-bitmaps", "state": true},
+			map[string]any{"capability": "postcopy-ram", "state": false},
+		}, nil
+	})
+	inst := fake.attach()
+
+	if err := inst.SetMigrationCapabilities(map[string]bool{MigrationCapDirtyBitmaps: true}); err != nil {
+		t.Fatalf("SetMigrationCapabilities failed: %v", err)
+	}
+	calls := fake.commands("migrate-set-capabilities")
+	caps := calls[0]["capabilities"].([]any)
+	if c := caps[0].(map[string]any); c["capability"] != "dirty-bitmaps" || c["state"] != true {
+		t.Errorf("capabilities = %v", caps)
+	}
+
+	got, err := inst.MigrationCapabilities()
+	if err != nil {
+		t.Fatalf("MigrationCapabilities failed: %v", err)
+	}
+	if !got["dirty-bitmaps"] || got["postcopy-ram"] {
+		t.Errorf("capabilities = %v", got)
+	}
+}
@@ -0,0 +1,257 @@
+package qemuctl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// blockdevCancelTimeout is how long BlockdevCreate waits for a cancelled
+// job to conclude before giving up on dismissing it.
+const blockdevCancelTimeout = 10 * time.Second
+
+// LiveImageOptions configures Instance.CreateImage.
+type LiveImageOptions struct {
+	// Format is the image format ("qcow2", "raw", "luks"). Defaults to "qcow2".
+	Format string
+
+	// Size is the virtual size in bytes.
+	Size int64
+
+	// ClusterSize is the qcow2 cluster size in bytes.
+	ClusterSize int64
+
+	// BackingFile is the qcow2 backing file name.
+	BackingFile string
+
+	// BackingFormat is the format of BackingFile.
+	BackingFormat string
+
+	// Preallocation is the preallocation mode ("off", "metadata", "falloc", "full").
+	Preallocation string
+
+	// KeySecret is the ID of the secret object holding the LUKS passphrase.
+	// Required for the "luks" format; qcow2 images are LUKS-encrypted with
+	// it if set.
+	KeySecret string
+}
+
+// BlockdevAdd adds a block node to the running VM.
+func (i *Instance) BlockdevAdd(options map[string]any) error {
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return ErrNotConnected
+	}
+
+	_, err := qmp.Execute("blockdev-add", options)
+	return err
+}
+
+// BlockdevDel removes a block node from the running VM.
+func (i *Instance) BlockdevDel(nodeName string) error {
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return ErrNotConnected
+	}
+
+	_, err := qmp.Execute("blockdev-del", map[string]any{
+		"node-name": nodeName,
+	})
+	return err
+}
+
+// EjectCDROM removes the medium from the CD-ROM drive at index in the VM
+// configuration, even if the guest has locked the tray.
+func (i *Instance) EjectCDROM(index int) error {
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return ErrNotConnected
+	}
+
+	_, err := qmp.Execute("eject", map[string]any{
+		"id":    fmt.Sprintf("cdrom%d-device", index),
+		"force": true,
+	})
+	return err
+}
+
+// BlockdevCreate runs a blockdev-create job with the given creation options
+// (as documented for BlockdevCreateOptions in the QEMU QAPI schema) and
+// waits for it to finish. The job is cancelled if ctx is done first.
+func (i *Instance) BlockdevCreate(ctx context.Context, options map[string]any) error {
+	job, err := i.StartJob("blockdev-create", map[string]any{"options": options})
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-job.Done():
+	case <-ctx.Done():
+		// blockdev-create jobs are never dismissed automatically, even
+		// once cancelled
+		if job.Cancel() == nil {
+			select {
+			case <-job.Done():
+				job.Dismiss()
+			case <-time.After(blockdevCancelTimeout):
+			}
+		}
+		return ctx.Err()
+	}
+
+	job.Dismiss()
+	return job.Err()
+}
+
+// CreateImage creates a disk image on backend using the running QEMU
+// process, then attaches it as block node nodeName ready for device_add.
+// It works where qemu-img is unavailable and for remote storage: file and
+// RBD images are created, NBD and iSCSI targets must already exist and are
+// only formatted.
+func (i *Instance) CreateImage(ctx context.Context, nodeName string, backend DiskBackend, opts *LiveImageOptions) error {
+	if opts == nil {
+		opts = &LiveImageOptions{}
+	}
+	format := opts.Format
+	if format == "" {
+		format = "qcow2"
+	}
+	if format == "luks" && opts.KeySecret == "" {
+		return fmt.Errorf("luks format requires a key secret")
+	}
+
+	proto, err := protocolBlockdev(backend, nodeName)
+	if err != nil {
+		return err
+	}
+	protoNode, _ := proto["node-name"].(string)
+
+	// Raw images take their size from the protocol layer
+	protoSize := int64(0)
+	if format == "raw" {
+		protoSize = opts.Size
+	}
+
+	if create := protocolCreateOptions(proto, protoSize, opts.Size); create != nil {
+		if err := i.BlockdevCreate(ctx, create); err != nil {
+			return fmt.Errorf("failed to create %s storage: %w", proto["driver"], err)
+		}
+	}
+
+	if err := i.BlockdevAdd(proto); err != nil {
+		return err
+	}
+
+	if format != "raw" {
+		create := map[string]any{
+			"driver": format,
+			"file":   protoNode,
+			"size":   opts.Size,
+		}
+		switch format {
+		case "qcow2":
+			if opts.ClusterSize > 0 {
+				create["cluster-size"] = opts.ClusterSize
+			}
+			if opts.BackingFile != "" {
+				create["backing-file"] = opts.BackingFile
+			}
+			if opts.BackingFormat != "" {
+				create["backing-fmt"] = opts.BackingFormat
+			}
+			if opts.Preallocation != "" {
+				create["preallocation"] = opts.Preallocation
+			}
+			if opts.KeySecret != "" {
+				create["encrypt"] = map[string]any{"format": "luks", "key-secret": opts.KeySecret}
+			}
+		case "luks":
+			create["key-secret"] = opts.KeySecret
+			if opts.Preallocation != "" {
+				create["preallocation"] = opts.Preallocation
+			}
+		}
+
+		if err := i.BlockdevCreate(ctx, create); err != nil {
+			i.BlockdevDel(protoNode)
+			return fmt.Errorf("failed to format image: %w", err)
+		}
+	}
+
+	formatOpts := map[string]any{
+		"driver":    format,
+		"file":      protoNode,
+		"node-name": nodeName,
+	}
+	switch {
+	case format == "luks":
+		formatOpts["key-secret"] = opts.KeySecret
+	case format == "qcow2" && opts.KeySecret != "":
+		formatOpts["encrypt"] = map[string]any{"format": "luks", "key-secret": opts.KeySecret}
+	}
+	if err := i.BlockdevAdd(formatOpts); err != nil {
+		i.BlockdevDel(protoNode)
+		return err
+	}
+
+	return nil
+}
+
+// protocolBlockdev returns the blockdev-add options of the protocol node
+// the backend would use on the command line.
+func protocolBlockdev(backend DiskBackend, id string) (map[string]any, error) {
+	if backend == nil {
+		return nil, fmt.Errorf("disk backend is required")
+	}
+
+	args := backend.BuildBlockdevArgs(id)
+	if len(args) < 2 || args[0] != "-blockdev" {
+		return nil, fmt.Errorf("unsupported disk backend %q", backend.Type())
+	}
+
+	var opts map[string]any
+	if err := json.Unmarshal([]byte(args[1]), &opts); err != nil {
+		return nil, fmt.Errorf("invalid blockdev options for %q: %w", backend.Type(), err)
+	}
+	return opts, nil
+}
+
+// protocolCreateOptions returns the blockdev-create options for a protocol
+// node, or nil if the protocol cannot create storage.
+func protocolCreateOptions(proto map[string]any, size, rbdSize int64) map[string]any {
+	switch proto["driver"] {
+	case "file":
+		return map[string]any{
+			"driver":   "file",
+			"filename": proto["filename"],
+			"size":     size,
+		}
+	case "rbd":
+		location := map[string]any{
+			"pool":  proto["pool"],
+			"image": proto["image"],
+		}
+		for _, key := range []string{"conf", "user", "key-secret", "auth-client-required"} {
+			if v, ok := proto[key]; ok {
+				location[key] = v
+			}
+		}
+		// RBD images have a fixed size, even under a qcow2 layer
+		return map[string]any{
+			"driver":   "rbd",
+			"location": location,
+			"size":     rbdSize,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package qemuctl
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// handleBlockJobs makes fake complete every blockdev-create and
+// blockdev-backup job, failing creations whose driver is listed in fail.
+func handleBlockJobs(fake *fakeQMP, fail ...string) {
+	var mu sync.Mutex
+	var jobs []map[string]any
+
+	fake.handle("blockdev-create", func(args map[string]any) (any, *qmpError) {
+		job := map[string]any{"id": args["job-id"], "type": "create", "status": "concluded"}
+		opts := args["options"].(map[string]any)
+		for _, driver := range fail {
+			if opts["driver"] == driver {
+				job["error"] = "Could not create image"
+			}
+		}
+		mu.Lock()
+		jobs = append(jobs, job)
+		mu.Unlock()
+		return map[string]any{}, nil
+	})
+	fake.handle("blockdev-backup", func(args map[string]any) (any, *qmpError) {
+		mu.Lock()
+		jobs = append(jobs, map[string]any{"id": args["job-id"], "type": "backup", "status": "concluded"})
+		mu.Unlock()
+		return map[string]any{}, nil
+	})
+	fake.handle("query-jobs", func(map[string]any) (any, *qmpError) {
+		mu.Lock()
+		defer mu.Unlock()
+		return jobs, nil
+	})
+	fake.handle("job-dismiss", func(args map[string]any) (any, *qmpError) {
+		mu.Lock()
+		defer mu.Unlock()
+		for idx, job := range jobs {
+			if job["id"] == args["id"] {
+				jobs = append(jobs[:idx], jobs[idx+1:]...)
+				break
+			}
+		}
+		return map[string]any{}, nil
+	})
+	fake.handle("blockdev-add", func(map[string]any) (any, *qmpError) {
+		return map[string]any{}, nil
+	})
+	fake.handle("blockdev-del", func(map[string]any) (any, *qmpError) {
+		return map[string]any{}, nil
+	})
+}
+
+func TestInstanceCreateImage(t *testing.T) {
+	fake := newFakeQMP(t)
+	handleBlockJobs(fake)
+	inst := fake.attach()
+
+	err := inst.CreateImage(context.Background(), "data0",
+		&FileDiskBackend{Path: "/var/lib/qemu/data0.qcow2"},
+		&LiveImageOptions{Size: 1 << 30, ClusterSize: 65536})
+	if err != nil {
+		t.Fatalf("CreateImage error: %v", err)
+	}
+
+	creates := fake.commands("blockdev-create")
+	if len(creates) != 2 {
+		t.Fatalf("expected 2 blockdev-create jobs, got %d", len(creates))
+	}
+	file := creates[0]["options"].(map[string]any)
+	if file["driver"] != "file" || file["filename"] != "/var/lib/qemu/data0.qcow2" || file["size"] != float64(0) {
+		t.Errorf("unexpected file creation: %v", file)
+	}
+	qcow2 := creates[1]["options"].(map[string]any)
+	if qcow2["driver"] != "qcow2" || qcow2["file"] != "data0-file" || qcow2["size"] != float64(1<<30) {
+		t.Errorf("unexpected qcow2 creation: %v", qcow2)
+	}
+
+	adds := fake.commands("blockdev-add")
+	if len(adds) != 2 || adds[0]["node-name"] != "data0-file" || adds[1]["node-name"] != "data0" {
+		t.Errorf("unexpected blockdev-add calls: %v", adds)
+	}
+
+	if len(fake.commands("job-dismiss")) != 2 {
+		t.Errorf("expected jobs to be dismissed")
+	}
+}
+
+func TestInstanceCreateImageRBDRaw(t *testing.T) {
+	fake := newFakeQMP(t)
+	handleBlockJobs(fake)
+	inst := fake.attach()
+
+	err := inst.CreateImage(context.Background(), "vol0",
+		&RBDDiskBackend{Pool: "rbd", Image: "vol0", User: "admin"},
+		&LiveImageOptions{Format: "raw", Size: 10 << 30})
+	if err != nil {
+		t.Fatalf("CreateImage error: %v", err)
+	}
+
+	creates := fake.commands("blockdev-create")
+	if len(creates) != 1 {
+		t.Fatalf("expected 1 blockdev-create job, got %d", len(creates))
+	}
+	rbd := creates[0]["options"].(map[string]any)
+	location := rbd["location"].(map[string]any)
+	if rbd["driver"] != "rbd" || rbd["size"] != float64(10<<30) || location["image"] != "vol0" || location["user"] != "admin" {
+		t.Errorf("unexpected rbd creation: %v", rbd)
+	}
+
+	adds := fake.commands("blockdev-add")
+	if len(adds) != 2 || adds[1]["driver"] != "raw" || adds[1]["file"] != "vol0-rbd" {
+		t.Errorf("unexpected blockdev-add calls: %v", adds)
+	}
+}
+
+func TestBlockdevCreateCancel(t *testing.T) {
+	fake := newFakeQMP(t)
+	fj := handleJobs(fake)
+	fake.handle("blockdev-create", func(args map[string]any) (any, *qmpError) {
+		fj.set(args["job-id"].(string), "running", "")
+		return map[string]any{}, nil
+	})
+	fake.handle("job-cancel", func(args map[string]any) (any, *qmpError) {
+		id := args["id"].(string)
+		fj.set(id, "concluded", "")
+		go fake.sendEvent("JOB_STATUS_CHANGE", map[string]any{"id": id, "status": "concluded"})
+		return map[string]any{}, nil
+	})
+	inst := fake.attach()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := inst.BlockdevCreate(ctx, map[string]any{"driver": "file", "filename": "/tmp/x", "size": 1024}); err != context.Canceled {
+		t.Fatalf("BlockdevCreate = %v, want context.Canceled", err)
+	}
+
+	// The cancelled job does not linger in query-jobs
+	if len(fake.commands("job-dismiss")) != 1 {
+		t.Errorf("job-dismiss calls = %v", fake.commands("job-dismiss"))
+	}
+	if jobs, _ := inst.Jobs(); len(jobs) != 0 {
+		t.Errorf("Jobs() = %v, want none", jobs)
+	}
+}
+
+func TestInstanceCreateImageFailure(t *testing.T) {
+	fake := newFakeQMP(t)
+	handleBlockJobs(fake, "qcow2")
+	inst := fake.attach()
+
+	err := inst.CreateImage(context.Background(), "data0",
+		&FileDiskBackend{Path: "/var/lib/qemu/data0.qcow2"},
+		&LiveImageOptions{Size: 1 << 30})
+	if err == nil || !strings.Contains(err.Error(), "Could not create image") {
+		t.Fatalf("expected job error, got: %v", err)
+	}
+
+	// The protocol node is removed again
+	dels := fake.commands("blockdev-del")
+	if len(dels) != 1 || dels[0]["node-name"] != "data0-file" {
+		t.Errorf("unexpected blockdev-del calls: %v", dels)
+	}
+
+	if err := inst.CreateImage(context.Background(), "enc0", &FileDiskBackend{Path: "/tmp/x"},
+		&LiveImageOptions{Format: "luks", Size: 1 << 20}); err == nil {
+		t.Error("expected error for luks without key secret")
+	}
+}
@@ -0,0 +1,92 @@
+package qemuctl
+
+import (
+	"errors"
+	"strings"
+)
+
+// defaultBootOrder is QEMU's boot order on PCs: floppy, disk, then CD-ROM.
+const defaultBootOrder = "cad"
+
+// SetBootOnce makes the guest boot from the devices in order, with the
+// letters of BootConfig.Order ("d" for the CD-ROM), for its next boot
+// only. The order is changed with the boot_set monitor command, which only
+// legacy BIOS machines implement; EFI firmware ignores it and follows
+// DiskConfig.BootIndex.
+//
+// Once the guest has booted and resets again, BootConfig.Order, or QEMU's
+// default, is restored. A guest that has not run yet boots from order when
+// continued, and gets the usual order back at its first reset.
+func (i *Instance) SetBootOnce(order string) error {
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return ErrNotConnected
+	}
+
+	status, err := queryRunStatus(qmp)
+	if err != nil {
+		return err
+	}
+	// A guest that never ran boots without a reset
+	resets := 2
+	if status == "prelaunch" {
+		resets = 1
+	}
+
+	// Subscribe first so a reset right after boot_set is counted
+	sub := qmp.Subscribe("RESET")
+	if err := i.bootSet(order); err != nil {
+		sub.Unsubscribe()
+		return err
+	}
+
+	i.bootOnceMu.Lock()
+	if i.bootOnce != nil {
+		i.bootOnce.Unsubscribe()
+	}
+	i.bootOnce = sub
+	i.bootOnceMu.Unlock()
+
+	go i.restoreBootOrder(sub, resets)
+	return nil
+}
+
+// restoreBootOrder sets the configured boot order back after the guest has
+// reset resets times, unless sub is cancelled first.
+func (i *Instance) restoreBootOrder(sub *Subscription, resets int) {
+	for range sub.C {
+		if resets--; resets > 0 {
+			continue
+		}
+
+		order := defaultBootOrder
+		if i.vmConfig != nil && i.vmConfig.Boot != nil && i.vmConfig.Boot.Order != "" {
+			order = i.vmConfig.Boot.Order
+		}
+		i.bootSet(order)
+
+		i.bootOnceMu.Lock()
+		if i.bootOnce == sub {
+			i.bootOnce = nil
+		}
+		i.bootOnceMu.Unlock()
+		sub.Unsubscribe()
+		return
+	}
+}
+
+// bootSet changes the boot order of the guest with the boot_set monitor
+// command, which reports errors as output.
+func (i *Instance) bootSet(order string) error {
+	out, err := i.HumanMonitorCommand("boot_set " + order)
+	if err != nil {
+		return err
+	}
+	if out = strings.TrimSpace(out); out != "" {
+		return errors.New("boot_set: " + out)
+	}
+	return nil
+}
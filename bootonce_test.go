@@ -0,0 +1,73 @@
+package qemuctl
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSetBootOnce(t *testing.T) {
+	fake := newFakeQMP(t)
+	cmds := make(chan string, 4)
+	fake.handle("human-monitor-command", func(args map[string]any) (any, *qmpError) {
+		cmd, _ := args["command-line"].(string)
+		cmds <- cmd
+		if cmd == "boot_set x" {
+			return "no such boot device 'x'\r\n", nil
+		}
+		return "", nil
+	})
+	inst := fake.attach()
+	inst.vmConfig = &VMConfig{Boot: &BootConfig{Order: "c"}}
+
+	next := func() string {
+		select {
+		case cmd := <-cmds:
+			return cmd
+		case <-time.After(5 * time.Second):
+			t.Fatal("no monitor command sent")
+			return ""
+		}
+	}
+
+	if err := inst.SetBootOnce("x"); err == nil || !strings.Contains(err.Error(), "no such boot device") {
+		t.Errorf("SetBootOnce(x) error = %v", err)
+	}
+	next()
+
+	if err := inst.SetBootOnce("d"); err != nil {
+		t.Fatal(err)
+	}
+	if cmd := next(); cmd != "boot_set d" {
+		t.Errorf("command = %q, want boot_set d", cmd)
+	}
+
+	// The guest boots from the CD-ROM after the first reset, and the
+	// configured order comes back at the second
+	fake.sendEvent("RESET", map[string]any{"guest": true, "reason": "guest-reset"})
+	select {
+	case cmd := <-cmds:
+		t.Fatalf("%q sent before the guest booted from the CD-ROM", cmd)
+	case <-time.After(50 * time.Millisecond):
+	}
+	fake.sendEvent("RESET", map[string]any{"guest": true, "reason": "guest-reset"})
+	if cmd := next(); cmd != "boot_set c" {
+		t.Errorf("command = %q, want boot_set c", cmd)
+	}
+}
+
+func TestVMBuilderBootOnce(t *testing.T) {
+	cfg := &VMConfig{Boot: &BootConfig{Order: "c", Once: "d"}}
+	args := NewVMBuilder(cfg).Build("test", "")
+	if joined := strings.Join(args, " "); !strings.Contains(joined, "-boot order=c,once=d") {
+		t.Errorf("args missing -boot order=c,once=d:\n%s", joined)
+	}
+
+	parsed, err := ParseArgs(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Boot == nil || parsed.Boot.Order != "c" || parsed.Boot.Once != "d" {
+		t.Errorf("parsed boot = %+v", parsed.Boot)
+	}
+}
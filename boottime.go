@@ -0,0 +1,123 @@
+package qemuctl
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// bootPollInterval is how often the guest agent is polled during boot.
+const bootPollInterval = 500 * time.Millisecond
+
+// bootTrackTimeout stops boot tracking for guests that never report in.
+const bootTrackTimeout = 10 * time.Minute
+
+// BootTimings records when each startup phase of a VM was first observed.
+// Zero values mean the phase has not been reached or could not be observed.
+type BootTimings struct {
+	// ProcessStart is when the QEMU process was started.
+	ProcessStart time.Time `json:"process_start"`
+
+	// QMPReady is when the QMP connection was established.
+	QMPReady time.Time `json:"qmp_ready"`
+
+	// FirstResume is when the VM was first seen running, either through a
+	// RESUME event or the initial status query.
+	FirstResume time.Time `json:"first_resume"`
+
+	// FirstRTCChange is when the guest first set its real-time clock,
+	// typically early in OS boot.
+	FirstRTCChange time.Time `json:"first_rtc_change"`
+
+	// GuestAgentReady is when the guest agent first answered guest-ping.
+	GuestAgentReady time.Time `json:"guest_agent_ready"`
+
+	// FirstIP is when the guest agent first reported a non-loopback address.
+	FirstIP time.Time `json:"first_ip"`
+}
+
+// Since returns the time between process start and t, or 0 if either is
+// unknown.
+func (b BootTimings) Since(t time.Time) time.Duration {
+	if b.ProcessStart.IsZero() || t.IsZero() {
+		return 0
+	}
+	return t.Sub(b.ProcessStart)
+}
+
+// BootTimings returns the startup phase timestamps recorded so far.
+// Guest agent phases are only tracked for instances started by this
+// package with a guest agent channel.
+func (i *Instance) BootTimings() BootTimings {
+	i.timingsMu.Lock()
+	defer i.timingsMu.Unlock()
+	return i.timings
+}
+
+// markBoot records a phase timestamp unless it was already set.
+func (i *Instance) markBoot(field func(*BootTimings) *time.Time, t time.Time) {
+	i.timingsMu.Lock()
+	defer i.timingsMu.Unlock()
+	if ts := field(&i.timings); ts.IsZero() {
+		*ts = t
+	}
+}
+
+// recordBootEvent updates boot timings from QMP events.
+func (i *Instance) recordBootEvent(event *Event) {
+	// RESUME is covered by setState, which also sees the initial status
+	if event.Name == "RTC_CHANGE" {
+		i.markBoot(func(b *BootTimings) *time.Time { return &b.FirstRTCChange }, event.Timestamp)
+	}
+}
+
+// trackGuestBoot polls the guest agent until it answers and reports an
+// address, the connection closes, or bootTrackTimeout expires.
+func (i *Instance) trackGuestBoot(agent *GuestAgent, done <-chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), bootTrackTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(bootPollInterval)
+	defer ticker.Stop()
+
+	agentReady := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		callCtx, callCancel := context.WithTimeout(ctx, bootPollInterval)
+		if !agentReady {
+			if agent.Ping(callCtx) == nil {
+				agentReady = true
+				i.markBoot(func(b *BootTimings) *time.Time { return &b.GuestAgentReady }, time.Now())
+			}
+		}
+		if agentReady {
+			if ifaces, err := agent.NetworkInterfaces(callCtx); err == nil && hasGuestIP(ifaces) {
+				i.markBoot(func(b *BootTimings) *time.Time { return &b.FirstIP }, time.Now())
+				callCancel()
+				return
+			}
+		}
+		callCancel()
+	}
+}
+
+// hasGuestIP reports whether any interface has a non-loopback,
+// non-link-local address.
+func hasGuestIP(ifaces []GuestNetworkInterface) bool {
+	for _, iface := range ifaces {
+		for _, addr := range iface.IPAddresses {
+			ip := net.ParseIP(addr.Address)
+			if ip != nil && !ip.IsLoopback() && !ip.IsLinkLocalUnicast() {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,353 @@
+// Package build produces VM disk images the way Packer does for simple
+// cases: a VM boots from a cloud image or an install ISO, provisioning
+// steps run in the guest over the guest agent or SSH, and the disk is
+// exported as a compressed qcow2 artifact with a checksum and metadata:
+//
+//	art, err := build.Run(ctx, &build.Template{
+//		Name:        "web",
+//		SourceImage: "debian-12-genericcloud-amd64.qcow2",
+//		DiskSize:    10 << 30,
+//		Config:      qemuctl.DefaultVMConfig().WithGuestAgent("/run/web-qga.sock"),
+//		Steps: []build.Step{
+//			&build.GuestExec{Path: "/usr/bin/apt-get", Args: []string{"install", "-y", "nginx"}},
+//		},
+//		OutputDir: "/var/lib/images",
+//	})
+//
+// The output directory receives <name>.qcow2, <name>.qcow2.sha256 (in
+// sha256sum format) and <name>.json (the Artifact).
+package build
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/KarpelesLab/qemuctl"
+)
+
+// defaultTimeout bounds a whole build.
+const defaultTimeout = 2 * time.Hour
+
+// shutdownTimeout is how long the guest has to power off once the steps
+// have run.
+const shutdownTimeout = 5 * time.Minute
+
+// Template describes an image build.
+type Template struct {
+	// Name is the artifact name. Defaults to "image".
+	Name string
+
+	// SourceImage is a disk image (such as a cloud image) the build starts
+	// from; it is copied, never modified. SourceFormat is probed if empty.
+	SourceImage  string
+	SourceFormat string
+
+	// SourceISO installs the guest from an ISO onto a blank disk instead.
+	// The installation is driven by a qemuctl.Provisioner, which needs
+	// InstallPattern or InstallWaitAgent to detect completion.
+	SourceISO        string
+	InstallPattern   *regexp.Regexp
+	InstallSerial    int
+	InstallWaitAgent bool
+
+	// DiskSize is the virtual disk size in bytes. Required with SourceISO;
+	// with SourceImage, the copy is grown to it if set.
+	DiskSize int64
+
+	// Config is the build VM. Its disks are replaced by the build disk;
+	// CD-ROMs, such as a cloud-init seed, are kept.
+	Config *qemuctl.VMConfig
+
+	// Steps run in order once the guest is up. Each step waits for the
+	// guest to be reachable.
+	Steps []Step
+
+	// OutputDir receives the artifact files.
+	OutputDir string
+
+	// Timeout bounds the whole build. Defaults to two hours.
+	Timeout time.Duration
+
+	// Output receives progress messages and step output.
+	Output io.Writer
+
+	// Start starts the build VM. Defaults to qemuctl.StartVMContext.
+	Start func(ctx context.Context, cfg *qemuctl.VMConfig) (*qemuctl.Instance, error)
+
+	// QemuImgPath overrides the qemu-img binary path.
+	QemuImgPath string
+}
+
+// Artifact describes a built image.
+type Artifact struct {
+	Name        string        `json:"name"`
+	Path        string        `json:"path"`
+	Format      string        `json:"format"`
+	Size        int64         `json:"size"`
+	VirtualSize int64         `json:"virtual_size"`
+	SHA256      string        `json:"sha256"`
+	Source      string        `json:"source"`
+	Steps       []string      `json:"steps,omitempty"`
+	BuiltAt     time.Time     `json:"built_at"`
+	Duration    time.Duration `json:"duration"`
+}
+
+// Run builds the image described by t.
+func Run(ctx context.Context, t *Template) (*Artifact, error) {
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+	name := t.Name
+	if name == "" {
+		name = "image"
+	}
+	timeout := t.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	out := t.Output
+	if out == nil {
+		out = io.Discard
+	}
+	started := time.Now()
+
+	if err := os.MkdirAll(t.OutputDir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+	work, err := os.MkdirTemp(t.OutputDir, "."+name+"-build-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create work directory: %w", err)
+	}
+	defer os.RemoveAll(work)
+
+	disk := filepath.Join(work, "disk.qcow2")
+	if err := t.prepareDisk(ctx, disk); err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(out, "==> booting %s\n", name)
+	inst, err := t.boot(ctx, t.vmConfig(name, disk))
+	if err != nil {
+		return nil, err
+	}
+
+	steps := make([]string, len(t.Steps))
+	for idx, step := range t.Steps {
+		steps[idx] = step.String()
+		fmt.Fprintf(out, "==> step %d/%d: %s\n", idx+1, len(t.Steps), step)
+		if err := step.Run(ctx, inst, out); err != nil {
+			inst.ForceStop()
+			return nil, fmt.Errorf("step %d (%s) failed: %w", idx+1, step, err)
+		}
+	}
+
+	fmt.Fprintf(out, "==> shutting down\n")
+	if err := inst.StopContext(ctx, shutdownTimeout); err != nil {
+		return nil, fmt.Errorf("build VM did not shut down cleanly: %w", err)
+	}
+
+	fmt.Fprintf(out, "==> exporting %s.qcow2\n", name)
+	art, err := t.export(ctx, name, disk)
+	if err != nil {
+		return nil, err
+	}
+	art.Steps = steps
+	art.BuiltAt = started.UTC()
+	art.Duration = time.Since(started)
+
+	if err := writeFile(filepath.Join(t.OutputDir, name+".json"), func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(art)
+	}); err != nil {
+		return nil, err
+	}
+	return art, nil
+}
+
+// validate checks the template.
+func (t *Template) validate() error {
+	switch {
+	case t.Config == nil:
+		return errors.New("build template has no VM configuration")
+	case t.OutputDir == "":
+		return errors.New("build template has no output directory")
+	case (t.SourceImage == "") == (t.SourceISO == ""):
+		return errors.New("build template needs exactly one of SourceImage and SourceISO")
+	case t.SourceISO != "" && t.DiskSize <= 0:
+		return errors.New("ISO builds need a disk size")
+	}
+	return nil
+}
+
+// prepareDisk creates the build disk.
+func (t *Template) prepareDisk(ctx context.Context, disk string) error {
+	if t.SourceISO != "" {
+		return qemuctl.CreateImage(ctx, disk, &qemuctl.CreateImageOptions{
+			Size:        t.DiskSize,
+			QemuImgPath: t.QemuImgPath,
+		})
+	}
+
+	if err := qemuctl.ConvertImage(ctx, t.SourceImage, disk, &qemuctl.ConvertImageOptions{
+		SourceFormat: t.SourceFormat,
+		QemuImgPath:  t.QemuImgPath,
+	}); err != nil {
+		return fmt.Errorf("failed to copy source image: %w", err)
+	}
+	if t.DiskSize > 0 {
+		if err := qemuctl.ResizeImage(ctx, disk, t.DiskSize, &qemuctl.ResizeImageOptions{
+			Format:      "qcow2",
+			QemuImgPath: t.QemuImgPath,
+		}); err != nil {
+			return fmt.Errorf("failed to resize build disk: %w", err)
+		}
+	}
+	return nil
+}
+
+// vmConfig returns the build VM configuration.
+func (t *Template) vmConfig(name, disk string) *qemuctl.VMConfig {
+	cfg := *t.Config
+	if cfg.Name == "" {
+		cfg.Name = name + "-build"
+	}
+	cfg.Disks = []*qemuctl.DiskConfig{{
+		ID:        "build0",
+		Backend:   &qemuctl.FileDiskBackend{Path: disk, Format: "qcow2"},
+		Interface: "virtio",
+		Discard:   "unmap",
+	}}
+	if t.SourceISO != "" {
+		cfg.CDROMs = append([]*qemuctl.CDROMConfig{{Path: t.SourceISO}}, cfg.CDROMs...)
+	}
+	return &cfg
+}
+
+// boot starts the build VM, installing from the ISO if there is one.
+func (t *Template) boot(ctx context.Context, cfg *qemuctl.VMConfig) (*qemuctl.Instance, error) {
+	start := t.Start
+	if start == nil {
+		start = qemuctl.StartVMContext
+	}
+	if t.SourceISO == "" {
+		return start(ctx, cfg)
+	}
+
+	p := &qemuctl.Provisioner{
+		Config:            cfg,
+		CompletionPattern: t.InstallPattern,
+		ConsoleSerial:     t.InstallSerial,
+		WaitAgent:         t.InstallWaitAgent,
+		Timeout:           time.Until(deadline(ctx)),
+		Start:             start,
+	}
+	return p.Run(ctx)
+}
+
+// export writes the compressed artifact and its checksum.
+func (t *Template) export(ctx context.Context, name, disk string) (*Artifact, error) {
+	path := filepath.Join(t.OutputDir, name+".qcow2")
+	tmp := path + ".tmp"
+	if err := qemuctl.ConvertImage(ctx, disk, tmp, &qemuctl.ConvertImageOptions{
+		SourceFormat: "qcow2",
+		Compress:     true,
+		QemuImgPath:  t.QemuImgPath,
+	}); err != nil {
+		os.Remove(tmp)
+		return nil, fmt.Errorf("failed to export image: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+
+	sum, size, err := fileSHA256(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := qemuctl.ImageInfo(ctx, path, &qemuctl.ImageInfoOptions{Format: "qcow2", QemuImgPath: t.QemuImgPath})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeFile(path+".sha256", func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "%s  %s\n", sum, filepath.Base(path))
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	source := t.SourceImage
+	if source == "" {
+		source = t.SourceISO
+	}
+	return &Artifact{
+		Name:        name,
+		Path:        path,
+		Format:      "qcow2",
+		Size:        size,
+		VirtualSize: info.VirtualSize,
+		SHA256:      sum,
+		Source:      source,
+	}, nil
+}
+
+// fileSHA256 returns the hex SHA-256 digest and size of a file.
+func fileSHA256(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// writeFile atomically replaces path with what write produces.
+func writeFile(path string, write func(io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0o644); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// deadline returns the deadline of ctx, which Run always sets.
+func deadline(ctx context.Context) time.Time {
+	d, _ := ctx.Deadline()
+	return d
+}
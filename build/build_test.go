@@ -0,0 +1,178 @@
+package build
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/KarpelesLab/qemuctl"
+	"github.com/KarpelesLab/qemuctl/qmpmock"
+)
+
+// writeScript writes an executable shell script.
+func writeScript(t *testing.T, name, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// recordStep records whether it ran.
+type recordStep struct {
+	ran bool
+	err error
+}
+
+func (s *recordStep) Run(ctx context.Context, inst *qemuctl.Instance, out io.Writer) error {
+	s.ran = true
+	io.WriteString(out, "step output\n")
+	return s.err
+}
+
+func (s *recordStep) String() string { return "record" }
+
+func TestRun(t *testing.T) {
+	qemuImg := writeScript(t, "qemu-img", `
+case "$1" in
+convert) for a; do src=$dst; dst=$a; done; cp "$src" "$dst" ;;
+info) echo '{"virtual-size": 1073741824, "format": "qcow2", "filename": "image.qcow2"}' ;;
+esac
+`)
+	ssh := writeScript(t, "ssh", `for a; do last=$a; done; echo "ran $last"`)
+
+	srv, err := qmpmock.NewServer(filepath.Join(t.TempDir(), "qmp.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	srv.HandleReturn("system_powerdown", map[string]any{})
+
+	source := filepath.Join(t.TempDir(), "cloud.qcow2")
+	os.WriteFile(source, []byte("cloud image"), 0o644)
+
+	var booted *qemuctl.VMConfig
+	var output strings.Builder
+	step := &recordStep{}
+	outDir := t.TempDir()
+	art, err := Run(context.Background(), &Template{
+		Name:        "web",
+		SourceImage: source,
+		Config:      &qemuctl.VMConfig{Name: "builder"},
+		Steps: []Step{
+			step,
+			&SSH{Addr: "127.0.0.1:2222", User: "root", Command: "apt-get install -y nginx", SSHPath: ssh},
+		},
+		OutputDir:   outDir,
+		Output:      &output,
+		QemuImgPath: qemuImg,
+		Timeout:     10 * time.Second,
+		Start: func(ctx context.Context, cfg *qemuctl.VMConfig) (*qemuctl.Instance, error) {
+			booted = cfg
+			return qemuctl.AttachContext(ctx, srv.SocketPath())
+		},
+	})
+	if err != nil {
+		t.Fatalf("Run failed: %v\n%s", err, output.String())
+	}
+
+	if !step.ran || len(srv.CallsTo("system_powerdown")) != 1 {
+		t.Errorf("steps ran = %v, powerdown calls = %d", step.ran, len(srv.CallsTo("system_powerdown")))
+	}
+	if !strings.Contains(output.String(), "ran apt-get install -y nginx") {
+		t.Errorf("ssh output missing:\n%s", output.String())
+	}
+	if len(booted.Disks) != 1 || booted.Disks[0].Backend.(*qemuctl.FileDiskBackend).Path == source {
+		t.Errorf("build VM disks = %+v", booted.Disks)
+	}
+
+	data, err := os.ReadFile(filepath.Join(outDir, "web.qcow2"))
+	if err != nil || string(data) != "cloud image" {
+		t.Fatalf("artifact = %q, %v", data, err)
+	}
+	if art.Path != filepath.Join(outDir, "web.qcow2") || art.VirtualSize != 1<<30 || art.Size != int64(len(data)) || len(art.SHA256) != 64 {
+		t.Errorf("artifact = %+v", art)
+	}
+	sumFile, _ := os.ReadFile(filepath.Join(outDir, "web.qcow2.sha256"))
+	if string(sumFile) != art.SHA256+"  web.qcow2\n" {
+		t.Errorf("checksum file = %q", sumFile)
+	}
+
+	var meta Artifact
+	metaFile, _ := os.ReadFile(filepath.Join(outDir, "web.json"))
+	if err := json.Unmarshal(metaFile, &meta); err != nil || meta.SHA256 != art.SHA256 || len(meta.Steps) != 2 {
+		t.Errorf("metadata = %s (%v)", metaFile, err)
+	}
+
+	entries, _ := os.ReadDir(outDir)
+	if len(entries) != 3 {
+		t.Errorf("output directory has %d entries, want 3", len(entries))
+	}
+}
+
+func TestRunStepFailure(t *testing.T) {
+	qemuImg := writeScript(t, "qemu-img", `for a; do last=$a; done; : > "$last"`)
+	srv, err := qmpmock.NewServer(filepath.Join(t.TempDir(), "qmp.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	outDir := t.TempDir()
+	stepErr := errors.New("boom")
+	_, err = Run(context.Background(), &Template{
+		SourceImage: "cloud.qcow2",
+		Config:      &qemuctl.VMConfig{},
+		Steps:       []Step{&recordStep{err: stepErr}},
+		OutputDir:   outDir,
+		QemuImgPath: qemuImg,
+		Start: func(ctx context.Context, cfg *qemuctl.VMConfig) (*qemuctl.Instance, error) {
+			return qemuctl.AttachContext(ctx, srv.SocketPath())
+		},
+	})
+	if !errors.Is(err, stepErr) {
+		t.Fatalf("Run = %v, want step error", err)
+	}
+	if entries, _ := os.ReadDir(outDir); len(entries) != 0 {
+		t.Errorf("output directory not cleaned up: %v", entries)
+	}
+}
+
+func TestTemplateValidate(t *testing.T) {
+	cfg := &qemuctl.VMConfig{}
+	for _, tmpl := range []*Template{
+		{SourceImage: "a", OutputDir: "out"},
+		{SourceImage: "a", Config: cfg},
+		{Config: cfg, OutputDir: "out"},
+		{SourceImage: "a", SourceISO: "b", Config: cfg, OutputDir: "out"},
+		{SourceISO: "b", Config: cfg, OutputDir: "out"},
+	} {
+		if err := tmpl.validate(); err == nil {
+			t.Errorf("validate(%+v) succeeded", tmpl)
+		}
+	}
+}
+
+func TestSSHArgs(t *testing.T) {
+	s := &SSH{Addr: "127.0.0.1:2222", User: "debian", KeyFile: "/keys/id_ed25519", Command: "uname -a"}
+	args, err := s.args()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(args, " ")
+	if !strings.HasSuffix(got, "-p 2222 -i /keys/id_ed25519 debian@127.0.0.1 uname -a") ||
+		!strings.Contains(got, "StrictHostKeyChecking=no") {
+		t.Errorf("args = %s", got)
+	}
+
+	if _, err := (&SSH{Addr: "no-port"}).args(); err == nil {
+		t.Error("args accepted an address without a port")
+	}
+}
@@ -0,0 +1,244 @@
+package build
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/KarpelesLab/qemuctl"
+)
+
+// pollInterval is how often steps retry reaching the guest and poll
+// running commands.
+var pollInterval = 2 * time.Second
+
+// agentProbeTimeout bounds each guest agent probe.
+const agentProbeTimeout = 5 * time.Second
+
+// Step is a provisioning step run in the build VM.
+type Step interface {
+	// Run runs the step, writing its output to out.
+	Run(ctx context.Context, inst *qemuctl.Instance, out io.Writer) error
+
+	// String describes the step, for progress messages and the artifact
+	// metadata.
+	String() string
+}
+
+// ExitError is returned by steps whose command exited with a non-zero
+// status.
+type ExitError struct {
+	ExitCode int
+	Stderr   string
+}
+
+func (e *ExitError) Error() string {
+	if e.Stderr == "" {
+		return fmt.Sprintf("exit status %d", e.ExitCode)
+	}
+	return fmt.Sprintf("exit status %d: %s", e.ExitCode, e.Stderr)
+}
+
+// GuestExec runs a program in the guest through the guest agent
+// (guest-exec), which the build VM configuration must provide (see
+// qemuctl.VMConfig.WithGuestAgent).
+type GuestExec struct {
+	// Path is the program to run, Args its arguments.
+	Path string
+	Args []string
+
+	// Env holds additional "KEY=value" environment variables.
+	Env []string
+
+	// Input is written to the program's standard input.
+	Input []byte
+}
+
+func (s *GuestExec) String() string {
+	return "guest-exec " + strings.Join(append([]string{s.Path}, s.Args...), " ")
+}
+
+// Run runs the program once the guest agent responds and waits for it to
+// exit.
+func (s *GuestExec) Run(ctx context.Context, inst *qemuctl.Instance, out io.Writer) error {
+	agent := inst.GuestAgent()
+	if agent == nil {
+		return errors.New("no guest agent channel configured")
+	}
+	if err := waitAgent(ctx, agent); err != nil {
+		return err
+	}
+
+	args := map[string]any{
+		"path":           s.Path,
+		"capture-output": true,
+	}
+	if len(s.Args) > 0 {
+		args["arg"] = s.Args
+	}
+	if len(s.Env) > 0 {
+		args["env"] = s.Env
+	}
+	if s.Input != nil {
+		args["input-data"] = base64.StdEncoding.EncodeToString(s.Input)
+	}
+
+	result, err := agent.Execute(ctx, "guest-exec", args)
+	if err != nil {
+		return err
+	}
+	var started struct {
+		PID int `json:"pid"`
+	}
+	if err := json.Unmarshal(result, &started); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		result, err := agent.Execute(ctx, "guest-exec-status", map[string]any{"pid": started.PID})
+		if err != nil {
+			return err
+		}
+		var status struct {
+			Exited   bool   `json:"exited"`
+			ExitCode int    `json:"exitcode"`
+			Signal   int    `json:"signal"`
+			OutData  []byte `json:"out-data"`
+			ErrData  []byte `json:"err-data"`
+		}
+		if err := json.Unmarshal(result, &status); err != nil {
+			return err
+		}
+
+		if status.Exited {
+			out.Write(status.OutData)
+			out.Write(status.ErrData)
+			switch {
+			case status.Signal != 0:
+				return fmt.Errorf("killed by signal %d", status.Signal)
+			case status.ExitCode != 0:
+				return &ExitError{ExitCode: status.ExitCode, Stderr: strings.TrimSpace(string(status.ErrData))}
+			}
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// waitAgent waits for the guest agent to respond.
+func waitAgent(ctx context.Context, agent *qemuctl.GuestAgent) error {
+	for {
+		probeCtx, cancel := context.WithTimeout(ctx, agentProbeTimeout)
+		err := agent.Ping(probeCtx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+
+		select {
+		case <-time.After(pollInterval):
+		case <-ctx.Done():
+			return fmt.Errorf("guest agent not reachable: %w", err)
+		}
+	}
+}
+
+// SSH runs a command in the guest with the ssh client, typically through
+// a user networking port forward. Host keys are not checked, since build
+// VMs generate new ones.
+type SSH struct {
+	// Addr is the host:port to connect to, such as "127.0.0.1:2222".
+	Addr string
+
+	// User is the login user, KeyFile the private key to log in with.
+	User    string
+	KeyFile string
+
+	// Command is the shell command to run.
+	Command string
+
+	// SSHPath overrides the ssh binary. Defaults to "ssh" in PATH.
+	SSHPath string
+}
+
+func (s *SSH) String() string {
+	return "ssh " + s.User + "@" + s.Addr + " " + s.Command
+}
+
+// Run runs the command, retrying while the guest does not accept
+// connections.
+func (s *SSH) Run(ctx context.Context, inst *qemuctl.Instance, out io.Writer) error {
+	args, err := s.args()
+	if err != nil {
+		return err
+	}
+	path := s.SSHPath
+	if path == "" {
+		path = "ssh"
+	}
+
+	for {
+		var stderr strings.Builder
+		cmd := exec.CommandContext(ctx, path, args...)
+		cmd.Stdout = out
+		cmd.Stderr = io.MultiWriter(out, &stderr)
+		err := cmd.Run()
+		if err == nil {
+			return nil
+		}
+
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return fmt.Errorf("failed to run ssh: %w", err)
+		}
+		// ssh exits with 255 when it cannot connect or log in
+		if exitErr.ExitCode() != 255 {
+			return &ExitError{ExitCode: exitErr.ExitCode(), Stderr: strings.TrimSpace(stderr.String())}
+		}
+
+		select {
+		case <-time.After(pollInterval):
+		case <-ctx.Done():
+			return fmt.Errorf("guest not reachable over ssh: %s", strings.TrimSpace(stderr.String()))
+		}
+	}
+}
+
+// args returns the ssh arguments.
+func (s *SSH) args() ([]string, error) {
+	host, port, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ssh address %q: %w", s.Addr, err)
+	}
+
+	args := []string{
+		"-o", "BatchMode=yes",
+		"-o", "StrictHostKeyChecking=no",
+		"-o", "UserKnownHostsFile=/dev/null",
+		"-o", "ConnectTimeout=10",
+		"-o", "LogLevel=ERROR",
+		"-p", port,
+	}
+	if s.KeyFile != "" {
+		args = append(args, "-i", s.KeyFile)
+	}
+	target := host
+	if s.User != "" {
+		target = s.User + "@" + host
+	}
+	return append(args, target, s.Command), nil
+}
@@ -2,110 +2,348 @@ package qemuctl
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
-	"syscall"
 	"time"
 )
 
 // VMConfig is a comprehensive VM configuration.
 type VMConfig struct {
 	// Name is the VM name.
-	Name string
+	Name string `json:"name,omitempty"`
+
+	// UUID is the system UUID the guest sees in SMBIOS (-uuid). QEMU
+	// uses the nil UUID if empty.
+	UUID string `json:"uuid,omitempty"`
 
 	// Arch is the target architecture (GOARCH-style).
-	Arch string
+	Arch string `json:"arch,omitempty"`
 
 	// QemuPath overrides the QEMU binary path.
-	QemuPath string
+	QemuPath string `json:"qemu_path,omitempty"`
 
 	// SocketDir overrides the socket directory.
-	SocketDir string
+	SocketDir string `json:"socket_dir,omitempty"`
 
 	// Machine configures the machine type.
-	Machine *MachineConfig
+	Machine *MachineConfig `json:"machine,omitempty"`
 
 	// CPU configures the virtual CPU.
-	CPU *CPUConfig
+	CPU *CPUConfig `json:"cpu,omitempty"`
 
 	// Memory configures the memory.
-	Memory *MemoryConfig
+	Memory *MemoryConfig `json:"memory,omitempty"`
 
 	// EFI configures UEFI firmware.
-	EFI *EFIConfig
+	EFI *EFIConfig `json:"efi,omitempty"`
 
 	// Boot configures boot options.
-	Boot *BootConfig
+	Boot *BootConfig `json:"boot,omitempty"`
 
 	// Disks is the list of disk configurations.
-	Disks []*DiskConfig
+	Disks []*DiskConfig `json:"disks,omitempty"`
+
+	// IOThreads is the list of I/O threads disks can be assigned to.
+	IOThreads []*IOThreadConfig `json:"iothreads,omitempty"`
+
+	// SCSIControllers is the list of virtio-scsi controllers. A controller
+	// "scsi0" is added for SCSI disks without an address if missing.
+	SCSIControllers []*SCSIControllerConfig `json:"scsi_controllers,omitempty"`
 
 	// CDROMs is the list of CD-ROM drives.
-	CDROMs []*CDROMConfig
+	CDROMs []*CDROMConfig `json:"cdroms,omitempty"`
 
 	// Networks is the list of network configurations.
-	Networks []*NetworkConfig
+	Networks []*NetworkConfig `json:"networks,omitempty"`
 
 	// Display configures display output.
-	Display *DisplayConfig
+	Display *DisplayConfig `json:"display,omitempty"`
 
 	// Audio configures audio device.
-	Audio *AudioConfig
+	Audio *AudioConfig `json:"audio,omitempty"`
 
 	// Serials is the list of serial port configurations.
-	Serials []*SerialConfig
+	Serials []*SerialConfig `json:"serials,omitempty"`
 
 	// Chardevs is the list of character devices.
-	Chardevs []*ChardevConfig
+	Chardevs []*ChardevConfig `json:"chardevs,omitempty"`
 
 	// VirtioSerial configures virtio-serial.
-	VirtioSerial *VirtioSerialConfig
+	VirtioSerial *VirtioSerialConfig `json:"virtio_serial,omitempty"`
 
 	// USB configures USB controller.
-	USB *USBControllerConfig
+	USB *USBControllerConfig `json:"usb,omitempty"`
 
 	// USBDevices is the list of USB devices.
-	USBDevices []*USBDeviceConfig
+	USBDevices []*USBDeviceConfig `json:"usb_devices,omitempty"`
+
+	// USBRedir lets SPICE clients redirect USB devices to the guest.
+	USBRedir *USBRedirConfig `json:"usb_redir,omitempty"`
+
+	// USBHostDevices are host USB devices passed through to the guest.
+	USBHostDevices []*USBHostDeviceConfig `json:"usb_host_devices,omitempty"`
+
+	// Mdevs are mediated devices, such as vGPUs, passed through to the
+	// guest.
+	Mdevs []*MdevConfig `json:"mdevs,omitempty"`
 
 	// Balloon configures memory balloon.
-	Balloon *BalloonConfig
+	Balloon *BalloonConfig `json:"balloon,omitempty"`
+
+	// Panic adds a pvpanic device for guest crash detection.
+	Panic *PanicConfig `json:"panic,omitempty"`
+
+	// Vsock adds a vhost-vsock device for host-guest sockets.
+	Vsock *VsockConfig `json:"vsock,omitempty"`
+
+	// TDX makes the VM an Intel TDX confidential guest.
+	TDX *TDXConfig `json:"tdx,omitempty"`
+
+	// TPM adds an emulated TPM 2.0 backed by swtpm.
+	TPM *TPMConfig `json:"tpm,omitempty"`
 
 	// RTC configures real-time clock.
-	RTC *RTCConfig
+	RTC *RTCConfig `json:"rtc,omitempty"`
 
 	// Secrets is the list of secret objects.
-	Secrets []*SecretConfig
+	Secrets []*SecretConfig `json:"secrets,omitempty"`
+
+	// Chroot makes QEMU chroot into this directory once initialized.
+	// Disk images, CD-ROMs and firmware are passed as pre-opened file
+	// descriptors so they remain usable after the chroot. Requires root
+	// and QEMU 8.1+.
+	Chroot string `json:"chroot,omitempty"`
+
+	// Namespaces configures namespace isolation for the QEMU process (Linux only).
+	Namespaces *NamespaceConfig `json:"namespaces,omitempty"`
+
+	// Cgroup places QEMU in a cgroup v2 with resource limits (Linux only).
+	Cgroup *CgroupConfig `json:"cgroup,omitempty"`
+
+	// RunAsUser starts QEMU as this user (name or UID) rather than root.
+	// Disk images, CD-ROMs, firmware and serial logs are then opened by the
+	// launcher and passed as descriptors, as with Chroot, but the user
+	// needs access to /dev/kvm and to SocketDir for the control socket.
+	// Requires root.
+	RunAsUser string `json:"run_as_user,omitempty"`
+
+	// RunAsGroup is the group (name or GID) QEMU runs as, by default the
+	// primary group of RunAsUser.
+	RunAsGroup string `json:"run_as_group,omitempty"`
+
+	// Sandbox enables QEMU's seccomp filter.
+	Sandbox *SandboxConfig `json:"sandbox,omitempty"`
+
+	// Daemonize detaches QEMU from the managing process, as
+	// Config.Daemonize does, for reattaching with LoadInstance.
+	Daemonize bool `json:"daemonize,omitempty"`
+
+	// OnlyMigratable emits -only-migratable and makes Validate reject
+	// devices that would block live migration, such as host USB passthrough.
+	OnlyMigratable bool `json:"only_migratable,omitempty"`
+
+	// Incoming makes QEMU wait for an incoming migration (-incoming) from
+	// a URI such as "tcp:0:4444", or "defer" to start it later with
+	// migrate-incoming, instead of booting the guest.
+	Incoming string `json:"incoming,omitempty"`
+
+	// AccelFallback decides what StartVM does when the configuration asks
+	// for KVM but /dev/kvm is missing or not accessible, or asks for KVM or
+	// hvf for a guest of another architecture: AccelFallbackAuto (the
+	// default) runs the guest with hvf on macOS and tcg elsewhere, AccelFallbackTCG always
+	// uses tcg and AccelFallbackNone fails with an AccelUnavailableError.
+	// Instance.Accel reports the accelerator chosen.
+	AccelFallback string `json:"accel_fallback,omitempty"`
+
+	// StrictWarnings makes StartVM fail if QEMU prints any warning at
+	// startup, such as a deprecated option notice. Warnings are always
+	// available from Instance.Warnings.
+	StrictWarnings bool `json:"strict_warnings,omitempty"`
+
+	// OutputLog is a file QEMU's stdout and stderr are appended to, for
+	// the lifetime of the process.
+	OutputLog string `json:"output_log,omitempty"`
+
+	// Output also receives QEMU's stdout and stderr, from another
+	// goroutine.
+	Output io.Writer `json:"-"`
+
+	// Hardening enables conservative QEMU behavior (compat policies, W^X).
+	Hardening *HardeningConfig `json:"hardening,omitempty"`
+
+	// Debug enables QEMU's logging and trace events.
+	Debug *DebugConfig `json:"debug,omitempty"`
+
+	// Replay records or replays a deterministic execution.
+	Replay *ReplayConfig `json:"replay,omitempty"`
 
 	// NoDefaults disables QEMU's default devices.
-	NoDefaults bool
+	NoDefaults bool `json:"no_defaults,omitempty"`
 
 	// ExtraArgs are additional command-line arguments.
-	ExtraArgs []string
+	ExtraArgs []string `json:"extra_args,omitempty"`
 }
 
 // RTCConfig configures the real-time clock.
 type RTCConfig struct {
 	// Base is the RTC base ("utc", "localtime", datetime).
-	Base string
+	Base string `json:"base,omitempty"`
 
 	// Clock is the clock source ("host", "rt", "vm").
-	Clock string
+	Clock string `json:"clock,omitempty"`
 
 	// DriftFix is the drift fix mode ("slew", "none").
-	DriftFix string
+	DriftFix string `json:"drift_fix,omitempty"`
+}
+
+// Validate checks the configuration for problems QEMU would only report at
+// startup or, for migration blockers, much later.
+func (cfg *VMConfig) Validate() error {
+	if cfg.UUID != "" && !isUUID(cfg.UUID) {
+		return fmt.Errorf("invalid VM UUID %q", cfg.UUID)
+	}
+	if cfg.Memory != nil && cfg.Memory.Backend != nil {
+		if err := cfg.Memory.Backend.validate(); err != nil {
+			return err
+		}
+	}
+	if cfg.Sandbox != nil {
+		if err := cfg.Sandbox.validate(); err != nil {
+			return err
+		}
+		if err := cfg.checkSpawn(); err != nil {
+			return err
+		}
+	}
+	if err := checkRunAs(cfg.RunAsUser, cfg.RunAsGroup); err != nil {
+		return err
+	}
+	if cfg.RunAsUser != "" && cfg.Namespaces != nil {
+		return fmt.Errorf("namespaces need QEMU to start as root, not %s", cfg.RunAsUser)
+	}
+	if cfg.RunAsUser != "" && cfg.TPM != nil {
+		return fmt.Errorf("TPM is not supported with QEMU running as %s", cfg.RunAsUser)
+	}
+	if cfg.Cgroup != nil {
+		if err := cfg.Cgroup.validate(); err != nil {
+			return err
+		}
+		if strings.Contains(cfg.Name, "/") {
+			return fmt.Errorf("cgroup: VM name %q contains a slash", cfg.Name)
+		}
+	}
+	if cfg.Vsock != nil && cfg.Vsock.CID < vsockMinCID {
+		return fmt.Errorf("vsock CID %d is reserved", cfg.Vsock.CID)
+	}
+	if cfg.TPM != nil && cfg.TPM.StateDir == "" {
+		return fmt.Errorf("TPM requires a state directory")
+	}
+	if cfg.TPM != nil && cfg.TPM.Model == "" && profileFor(cfg.Arch).tpm == "" {
+		return fmt.Errorf("no TPM device model for %s guests", cfg.Arch)
+	}
+	for _, ch := range cfg.Chardevs {
+		if err := ch.validate(); err != nil {
+			return err
+		}
+	}
+	for i, serial := range cfg.Serials {
+		if err := serial.chardev(i).validate(); err != nil {
+			return err
+		}
+		if serial.Log != nil {
+			if err := serial.Log.validate(i); err != nil {
+				return err
+			}
+		}
+	}
+	for _, disk := range cfg.Disks {
+		if err := disk.validate(); err != nil {
+			return err
+		}
+	}
+	if err := cfg.checkIOThreads(); err != nil {
+		return err
+	}
+	if err := cfg.checkSCSI(); err != nil {
+		return err
+	}
+	for _, net := range cfg.Networks {
+		if err := net.validate(); err != nil {
+			return err
+		}
+	}
+	for _, dev := range cfg.USBHostDevices {
+		if err := dev.validate(); err != nil {
+			return err
+		}
+	}
+	if cfg.USBRedir != nil {
+		if err := cfg.checkUSBRedir(); err != nil {
+			return err
+		}
+	}
+	for _, mdev := range cfg.Mdevs {
+		if err := mdev.validate(); err != nil {
+			return err
+		}
+	}
+	if m := cfg.Machine; m != nil && m.VirtioTransport != "" && m.VirtioTransport != VirtioPCI && m.VirtioTransport != VirtioMMIO && m.VirtioTransport != VirtioCCW {
+		return fmt.Errorf("unknown virtio transport %q", m.VirtioTransport)
+	}
+	switch cfg.AccelFallback {
+	case AccelFallbackAuto, AccelFallbackTCG, AccelFallbackNone:
+	default:
+		return fmt.Errorf("unknown accelerator fallback %q", cfg.AccelFallback)
+	}
+	if profileFor(cfg.Arch).withTransport(cfg.Machine).virtioOnCCW() {
+		if profileFor(cfg.Arch) != s390Profile {
+			return fmt.Errorf("the virtio-ccw transport needs an s390x guest")
+		}
+		if err := cfg.checkCCW(); err != nil {
+			return err
+		}
+	}
+	if cfg.EFI != nil && cfg.EFI.SecureBoot {
+		if err := cfg.checkSecureBoot(); err != nil {
+			return err
+		}
+	}
+	if cfg.TDX != nil {
+		if err := cfg.checkTDX(); err != nil {
+			return err
+		}
+	}
+	if cfg.Debug != nil {
+		if err := cfg.Debug.validate(); err != nil {
+			return err
+		}
+	}
+	if cfg.Replay != nil {
+		if err := cfg.checkReplay(); err != nil {
+			return err
+		}
+	}
+	if cfg.onlyMigratable() {
+		if err := cfg.checkMigratable(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // VMBuilder builds QEMU command-line arguments from VMConfig.
 type VMBuilder struct {
-	config   *VMConfig
-	pciAlloc *pciSlotAllocator
-	args     []string
-	isQ35    bool
+	config      *VMConfig
+	profile     *archProfile
+	pciAlloc    *pciSlotAllocator
+	ccwAlloc    *ccwDevnoAllocator
+	args        []string
+	passedFiles []passedFile
+	version     QemuVersion
 }
 
 // NewVMBuilder creates a new VM builder.
@@ -114,29 +352,42 @@ func NewVMBuilder(cfg *VMConfig) *VMBuilder {
 		cfg = &VMConfig{}
 	}
 
-	// Determine if Q35
-	isQ35 := false
-	if cfg.Machine != nil && strings.HasPrefix(cfg.Machine.Type, "q35") {
-		isQ35 = true
-	} else if cfg.Machine == nil && (cfg.Arch == "" || cfg.Arch == "amd64" || cfg.Arch == "386") {
-		// Default to Q35 for x86
-		isQ35 = true
-	}
-
+	profile := profileFor(cfg.Arch).withTransport(cfg.Machine)
 	return &VMBuilder{
 		config:   cfg,
-		pciAlloc: newPCISlotAllocator(isQ35),
-		isQ35:    isQ35,
+		profile:  profile,
+		pciAlloc: newPCISlotAllocator(profile.isPCIe(profile.machineType(cfg.Machine))),
+		ccwAlloc: newCCWDevnoAllocator(),
 	}
 }
 
 // Build builds the complete QEMU command-line arguments.
+//
+// The arguments only depend on the configuration, the QEMU version and the
+// parameters, so that command lines can be compared across runs, for
+// instance with CanonicalArgs in golden-file tests. Options are emitted in
+// groups, always in this order: name and defaults, chroot and hardening,
+// debug logging, machine and firmware, CPU, record/replay, memory, clock, boot, secrets, display, audio,
+// QMP socket, incoming migration, I/O threads, then the devices: CD-ROM and SCSI controllers,
+// disks, CD-ROMs, networks, virtio-serial, serials, chardevs, USB, mediated
+// devices, balloon, panic, vsock, TPM and RNG, and finally ExtraArgs.
+// Within a group, devices are emitted in the order of their configuration
+// slice, which also decides their PCI slots or CCW device numbers.
 func (b *VMBuilder) Build(name, socketPath string) []string {
-	b.args = nil
+	// Each build gets a fresh slice since the previous result belongs to
+	// the caller, but sized up front to avoid regrowing it
+	b.args = make([]string, 0, b.estimateArgs())
+	b.passedFiles = nil
+	b.pciAlloc.reset()
+	b.ccwAlloc.reset()
 
 	// Name
 	if name != "" {
-		b.args = append(b.args, "-name", fmt.Sprintf("guest=%s,debug-threads=on", name))
+		b.args = append(b.args, "-name", "guest="+name+",debug-threads=on")
+	}
+
+	if b.config.UUID != "" {
+		b.args = append(b.args, "-uuid", b.config.UUID)
 	}
 
 	// No defaults
@@ -145,9 +396,15 @@ func (b *VMBuilder) Build(name, socketPath string) []string {
 	}
 
 	// Build in order
+	b.buildChroot()
+	b.buildHardening()
+	b.args = append(b.args, buildSandboxArgs(b.config.Sandbox)...)
+	b.args = append(b.args, buildDebugArgs(b.config.Debug)...)
 	b.buildMachine()
 	b.buildEFI()
+	b.buildTDX()
 	b.buildCPU()
+	b.buildReplay()
 	b.buildMemory()
 	b.buildRTC()
 	b.buildBoot()
@@ -155,42 +412,119 @@ func (b *VMBuilder) Build(name, socketPath string) []string {
 	b.buildDisplay()
 	b.buildAudio()
 	b.buildControlSocket(socketPath)
+	b.buildPIDFile(socketPath)
+	b.buildIncoming()
+	b.buildIOThreads()
 	b.buildSATAController()
+	b.buildSCSIControllers()
 	b.buildDisks()
 	b.buildCDROMs()
 	b.buildNetworks()
 	b.buildVirtioSerial()
-	b.buildSerials()
+	b.buildSerials(socketPath)
 	b.buildChardevs()
 	b.buildUSB()
+	b.buildMdevs()
 	b.buildBalloon()
+	b.buildPanic()
+	b.buildVsock()
+	b.buildTPM(socketPath)
 	b.buildMiscDevices()
 
 	// Extra args
 	b.args = append(b.args, b.config.ExtraArgs...)
 
+	b.args = translateArgs(b.args, b.version)
+
 	return b.args
 }
 
+// SetQemuVersion sets the QEMU version the arguments are built for.
+// Options renamed between releases are then translated for that version.
+// Without a version, arguments target current QEMU releases.
+func (b *VMBuilder) SetQemuVersion(v QemuVersion) {
+	b.version = v
+}
+
+// buildChroot builds the chroot arguments.
+func (b *VMBuilder) buildChroot() {
+	if b.config.Chroot == "" {
+		return
+	}
+	b.args = append(b.args, "-run-with", "chroot="+b.config.Chroot)
+}
+
+// buildIncoming builds the incoming migration argument.
+func (b *VMBuilder) buildIncoming() {
+	if b.config.Incoming != "" {
+		b.args = append(b.args, "-incoming", b.config.Incoming)
+	}
+}
+
+// buildHardening builds hardening arguments.
+func (b *VMBuilder) buildHardening() {
+	h := b.config.Hardening
+	b.args = append(b.args, buildHardeningArgs(h)...)
+	if b.config.OnlyMigratable && (h == nil || !h.OnlyMigratable) {
+		b.args = append(b.args, "-only-migratable")
+	}
+}
+
 // buildMachine builds machine arguments.
 func (b *VMBuilder) buildMachine() {
 	cfg := b.config.Machine
 	if cfg == nil {
 		// Default machine
-		machineType := ""
-		if b.config.Arch == "" || b.config.Arch == "amd64" || b.config.Arch == "386" {
-			machineType = "q35"
-		}
-		if machineType != "" {
-			b.args = append(b.args, "-machine", machineType+",accel=kvm")
+		if machineType := b.profile.machine; machineType != "" {
+			b.args = append(b.args, "-machine", strings.Join(append([]string{machineType, "accel=" + hostAccel()}, b.machineOpts()...), ","))
 		}
 		return
 	}
 
+	// split-wx is a TCG accelerator property, so the accelerator must be
+	// given with -accel instead of -machine accel=
+	if h := b.config.Hardening; h != nil && h.SplitWX && cfg.Accel == "tcg" {
+		machine := *cfg
+		machine.Accel = ""
+		b.args = append(b.args, buildMachineArgs(&machine)...)
+		b.args = append(b.args, "-accel", "tcg,split-wx=on")
+		return
+	}
+
 	args := buildMachineArgs(cfg)
+	if opts := b.machineOpts(); len(opts) > 0 {
+		if len(args) == 0 {
+			args = []string{"-machine", strings.Join(opts, ",")}
+		} else {
+			args[1] += "," + strings.Join(opts, ",")
+		}
+	}
 	b.args = append(b.args, args...)
 }
 
+// machineOpts returns the machine options implied by the rest of the
+// configuration: the EFI flash nodes, SMM for Secure Boot and the options of
+// confidential guests.
+func (b *VMBuilder) machineOpts() []string {
+	var opts []string
+	if efi := b.config.EFI; efi != nil && efi.Code != "" {
+		machine := b.config.Machine
+		if machine == nil || machine.Pflash0 == "" {
+			opts = append(opts, "pflash0=pflash0")
+		}
+		if efi.Vars != "" && (machine == nil || machine.Pflash1 == "") {
+			opts = append(opts, "pflash1=pflash1")
+		}
+		if efi.SecureBoot {
+			opts = append(opts, "smm=on")
+		}
+	}
+	if b.config.TDX != nil {
+		opts = append(opts, "confidential-guest-support=tdx0", "kernel-irqchip=split")
+	}
+	return opts
+}
+
 // buildEFI builds EFI/pflash arguments.
 func (b *VMBuilder) buildEFI() {
 	cfg := b.config.EFI
@@ -201,12 +535,12 @@ func (b *VMBuilder) buildEFI() {
 	// EFI code (pflash0) - read-only
 	codeOpts := map[string]any{
 		"driver":    "file",
-		"filename":  cfg.Code,
+		"filename":  b.filePath(cfg.Code, true),
 		"node-name": "pflash0-file",
 		"read-only": true,
 	}
-	codeJSON, _ := json.Marshal(codeOpts)
-	b.args = append(b.args, "-blockdev", string(codeJSON))
+	codeJSON := blockdevJSON(codeOpts)
+	b.args = append(b.args, "-blockdev", codeJSON)
 
 	codeFormatOpts := map[string]any{
 		"driver":    "raw",
@@ -214,36 +548,69 @@ func (b *VMBuilder) buildEFI() {
 		"node-name": "pflash0",
 		"read-only": true,
 	}
-	codeFormatJSON, _ := json.Marshal(codeFormatOpts)
-	b.args = append(b.args, "-blockdev", string(codeFormatJSON))
+	codeFormatJSON := blockdevJSON(codeFormatOpts)
+	b.args = append(b.args, "-blockdev", codeFormatJSON)
 
 	// EFI vars (pflash1) - read-write
 	if cfg.Vars != "" {
 		varsOpts := map[string]any{
 			"driver":    "file",
-			"filename":  cfg.Vars,
+			"filename":  b.filePath(cfg.Vars, false),
 			"node-name": "pflash1-file",
 		}
-		varsJSON, _ := json.Marshal(varsOpts)
-		b.args = append(b.args, "-blockdev", string(varsJSON))
+		varsJSON := blockdevJSON(varsOpts)
+		b.args = append(b.args, "-blockdev", varsJSON)
 
 		varsFormatOpts := map[string]any{
 			"driver":    "raw",
 			"file":      "pflash1-file",
 			"node-name": "pflash1",
 		}
-		varsFormatJSON, _ := json.Marshal(varsFormatOpts)
-		b.args = append(b.args, "-blockdev", string(varsFormatJSON))
+		varsFormatJSON := blockdevJSON(varsFormatOpts)
+		b.args = append(b.args, "-blockdev", varsFormatJSON)
 	}
 
-	// Update machine config to reference pflash nodes
-	// This is handled in buildMachine via Machine.Pflash0/Pflash1
+	// Only SMM code may write the vars flash of a Secure Boot firmware
+	if cfg.SecureBoot {
+		b.args = append(b.args, "-global", "driver=cfi.pflash01,property=secure,value=on")
+	}
+
+	// The machine references the pflash nodes, see machineOpts
+}
+
+// buildTDX builds the TDX guest object and firmware arguments.
+func (b *VMBuilder) buildTDX() {
+	cfg := b.config.TDX
+	if cfg == nil {
+		return
+	}
+
+	parts := []string{"tdx-guest", "id=tdx0"}
+	if cfg.QuoteGenerationSocket != "" {
+		parts = append(parts, "quote-generation-socket.type=unix",
+			"quote-generation-socket.path="+cfg.QuoteGenerationSocket)
+	}
+	if cfg.SeptVEDisable {
+		parts = append(parts, "sept-ve-disable=on")
+	}
+	if cfg.MrConfigID != "" {
+		parts = append(parts, "mrconfigid="+cfg.MrConfigID)
+	}
+	if cfg.MrOwner != "" {
+		parts = append(parts, "mrowner="+cfg.MrOwner)
+	}
+	if cfg.MrOwnerConfig != "" {
+		parts = append(parts, "mrownerconfig="+cfg.MrOwnerConfig)
+	}
+	b.args = append(b.args, "-object", strings.Join(parts, ","))
+	// -bios is loaded before QEMU chroots, so no descriptor is passed
+	b.args = append(b.args, "-bios", cfg.Firmware)
 }
 
 // buildCPU builds CPU arguments.
 func (b *VMBuilder) buildCPU() {
 	cfg := b.config.CPU
-	memSize := 512
+	memSize := defaultMemorySize
 	if b.config.Memory != nil && b.config.Memory.Size > 0 {
 		memSize = b.config.Memory.Size
 	}
@@ -290,6 +657,12 @@ func (b *VMBuilder) buildMemory() {
 			if cfg.Backend.Share {
 				parts = append(parts, "share=on")
 			}
+			if cfg.Backend.HugePages {
+				parts = append(parts, "hugetlb=on")
+				if cfg.Backend.HugePageSize != 0 {
+					parts = append(parts, fmt.Sprintf("hugetlbsize=%d", cfg.Backend.HugePageSize))
+				}
+			}
 		}
 
 		if len(parts) > 0 {
@@ -337,11 +710,14 @@ func (b *VMBuilder) buildBoot() {
 	}
 
 	// Boot order/menu
-	if cfg.Order != "" || cfg.Menu != nil || cfg.Strict {
+	if cfg.Order != "" || cfg.Once != "" || cfg.Menu != nil || cfg.Strict {
 		var parts []string
 		if cfg.Order != "" {
 			parts = append(parts, "order="+cfg.Order)
 		}
+		if cfg.Once != "" {
+			parts = append(parts, "once="+cfg.Once)
+		}
 		if cfg.Menu != nil {
 			if *cfg.Menu {
 				parts = append(parts, "menu=on")
@@ -367,6 +743,9 @@ func (b *VMBuilder) buildBoot() {
 	if cfg.Append != "" {
 		b.args = append(b.args, "-append", cfg.Append)
 	}
+	if cfg.Firmware != "" {
+		b.args = append(b.args, "-bios", cfg.Firmware)
+	}
 }
 
 // buildSecrets builds secret object arguments.
@@ -485,6 +864,23 @@ func (b *VMBuilder) buildAudio() {
 	}
 }
 
+// estimateArgs returns an upper estimate of the argument count for Build.
+func (b *VMBuilder) estimateArgs() int {
+	cfg := b.config
+	redir := 0
+	if cfg.USBRedir != nil {
+		redir = cfg.USBRedir.channels()
+	}
+	// Fixed options (machine, cpu, memory, display, ...) fit in 64 entries;
+	// disks take up to five option pairs and other devices one or two
+	debug := 0
+	if cfg.Debug != nil {
+		debug = 6 + 2*len(cfg.Debug.Trace)
+	}
+	return 64 + debug + 10*len(cfg.Disks) + 4*len(cfg.Networks) + 4*len(cfg.CDROMs) +
+		4*(len(cfg.Serials)+len(cfg.Chardevs)+len(cfg.USBDevices)+len(cfg.USBHostDevices)+redir) + len(cfg.ExtraArgs)
+}
+
 // buildControlSocket builds QMP control socket arguments.
 func (b *VMBuilder) buildControlSocket(socketPath string) {
 	if socketPath == "" {
@@ -492,10 +888,17 @@ func (b *VMBuilder) buildControlSocket(socketPath string) {
 	}
 
 	b.args = append(b.args, "-chardev",
-		fmt.Sprintf("socket,id=qmp,path=%s,server=on,wait=off", socketPath))
+		"socket,id=qmp,path="+socketPath+",server=on,wait=off")
 	b.args = append(b.args, "-mon", "chardev=qmp,id=monitor,mode=control")
 }
 
+// buildIOThreads builds I/O thread objects.
+func (b *VMBuilder) buildIOThreads() {
+	for _, t := range b.config.IOThreads {
+		b.args = append(b.args, buildIOThreadArgs(t)...)
+	}
+}
+
 // buildSATAController builds SATA controller for CD-ROMs.
 func (b *VMBuilder) buildSATAController() {
 	// Only add SATA controller if we have CD-ROMs
@@ -503,23 +906,29 @@ func (b *VMBuilder) buildSATAController() {
 		return
 	}
 
-	if b.isQ35 {
-		// Q35 has ICH9 AHCI built-in, but we add it explicitly for control
-		b.args = append(b.args, "-device",
-			fmt.Sprintf("ich9-ahci,id=sata0,bus=%s,addr=%s",
-				b.pciAlloc.Bus(), b.pciAlloc.Alloc()))
-	} else {
-		// For i440FX, use PIIX4 IDE or ahci
-		b.args = append(b.args, "-device",
-			fmt.Sprintf("ahci,id=sata0,bus=%s,addr=%s",
-				b.pciAlloc.Bus(), b.pciAlloc.Alloc()))
-	}
+	// Q35 has ICH9 AHCI built-in, but we add it explicitly for control
+	b.args = append(b.args, "-device",
+		fmt.Sprintf("%s,id=sata0,bus=%s,addr=%s",
+			b.profile.ahci, b.pciAlloc.Bus(), b.pciAlloc.Alloc()))
 }
 
 // buildDisks builds disk device arguments.
 func (b *VMBuilder) buildDisks() {
 	for _, disk := range b.config.Disks {
-		args := buildDiskArgs(disk, b.pciAlloc)
+		if file, ok := disk.Backend.(*FileDiskBackend); ok && b.passFiles() {
+			// Use a copy so the caller's config keeps the host path
+			d := *disk
+			f := *file
+			f.Path = b.filePath(file.Path, disk.ReadOnly)
+			d.Backend = &f
+			disk = &d
+		}
+		if b.config.Replay != nil {
+			d := *disk
+			d.replay = true
+			disk = &d
+		}
+		args := buildDiskArgs(disk, b.profile, b.pciAlloc, b.ccwAlloc)
 		b.args = append(b.args, args...)
 	}
 }
@@ -527,6 +936,11 @@ func (b *VMBuilder) buildDisks() {
 // buildCDROMs builds CD-ROM drive arguments.
 func (b *VMBuilder) buildCDROMs() {
 	for i, cdrom := range b.config.CDROMs {
+		if cdrom.Path != "" && b.passFiles() {
+			c := *cdrom
+			c.Path = b.filePath(cdrom.Path, true)
+			cdrom = &c
+		}
 		args := buildCDROMArgs(cdrom, i, "sata0")
 		b.args = append(b.args, args...)
 	}
@@ -535,8 +949,19 @@ func (b *VMBuilder) buildCDROMs() {
 // buildNetworks builds network device arguments.
 func (b *VMBuilder) buildNetworks() {
 	for _, net := range b.config.Networks {
-		args := buildNetworkArgs(net, b.pciAlloc)
+		net = b.passTap(net)
+		args := buildNetworkArgs(net, b.profile, b.pciAlloc, b.ccwAlloc)
 		b.args = append(b.args, args...)
+
+		id := net.netdevID()
+		if net.CaptureFile != "" {
+			b.args = append(b.args, captureArgs(id, net.CaptureFile)...)
+		}
+
+		// Record/replay logs the packets of each netdev
+		if b.config.Replay != nil {
+			b.args = append(b.args, "-object", "filter-replay,id="+id+"-replay,netdev="+id)
+		}
 	}
 }
 
@@ -549,10 +974,15 @@ func (b *VMBuilder) buildVirtioSerial() {
 
 	// Add virtio-serial controller
 	var controllerParts []string
-	controllerParts = append(controllerParts, "virtio-serial-pci")
+	controllerParts = append(controllerParts, b.profile.virtio("virtio-serial"))
 	controllerParts = append(controllerParts, "id=virtio-serial0")
-	controllerParts = append(controllerParts, "bus="+b.pciAlloc.Bus())
-	controllerParts = append(controllerParts, "addr="+b.pciAlloc.Alloc())
+	switch {
+	case b.profile.virtioOnPCI():
+		controllerParts = append(controllerParts, "bus="+b.pciAlloc.Bus())
+		controllerParts = append(controllerParts, "addr="+b.pciAlloc.Alloc())
+	case b.profile.virtioOnCCW():
+		controllerParts = append(controllerParts, "devno="+b.ccwAlloc.Alloc())
+	}
 
 	if cfg != nil && cfg.MaxPorts > 0 {
 		controllerParts = append(controllerParts, fmt.Sprintf("max_ports=%d", cfg.MaxPorts))
@@ -571,6 +1001,9 @@ func (b *VMBuilder) buildVirtioSerial() {
 			var portParts []string
 			portParts = append(portParts, portType)
 			portParts = append(portParts, "bus=virtio-serial0.0")
+			if port.ID != "" {
+				portParts = append(portParts, "id="+port.ID)
+			}
 			if port.Chardev != "" {
 				portParts = append(portParts, "chardev="+port.Chardev)
 			}
@@ -583,32 +1016,23 @@ func (b *VMBuilder) buildVirtioSerial() {
 	}
 }
 
-// buildSerials builds serial port arguments.
-func (b *VMBuilder) buildSerials() {
+// buildSerials builds serial port arguments. Logged ports copy their
+// output to a FIFO next to the QMP socket.
+func (b *VMBuilder) buildSerials(socketPath string) {
 	for i, serial := range b.config.Serials {
-		chardevID := fmt.Sprintf("serial%d", i)
-
-		// Build chardev
-		var chardevParts []string
-		chardevParts = append(chardevParts, serial.Type)
-		chardevParts = append(chardevParts, "id="+chardevID)
-
-		if serial.Path != "" {
-			chardevParts = append(chardevParts, "path="+serial.Path)
-		}
-		if serial.Server {
-			chardevParts = append(chardevParts, "server=on")
-		}
-		if !serial.Wait {
-			chardevParts = append(chardevParts, "wait=off")
+		chardev := serial.chardev(i)
+		chardevID := chardev.ID
+		if serial.Log != nil && socketPath != "" {
+			chardev.LogFile = b.logFilePath(serialLogFIFO(socketPath, i))
 		}
 
-		b.args = append(b.args, "-chardev", strings.Join(chardevParts, ","))
+		// Build chardev
+		b.args = append(b.args, buildChardevArgs(chardev)...)
 
 		// Build device
 		device := serial.Device
 		if device == "" {
-			device = "isa-serial"
+			device = b.profile.serial
 		}
 
 		b.args = append(b.args, "-device",
@@ -627,7 +1051,7 @@ func (b *VMBuilder) buildChardevs() {
 // buildUSB builds USB controller and device arguments.
 func (b *VMBuilder) buildUSB() {
 	cfg := b.config.USB
-	if cfg == nil && len(b.config.USBDevices) == 0 {
+	if cfg == nil && len(b.config.USBDevices) == 0 && len(b.config.USBHostDevices) == 0 && b.config.USBRedir == nil {
 		return
 	}
 
@@ -654,6 +1078,18 @@ func (b *VMBuilder) buildUSB() {
 
 		b.args = append(b.args, "-device", strings.Join(parts, ","))
 	}
+
+	for i, device := range b.config.USBHostDevices {
+		id := device.ID
+		if id == "" {
+			id = fmt.Sprintf("usb-host%d", i)
+		}
+		b.args = append(b.args, "-device", "usb-host,id="+id+",bus=usb0.0"+usbHostDeviceOpts(device))
+	}
+
+	if b.config.USBRedir != nil {
+		b.args = append(b.args, buildUSBRedirArgs(b.config.USBRedir)...)
+	}
 }
 
 // buildBalloon builds memory balloon device arguments.
@@ -664,8 +1100,58 @@ func (b *VMBuilder) buildBalloon() {
 	}
 
 	b.args = append(b.args, "-device",
-		fmt.Sprintf("virtio-balloon-pci,id=balloon0,bus=%s,addr=%s",
-			b.pciAlloc.Bus(), b.pciAlloc.Alloc()))
+		b.profile.virtio("virtio-balloon")+",id=balloon0"+b.virtioAddr())
+}
+
+// buildPanic builds the pvpanic device and panic action.
+func (b *VMBuilder) buildPanic() {
+	cfg := b.config.Panic
+	if cfg == nil {
+		return
+	}
+
+	if cfg.Action != "" {
+		b.args = append(b.args, "-action", "panic="+cfg.Action)
+	}
+
+	switch cfg.Device {
+	case "pvpanic-isa":
+		b.args = append(b.args, "-device", "pvpanic,id=pvpanic0")
+	default:
+		b.args = append(b.args, "-device",
+			"pvpanic-pci,id=pvpanic0,bus="+b.pciAlloc.Bus()+",addr="+b.pciAlloc.Alloc())
+	}
+}
+
+// buildVsock builds the vhost-vsock device.
+func (b *VMBuilder) buildVsock() {
+	cfg := b.config.Vsock
+	if cfg == nil {
+		return
+	}
+
+	b.args = append(b.args, "-device",
+		fmt.Sprintf("%s,id=vsock0,guest-cid=%d%s",
+			b.profile.virtio("vhost-vsock"), cfg.CID, b.virtioAddr()))
+}
+
+// buildTPM builds the TPM arguments, connecting to the swtpm control
+// socket next to the QMP socket.
+func (b *VMBuilder) buildTPM(socketPath string) {
+	cfg := b.config.TPM
+	if cfg == nil {
+		return
+	}
+
+	model := cfg.Model
+	if model == "" {
+		model = b.profile.tpm
+	}
+
+	b.args = append(b.args,
+		"-chardev", "socket,id=chrtpm,path="+swtpmSocketPath(socketPath),
+		"-tpmdev", "emulator,id=tpm0,chardev=chrtpm",
+		"-device", model+",tpmdev=tpm0")
 }
 
 // buildMiscDevices builds miscellaneous devices (RNG, etc.).
@@ -673,8 +1159,19 @@ func (b *VMBuilder) buildMiscDevices() {
 	// Always add virtio-rng for entropy
 	b.args = append(b.args, "-object", "rng-random,id=rng0,filename=/dev/urandom")
 	b.args = append(b.args, "-device",
-		fmt.Sprintf("virtio-rng-pci,rng=rng0,id=rng-dev0,bus=%s,addr=%s",
-			b.pciAlloc.Bus(), b.pciAlloc.Alloc()))
+		b.profile.virtio("virtio-rng")+",rng=rng0,id=rng-dev0"+b.virtioAddr())
+}
+
+// virtioAddr returns the address options of a virtio device, allocating a
+// PCI slot on the PCI transport or a device number on the CCW transport.
+func (b *VMBuilder) virtioAddr() string {
+	switch {
+	case b.profile.virtioOnPCI():
+		return ",bus=" + b.pciAlloc.Bus() + ",addr=" + b.pciAlloc.Alloc()
+	case b.profile.virtioOnCCW():
+		return ",devno=" + b.ccwAlloc.Alloc()
+	}
+	return ""
 }
 
 // ToConfig converts VMConfig to the simpler Config for Start().
@@ -685,6 +1182,12 @@ func (cfg *VMConfig) ToConfig() *Config {
 		QemuPath:  cfg.QemuPath,
 		SocketDir: cfg.SocketDir,
 		ExtraArgs: cfg.ExtraArgs,
+
+		RunAsUser:  cfg.RunAsUser,
+		RunAsGroup: cfg.RunAsGroup,
+		Chroot:     cfg.Chroot,
+		Sandbox:    cfg.Sandbox,
+		Daemonize:  cfg.Daemonize,
 	}
 
 	if cfg.Memory != nil {
@@ -723,11 +1226,28 @@ func StartVM(cfg *VMConfig) (*Instance, error) {
 }
 
 // StartVMContext launches a QEMU instance with context support.
-func StartVMContext(ctx context.Context, cfg *VMConfig) (*Instance, error) {
+func StartVMContext(ctx context.Context, cfg *VMConfig) (inst *Instance, err error) {
+	ctx, span := startSpan(ctx, "qemuctl.start")
+	defer func() { endInstanceSpan(span, inst, err) }()
+
 	if cfg == nil {
 		cfg = DefaultVMConfig()
 	}
 
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	// Fall back from KVM before QEMU fails on it
+	cfg, accel, err := cfg.resolveAccel()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err = cfg.resolveHugePages("/")
+	if err != nil {
+		return nil, err
+	}
+
 	// Generate name if not provided
 	name := cfg.Name
 	if name == "" {
@@ -747,60 +1267,191 @@ func StartVMContext(ctx context.Context, cfg *VMConfig) (*Instance, error) {
 	}
 
 	socketPath := filepath.Join(socketDir, name+".sock")
+	cfg, passtLaunches := cfg.resolvePasst(socketPath)
+	longest := socketPath
+	if cfg.TPM != nil {
+		longest = swtpmSocketPath(socketPath)
+	}
+	for _, b := range passtLaunches {
+		if len(b.Socket) > len(longest) {
+			longest = b.Socket
+		}
+	}
+	if err := checkSocketPath(longest); err != nil {
+		return nil, err
+	}
 
 	// Remove stale socket
 	os.Remove(socketPath)
 
 	// Build command line using VMBuilder
 	builder := NewVMBuilder(cfg)
+	if v, err := DetectQemuVersion(qemuPath); err == nil {
+		builder.SetQemuVersion(v)
+	}
 	args := builder.Build(name, socketPath)
 
+	if cfg.Chroot != "" {
+		if err := os.MkdirAll(cfg.Chroot, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create chroot directory: %w", err)
+		}
+	}
+
+	if cfg.EFI != nil {
+		if err := cfg.EFI.prepareVars(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Serial log FIFOs need a reader before QEMU opens them
+	logs, err := openSerialLogs(cfg, socketPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var tpm *helperProcess
+	if cfg.TPM != nil {
+		tpm, err = startSwtpm(ctx, cfg.TPM, swtpmSocketPath(socketPath))
+		if err != nil {
+			return nil, err
+		}
+	}
+	// swtpm and passt are stopped, and the cgroup removed, with the
+	// instance once QEMU is up
+	var passt []*helperProcess
+	var cgroup string
+	started := false
+	defer func() {
+		if !started && tpm != nil {
+			tpm.stop()
+		}
+		if !started {
+			for _, p := range passt {
+				p.stop()
+			}
+		}
+		if !started {
+			logs.close()
+		}
+		if !started && cgroup != "" {
+			removeCgroup(cgroup)
+		}
+	}()
+
+	for _, b := range passtLaunches {
+		p, err := startPasst(ctx, b)
+		if err != nil {
+			return nil, err
+		}
+		passt = append(passt, p)
+	}
+
+	if cfg.Cgroup != nil {
+		if cgroup, err = createCgroup(cfg.Cgroup, name); err != nil {
+			return nil, err
+		}
+	}
+
+	// Pre-open files that must stay reachable after QEMU chroots
+	files, err := builder.OpenPassedFiles()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
 	// Create and start process
-	cmd := exec.CommandContext(ctx, qemuPath, args...)
-	cmd.Dir = "/"
-	cmd.Stdin = nil
-	cmd.Stdout = nil
-	cmd.Stderr = nil
+	cmd := qemuCommand(ctx, qemuPath, args, cfg.Daemonize)
+	cmd.ExtraFiles = files
 
-	// Set process group so we can kill all children
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Setpgid: true,
+	if err := applyNamespaces(cmd, cfg.Namespaces); err != nil {
+		return nil, fmt.Errorf("failed to set up namespaces: %w", err)
+	}
+	if err := applyRunAs(cmd, cfg.RunAsUser, cfg.RunAsGroup, cfg.Chroot != ""); err != nil {
+		return nil, err
+	}
+	if cgroup != "" {
+		dir, err := applyCgroup(cmd, cgroup)
+		if err != nil {
+			return nil, err
+		}
+		defer dir.Close()
 	}
 
-	if err := cmd.Start(); err != nil {
-		return nil, fmt.Errorf("failed to start QEMU: %w", err)
+	processStart := time.Now()
+	warnings, err := startQemu(cmd, cfg.Daemonize, cfg.OutputLog, cfg.Output)
+	if err != nil {
+		return nil, err
 	}
 
-	inst := &Instance{
+	inst = &Instance{
 		name:       name,
 		process:    cmd.Process,
+		vmConfig:   cfg,
 		socketPath: socketPath,
+		args:       append([]string{qemuPath}, args...),
+		warnings:   warnings,
+		tpm:        tpm,
+		passt:      passt,
+		cgroup:     cgroup,
+		ownCgroup:  cgroup != "",
+		accel:      accel,
 		state:      StatePrelaunch,
+		timings:    BootTimings{ProcessStart: processStart},
 	}
 
 	// Wait for socket to be available
-	if err := waitForSocket(ctx, socketPath, 10*time.Second); err != nil {
+	if err := waitForSocket(ctx, socketPath, 10*time.Second, warnings.exited()); err != nil {
 		cmd.Process.Kill()
-		return nil, fmt.Errorf("QEMU failed to create socket: %w", err)
+		return nil, warnings.startError(fmt.Errorf("QEMU failed to create socket: %w", err))
 	}
 
 	// Connect QMP
 	qmp, err := newQMP(socketPath)
 	if err != nil {
 		cmd.Process.Kill()
-		return nil, fmt.Errorf("failed to connect QMP: %w", err)
+		return nil, warnings.startError(fmt.Errorf("failed to connect QMP: %w", err))
 	}
 
 	inst.qmp = qmp
+	inst.markBoot(func(b *BootTimings) *time.Time { return &b.QMPReady }, time.Now())
+	qmp.addEventHook(inst.recordBootEvent)
+	qmp.addEventHook(inst.recordPanic)
+	qmp.addEventHook(inst.recordIOError)
+	qmp.addEventHook(inst.recordAgentPort)
+	qmp.addEventHook(inst.recordRxFilter)
+	qmp.addEventHook(inst.history.recordEvent)
 	qmp.SetStateChangeCallback(func(s State) {
 		inst.setState(s)
 	})
 
+	// QEMU has parsed its whole command line once QMP is up, so all
+	// startup warnings are in the pipe by now
+	if cfg.StrictWarnings {
+		time.Sleep(stderrSettleTime)
+		if w := inst.Warnings(); len(w) > 0 {
+			qmp.Close()
+			cmd.Process.Kill()
+			return nil, &WarningsError{Warnings: w}
+		}
+	}
+
 	// Query initial state
 	if err := inst.QueryState(); err != nil {
 		// Non-fatal, state will be updated via events
 	}
 
+	if agent := inst.GuestAgent(); agent != nil {
+		go inst.trackGuestBoot(agent, qmp.closeCh)
+	}
+
+	// QEMU has opened the serial log FIFOs along with the QMP socket
+	logs.start()
+
+	started = true
 	return inst, nil
 }
 
@@ -838,8 +1489,9 @@ func (cfg *VMConfig) WithGuestAgent(socketPath string) *VMConfig {
 		cfg.VirtioSerial = &VirtioSerialConfig{}
 	}
 	cfg.VirtioSerial.Ports = append(cfg.VirtioSerial.Ports, VirtioSerialPortConfig{
+		ID:      "qga0-port",
 		Chardev: "qga0",
-		Name:    "org.qemu.guest_agent.0",
+		Name:    guestAgentChannel,
 		Type:    "virtserialport",
 	})
 
@@ -861,7 +1513,7 @@ func (cfg *VMConfig) WithSpiceAgent() *VMConfig {
 	}
 	cfg.VirtioSerial.Ports = append(cfg.VirtioSerial.Ports, VirtioSerialPortConfig{
 		Chardev: "vdagent0",
-		Name:    "com.redhat.spice.0",
+		Name:    spiceAgentChannel,
 		Type:    "virtserialport",
 	})
 
@@ -884,7 +1536,7 @@ func DefaultVMConfig() *VMConfig {
 	return &VMConfig{
 		Machine: &MachineConfig{
 			Type:  "q35",
-			Accel: "kvm",
+			Accel: hostAccel(),
 		},
 		CPU: &CPUConfig{
 			Model:   "host",
@@ -2,6 +2,10 @@ package qemuctl
 
 import (
 	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -189,6 +193,59 @@ func TestStreamNetBackend(t *testing.T) {
 	}
 }
 
+func TestVmnetNetBackends(t *testing.T) {
+	cfg := &VMConfig{
+		Arch: "arm64",
+		Networks: []*NetworkConfig{
+			{ID: "net0", Backend: &VmnetSharedNetBackend{StartAddress: "192.168.105.1", EndAddress: "192.168.105.254", SubnetMask: "255.255.255.0", Isolated: true}},
+			{ID: "net1", Backend: &VmnetBridgedNetBackend{Ifname: "en0"}},
+		},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	args := NewVMBuilder(cfg).Build("test-vm", "")
+	argsStr := strings.Join(args, " ")
+	for _, want := range []string{
+		"-netdev vmnet-shared,id=net0,start-address=192.168.105.1,end-address=192.168.105.254,subnet-mask=255.255.255.0,isolated=on",
+		"-netdev vmnet-bridged,id=net1,ifname=en0",
+	} {
+		if !strings.Contains(argsStr, want) {
+			t.Errorf("expected %q, got: %s", want, argsStr)
+		}
+	}
+
+	parsed, err := ParseArgs(args)
+	if err != nil {
+		t.Fatalf("ParseArgs failed: %v", err)
+	}
+	if b, ok := parsed.Networks[0].Backend.(*VmnetSharedNetBackend); !ok || *b != *cfg.Networks[0].Backend.(*VmnetSharedNetBackend) {
+		t.Errorf("unexpected parsed backend: %#v", parsed.Networks[0].Backend)
+	}
+
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	back, err := ParseVMConfig(data)
+	if err != nil {
+		t.Fatalf("ParseVMConfig failed: %v", err)
+	}
+	if b, ok := back.Networks[1].Backend.(*VmnetBridgedNetBackend); !ok || b.Ifname != "en0" {
+		t.Errorf("unexpected decoded backend: %#v", back.Networks[1].Backend)
+	}
+
+	for _, backend := range []NetworkBackend{
+		&VmnetSharedNetBackend{StartAddress: "192.168.105.1"},
+		&VmnetBridgedNetBackend{},
+	} {
+		if err := (&VMConfig{Networks: []*NetworkConfig{{ID: "net0", Backend: backend}}}).Validate(); err == nil {
+			t.Errorf("Validate accepted %#v", backend)
+		}
+	}
+}
+
 func TestFileDiskBackend(t *testing.T) {
 	backend := &FileDiskBackend{
 		Path:         "/var/lib/qemu/disk.qcow2",
@@ -827,7 +884,7 @@ func TestDefaultVMConfig(t *testing.T) {
 	if cfg.Machine == nil || cfg.Machine.Type != "q35" {
 		t.Error("expected q35 machine")
 	}
-	if cfg.Machine.Accel != "kvm" {
+	if cfg.Machine.Accel != hostAccel() {
 		t.Error("expected kvm accel")
 	}
 	if cfg.CPU == nil || cfg.CPU.Model != "host" {
@@ -891,7 +948,7 @@ func TestBuildNetworkArgs(t *testing.T) {
 		BootIndex: 2,
 	}
 
-	args := buildNetworkArgs(cfg, alloc)
+	args := buildNetworkArgs(cfg, x86Profile, alloc, nil)
 	argsStr := strings.Join(args, " ")
 
 	if !strings.Contains(argsStr, "-netdev") {
@@ -914,6 +971,50 @@ func TestBuildNetworkArgs(t *testing.T) {
 	}
 }
 
+func TestBuildNetworkArgsTuning(t *testing.T) {
+	off, on := false, true
+	cfg := &NetworkConfig{
+		ID:          "net0",
+		Backend:     &TapNetBackend{Ifname: "tap0", Script: "no", DownScript: "no"},
+		MTU:         9000,
+		Offloads:    &NICOffloads{Checksum: &on, GSO: &off, TSO: &off},
+		RxQueueSize: 1024,
+		TxQueueSize: 512,
+	}
+	vm := &VMConfig{Arch: "amd64", Networks: []*NetworkConfig{cfg}}
+	if err := vm.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	args := NewVMBuilder(vm).Build("test-vm", "")
+	want := "host_mtu=9000,csum=on,guest_csum=on,gso=off,host_tso4=off,host_tso6=off,guest_tso4=off,guest_tso6=off,rx_queue_size=1024,tx_queue_size=512"
+	if argsStr := strings.Join(args, " "); !strings.Contains(argsStr, want) {
+		t.Errorf("expected %q, got: %s", want, argsStr)
+	}
+
+	parsed, err := ParseArgs(args)
+	if err != nil {
+		t.Fatalf("ParseArgs failed: %v", err)
+	}
+	got := parsed.Networks[0]
+	if got.MTU != 9000 || got.RxQueueSize != 1024 || got.TxQueueSize != 512 ||
+		got.Offloads == nil || !*got.Offloads.Checksum || *got.Offloads.GSO || *got.Offloads.TSO {
+		t.Errorf("unexpected parsed network: %+v", got)
+	}
+
+	for _, bad := range []*NetworkConfig{
+		{ID: "net0", Model: "e1000", MTU: 9000},
+		{ID: "net0", MTU: 70000},
+		{ID: "net0", RxQueueSize: 300},
+		{ID: "net0", TxQueueSize: 2048},
+		{ID: "net0", Offloads: &NICOffloads{Checksum: &off, TSO: &on}},
+	} {
+		if err := (&VMConfig{Networks: []*NetworkConfig{bad}}).Validate(); err == nil {
+			t.Errorf("Validate accepted %+v", bad)
+		}
+	}
+}
+
 func TestBuildDiskArgs(t *testing.T) {
 	alloc := newPCISlotAllocator(true)
 
@@ -928,7 +1029,7 @@ func TestBuildDiskArgs(t *testing.T) {
 		Serial:    "DISK001",
 	}
 
-	args := buildDiskArgs(cfg, alloc)
+	args := buildDiskArgs(cfg, x86Profile, alloc, nil)
 	argsStr := strings.Join(args, " ")
 
 	if !strings.Contains(argsStr, "-blockdev") {
@@ -948,6 +1049,65 @@ func TestBuildDiskArgs(t *testing.T) {
 	}
 }
 
+func TestBuildDiskArgsIOOptions(t *testing.T) {
+	cfg := &DiskConfig{
+		ID:           "drive0",
+		Backend:      &FileDiskBackend{Path: "/var/lib/qemu/disk.qcow2", Format: "qcow2"},
+		Cache:        "directsync",
+		Discard:      "unmap",
+		AIO:          "native",
+		DetectZeroes: "unmap",
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	args := buildDiskArgs(cfg, x86Profile, nil, nil)
+	var file, format map[string]any
+	if err := json.Unmarshal([]byte(args[1]), &file); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(args[3]), &format); err != nil {
+		t.Fatal(err)
+	}
+	cache := map[string]any{"direct": true, "no-flush": false}
+	if !reflect.DeepEqual(file["cache"], cache) || !reflect.DeepEqual(format["cache"], cache) {
+		t.Errorf("cache options = %v, %v, want %v on both nodes", file["cache"], format["cache"], cache)
+	}
+	if file["aio"] != "native" || format["aio"] != nil {
+		t.Errorf("aio = %v, %v, want native on the file node only", file["aio"], format["aio"])
+	}
+	if file["discard"] != "unmap" || format["discard"] != "unmap" {
+		t.Errorf("discard = %v, %v, want unmap on both nodes", file["discard"], format["discard"])
+	}
+	if format["detect-zeroes"] != "unmap" || file["detect-zeroes"] != nil {
+		t.Errorf("detect-zeroes = %v, %v, want unmap on the format node only", file["detect-zeroes"], format["detect-zeroes"])
+	}
+	if !strings.Contains(args[5], ",write-cache=off") {
+		t.Errorf("device = %s, want write-cache=off", args[5])
+	}
+
+	parsed, err := ParseArgs(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := parsed.Disks[0]
+	if d.Cache != "directsync" || d.Discard != "unmap" || d.AIO != "native" || d.DetectZeroes != "unmap" {
+		t.Errorf("parsed disk = %+v", d)
+	}
+
+	for _, bad := range []*DiskConfig{
+		{ID: "d", Cache: "fast"},
+		{ID: "d", AIO: "native"},
+		{ID: "d", AIO: "posix"},
+		{ID: "d", DetectZeroes: "unmap"},
+	} {
+		if err := bad.validate(); err == nil {
+			t.Errorf("validate() accepted %+v", bad)
+		}
+	}
+}
+
 func TestBuildDiskArgsWithThrottle(t *testing.T) {
 	alloc := newPCISlotAllocator(true)
 
@@ -965,7 +1125,7 @@ func TestBuildDiskArgsWithThrottle(t *testing.T) {
 		},
 	}
 
-	args := buildDiskArgs(cfg, alloc)
+	args := buildDiskArgs(cfg, x86Profile, alloc, nil)
 	argsStr := strings.Join(args, " ")
 
 	// Should have throttle-group object
@@ -1017,3 +1177,373 @@ func TestISCSIDiskBackend(t *testing.T) {
 		t.Error("expected initiator-name")
 	}
 }
+
+func TestVMConfigJSONRoundTrip(t *testing.T) {
+	cfg := DefaultVMConfig()
+	cfg.Name = "test-vm"
+	cfg.Disks = []*DiskConfig{
+		{
+			ID:        "disk0",
+			Backend:   &FileDiskBackend{Path: "/var/lib/qemu/test.qcow2", Format: "qcow2"},
+			BootIndex: 1,
+		},
+		{
+			ID:      "disk1",
+			Backend: &RBDDiskBackend{Pool: "rbd", Image: "vm-disk-1"},
+		},
+	}
+	cfg.Networks = []*NetworkConfig{
+		{
+			ID:      "net0",
+			Backend: &UserNetBackend{Hostfwd: []string{"tcp::2222-:22"}},
+		},
+		{
+			ID:      "net1",
+			Backend: &TapNetBackend{Ifname: "tap0", VHost: true},
+		},
+	}
+
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatalf("Marshal error: %v", err)
+	}
+	if !strings.Contains(string(data), `"type":"file"`) {
+		t.Errorf("expected type-tagged disk backend, got: %s", data)
+	}
+
+	decoded, err := ParseVMConfig(data)
+	if err != nil {
+		t.Fatalf("ParseVMConfig error: %v", err)
+	}
+
+	file, ok := decoded.Disks[0].Backend.(*FileDiskBackend)
+	if !ok {
+		t.Fatalf("expected *FileDiskBackend, got %T", decoded.Disks[0].Backend)
+	}
+	if file.Path != "/var/lib/qemu/test.qcow2" || file.Format != "qcow2" {
+		t.Errorf("unexpected file backend: %+v", file)
+	}
+	if _, ok := decoded.Disks[1].Backend.(*RBDDiskBackend); !ok {
+		t.Errorf("expected *RBDDiskBackend, got %T", decoded.Disks[1].Backend)
+	}
+	if _, ok := decoded.Networks[0].Backend.(*UserNetBackend); !ok {
+		t.Errorf("expected *UserNetBackend, got %T", decoded.Networks[0].Backend)
+	}
+	tap, ok := decoded.Networks[1].Backend.(*TapNetBackend)
+	if !ok || !tap.VHost || tap.Ifname != "tap0" {
+		t.Errorf("unexpected tap backend: %#v", decoded.Networks[1].Backend)
+	}
+
+	// Both configs must produce the same command line
+	want := strings.Join(NewVMBuilder(cfg).Build("test-vm", "/tmp/test.sock"), " ")
+	got := strings.Join(NewVMBuilder(decoded).Build("test-vm", "/tmp/test.sock"), " ")
+	if want != got {
+		t.Errorf("args differ after round trip:\nwant: %s\ngot:  %s", want, got)
+	}
+}
+
+func TestVMConfigJSONUnknownBackend(t *testing.T) {
+	_, err := ParseVMConfig([]byte(`{"disks":[{"id":"disk0","backend":{"type":"floppy"}}]}`))
+	if err == nil {
+		t.Error("expected error for unknown backend type")
+	}
+
+	_, err = ParseVMConfig([]byte(`{"networks":[{"id":"net0","backend":{"ifname":"tap0"}}]}`))
+	if err == nil {
+		t.Error("expected error for backend without type")
+	}
+}
+
+func TestLoadVMConfigInherits(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "profiles"), 0o755)
+	os.WriteFile(filepath.Join(dir, "profiles", "base.json"), []byte(`{
+		"memory": {"size": 1024},
+		"display": {"type": "none"},
+		"disks": [{"id": "disk0", "backend": {"type": "file", "path": "/base.qcow2", "format": "qcow2"}, "boot_index": 1}]
+	}`), 0o644)
+	os.WriteFile(filepath.Join(dir, "profiles", "linux-server.json"), []byte(`{
+		"inherits": "base.json",
+		"cpu": {"cores": 2},
+		"networks": [{"id": "net0", "backend": {"type": "user"}}]
+	}`), 0o644)
+	os.WriteFile(filepath.Join(dir, "vm.json"), []byte(`{
+		"inherits": "profiles/linux-server.json",
+		"name": "web1",
+		"memory": {"size": 4096},
+		"display": null,
+		"disks": [
+			{"id": "disk0", "backend": {"type": "file", "path": "/web1.qcow2", "format": "qcow2"}},
+			{"id": "disk1", "backend": {"type": "file", "path": "/data.raw", "format": "raw"}}
+		]
+	}`), 0o644)
+
+	cfg, err := LoadVMConfig(filepath.Join(dir, "vm.json"))
+	if err != nil {
+		t.Fatalf("LoadVMConfig failed: %v", err)
+	}
+	if cfg.Name != "web1" || cfg.Memory.Size != 4096 || cfg.CPU == nil || cfg.CPU.Cores != 2 || cfg.Display != nil {
+		t.Errorf("unexpected merged config: %+v", cfg)
+	}
+	if len(cfg.Disks) != 2 || len(cfg.Networks) != 1 {
+		t.Fatalf("disks = %d, networks = %d", len(cfg.Disks), len(cfg.Networks))
+	}
+	if file, ok := cfg.Disks[0].Backend.(*FileDiskBackend); !ok || file.Path != "/web1.qcow2" || cfg.Disks[0].BootIndex != 1 {
+		t.Errorf("disk0 not merged: %+v %+v", cfg.Disks[0], cfg.Disks[0].Backend)
+	}
+
+	os.WriteFile(filepath.Join(dir, "profiles", "base.json"), []byte(`{"inherits": "../vm.json"}`), 0o644)
+	if _, err := LoadVMConfig(filepath.Join(dir, "vm.json")); err == nil || !strings.Contains(err.Error(), "inherits from itself") {
+		t.Errorf("expected cycle error, got %v", err)
+	}
+
+	if _, err := ParseVMConfig([]byte(`{"inherits": "base.json"}`)); err == nil {
+		t.Error("ParseVMConfig accepted inherits")
+	}
+}
+
+func TestVMBuilderWithChroot(t *testing.T) {
+	dir := t.TempDir()
+	img := dir + "/disk.qcow2"
+	if err := os.WriteFile(img, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &VMConfig{
+		Name:   "test-vm",
+		Chroot: "/var/empty/qemu",
+		Disks: []*DiskConfig{
+			{ID: "disk0", Backend: &FileDiskBackend{Path: img, Format: "qcow2"}},
+		},
+		CDROMs:     []*CDROMConfig{{Path: img}},
+		NoDefaults: true,
+	}
+
+	builder := NewVMBuilder(cfg)
+	args := builder.Build("test-vm", "/tmp/test.sock")
+	argsStr := strings.Join(args, " ")
+
+	for _, want := range []string{
+		"-run-with chroot=/var/empty/qemu",
+		"-add-fd fd=3,set=0,opaque=rw:" + img,
+		"-add-fd fd=4,set=0,opaque=ro:" + img,
+		"-add-fd fd=5,set=1,opaque=ro:" + img,
+		`"filename":"/dev/fdset/0"`,
+		"file=/dev/fdset/1,format=raw",
+	} {
+		if !strings.Contains(argsStr, want) {
+			t.Errorf("expected args to contain %q, got: %s", want, argsStr)
+		}
+	}
+
+	// The caller's config must keep the host path
+	if cfg.Disks[0].Backend.(*FileDiskBackend).Path != img {
+		t.Error("builder modified the disk backend path")
+	}
+
+	files, err := builder.OpenPassedFiles()
+	if err != nil {
+		t.Fatalf("OpenPassedFiles error: %v", err)
+	}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	if len(files) != 3 {
+		t.Errorf("expected 3 passed files, got %d", len(files))
+	}
+}
+
+func TestVMBuilderWithHardening(t *testing.T) {
+	cfg := &VMConfig{
+		Machine:   &MachineConfig{Type: "q35", Accel: "tcg"},
+		Hardening: StrictHardening(),
+	}
+	cfg.Hardening.OnlyMigratable = true
+	cfg.Hardening.SplitWX = true
+
+	args := NewVMBuilder(cfg).Build("test-vm", "/tmp/test.sock")
+	argsStr := strings.Join(args, " ")
+
+	if !strings.Contains(argsStr, "-compat deprecated-input=reject,deprecated-output=hide,unstable-input=reject,unstable-output=hide") {
+		t.Errorf("expected -compat policies, got: %s", argsStr)
+	}
+	if !strings.Contains(argsStr, "-only-migratable") {
+		t.Errorf("expected -only-migratable, got: %s", argsStr)
+	}
+	if !strings.Contains(argsStr, "-machine q35 ") {
+		t.Errorf("expected machine without accel, got: %s", argsStr)
+	}
+	if !strings.Contains(argsStr, "-accel tcg,split-wx=on") {
+		t.Errorf("expected split-wx accel, got: %s", argsStr)
+	}
+
+	// split-wx is ignored for hardware accelerators
+	cfg.Machine.Accel = "kvm"
+	argsStr = strings.Join(NewVMBuilder(cfg).Build("test-vm", "/tmp/test.sock"), " ")
+	if strings.Contains(argsStr, "split-wx") || !strings.Contains(argsStr, "q35,accel=kvm") {
+		t.Errorf("unexpected args for kvm: %s", argsStr)
+	}
+
+	parsed, err := ParseArgs(args)
+	if err != nil {
+		t.Fatalf("ParseArgs error: %v", err)
+	}
+	if parsed.Hardening == nil || !parsed.Hardening.SplitWX || !parsed.OnlyMigratable ||
+		parsed.Hardening.UnstableInput != "reject" {
+		t.Errorf("unexpected parsed hardening: %+v", parsed.Hardening)
+	}
+}
+
+func TestVMConfigOnlyMigratable(t *testing.T) {
+	cfg := DefaultVMConfig()
+	cfg.OnlyMigratable = true
+	cfg.USBDevices = []*USBDeviceConfig{{Type: "usb-tablet"}}
+
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	argsStr := strings.Join(NewVMBuilder(cfg).Build("test-vm", "/tmp/test.sock"), " ")
+	if strings.Count(argsStr, "-only-migratable") != 1 {
+		t.Errorf("expected a single -only-migratable, got: %s", argsStr)
+	}
+
+	cfg.USBDevices = append(cfg.USBDevices, &USBDeviceConfig{Type: "usb-host"})
+	var nmErr *NotMigratableError
+	if err := cfg.Validate(); !errors.As(err, &nmErr) || nmErr.Device != "usb-host" {
+		t.Errorf("expected NotMigratableError for usb-host, got: %v", err)
+	}
+
+	cfg.USBDevices = nil
+	cfg.ExtraArgs = []string{"-device", "vfio-pci,host=0000:01:00.0"}
+	if err := cfg.Validate(); !errors.As(err, &nmErr) || nmErr.Device != "vfio-pci" {
+		t.Errorf("expected NotMigratableError for vfio-pci, got: %v", err)
+	}
+
+	// Without OnlyMigratable, passthrough is allowed
+	cfg.OnlyMigratable = false
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestVMBuilderQemuVersion(t *testing.T) {
+	cfg := &VMConfig{
+		Chroot: "/var/empty",
+		Chardevs: []*ChardevConfig{
+			{ID: "c0", Backend: "socket", Path: "/tmp/c0.sock", Reconnect: 5},
+		},
+		ExtraArgs: []string{"-chardev", "socket,id=c1,path=/tmp/c1.sock,server,nowait"},
+	}
+
+	tests := []struct {
+		version  QemuVersion
+		contains []string
+		excludes []string
+	}{
+		{
+			// No version: arguments are left as built
+			version:  QemuVersion{},
+			contains: []string{"reconnect=5", "-run-with chroot=/var/empty", "server,nowait"},
+		},
+		{
+			version:  QemuVersion{Major: 6, Minor: 2},
+			contains: []string{"reconnect=5", "-chroot /var/empty", "path=/tmp/c1.sock,server=on,wait=off"},
+			excludes: []string{"-run-with"},
+		},
+		{
+			version:  QemuVersion{Major: 9, Minor: 2},
+			contains: []string{"reconnect-ms=5000", "-run-with chroot=/var/empty"},
+			excludes: []string{"reconnect=5"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version.String(), func(t *testing.T) {
+			b := NewVMBuilder(cfg)
+			b.SetQemuVersion(tt.version)
+			argsStr := strings.Join(b.Build("test-vm", "/tmp/test.sock"), " ")
+
+			for _, s := range tt.contains {
+				if !strings.Contains(argsStr, s) {
+					t.Errorf("expected %q in args: %s", s, argsStr)
+				}
+			}
+			for _, s := range tt.excludes {
+				if strings.Contains(argsStr, s) {
+					t.Errorf("unexpected %q in args: %s", s, argsStr)
+				}
+			}
+		})
+	}
+}
+
+func TestParseQemuVersion(t *testing.T) {
+	v, err := ParseQemuVersion("QEMU emulator version 8.2.2 (Debian 1:8.2.2+ds-0ubuntu1)\nCopyright (c) 2003-2023 Fabrice Bellard")
+	if err != nil {
+		t.Fatalf("ParseQemuVersion error: %v", err)
+	}
+	if v != (QemuVersion{Major: 8, Minor: 2, Micro: 2}) {
+		t.Errorf("unexpected version: %v", v)
+	}
+	if !v.AtLeast(8, 1) || !v.AtLeast(7, 9) || v.AtLeast(8, 3) || v.AtLeast(9, 0) {
+		t.Errorf("unexpected AtLeast results for %v", v)
+	}
+
+	if _, err := ParseQemuVersion("not qemu"); err == nil {
+		t.Error("expected error for invalid version output")
+	}
+}
+
+func TestVMBuilderReuse(t *testing.T) {
+	builder := NewVMBuilder(benchmarkVMConfig())
+	first := builder.Build("bench-vm", "/run/qemu/bench.sock")
+	want := strings.Join(first, " ")
+
+	// PCI slots must be handed out again from the start
+	for i := 0; i < 40; i++ {
+		args := builder.Build("bench-vm", "/run/qemu/bench.sock")
+		if got := strings.Join(args, " "); got != want {
+			t.Fatalf("build %d differs:\n got: %s\nwant: %s", i, got, want)
+		}
+	}
+
+	// Earlier results must not be overwritten by later builds
+	if got := strings.Join(first, " "); got != want {
+		t.Errorf("first result was modified: %s", got)
+	}
+}
+
+// benchmarkVMConfig returns a representative configuration for benchmarks.
+func benchmarkVMConfig() *VMConfig {
+	cfg := DefaultVMConfig()
+	cfg.Name = "bench-vm"
+	cfg.Disks = []*DiskConfig{
+		{ID: "disk0", Backend: &FileDiskBackend{Path: "/var/lib/qemu/bench.qcow2", Format: "qcow2"}, BootIndex: 1},
+		{ID: "disk1", Backend: &RBDDiskBackend{Pool: "rbd", Image: "bench-data"}, Throttle: &ThrottleConfig{Group: "tg0", BPS: 100 << 20, IOPS: 1000}},
+	}
+	cfg.Networks = []*NetworkConfig{
+		{ID: "net0", Backend: &UserNetBackend{Hostfwd: []string{"tcp::2222-:22"}}},
+		{ID: "net1", Backend: &TapNetBackend{Ifname: "tap0", VHost: true}},
+	}
+	cfg.Display = &DisplayConfig{Type: "vnc", VNC: &VNCConfig{Listen: "127.0.0.1:0"}}
+	return cfg.WithGuestAgent("/run/qemu/bench-qga.sock")
+}
+
+func BenchmarkVMBuilderBuild(b *testing.B) {
+	cfg := benchmarkVMConfig()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		NewVMBuilder(cfg).Build("bench-vm", "/run/qemu/bench.sock")
+	}
+}
+
+func BenchmarkVMBuilderBuildReuse(b *testing.B) {
+	builder := NewVMBuilder(benchmarkVMConfig())
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		builder.Build("bench-vm", "/run/qemu/bench.sock")
+	}
+}
@@ -0,0 +1,266 @@
+package qemuctl
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// historySize is how many recent events and state changes an instance
+// keeps for support bundles.
+const historySize = 100
+
+// bundleQueries are the QMP queries whose output goes in support bundles.
+var bundleQueries = []string{"query-version", "query-status", "query-block", "query-cpus-fast"}
+
+// redactedKeys are option and JSON keys whose values are secrets.
+var redactedKeys = map[string]bool{"data": true, "password": true}
+
+// StateChange is a state transition of an instance.
+type StateChange struct {
+	State State
+	Time  time.Time
+}
+
+// instanceHistory keeps the recent events and state changes of an
+// instance.
+type instanceHistory struct {
+	mu     sync.Mutex
+	events []*Event
+	states []StateChange
+}
+
+// recordEvent is an event hook recording every event.
+func (h *instanceHistory) recordEvent(event *Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.events) == historySize {
+		h.events = append(h.events[:0], h.events[1:]...)
+	}
+	h.events = append(h.events, event)
+}
+
+// recordState records a state change.
+func (h *instanceHistory) recordState(s State) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.states) == historySize {
+		h.states = append(h.states[:0], h.states[1:]...)
+	}
+	h.states = append(h.states, StateChange{State: s, Time: time.Now()})
+}
+
+// StateHistory returns the last state changes of the instance, oldest
+// first.
+func (i *Instance) StateHistory() []StateChange {
+	i.history.mu.Lock()
+	defer i.history.mu.Unlock()
+	return append([]StateChange(nil), i.history.states...)
+}
+
+// bundleFile is a file of a support bundle.
+type bundleFile struct {
+	name string
+	data []byte
+}
+
+// SupportBundle collects what is needed to report a problem with the
+// instance into a gzipped tarball in dir, and returns its path. The bundle
+// holds:
+//
+//   - info.json: name, PID, state, boot timings and warnings
+//   - argv.txt: the QEMU command line, one argument per line
+//   - config.json: the VM configuration
+//   - events.json and states.json: the last 100 events and state changes
+//   - qemu.log: the last lines QEMU printed on stderr
+//   - query-version.json, query-status.json, query-block.json and
+//     query-cpus-fast.json, if the monitor is connected
+//   - errors.txt: what could not be collected
+//
+// Secret data and passwords are redacted. The command line and stderr are
+// only known for instances started by this package or attached by PID.
+func (i *Instance) SupportBundle(dir string) (string, error) {
+	now := time.Now()
+	files := i.bundleFiles(now)
+
+	f, err := os.CreateTemp(dir, ".support-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create support bundle: %w", err)
+	}
+	tmp := f.Name()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	prefix := i.name + "-support-" + now.Format("20060102-150405")
+	for _, file := range files {
+		hdr := &tar.Header{
+			Name:    prefix + "/" + file.name,
+			Mode:    0o644,
+			Size:    int64(len(file.data)),
+			ModTime: now,
+		}
+		if err = tw.WriteHeader(hdr); err != nil {
+			break
+		}
+		if _, err = tw.Write(file.data); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("failed to write support bundle: %w", err)
+	}
+
+	path := filepath.Join(dir, prefix+".tar.gz")
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return path, nil
+}
+
+// bundleFiles collects the support bundle contents.
+func (i *Instance) bundleFiles(now time.Time) []bundleFile {
+	var files []bundleFile
+	var errs []string
+	addJSON := func(name string, v any) {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+			return
+		}
+		files = append(files, bundleFile{name, append(redactJSON(data), '\n')})
+	}
+
+	addJSON("info.json", map[string]any{
+		"name":         i.name,
+		"pid":          i.PID(),
+		"socket_path":  i.socketPath,
+		"state":        i.State().String(),
+		"boot_timings": i.BootTimings(),
+		"warnings":     i.Warnings(),
+		"go_version":   runtime.Version(),
+		"collected_at": now,
+	})
+
+	if len(i.args) > 0 {
+		var argv strings.Builder
+		for _, arg := range i.args {
+			argv.WriteString(redactArg(arg))
+			argv.WriteByte('\n')
+		}
+		files = append(files, bundleFile{"argv.txt", []byte(argv.String())})
+	}
+
+	switch {
+	case i.vmConfig != nil:
+		addJSON("config.json", i.vmConfig)
+	case i.config != nil:
+		addJSON("config.json", i.config)
+	}
+
+	i.history.mu.Lock()
+	events := make([]map[string]any, len(i.history.events))
+	for idx, e := range i.history.events {
+		events[idx] = map[string]any{"event": e.Name, "data": e.Data, "timestamp": e.Timestamp}
+	}
+	states := make([]map[string]any, len(i.history.states))
+	for idx, s := range i.history.states {
+		states[idx] = map[string]any{"state": s.State.String(), "time": s.Time}
+	}
+	i.history.mu.Unlock()
+	addJSON("events.json", events)
+	addJSON("states.json", states)
+
+	if lines := i.warnings.tail(); len(lines) > 0 {
+		files = append(files, bundleFile{"qemu.log", []byte(strings.Join(lines, "\n") + "\n")})
+	}
+
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+	if qmp == nil {
+		errs = append(errs, "monitor: "+ErrNotConnected.Error())
+	} else {
+		for _, cmd := range bundleQueries {
+			result, err := qmp.Execute(cmd, nil)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", cmd, err))
+				continue
+			}
+			addJSON(cmd+".json", result)
+		}
+	}
+
+	if len(errs) > 0 {
+		files = append(files, bundleFile{"errors.txt", []byte(strings.Join(errs, "\n") + "\n")})
+	}
+	return files
+}
+
+// redactArg hides secret values in a QEMU option string, such as the data
+// of "-object secret" or a SPICE password.
+func redactArg(arg string) string {
+	parts := strings.Split(arg, ",")
+	for idx, part := range parts {
+		if key, _, ok := strings.Cut(part, "="); ok && redactedKeys[key] {
+			parts[idx] = key + "=<redacted>"
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// redactJSON hides string values of secret keys in a JSON document.
+func redactJSON(data []byte) []byte {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return data
+	}
+	if !redactValue(v) {
+		return data
+	}
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return data
+	}
+	return out
+}
+
+// redactValue redacts v in place and reports whether anything changed.
+func redactValue(v any) bool {
+	changed := false
+	switch v := v.(type) {
+	case map[string]any:
+		for k, val := range v {
+			if _, ok := val.(string); ok && redactedKeys[k] {
+				v[k] = "<redacted>"
+				changed = true
+			} else if redactValue(val) {
+				changed = true
+			}
+		}
+	case []any:
+		for _, val := range v {
+			if redactValue(val) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
@@ -0,0 +1,97 @@
+package qemuctl
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSupportBundle(t *testing.T) {
+	fake := newFakeQMP(t)
+	fake.handle("query-version", func(map[string]any) (any, *qmpError) {
+		return map[string]any{"qemu": map[string]any{"major": 8, "minor": 2, "micro": 0}}, nil
+	})
+	fake.handle("query-block", func(map[string]any) (any, *qmpError) {
+		return []any{map[string]any{"device": "disk0"}}, nil
+	})
+	inst := fake.attach()
+	inst.args = []string{"qemu-system-x86_64", "-object", "secret,id=sec0,data=hunter2", "-name", "guest=vm"}
+	inst.vmConfig = &VMConfig{Name: "vm", Secrets: []*SecretConfig{{ID: "sec0", Data: "hunter2"}}}
+	inst.warnings = &warningCollector{lines: []string{"qemu-system-x86_64: warning: something"}}
+
+	events := inst.QMP().Subscribe("STOP")
+	fake.sendEvent("STOP", nil)
+	select {
+	case <-events.C:
+	case <-time.After(5 * time.Second):
+		t.Fatal("event not received")
+	}
+	events.Unsubscribe()
+	inst.setState(StatePaused)
+
+	bundle, err := inst.SupportBundle(t.TempDir())
+	if err != nil {
+		t.Fatalf("SupportBundle failed: %v", err)
+	}
+
+	f, err := os.Open(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(tr)
+		files[path.Base(hdr.Name)] = string(data)
+	}
+
+	for _, name := range []string{"info.json", "argv.txt", "config.json", "events.json", "states.json", "qemu.log", "query-version.json", "query-block.json", "errors.txt"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("bundle is missing %s", name)
+		}
+	}
+	for name, data := range files {
+		if strings.Contains(data, "hunter2") {
+			t.Errorf("%s leaks the secret:\n%s", name, data)
+		}
+	}
+	if !strings.Contains(files["argv.txt"], "secret,id=sec0,data=<redacted>\n") {
+		t.Errorf("argv.txt = %q", files["argv.txt"])
+	}
+	if !strings.Contains(files["events.json"], `"STOP"`) || !strings.Contains(files["states.json"], `"paused"`) {
+		t.Errorf("history missing: %s %s", files["events.json"], files["states.json"])
+	}
+	// query-status and query-cpus-fast are not handled by the fake
+	if !strings.Contains(files["errors.txt"], "query-cpus-fast") {
+		t.Errorf("errors.txt = %q", files["errors.txt"])
+	}
+}
+
+func TestStateHistory(t *testing.T) {
+	inst := &Instance{}
+	for n := 0; n < historySize+5; n++ {
+		inst.setState(StateRunning)
+		inst.setState(StatePaused)
+	}
+	history := inst.StateHistory()
+	if len(history) != historySize || history[len(history)-1].State != StatePaused {
+		t.Errorf("history has %d entries, last %+v", len(history), history[len(history)-1])
+	}
+}
@@ -0,0 +1,69 @@
+package qemuctl
+
+import (
+	"sort"
+	"strings"
+)
+
+// CanonicalArgs renders a QEMU command line in a canonical text form for
+// golden-file tests: one option per line followed by its value, with the
+// key=value properties of each option string sorted by key. Command lines
+// that only differ in property order render the same, while the order of
+// the options themselves, which QEMU depends on, is kept. A leading QEMU
+// binary path is dropped, since it depends on the host.
+func CanonicalArgs(args []string) string {
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		args = args[1:]
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(args); i++ {
+		opt := args[i]
+		sb.WriteString(opt)
+		if strings.HasPrefix(opt, "-") && !qemuFlagOptions[opt] && !qemuNoValueOptions[opt] && i+1 < len(args) {
+			i++
+			sb.WriteByte(' ')
+			sb.WriteString(canonicalOpts(args[i]))
+		}
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// qemuNoValueOptions lists the options without a value that the builder
+// emits, on top of qemuFlagOptions.
+var qemuNoValueOptions = map[string]bool{
+	"-nodefaults":      true,
+	"-no-user-config":  true,
+	"-only-migratable": true,
+	"-enable-kvm":      true,
+}
+
+// canonicalOpts sorts the properties of a QEMU option string by key. The
+// leading value of options such as "-device virtio-blk-pci,..." stays
+// first, and properties given more than once keep their relative order.
+// JSON values are already encoded with sorted keys and are left alone.
+func canonicalOpts(value string) string {
+	if strings.HasPrefix(value, "{") {
+		return value
+	}
+	parts := splitOpts(value)
+	if len(parts) < 2 {
+		return value
+	}
+
+	props := parts
+	if !strings.Contains(parts[0], "=") {
+		props = parts[1:]
+	}
+	sort.SliceStable(props, func(a, b int) bool {
+		ka, _, _ := strings.Cut(props[a], "=")
+		kb, _, _ := strings.Cut(props[b], "=")
+		return ka < kb
+	})
+
+	for i, p := range parts {
+		parts[i] = strings.ReplaceAll(p, ",", ",,")
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,54 @@
+package qemuctl
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCanonicalArgs(t *testing.T) {
+	got := CanonicalArgs([]string{
+		"/usr/bin/qemu-system-x86_64",
+		"-nodefaults",
+		"-machine", "q35,usb=off,accel=kvm",
+		"-name", "guest=vm,debug-threads=on",
+		"-device", "virtio-net-pci,netdev=net0,mac=52:54:00:00:00:01,id=net0-device",
+		"-netdev", "user,id=net0,hostfwd=tcp::2222-:22,hostfwd=tcp::8080-:80",
+		"-blockdev", `{"driver":"file","node-name":"disk0-file"}`,
+		"-append", "console=ttyS0,,115200",
+		"-S",
+	})
+	want := `-nodefaults
+-machine q35,accel=kvm,usb=off
+-name debug-threads=on,guest=vm
+-device virtio-net-pci,id=net0-device,mac=52:54:00:00:00:01,netdev=net0
+-netdev user,hostfwd=tcp::2222-:22,hostfwd=tcp::8080-:80,id=net0
+-blockdev {"driver":"file","node-name":"disk0-file"}
+-append console=ttyS0,,115200
+-S
+`
+	if got != want {
+		t.Errorf("CanonicalArgs =\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestVMBuilderDeterministic(t *testing.T) {
+	cfg := DefaultVMConfig()
+	cfg.Disks = []*DiskConfig{
+		{ID: "disk1", Backend: &FileDiskBackend{Path: "/data.raw", Format: "raw"}},
+		{ID: "disk0", Backend: &FileDiskBackend{Path: "/system.qcow2", Format: "qcow2"}, BootIndex: 1},
+	}
+	cfg.Networks = []*NetworkConfig{{ID: "net0", Backend: &UserNetBackend{}}}
+	cfg.WithGuestAgent("/run/qga.sock")
+
+	first := CanonicalArgs(NewVMBuilder(cfg).Build("vm", "/run/vm.sock"))
+	for i := 0; i < 10; i++ {
+		if got := CanonicalArgs(NewVMBuilder(cfg).Build("vm", "/run/vm.sock")); got != first {
+			t.Fatalf("command line changed between builds:\n%s\nthen:\n%s", first, got)
+		}
+	}
+
+	// Disks keep their configuration order and slots
+	if d1, d0 := strings.Index(first, "disk1-device"), strings.Index(first, "disk0-device"); d1 < 0 || d0 < d1 {
+		t.Errorf("disks out of order:\n%s", first)
+	}
+}
@@ -0,0 +1,248 @@
+package qemuctl
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Capabilities describes what the QEMU process of an instance supports:
+// QMP commands and their arguments, events, machine types and devices. It
+// lets features that depend on the QEMU release degrade gracefully instead
+// of failing with CommandNotFound or an unknown property.
+type Capabilities struct {
+	// Version is the QEMU version.
+	Version QemuVersion
+
+	commands map[string]schemaCommand
+	events   map[string]bool
+	machines map[string]bool
+	devices  map[string]bool
+
+	// Device properties are probed on first use, one device at a time
+	qmp     *QMP
+	props   map[string]map[string]bool
+	propsMu sync.Mutex
+}
+
+// schemaCommand is a command of the QMP schema.
+type schemaCommand struct {
+	args       map[string]bool
+	deprecated bool
+}
+
+// HasCommand reports whether QEMU has the QMP command.
+func (c *Capabilities) HasCommand(name string) bool {
+	_, ok := c.commands[name]
+	return ok
+}
+
+// HasCommandArg reports whether the QMP command takes the argument. Commands
+// taking a union only report the common members.
+func (c *Capabilities) HasCommandArg(command, arg string) bool {
+	return c.commands[command].args[arg]
+}
+
+// CommandDeprecated reports whether the QMP command is deprecated and may go
+// away in a later release.
+func (c *Capabilities) CommandDeprecated(name string) bool {
+	return c.commands[name].deprecated
+}
+
+// HasEvent reports whether QEMU can send the QMP event.
+func (c *Capabilities) HasEvent(name string) bool {
+	return c.events[name]
+}
+
+// HasMachine reports whether QEMU has the machine type, by name or alias
+// such as "q35".
+func (c *Capabilities) HasMachine(name string) bool {
+	return c.machines[name]
+}
+
+// HasDevice reports whether QEMU has the device type, e.g. "virtio-blk-pci".
+func (c *Capabilities) HasDevice(driver string) bool {
+	return c.devices[driver]
+}
+
+// HasDeviceProperty reports whether the device type has the property, e.g.
+// "host_mtu" on "virtio-net-pci". Properties are queried from QEMU the first
+// time a device is asked about, and reported missing if that fails.
+func (c *Capabilities) HasDeviceProperty(driver, prop string) bool {
+	c.propsMu.Lock()
+	defer c.propsMu.Unlock()
+
+	props, ok := c.props[driver]
+	if !ok {
+		props = c.deviceProperties(driver)
+		if c.props == nil {
+			c.props = make(map[string]map[string]bool)
+		}
+		c.props[driver] = props
+	}
+	return props[prop]
+}
+
+// deviceProperties lists the properties of a device type, nil if QEMU does
+// not have it.
+func (c *Capabilities) deviceProperties(driver string) map[string]bool {
+	if !c.devices[driver] || c.qmp == nil {
+		return nil
+	}
+	result, err := c.qmp.Execute("device-list-properties", map[string]any{"typename": driver})
+	if err != nil {
+		return nil
+	}
+	var list []struct {
+		Name string `json:"name"`
+	}
+	if err := unmarshalJSON(result, &list); err != nil {
+		return nil
+	}
+	props := make(map[string]bool, len(list))
+	for _, p := range list {
+		props[p.Name] = true
+	}
+	return props
+}
+
+// Capabilities introspects the running QEMU with query-qmp-schema,
+// query-machines and qom-list-types. The result is cached for the life of
+// the instance; a failed probe is retried on the next call.
+func (i *Instance) Capabilities() (*Capabilities, error) {
+	i.capsMu.Lock()
+	defer i.capsMu.Unlock()
+	if i.caps != nil {
+		return i.caps, nil
+	}
+
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return nil, ErrNotConnected
+	}
+
+	caps, err := probeCapabilities(qmp)
+	if err != nil {
+		return nil, err
+	}
+	i.caps = caps
+	return caps, nil
+}
+
+// probeCapabilities queries the capabilities of the QEMU behind qmp.
+func probeCapabilities(qmp *QMP) (*Capabilities, error) {
+	caps := &Capabilities{
+		commands: make(map[string]schemaCommand),
+		events:   make(map[string]bool),
+		machines: make(map[string]bool),
+		devices:  make(map[string]bool),
+		qmp:      qmp,
+	}
+
+	caps.Version = qmp.Version()
+	if caps.Version.IsZero() {
+		result, err := qmp.Execute("query-version", nil)
+		if err != nil {
+			return nil, err
+		}
+		var version struct {
+			QEMU    QemuVersion `json:"qemu"`
+			Package string      `json:"package"`
+		}
+		if err := unmarshalJSON(result, &version); err != nil {
+			return nil, err
+		}
+		caps.Version = version.QEMU
+		caps.Version.Package = strings.TrimSpace(version.Package)
+	}
+
+	result, err := qmp.ExecuteWithTimeout("query-qmp-schema", nil, largeResponseTimeout)
+	if err != nil {
+		return nil, err
+	}
+	var schema []QMPSchemaEntry
+	if err := unmarshalJSON(result, &schema); err != nil {
+		return nil, err
+	}
+	caps.addSchema(schema)
+
+	result, err = qmp.Execute("query-machines", nil)
+	if err != nil {
+		return nil, err
+	}
+	var machines []struct {
+		Name  string `json:"name"`
+		Alias string `json:"alias,omitempty"`
+	}
+	if err := unmarshalJSON(result, &machines); err != nil {
+		return nil, err
+	}
+	for _, m := range machines {
+		caps.machines[m.Name] = true
+		if m.Alias != "" {
+			caps.machines[m.Alias] = true
+		}
+	}
+
+	result, err = qmp.Execute("qom-list-types", map[string]any{"implements": "device", "abstract": false})
+	if err != nil {
+		return nil, err
+	}
+	var types []struct {
+		Name string `json:"name"`
+	}
+	if err := unmarshalJSON(result, &types); err != nil {
+		return nil, err
+	}
+	for _, t := range types {
+		caps.devices[t.Name] = true
+	}
+
+	return caps, nil
+}
+
+// addSchema records the commands and events of a QMP schema. Command
+// arguments are the members of the object named by their arg-type.
+func (c *Capabilities) addSchema(schema []QMPSchemaEntry) {
+	objects := make(map[string]*QMPSchemaEntry)
+	for i := range schema {
+		if schema[i].MetaType == "object" {
+			objects[schema[i].Name] = &schema[i]
+		}
+	}
+
+	for _, e := range schema {
+		switch e.MetaType {
+		case "command":
+			cmd := schemaCommand{args: make(map[string]bool)}
+			if obj := objects[e.ArgType]; obj != nil {
+				for _, m := range obj.Members {
+					cmd.args[m.Name] = true
+				}
+			}
+			for _, f := range e.Features {
+				if f == "deprecated" {
+					cmd.deprecated = true
+				}
+			}
+			c.commands[e.Name] = cmd
+		case "event":
+			c.events[e.Name] = true
+		}
+	}
+}
+
+// requireCommand returns an error wrapping errors.ErrUnsupported if QEMU
+// lacks the QMP command. The command is assumed to exist if the
+// capabilities cannot be probed.
+func (i *Instance) requireCommand(command string) error {
+	caps, err := i.Capabilities()
+	if err != nil || caps.HasCommand(command) {
+		return nil
+	}
+	return fmt.Errorf("QEMU %s has no %s command: %w", caps.Version, command, errors.ErrUnsupported)
+}
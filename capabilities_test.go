@@ -0,0 +1,81 @@
+package qemuctl
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCapabilities(t *testing.T) {
+	fake := newFakeQMP(t)
+	fake.SetVersion(6, 2, 0)
+	fake.handle("query-qmp-schema", func(map[string]any) (any, *qmpError) {
+		return []map[string]any{
+			{"name": "query-status", "meta-type": "command", "arg-type": "0", "ret-type": "1"},
+			{"name": "blockdev-add", "meta-type": "command", "arg-type": "2", "ret-type": "0"},
+			{"name": "x-old", "meta-type": "command", "arg-type": "0", "ret-type": "0", "features": []string{"deprecated"}},
+			{"name": "BLOCK_IO_ERROR", "meta-type": "event", "arg-type": "3"},
+			{"name": "0", "meta-type": "object", "members": []any{}},
+			{"name": "2", "meta-type": "object", "members": []map[string]any{{"name": "driver", "type": "str"}, {"name": "node-name", "type": "str"}}},
+		}, nil
+	})
+	fake.handle("query-machines", func(map[string]any) (any, *qmpError) {
+		return []map[string]any{{"name": "pc-q35-6.2", "alias": "q35"}, {"name": "none"}}, nil
+	})
+	fake.handle("qom-list-types", func(args map[string]any) (any, *qmpError) {
+		if args["implements"] != "device" {
+			t.Errorf("qom-list-types arguments = %v", args)
+		}
+		return []map[string]any{{"name": "virtio-net-pci"}, {"name": "nvme"}}, nil
+	})
+	fake.handle("device-list-properties", func(args map[string]any) (any, *qmpError) {
+		return []map[string]any{{"name": "mq", "type": "bool"}, {"name": "host_mtu", "type": "uint16"}}, nil
+	})
+	inst := fake.attach()
+
+	caps, err := inst.Capabilities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if caps.Version != (QemuVersion{Major: 6, Minor: 2}) {
+		t.Errorf("Version = %v, want 6.2.0", caps.Version)
+	}
+	if !caps.HasCommand("blockdev-add") || caps.HasCommand("query-stats") {
+		t.Error("HasCommand reports the wrong commands")
+	}
+	if !caps.HasCommandArg("blockdev-add", "node-name") || caps.HasCommandArg("blockdev-add", "nope") {
+		t.Error("HasCommandArg reports the wrong arguments")
+	}
+	if !caps.CommandDeprecated("x-old") || caps.CommandDeprecated("query-status") {
+		t.Error("CommandDeprecated reports the wrong commands")
+	}
+	if !caps.HasEvent("BLOCK_IO_ERROR") || caps.HasEvent("query-status") {
+		t.Error("HasEvent reports the wrong events")
+	}
+	if !caps.HasMachine("q35") || !caps.HasMachine("pc-q35-6.2") || caps.HasMachine("virt") {
+		t.Error("HasMachine reports the wrong machines")
+	}
+	if !caps.HasDevice("nvme") || caps.HasDevice("virtio-blk-pci") {
+		t.Error("HasDevice reports the wrong devices")
+	}
+	if !caps.HasDeviceProperty("virtio-net-pci", "host_mtu") || caps.HasDeviceProperty("virtio-net-pci", "rss") {
+		t.Error("HasDeviceProperty reports the wrong properties")
+	}
+	if caps.HasDeviceProperty("virtio-blk-pci", "mq") {
+		t.Error("HasDeviceProperty reports properties of a missing device")
+	}
+	if n := len(fake.commands("device-list-properties")); n != 1 {
+		t.Errorf("device-list-properties sent %d times, want 1", n)
+	}
+
+	if again, _ := inst.Capabilities(); again != caps {
+		t.Error("Capabilities() probed QEMU again")
+	}
+
+	// QEMU 6.2 predates query-stats
+	if _, err := inst.QueryStats(StatsTargetVM); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("QueryStats() error = %v, want ErrUnsupported", err)
+	}
+	if n := len(fake.commands("query-stats")); n != 0 {
+		t.Errorf("query-stats sent %d times to a QEMU without it", n)
+	}
+}
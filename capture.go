@@ -0,0 +1,59 @@
+package qemuctl
+
+import (
+	"fmt"
+	"strings"
+)
+
+// captureID returns the ID of the filter-dump object capturing the
+// traffic of the netdev with ID netdev.
+func captureID(netdev string) string {
+	return netdev + "-dump"
+}
+
+// captureArgs returns the -object arguments capturing the traffic of a
+// netdev to file.
+func captureArgs(netdev, file string) []string {
+	return []string{"-object", "filter-dump,id=" + captureID(netdev) + ",netdev=" + netdev + ",file=" + strings.ReplaceAll(file, ",", ",,")}
+}
+
+// StartCapture starts writing the packets of the netdev with ID netdev,
+// in both directions, to file in pcap format, as NetworkConfig.CaptureFile
+// does from the start. The file is opened by QEMU and truncated.
+func (i *Instance) StartCapture(netdev, file string) error {
+	if netdev == "" || file == "" {
+		return fmt.Errorf("packet capture needs a netdev and a file")
+	}
+
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return ErrNotConnected
+	}
+
+	_, err := qmp.Execute("object-add", map[string]any{
+		"qom-type": "filter-dump",
+		"id":       captureID(netdev),
+		"netdev":   netdev,
+		"file":     file,
+	})
+	return err
+}
+
+// StopCapture stops the packet capture of the netdev with ID netdev,
+// started by StartCapture or NetworkConfig.CaptureFile, and closes the
+// file.
+func (i *Instance) StopCapture(netdev string) error {
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return ErrNotConnected
+	}
+
+	_, err := qmp.Execute("object-del", map[string]any{"id": captureID(netdev)})
+	return err
+}
@@ -0,0 +1,58 @@
+package qemuctl
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNetworkCaptureFile(t *testing.T) {
+	cfg := DefaultVMConfig()
+	cfg.Networks = []*NetworkConfig{{Backend: &UserNetBackend{}, CaptureFile: "/tmp/vm,1.pcap"}}
+
+	args := NewVMBuilder(cfg).Build("vm", "/run/qemu/vm.sock")
+	want := "-object filter-dump,id=net0-dump,netdev=net0,file=/tmp/vm,,1.pcap"
+	if !strings.Contains(strings.Join(args, " "), want) {
+		t.Errorf("expected %q in: %s", want, strings.Join(args, " "))
+	}
+
+	parsed, err := ParseArgs(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed.Networks) != 1 || parsed.Networks[0].CaptureFile != "/tmp/vm,1.pcap" {
+		t.Errorf("parsed networks = %+v", parsed.Networks)
+	}
+	for n, arg := range parsed.ExtraArgs {
+		if strings.Contains(arg, "filter-dump") {
+			t.Errorf("filter-dump left in extra args: %q", parsed.ExtraArgs[n])
+		}
+	}
+}
+
+func TestCapture(t *testing.T) {
+	fake := newFakeQMP(t)
+	for _, cmd := range []string{"object-add", "object-del"} {
+		fake.handle(cmd, func(map[string]any) (any, *qmpError) { return map[string]any{}, nil })
+	}
+	inst := fake.attach()
+
+	if err := inst.StartCapture("net0", "/tmp/net0.pcap"); err != nil {
+		t.Fatalf("StartCapture failed: %v", err)
+	}
+	if err := inst.StopCapture("net0"); err != nil {
+		t.Fatalf("StopCapture failed: %v", err)
+	}
+	if err := inst.StartCapture("net0", ""); err == nil {
+		t.Error("StartCapture succeeded without a file")
+	}
+
+	want := []map[string]any{{"qom-type": "filter-dump", "id": "net0-dump", "netdev": "net0", "file": "/tmp/net0.pcap"}}
+	if got := fake.commands("object-add"); !reflect.DeepEqual(got, want) {
+		t.Errorf("object-add = %v, want %v", got, want)
+	}
+	want = []map[string]any{{"id": "net0-dump"}}
+	if got := fake.commands("object-del"); !reflect.DeepEqual(got, want) {
+		t.Errorf("object-del = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,58 @@
+package qemuctl
+
+import "fmt"
+
+// virtioCCWSubchannelSet is the channel subsystem image of virtio-ccw
+// devices. QEMU reserves 0xfe for them.
+const virtioCCWSubchannelSet = "fe.0"
+
+// ccwDevnoAllocator assigns device numbers to virtio-ccw devices, which sit
+// on the channel subsystem instead of a PCI bus.
+type ccwDevnoAllocator struct {
+	next int
+}
+
+// newCCWDevnoAllocator creates a new CCW device number allocator.
+func newCCWDevnoAllocator() *ccwDevnoAllocator {
+	return &ccwDevnoAllocator{}
+}
+
+// reset releases all device numbers.
+func (a *ccwDevnoAllocator) reset() {
+	a.next = 0
+}
+
+// Alloc returns the next device number, such as "fe.0.0001". The first
+// device gets fe.0.0000, the number s390 boot loaders look at first.
+func (a *ccwDevnoAllocator) Alloc() string {
+	if a.next > 0xffff {
+		panic("ran out of CCW device numbers")
+	}
+	devno := fmt.Sprintf("%s.%04x", virtioCCWSubchannelSet, a.next)
+	a.next++
+	return devno
+}
+
+// checkCCW checks that the devices of a configuration exist on the
+// virtio-ccw transport. Guests there have no PCI slots for emulated
+// controllers, so disks and NICs must be virtio and CD-ROMs, which attach
+// to an AHCI controller, are not available.
+func (cfg *VMConfig) checkCCW() error {
+	if len(cfg.CDROMs) > 0 {
+		return fmt.Errorf("CD-ROMs are not supported on the virtio-ccw transport; attach the image as a read-only disk")
+	}
+	for _, disk := range cfg.Disks {
+		if disk.Interface != "" && disk.Interface != "virtio" && disk.Interface != "scsi" {
+			return fmt.Errorf("disk %s: interface %s is not supported on the virtio-ccw transport", disk.ID, disk.Interface)
+		}
+	}
+	for _, net := range cfg.Networks {
+		if net.Model != "" && net.Model != "virtio-net-ccw" {
+			return fmt.Errorf("network %s: model %s is not supported on the virtio-ccw transport", net.ID, net.Model)
+		}
+	}
+	if cfg.Panic != nil {
+		return fmt.Errorf("pvpanic is not available on the virtio-ccw transport; s390 guests report panics to QEMU directly")
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package qemuctl
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// defaultCgroupParent is the cgroup VM cgroups are created in by default.
+const defaultCgroupParent = "qemuctl"
+
+// cgroupPeriod is the cpu.max period in microseconds.
+const cgroupPeriod = 100000
+
+// CgroupConfig places QEMU in a cgroup v2 of its own, created when the VM
+// starts and removed when it stops, so a runaway guest cannot starve the
+// host. The limits apply to the whole QEMU process, including its I/O and
+// vCPU threads and its memory overhead beyond guest RAM. Creating cgroups
+// requires root or a delegated subtree. Linux only, kernel 5.7+.
+type CgroupConfig struct {
+	// Parent is the cgroup the VM cgroup is created in, named after the
+	// VM, relative to the root of the hierarchy ("qemuctl" if empty). It is
+	// created if needed, and the controllers the limits need are enabled
+	// in it and its ancestors.
+	Parent string `json:"parent,omitempty"`
+
+	// CPUMax is the CPU time the VM may use, in CPUs, such as 1.5 for one
+	// and a half (cpu.max). Zero is unlimited.
+	CPUMax float64 `json:"cpu_max,omitempty"`
+
+	// MemoryMax is the memory limit in megabytes (memory.max), above which
+	// the kernel reclaims memory and then kills QEMU. It must leave room
+	// for QEMU itself beyond guest memory. Zero is unlimited.
+	MemoryMax int `json:"memory_max,omitempty"`
+
+	// IOWeight is the relative I/O weight, from 1 to 10000 (io.weight).
+	// Zero keeps the default of 100.
+	IOWeight int `json:"io_weight,omitempty"`
+}
+
+// validate checks the limits and the parent path.
+func (cfg *CgroupConfig) validate() error {
+	switch {
+	case cfg.CPUMax < 0 || cfg.MemoryMax < 0:
+		return fmt.Errorf("cgroup: negative limit")
+	case cfg.IOWeight < 0 || cfg.IOWeight > 10000:
+		return fmt.Errorf("cgroup: I/O weight %d out of range 1-10000", cfg.IOWeight)
+	case cfg.Parent != "" && (path.IsAbs(cfg.Parent) || path.Clean(cfg.Parent) != cfg.Parent || strings.HasPrefix(cfg.Parent, "..")):
+		return fmt.Errorf("cgroup: parent %q must be a clean relative path", cfg.Parent)
+	}
+	return nil
+}
+
+// parent returns the parent cgroup, relative to the hierarchy root.
+func (cfg *CgroupConfig) parent() string {
+	if cfg.Parent == "" {
+		return defaultCgroupParent
+	}
+	return cfg.Parent
+}
+
+// controllers returns the controllers the limits need.
+func (cfg *CgroupConfig) controllers() []string {
+	var controllers []string
+	if cfg.CPUMax > 0 {
+		controllers = append(controllers, "cpu")
+	}
+	if cfg.MemoryMax > 0 {
+		controllers = append(controllers, "memory")
+	}
+	if cfg.IOWeight > 0 {
+		controllers = append(controllers, "io")
+	}
+	return controllers
+}
+
+// limits returns the interface files to write and their values.
+func (cfg *CgroupConfig) limits() [][2]string {
+	var limits [][2]string
+	if cfg.CPUMax > 0 {
+		quota := int64(cfg.CPUMax * cgroupPeriod)
+		limits = append(limits, [2]string{"cpu.max", fmt.Sprintf("%d %d", quota, cgroupPeriod)})
+	}
+	if cfg.MemoryMax > 0 {
+		limits = append(limits, [2]string{"memory.max", fmt.Sprintf("%d", int64(cfg.MemoryMax)<<20)})
+	}
+	if cfg.IOWeight > 0 {
+		limits = append(limits, [2]string{"io.weight", fmt.Sprintf("default %d", cfg.IOWeight)})
+	}
+	return limits
+}
+
+// Cgroup returns the cgroup v2 directory of the QEMU process, for
+// instances started with a CgroupConfig or attached by PID, or "" if
+// unknown.
+func (i *Instance) Cgroup() string {
+	return i.cgroup
+}
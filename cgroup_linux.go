@@ -0,0 +1,161 @@
+//go:build linux
+
+package qemuctl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// cgroupRoot is the mount point of the cgroup v2 hierarchy.
+var cgroupRoot = "/sys/fs/cgroup"
+
+// cgroupRemoveTimeout bounds the wait for the processes of a cgroup to
+// exit before it is removed.
+const cgroupRemoveTimeout = 2 * time.Second
+
+// createCgroup creates the cgroup of the VM name with its limits, and
+// returns its directory.
+func createCgroup(cfg *CgroupConfig, name string) (string, error) {
+	parent := filepath.Join(cgroupRoot, cfg.parent())
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return "", fmt.Errorf("failed to create cgroup: %w", err)
+	}
+
+	// Each level only gets the controllers its parent enabled for it
+	if controllers := cfg.controllers(); len(controllers) > 0 {
+		dir := cgroupRoot
+		if err := enableControllers(dir, controllers); err != nil {
+			return "", err
+		}
+		for _, elem := range strings.Split(cfg.parent(), "/") {
+			dir = filepath.Join(dir, elem)
+			if err := enableControllers(dir, controllers); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	dir := filepath.Join(parent, name)
+	if err := os.Mkdir(dir, 0755); err != nil {
+		// The cgroup of a VM of the same name that was not cleaned up is
+		// reused once its processes are gone
+		if !errors.Is(err, fs.ErrExist) {
+			return "", fmt.Errorf("failed to create cgroup: %w", err)
+		}
+		if cgroupPopulated(dir) {
+			return "", fmt.Errorf("cgroup %s is in use", dir)
+		}
+	}
+
+	for _, limit := range cfg.limits() {
+		if err := os.WriteFile(filepath.Join(dir, limit[0]), []byte(limit[1]), 0644); err != nil {
+			removeCgroup(dir)
+			return "", fmt.Errorf("failed to set cgroup limit: %w", err)
+		}
+	}
+	return dir, nil
+}
+
+// enableControllers enables the controllers missing from the
+// cgroup.subtree_control of dir.
+func enableControllers(dir string, controllers []string) error {
+	path := filepath.Join(dir, "cgroup.subtree_control")
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to read cgroup controllers: %w", err)
+	}
+	enabled := make(map[string]bool)
+	for _, c := range strings.Fields(string(data)) {
+		enabled[c] = true
+	}
+
+	var missing []string
+	for _, c := range controllers {
+		if !enabled[c] {
+			missing = append(missing, "+"+c)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(missing, " ")), 0644); err != nil {
+		return fmt.Errorf("failed to enable cgroup controllers %s in %s: %w", strings.Join(missing, " "), dir, err)
+	}
+	return nil
+}
+
+// cgroupPopulated reports whether processes are left in the cgroup at dir.
+func cgroupPopulated(dir string) bool {
+	data, err := os.ReadFile(filepath.Join(dir, "cgroup.events"))
+	return err == nil && bytes.Contains(data, []byte("populated 1"))
+}
+
+// applyCgroup makes cmd start in the cgroup at dir, so that no thread of
+// QEMU ever runs outside of it. The returned directory must be closed once
+// the process is started.
+func applyCgroup(cmd *exec.Cmd, dir string) (*os.File, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cgroup: %w", err)
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(f.Fd())
+	return f, nil
+}
+
+// killCgroup kills every process in the cgroup at dir, including helpers
+// QEMU spawned (kernel 5.14+).
+func killCgroup(dir string) error {
+	return os.WriteFile(filepath.Join(dir, "cgroup.kill"), []byte("1"), 0644)
+}
+
+// removeCgroup removes the cgroup at dir once its processes have exited.
+// The cgroup is left behind if they do not exit in time.
+func removeCgroup(dir string) {
+	deadline := time.Now().Add(cgroupRemoveTimeout)
+	for {
+		err := syscall.Rmdir(dir)
+		if err != syscall.EBUSY || time.Now().After(deadline) {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// CgroupOf returns the cgroup v2 directory of the process pid, such as
+// the one of a VM attached with AttachByPID.
+func CgroupOf(pid int) (string, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return "", err
+	}
+	path, ok := cgroupFromProc(data)
+	if !ok {
+		return "", fmt.Errorf("process %d is not in a cgroup v2 hierarchy", pid)
+	}
+	return filepath.Join(cgroupRoot, path), nil
+}
+
+// cgroupFromProc returns the cgroup v2 path in the content of a
+// /proc/<pid>/cgroup file, whose v2 entry has hierarchy ID 0 and no
+// controllers.
+func cgroupFromProc(data []byte) (string, bool) {
+	for _, line := range strings.Split(string(data), "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			return path, true
+		}
+	}
+	return "", false
+}
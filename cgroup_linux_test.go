@@ -0,0 +1,70 @@
+//go:build linux
+
+package qemuctl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCreateCgroup(t *testing.T) {
+	root := t.TempDir()
+	old := cgroupRoot
+	cgroupRoot = root
+	defer func() { cgroupRoot = old }()
+
+	// The root already delegates cpu
+	if err := os.WriteFile(filepath.Join(root, "cgroup.subtree_control"), []byte("cpu io\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := createCgroup(&CgroupConfig{Parent: "tenants/acme", CPUMax: 2, MemoryMax: 1024}, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(root, "tenants/acme/web"); dir != want {
+		t.Errorf("cgroup = %s, want %s", dir, want)
+	}
+
+	read := func(path string) string {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join(root, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	for path, want := range map[string]string{
+		"cgroup.subtree_control":              "+memory",
+		"tenants/cgroup.subtree_control":      "+cpu +memory",
+		"tenants/acme/cgroup.subtree_control": "+cpu +memory",
+		"tenants/acme/web/cpu.max":            "200000 100000",
+		"tenants/acme/web/memory.max":         "1073741824",
+	} {
+		if got := read(path); got != want {
+			t.Errorf("%s = %q, want %q", path, got, want)
+		}
+	}
+
+	// A leftover cgroup is reused once empty, not while in use
+	if _, err := createCgroup(&CgroupConfig{Parent: "tenants/acme"}, "web"); err != nil {
+		t.Errorf("empty leftover cgroup: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "cgroup.events"), []byte("populated 1\nfrozen 0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := createCgroup(&CgroupConfig{Parent: "tenants/acme"}, "web"); err == nil {
+		t.Error("createCgroup reused a cgroup in use")
+	}
+}
+
+func TestCgroupFromProc(t *testing.T) {
+	path, ok := cgroupFromProc([]byte("12:cpuset:/\n0::/qemuctl/web\n"))
+	if !ok || path != "/qemuctl/web" {
+		t.Errorf("cgroupFromProc = %q, %v", path, ok)
+	}
+	if _, ok := cgroupFromProc([]byte("12:cpuset:/\n11:memory:/\n")); ok {
+		t.Error("cgroupFromProc found a v2 entry in a v1 file")
+	}
+}
@@ -0,0 +1,35 @@
+//go:build !linux
+
+package qemuctl
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// errCgroupUnsupported is returned for cgroup operations off Linux.
+var errCgroupUnsupported = errors.New("cgroups are only supported on Linux")
+
+// createCgroup is unsupported on this platform.
+func createCgroup(cfg *CgroupConfig, name string) (string, error) {
+	return "", errCgroupUnsupported
+}
+
+// applyCgroup is unsupported on this platform.
+func applyCgroup(cmd *exec.Cmd, dir string) (*os.File, error) {
+	return nil, errCgroupUnsupported
+}
+
+// killCgroup is unsupported on this platform.
+func killCgroup(dir string) error {
+	return errCgroupUnsupported
+}
+
+// removeCgroup does nothing on this platform.
+func removeCgroup(dir string) {}
+
+// CgroupOf is unsupported on this platform.
+func CgroupOf(pid int) (string, error) {
+	return "", errCgroupUnsupported
+}
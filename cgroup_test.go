@@ -0,0 +1,47 @@
+package qemuctl
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCgroupLimits(t *testing.T) {
+	cfg := &CgroupConfig{CPUMax: 1.5, MemoryMax: 4096, IOWeight: 50}
+	want := [][2]string{
+		{"cpu.max", "150000 100000"},
+		{"memory.max", "4294967296"},
+		{"io.weight", "default 50"},
+	}
+	if got := cfg.limits(); !reflect.DeepEqual(got, want) {
+		t.Errorf("limits = %v, want %v", got, want)
+	}
+	if got := cfg.controllers(); !reflect.DeepEqual(got, []string{"cpu", "memory", "io"}) {
+		t.Errorf("controllers = %v", got)
+	}
+	if got := (&CgroupConfig{}).limits(); len(got) != 0 {
+		t.Errorf("unlimited cgroup has limits %v", got)
+	}
+}
+
+func TestValidateCgroup(t *testing.T) {
+	for _, cgroup := range []*CgroupConfig{
+		{CPUMax: -1},
+		{MemoryMax: -1},
+		{IOWeight: 10001},
+		{Parent: "/sys/fs/cgroup/qemuctl"},
+		{Parent: "../escape"},
+		{Parent: "vms/../../escape"},
+		{Parent: "vms/"},
+	} {
+		if err := (&VMConfig{Cgroup: cgroup}).Validate(); err == nil {
+			t.Errorf("Validate accepted %+v", cgroup)
+		}
+	}
+
+	if err := (&VMConfig{Name: "a/b", Cgroup: &CgroupConfig{}}).Validate(); err == nil {
+		t.Error("Validate accepted a VM name with a slash")
+	}
+	if err := (&VMConfig{Name: "web", Cgroup: &CgroupConfig{Parent: "tenants/acme", CPUMax: 2}}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,225 @@
+package qemuctl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Chardev backends with typed support in ChardevConfig.
+const (
+	// ChardevSocket is a unix socket (Path) or a TCP socket (Host, Port).
+	ChardevSocket = "socket"
+
+	// ChardevTCP is a TCP socket, with optional telnet or WebSocket
+	// framing. It is emitted as a QEMU socket chardev.
+	ChardevTCP = "tcp"
+
+	// ChardevUDP sends to Host:Port and receives on LocalAddr:LocalPort.
+	ChardevUDP = "udp"
+
+	// ChardevFile writes the output to Path.
+	ChardevFile = "file"
+
+	// ChardevStdio connects to QEMU's standard input and output.
+	ChardevStdio = "stdio"
+
+	// ChardevPty allocates a pseudo-terminal.
+	ChardevPty = "pty"
+
+	// ChardevNull discards output and never has input.
+	ChardevNull = "null"
+
+	// ChardevSpiceVMC is a SPICE channel, named by Name.
+	ChardevSpiceVMC = "spicevmc"
+
+	// ChardevQemuVDAgent is QEMU's own implementation of the SPICE agent
+	// protocol, for the SPICE agent port of VMs without SPICE.
+	ChardevQemuVDAgent = "qemu-vdagent"
+)
+
+// validate checks that the options fit the backend.
+func (c *ChardevConfig) validate() error {
+	if c.ID == "" {
+		return fmt.Errorf("chardev has no ID")
+	}
+	fail := func(format string, args ...any) error {
+		return fmt.Errorf("chardev %s: %s", c.ID, fmt.Sprintf(format, args...))
+	}
+
+	tcp := c.Backend == ChardevTCP || (c.Backend == ChardevSocket && c.Host != "")
+	switch c.Backend {
+	case ChardevSocket:
+		if (c.Path == "") == (c.Host == "") {
+			return fail("socket needs either a path or a host")
+		}
+		if c.Host != "" && c.Port <= 0 {
+			return fail("TCP socket needs a port")
+		}
+	case ChardevTCP:
+		if c.Host == "" || c.Port <= 0 {
+			return fail("tcp needs a host and a port")
+		}
+		if c.Path != "" {
+			return fail("tcp does not take a path")
+		}
+	case ChardevUDP:
+		if c.Port <= 0 {
+			return fail("udp needs a remote port")
+		}
+	case ChardevFile:
+		if c.Path == "" {
+			return fail("file needs a path")
+		}
+	case ChardevStdio, ChardevNull:
+		if c.Path != "" || c.Host != "" || c.Port != 0 {
+			return fail("%s takes no path or address", c.Backend)
+		}
+	}
+
+	switch {
+	case (c.Telnet || c.WebSocket || c.NoDelay) && !tcp:
+		return fail("telnet, websocket and nodelay need a TCP socket")
+	case c.Telnet && c.WebSocket:
+		return fail("telnet and websocket are exclusive")
+	case c.WebSocket && !c.Server:
+		return fail("websocket needs a server socket")
+	case c.Reconnect > 0 && c.Server:
+		return fail("server sockets do not reconnect")
+	case (c.LocalAddr != "" || c.LocalPort != 0) && c.Backend != ChardevUDP:
+		return fail("local address is only used by udp")
+	case c.Append && c.Backend != ChardevFile:
+		return fail("append is only used by file")
+	case c.Clipboard && c.Backend != ChardevQemuVDAgent:
+		return fail("clipboard is only used by qemu-vdagent")
+	}
+	return nil
+}
+
+// chardev returns the chardev of the serial port at index i.
+func (s *SerialConfig) chardev(i int) *ChardevConfig {
+	return &ChardevConfig{
+		ID:      fmt.Sprintf("serial%d", i),
+		Backend: s.Type,
+		Path:    s.Path,
+		Server:  s.Server,
+		Wait:    s.Wait,
+	}
+}
+
+// buildChardevArgs builds chardev arguments.
+func buildChardevArgs(cfg *ChardevConfig) []string {
+	if cfg == nil || cfg.ID == "" {
+		return nil
+	}
+
+	backend := cfg.Backend
+	if backend == ChardevTCP {
+		backend = ChardevSocket
+	}
+
+	var parts []string
+	parts = append(parts, backend)
+	parts = append(parts, "id="+cfg.ID)
+
+	switch cfg.Backend {
+	case ChardevSocket, ChardevTCP:
+		if cfg.Path != "" {
+			parts = append(parts, "path="+cfg.Path)
+		}
+		if cfg.Host != "" {
+			parts = append(parts, "host="+cfg.Host)
+		}
+		if cfg.Port > 0 {
+			parts = append(parts, "port="+strconv.Itoa(cfg.Port))
+		}
+		// QEMU rejects wait on client sockets
+		if cfg.Server {
+			parts = append(parts, "server=on")
+			if !cfg.Wait {
+				parts = append(parts, "wait=off")
+			}
+		}
+		if cfg.Telnet {
+			parts = append(parts, "telnet=on")
+		}
+		if cfg.WebSocket {
+			parts = append(parts, "websocket=on")
+		}
+		if cfg.NoDelay {
+			parts = append(parts, "nodelay=on")
+		}
+		if cfg.Reconnect > 0 {
+			parts = append(parts, fmt.Sprintf("reconnect=%d", cfg.Reconnect))
+		}
+
+	case ChardevUDP:
+		if cfg.Host != "" {
+			parts = append(parts, "host="+cfg.Host)
+		}
+		parts = append(parts, "port="+strconv.Itoa(cfg.Port))
+		if cfg.LocalAddr != "" {
+			parts = append(parts, "localaddr="+cfg.LocalAddr)
+		}
+		if cfg.LocalPort > 0 {
+			parts = append(parts, "localport="+strconv.Itoa(cfg.LocalPort))
+		}
+
+	case ChardevFile:
+		parts = append(parts, "path="+cfg.Path)
+		if cfg.Append {
+			parts = append(parts, "append=on")
+		}
+
+	case ChardevPty:
+		if cfg.Path != "" {
+			parts = append(parts, "path="+cfg.Path)
+		}
+
+	case ChardevStdio, ChardevNull:
+		// No options
+
+	case ChardevSpiceVMC:
+		if cfg.Name != "" {
+			parts = append(parts, "name="+cfg.Name)
+		}
+
+	case ChardevQemuVDAgent:
+		if cfg.Name != "" {
+			parts = append(parts, "name="+cfg.Name)
+		}
+		if cfg.Clipboard {
+			parts = append(parts, "clipboard=on")
+		}
+
+	default:
+		// Other backends get the generic options
+		if cfg.Path != "" {
+			parts = append(parts, "path="+cfg.Path)
+		}
+		if cfg.Host != "" {
+			parts = append(parts, "host="+cfg.Host)
+		}
+		if cfg.Port > 0 {
+			parts = append(parts, fmt.Sprintf("port=%d", cfg.Port))
+		}
+		if cfg.Server {
+			parts = append(parts, "server=on")
+			if !cfg.Wait {
+				parts = append(parts, "wait=off")
+			}
+		}
+		if cfg.Reconnect > 0 {
+			parts = append(parts, fmt.Sprintf("reconnect=%d", cfg.Reconnect))
+		}
+		if cfg.Name != "" {
+			parts = append(parts, "name="+cfg.Name)
+		}
+	}
+
+	if cfg.LogFile != "" {
+		parts = append(parts, "logfile="+cfg.LogFile)
+	}
+
+	return []string{"-chardev", strings.Join(parts, ",")}
+}
@@ -0,0 +1,62 @@
+package qemuctl
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuildChardevBackends(t *testing.T) {
+	for _, tt := range []struct {
+		cfg  ChardevConfig
+		want string
+	}{
+		{ChardevConfig{Backend: ChardevTCP, Host: "127.0.0.1", Port: 4555, Server: true, Telnet: true}, "socket,id=c0,host=127.0.0.1,port=4555,server=on,wait=off,telnet=on"},
+		{ChardevConfig{Backend: ChardevTCP, Host: "0.0.0.0", Port: 5700, Server: true, Wait: true, WebSocket: true}, "socket,id=c0,host=0.0.0.0,port=5700,server=on,websocket=on"},
+		{ChardevConfig{Backend: ChardevSocket, Path: "/run/c0.sock", Reconnect: 5}, "socket,id=c0,path=/run/c0.sock,reconnect=5"},
+		{ChardevConfig{Backend: ChardevUDP, Host: "10.0.0.1", Port: 514, LocalPort: 5514}, "udp,id=c0,host=10.0.0.1,port=514,localport=5514"},
+		{ChardevConfig{Backend: ChardevFile, Path: "/var/log/c0.log", Append: true}, "file,id=c0,path=/var/log/c0.log,append=on"},
+		{ChardevConfig{Backend: ChardevStdio}, "stdio,id=c0"},
+		{ChardevConfig{Backend: ChardevPty}, "pty,id=c0"},
+		{ChardevConfig{Backend: ChardevNull}, "null,id=c0"},
+		{ChardevConfig{Backend: ChardevQemuVDAgent, Name: "vdagent", Clipboard: true}, "qemu-vdagent,id=c0,name=vdagent,clipboard=on"},
+	} {
+		tt.cfg.ID = "c0"
+		if err := tt.cfg.validate(); err != nil {
+			t.Errorf("validate %s: %v", tt.want, err)
+		}
+		if got := strings.Join(buildChardevArgs(&tt.cfg), " "); got != "-chardev "+tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+
+		// Options must survive a parse of the command line
+		parsed, err := ParseArgs(buildChardevArgs(&tt.cfg))
+		if err != nil || len(parsed.Chardevs) != 1 {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		if got := strings.Join(buildChardevArgs(parsed.Chardevs[0]), " "); got != "-chardev "+tt.want {
+			t.Errorf("round trip: got %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestValidateChardev(t *testing.T) {
+	for _, cfg := range []*ChardevConfig{
+		{Backend: ChardevNull},
+		{ID: "c0", Backend: ChardevSocket},
+		{ID: "c0", Backend: ChardevSocket, Path: "/run/c0.sock", Host: "localhost", Port: 1},
+		{ID: "c0", Backend: ChardevTCP, Host: "localhost"},
+		{ID: "c0", Backend: ChardevTCP, Host: "localhost", Port: 1, WebSocket: true},
+		{ID: "c0", Backend: ChardevTCP, Host: "localhost", Port: 1, Server: true, WebSocket: true, Telnet: true},
+		{ID: "c0", Backend: ChardevTCP, Host: "localhost", Port: 1, Server: true, Reconnect: 5},
+		{ID: "c0", Backend: ChardevSocket, Path: "/run/c0.sock", Telnet: true},
+		{ID: "c0", Backend: ChardevUDP},
+		{ID: "c0", Backend: ChardevFile},
+		{ID: "c0", Backend: ChardevPty, Append: true},
+		{ID: "c0", Backend: ChardevStdio, Path: "/dev/tty"},
+		{ID: "c0", Backend: ChardevSpiceVMC, Name: "vdagent", Clipboard: true},
+	} {
+		if err := (&VMConfig{Chardevs: []*ChardevConfig{cfg}}).Validate(); err == nil {
+			t.Errorf("Validate accepted %+v", cfg)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package qemuctl
+
+import (
+	"fmt"
+	"os"
+)
+
+// passedFile is a file opened by the launcher and handed to QEMU through
+// an fdset, so QEMU can still reach it after dropping into a chroot or
+// when running as a user without access to it. File, if set, is a
+// descriptor the caller already opened, which is passed as is instead.
+type passedFile struct {
+	Path string
+	Flag int
+	Set  int
+	File *os.File
+}
+
+// filePath returns the path QEMU should use for a host file. Without a chroot
+// or another user this is the path itself; otherwise the file is registered
+// for pre-opening and its fdset path is returned instead.
+func (b *VMBuilder) filePath(path string, readOnly bool) string {
+	if !b.passFiles() || path == "" {
+		return path
+	}
+
+	set := b.nextFdset()
+
+	flags := []int{os.O_RDONLY}
+	if !readOnly {
+		// QEMU may open the image read-only first and reopen it read-write,
+		// so the set carries a descriptor for each access mode.
+		flags = []int{os.O_RDWR, os.O_RDONLY}
+	}
+
+	for _, flag := range flags {
+		fd := 3 + len(b.passedFiles)
+		b.passedFiles = append(b.passedFiles, passedFile{Path: path, Flag: flag, Set: set})
+		opaque := "rw"
+		if flag == os.O_RDONLY {
+			opaque = "ro"
+		}
+		b.args = append(b.args, "-add-fd", fmt.Sprintf("fd=%d,set=%d,opaque=%s:%s", fd, set, opaque, path))
+	}
+
+	return fmt.Sprintf("/dev/fdset/%d", set)
+}
+
+// passFiles reports whether the launcher opens the files QEMU uses, which
+// QEMU cannot reach itself once chrooted or running as another user.
+func (b *VMBuilder) passFiles() bool {
+	return b.config.Chroot != "" || b.config.RunAsUser != ""
+}
+
+// nextFdset returns the next unused fdset number.
+func (b *VMBuilder) nextFdset() int {
+	set := 0
+	for _, f := range b.passedFiles {
+		if f.Set >= set {
+			set = f.Set + 1
+		}
+	}
+	return set
+}
+
+// OpenPassedFiles opens the files that the last Build call arranged to pass
+// to QEMU as pre-opened descriptors, and duplicates the descriptors of TAP
+// interfaces. The returned files must be assigned, in order, to
+// exec.Cmd.ExtraFiles and closed once the process has started.
+func (b *VMBuilder) OpenPassedFiles() ([]*os.File, error) {
+	files := make([]*os.File, 0, len(b.passedFiles))
+	for _, pf := range b.passedFiles {
+		f, err := pf.open()
+		if err != nil {
+			for _, opened := range files {
+				opened.Close()
+			}
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// open opens the file, or duplicates the descriptor of the caller so that
+// it can be closed after the start independently.
+func (pf passedFile) open() (*os.File, error) {
+	if pf.File != nil {
+		fd, err := dupFd(int(pf.File.Fd()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to dup %s: %w", pf.File.Name(), err)
+		}
+		return os.NewFile(uintptr(fd), pf.File.Name()), nil
+	}
+
+	f, err := os.OpenFile(pf.Path, pf.Flag, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", pf.Path, err)
+	}
+	return f, nil
+}
@@ -0,0 +1,398 @@
+package qemuctl
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"unicode/utf8"
+)
+
+// spiceAgentChannel is the virtio-serial port name the SPICE guest agent
+// (spice-vdagent) opens.
+const spiceAgentChannel = "com.redhat.spice.0"
+
+// vdagent protocol constants, from spice-protocol's vd_agent.h.
+const (
+	vdpClientPort   = 1
+	vdAgentProtocol = 1
+
+	// vdChunkMaxData is the most data a chunk carries.
+	vdChunkMaxData = 2048
+
+	// vdMessageMaxSize bounds the messages accepted from the guest.
+	vdMessageMaxSize = 64 << 20
+
+	vdChunkHeaderSize   = 8
+	vdMessageHeaderSize = 20
+
+	// Message types
+	vdAgentClipboard            = 4
+	vdAgentAnnounceCapabilities = 6
+	vdAgentClipboardGrab        = 7
+	vdAgentClipboardRequest     = 8
+	vdAgentClipboardRelease     = 9
+
+	vdAgentCapClipboardByDemand = 5
+
+	// Clipboard data types
+	vdAgentClipboardNone     = 0
+	vdAgentClipboardUTF8Text = 1
+)
+
+// WithQemuVDAgent adds a qemu-vdagent chardev on the SPICE agent port, so
+// that QEMU itself talks to spice-vdagent in the guest and shares the
+// guest clipboard with VNC clients supporting the extended clipboard. It
+// replaces WithSpiceAgent for VMs without SPICE (QEMU 6.1+).
+func (cfg *VMConfig) WithQemuVDAgent() *VMConfig {
+	cfg.Chardevs = append(cfg.Chardevs, &ChardevConfig{
+		ID:        "vdagent0",
+		Backend:   ChardevQemuVDAgent,
+		Name:      "vdagent",
+		Clipboard: true,
+	})
+
+	if cfg.VirtioSerial == nil {
+		cfg.VirtioSerial = &VirtioSerialConfig{}
+	}
+	cfg.VirtioSerial.Ports = append(cfg.VirtioSerial.Ports, VirtioSerialPortConfig{
+		Chardev: "vdagent0",
+		Name:    spiceAgentChannel,
+		Type:    "virtserialport",
+	})
+
+	return cfg
+}
+
+// WithClipboard connects the SPICE agent port to a unix socket at
+// socketPath, where Instance.SetGuestClipboard and
+// Instance.GetGuestClipboard speak the vdagent protocol to spice-vdagent
+// in the guest. The port cannot also be used by WithSpiceAgent or
+// WithQemuVDAgent.
+func (cfg *VMConfig) WithClipboard(socketPath string) *VMConfig {
+	cfg.Chardevs = append(cfg.Chardevs, &ChardevConfig{
+		ID:      "vdagent0",
+		Backend: ChardevSocket,
+		Path:    socketPath,
+		Server:  true,
+	})
+
+	if cfg.VirtioSerial == nil {
+		cfg.VirtioSerial = &VirtioSerialConfig{}
+	}
+	cfg.VirtioSerial.Ports = append(cfg.VirtioSerial.Ports, VirtioSerialPortConfig{
+		Chardev: "vdagent0",
+		Name:    spiceAgentChannel,
+		Type:    "virtserialport",
+	})
+
+	return cfg
+}
+
+// clipboardSocket returns the host socket path of the SPICE agent port, if
+// it is connected to a unix socket server.
+func clipboardSocket(cfg *VMConfig) string {
+	if cfg == nil || cfg.VirtioSerial == nil {
+		return ""
+	}
+	for _, port := range cfg.VirtioSerial.Ports {
+		if port.Name != spiceAgentChannel {
+			continue
+		}
+		for _, ch := range cfg.Chardevs {
+			if ch.ID == port.Chardev && ch.Backend == ChardevSocket && ch.Server && ch.Path != "" {
+				return ch.Path
+			}
+		}
+	}
+	return ""
+}
+
+// SetGuestClipboard puts text on the guest clipboard, as a SPICE client
+// does when its user copies: the guest is told that text is available and
+// fetches it when an application pastes. The guest needs spice-vdagent
+// running and the channel added by VMConfig.WithClipboard. If the agent
+// is not running yet, it gets the text when it starts.
+func (i *Instance) SetGuestClipboard(text string) error {
+	if !utf8.ValidString(text) {
+		return errors.New("clipboard text is not valid UTF-8")
+	}
+	c, err := i.clipboardClient()
+	if err != nil {
+		return err
+	}
+	return c.set(text)
+}
+
+// GetGuestClipboard returns the text on the guest clipboard, or "" if the
+// guest clipboard holds no text. It waits for spice-vdagent to send it
+// until ctx is done.
+func (i *Instance) GetGuestClipboard(ctx context.Context) (string, error) {
+	c, err := i.clipboardClient()
+	if err != nil {
+		return "", err
+	}
+	return c.get(ctx)
+}
+
+// clipboardClient returns the instance's vdagent client, connected.
+func (i *Instance) clipboardClient() (*vdagentClient, error) {
+	i.clipboardMu.Lock()
+	defer i.clipboardMu.Unlock()
+
+	if i.clipboard == nil {
+		path := clipboardSocket(i.vmConfig)
+		if path == "" {
+			return nil, errors.New("no clipboard channel configured")
+		}
+		i.clipboard = &vdagentClient{path: path}
+	}
+	if err := i.clipboard.connect(); err != nil {
+		return nil, err
+	}
+	return i.clipboard, nil
+}
+
+// vdagentClient plays the part of a SPICE client to spice-vdagent in the
+// guest, for the clipboard only.
+type vdagentClient struct {
+	path string
+
+	// getMu serializes clipboard requests, as replies carry no ID
+	getMu sync.Mutex
+
+	mu     sync.Mutex
+	conn   net.Conn
+	closed chan struct{}
+	// text is the host clipboard offered to the guest, if hostOwned
+	text      string
+	hostOwned bool
+	// guestText is set when the guest clipboard holds text
+	guestText bool
+	reply     chan string
+
+	wmu sync.Mutex
+}
+
+// connect dials the agent socket unless connected, and announces the
+// host capabilities.
+func (c *vdagentClient) connect() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		return nil
+	}
+
+	conn, err := net.Dial("unix", c.path)
+	if err != nil {
+		return fmt.Errorf("failed to connect to clipboard channel: %w", err)
+	}
+	c.conn = conn
+	c.closed = make(chan struct{})
+	c.guestText = false
+	go c.readLoop(conn, c.closed)
+
+	if err := c.announce(conn); err != nil {
+		return err
+	}
+	if c.hostOwned {
+		return c.grab(conn)
+	}
+	return nil
+}
+
+// set offers text to the guest.
+func (c *vdagentClient) set(text string) error {
+	c.mu.Lock()
+	c.text = text
+	c.hostOwned = true
+	c.guestText = false
+	conn := c.conn
+	c.mu.Unlock()
+
+	if conn == nil {
+		return ErrGuestAgentDisconnected
+	}
+	return c.grab(conn)
+}
+
+// get requests the guest clipboard text.
+func (c *vdagentClient) get(ctx context.Context) (string, error) {
+	c.getMu.Lock()
+	defer c.getMu.Unlock()
+
+	c.mu.Lock()
+	conn, closed := c.conn, c.closed
+	switch {
+	case c.hostOwned:
+		c.mu.Unlock()
+		return c.text, nil
+	case !c.guestText || conn == nil:
+		c.mu.Unlock()
+		return "", nil
+	}
+	reply := make(chan string, 1)
+	c.reply = reply
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		c.reply = nil
+		c.mu.Unlock()
+	}()
+
+	if err := c.send(conn, vdAgentClipboardRequest, binary.LittleEndian.AppendUint32(nil, vdAgentClipboardUTF8Text)); err != nil {
+		return "", err
+	}
+	select {
+	case text := <-reply:
+		return text, nil
+	case <-closed:
+		return "", ErrGuestAgentDisconnected
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// readLoop handles the messages of the guest until the connection fails.
+func (c *vdagentClient) readLoop(conn net.Conn, closed chan struct{}) {
+	defer func() {
+		c.mu.Lock()
+		if c.conn == conn {
+			c.conn = nil
+		}
+		c.mu.Unlock()
+		conn.Close()
+		close(closed)
+	}()
+
+	r := &vdChunkReader{r: conn}
+	for {
+		var hdr [vdMessageHeaderSize]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return
+		}
+		size := binary.LittleEndian.Uint32(hdr[16:])
+		if size > vdMessageMaxSize {
+			return
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return
+		}
+		c.handle(conn, binary.LittleEndian.Uint32(hdr[4:]), data)
+	}
+}
+
+// handle handles a message of the guest.
+func (c *vdagentClient) handle(conn net.Conn, typ uint32, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch typ {
+	case vdAgentAnnounceCapabilities:
+		// The agent asks for the capabilities when it starts, and has
+		// forgotten a grab made before
+		if len(data) >= 4 && binary.LittleEndian.Uint32(data) != 0 {
+			hostOwned := c.hostOwned
+			go func() {
+				c.announce(conn)
+				if hostOwned {
+					c.grab(conn)
+				}
+			}()
+		}
+
+	case vdAgentClipboardGrab:
+		c.hostOwned = false
+		c.guestText = false
+		for n := 0; n+4 <= len(data); n += 4 {
+			if binary.LittleEndian.Uint32(data[n:]) == vdAgentClipboardUTF8Text {
+				c.guestText = true
+			}
+		}
+
+	case vdAgentClipboardRelease:
+		c.guestText = false
+
+	case vdAgentClipboardRequest:
+		if len(data) >= 4 && binary.LittleEndian.Uint32(data) == vdAgentClipboardUTF8Text && c.hostOwned {
+			go c.send(conn, vdAgentClipboard, append(binary.LittleEndian.AppendUint32(nil, vdAgentClipboardUTF8Text), c.text...))
+		} else {
+			go c.send(conn, vdAgentClipboard, binary.LittleEndian.AppendUint32(nil, vdAgentClipboardNone))
+		}
+
+	case vdAgentClipboard:
+		if c.reply == nil {
+			return
+		}
+		var text string
+		if len(data) >= 4 && binary.LittleEndian.Uint32(data) == vdAgentClipboardUTF8Text {
+			text = string(data[4:])
+		}
+		c.reply <- text
+		c.reply = nil
+	}
+}
+
+// announce sends the host capabilities, without asking for the guest's,
+// which the agent sends when it starts.
+func (c *vdagentClient) announce(conn net.Conn) error {
+	data := binary.LittleEndian.AppendUint32(nil, 0)
+	data = binary.LittleEndian.AppendUint32(data, 1<<vdAgentCapClipboardByDemand)
+	return c.send(conn, vdAgentAnnounceCapabilities, data)
+}
+
+// grab tells the guest that the host clipboard holds text.
+func (c *vdagentClient) grab(conn net.Conn) error {
+	return c.send(conn, vdAgentClipboardGrab, binary.LittleEndian.AppendUint32(nil, vdAgentClipboardUTF8Text))
+}
+
+// send writes a message, split into chunks for the client port.
+func (c *vdagentClient) send(conn net.Conn, typ uint32, data []byte) error {
+	msg := make([]byte, vdMessageHeaderSize, vdMessageHeaderSize+len(data))
+	binary.LittleEndian.PutUint32(msg[0:], vdAgentProtocol)
+	binary.LittleEndian.PutUint32(msg[4:], typ)
+	binary.LittleEndian.PutUint32(msg[16:], uint32(len(data)))
+	msg = append(msg, data...)
+
+	var buf []byte
+	for len(msg) > 0 {
+		n := min(len(msg), vdChunkMaxData)
+		buf = binary.LittleEndian.AppendUint32(buf, vdpClientPort)
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(n))
+		buf = append(buf, msg[:n]...)
+		msg = msg[n:]
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := conn.Write(buf)
+	return err
+}
+
+// vdChunkReader returns the data of the chunks read from r, which form
+// the stream of messages.
+type vdChunkReader struct {
+	r    io.Reader
+	left uint32
+}
+
+func (cr *vdChunkReader) Read(p []byte) (int, error) {
+	for cr.left == 0 {
+		var hdr [vdChunkHeaderSize]byte
+		if _, err := io.ReadFull(cr.r, hdr[:]); err != nil {
+			return 0, err
+		}
+		cr.left = binary.LittleEndian.Uint32(hdr[4:])
+	}
+	if uint32(len(p)) > cr.left {
+		p = p[:cr.left]
+	}
+	n, err := cr.r.Read(p)
+	cr.left -= uint32(n)
+	return n, err
+}
@@ -0,0 +1,161 @@
+package qemuctl
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// vdMessage is a vdagent message received by the fake guest agent.
+type vdMessage struct {
+	typ  uint32
+	data []byte
+}
+
+// newFakeVDAgent listens on a unix socket as QEMU does for the SPICE agent
+// port, and returns the path, the messages the host sends and the
+// connection once the host connects, to send messages as spice-vdagent.
+func newFakeVDAgent(t *testing.T) (string, <-chan vdMessage, <-chan net.Conn) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "vdagent.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	msgs := make(chan vdMessage, 16)
+	conns := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		t.Cleanup(func() { conn.Close() })
+		conns <- conn
+
+		r := &vdChunkReader{r: conn}
+		for {
+			var hdr [vdMessageHeaderSize]byte
+			if _, err := io.ReadFull(r, hdr[:]); err != nil {
+				return
+			}
+			data := make([]byte, binary.LittleEndian.Uint32(hdr[16:]))
+			if _, err := io.ReadFull(r, data); err != nil {
+				return
+			}
+			msgs <- vdMessage{binary.LittleEndian.Uint32(hdr[4:]), data}
+		}
+	}()
+	return path, msgs, conns
+}
+
+func nextVDMessage(t *testing.T, msgs <-chan vdMessage, typ uint32) []byte {
+	t.Helper()
+	for {
+		select {
+		case m := <-msgs:
+			if m.typ == typ {
+				return m.data
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no message of type %d", typ)
+		}
+	}
+}
+
+func TestGuestClipboard(t *testing.T) {
+	path, msgs, conns := newFakeVDAgent(t)
+	cfg := (&VMConfig{}).WithClipboard(path)
+	inst := &Instance{vmConfig: cfg}
+
+	if err := inst.SetGuestClipboard("héllo"); err != nil {
+		t.Fatal(err)
+	}
+	guest := &vdagentClient{}
+	conn := <-conns
+
+	caps := nextVDMessage(t, msgs, vdAgentAnnounceCapabilities)
+	if len(caps) != 8 || binary.LittleEndian.Uint32(caps[4:])&(1<<vdAgentCapClipboardByDemand) == 0 {
+		t.Errorf("announced capabilities %x", caps)
+	}
+	grab := nextVDMessage(t, msgs, vdAgentClipboardGrab)
+	if binary.LittleEndian.Uint32(grab) != vdAgentClipboardUTF8Text {
+		t.Errorf("grabbed types %x", grab)
+	}
+
+	// A guest application pastes
+	guest.send(conn, vdAgentClipboardRequest, binary.LittleEndian.AppendUint32(nil, vdAgentClipboardUTF8Text))
+	data := nextVDMessage(t, msgs, vdAgentClipboard)
+	if binary.LittleEndian.Uint32(data) != vdAgentClipboardUTF8Text || string(data[4:]) != "héllo" {
+		t.Errorf("clipboard data %q", data)
+	}
+
+	// A guest application copies text longer than a chunk
+	text := strings.Repeat("0123456789", 500)
+	guest.send(conn, vdAgentClipboardGrab, binary.LittleEndian.AppendUint32(nil, vdAgentClipboardUTF8Text))
+	go func() {
+		nextVDMessage(t, msgs, vdAgentClipboardRequest)
+		guest.send(conn, vdAgentClipboard, append(binary.LittleEndian.AppendUint32(nil, vdAgentClipboardUTF8Text), text...))
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var got string
+	for got != text && ctx.Err() == nil {
+		// Until the grab is handled, the host text is returned
+		got, _ = inst.GetGuestClipboard(ctx)
+	}
+	if got != text {
+		t.Errorf("guest clipboard has %d bytes, want %d", len(got), len(text))
+	}
+
+	// Nothing is requested once the guest releases the clipboard
+	guest.send(conn, vdAgentClipboardRelease, nil)
+	for got != "" && ctx.Err() == nil {
+		// Requests sent before the release is handled get no reply
+		reqCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		got, _ = inst.GetGuestClipboard(reqCtx)
+		cancel()
+	}
+	if got != "" {
+		t.Errorf("released clipboard has %q", got)
+	}
+}
+
+func TestGuestClipboardNotConfigured(t *testing.T) {
+	inst := &Instance{vmConfig: (&VMConfig{}).WithSpiceAgent()}
+	if err := inst.SetGuestClipboard("x"); err == nil {
+		t.Error("SetGuestClipboard succeeded without a clipboard channel")
+	}
+}
+
+func TestQemuVDAgent(t *testing.T) {
+	cfg := (&VMConfig{}).WithQemuVDAgent()
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	args := NewVMBuilder(cfg).Build("test", "")
+	joined := strings.Join(args, " ")
+	if !strings.Contains(joined, "-chardev qemu-vdagent,id=vdagent0,name=vdagent,clipboard=on") ||
+		!strings.Contains(joined, "chardev=vdagent0,name=com.redhat.spice.0") {
+		t.Errorf("args missing qemu-vdagent:\n%s", joined)
+	}
+
+	out, err := cfg.ToLibvirtXML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	back, err := ParseLibvirtXML([]byte(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(back.Chardevs) != 1 || back.Chardevs[0].Backend != ChardevQemuVDAgent || !back.Chardevs[0].Clipboard {
+		t.Errorf("libvirt chardevs = %+v\n%s", back.Chardevs, out)
+	}
+}
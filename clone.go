@@ -0,0 +1,149 @@
+package qemuctl
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// CloneOptions configures CloneVM.
+type CloneOptions struct {
+	// Dir is the directory of the files created for the clone. If empty,
+	// each file is created next to the template file it replaces.
+	Dir string
+
+	// QemuImgPath overrides the qemu-img binary path.
+	QemuImgPath string
+}
+
+// CloneVM returns the configuration of a new VM named name, based on a
+// template configuration such as a golden image. Each writable file disk
+// of the template becomes a qcow2 overlay, "<name>-<disk ID>.qcow2", backed
+// by the template image, which must then no longer be written to. The
+// clone gets its own UUID, disk serials and MAC addresses (see StableMAC),
+// and its own EFI vars, seeded from the template's, and TPM state.
+// Read-only disks and CD-ROMs are shared with the template.
+//
+// Output and serial log writers, and TAP interfaces, are not copied.
+func CloneVM(ctx context.Context, template *VMConfig, name string, opts *CloneOptions) (*VMConfig, error) {
+	if template == nil || name == "" {
+		return nil, fmt.Errorf("cloning a VM needs a template and a name")
+	}
+	if opts == nil {
+		opts = &CloneOptions{}
+	}
+
+	// Deep copy through JSON, which also drops the writers
+	data, err := json.Marshal(template)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy template: %w", err)
+	}
+	cfg, err := ParseVMConfig(data)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.Name = name
+	cfg.UUID = newUUID()
+	for n, nc := range cfg.Networks {
+		nc.MACAddr = StableMAC(name, n)
+	}
+
+	if efi := cfg.EFI; efi != nil && efi.Vars != "" {
+		if efi.VarsTemplate == "" {
+			efi.VarsTemplate = efi.Vars
+		}
+		efi.Vars = clonePath(opts.Dir, efi.Vars, name+"-VARS.fd")
+	}
+	if tpm := cfg.TPM; tpm != nil && tpm.StateDir != "" {
+		tpm.StateDir = clonePath(opts.Dir, tpm.StateDir, name+"-tpm")
+	}
+
+	var overlays []string
+	for n, disk := range cfg.Disks {
+		id := disk.ID
+		if id == "" {
+			id = "drive" + strconv.Itoa(n)
+		}
+		if disk.Serial != "" {
+			disk.Serial = cloneSerial(name, id)
+		}
+		if disk.ReadOnly {
+			continue
+		}
+
+		if disk.Backend == nil {
+			removeFiles(overlays)
+			return nil, fmt.Errorf("disk %s has no backend", id)
+		}
+		file, ok := disk.Backend.(*FileDiskBackend)
+		if !ok {
+			removeFiles(overlays)
+			return nil, fmt.Errorf("disk %s: only file disks can be cloned, not %s", id, disk.Backend.Type())
+		}
+		overlay, err := createOverlay(ctx, file, clonePath(opts.Dir, file.Path, name+"-"+id+".qcow2"), opts.QemuImgPath)
+		if err != nil {
+			removeFiles(overlays)
+			return nil, fmt.Errorf("disk %s: %w", id, err)
+		}
+		overlays = append(overlays, overlay)
+		file.Path, file.Format = overlay, "qcow2"
+	}
+	return cfg, nil
+}
+
+// clonePath returns the path of a file of a clone, in dir or next to the
+// template file it replaces.
+func clonePath(dir, templatePath, base string) string {
+	if dir == "" {
+		dir = filepath.Dir(templatePath)
+	}
+	return filepath.Join(dir, base)
+}
+
+// cloneSerial returns the disk serial of the disk id of a clone, 20
+// characters as IDE and virtio-blk allow.
+func cloneSerial(name, id string) string {
+	sum := sha256.Sum256([]byte(name + "\x00" + id))
+	return hex.EncodeToString(sum[:10])
+}
+
+// createOverlay creates a qcow2 overlay at path backed by the image of a
+// file disk. An existing file is not overwritten.
+func createOverlay(ctx context.Context, base *FileDiskBackend, path, qemuImgPath string) (string, error) {
+	backing, err := filepath.Abs(base.Path)
+	if err != nil {
+		return "", err
+	}
+	format := base.Format
+	if format == "" {
+		format = "raw"
+	}
+	if _, err := os.Lstat(path); err == nil {
+		return "", fmt.Errorf("overlay %s already exists", path)
+	}
+
+	err = CreateImage(ctx, path, &CreateImageOptions{
+		Format:        "qcow2",
+		BackingFile:   backing,
+		BackingFormat: format,
+		QemuImgPath:   qemuImgPath,
+	})
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
+
+// removeFiles removes files created before a failure.
+func removeFiles(paths []string) {
+	for _, path := range paths {
+		os.Remove(path)
+	}
+}
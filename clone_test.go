@@ -0,0 +1,157 @@
+package qemuctl
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeOverlayImg writes a qemu-img stand-in logging its arguments and
+// creating the image, its last argument when no size is given.
+func fakeOverlayImg(t *testing.T) (path, log string) {
+	t.Helper()
+	dir := t.TempDir()
+	path = filepath.Join(dir, "qemu-img")
+	log = filepath.Join(dir, "args.log")
+	script := "#!/bin/sh\necho \"$@\" >> " + log + "\nfor last; do :; done\n: > \"$last\"\n"
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path, log
+}
+
+func TestCloneVM(t *testing.T) {
+	qemuImg, log := fakeOverlayImg(t)
+	base := t.TempDir()
+	dir := t.TempDir()
+
+	var output bytes.Buffer
+	template := DefaultVMConfig()
+	template.Name = "golden"
+	template.UUID = newUUID()
+	template.Output = &output
+	template.Disks = []*DiskConfig{
+		{ID: "root", Backend: &FileDiskBackend{Path: filepath.Join(base, "root.qcow2"), Format: "qcow2"}, Serial: "GOLDEN0"},
+		{ID: "data", Backend: &FileDiskBackend{Path: filepath.Join(base, "data.img")}},
+		{ID: "tools", Backend: &FileDiskBackend{Path: filepath.Join(base, "tools.img")}, ReadOnly: true},
+	}
+	template.Networks = []*NetworkConfig{{ID: "net0", Backend: &UserNetBackend{}, MACAddr: "52:54:00:12:34:56"}}
+	template.EFI = &EFIConfig{Code: "/usr/share/OVMF/OVMF_CODE.fd", Vars: filepath.Join(base, "golden-VARS.fd")}
+	template.TPM = &TPMConfig{StateDir: filepath.Join(base, "tpm")}
+
+	clone, err := CloneVM(context.Background(), template, "web1", &CloneOptions{Dir: dir, QemuImgPath: qemuImg})
+	if err != nil {
+		t.Fatalf("CloneVM failed: %v", err)
+	}
+
+	if clone.Name != "web1" || !isUUID(clone.UUID) || clone.UUID == template.UUID {
+		t.Errorf("clone name %q, UUID %q", clone.Name, clone.UUID)
+	}
+	if clone.Output != nil {
+		t.Error("output writer copied")
+	}
+	if mac := clone.Networks[0].MACAddr; mac != StableMAC("web1", 0) {
+		t.Errorf("clone MAC = %s", mac)
+	}
+	if serial := clone.Disks[0].Serial; serial == "GOLDEN0" || len(serial) != 20 || serial != cloneSerial("web1", "root") {
+		t.Errorf("clone serial = %q", serial)
+	}
+	if clone.Disks[1].Serial != "" {
+		t.Errorf("serial added to disk without one: %q", clone.Disks[1].Serial)
+	}
+
+	for n, want := range []string{filepath.Join(dir, "web1-root.qcow2"), filepath.Join(dir, "web1-data.qcow2")} {
+		file := clone.Disks[n].Backend.(*FileDiskBackend)
+		if file.Path != want || file.Format != "qcow2" {
+			t.Errorf("disk %d = %+v, want overlay %s", n, file, want)
+		}
+	}
+	if file := clone.Disks[2].Backend.(*FileDiskBackend); file.Path != filepath.Join(base, "tools.img") {
+		t.Errorf("read-only disk not shared: %s", file.Path)
+	}
+	if template.Disks[0].Backend.(*FileDiskBackend).Path != filepath.Join(base, "root.qcow2") || template.Networks[0].MACAddr != "52:54:00:12:34:56" {
+		t.Error("CloneVM modified the template")
+	}
+
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantArgs := "create -f qcow2 -b " + filepath.Join(base, "root.qcow2") + " -F qcow2 " + filepath.Join(dir, "web1-root.qcow2") + "\n" +
+		"create -f qcow2 -b " + filepath.Join(base, "data.img") + " -F raw " + filepath.Join(dir, "web1-data.qcow2") + "\n"
+	if string(data) != wantArgs {
+		t.Errorf("qemu-img calls:\n%s\nwant:\n%s", data, wantArgs)
+	}
+
+	if efi := clone.EFI; efi.Vars != filepath.Join(dir, "web1-VARS.fd") || efi.VarsTemplate != template.EFI.Vars {
+		t.Errorf("clone EFI = %+v", efi)
+	}
+	if tpm := clone.TPM; tpm.StateDir != filepath.Join(dir, "web1-tpm") {
+		t.Errorf("clone TPM state = %s", tpm.StateDir)
+	}
+	if err := clone.Validate(); err != nil {
+		t.Errorf("clone does not validate: %v", err)
+	}
+
+	// The overlays of a clone with the same name exist already
+	if _, err := CloneVM(context.Background(), template, "web1", &CloneOptions{Dir: dir, QemuImgPath: qemuImg}); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("CloneVM over an existing clone = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "web1-root.qcow2")); err != nil {
+		t.Errorf("existing overlay removed: %v", err)
+	}
+}
+
+func TestCloneVMFailure(t *testing.T) {
+	qemuImg, _ := fakeOverlayImg(t)
+	dir := t.TempDir()
+
+	template := DefaultVMConfig()
+	template.Disks = []*DiskConfig{
+		{ID: "root", Backend: &FileDiskBackend{Path: "/images/root.img"}},
+		{ID: "net", Backend: &NBDDiskBackend{Host: "storage", Export: "root"}},
+	}
+	if _, err := CloneVM(context.Background(), template, "web2", &CloneOptions{Dir: dir, QemuImgPath: qemuImg}); err == nil {
+		t.Fatal("CloneVM cloned a writable NBD disk")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "web2-root.qcow2")); !os.IsNotExist(err) {
+		t.Error("overlay not removed after failure")
+	}
+
+	template.Disks[1] = &DiskConfig{ID: "data"}
+	if _, err := CloneVM(context.Background(), template, "web2", &CloneOptions{Dir: dir, QemuImgPath: qemuImg}); err == nil || err.Error() != "disk data has no backend" {
+		t.Errorf("CloneVM of a disk without backend = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "web2-root.qcow2")); !os.IsNotExist(err) {
+		t.Error("overlay not removed after failure")
+	}
+
+	if _, err := CloneVM(context.Background(), template, "", nil); err == nil {
+		t.Error("CloneVM accepted an empty name")
+	}
+}
+
+func TestVMConfigUUID(t *testing.T) {
+	cfg := DefaultVMConfig()
+	cfg.UUID = "6f1c3b9e-2a4d-4e8f-9b1a-0c2d3e4f5a6b"
+	args := NewVMBuilder(cfg).Build("vm", "/run/qemu/vm.sock")
+	if !strings.Contains(strings.Join(args, " "), "-uuid "+cfg.UUID) {
+		t.Errorf("expected -uuid in: %s", strings.Join(args, " "))
+	}
+
+	parsed, err := ParseArgs(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.UUID != cfg.UUID {
+		t.Errorf("parsed UUID = %q", parsed.UUID)
+	}
+
+	cfg.UUID = "not-a-uuid"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate accepted an invalid UUID")
+	}
+}
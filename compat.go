@@ -0,0 +1,121 @@
+package qemuctl
+
+import (
+	"strconv"
+	"strings"
+)
+
+// optionShim rewrites one option and its value for a given QEMU version.
+type optionShim func(v QemuVersion, opt, value string) (string, string)
+
+// optionShims maps command-line options to their compatibility shims.
+// The builder writes options for current QEMU; shims adapt them to the
+// target version so a single VMConfig works across QEMU 6 to 9.
+var optionShims = map[string][]optionShim{
+	"-chardev":  {shimReconnect, shimShortBooleans},
+	"-netdev":   {shimReconnect},
+	"-spice":    {shimShortBooleans},
+	"-vnc":      {shimShortBooleans},
+	"-run-with": {shimRunWithChroot},
+}
+
+// shortBooleans are options that older configs may give without a value.
+var shortBooleans = map[string]string{
+	"server":            "server=on",
+	"nowait":            "wait=off",
+	"disable-ticketing": "disable-ticketing=on",
+	"password":          "password=on",
+}
+
+// translateArgs applies the option shims for version v to args. A zero
+// version leaves args unchanged.
+func translateArgs(args []string, v QemuVersion) []string {
+	if v.IsZero() {
+		return args
+	}
+
+	out := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		shims, ok := optionShims[args[i]]
+		if !ok || i+1 >= len(args) {
+			out = append(out, args[i])
+			continue
+		}
+
+		opt, value := args[i], args[i+1]
+		i++
+		for _, shim := range shims {
+			opt, value = shim(v, opt, value)
+		}
+		out = append(out, opt, value)
+	}
+	return out
+}
+
+// rewriteOpts applies fn to each comma-separated part of value, keeping
+// ",," escapes intact.
+func rewriteOpts(value string, fn func(part string) string) string {
+	parts := splitOpts(value)
+	for idx, part := range parts {
+		parts[idx] = strings.ReplaceAll(fn(part), ",", ",,")
+	}
+	return strings.Join(parts, ",")
+}
+
+// shimReconnect converts between reconnect (seconds) and reconnect-ms,
+// which replaced it in QEMU 9.2.
+func shimReconnect(v QemuVersion, opt, value string) (string, string) {
+	return opt, rewriteOpts(value, func(part string) string {
+		key, val, _ := strings.Cut(part, "=")
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return part
+		}
+
+		switch {
+		case key == "reconnect" && v.AtLeast(9, 2):
+			return "reconnect-ms=" + strconv.Itoa(n*1000)
+		case key == "reconnect-ms" && !v.AtLeast(9, 2):
+			// Round up so a non-zero delay stays enabled
+			return "reconnect=" + strconv.Itoa((n+999)/1000)
+		}
+		return part
+	})
+}
+
+// shimShortBooleans expands short-form booleans ("server", "nowait"),
+// which are deprecated since QEMU 6.0.
+func shimShortBooleans(v QemuVersion, opt, value string) (string, string) {
+	if !v.AtLeast(6, 0) {
+		return opt, value
+	}
+
+	parts := splitOpts(value)
+	for idx, part := range parts {
+		// The first part is the backend or address, not an option
+		if idx == 0 && opt != "-spice" {
+			continue
+		}
+		if full, ok := shortBooleans[part]; ok {
+			parts[idx] = full
+		}
+	}
+
+	for idx, part := range parts {
+		parts[idx] = strings.ReplaceAll(part, ",", ",,")
+	}
+	return opt, strings.Join(parts, ",")
+}
+
+// shimRunWithChroot falls back to -chroot before -run-with existed (QEMU 8.1).
+func shimRunWithChroot(v QemuVersion, opt, value string) (string, string) {
+	if v.AtLeast(8, 1) {
+		return opt, value
+	}
+
+	dir, ok := strings.CutPrefix(value, "chroot=")
+	if !ok || strings.Contains(value, ",") {
+		return opt, value
+	}
+	return "-chroot", dir
+}
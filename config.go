@@ -2,8 +2,10 @@ package qemuctl
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"runtime"
 )
 
 // Config holds the configuration for launching a QEMU instance.
@@ -41,8 +43,9 @@ type Config struct {
 	// Defaults to "host" if KVM is available.
 	CPU string
 
-	// KVM enables KVM acceleration if available.
-	// Defaults to true.
+	// KVM enables hardware acceleration: KVM, or hvf on macOS. When unset,
+	// it is used if it can run the guest and QEMU falls back to tcg
+	// otherwise; true requires it.
 	KVM *bool
 
 	// Drives is a list of drive configurations.
@@ -61,11 +64,39 @@ type Config struct {
 	// ExtraArgs are additional command-line arguments.
 	ExtraArgs []string
 
+	// OutputLog is a file QEMU's stdout and stderr are appended to, for
+	// the lifetime of the process.
+	OutputLog string
+
+	// Output also receives QEMU's stdout and stderr, from another
+	// goroutine.
+	Output io.Writer
+
+	// RunAsUser starts QEMU as this user (name or UID) rather than root.
+	// The user needs access to the drives, /dev/kvm and SocketDir.
+	// Requires root.
+	RunAsUser string
+
+	// RunAsGroup is the group (name or GID) QEMU runs as, by default the
+	// primary group of RunAsUser.
+	RunAsGroup string
+
+	// Chroot makes QEMU chroot into this directory once initialized.
+	Chroot string
+
+	// Sandbox enables QEMU's seccomp filter.
+	Sandbox *SandboxConfig
+
 	// NoDefaults disables QEMU's default devices.
 	// Defaults to true.
 	NoDefaults *bool
 
-	// Daemonize runs QEMU in the background.
+	// Daemonize runs QEMU in a session of its own, which the context
+	// given to StartContext only bounds the start of, with a PID file next
+	// to the control socket. QEMU then outlives the managing process,
+	// which can reattach with LoadInstance once restarted. QEMU's stdout
+	// and stderr then go straight to OutputLog, or /dev/null if unset, and
+	// not to Output; warnings and start errors are read back from the log.
 	// Note: This is handled by the library, not QEMU's -daemonize.
 	Daemonize bool
 }
@@ -161,6 +192,14 @@ func (c *Config) Validate() error {
 	if c.CPUs <= 0 {
 		return fmt.Errorf("CPUs must be positive")
 	}
+	if c.Sandbox != nil {
+		if err := c.Sandbox.validate(); err != nil {
+			return err
+		}
+	}
+	if err := checkRunAs(c.RunAsUser, c.RunAsGroup); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -185,6 +224,24 @@ func defaultSocketDir() (string, error) {
 	return filepath.Join(cacheDir, "qemuctl"), nil
 }
 
+// maxSocketPath returns the longest unix socket path. sun_path holds 108
+// bytes on Linux and 104 on macOS and the BSDs, including the NUL.
+func maxSocketPath() int {
+	if runtime.GOOS == "linux" {
+		return 107
+	}
+	return 103
+}
+
+// checkSocketPath checks that path fits in a unix socket address. The user
+// cache directory on macOS (~/Library/Caches) leaves little room for names.
+func checkSocketPath(path string) error {
+	if len(path) > maxSocketPath() {
+		return fmt.Errorf("socket path %s is too long for a unix socket (%d bytes, at most %d); use a shorter name or SocketDir", path, len(path), maxSocketPath())
+	}
+	return nil
+}
+
 // ensureSocketDir creates the socket directory if it doesn't exist.
 func (c *Config) ensureSocketDir() (string, error) {
 	dir, err := c.socketDir()
@@ -0,0 +1,169 @@
+package qemuctl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ConflictError is returned by Supervisor.Start when a VM claims a MAC
+// address or host forward port that another managed VM already uses.
+type ConflictError struct {
+	// Resource is "MAC address" or "host port".
+	Resource string
+
+	// Value is the conflicting address or port, e.g. "tcp/127.0.0.1:2222".
+	Value string
+
+	// Holder is the name of the VM that has it, empty if the VM being
+	// started claims it twice.
+	Holder string
+}
+
+func (e *ConflictError) Error() string {
+	if e.Holder == "" {
+		return fmt.Sprintf("%s %s is used twice", e.Resource, e.Value)
+	}
+	return fmt.Sprintf("%s %s is already used by %s", e.Resource, e.Value, e.Holder)
+}
+
+// netClaim is a network resource a VM needs exclusively: a MAC address, or
+// a host port of a user-mode forward.
+type netClaim struct {
+	mac string
+
+	proto string
+	addr  string // empty for all addresses
+	port  int
+}
+
+// conflicts reports whether two claims cannot be held at once. A port
+// bound on all addresses conflicts with the same port on any address.
+func (c netClaim) conflicts(o netClaim) bool {
+	if c.mac != "" || o.mac != "" {
+		return c.mac == o.mac
+	}
+	return c.proto == o.proto && c.port == o.port &&
+		(c.addr == "" || o.addr == "" || c.addr == o.addr)
+}
+
+func (c netClaim) conflictError(holder string) *ConflictError {
+	if c.mac != "" {
+		return &ConflictError{Resource: "MAC address", Value: c.mac, Holder: holder}
+	}
+	addr := c.addr
+	if addr == "" {
+		addr = "*"
+	}
+	return &ConflictError{Resource: "host port", Value: c.proto + "/" + addr + ":" + strconv.Itoa(c.port), Holder: holder}
+}
+
+// vmNetClaims returns the MAC addresses and host forward ports of a
+// configuration. It fails on forwards it cannot parse.
+func vmNetClaims(cfg *VMConfig) ([]netClaim, error) {
+	var claims []netClaim
+	for _, net := range cfg.Networks {
+		if net.MACAddr != "" {
+			claims = append(claims, netClaim{mac: strings.ToLower(net.MACAddr)})
+		}
+		user, ok := net.Backend.(*UserNetBackend)
+		if !ok {
+			continue
+		}
+		for _, fwd := range user.Hostfwd {
+			c, err := parseHostfwd(fwd)
+			if err != nil {
+				return nil, fmt.Errorf("network %s: %w", net.ID, err)
+			}
+			claims = append(claims, c)
+		}
+	}
+	return claims, nil
+}
+
+// parseHostfwd parses the host side of a hostfwd rule,
+// "[tcp|udp]:[hostaddr]:hostport-[guestaddr]:guestport".
+func parseHostfwd(rule string) (netClaim, error) {
+	host, _, ok := strings.Cut(rule, "-")
+	if !ok {
+		return netClaim{}, fmt.Errorf("invalid hostfwd rule %q", rule)
+	}
+	return parseHostfwdHost(host)
+}
+
+// parseHostfwdHost parses the host side of a hostfwd rule,
+// "[tcp|udp]:[hostaddr]:hostport".
+func parseHostfwdHost(host string) (netClaim, error) {
+	proto, rest, ok := strings.Cut(host, ":")
+	i := strings.LastIndexByte(rest, ':')
+	if !ok || i < 0 {
+		return netClaim{}, fmt.Errorf("invalid hostfwd rule %q", host)
+	}
+	if proto == "" {
+		proto = "tcp"
+	}
+	port, err := strconv.Atoi(rest[i+1:])
+	if err != nil || port <= 0 || port > 65535 {
+		return netClaim{}, fmt.Errorf("invalid host port in hostfwd rule %q", host)
+	}
+
+	addr := rest[:i]
+	if addr == "0.0.0.0" {
+		addr = ""
+	}
+	return netClaim{proto: proto, addr: addr, port: port}, nil
+}
+
+// checkClaims returns a ConflictError if claims collide with each other or
+// with those of the current reservations. s.mu must be held.
+func (s *Supervisor) checkClaims(claims []netClaim) error {
+	for i, c := range claims {
+		for _, prev := range claims[:i] {
+			if c.conflicts(prev) {
+				return c.conflictError("")
+			}
+		}
+		for r := range s.reservations {
+			for _, held := range r.claims {
+				if c.conflicts(held) {
+					return c.conflictError(r.Name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// claim adds a claim to the reservation of inst, if the supervisor manages
+// it, or returns a ConflictError if another VM holds it.
+func (s *Supervisor) claim(inst *Instance, c netClaim) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := s.instances[inst]
+	if res == nil {
+		return nil
+	}
+	if err := s.checkClaims([]netClaim{c}); err != nil {
+		return err
+	}
+	res.claims = append(res.claims, c)
+	return nil
+}
+
+// unclaim removes a claim from the reservation of inst.
+func (s *Supervisor) unclaim(inst *Instance, c netClaim) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := s.instances[inst]
+	if res == nil {
+		return
+	}
+	for n, held := range res.claims {
+		if held == c {
+			res.claims = append(res.claims[:n:n], res.claims[n+1:]...)
+			return
+		}
+	}
+}
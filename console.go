@@ -0,0 +1,186 @@
+package qemuctl
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// defaultConsoleLogSize is the ring buffer size of console logs.
+const defaultConsoleLogSize = 256 << 10
+
+// ConsoleLog keeps the most recent output of a serial console in a ring
+// buffer. Readers can fetch what is buffered and follow new output; it
+// implements io.Writer to be fed, and http.Handler to serve web UIs a live
+// console without VNC.
+type ConsoleLog struct {
+	mu      sync.Mutex
+	buf     []byte
+	written int64         // total bytes written, the offset of the next byte
+	notify  chan struct{} // closed and replaced on each write
+	closed  bool
+}
+
+// NewConsoleLog returns a console log keeping the last size bytes of
+// output (256 KiB if size is 0 or less).
+func NewConsoleLog(size int) *ConsoleLog {
+	if size <= 0 {
+		size = defaultConsoleLogSize
+	}
+	return &ConsoleLog{buf: make([]byte, size), notify: make(chan struct{})}
+}
+
+// Write appends console output, overwriting the oldest data once the
+// buffer is full.
+func (c *ConsoleLog) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return 0, io.ErrClosedPipe
+	}
+
+	n := len(p)
+	if len(p) > len(c.buf) {
+		// Only the tail can be kept
+		c.written += int64(len(p) - len(c.buf))
+		p = p[len(p)-len(c.buf):]
+	}
+	for len(p) > 0 {
+		pos := int(c.written % int64(len(c.buf)))
+		copied := copy(c.buf[pos:], p)
+		p = p[copied:]
+		c.written += int64(copied)
+	}
+
+	close(c.notify)
+	c.notify = make(chan struct{})
+	return n, nil
+}
+
+// Close ends the log: writes fail and followers stop once they have read
+// everything.
+func (c *ConsoleLog) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.closed {
+		c.closed = true
+		close(c.notify)
+	}
+	return nil
+}
+
+// Bytes returns the buffered output.
+func (c *ConsoleLog) Bytes() []byte {
+	data, _, _, _ := c.readFrom(0)
+	return data
+}
+
+// readFrom returns the buffered output from offset off, the offset after
+// it, a channel closed on the next write, and whether the log is closed.
+// Output that has been overwritten is skipped.
+func (c *ConsoleLog) readFrom(off int64) ([]byte, int64, <-chan struct{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	size := int64(len(c.buf))
+	off = max(off, c.written-size, 0)
+	data := make([]byte, 0, c.written-off)
+	for off < c.written {
+		pos := off % size
+		end := min(size, pos+c.written-off)
+		data = append(data, c.buf[pos:end]...)
+		off += end - pos
+	}
+	return data, c.written, c.notify, c.closed
+}
+
+// ServeHTTP serves the buffered output as plain text. Query parameters:
+//
+//   - tail=N returns only the last N bytes
+//   - follow=1 keeps the response open and streams new output until the
+//     client disconnects or the log is closed
+func (c *ConsoleLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var off int64
+	if tail := r.URL.Query().Get("tail"); tail != "" {
+		n, err := strconv.ParseInt(tail, 10, 64)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid tail", http.StatusBadRequest)
+			return
+		}
+		c.mu.Lock()
+		off = c.written - n
+		c.mu.Unlock()
+	}
+	follow, _ := strconv.ParseBool(r.URL.Query().Get("follow"))
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	for {
+		data, next, notify, closed := c.readFrom(off)
+		off = next
+		if len(data) > 0 {
+			if _, err := w.Write(data); err != nil {
+				return
+			}
+		}
+		if !follow || closed {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		select {
+		case <-notify:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// CaptureSerial connects to the serial port at index in the VM
+// configuration, which must be a unix socket server chardev, and copies
+// its output to a new console log of size bytes (see NewConsoleLog). The
+// log is closed when QEMU closes the socket.
+//
+// QEMU serves one client per chardev socket, so nothing else can use the
+// console while it is captured.
+func (i *Instance) CaptureSerial(index, size int) (*ConsoleLog, error) {
+	if i.vmConfig == nil || index < 0 || index >= len(i.vmConfig.Serials) {
+		return nil, fmt.Errorf("serial port %d not configured", index)
+	}
+	serial := i.vmConfig.Serials[index]
+	if serial.Type != "socket" || !serial.Server || serial.Path == "" {
+		return nil, fmt.Errorf("serial port %d is not a unix socket server", index)
+	}
+
+	conn, err := net.Dial("unix", serial.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to serial port %d: %w", index, err)
+	}
+
+	log := NewConsoleLog(size)
+	go func() {
+		defer conn.Close()
+		defer log.Close()
+		io.Copy(log, conn)
+	}()
+	return log, nil
+}
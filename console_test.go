@@ -0,0 +1,130 @@
+package qemuctl
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConsoleLogRing(t *testing.T) {
+	log := NewConsoleLog(8)
+
+	log.Write([]byte("abc"))
+	if got := string(log.Bytes()); got != "abc" {
+		t.Errorf("Bytes() = %q, want %q", got, "abc")
+	}
+
+	log.Write([]byte("defghij"))
+	if got := string(log.Bytes()); got != "cdefghij" {
+		t.Errorf("Bytes() after wrap = %q, want %q", got, "cdefghij")
+	}
+
+	log.Write([]byte("0123456789"))
+	if got := string(log.Bytes()); got != "23456789" {
+		t.Errorf("Bytes() after oversized write = %q, want %q", got, "23456789")
+	}
+
+	log.Close()
+	if _, err := log.Write([]byte("x")); err == nil {
+		t.Error("Write after Close succeeded")
+	}
+}
+
+func TestConsoleLogHTTP(t *testing.T) {
+	log := NewConsoleLog(0)
+	log.Write([]byte("line 1\nline 2\n"))
+
+	srv := httptest.NewServer(log)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "?tail=7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "line 2\n" {
+		t.Errorf("tail body = %q, want %q", body, "line 2\n")
+	}
+
+	// Follow mode streams new output until the log is closed
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"?follow=1", nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	r := bufio.NewReader(resp.Body)
+	for _, want := range []string{"line 1\n", "line 2\n"} {
+		if line, err := r.ReadString('\n'); err != nil || line != want {
+			t.Fatalf("read %q, %v; want %q", line, err, want)
+		}
+	}
+
+	log.Write([]byte("line 3\n"))
+	if line, err := r.ReadString('\n'); err != nil || line != "line 3\n" {
+		t.Fatalf("followed %q, %v; want %q", line, err, "line 3\n")
+	}
+
+	log.Close()
+	if rest, err := io.ReadAll(r); err != nil || len(rest) != 0 {
+		t.Errorf("after close read %q, %v", rest, err)
+	}
+}
+
+func TestCaptureSerial(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "serial.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	inst := &Instance{vmConfig: &VMConfig{Serials: []*SerialConfig{
+		{Type: "pty"},
+		{Type: "socket", Path: path, Server: true},
+	}}}
+
+	if _, err := inst.CaptureSerial(0, 0); err == nil {
+		t.Error("CaptureSerial of a pty succeeded")
+	}
+	if _, err := inst.CaptureSerial(2, 0); err == nil {
+		t.Error("CaptureSerial of a missing port succeeded")
+	}
+
+	log, err := inst.CaptureSerial(1, 0)
+	if err != nil {
+		t.Fatalf("CaptureSerial failed: %v", err)
+	}
+
+	// QEMU side of the chardev
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("Booting Linux...\n"))
+	conn.Close()
+
+	_, _, notify, closed := log.readFrom(0)
+	for !closed {
+		select {
+		case <-notify:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for console output")
+		}
+		_, _, notify, closed = log.readFrom(0)
+	}
+	if got := string(log.Bytes()); !strings.Contains(got, "Booting Linux") {
+		t.Errorf("captured %q", got)
+	}
+}
@@ -0,0 +1,69 @@
+package qemuctl
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// VMController is the set of Instance methods used to inspect and control
+// a VM. Code that accepts a VMController instead of an *Instance can be
+// tested against a fake such as qemuctltest.Controller, without starting
+// QEMU.
+//
+// Methods returning protocol clients (QMP, GuestAgent, Subscribe) are not
+// part of the interface, since they are tied to a live connection.
+type VMController interface {
+	// Identity and configuration
+	Name() string
+	PID() int
+	SocketPath() string
+	VMConfig() *VMConfig
+	Warnings() []string
+	BootTimings() BootTimings
+
+	// State and events
+	State() State
+	QueryState() error
+	SetStateChangeCallback(cb func(State))
+	SetEventCallback(cb func(*Event))
+	Events() <-chan *Event
+
+	// Lifecycle
+	Continue() error
+	Pause() error
+	Reset() error
+	Shutdown() error
+	Stop(timeout time.Duration) error
+	StopContext(ctx context.Context, timeout time.Duration) error
+	ForceStop() error
+	Quit() error
+	Wait() error
+	WaitContext(ctx context.Context) error
+
+	// Monitor commands
+	ExecuteBatch(cmds []BatchCommand) ([]BatchResult, error)
+	HumanMonitorCommand(cmd string) (string, error)
+	SendKey(keys ...string) error
+	Screendump(filename string) error
+
+	// Block devices
+	QueryBlockDevices() ([]BlockInfo, error)
+	QueryNamedBlockNodes(flat bool) ([]BlockNode, error)
+	QueryQMPSchema() ([]QMPSchemaEntry, error)
+	SetIOThrottle(device string, bps, iops uint64) error
+	BlockdevAdd(options map[string]any) error
+	BlockdevDel(nodeName string) error
+	BlockdevCreate(ctx context.Context, options map[string]any) error
+	CreateImage(ctx context.Context, nodeName string, backend DiskBackend, opts *LiveImageOptions) error
+
+	// Display
+	AddVNCClient(conn net.Conn, skipAuth bool) error
+	AddSpiceClient(conn net.Conn, skipAuth bool) error
+	SetVNCPassword(password string) error
+	SetSpicePassword(password string) error
+	ExpireVNCPassword(expireTime string) error
+	ExpireSpicePassword(expireTime string) error
+}
+
+var _ VMController = (*Instance)(nil)
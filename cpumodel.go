@@ -0,0 +1,145 @@
+package qemuctl
+
+import (
+	"fmt"
+	"sort"
+)
+
+// CPU model expansion types, for ExpandCPUModel.
+const (
+	// CPUModelExpansionStatic expands to a static base model that means the
+	// same on every QEMU release and host, plus properties.
+	CPUModelExpansionStatic = "static"
+
+	// CPUModelExpansionFull keeps the model name and lists all properties.
+	CPUModelExpansionFull = "full"
+)
+
+// CPUModel is a CPU model with property overrides, as used by the
+// query-cpu-model-* commands.
+type CPUModel struct {
+	// Name is the model name, e.g. "host" or "Skylake-Server".
+	Name string `json:"name"`
+
+	// Props holds the properties, mostly feature flags set to true or
+	// false.
+	Props map[string]any `json:"props,omitempty"`
+}
+
+// Features returns the properties as CPUConfig.Features ("name=on",
+// "name=off" or "name=value"), sorted by name.
+func (m *CPUModel) Features() []string {
+	names := make([]string, 0, len(m.Props))
+	for name := range m.Props {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	features := make([]string, 0, len(names))
+	for _, name := range names {
+		switch v := m.Props[name].(type) {
+		case bool:
+			if v {
+				features = append(features, name+"=on")
+			} else {
+				features = append(features, name+"=off")
+			}
+		default:
+			features = append(features, fmt.Sprintf("%s=%v", name, v))
+		}
+	}
+	return features
+}
+
+// ExpandCPUModel resolves model on the running QEMU and host. Expanding
+// "host" with CPUModelExpansionStatic on each host of a cluster gives
+// models that BaselineCPUModels can combine. Supported on x86, Arm, RISC-V
+// and s390x.
+func (i *Instance) ExpandCPUModel(expansion string, model CPUModel) (*CPUModel, error) {
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return nil, ErrNotConnected
+	}
+
+	result, err := qmp.Execute("query-cpu-model-expansion", map[string]any{
+		"type":  expansion,
+		"model": model,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var info struct {
+		Model CPUModel `json:"model"`
+	}
+	if err := unmarshalJSON(result, &info); err != nil {
+		return nil, err
+	}
+	return &info.Model, nil
+}
+
+// BaselineCPUModel asks QEMU for a model that runs everywhere a and b run.
+// QEMU only implements it for s390x; use BaselineCPUModels for other
+// architectures.
+func (i *Instance) BaselineCPUModel(a, b CPUModel) (*CPUModel, error) {
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return nil, ErrNotConnected
+	}
+
+	result, err := qmp.Execute("query-cpu-model-baseline", map[string]any{
+		"modela": a,
+		"modelb": b,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var info struct {
+		Model CPUModel `json:"model"`
+	}
+	if err := unmarshalJSON(result, &info); err != nil {
+		return nil, err
+	}
+	return &info.Model, nil
+}
+
+// BaselineCPUModels computes a model that runs on every host, from the
+// static expansions of their "host" model: a feature is enabled only if
+// all hosts have it, and other properties, such as the family, are kept
+// only if all hosts agree. The models must share a base model name.
+func BaselineCPUModels(models ...CPUModel) (*CPUModel, error) {
+	if len(models) == 0 {
+		return nil, fmt.Errorf("no CPU models to baseline")
+	}
+
+	base := &CPUModel{Name: models[0].Name, Props: make(map[string]any)}
+	for name, v := range models[0].Props {
+		base.Props[name] = v
+	}
+
+	for _, m := range models[1:] {
+		if m.Name != base.Name {
+			return nil, fmt.Errorf("cannot baseline CPU models %s and %s", base.Name, m.Name)
+		}
+		for name, v := range base.Props {
+			other, ok := m.Props[name]
+			switch v := v.(type) {
+			case bool:
+				on, _ := other.(bool)
+				base.Props[name] = v && on
+			default:
+				if !ok || other != v {
+					delete(base.Props, name)
+				}
+			}
+		}
+	}
+	return base, nil
+}
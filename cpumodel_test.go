@@ -0,0 +1,53 @@
+package qemuctl
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpandCPUModel(t *testing.T) {
+	fake := newFakeQMP(t)
+	fake.handle("query-cpu-model-expansion", func(args map[string]any) (any, *qmpError) {
+		return map[string]any{"model": map[string]any{
+			"name":  "base",
+			"props": map[string]any{"avx2": true, "svm": false, "family": 6},
+		}}, nil
+	})
+	inst := fake.attach()
+
+	model, err := inst.ExpandCPUModel(CPUModelExpansionStatic, CPUModel{Name: "host"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := fake.commands("query-cpu-model-expansion")[0]
+	if args["type"] != "static" || args["model"].(map[string]any)["name"] != "host" {
+		t.Errorf("query-cpu-model-expansion arguments = %v", args)
+	}
+	if model.Name != "base" || model.Props["avx2"] != true {
+		t.Errorf("expanded model = %+v", model)
+	}
+	if got, want := model.Features(), []string{"avx2=on", "family=6", "svm=off"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Features() = %v, want %v", got, want)
+	}
+}
+
+func TestBaselineCPUModels(t *testing.T) {
+	a := CPUModel{Name: "base", Props: map[string]any{"avx2": true, "avx512f": true, "vmx": true, "family": 6.0, "model": 85.0}}
+	b := CPUModel{Name: "base", Props: map[string]any{"avx2": true, "avx512f": false, "family": 6.0, "model": 63.0}}
+
+	base, err := BaselineCPUModels(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{"avx2": true, "avx512f": false, "vmx": false, "family": 6.0}
+	if !reflect.DeepEqual(base.Props, want) {
+		t.Errorf("baseline props = %v, want %v", base.Props, want)
+	}
+	if a.Props["avx512f"] != true {
+		t.Error("BaselineCPUModels modified its input")
+	}
+
+	if _, err := BaselineCPUModels(a, CPUModel{Name: "z14"}); err == nil {
+		t.Error("BaselineCPUModels accepted different base models")
+	}
+}
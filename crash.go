@@ -0,0 +1,190 @@
+package qemuctl
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// crashDumpTimeout bounds dump-guest-memory, which writes the whole guest
+// memory before returning.
+const crashDumpTimeout = 10 * time.Minute
+
+// CrashCapture is the policy a Supervisor applies when a guest it manages
+// panics: the guest is paused, its memory dumped for post-mortem analysis,
+// and optionally restarted.
+type CrashCapture struct {
+	// Dir receives a memory dump per crash, named
+	// "<vm>-<YYYYMMDD-HHMMSS>.<ext>". Empty disables dumps. The path is
+	// opened by QEMU, so it must be reachable from a chrooted process.
+	Dir string
+
+	// Format is the dump format: "elf" (default, readable by crash and
+	// gdb), "kdump-zlib", "kdump-lzo", "kdump-snappy" or "win-dmp".
+	Format string
+
+	// Restart resets and resumes the guest once the dump is written. The
+	// QEMU process and the Instance are kept.
+	Restart bool
+
+	// OnCrash is called once the crash has been handled.
+	OnCrash func(inst *Instance, report *CrashReport)
+}
+
+// CrashReport describes a guest crash handled by a Supervisor.
+type CrashReport struct {
+	VM   string
+	Time time.Time
+
+	// Panic holds the details of the GUEST_PANICKED event.
+	Panic *GuestPanickedEvent
+
+	// DumpPath is the memory dump, empty if none was written.
+	DumpPath string
+
+	// Restarted reports whether the guest was reset and resumed.
+	Restarted bool
+
+	// Err is the first error encountered while handling the crash.
+	Err error
+}
+
+// SetCrashCapture sets the policy applied to guest panics, or disables
+// crash handling if policy is nil. VMs started afterwards without a
+// VMConfig.Panic get a pvpanic device, and the "pause" panic action unless
+// one is configured, so the guest is kept intact until the dump is taken.
+func (s *Supervisor) SetCrashCapture(policy *CrashCapture) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.crash = policy
+}
+
+// crashConfig returns cfg with the pvpanic device crash capture relies on.
+func (s *Supervisor) crashConfig(cfg *VMConfig) *VMConfig {
+	s.mu.Lock()
+	policy := s.crash
+	s.mu.Unlock()
+
+	if policy == nil || cfg == nil || (cfg.Panic != nil && cfg.Panic.Action != "") {
+		return cfg
+	}
+
+	c := *cfg
+	panicCfg := PanicConfig{}
+	if cfg.Panic != nil {
+		panicCfg = *cfg.Panic
+	}
+	panicCfg.Action = "pause"
+	c.Panic = &panicCfg
+	return &c
+}
+
+// handleCrash applies the crash capture policy to a GUEST_PANICKED event,
+// and reports whether the policy handled the crash and whether it resumed
+// the guest.
+func (s *Supervisor) handleCrash(inst *Instance, event *Event) (handled, restarted bool) {
+	s.mu.Lock()
+	policy := s.crash
+	s.mu.Unlock()
+
+	if policy == nil {
+		return false, false
+	}
+
+	report := &CrashReport{VM: inst.Name(), Time: event.Timestamp}
+	report.Panic, _ = event.Payload().(*GuestPanickedEvent)
+	if report.Panic == nil {
+		report.Panic = &GuestPanickedEvent{}
+	}
+	fail := func(err error) {
+		if report.Err == nil {
+			report.Err = err
+		}
+	}
+
+	if report.Panic.Action != "pause" {
+		if err := inst.Pause(); err != nil {
+			fail(fmt.Errorf("failed to pause crashed guest: %w", err))
+		}
+	}
+
+	if policy.Dir != "" && report.Err == nil {
+		path, err := writeCrashDump(inst, policy, report.Time)
+		if err != nil {
+			fail(err)
+		} else {
+			report.DumpPath = path
+		}
+	}
+
+	if policy.Restart {
+		if err := inst.Reset(); err != nil {
+			fail(err)
+		} else if err := inst.Continue(); err != nil {
+			fail(fmt.Errorf("failed to resume guest: %w", err))
+		} else {
+			report.Restarted = true
+		}
+	}
+
+	if policy.OnCrash != nil {
+		policy.OnCrash(inst, report)
+	}
+	return true, report.Restarted
+}
+
+// writeCrashDump dumps the guest memory to the crash directory.
+func writeCrashDump(inst *Instance, policy *CrashCapture, t time.Time) (string, error) {
+	if err := os.MkdirAll(policy.Dir, 0o700); err != nil {
+		return "", fmt.Errorf("failed to create crash dump directory: %w", err)
+	}
+
+	format := policy.Format
+	if format == "" {
+		format = "elf"
+	}
+	ext := format
+	switch {
+	case strings.HasPrefix(format, "kdump-"):
+		ext = "kdump"
+	case format == "win-dmp":
+		ext = "dmp"
+	}
+	if t.IsZero() {
+		t = time.Now()
+	}
+	path := filepath.Join(policy.Dir, inst.Name()+"-"+t.Format("20060102-150405")+"."+ext)
+
+	if err := inst.DumpGuestMemory(path, format); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// DumpGuestMemory writes the guest memory to path, in the given
+// dump-guest-memory format ("elf" if empty). The guest should be paused
+// for a consistent dump. The call blocks until the dump is written.
+func (i *Instance) DumpGuestMemory(path, format string) error {
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return ErrNotConnected
+	}
+	if format == "" {
+		format = "elf"
+	}
+
+	_, err := qmp.ExecuteWithTimeout("dump-guest-memory", map[string]any{
+		"paging":   false,
+		"protocol": "file:" + path,
+		"format":   format,
+	}, crashDumpTimeout)
+	if err != nil {
+		return fmt.Errorf("dump-guest-memory failed: %w", err)
+	}
+	return nil
+}
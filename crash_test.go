@@ -0,0 +1,71 @@
+package qemuctl
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCrashConfig(t *testing.T) {
+	s := NewSupervisor(Resources{}, OvercommitRatios{})
+	cfg := &VMConfig{Name: "vm"}
+	if got := s.crashConfig(cfg); got != cfg {
+		t.Errorf("config changed without crash capture")
+	}
+
+	s.SetCrashCapture(&CrashCapture{})
+	got := s.crashConfig(cfg)
+	if got.Panic == nil || got.Panic.Action != "pause" {
+		t.Errorf("Panic = %+v, want pause action", got.Panic)
+	}
+	if cfg.Panic != nil {
+		t.Errorf("caller config modified")
+	}
+
+	cfg.Panic = &PanicConfig{Action: "none"}
+	if got := s.crashConfig(cfg); got.Panic.Action != "none" {
+		t.Errorf("configured panic action overridden: %+v", got.Panic)
+	}
+}
+
+func TestCrashCapture(t *testing.T) {
+	fake := newFakeQMP(t)
+	for _, cmd := range []string{"stop", "system_reset", "cont"} {
+		fake.handle(cmd, func(map[string]any) (any, *qmpError) { return map[string]any{}, nil })
+	}
+	fake.handle("dump-guest-memory", func(map[string]any) (any, *qmpError) { return map[string]any{}, nil })
+
+	s, inst := supervisedFake(t, fake)
+	dir := t.TempDir()
+	reports := make(chan *CrashReport, 1)
+	s.SetCrashCapture(&CrashCapture{
+		Dir:     dir,
+		Format:  "kdump-zlib",
+		Restart: true,
+		OnCrash: func(_ *Instance, r *CrashReport) { reports <- r },
+	})
+
+	fake.sendEvent("GUEST_PANICKED", map[string]any{"action": "run"})
+
+	var r *CrashReport
+	select {
+	case r = <-reports:
+	case <-time.After(5 * time.Second):
+		t.Fatal("crash not handled")
+	}
+	if r.Err != nil || !r.Restarted || r.VM != inst.Name() {
+		t.Errorf("report = %+v", r)
+	}
+	if filepath.Dir(r.DumpPath) != dir || !strings.HasSuffix(r.DumpPath, ".kdump") {
+		t.Errorf("DumpPath = %q", r.DumpPath)
+	}
+
+	calls := fake.commands("dump-guest-memory")
+	if len(calls) != 1 || calls[0]["protocol"] != "file:"+r.DumpPath || calls[0]["format"] != "kdump-zlib" {
+		t.Errorf("dump-guest-memory calls = %v", calls)
+	}
+	if len(fake.commands("stop")) != 1 || len(fake.commands("system_reset")) != 1 || len(fake.commands("cont")) != 1 {
+		t.Errorf("expected stop, system_reset and cont to be sent once")
+	}
+}
@@ -0,0 +1,50 @@
+package qemuctl
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronErrors(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := parseCron(spec); err == nil {
+			t.Errorf("parseCron(%q) succeeded, want error", spec)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// Wednesday
+	base := time.Date(2024, 5, 15, 10, 30, 45, 0, time.UTC)
+
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2024, 5, 15, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 5, 15, 10, 45, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2024, 5, 16, 3, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, 5, 16, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, 5, 15, 11, 0, 0, 0, time.UTC)},
+		{"0 0 * * 0", time.Date(2024, 5, 19, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 5, 19, 0, 0, 0, 0, time.UTC)},
+		{"30 12 1,20 * *", time.Date(2024, 5, 20, 12, 30, 0, 0, time.UTC)},
+		{"0 0 1 1 *", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// Both day fields restricted: either matches
+		{"0 0 1 * 5", time.Date(2024, 5, 17, 0, 0, 0, 0, time.UTC)},
+		{"0 9-17/4 * * 1-5", time.Date(2024, 5, 15, 13, 0, 0, 0, time.UTC)},
+		// Never matches
+		{"0 0 31 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		s, err := parseCron(tt.spec)
+		if err != nil {
+			t.Errorf("parseCron(%q) error: %v", tt.spec, err)
+			continue
+		}
+		if got := s.next(base); !got.Equal(tt.want) {
+			t.Errorf("next(%q) = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package qemuctl
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/KarpelesLab/runutil"
+)
+
+// pidFilePath returns the PID file of a daemonized VM, next to its control
+// socket.
+func pidFilePath(socketPath string) string {
+	return strings.TrimSuffix(socketPath, ".sock") + ".pid"
+}
+
+// buildPIDFile makes a daemonized QEMU write its PID file, which it
+// removes when it exits.
+func (b *VMBuilder) buildPIDFile(socketPath string) {
+	if !b.config.Daemonize || socketPath == "" {
+		return
+	}
+	b.args = append(b.args, "-pidfile", pidFilePath(socketPath))
+}
+
+// LoadInstance reattaches to the daemonized VM name, whose control socket
+// is in the default socket directory, after the process that started it
+// restarted.
+func LoadInstance(name string) (*Instance, error) {
+	return LoadInstanceContext(context.Background(), "", name)
+}
+
+// LoadInstanceContext reattaches to the daemonized VM name, whose control
+// socket is in socketDir, or the default socket directory if empty. The
+// instance is found from its PID file and attached as with AttachByPID; if
+// the VM is gone, the error wraps ErrNotRunning.
+func LoadInstanceContext(ctx context.Context, socketDir, name string) (*Instance, error) {
+	if socketDir == "" {
+		var err error
+		if socketDir, err = defaultSocketDir(); err != nil {
+			return nil, err
+		}
+	}
+	socketPath := filepath.Join(socketDir, name+".sock")
+	pidFile := pidFilePath(socketPath)
+
+	pid, err := readPIDFile(pidFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: no PID file for %s", ErrNotRunning, name)
+		}
+		return nil, err
+	}
+	if err := syscall.Kill(pid, 0); err == syscall.ESRCH {
+		return nil, fmt.Errorf("%w: stale PID file %s", ErrNotRunning, pidFile)
+	}
+
+	// The PID may have been reused since QEMU was killed without
+	// removing its PID file
+	args, err := runutil.ArgsOf(pid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get process arguments: %w", err)
+	}
+	if findSocketFromArgs(args) != socketPath {
+		return nil, fmt.Errorf("%w: process %d from %s is not VM %s", ErrNotRunning, pid, pidFile, name)
+	}
+
+	return AttachByPIDContext(ctx, pid)
+}
+
+// readPIDFile reads the PID written by QEMU's -pidfile.
+func readPIDFile(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("invalid PID file %s", path)
+	}
+	return pid, nil
+}
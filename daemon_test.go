@@ -0,0 +1,161 @@
+package qemuctl
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVMBuilderDaemonize(t *testing.T) {
+	cfg := &VMConfig{Daemonize: true}
+	args := NewVMBuilder(cfg).Build("web", "/run/qemu/web.sock")
+	if !strings.Contains(strings.Join(args, " "), "-pidfile /run/qemu/web.pid") {
+		t.Errorf("args missing the PID file: %v", args)
+	}
+
+	parsed, err := ParseArgs(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !parsed.Daemonize {
+		t.Error("parsed config is not daemonized")
+	}
+
+	// Other PID files are kept as is
+	parsed, err = ParseArgs(append(NewVMBuilder(&VMConfig{}).Build("web", "/run/qemu/web.sock"), "-pidfile", "/run/web.pid"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Daemonize || strings.Join(parsed.ExtraArgs, " ") != "-pidfile /run/web.pid" {
+		t.Errorf("foreign PID file parsed as Daemonize = %v, ExtraArgs = %v", parsed.Daemonize, parsed.ExtraArgs)
+	}
+}
+
+func TestLoadInstance(t *testing.T) {
+	dir := t.TempDir()
+	socketPath := filepath.Join(dir, "web.sock")
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	startFakeQMP(t, ln, socketPath)
+
+	// A process with the command line of the VM stands in for QEMU
+	cmd := exec.Command("sh", "-c", "read x", "qemu-system-x86_64",
+		"-name", "guest=web,debug-threads=on",
+		"-chardev", "socket,id=qmp,path="+socketPath+",server=on,wait=off")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		stdin.Close()
+		cmd.Wait()
+	})
+
+	pidFile := filepath.Join(dir, "web.pid")
+	writePID := func(pid int) {
+		t.Helper()
+		if err := os.WriteFile(pidFile, []byte(strconv.Itoa(pid)+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writePID(cmd.Process.Pid)
+	inst, err := LoadInstanceContext(context.Background(), dir, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inst.qmp.Close()
+	if inst.Name() != "web" || inst.PID() != cmd.Process.Pid {
+		t.Errorf("loaded instance %s with PID %d, want web with PID %d", inst.Name(), inst.PID(), cmd.Process.Pid)
+	}
+
+	// A PID reused by another process is not taken for the VM
+	writePID(os.Getpid())
+	if _, err := LoadInstanceContext(context.Background(), dir, "web"); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("reused PID: err = %v, want ErrNotRunning", err)
+	}
+
+	exited := exec.Command("true")
+	if err := exited.Run(); err != nil {
+		t.Fatal(err)
+	}
+	writePID(exited.Process.Pid)
+	if _, err := LoadInstanceContext(context.Background(), dir, "web"); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("stale PID file: err = %v, want ErrNotRunning", err)
+	}
+
+	if _, err := LoadInstanceContext(context.Background(), dir, "db"); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("missing PID file: err = %v, want ErrNotRunning", err)
+	}
+}
+
+func TestStartDaemonizedOutput(t *testing.T) {
+	dir := t.TempDir()
+	qemu := filepath.Join(dir, "qemu-system-x86_64")
+	script := "#!/bin/sh\n[ -p /dev/stderr ] && echo 'stderr is a pipe' >&2\n" +
+		"echo 'qemu-system-x86_64: -m 1T: warning: memory size rounded' >&2\n" +
+		"echo \"qemu-system-x86_64: -drive file=missing.img: Could not open 'missing.img'\" >&2\nexit 1\n"
+	if err := os.WriteFile(qemu, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	logPath := filepath.Join(dir, "qemu.log")
+	if err := os.WriteFile(logPath, []byte("previous run\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	_, err := StartVM(&VMConfig{
+		Name:          "bad",
+		Arch:          "amd64",
+		QemuPath:      qemu,
+		SocketDir:     dir,
+		AccelFallback: AccelFallbackTCG,
+		OutputLog:     logPath,
+		Daemonize:     true,
+	})
+	var startErr *StartError
+	if !errors.As(err, &startErr) {
+		t.Fatalf("expected a StartError, got %v", err)
+	}
+	want := []string{
+		"qemu-system-x86_64: -m 1T: warning: memory size rounded",
+		"qemu-system-x86_64: -drive file=missing.img: Could not open 'missing.img'",
+	}
+	if !reflect.DeepEqual(startErr.Output, want) {
+		t.Errorf("start error output = %q, want %q", startErr.Output, want)
+	}
+	// The exit is noticed without waiting for the socket timeout
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("StartVM took %v", d)
+	}
+	data, _ := os.ReadFile(logPath)
+	if !strings.HasPrefix(string(data), "previous run\n") || !strings.Contains(string(data), "missing.img") {
+		t.Errorf("output log has %q", data)
+	}
+
+	// Without a log, the output is discarded
+	_, err = StartVM(&VMConfig{
+		Name:          "bad",
+		Arch:          "amd64",
+		QemuPath:      qemu,
+		SocketDir:     dir,
+		AccelFallback: AccelFallbackTCG,
+		Daemonize:     true,
+	})
+	if !errors.As(err, &startErr) || len(startErr.Output) != 0 {
+		t.Errorf("expected a StartError without output, got %v", err)
+	}
+}
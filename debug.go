@@ -0,0 +1,74 @@
+package qemuctl
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DebugConfig collects QEMU's own diagnostics, for troubleshooting guests
+// that crash or misbehave under emulation rather than in production.
+type DebugConfig struct {
+	// LogFile is where the Log categories and the trace events go (-D).
+	// QEMU's stderr is used if it is empty.
+	LogFile string `json:"log_file,omitempty"`
+
+	// Log lists the categories to log (-d), e.g. "guest_errors" for
+	// invalid accesses by the guest, "unimp" for unimplemented device
+	// features, "int" for interrupts and exceptions or "cpu_reset" for CPU
+	// state at reset. "qemu-system-x86_64 -d help" lists them.
+	Log []string `json:"log,omitempty"`
+
+	// Trace lists the trace events to enable, or patterns such as
+	// "virtio_blk_*", logged with QEMU's log trace backend.
+	Trace []string `json:"trace,omitempty"`
+
+	// TraceEvents is a file listing trace events or patterns to enable,
+	// one per line.
+	TraceEvents string `json:"trace_events,omitempty"`
+}
+
+// validate checks that the categories and events can be passed as options.
+func (cfg *DebugConfig) validate() error {
+	for _, c := range cfg.Log {
+		if c == "" || strings.Contains(c, ",") {
+			return fmt.Errorf("invalid log category %q", c)
+		}
+	}
+	for _, e := range cfg.Trace {
+		if e == "" || strings.Contains(e, ",") {
+			return fmt.Errorf("invalid trace event %q", e)
+		}
+	}
+	return nil
+}
+
+// buildDebugArgs builds the logging and tracing arguments.
+func buildDebugArgs(cfg *DebugConfig) []string {
+	if cfg == nil {
+		return nil
+	}
+
+	var args []string
+	if cfg.LogFile != "" {
+		args = append(args, "-D", cfg.LogFile)
+	}
+	if len(cfg.Log) > 0 {
+		args = append(args, "-d", strings.Join(cfg.Log, ","))
+	}
+	for _, e := range cfg.Trace {
+		args = append(args, "-trace", "enable="+e)
+	}
+	if cfg.TraceEvents != "" {
+		args = append(args, "-trace", "events="+cfg.TraceEvents)
+	}
+	return args
+}
+
+// debugConfig returns the debug configuration of cfg, creating it if
+// needed, for ParseArgs.
+func debugConfig(cfg *VMConfig) *DebugConfig {
+	if cfg.Debug == nil {
+		cfg.Debug = &DebugConfig{}
+	}
+	return cfg.Debug
+}
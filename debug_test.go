@@ -0,0 +1,60 @@
+package qemuctl
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestVMBuilderDebug(t *testing.T) {
+	cfg := &VMConfig{Debug: &DebugConfig{
+		LogFile:     "/var/log/qemu/web-debug.log",
+		Log:         []string{"guest_errors", "unimp"},
+		Trace:       []string{"virtio_blk_*", "qemu_system_reset_request"},
+		TraceEvents: "/etc/qemu/events",
+	}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	args := NewVMBuilder(cfg).Build("test", "")
+	joined := strings.Join(args, " ")
+	want := "-D /var/log/qemu/web-debug.log -d guest_errors,unimp -trace enable=virtio_blk_* " +
+		"-trace enable=qemu_system_reset_request -trace events=/etc/qemu/events"
+	if !strings.Contains(joined, want) {
+		t.Errorf("args missing %q:\n%s", want, joined)
+	}
+
+	parsed, err := ParseArgs(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed.Debug, cfg.Debug) {
+		t.Errorf("parsed debug config = %+v, want %+v", parsed.Debug, cfg.Debug)
+	}
+}
+
+func TestParseTraceArgs(t *testing.T) {
+	parsed, err := ParseArgs([]string{"--trace", "pci_*", "-trace", "enable=kvm_*,file=/tmp/trace"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Debug == nil || !reflect.DeepEqual(parsed.Debug.Trace, []string{"pci_*"}) {
+		t.Errorf("parsed debug config = %+v", parsed.Debug)
+	}
+	if len(parsed.ExtraArgs) != 2 || parsed.ExtraArgs[1] != "enable=kvm_*,file=/tmp/trace" {
+		t.Errorf("simple trace backend options not kept: %v", parsed.ExtraArgs)
+	}
+}
+
+func TestValidateDebug(t *testing.T) {
+	for _, debug := range []*DebugConfig{
+		{Log: []string{"guest_errors,unimp"}},
+		{Log: []string{""}},
+		{Trace: []string{"pci_*,file=/tmp/trace"}},
+	} {
+		if err := (&VMConfig{Debug: debug}).Validate(); err == nil {
+			t.Errorf("Validate accepted %+v", debug)
+		}
+	}
+}
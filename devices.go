@@ -9,356 +9,506 @@ import (
 // MachineConfig configures the virtual machine type.
 type MachineConfig struct {
 	// Type is the machine type (e.g., "q35", "pc", "virt").
-	Type string
+	Type string `json:"type,omitempty"`
 
 	// Accel is the accelerator (e.g., "kvm", "tcg", "hvf").
-	Accel string
+	Accel string `json:"accel,omitempty"`
 
 	// USB enables/disables USB controller creation by machine.
-	USB *bool
+	USB *bool `json:"usb,omitempty"`
 
 	// DumpGuestCore enables/disables guest core dumps.
-	DumpGuestCore *bool
+	DumpGuestCore *bool `json:"dump_guest_core,omitempty"`
 
 	// Pflash0 is the node name for pflash0 (EFI code).
-	Pflash0 string
+	Pflash0 string `json:"pflash0,omitempty"`
 
 	// Pflash1 is the node name for pflash1 (EFI vars).
-	Pflash1 string
+	Pflash1 string `json:"pflash1,omitempty"`
+
+	// VirtioTransport selects the transport of virtio devices, VirtioPCI,
+	// VirtioMMIO or VirtioCCW. It defaults to the architecture's usual
+	// transport. virtio-mmio suits minimal virt machines without a PCI bus
+	// in the guest kernel; virtio-ccw is the s390x channel subsystem.
+	VirtioTransport string `json:"virtio_transport,omitempty"`
 }
 
+// Virtio transports.
+const (
+	VirtioPCI  = "pci"
+	VirtioMMIO = "mmio"
+	VirtioCCW  = "ccw"
+)
+
 // CPUConfig configures the virtual CPU.
 type CPUConfig struct {
 	// Model is the CPU model (e.g., "host", "qemu64", "max").
-	Model string
+	Model string `json:"model,omitempty"`
 
 	// Features are CPU feature flags (e.g., "+ssse3", "-svm").
-	Features []string
+	Features []string `json:"features,omitempty"`
 
 	// Sockets is the number of CPU sockets.
-	Sockets int
+	Sockets int `json:"sockets,omitempty"`
 
 	// Cores is the number of cores per socket.
-	Cores int
+	Cores int `json:"cores,omitempty"`
 
 	// Threads is the number of threads per core.
-	Threads int
+	Threads int `json:"threads,omitempty"`
 }
 
 // MemoryConfig configures the virtual machine memory.
 type MemoryConfig struct {
 	// Size is the memory size in megabytes.
-	Size int
+	Size int `json:"size,omitempty"`
 
 	// Backend configures memory backend (optional).
-	Backend *MemoryBackendConfig
+	Backend *MemoryBackendConfig `json:"backend,omitempty"`
 
 	// MemLock controls memory locking ("on", "off").
-	MemLock string
+	MemLock string `json:"mem_lock,omitempty"`
 }
 
 // MemoryBackendConfig configures a memory backend.
 type MemoryBackendConfig struct {
 	// Type is the backend type ("file", "memfd", "ram").
-	Type string
+	Type string `json:"type,omitempty"`
 
 	// Path is the file path for file-backed memory.
-	Path string
+	Path string `json:"path,omitempty"`
 
 	// Share enables memory sharing with other processes.
-	Share bool
+	Share bool `json:"share,omitempty"`
 
 	// Prealloc preallocates memory.
-	Prealloc bool
+	Prealloc bool `json:"prealloc,omitempty"`
+
+	// HugePages backs the memory with huge pages: hugetlb=on for memfd
+	// backends, or a hugetlbfs mount for file backends, found in
+	// /proc/mounts when Path is empty. StartVM fails with a
+	// HugePagesUnavailableError if too few pages are free. Linux only.
+	HugePages bool `json:"hugepages,omitempty"`
+
+	// HugePageSize is the huge page size in bytes, such as 2<<20 or 1<<30
+	// (the host default if 0).
+	HugePageSize int64 `json:"hugepage_size,omitempty"`
 }
 
 // EFIConfig configures UEFI firmware.
 type EFIConfig struct {
 	// Code is the path to the EFI code (pflash0).
-	Code string
+	Code string `json:"code,omitempty"`
 
 	// Vars is the path to the EFI variables file (pflash1).
-	Vars string
+	Vars string `json:"vars,omitempty"`
+
+	// VarsTemplate is the template for creating new vars file. It is copied
+	// to Vars when the VM starts if Vars does not exist yet.
+	VarsTemplate string `json:"vars_template,omitempty"`
 
-	// VarsTemplate is the template for creating new vars file.
-	VarsTemplate string
+	// SecureBoot marks Code as an SMM-enabled Secure Boot build such as
+	// OVMF_CODE.secboot.fd. It enables SMM on the machine and restricts
+	// writes to the vars flash to SMM, so that the guest OS cannot tamper
+	// with the enrolled keys. Requires a q35 machine and Vars.
+	SecureBoot bool `json:"secure_boot,omitempty"`
 }
 
 // BootConfig configures boot options.
 type BootConfig struct {
 	// Order is the boot order (e.g., "cdn" for cdrom, disk, network).
-	Order string
+	Order string `json:"order,omitempty"`
+
+	// Once is the boot order of the first boot only, e.g. "d" to boot an
+	// installer CD-ROM and then the disk once it reboots. Like Order, it
+	// only applies to legacy BIOS firmware.
+	Once string `json:"once,omitempty"`
 
 	// Menu enables/disables boot menu.
-	Menu *bool
+	Menu *bool `json:"menu,omitempty"`
 
 	// Strict enforces boot order.
-	Strict bool
+	Strict bool `json:"strict,omitempty"`
 
 	// Kernel is the path to a kernel image for direct boot.
-	Kernel string
+	Kernel string `json:"kernel,omitempty"`
 
 	// Initrd is the path to an initrd image.
-	Initrd string
+	Initrd string `json:"initrd,omitempty"`
 
 	// Append is the kernel command line.
-	Append string
+	Append string `json:"append,omitempty"`
+
+	// Firmware is the firmware loaded with -bios: a path, "default" for
+	// the firmware bundled with QEMU or "none". On riscv64 the default is
+	// OpenSBI, which starts Kernel or the EFI firmware; "none" runs Kernel
+	// itself in M-mode.
+	Firmware string `json:"firmware,omitempty"`
 }
 
 // DisplayConfig configures display output.
 type DisplayConfig struct {
 	// Type is the display type ("none", "vnc", "spice", "gtk", "sdl").
-	Type string
+	Type string `json:"type,omitempty"`
 
 	// VNC configures VNC display.
-	VNC *VNCConfig
+	VNC *VNCConfig `json:"vnc,omitempty"`
 
 	// Spice configures SPICE display.
-	Spice *SpiceDisplayConfig
+	Spice *SpiceDisplayConfig `json:"spice,omitempty"`
 
 	// Video configures video device.
-	Video *VideoConfig
+	Video *VideoConfig `json:"video,omitempty"`
 }
 
 // VNCConfig configures VNC display.
 type VNCConfig struct {
 	// Listen is the listen address (e.g., ":0", "127.0.0.1:5900", "none").
 	// Use "none" for add_client mode.
-	Listen string
+	Listen string `json:"listen,omitempty"`
 
 	// Password is the VNC password (use PasswordSecret for security).
-	Password string
+	Password string `json:"password,omitempty"`
 
 	// PasswordSecret is the secret ID for password.
-	PasswordSecret string
+	PasswordSecret string `json:"password_secret,omitempty"`
 
 	// Lossy enables lossy compression.
-	Lossy bool
+	Lossy bool `json:"lossy,omitempty"`
 
 	// AudioDev is the audio device ID for VNC audio.
-	AudioDev string
+	AudioDev string `json:"audio_dev,omitempty"`
 
 	// Websocket enables websocket support on given port.
-	Websocket int
+	Websocket int `json:"websocket,omitempty"`
 }
 
 // SpiceDisplayConfig configures SPICE display.
 type SpiceDisplayConfig struct {
 	// Unix uses Unix socket instead of TCP.
-	Unix bool
+	Unix bool `json:"unix,omitempty"`
 
 	// Port is the TCP port (0 for none).
-	Port int
+	Port int `json:"port,omitempty"`
 
 	// Password is the SPICE password.
-	Password string
+	Password string `json:"password,omitempty"`
 
 	// PasswordSecret is the secret ID for password.
-	PasswordSecret string
+	PasswordSecret string `json:"password_secret,omitempty"`
 
 	// DisableTicketing disables password authentication.
-	DisableTicketing bool
+	DisableTicketing bool `json:"disable_ticketing,omitempty"`
 
 	// ImageCompression sets image compression mode.
-	ImageCompression string
+	ImageCompression string `json:"image_compression,omitempty"`
 
 	// JpegWanCompression sets JPEG WAN compression.
-	JpegWanCompression string
+	JpegWanCompression string `json:"jpeg_wan_compression,omitempty"`
 
 	// ZlibGlzWanCompression sets zlib-glz WAN compression.
-	ZlibGlzWanCompression string
+	ZlibGlzWanCompression string `json:"zlib_glz_wan_compression,omitempty"`
 
 	// PlaybackCompression enables audio playback compression.
-	PlaybackCompression bool
+	PlaybackCompression bool `json:"playback_compression,omitempty"`
 
 	// SeamlessMigration enables seamless migration.
-	SeamlessMigration bool
+	SeamlessMigration bool `json:"seamless_migration,omitempty"`
 
 	// DisableCopyPaste disables copy-paste.
-	DisableCopyPaste bool
+	DisableCopyPaste bool `json:"disable_copy_paste,omitempty"`
 }
 
 // VideoConfig configures video device.
 type VideoConfig struct {
 	// Type is the video device type ("qxl-vga", "virtio-vga", "vga", "cirrus").
-	Type string
+	Type string `json:"type,omitempty"`
 
 	// VgaMem is VGA memory size in MB.
-	VgaMem int
+	VgaMem int `json:"vga_mem,omitempty"`
 
 	// Ram is video RAM size in bytes (for QXL).
-	Ram int
+	Ram int `json:"ram,omitempty"`
 
 	// Vram is video VRAM size in bytes (for QXL).
-	Vram int
+	Vram int `json:"vram,omitempty"`
 
 	// MaxOutputs is maximum number of outputs.
-	MaxOutputs int
+	MaxOutputs int `json:"max_outputs,omitempty"`
 }
 
 // AudioConfig configures audio device.
 type AudioConfig struct {
 	// Backend is the audio backend ("spice", "pa", "alsa", "none").
-	Backend string
+	Backend string `json:"backend,omitempty"`
 
 	// Device is the sound device type ("intel-hda", "ich9-intel-hda", "ac97").
-	Device string
+	Device string `json:"device,omitempty"`
 
 	// Codec is the codec for HDA devices ("hda-micro", "hda-duplex", "hda-output").
-	Codec string
+	Codec string `json:"codec,omitempty"`
 }
 
 // SerialConfig configures a serial port.
 type SerialConfig struct {
 	// Type is the chardev type ("socket", "pty", "file", "pipe").
-	Type string
+	Type string `json:"type,omitempty"`
 
 	// Path is the socket/file/pipe path.
-	Path string
+	Path string `json:"path,omitempty"`
 
 	// Server makes the socket a server.
-	Server bool
+	Server bool `json:"server,omitempty"`
 
 	// Wait waits for client connection.
-	Wait bool
+	Wait bool `json:"wait,omitempty"`
+
+	// Device is the serial device type ("isa-serial", "usb-serial",
+	// "virtio-serial", "sclpconsole"). It defaults to the architecture's
+	// serial port.
+	Device string `json:"device,omitempty"`
 
-	// Device is the serial device type ("isa-serial", "usb-serial", "virtio-serial").
-	Device string
+	// Log records the output of the port to rotating files.
+	Log *SerialLogConfig `json:"log,omitempty"`
 }
 
 // ChardevConfig configures a character device.
 type ChardevConfig struct {
 	// ID is the chardev ID.
-	ID string
+	ID string `json:"id,omitempty"`
 
-	// Backend is the chardev backend type.
-	Backend string
+	// Backend is the chardev backend type, such as ChardevSocket or
+	// ChardevTCP. Other QEMU backends are passed through with the generic
+	// options.
+	Backend string `json:"backend,omitempty"`
 
-	// Path is the socket/file path.
-	Path string
+	// Path is the socket/file path. For pty, it is a symlink to the pty
+	// (QEMU 9.0+).
+	Path string `json:"path,omitempty"`
 
 	// Server makes socket a server.
-	Server bool
+	Server bool `json:"server,omitempty"`
+
+	// Wait waits for connection. Only server sockets wait.
+	Wait bool `json:"wait,omitempty"`
+
+	// Host is the TCP host, or the remote host for udp.
+	Host string `json:"host,omitempty"`
+
+	// Port is the TCP port, or the remote port for udp.
+	Port int `json:"port,omitempty"`
 
-	// Wait waits for connection.
-	Wait bool
+	// Reconnect is the reconnect interval in seconds, for client sockets.
+	Reconnect int `json:"reconnect,omitempty"`
 
-	// Host is the TCP host.
-	Host string
+	// Name is the spicevmc channel name, "vdagent" for qemu-vdagent.
+	Name string `json:"name,omitempty"`
 
-	// Port is the TCP port.
-	Port int
+	// Telnet speaks the telnet protocol on a TCP socket.
+	Telnet bool `json:"telnet,omitempty"`
 
-	// Reconnect is the reconnect interval in seconds.
-	Reconnect int
+	// WebSocket accepts WebSocket clients on a TCP server socket.
+	WebSocket bool `json:"websocket,omitempty"`
 
-	// Name is the spicevmc channel name.
-	Name string
+	// NoDelay disables Nagle's algorithm on a TCP socket.
+	NoDelay bool `json:"nodelay,omitempty"`
+
+	// LocalAddr and LocalPort are the local address of a udp chardev.
+	LocalAddr string `json:"local_addr,omitempty"`
+	LocalPort int    `json:"local_port,omitempty"`
+
+	// Append appends to a file instead of truncating it.
+	Append bool `json:"append,omitempty"`
+
+	// Clipboard shares the guest clipboard through a qemu-vdagent chardev.
+	Clipboard bool `json:"clipboard,omitempty"`
+
+	// LogFile receives a copy of the output, whatever the backend.
+	LogFile string `json:"log_file,omitempty"`
 }
 
 // VirtioSerialConfig configures virtio-serial device.
 type VirtioSerialConfig struct {
 	// MaxPorts is maximum number of ports.
-	MaxPorts int
+	MaxPorts int `json:"max_ports,omitempty"`
 
 	// Ports is the list of serial ports.
-	Ports []VirtioSerialPortConfig
+	Ports []VirtioSerialPortConfig `json:"ports,omitempty"`
 }
 
 // VirtioSerialPortConfig configures a virtio-serial port.
 type VirtioSerialPortConfig struct {
+	// ID is the device ID, which QEMU names in VSERPORT_CHANGE events.
+	ID string `json:"id,omitempty"`
+
 	// Chardev is the chardev ID.
-	Chardev string
+	Chardev string `json:"chardev,omitempty"`
 
 	// Name is the port name (e.g., "org.qemu.guest_agent.0").
-	Name string
+	Name string `json:"name,omitempty"`
 
 	// Type is the port type ("virtserialport", "virtconsole").
-	Type string
+	Type string `json:"type,omitempty"`
 }
 
 // USBControllerConfig configures USB controller.
 type USBControllerConfig struct {
 	// Type is the controller type ("qemu-xhci", "nec-usb-xhci", "ich9-usb-uhci1").
-	Type string
+	Type string `json:"type,omitempty"`
 }
 
 // USBDeviceConfig configures a USB device.
 type USBDeviceConfig struct {
 	// Type is the device type ("usb-tablet", "usb-mouse", "usb-kbd", "usb-redir").
-	Type string
+	Type string `json:"type,omitempty"`
 
 	// Chardev is the chardev ID (for usb-redir, usb-serial).
-	Chardev string
+	Chardev string `json:"chardev,omitempty"`
 }
 
 // BalloonConfig configures memory balloon device.
 type BalloonConfig struct {
 	// Enabled enables/disables balloon device.
-	Enabled bool
+	Enabled bool `json:"enabled,omitempty"`
+}
+
+// PanicConfig configures a pvpanic device, through which the guest kernel
+// reports panics to QEMU. Panics are reported as GUEST_PANICKED events and
+// move the instance to StateCrashed.
+type PanicConfig struct {
+	// Device is "pvpanic-pci" (default) or "pvpanic-isa".
+	Device string `json:"device,omitempty"`
+
+	// Action is what QEMU does when the guest panics: "pause" keeps the
+	// guest for inspection, "shutdown", "exit-failure" or "none". Empty
+	// keeps QEMU's default. Requires QEMU 6.0+.
+	Action string `json:"action,omitempty"`
+}
+
+// VsockConfig configures a vhost-vsock device, a socket channel between
+// host and guest that needs no guest networking (see DialVsock). The host
+// must have the vhost_vsock module loaded.
+type VsockConfig struct {
+	// CID is the guest context ID, unique on the host. CIDs 0 to 2 are
+	// reserved.
+	CID uint32 `json:"cid"`
+}
+
+// TPMConfig configures an emulated TPM 2.0, as required by Windows 11 and
+// measured boot. StartVM runs a swtpm process for it, which is stopped
+// with the instance.
+type TPMConfig struct {
+	// StateDir holds the persistent TPM state of the VM (keys, NVRAM). It
+	// must not be shared between VMs and is created if missing.
+	StateDir string `json:"state_dir"`
+
+	// Model is the TPM device ("tpm-tis", "tpm-crb", "tpm-tis-device",
+	// "tpm-spapr"). Defaults to "tpm-tis-device" on arm and riscv64,
+	// "tpm-spapr" on ppc64 and "tpm-tis" elsewhere.
+	Model string `json:"model,omitempty"`
+
+	// SwtpmPath overrides the swtpm binary. Defaults to "swtpm" in PATH.
+	SwtpmPath string `json:"swtpm_path,omitempty"`
+}
+
+// TDXConfig makes the VM an Intel TDX confidential guest, whose memory and
+// CPU state are protected from the host. It needs a TDX-enabled host
+// (HostCapabilities.TDX), KVM on a q35 machine, QEMU 10.1+ and a TDX build
+// of OVMF; guest memory is made private with guest_memfd, so any memory
+// backend works.
+type TDXConfig struct {
+	// Firmware is the TDX-enabled OVMF image (such as OVMF.inteltdx.fd),
+	// loaded with -bios. TDX guests cannot use pflash EFI firmware.
+	Firmware string `json:"firmware"`
+
+	// QuoteGenerationSocket is the Unix socket of the host Quote
+	// Generation Service, for remote attestation.
+	QuoteGenerationSocket string `json:"quote_generation_socket,omitempty"`
+
+	// SeptVEDisable disables EPT violation #VE conversion, as required by
+	// Linux guests.
+	SeptVEDisable bool `json:"sept_ve_disable,omitempty"`
+
+	// MrConfigID, MrOwner and MrOwnerConfig are base64-encoded SHA-384
+	// digests included in attestation reports.
+	MrConfigID    string `json:"mrconfigid,omitempty"`
+	MrOwner       string `json:"mrowner,omitempty"`
+	MrOwnerConfig string `json:"mrownerconfig,omitempty"`
 }
 
 // SecretConfig configures a secret object.
 type SecretConfig struct {
 	// ID is the secret ID.
-	ID string
+	ID string `json:"id,omitempty"`
 
 	// Data is the raw secret data.
-	Data string
+	Data string `json:"data,omitempty"`
 
 	// File is the path to secret file.
-	File string
+	File string `json:"file,omitempty"`
 
 	// Format is the secret format ("raw", "base64").
-	Format string
+	Format string `json:"format,omitempty"`
 }
 
 // pciSlotAllocator manages PCI slot allocation.
 type pciSlotAllocator struct {
 	nextSlot int
-	used     map[int]bool
+	used     uint32
+	reserved uint32
 	bus      string
 }
 
-// newPCISlotAllocator creates a new PCI slot allocator.
-func newPCISlotAllocator(isQ35 bool) *pciSlotAllocator {
-	a := &pciSlotAllocator{
-		nextSlot: 0x3,
-		used:     make(map[int]bool),
+// pciSlotNames caches the "0x%x" form of every slot number.
+var pciSlotNames = func() (names [0x20]string) {
+	for i := range names {
+		names[i] = "0x" + strconv.FormatInt(int64(i), 16)
 	}
+	return names
+}()
+
+// newPCISlotAllocator creates a new PCI slot allocator.
+func newPCISlotAllocator(pcie bool) *pciSlotAllocator {
+	a := &pciSlotAllocator{}
 
 	// Reserve slot 0 for root complex
-	a.used[0] = true
+	a.reserved = 1 << 0
 
-	if isQ35 {
-		// For Q35, reserve slots 1 and 2 for pcie-root-ports
-		a.used[0x1] = true
-		a.used[0x2] = true
+	if pcie {
+		// For PCIe, reserve slots 1 and 2 for pcie-root-ports
+		a.reserved |= 1<<0x1 | 1<<0x2
 		a.bus = "pcie.0"
 	} else {
 		a.bus = "pci.0"
 	}
 
+	a.reset()
 	return a
 }
 
+// reset releases all slots allocated or reserved since creation.
+func (a *pciSlotAllocator) reset() {
+	a.nextSlot = 0x3
+	a.used = a.reserved
+}
+
 // Alloc returns the next available PCI slot address.
 func (a *pciSlotAllocator) Alloc() string {
-	for a.used[a.nextSlot] {
+	for a.used&(1<<a.nextSlot) != 0 {
 		a.nextSlot++
 		if a.nextSlot > 0x1f {
 			panic("ran out of PCI slots")
 		}
 	}
 	slot := a.nextSlot
-	a.used[slot] = true
+	a.used |= 1 << slot
 	a.nextSlot++
-	return fmt.Sprintf("0x%x", slot)
+	return pciSlotNames[slot]
 }
 
 // Reserve marks a specific slot as used.
 func (a *pciSlotAllocator) Reserve(slot int) {
-	a.used[slot] = true
+	a.used |= 1 << slot
 }
 
 // Bus returns the PCI bus name.
@@ -541,41 +691,6 @@ func buildSpiceArgs(cfg *SpiceDisplayConfig) []string {
 	return []string{"-spice", strings.Join(parts, ",")}
 }
 
-// buildChardevArgs builds chardev arguments.
-func buildChardevArgs(cfg *ChardevConfig) []string {
-	if cfg == nil || cfg.ID == "" {
-		return nil
-	}
-
-	var parts []string
-	parts = append(parts, cfg.Backend)
-	parts = append(parts, "id="+cfg.ID)
-
-	if cfg.Path != "" {
-		parts = append(parts, "path="+cfg.Path)
-	}
-	if cfg.Host != "" {
-		parts = append(parts, "host="+cfg.Host)
-	}
-	if cfg.Port > 0 {
-		parts = append(parts, fmt.Sprintf("port=%d", cfg.Port))
-	}
-	if cfg.Server {
-		parts = append(parts, "server=on")
-	}
-	if !cfg.Wait {
-		parts = append(parts, "wait=off")
-	}
-	if cfg.Reconnect > 0 {
-		parts = append(parts, fmt.Sprintf("reconnect=%d", cfg.Reconnect))
-	}
-	if cfg.Name != "" {
-		parts = append(parts, "name="+cfg.Name)
-	}
-
-	return []string{"-chardev", strings.Join(parts, ",")}
-}
-
 // buildSecretArgs builds secret object arguments.
 func buildSecretArgs(cfg *SecretConfig) []string {
 	if cfg == nil || cfg.ID == "" {
@@ -3,37 +3,190 @@ package qemuctl
 import (
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
 )
 
 // DiskConfig configures a disk drive.
 type DiskConfig struct {
 	// ID is the drive/device ID.
-	ID string
+	ID string `json:"id,omitempty"`
 
 	// Backend configures the storage backend.
-	Backend DiskBackend
+	Backend DiskBackend `json:"backend,omitempty"`
 
 	// Interface is the disk interface ("virtio", "ide", "scsi", "nvme").
-	Interface string
+	Interface string `json:"interface,omitempty"`
 
-	// Cache is the caching mode ("none", "writeback", "writethrough").
-	Cache string
+	// Cache is the caching mode ("writeback", the default, "none",
+	// "writethrough", "directsync" or "unsafe"). "none" and "directsync"
+	// bypass the host page cache.
+	Cache string `json:"cache,omitempty"`
 
 	// Discard enables discard/TRIM ("unmap", "ignore").
-	Discard string
+	Discard string `json:"discard,omitempty"`
+
+	// AIO is the host I/O engine of file and host device backends
+	// ("threads", "native" or "io_uring"). "native" needs a cache mode
+	// bypassing the page cache.
+	AIO string `json:"aio,omitempty"`
+
+	// DetectZeroes detects writes of zeroes ("off", "on", or "unmap" to
+	// discard them, which needs Discard "unmap").
+	DetectZeroes string `json:"detect_zeroes,omitempty"`
 
 	// ReadOnly makes the drive read-only.
-	ReadOnly bool
+	ReadOnly bool `json:"read_only,omitempty"`
 
 	// BootIndex sets the boot priority (lower = higher priority).
-	BootIndex int
+	BootIndex int `json:"boot_index,omitempty"`
 
 	// Throttle configures I/O throttling.
-	Throttle *ThrottleConfig
+	Throttle *ThrottleConfig `json:"throttle,omitempty"`
 
 	// Serial is the drive's serial number.
-	Serial string
+	Serial string `json:"serial,omitempty"`
+
+	// WriteError is the action on write errors: DiskErrorENOSPC, QEMU's
+	// default, DiskErrorStop, DiskErrorReport or DiskErrorIgnore. A guest
+	// paused by an error can be resumed with
+	// Instance.ResumeWhenSpaceAvailable.
+	WriteError string `json:"write_error,omitempty"`
+
+	// ReadError is the action on read errors. QEMU defaults to
+	// DiskErrorReport.
+	ReadError string `json:"read_error,omitempty"`
+
+	// IOThread is the ID of the VMConfig.IOThreads entry processing the
+	// disk's requests. Only virtio disks can use one; SCSI disks use the
+	// I/O thread of their controller.
+	IOThread string `json:"iothread,omitempty"`
+
+	// SCSI places a disk with Interface "scsi" on a controller. Without
+	// it, the disk goes on controller "scsi0" at the next free target.
+	SCSI *SCSIAddress `json:"scsi,omitempty"`
+
+	// replay adds a blkreplay filter for record/replay, set by the builder
+	replay bool
+}
+
+// Disk error actions, for DiskConfig.WriteError and ReadError.
+const (
+	// DiskErrorReport passes the error to the guest.
+	DiskErrorReport = "report"
+
+	// DiskErrorIgnore drops the error and carries on.
+	DiskErrorIgnore = "ignore"
+
+	// DiskErrorStop pauses the guest on any error.
+	DiskErrorStop = "stop"
+
+	// DiskErrorENOSPC pauses the guest when the host runs out of space
+	// and reports other errors.
+	DiskErrorENOSPC = "enospc"
+)
+
+// diskCacheMode is a cache mode as blockdev cache options and the device's
+// write cache setting.
+type diskCacheMode struct {
+	direct, noFlush, writeCache bool
+}
+
+// diskCacheModes maps the DiskConfig.Cache modes to their settings.
+var diskCacheModes = map[string]diskCacheMode{
+	"writeback":    {writeCache: true},
+	"none":         {direct: true, writeCache: true},
+	"writethrough": {},
+	"directsync":   {direct: true},
+	"unsafe":       {noFlush: true, writeCache: true},
+}
+
+// diskCacheModeName returns the name of a cache mode.
+func diskCacheModeName(mode diskCacheMode) string {
+	for name, m := range diskCacheModes {
+		if m == mode {
+			return name
+		}
+	}
+	return ""
+}
+
+// applyBlockdevOptions adds the cache, discard, AIO and zero detection
+// options of a disk to the -blockdev arguments of its backend. Cache and
+// discard apply to every node, AIO to the protocol nodes that have it and
+// zero detection to the top node.
+func (cfg *DiskConfig) applyBlockdevOptions(args []string, top string) []string {
+	if cfg.Cache == "" && cfg.Discard == "" && cfg.AIO == "" && cfg.DetectZeroes == "" {
+		return args
+	}
+
+	out := make([]string, len(args))
+	copy(out, args)
+	for i := 0; i+1 < len(out); i += 2 {
+		var opts map[string]any
+		if out[i] != "-blockdev" || json.Unmarshal([]byte(out[i+1]), &opts) != nil {
+			continue
+		}
+		if mode, ok := diskCacheModes[cfg.Cache]; ok {
+			opts["cache"] = map[string]any{"direct": mode.direct, "no-flush": mode.noFlush}
+		}
+		if cfg.Discard != "" {
+			opts["discard"] = cfg.Discard
+		}
+		switch opts["driver"] {
+		case "file", "host_device":
+			if cfg.AIO != "" {
+				opts["aio"] = cfg.AIO
+			}
+		}
+		if cfg.DetectZeroes != "" && opts["node-name"] == top {
+			opts["detect-zeroes"] = cfg.DetectZeroes
+		}
+		out[i+1] = blockdevJSON(opts)
+	}
+	return out
+}
+
+// validate checks the error actions and I/O options of a disk.
+func (cfg *DiskConfig) validate() error {
+	if _, ok := diskCacheModes[cfg.Cache]; cfg.Cache != "" && !ok {
+		return fmt.Errorf("disk %s: unknown cache mode %q", cfg.ID, cfg.Cache)
+	}
+	switch cfg.Discard {
+	case "", "ignore", "unmap", "off", "on":
+	default:
+		return fmt.Errorf("disk %s: unknown discard mode %q", cfg.ID, cfg.Discard)
+	}
+	switch cfg.AIO {
+	case "", "threads", "io_uring":
+	case "native":
+		if !diskCacheModes[cfg.Cache].direct {
+			return fmt.Errorf("disk %s: native AIO needs cache mode none or directsync", cfg.ID)
+		}
+	default:
+		return fmt.Errorf("disk %s: unknown AIO engine %q", cfg.ID, cfg.AIO)
+	}
+	switch cfg.DetectZeroes {
+	case "", "off", "on":
+	case "unmap":
+		if cfg.Discard != "unmap" && cfg.Discard != "on" {
+			return fmt.Errorf("disk %s: detect-zeroes unmap needs discard unmap", cfg.ID)
+		}
+	default:
+		return fmt.Errorf("disk %s: unknown detect-zeroes mode %q", cfg.ID, cfg.DetectZeroes)
+	}
+
+	for _, action := range []string{cfg.WriteError, cfg.ReadError} {
+		switch action {
+		case "", DiskErrorReport, DiskErrorIgnore, DiskErrorStop, DiskErrorENOSPC:
+		default:
+			return fmt.Errorf("disk %s: unknown error action %q", cfg.ID, action)
+		}
+	}
+	if cfg.Interface == "nvme" && (cfg.WriteError != "" || cfg.ReadError != "") {
+		return fmt.Errorf("disk %s: nvme disks have no error actions", cfg.ID)
+	}
+	return nil
 }
 
 // DiskBackend is the interface for disk backends.
@@ -48,13 +201,13 @@ type DiskBackend interface {
 // FileDiskBackend represents a file-based disk.
 type FileDiskBackend struct {
 	// Path is the file path.
-	Path string
+	Path string `json:"path,omitempty"`
 
 	// Format is the disk format ("raw", "qcow2", "vmdk").
-	Format string
+	Format string `json:"format,omitempty"`
 
 	// AutoReadOnly enables auto read-only mode.
-	AutoReadOnly bool
+	AutoReadOnly bool `json:"auto_read_only,omitempty"`
 }
 
 func (f *FileDiskBackend) Type() string { return "file" }
@@ -73,7 +226,7 @@ func (f *FileDiskBackend) BuildBlockdevArgs(id string) []string {
 		fileOpts["auto-read-only"] = true
 	}
 
-	fileJSON, _ := json.Marshal(fileOpts)
+	fileJSON := blockdevJSON(fileOpts)
 
 	// Build format layer
 	format := f.Format
@@ -86,33 +239,33 @@ func (f *FileDiskBackend) BuildBlockdevArgs(id string) []string {
 		"node-name": formatNode,
 	}
 
-	formatJSON, _ := json.Marshal(formatOpts)
+	formatJSON := blockdevJSON(formatOpts)
 
 	return []string{
-		"-blockdev", string(fileJSON),
-		"-blockdev", string(formatJSON),
+		"-blockdev", fileJSON,
+		"-blockdev", formatJSON,
 	}
 }
 
 // NBDDiskBackend represents an NBD-connected disk.
 type NBDDiskBackend struct {
 	// SocketPath is the Unix socket path.
-	SocketPath string
+	SocketPath string `json:"socket_path,omitempty"`
 
 	// Host is the TCP host (alternative to socket).
-	Host string
+	Host string `json:"host,omitempty"`
 
 	// Port is the TCP port.
-	Port int
+	Port int `json:"port,omitempty"`
 
 	// Export is the NBD export name.
-	Export string
+	Export string `json:"export,omitempty"`
 
 	// TLS enables TLS.
-	TLS bool
+	TLS bool `json:"tls,omitempty"`
 
 	// TLSCreds is the TLS credentials ID.
-	TLSCreds string
+	TLSCreds string `json:"tls_creds,omitempty"`
 }
 
 func (n *NBDDiskBackend) Type() string { return "nbd" }
@@ -156,7 +309,7 @@ func (n *NBDDiskBackend) BuildBlockdevArgs(id string) []string {
 		"no-flush": false,
 	}
 
-	nbdJSON, _ := json.Marshal(nbdOpts)
+	nbdJSON := blockdevJSON(nbdOpts)
 
 	// Build format layer (raw on top of NBD)
 	formatOpts := map[string]any{
@@ -166,36 +319,36 @@ func (n *NBDDiskBackend) BuildBlockdevArgs(id string) []string {
 		"read-only": false,
 	}
 
-	formatJSON, _ := json.Marshal(formatOpts)
+	formatJSON := blockdevJSON(formatOpts)
 
 	return []string{
-		"-blockdev", string(nbdJSON),
-		"-blockdev", string(formatJSON),
+		"-blockdev", nbdJSON,
+		"-blockdev", formatJSON,
 	}
 }
 
 // RBDDiskBackend represents a Ceph RBD disk.
 type RBDDiskBackend struct {
 	// Pool is the RBD pool name.
-	Pool string
+	Pool string `json:"pool,omitempty"`
 
 	// Image is the RBD image name.
-	Image string
+	Image string `json:"image,omitempty"`
 
 	// Snapshot is the snapshot name (optional).
-	Snapshot string
+	Snapshot string `json:"snapshot,omitempty"`
 
 	// Conf is the path to ceph.conf.
-	Conf string
+	Conf string `json:"conf,omitempty"`
 
 	// User is the Ceph user name.
-	User string
+	User string `json:"user,omitempty"`
 
 	// KeySecret is the secret ID for the key.
-	KeySecret string
+	KeySecret string `json:"key_secret,omitempty"`
 
 	// AuthClientRequired is the auth method list.
-	AuthClientRequired []string
+	AuthClientRequired []string `json:"auth_client_required,omitempty"`
 }
 
 func (r *RBDDiskBackend) Type() string { return "rbd" }
@@ -234,7 +387,7 @@ func (r *RBDDiskBackend) BuildBlockdevArgs(id string) []string {
 	}
 	rbdOpts["discard"] = "unmap"
 
-	rbdJSON, _ := json.Marshal(rbdOpts)
+	rbdJSON := blockdevJSON(rbdOpts)
 
 	// Build format layer
 	formatOpts := map[string]any{
@@ -244,33 +397,33 @@ func (r *RBDDiskBackend) BuildBlockdevArgs(id string) []string {
 		"read-only": false,
 	}
 
-	formatJSON, _ := json.Marshal(formatOpts)
+	formatJSON := blockdevJSON(formatOpts)
 
 	return []string{
-		"-blockdev", string(rbdJSON),
-		"-blockdev", string(formatJSON),
+		"-blockdev", rbdJSON,
+		"-blockdev", formatJSON,
 	}
 }
 
 // ISCSIDiskBackend represents an iSCSI disk.
 type ISCSIDiskBackend struct {
 	// Portal is the iSCSI portal address (host:port).
-	Portal string
+	Portal string `json:"portal,omitempty"`
 
 	// Target is the iSCSI target name.
-	Target string
+	Target string `json:"target,omitempty"`
 
 	// Lun is the LUN number.
-	Lun int
+	Lun int `json:"lun,omitempty"`
 
 	// User is the CHAP user name.
-	User string
+	User string `json:"user,omitempty"`
 
 	// PasswordSecret is the secret ID for CHAP password.
-	PasswordSecret string
+	PasswordSecret string `json:"password_secret,omitempty"`
 
 	// InitiatorName is the initiator IQN.
-	InitiatorName string
+	InitiatorName string `json:"initiator_name,omitempty"`
 }
 
 func (i *ISCSIDiskBackend) Type() string { return "iscsi" }
@@ -304,45 +457,45 @@ func (i *ISCSIDiskBackend) BuildBlockdevArgs(id string) []string {
 	}
 	iscsiOpts["discard"] = "unmap"
 
-	iscsiJSON, _ := json.Marshal(iscsiOpts)
+	iscsiJSON := blockdevJSON(iscsiOpts)
 
 	// For iSCSI, we typically use scsi-block which needs direct access
 	return []string{
-		"-blockdev", string(iscsiJSON),
+		"-blockdev", iscsiJSON,
 	}
 }
 
 // ThrottleConfig configures I/O throttling.
 type ThrottleConfig struct {
 	// Group is the throttle group name.
-	Group string
+	Group string `json:"group,omitempty"`
 
 	// BPS is the total bytes per second limit.
-	BPS uint64
+	BPS uint64 `json:"bps,omitempty"`
 
 	// BPSRead is the read bytes per second limit.
-	BPSRead uint64
+	BPSRead uint64 `json:"bps_read,omitempty"`
 
 	// BPSWrite is the write bytes per second limit.
-	BPSWrite uint64
+	BPSWrite uint64 `json:"bps_write,omitempty"`
 
 	// IOPS is the total I/O operations per second limit.
-	IOPS uint64
+	IOPS uint64 `json:"iops,omitempty"`
 
 	// IOPSRead is the read IOPS limit.
-	IOPSRead uint64
+	IOPSRead uint64 `json:"iops_read,omitempty"`
 
 	// IOPSWrite is the write IOPS limit.
-	IOPSWrite uint64
+	IOPSWrite uint64 `json:"iops_write,omitempty"`
 
 	// BPSMax is the burst BPS limit.
-	BPSMax uint64
+	BPSMax uint64 `json:"bps_max,omitempty"`
 
 	// IOPSMax is the burst IOPS limit.
-	IOPSMax uint64
+	IOPSMax uint64 `json:"iops_max,omitempty"`
 
 	// BurstLength is the burst duration in seconds.
-	BurstLength int
+	BurstLength int `json:"burst_length,omitempty"`
 }
 
 // BuildThrottleGroupArgs builds throttle-group object arguments.
@@ -400,27 +553,40 @@ func (t *ThrottleConfig) BuildThrottleBlockdevArgs(id, fileNode string) []string
 		"throttle-group": t.Group,
 	}
 
-	throttleJSON, _ := json.Marshal(throttleOpts)
+	throttleJSON := blockdevJSON(throttleOpts)
 
-	return []string{"-blockdev", string(throttleJSON)}
+	return []string{"-blockdev", throttleJSON}
 }
 
 // CDROMConfig configures a CD-ROM drive.
 type CDROMConfig struct {
 	// Path is the ISO file path.
-	Path string
+	Path string `json:"path,omitempty"`
 
 	// BootIndex sets the boot priority.
-	BootIndex int
+	BootIndex int `json:"boot_index,omitempty"`
+}
+
+// nodeName returns the name of the block node the backend of a disk
+// exposes, below any throttle filter.
+func (cfg *DiskConfig) nodeName() string {
+	id := cfg.ID
+	if id == "" {
+		id = "drive0"
+	}
+	if cfg.Backend != nil && cfg.Backend.Type() == "iscsi" {
+		return id + "-iscsi"
+	}
+	return id + "-format"
 }
 
 // buildDiskArgs builds all arguments for a disk configuration.
-func buildDiskArgs(cfg *DiskConfig, pciAlloc *pciSlotAllocator) []string {
+func buildDiskArgs(cfg *DiskConfig, profile *archProfile, pciAlloc *pciSlotAllocator, ccwAlloc *ccwDevnoAllocator) []string {
 	if cfg == nil || cfg.Backend == nil {
 		return nil
 	}
 
-	var args []string
+	args := make([]string, 0, 10)
 
 	id := cfg.ID
 	if id == "" {
@@ -432,19 +598,13 @@ func buildDiskArgs(cfg *DiskConfig, pciAlloc *pciSlotAllocator) []string {
 		args = append(args, cfg.Throttle.BuildThrottleGroupArgs()...)
 	}
 
+	// Determine the final node name
+	finalNode := cfg.nodeName()
+
 	// Build backend blockdev args
-	backendArgs := cfg.Backend.BuildBlockdevArgs(id)
+	backendArgs := cfg.applyBlockdevOptions(cfg.Backend.BuildBlockdevArgs(id), finalNode)
 	args = append(args, backendArgs...)
 
-	// Determine the final node name
-	var finalNode string
-	switch cfg.Backend.Type() {
-	case "iscsi":
-		finalNode = id + "-iscsi"
-	default:
-		finalNode = id + "-format"
-	}
-
 	// Add throttle layer if configured
 	if cfg.Throttle != nil && cfg.Throttle.Group != "" {
 		throttleNode := id + "-throttle"
@@ -452,6 +612,13 @@ func buildDiskArgs(cfg *DiskConfig, pciAlloc *pciSlotAllocator) []string {
 		finalNode = throttleNode
 	}
 
+	// Record/replay logs the completion order of requests
+	if cfg.replay {
+		replayNode := id + "-replay"
+		args = append(args, "-blockdev", "driver=blkreplay,node-name="+replayNode+",image="+finalNode)
+		finalNode = replayNode
+	}
+
 	// Build device
 	iface := cfg.Interface
 	if iface == "" {
@@ -461,30 +628,51 @@ func buildDiskArgs(cfg *DiskConfig, pciAlloc *pciSlotAllocator) []string {
 	var deviceType string
 	switch iface {
 	case "virtio":
-		deviceType = "virtio-blk-pci"
+		deviceType = profile.virtio("virtio-blk")
 	case "scsi":
-		// For SCSI, we need to add a controller first (handled separately)
+		// The controller is built by VMBuilder.buildSCSIControllers
 		deviceType = "scsi-hd"
 	case "ide":
 		deviceType = "ide-hd"
 	case "nvme":
 		deviceType = "nvme"
 	default:
-		deviceType = "virtio-blk-pci"
+		deviceType = profile.virtio("virtio-blk")
 	}
 
-	deviceArgs := fmt.Sprintf("%s,drive=%s,id=%s-device", deviceType, finalNode, id)
+	deviceArgs := deviceType + ",drive=" + finalNode + ",id=" + id + "-device"
+	if iface == "scsi" {
+		deviceArgs += scsiDeviceOpts(cfg.SCSI)
+	}
 
-	if pciAlloc != nil && (iface == "virtio" || iface == "nvme") {
-		deviceArgs += fmt.Sprintf(",bus=%s,addr=%s", pciAlloc.Bus(), pciAlloc.Alloc())
+	switch {
+	case pciAlloc != nil && ((iface == "virtio" && profile.virtioOnPCI()) || iface == "nvme"):
+		deviceArgs += ",bus=" + pciAlloc.Bus() + ",addr=" + pciAlloc.Alloc()
+	case ccwAlloc != nil && iface == "virtio" && profile.virtioOnCCW():
+		deviceArgs += ",devno=" + ccwAlloc.Alloc()
 	}
 
 	if cfg.BootIndex > 0 {
-		deviceArgs += fmt.Sprintf(",bootindex=%d", cfg.BootIndex)
+		deviceArgs += ",bootindex=" + strconv.Itoa(cfg.BootIndex)
 	}
 
 	if cfg.Serial != "" {
-		deviceArgs += fmt.Sprintf(",serial=%s", cfg.Serial)
+		deviceArgs += ",serial=" + cfg.Serial
+	}
+
+	if mode, ok := diskCacheModes[cfg.Cache]; ok && !mode.writeCache {
+		deviceArgs += ",write-cache=off"
+	}
+
+	if cfg.IOThread != "" {
+		deviceArgs += ",iothread=" + cfg.IOThread
+	}
+
+	if cfg.WriteError != "" {
+		deviceArgs += ",werror=" + cfg.WriteError
+	}
+	if cfg.ReadError != "" {
+		deviceArgs += ",rerror=" + cfg.ReadError
 	}
 
 	args = append(args, "-device", deviceArgs)
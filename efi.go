@@ -0,0 +1,67 @@
+package qemuctl
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// checkSecureBoot checks the configuration of a Secure Boot guest.
+func (cfg *VMConfig) checkSecureBoot() error {
+	efi := cfg.EFI
+	switch {
+	case efi.Code == "":
+		return fmt.Errorf("Secure Boot needs EFI firmware code")
+	case efi.Vars == "":
+		return fmt.Errorf("Secure Boot needs an EFI vars file to hold the enrolled keys")
+	case cfg.Arch != "" && cfg.Arch != "amd64" && cfg.Arch != "386":
+		return fmt.Errorf("Secure Boot with SMM is only supported on x86, not %s", cfg.Arch)
+	case cfg.Machine != nil && cfg.Machine.Type != "" && !isQ35(cfg.Machine.Type):
+		return fmt.Errorf("Secure Boot needs a q35 machine, not %s", cfg.Machine.Type)
+	}
+	return nil
+}
+
+// prepareVars creates the vars file from VarsTemplate if it does not exist
+// yet.
+func (efi *EFIConfig) prepareVars() error {
+	if efi.Vars == "" || efi.VarsTemplate == "" {
+		return nil
+	}
+	if _, err := os.Stat(efi.Vars); err == nil || !os.IsNotExist(err) {
+		return nil
+	}
+
+	src, err := os.Open(efi.VarsTemplate)
+	if err != nil {
+		return fmt.Errorf("failed to open EFI vars template: %w", err)
+	}
+	defer src.Close()
+
+	// Copy to a temporary file first so that a failed copy does not leave a
+	// truncated vars file behind
+	if err := os.MkdirAll(filepath.Dir(efi.Vars), 0755); err != nil {
+		return fmt.Errorf("failed to create EFI vars directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(efi.Vars), "."+filepath.Base(efi.Vars)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create EFI vars: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, src); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to copy EFI vars template: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to copy EFI vars template: %w", err)
+	}
+	if err := os.Link(tmp.Name(), efi.Vars); err != nil {
+		if os.IsExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to create EFI vars: %w", err)
+	}
+	return nil
+}
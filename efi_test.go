@@ -0,0 +1,67 @@
+package qemuctl
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVMBuilderWithSecureBoot(t *testing.T) {
+	cfg := &VMConfig{
+		EFI: &EFIConfig{
+			Code:       "/usr/share/OVMF/OVMF_CODE.secboot.fd",
+			Vars:       "/var/lib/qemu/test_VARS.fd",
+			SecureBoot: true,
+		},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	argsStr := strings.Join(NewVMBuilder(cfg).Build("test-vm", "/tmp/test.sock"), " ")
+	for _, want := range []string{
+		"-machine q35,accel=kvm,pflash0=pflash0,pflash1=pflash1,smm=on",
+		"-global driver=cfi.pflash01,property=secure,value=on",
+	} {
+		if !strings.Contains(argsStr, want) {
+			t.Errorf("expected %q, got: %s", want, argsStr)
+		}
+	}
+
+	for _, cfg := range []*VMConfig{
+		{EFI: &EFIConfig{Code: "/code.fd", SecureBoot: true}},
+		{Arch: "arm64", EFI: &EFIConfig{Code: "/code.fd", Vars: "/vars.fd", SecureBoot: true}},
+		{Machine: &MachineConfig{Type: "pc"}, EFI: &EFIConfig{Code: "/code.fd", Vars: "/vars.fd", SecureBoot: true}},
+	} {
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Validate accepted %+v", cfg.EFI)
+		}
+	}
+}
+
+func TestEFIPrepareVars(t *testing.T) {
+	dir := t.TempDir()
+	template := filepath.Join(dir, "OVMF_VARS.secboot.fd")
+	os.WriteFile(template, []byte("template"), 0o644)
+
+	efi := &EFIConfig{Vars: filepath.Join(dir, "vms", "test_VARS.fd"), VarsTemplate: template}
+	if err := efi.prepareVars(); err != nil {
+		t.Fatalf("prepareVars failed: %v", err)
+	}
+	if data, _ := os.ReadFile(efi.Vars); string(data) != "template" {
+		t.Errorf("vars = %q", data)
+	}
+
+	// Existing vars hold enrolled keys and must be kept
+	os.WriteFile(efi.Vars, []byte("enrolled"), 0o600)
+	if err := efi.prepareVars(); err != nil {
+		t.Fatalf("prepareVars failed: %v", err)
+	}
+	if data, _ := os.ReadFile(efi.Vars); string(data) != "enrolled" {
+		t.Errorf("vars overwritten: %q", data)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(efi.Vars)); len(entries) != 1 {
+		t.Errorf("temporary files left: %v", entries)
+	}
+}
@@ -6,4 +6,19 @@ import "errors"
 var (
 	ErrNotConnected = errors.New("not connected to QEMU")
 	ErrNotRunning   = errors.New("QEMU process not running")
+
+	// ErrOOBNotSupported is returned by ExecuteOOB when the monitor did
+	// not offer the "oob" capability.
+	ErrOOBNotSupported = errors.New("QMP out-of-band execution not supported")
+
+	// ErrGuestAgentDisconnected is returned when the guest agent connection
+	// is lost during a command that is not safe to repeat. The command may
+	// or may not have run in the guest.
+	ErrGuestAgentDisconnected = errors.New("guest agent disconnected")
+
+	// ErrPostcopyPaused is returned by WaitMigration when a post-copy
+	// migration lost its connection. The guest runs on the destination but
+	// stalls on the memory still on the source until the migration is
+	// recovered.
+	ErrPostcopyPaused = errors.New("post-copy migration paused")
 )
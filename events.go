@@ -0,0 +1,165 @@
+package qemuctl
+
+import "encoding/json"
+
+// ShutdownEvent is the payload of SHUTDOWN.
+type ShutdownEvent struct {
+	// Guest is true if the shutdown was requested by the guest.
+	Guest bool `json:"guest"`
+
+	// Reason is the cause of the shutdown, e.g. "host-qmp-quit",
+	// "guest-shutdown" or "guest-panic".
+	Reason string `json:"reason"`
+}
+
+// BlockJobCompletedEvent is the payload of BLOCK_JOB_COMPLETED.
+type BlockJobCompletedEvent struct {
+	// Type is the job type ("stream", "commit", "mirror", "backup", ...).
+	Type string `json:"type"`
+
+	// Device is the job ID.
+	Device string `json:"device"`
+
+	Len    int64 `json:"len"`
+	Offset int64 `json:"offset"`
+	Speed  int64 `json:"speed"`
+
+	// Error is set if the job failed.
+	Error string `json:"error,omitempty"`
+}
+
+// DeviceDeletedEvent is the payload of DEVICE_DELETED.
+type DeviceDeletedEvent struct {
+	// Device is the device ID, empty for devices without one.
+	Device string `json:"device,omitempty"`
+
+	// Path is the QOM path of the device.
+	Path string `json:"path"`
+}
+
+// GuestPanickedEvent is the payload of GUEST_PANICKED.
+type GuestPanickedEvent struct {
+	// Action is what QEMU did: "pause", "poweroff" or "run".
+	Action string `json:"action"`
+
+	// Info holds the panic details reported by the guest, if any.
+	Info *GuestPanicInfo `json:"info,omitempty"`
+}
+
+// GuestPanicInfo describes a guest panic. Which fields are set depends on
+// Type ("hyper-v", "s390" or "tdx").
+type GuestPanicInfo struct {
+	Type string `json:"type"`
+
+	// Hyper-V crash parameters
+	Arg1 uint64 `json:"arg1,omitempty"`
+	Arg2 uint64 `json:"arg2,omitempty"`
+	Arg3 uint64 `json:"arg3,omitempty"`
+	Arg4 uint64 `json:"arg4,omitempty"`
+	Arg5 uint64 `json:"arg5,omitempty"`
+
+	// s390 crash reason
+	Reason string `json:"reason,omitempty"`
+
+	// TDX error message
+	Message string `json:"message,omitempty"`
+}
+
+// WatchdogEvent is the payload of WATCHDOG.
+type WatchdogEvent struct {
+	// Action is the watchdog action taken ("reset", "shutdown", "pause",
+	// "debug", "none", "inject-nmi").
+	Action string `json:"action"`
+}
+
+// NicRxFilterChangedEvent is the payload of NIC_RX_FILTER_CHANGED.
+type NicRxFilterChangedEvent struct {
+	// Name is the NIC ID, empty for NICs without one.
+	Name string `json:"name,omitempty"`
+
+	// Path is the QOM path of the NIC.
+	Path string `json:"path"`
+}
+
+// MigrationEvent is the payload of MIGRATION, sent on each change of the
+// migration status once the "events" migration capability is enabled.
+type MigrationEvent struct {
+	// Status is one of the MigrationStatus constants.
+	Status string `json:"status"`
+}
+
+// VserportChangeEvent is the payload of VSERPORT_CHANGE, sent when the guest
+// opens or closes a virtio-serial port.
+type VserportChangeEvent struct {
+	// ID is the port's device ID. Ports without an ID send no events.
+	ID string `json:"id"`
+
+	// Open reports whether the guest has the port open.
+	Open bool `json:"open"`
+}
+
+// BlockIOErrorEvent is the payload of BLOCK_IO_ERROR.
+type BlockIOErrorEvent struct {
+	// Device is the drive ID, empty for disks configured with -blockdev.
+	Device string `json:"device"`
+
+	// NodeName is the block node that failed, e.g. "disk0-format".
+	NodeName string `json:"node-name,omitempty"`
+
+	// Operation is "read" or "write".
+	Operation string `json:"operation"`
+
+	// Action is what QEMU did: "report", "ignore" or "stop".
+	Action string `json:"action"`
+
+	// NoSpace is set if the host ran out of space.
+	NoSpace bool `json:"nospace,omitempty"`
+
+	// Reason is the error message.
+	Reason string `json:"reason"`
+}
+
+// eventTypes maps event names to their payload types.
+var eventTypes = map[string]func() any{
+	"SHUTDOWN":              func() any { return &ShutdownEvent{} },
+	"BLOCK_JOB_COMPLETED":   func() any { return &BlockJobCompletedEvent{} },
+	"DEVICE_DELETED":        func() any { return &DeviceDeletedEvent{} },
+	"GUEST_PANICKED":        func() any { return &GuestPanickedEvent{} },
+	"WATCHDOG":              func() any { return &WatchdogEvent{} },
+	"NIC_RX_FILTER_CHANGED": func() any { return &NicRxFilterChangedEvent{} },
+	"JOB_STATUS_CHANGE":     func() any { return &JobStatusChangeEvent{} },
+	"VSERPORT_CHANGE":       func() any { return &VserportChangeEvent{} },
+	"BLOCK_IO_ERROR":        func() any { return &BlockIOErrorEvent{} },
+	"MIGRATION":             func() any { return &MigrationEvent{} },
+}
+
+// Decode decodes the event payload into v.
+func (e *Event) Decode(v any) error {
+	data, err := json.Marshal(e.Data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Payload returns the typed payload of the event, such as *ShutdownEvent
+// for SHUTDOWN, or nil if the event has no payload type:
+//
+//	switch p := event.Payload().(type) {
+//	case *qemuctl.ShutdownEvent:
+//		log.Printf("shutdown (guest=%v): %s", p.Guest, p.Reason)
+//	case *qemuctl.DeviceDeletedEvent:
+//		log.Printf("device %s removed", p.Device)
+//	}
+func (e *Event) Payload() any {
+	newPayload, ok := eventTypes[e.Name]
+	if !ok {
+		return nil
+	}
+
+	v := newPayload()
+	if err := e.Decode(v); err != nil {
+		return nil
+	}
+	return v
+}
@@ -0,0 +1,65 @@
+package qemuctl
+
+import "testing"
+
+func TestEventPayload(t *testing.T) {
+	tests := []struct {
+		event *Event
+		check func(t *testing.T, payload any)
+	}{
+		{
+			&Event{Name: "SHUTDOWN", Data: map[string]any{"guest": true, "reason": "guest-shutdown"}},
+			func(t *testing.T, payload any) {
+				p, ok := payload.(*ShutdownEvent)
+				if !ok || !p.Guest || p.Reason != "guest-shutdown" {
+					t.Errorf("got %#v", payload)
+				}
+			},
+		},
+		{
+			&Event{Name: "BLOCK_JOB_COMPLETED", Data: map[string]any{
+				"type": "mirror", "device": "job0", "len": 1073741824.0, "offset": 1073741824.0, "speed": 0.0,
+			}},
+			func(t *testing.T, payload any) {
+				p, ok := payload.(*BlockJobCompletedEvent)
+				if !ok || p.Type != "mirror" || p.Device != "job0" || p.Len != 1<<30 || p.Error != "" {
+					t.Errorf("got %#v", payload)
+				}
+			},
+		},
+		{
+			&Event{Name: "GUEST_PANICKED", Data: map[string]any{
+				"action": "pause",
+				"info":   map[string]any{"type": "hyper-v", "arg1": 30.0},
+			}},
+			func(t *testing.T, payload any) {
+				p, ok := payload.(*GuestPanickedEvent)
+				if !ok || p.Action != "pause" || p.Info == nil || p.Info.Type != "hyper-v" || p.Info.Arg1 != 30 {
+					t.Errorf("got %#v", payload)
+				}
+			},
+		},
+		{
+			&Event{Name: "DEVICE_DELETED", Data: map[string]any{"device": "net1", "path": "/machine/peripheral/net1"}},
+			func(t *testing.T, payload any) {
+				if p, ok := payload.(*DeviceDeletedEvent); !ok || p.Device != "net1" {
+					t.Errorf("got %#v", payload)
+				}
+			},
+		},
+		{
+			&Event{Name: "RESUME"},
+			func(t *testing.T, payload any) {
+				if payload != nil {
+					t.Errorf("got %#v, want nil", payload)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.event.Name, func(t *testing.T) {
+			tt.check(t, tt.event.Payload())
+		})
+	}
+}
@@ -2,7 +2,12 @@
 
 package qemuctl
 
-import "syscall"
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
 
 // dupFd duplicates a file descriptor.
 func dupFd(fd int) (int, error) {
@@ -13,3 +18,27 @@ func dupFd(fd int) (int, error) {
 func closeFd(fd int) error {
 	return syscall.Close(fd)
 }
+
+// socketPair returns the two ends of a connected unix socket pair.
+func socketPair() (net.Conn, net.Conn, error) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create socket pair: %w", err)
+	}
+
+	var conns [2]net.Conn
+	for i, fd := range fds {
+		f := os.NewFile(uintptr(fd), "socketpair")
+		conns[i], err = net.FileConn(f)
+		f.Close()
+		if err != nil {
+			if i == 0 {
+				syscall.Close(fds[1])
+			} else {
+				conns[0].Close()
+			}
+			return nil, nil, fmt.Errorf("failed to create socket pair: %w", err)
+		}
+	}
+	return conns[0], conns[1], nil
+}
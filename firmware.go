@@ -0,0 +1,253 @@
+package qemuctl
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// ErrFirmwareNotFound is returned when no installed EFI firmware matches.
+var ErrFirmwareNotFound = errors.New("EFI firmware not found")
+
+// Firmware features, as named by the QEMU firmware descriptors.
+const (
+	FirmwareSecureBoot   = "secure-boot"
+	FirmwareEnrolledKeys = "enrolled-keys"
+	FirmwareRequiresSMM  = "requires-smm"
+	FirmwareAMDSEV       = "amd-sev"
+	FirmwareAMDSEVES     = "amd-sev-es"
+	FirmwareAMDSEVSNP    = "amd-sev-snp"
+)
+
+// firmwareDescriptorDirs are the directories of firmware descriptors, from
+// lowest to highest priority. A descriptor overrides any descriptor of the
+// same name in a lower priority directory.
+var firmwareDescriptorDirs = []string{
+	"/usr/share/qemu/firmware",
+	"/etc/qemu/firmware",
+}
+
+// firmwareCandidate is firmware at a well-known path, for hosts without
+// firmware descriptors.
+type firmwareCandidate struct {
+	code, vars string
+	features   []string
+}
+
+// firmwareSearchPaths are the well-known firmware paths of distributions,
+// per GOARCH value, in order of preference.
+var firmwareSearchPaths = map[string][]firmwareCandidate{
+	"amd64": {
+		{"/usr/share/OVMF/OVMF_CODE_4M.secboot.fd", "/usr/share/OVMF/OVMF_VARS_4M.ms.fd", []string{FirmwareSecureBoot, FirmwareEnrolledKeys, FirmwareRequiresSMM}},
+		{"/usr/share/edk2/ovmf/OVMF_CODE.secboot.fd", "/usr/share/edk2/ovmf/OVMF_VARS.secboot.fd", []string{FirmwareSecureBoot, FirmwareEnrolledKeys, FirmwareRequiresSMM}},
+		{"/usr/share/edk2/x64/OVMF_CODE.secboot.4m.fd", "/usr/share/edk2/x64/OVMF_VARS.4m.fd", []string{FirmwareSecureBoot, FirmwareRequiresSMM}},
+		{"/usr/share/OVMF/OVMF_CODE_4M.fd", "/usr/share/OVMF/OVMF_VARS_4M.fd", nil},
+		{"/usr/share/OVMF/OVMF_CODE.fd", "/usr/share/OVMF/OVMF_VARS.fd", nil},
+		{"/usr/share/edk2/ovmf/OVMF_CODE.fd", "/usr/share/edk2/ovmf/OVMF_VARS.fd", nil},
+		{"/usr/share/edk2/x64/OVMF_CODE.4m.fd", "/usr/share/edk2/x64/OVMF_VARS.4m.fd", nil},
+		{"/usr/share/edk2-ovmf/x64/OVMF_CODE.fd", "/usr/share/edk2-ovmf/x64/OVMF_VARS.fd", nil},
+		{"/usr/share/qemu/edk2-x86_64-code.fd", "/usr/share/qemu/edk2-i386-vars.fd", nil},
+	},
+	"arm64": {
+		{"/usr/share/AAVMF/AAVMF_CODE.ms.fd", "/usr/share/AAVMF/AAVMF_VARS.ms.fd", []string{FirmwareSecureBoot, FirmwareEnrolledKeys}},
+		{"/usr/share/AAVMF/AAVMF_CODE.fd", "/usr/share/AAVMF/AAVMF_VARS.fd", nil},
+		{"/usr/share/edk2/aarch64/QEMU_EFI-pflash.raw", "/usr/share/edk2/aarch64/vars-template-pflash.raw", nil},
+		{"/usr/share/qemu/edk2-aarch64-code.fd", "/usr/share/qemu/edk2-arm-vars.fd", nil},
+	},
+	"arm": {
+		{"/usr/share/AAVMF/AAVMF32_CODE.fd", "/usr/share/AAVMF/AAVMF32_VARS.fd", nil},
+		{"/usr/share/qemu/edk2-arm-code.fd", "/usr/share/qemu/edk2-arm-vars.fd", nil},
+	},
+	"riscv64": {
+		{"/usr/share/qemu/edk2-riscv-code.fd", "/usr/share/qemu/edk2-riscv-vars.fd", nil},
+	},
+}
+
+// firmwareDescriptor is the subset of the QEMU firmware descriptor schema
+// (docs/interop/firmware.json) used to select firmware.
+type firmwareDescriptor struct {
+	InterfaceTypes []string `json:"interface-types"`
+	Mapping        struct {
+		Device     string `json:"device"`
+		Mode       string `json:"mode"`
+		Executable struct {
+			Filename string `json:"filename"`
+			Format   string `json:"format"`
+		} `json:"executable"`
+		NVRAMTemplate struct {
+			Filename string `json:"filename"`
+			Format   string `json:"format"`
+		} `json:"nvram-template"`
+	} `json:"mapping"`
+	Targets []struct {
+		Architecture string `json:"architecture"`
+	} `json:"targets"`
+	Features []string `json:"features"`
+}
+
+// FindFirmware finds installed EFI firmware for the given architecture and
+// returns it as an EFIConfig with Code and VarsTemplate set. The caller
+// sets Vars to the VM's own vars file, which is created from the template
+// when the VM starts.
+//
+// Features select the firmware: "secure-boot" or "+secure-boot" requires a
+// feature, "-secure-boot" excludes it. See the Firmware constants for the
+// usual features. The firmware descriptors installed with QEMU are
+// consulted first, in their priority order, then the paths used by common
+// distributions. SecureBoot is set on the result when the firmware needs
+// SMM.
+//
+// The arch parameter should be a GOARCH-style value (e.g., "amd64", "arm64").
+// If arch is empty, it defaults to runtime.GOARCH.
+func FindFirmware(arch string, features ...string) (*EFIConfig, error) {
+	if arch == "" {
+		arch = runtime.GOARCH
+	}
+	qemuArch, ok := archToQemu[arch]
+	if !ok {
+		return nil, &UnsupportedArchError{Arch: arch}
+	}
+
+	for _, desc := range loadFirmwareDescriptors() {
+		if !desc.usable(qemuArch) || !firmwareMatches(desc.Features, features) {
+			continue
+		}
+		efi := &EFIConfig{
+			Code:         desc.Mapping.Executable.Filename,
+			VarsTemplate: desc.Mapping.NVRAMTemplate.Filename,
+			SecureBoot:   hasFeature(desc.Features, FirmwareRequiresSMM),
+		}
+		if firmwareExists(efi) {
+			return efi, nil
+		}
+	}
+
+	for _, c := range firmwareSearchPaths[arch] {
+		if !firmwareMatches(c.features, features) {
+			continue
+		}
+		efi := &EFIConfig{
+			Code:         c.code,
+			VarsTemplate: c.vars,
+			SecureBoot:   hasFeature(c.features, FirmwareRequiresSMM),
+		}
+		if firmwareExists(efi) {
+			return efi, nil
+		}
+	}
+
+	return nil, ErrFirmwareNotFound
+}
+
+// loadFirmwareDescriptors reads the firmware descriptors in priority order.
+// Descriptors are sorted by file name across all directories, and an empty
+// file masks a descriptor of the same name in a lower priority directory.
+func loadFirmwareDescriptors() []*firmwareDescriptor {
+	dirs := firmwareDescriptorDirs
+	if os.Geteuid() != 0 {
+		if dir, err := os.UserConfigDir(); err == nil {
+			dirs = append(dirs[:len(dirs):len(dirs)], filepath.Join(dir, "qemu", "firmware"))
+		}
+	}
+
+	paths := make(map[string]string)
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") {
+				paths[e.Name()] = filepath.Join(dir, e.Name())
+			}
+		}
+	}
+
+	names := make([]string, 0, len(paths))
+	for name := range paths {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var descs []*firmwareDescriptor
+	for _, name := range names {
+		data, err := os.ReadFile(paths[name])
+		if err != nil || len(data) == 0 {
+			continue
+		}
+		desc := &firmwareDescriptor{}
+		if err := json.Unmarshal(data, desc); err != nil {
+			continue
+		}
+		descs = append(descs, desc)
+	}
+	return descs
+}
+
+// usable reports whether the descriptor is raw UEFI flash firmware for
+// qemuArch, which EFIConfig can describe.
+func (d *firmwareDescriptor) usable(qemuArch string) bool {
+	m := &d.Mapping
+	if !hasFeature(d.InterfaceTypes, "uefi") || m.Device != "flash" || m.Executable.Filename == "" {
+		return false
+	}
+	// Combined firmware keeps its variables in the code file, which is
+	// mapped read-only
+	if m.Mode == "combined" {
+		return false
+	}
+	if m.Executable.Format != "" && m.Executable.Format != "raw" {
+		return false
+	}
+	if m.NVRAMTemplate.Format != "" && m.NVRAMTemplate.Format != "raw" {
+		return false
+	}
+	for _, t := range d.Targets {
+		if t.Architecture == qemuArch {
+			return true
+		}
+	}
+	return false
+}
+
+// firmwareMatches reports whether firmware with the given features
+// satisfies the requested features.
+func firmwareMatches(have, want []string) bool {
+	for _, f := range want {
+		switch {
+		case strings.HasPrefix(f, "-"):
+			if hasFeature(have, f[1:]) {
+				return false
+			}
+		case !hasFeature(have, strings.TrimPrefix(f, "+")):
+			return false
+		}
+	}
+	return true
+}
+
+func hasFeature(features []string, name string) bool {
+	for _, f := range features {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// firmwareExists reports whether the firmware files are installed.
+func firmwareExists(efi *EFIConfig) bool {
+	if _, err := os.Stat(efi.Code); err != nil {
+		return false
+	}
+	if efi.VarsTemplate != "" {
+		if _, err := os.Stat(efi.VarsTemplate); err != nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,62 @@
+package qemuctl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindFirmware(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "config"))
+	for _, name := range []string{"secboot.fd", "secboot-vars.fd", "plain.fd", "plain-vars.fd", "sev.fd", "fallback.fd"} {
+		os.WriteFile(filepath.Join(dir, name), nil, 0o644)
+	}
+
+	share := filepath.Join(dir, "share")
+	etc := filepath.Join(dir, "etc")
+	os.Mkdir(share, 0o755)
+	os.Mkdir(etc, 0o755)
+	descriptor := func(path, code, vars, arch, features string) {
+		nvram := ""
+		if vars != "" {
+			nvram = `, "nvram-template": {"filename": "` + filepath.Join(dir, vars) + `", "format": "raw"}`
+		}
+		os.WriteFile(path, []byte(`{
+			"interface-types": ["uefi"],
+			"mapping": {"device": "flash", "executable": {"filename": "`+filepath.Join(dir, code)+`", "format": "raw"}`+nvram+`},
+			"targets": [{"architecture": "`+arch+`", "machines": ["pc-q35-*"]}],
+			"features": [`+features+`]
+		}`), 0o644)
+	}
+	descriptor(filepath.Join(share, "30-secboot.json"), "secboot.fd", "secboot-vars.fd", "x86_64", `"secure-boot", "enrolled-keys", "requires-smm"`)
+	descriptor(filepath.Join(share, "40-plain.json"), "plain.fd", "plain-vars.fd", "x86_64", `"acpi-s3"`)
+	descriptor(filepath.Join(share, "50-sev.json"), "sev.fd", "", "x86_64", `"amd-sev", "amd-sev-es"`)
+	descriptor(filepath.Join(share, "60-missing.json"), "missing.fd", "", "aarch64", ``)
+	os.WriteFile(filepath.Join(etc, "50-sev.json"), nil, 0o644) // masked
+
+	oldDirs, oldPaths := firmwareDescriptorDirs, firmwareSearchPaths
+	defer func() { firmwareDescriptorDirs, firmwareSearchPaths = oldDirs, oldPaths }()
+	firmwareDescriptorDirs = []string{share, etc}
+	firmwareSearchPaths = map[string][]firmwareCandidate{
+		"amd64": {{filepath.Join(dir, "fallback.fd"), "", []string{FirmwareAMDSEV}}},
+		"arm64": {{filepath.Join(dir, "missing.fd"), "", nil}},
+	}
+
+	efi, err := FindFirmware("amd64")
+	if err != nil || efi.Code != filepath.Join(dir, "secboot.fd") || efi.VarsTemplate != filepath.Join(dir, "secboot-vars.fd") || !efi.SecureBoot {
+		t.Errorf("FindFirmware = %+v, %v", efi, err)
+	}
+	if efi, err := FindFirmware("amd64", "-"+FirmwareSecureBoot); err != nil || efi.Code != filepath.Join(dir, "plain.fd") || efi.SecureBoot {
+		t.Errorf("FindFirmware(-secure-boot) = %+v, %v", efi, err)
+	}
+	if efi, err := FindFirmware("amd64", "+"+FirmwareAMDSEV); err != nil || efi.Code != filepath.Join(dir, "fallback.fd") {
+		t.Errorf("FindFirmware(amd-sev) = %+v, %v", efi, err)
+	}
+	if _, err := FindFirmware("arm64"); err != ErrFirmwareNotFound {
+		t.Errorf("FindFirmware(arm64) = %v", err)
+	}
+	if _, err := FindFirmware("sparc"); err == nil {
+		t.Error("FindFirmware accepted an unsupported architecture")
+	}
+}
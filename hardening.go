@@ -0,0 +1,124 @@
+package qemuctl
+
+import "strings"
+
+// HardeningConfig enables conservative QEMU behavior for production fleets.
+type HardeningConfig struct {
+	// DeprecatedInput is the policy for deprecated QMP commands and arguments
+	// ("accept", "reject", "crash").
+	DeprecatedInput string `json:"deprecated_input,omitempty"`
+
+	// DeprecatedOutput is the policy for deprecated QMP output ("accept", "hide").
+	DeprecatedOutput string `json:"deprecated_output,omitempty"`
+
+	// UnstableInput is the policy for unstable QMP commands and arguments
+	// ("accept", "reject", "crash").
+	UnstableInput string `json:"unstable_input,omitempty"`
+
+	// UnstableOutput is the policy for unstable QMP output ("accept", "hide").
+	UnstableOutput string `json:"unstable_output,omitempty"`
+
+	// OnlyMigratable refuses devices that would block live migration.
+	// It is equivalent to VMConfig.OnlyMigratable.
+	OnlyMigratable bool `json:"only_migratable,omitempty"`
+
+	// SplitWX maps TCG translated code twice, once writable and once
+	// executable, so no page is both (W^X). Only applies to the TCG accelerator.
+	SplitWX bool `json:"split_wx,omitempty"`
+}
+
+// StrictHardening returns a HardeningConfig that rejects deprecated and
+// unstable monitor usage and hides them from output.
+func StrictHardening() *HardeningConfig {
+	return &HardeningConfig{
+		DeprecatedInput:  "reject",
+		DeprecatedOutput: "hide",
+		UnstableInput:    "reject",
+		UnstableOutput:   "hide",
+	}
+}
+
+// buildHardeningArgs builds hardening-related arguments.
+func buildHardeningArgs(cfg *HardeningConfig) []string {
+	if cfg == nil {
+		return nil
+	}
+
+	var args []string
+
+	var compat []string
+	if cfg.DeprecatedInput != "" {
+		compat = append(compat, "deprecated-input="+cfg.DeprecatedInput)
+	}
+	if cfg.DeprecatedOutput != "" {
+		compat = append(compat, "deprecated-output="+cfg.DeprecatedOutput)
+	}
+	if cfg.UnstableInput != "" {
+		compat = append(compat, "unstable-input="+cfg.UnstableInput)
+	}
+	if cfg.UnstableOutput != "" {
+		compat = append(compat, "unstable-output="+cfg.UnstableOutput)
+	}
+	if len(compat) > 0 {
+		args = append(args, "-compat", strings.Join(compat, ","))
+	}
+
+	if cfg.OnlyMigratable {
+		args = append(args, "-only-migratable")
+	}
+
+	return args
+}
+
+// nonMigratableDevices lists device drivers that block live migration.
+var nonMigratableDevices = map[string]bool{
+	"usb-host":         true,
+	"vfio-pci":         true,
+	"vfio-ccw":         true,
+	"vfio-ap":          true,
+	"vfio-platform":    true,
+	"ivshmem-doorbell": true,
+}
+
+// NotMigratableError is returned by VMConfig.Validate when OnlyMigratable
+// is set and the configuration contains a device that blocks migration.
+type NotMigratableError struct {
+	Device string
+}
+
+func (e *NotMigratableError) Error() string {
+	return "device " + e.Device + " blocks migration but only migratable devices are allowed"
+}
+
+// onlyMigratable reports whether -only-migratable is requested.
+func (cfg *VMConfig) onlyMigratable() bool {
+	return cfg.OnlyMigratable || (cfg.Hardening != nil && cfg.Hardening.OnlyMigratable)
+}
+
+// checkMigratable returns a NotMigratableError for the first device that
+// would block live migration.
+func (cfg *VMConfig) checkMigratable() error {
+	for _, dev := range cfg.USBDevices {
+		if nonMigratableDevices[dev.Type] {
+			return &NotMigratableError{Device: dev.Type}
+		}
+	}
+	if len(cfg.USBHostDevices) > 0 {
+		return &NotMigratableError{Device: "usb-host"}
+	}
+	if len(cfg.Mdevs) > 0 {
+		return &NotMigratableError{Device: "vfio-pci"}
+	}
+
+	for i := 0; i < len(cfg.ExtraArgs)-1; i++ {
+		if cfg.ExtraArgs[i] != "-device" {
+			continue
+		}
+		driver := parseOpts(cfg.ExtraArgs[i+1]).First
+		if nonMigratableDevices[driver] {
+			return &NotMigratableError{Device: driver}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package qemuctl
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// helperStartTimeout bounds the wait for a helper to create its socket.
+const helperStartTimeout = 10 * time.Second
+
+// helperProcess is a process serving a socket QEMU connects to, such as
+// swtpm or passt, started before QEMU and stopped with the instance.
+type helperProcess struct {
+	cmd        *exec.Cmd
+	socketPath string
+	done       chan struct{}
+}
+
+// startHelper starts cmd and waits for it to create socketPath. QEMU
+// connects once, so the socket is only checked for, never dialed. name is
+// used in errors.
+func startHelper(ctx context.Context, name string, cmd *exec.Cmd, socketPath string) (*helperProcess, error) {
+	os.Remove(socketPath)
+
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", name, err)
+	}
+	p := &helperProcess{cmd: cmd, socketPath: socketPath, done: make(chan struct{})}
+	go func() {
+		cmd.Wait()
+		close(p.done)
+	}()
+
+	timer := time.NewTimer(helperStartTimeout)
+	defer timer.Stop()
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if _, err := os.Stat(socketPath); err == nil {
+			return p, nil
+		}
+		select {
+		case <-ticker.C:
+		case <-p.done:
+			p.stop()
+			return nil, fmt.Errorf("%s exited: %s", name, strings.TrimSpace(stderr.String()))
+		case <-timer.C:
+			p.stop()
+			return nil, fmt.Errorf("timeout waiting for %s socket %s", name, socketPath)
+		case <-ctx.Done():
+			p.stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// stop kills the helper if it is still running and removes its socket.
+func (p *helperProcess) stop() {
+	select {
+	case <-p.done:
+	default:
+		p.cmd.Process.Kill()
+		<-p.done
+	}
+	os.Remove(p.socketPath)
+}
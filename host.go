@@ -0,0 +1,103 @@
+package qemuctl
+
+import (
+	"runtime"
+	"sort"
+)
+
+// HostCapabilities describes the virtualization capabilities of the host, for
+// schedulers deciding where to place VMs.
+type HostCapabilities struct {
+	// Arch is the host architecture (GOARCH).
+	Arch string `json:"arch"`
+
+	// KVM reports whether /dev/kvm is usable by this process.
+	KVM bool `json:"kvm"`
+
+	// NestedVirt reports whether the KVM module allows nested guests.
+	NestedVirt bool `json:"nested_virt"`
+
+	// TDX reports whether KVM can run Intel TDX guests.
+	TDX bool `json:"tdx"`
+
+	// IOMMU summarizes IOMMU groups, used for device passthrough.
+	IOMMU IOMMUInfo `json:"iommu"`
+
+	// HugePages lists the huge page pools, smallest page size first.
+	HugePages []HugePagePool `json:"hugepages,omitempty"`
+
+	// Bridges lists the network bridges.
+	Bridges []string `json:"bridges,omitempty"`
+
+	// Qemu lists the installed QEMU system emulators.
+	Qemu []QemuBinary `json:"qemu,omitempty"`
+}
+
+// IOMMUInfo summarizes the host IOMMU groups.
+type IOMMUInfo struct {
+	// Groups is the number of IOMMU groups; zero if the IOMMU is disabled.
+	Groups int `json:"groups"`
+
+	// Devices is the number of devices across all groups.
+	Devices int `json:"devices"`
+}
+
+// HugePagePool is a pool of huge pages of one size.
+type HugePagePool struct {
+	// PageSize is the page size in bytes.
+	PageSize int64 `json:"page_size"`
+
+	// Total is the number of pages in the pool.
+	Total int `json:"total"`
+
+	// Free is the number of unused pages.
+	Free int `json:"free"`
+}
+
+// QemuBinary is an installed QEMU system emulator.
+type QemuBinary struct {
+	// Arch is the guest architecture (GOARCH-style, as in VMConfig.Arch).
+	Arch string `json:"arch"`
+
+	// Path is the binary path.
+	Path string `json:"path"`
+
+	// Version is the QEMU version, zero if it could not be determined.
+	Version QemuVersion `json:"version"`
+}
+
+// HostInfo reports the virtualization capabilities of the host. Hardware
+// details are only available on Linux; elsewhere only the QEMU binaries
+// are listed.
+func HostInfo() *HostCapabilities {
+	info := &HostCapabilities{Arch: runtime.GOARCH}
+	probeHost(info, "/")
+	info.Qemu = installedQemu()
+	return info
+}
+
+// installedQemu lists the QEMU system emulators found for each supported
+// architecture.
+func installedQemu() []QemuBinary {
+	arches := make([]string, 0, len(archToQemu))
+	for arch := range archToQemu {
+		arches = append(arches, arch)
+	}
+	sort.Strings(arches)
+
+	var bins []QemuBinary
+	seen := make(map[string]bool)
+	for _, arch := range arches {
+		path, ok := locateBinary("qemu-system-"+archToQemu[arch], "")
+		if !ok || seen[path] {
+			// ppc64 and ppc64le share a binary
+			continue
+		}
+		seen[path] = true
+
+		bin := QemuBinary{Arch: arch, Path: path}
+		bin.Version, _ = DetectQemuVersion(path)
+		bins = append(bins, bin)
+	}
+	return bins
+}
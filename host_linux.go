@@ -0,0 +1,92 @@
+//go:build linux
+
+package qemuctl
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// probeHost fills the hardware details of info from /dev and /sys under
+// root.
+func probeHost(info *HostCapabilities, root string) {
+	if f, err := os.OpenFile(filepath.Join(root, "dev/kvm"), os.O_RDWR, 0); err == nil {
+		f.Close()
+		info.KVM = true
+	}
+
+	for _, module := range []string{"kvm_intel", "kvm_amd"} {
+		data, err := os.ReadFile(filepath.Join(root, "sys/module", module, "parameters/nested"))
+		if err != nil {
+			continue
+		}
+		switch strings.TrimSpace(string(data)) {
+		case "Y", "y", "1":
+			info.NestedVirt = true
+		}
+	}
+
+	if data, err := os.ReadFile(filepath.Join(root, "sys/module/kvm_intel/parameters/tdx")); err == nil {
+		switch strings.TrimSpace(string(data)) {
+		case "Y", "y", "1":
+			info.TDX = true
+		}
+	}
+
+	groups, _ := os.ReadDir(filepath.Join(root, "sys/kernel/iommu_groups"))
+	for _, group := range groups {
+		devices, err := os.ReadDir(filepath.Join(root, "sys/kernel/iommu_groups", group.Name(), "devices"))
+		if err != nil {
+			continue
+		}
+		info.IOMMU.Groups++
+		info.IOMMU.Devices += len(devices)
+	}
+
+	info.HugePages = hugePagePools(filepath.Join(root, "sys/kernel/mm/hugepages"))
+
+	ifaces, _ := os.ReadDir(filepath.Join(root, "sys/class/net"))
+	for _, iface := range ifaces {
+		if _, err := os.Stat(filepath.Join(root, "sys/class/net", iface.Name(), "bridge")); err == nil {
+			info.Bridges = append(info.Bridges, iface.Name())
+		}
+	}
+}
+
+// hugePagePools reads the pools in dir, named "hugepages-<size>kB".
+func hugePagePools(dir string) []HugePagePool {
+	entries, _ := os.ReadDir(dir)
+
+	var pools []HugePagePool
+	for _, entry := range entries {
+		size, ok := strings.CutPrefix(entry.Name(), "hugepages-")
+		if !ok {
+			continue
+		}
+		kb, err := strconv.ParseInt(strings.TrimSuffix(size, "kB"), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		pool := HugePagePool{PageSize: kb << 10}
+		pool.Total = readSysInt(filepath.Join(dir, entry.Name(), "nr_hugepages"))
+		pool.Free = readSysInt(filepath.Join(dir, entry.Name(), "free_hugepages"))
+		pools = append(pools, pool)
+	}
+
+	sort.Slice(pools, func(a, b int) bool { return pools[a].PageSize < pools[b].PageSize })
+	return pools
+}
+
+// readSysInt reads an integer sysfs attribute, returning 0 on error.
+func readSysInt(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return n
+}
@@ -0,0 +1,64 @@
+//go:build linux
+
+package qemuctl
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestProbeHost(t *testing.T) {
+	root := t.TempDir()
+	write := func(path, content string) {
+		t.Helper()
+		full := filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("dev/kvm", "")
+	write("sys/module/kvm_intel/parameters/nested", "Y\n")
+	write("sys/module/kvm_intel/parameters/tdx", "Y\n")
+	write("sys/kernel/iommu_groups/0/devices/0000:00:00.0", "")
+	write("sys/kernel/iommu_groups/1/devices/0000:01:00.0", "")
+	write("sys/kernel/iommu_groups/1/devices/0000:01:00.1", "")
+	write("sys/kernel/mm/hugepages/hugepages-1048576kB/nr_hugepages", "4\n")
+	write("sys/kernel/mm/hugepages/hugepages-1048576kB/free_hugepages", "1\n")
+	write("sys/kernel/mm/hugepages/hugepages-2048kB/nr_hugepages", "512\n")
+	write("sys/kernel/mm/hugepages/hugepages-2048kB/free_hugepages", "512\n")
+	write("sys/class/net/br0/bridge/bridge_id", "")
+	write("sys/class/net/eth0/address", "")
+
+	info := &HostCapabilities{}
+	probeHost(info, root)
+
+	if !info.KVM || !info.NestedVirt || !info.TDX {
+		t.Errorf("KVM = %v, NestedVirt = %v, TDX = %v, want all true", info.KVM, info.NestedVirt, info.TDX)
+	}
+	if info.IOMMU != (IOMMUInfo{Groups: 2, Devices: 3}) {
+		t.Errorf("IOMMU = %+v", info.IOMMU)
+	}
+	wantPools := []HugePagePool{
+		{PageSize: 2 << 20, Total: 512, Free: 512},
+		{PageSize: 1 << 30, Total: 4, Free: 1},
+	}
+	if !reflect.DeepEqual(info.HugePages, wantPools) {
+		t.Errorf("HugePages = %+v, want %+v", info.HugePages, wantPools)
+	}
+	if !reflect.DeepEqual(info.Bridges, []string{"br0"}) {
+		t.Errorf("Bridges = %v, want [br0]", info.Bridges)
+	}
+
+	// Nothing is reported on a host without virtualization support
+	empty := &HostCapabilities{}
+	probeHost(empty, t.TempDir())
+	if !reflect.DeepEqual(empty, &HostCapabilities{}) {
+		t.Errorf("empty host reported %+v", empty)
+	}
+}
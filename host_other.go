@@ -0,0 +1,7 @@
+//go:build !linux
+
+package qemuctl
+
+// probeHost is unsupported on this platform; only QEMU binaries are
+// reported.
+func probeHost(info *HostCapabilities, root string) {}
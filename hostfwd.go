@@ -0,0 +1,135 @@
+package qemuctl
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PortForward is a host forward of a user-mode network.
+type PortForward struct {
+	// Netdev is the ID of the user-mode netdev.
+	Netdev string `json:"netdev"`
+
+	// Rule is the hostfwd rule, such as "tcp:127.0.0.1:2222-:22".
+	Rule string `json:"rule"`
+}
+
+// AddPortForward adds a hostfwd rule,
+// "[tcp|udp]:[hostaddr]:hostport-[guestaddr]:guestport", to the user-mode
+// netdev with the given ID while the VM runs. For VMs managed by a
+// Supervisor, the host port is held with the reservation, and a
+// ConflictError is returned if another VM holds it.
+func (i *Instance) AddPortForward(netdev, rule string) error {
+	if err := checkMonitorArgs(netdev, rule); err != nil {
+		return err
+	}
+	claim, err := parseHostfwd(rule)
+	if err != nil {
+		return err
+	}
+
+	if i.supervisor != nil {
+		if err := i.supervisor.claim(i, claim); err != nil {
+			return err
+		}
+	}
+	if err := i.hostfwd("hostfwd_add", netdev, rule); err != nil {
+		if i.supervisor != nil {
+			i.supervisor.unclaim(i, claim)
+		}
+		return err
+	}
+
+	i.forwardsMu.Lock()
+	defer i.forwardsMu.Unlock()
+	i.seedForwardsLocked()
+	i.forwards = append(i.forwards, PortForward{Netdev: netdev, Rule: rule})
+	return nil
+}
+
+// RemovePortForward removes the forward of a host port from the user-mode
+// netdev with the given ID. rule is either the whole hostfwd rule or its
+// host side, "[tcp|udp]:[hostaddr]:hostport".
+func (i *Instance) RemovePortForward(netdev, rule string) error {
+	if err := checkMonitorArgs(netdev, rule); err != nil {
+		return err
+	}
+	host, _, _ := strings.Cut(rule, "-")
+	claim, err := parseHostfwdHost(host)
+	if err != nil {
+		return err
+	}
+
+	if err := i.hostfwd("hostfwd_remove", netdev, host); err != nil {
+		return err
+	}
+	if i.supervisor != nil {
+		i.supervisor.unclaim(i, claim)
+	}
+
+	i.forwardsMu.Lock()
+	defer i.forwardsMu.Unlock()
+	i.seedForwardsLocked()
+	for n, fwd := range i.forwards {
+		if c, err := parseHostfwd(fwd.Rule); err == nil && fwd.Netdev == netdev && c == claim {
+			i.forwards = append(i.forwards[:n:n], i.forwards[n+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// PortForwards returns the active host forwards: those of the VM
+// configuration, updated by AddPortForward and RemovePortForward.
+func (i *Instance) PortForwards() []PortForward {
+	i.forwardsMu.Lock()
+	defer i.forwardsMu.Unlock()
+	i.seedForwardsLocked()
+	return append([]PortForward(nil), i.forwards...)
+}
+
+// seedForwardsLocked initializes the forwards from the VM configuration.
+// i.forwardsMu must be held.
+func (i *Instance) seedForwardsLocked() {
+	if i.forwardsSeeded {
+		return
+	}
+	i.forwardsSeeded = true
+	if i.vmConfig == nil {
+		return
+	}
+	for _, n := range i.vmConfig.Networks {
+		if user, ok := n.Backend.(*UserNetBackend); ok {
+			for _, rule := range user.Hostfwd {
+				i.forwards = append(i.forwards, PortForward{Netdev: n.netdevID(), Rule: rule})
+			}
+		}
+	}
+}
+
+// hostfwd runs hostfwd_add or hostfwd_remove, which have no QMP
+// equivalent and report errors as monitor output. hostfwd_add prints
+// nothing on success, hostfwd_remove "host forwarding rule for ...
+// removed", or "... not found" on failure.
+func (i *Instance) hostfwd(command, netdev, rule string) error {
+	out, err := i.HumanMonitorCommand(command + " " + netdev + " " + rule)
+	if err != nil {
+		return err
+	}
+	out = strings.TrimSpace(out)
+	if out == "" || (command == "hostfwd_remove" && strings.HasSuffix(out, " removed")) {
+		return nil
+	}
+	return fmt.Errorf("%s: %s", command, out)
+}
+
+// checkMonitorArgs rejects empty arguments and arguments that would split
+// a human monitor command line.
+func checkMonitorArgs(args ...string) error {
+	for _, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\r\n") {
+			return fmt.Errorf("invalid monitor command argument %q", arg)
+		}
+	}
+	return nil
+}
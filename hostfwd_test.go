@@ -0,0 +1,129 @@
+package qemuctl
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// handleHostfwd makes the fake monitor accept hostfwd commands with
+// QEMU's output, failing hostfwd_add for port 80 and hostfwd_remove for
+// port 9999.
+func handleHostfwd(fake *fakeQMP) {
+	fake.handle("human-monitor-command", func(args map[string]any) (any, *qmpError) {
+		cmd, _ := args["command-line"].(string)
+		fields := strings.Fields(cmd)
+		switch {
+		case fields[0] == "hostfwd_add" && strings.Contains(cmd, ":80-"):
+			return "Could not set up host forwarding rule 'tcp::80-:80'\r\n", nil
+		case fields[0] == "hostfwd_remove" && strings.HasSuffix(cmd, ":9999"):
+			return "host forwarding rule for " + fields[2] + " not found\r\n", nil
+		case fields[0] == "hostfwd_remove":
+			return "host forwarding rule for " + fields[2] + " removed\r\n", nil
+		}
+		return "", nil
+	})
+}
+
+func TestPortForwards(t *testing.T) {
+	fake := newFakeQMP(t)
+	handleHostfwd(fake)
+	inst := fake.attach()
+	inst.vmConfig = DefaultVMConfig()
+	inst.vmConfig.Networks = []*NetworkConfig{{ID: "net0", Backend: &UserNetBackend{Hostfwd: []string{"tcp::2222-:22"}}}}
+
+	if err := inst.AddPortForward("net0", "tcp:127.0.0.1:8080-:8080"); err != nil {
+		t.Fatalf("AddPortForward failed: %v", err)
+	}
+	if err := inst.AddPortForward("net0", "tcp::80-:80"); err == nil || !strings.Contains(err.Error(), "Could not set up") {
+		t.Errorf("AddPortForward = %v, want the monitor error", err)
+	}
+	if err := inst.RemovePortForward("net0", "tcp::2222"); err != nil {
+		t.Fatalf("RemovePortForward failed: %v", err)
+	}
+	if err := inst.RemovePortForward("net0", "tcp::9999"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("RemovePortForward = %v, want the monitor error", err)
+	}
+
+	want := []PortForward{{Netdev: "net0", Rule: "tcp:127.0.0.1:8080-:8080"}}
+	if got := inst.PortForwards(); !reflect.DeepEqual(got, want) {
+		t.Errorf("PortForwards = %+v, want %+v", got, want)
+	}
+
+	var cmds []string
+	for _, args := range fake.commands("human-monitor-command") {
+		cmds = append(cmds, args["command-line"].(string))
+	}
+	wantCmds := []string{
+		"hostfwd_add net0 tcp:127.0.0.1:8080-:8080",
+		"hostfwd_add net0 tcp::80-:80",
+		"hostfwd_remove net0 tcp::2222",
+		"hostfwd_remove net0 tcp::9999",
+	}
+	if !reflect.DeepEqual(cmds, wantCmds) {
+		t.Errorf("monitor commands = %q, want %q", cmds, wantCmds)
+	}
+
+	for _, args := range [][2]string{{"", "tcp::1-:1"}, {"net0", "tcp::1-:1 quit"}, {"net0", "tcp::x-:1"}} {
+		if err := inst.AddPortForward(args[0], args[1]); err == nil {
+			t.Errorf("AddPortForward(%q, %q) succeeded", args[0], args[1])
+		}
+	}
+}
+
+func TestPortForwardsDefaultNetdev(t *testing.T) {
+	fake := newFakeQMP(t)
+	handleHostfwd(fake)
+	inst := fake.attach()
+	inst.vmConfig = DefaultVMConfig()
+	inst.vmConfig.Networks = []*NetworkConfig{{Backend: &UserNetBackend{Hostfwd: []string{"tcp::2222-:22"}}}}
+
+	want := []PortForward{{Netdev: "net0", Rule: "tcp::2222-:22"}}
+	if got := inst.PortForwards(); !reflect.DeepEqual(got, want) {
+		t.Errorf("PortForwards = %+v, want %+v", got, want)
+	}
+	if err := inst.RemovePortForward("net0", "tcp::2222"); err != nil {
+		t.Fatalf("RemovePortForward failed: %v", err)
+	}
+	if got := inst.PortForwards(); len(got) != 0 {
+		t.Errorf("PortForwards = %+v after removal", got)
+	}
+}
+
+func TestPortForwardClaims(t *testing.T) {
+	fake := newFakeQMP(t)
+	handleHostfwd(fake)
+	s := NewSupervisor(Resources{}, OvercommitRatios{})
+	inst := fake.attach()
+	res, err := s.reserve("vm", Resources{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.track(inst, res)
+
+	if _, err := s.reserve("other", Resources{}, []netClaim{{proto: "tcp", port: 2222}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var conflict *ConflictError
+	if err := inst.AddPortForward("net0", "tcp:127.0.0.1:2222-:22"); !errors.As(err, &conflict) || conflict.Holder != "other" {
+		t.Errorf("AddPortForward = %v, want a conflict with other", err)
+	}
+
+	if err := inst.AddPortForward("net0", "tcp::2223-:22"); err != nil {
+		t.Fatalf("AddPortForward failed: %v", err)
+	}
+	if _, err := s.reserve("third", Resources{}, []netClaim{{proto: "tcp", port: 2223}}); !errors.As(err, &conflict) || conflict.Holder != inst.Name() {
+		t.Errorf("reserve = %v, want a conflict", err)
+	}
+
+	// Failed and removed forwards release their port
+	inst.AddPortForward("net0", "tcp::80-:80")
+	if err := inst.RemovePortForward("net0", "tcp::2223-:22"); err != nil {
+		t.Fatalf("RemovePortForward failed: %v", err)
+	}
+	if _, err := s.reserve("third", Resources{}, []netClaim{{proto: "tcp", port: 2223}, {proto: "tcp", port: 80}}); err != nil {
+		t.Errorf("reserve = %v after removal", err)
+	}
+}
@@ -0,0 +1,72 @@
+package qemuctl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// HugePagesUnavailableError is returned by StartVM when the host has too
+// few free huge pages to back the guest memory.
+type HugePagesUnavailableError struct {
+	// PageSize is the huge page size in bytes.
+	PageSize int64
+
+	// Needed is the number of pages the guest memory takes.
+	Needed int
+
+	// Free is the number of free pages of that size.
+	Free int
+}
+
+func (e *HugePagesUnavailableError) Error() string {
+	return fmt.Sprintf("%d free huge pages of %d kB needed, %d available", e.Needed, e.PageSize>>10, e.Free)
+}
+
+// validate checks the huge page settings of a memory backend.
+func (cfg *MemoryBackendConfig) validate() error {
+	switch {
+	case cfg.HugePageSize != 0 && !cfg.HugePages:
+		return fmt.Errorf("memory backend: huge page size set without huge pages")
+	case cfg.HugePageSize < 0 || cfg.HugePageSize&(cfg.HugePageSize-1) != 0:
+		return fmt.Errorf("memory backend: huge page size %d is not a power of two", cfg.HugePageSize)
+	case cfg.HugePages && cfg.Type != "file" && cfg.Type != "memfd":
+		return fmt.Errorf("memory backend: huge pages need a file or memfd backend, not %q", cfg.Type)
+	}
+	return nil
+}
+
+// hugePagesNeeded returns the number of pages of pageSize bytes backing
+// size megabytes.
+func hugePagesNeeded(size int, pageSize int64) int {
+	bytes := int64(size) << 20
+	return int((bytes + pageSize - 1) / pageSize)
+}
+
+// parseByteSize parses a size in bytes with an optional K, M, G or T
+// suffix, as in the pagesize option of hugetlbfs mounts.
+func parseByteSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	shift := 0
+	if s != "" {
+		switch strings.ToUpper(s[len(s)-1:]) {
+		case "K":
+			shift = 10
+		case "M":
+			shift = 20
+		case "G":
+			shift = 30
+		case "T":
+			shift = 40
+		}
+	}
+	if shift != 0 {
+		s = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n << shift, nil
+}
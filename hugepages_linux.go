@@ -0,0 +1,127 @@
+//go:build linux
+
+package qemuctl
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// mountPathUnescaper decodes the octal escapes of /proc/mounts fields.
+var mountPathUnescaper = strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`)
+
+// resolveHugePages checks that the host under root has enough free huge
+// pages for a configuration asking for them, before QEMU fails allocating
+// them or, worse, the guest faults on them later. It returns a copy of the
+// configuration with the page size set, and for file backends without a
+// path, the hugetlbfs mount of that page size.
+func (cfg *VMConfig) resolveHugePages(root string) (*VMConfig, error) {
+	if cfg.Memory == nil || cfg.Memory.Backend == nil || !cfg.Memory.Backend.HugePages {
+		return cfg, nil
+	}
+	backend := *cfg.Memory.Backend
+
+	defaultSize := defaultHugePageSize(root)
+	if backend.HugePageSize == 0 {
+		if defaultSize == 0 {
+			return nil, fmt.Errorf("huge pages are not supported by the kernel")
+		}
+		backend.HugePageSize = defaultSize
+	}
+
+	var pool *HugePagePool
+	pools := hugePagePools(filepath.Join(root, "sys/kernel/mm/hugepages"))
+	for n := range pools {
+		if pools[n].PageSize == backend.HugePageSize {
+			pool = &pools[n]
+		}
+	}
+	if pool == nil {
+		return nil, fmt.Errorf("no huge page pool of %d kB", backend.HugePageSize>>10)
+	}
+
+	size := cfg.Memory.Size
+	if size == 0 {
+		size = defaultMemorySize
+	}
+	if needed := hugePagesNeeded(size, pool.PageSize); needed > pool.Free {
+		return nil, &HugePagesUnavailableError{PageSize: pool.PageSize, Needed: needed, Free: pool.Free}
+	}
+
+	if backend.Type == "file" && backend.Path == "" {
+		path, err := hugetlbfsMount(root, backend.HugePageSize, defaultSize)
+		if err != nil {
+			return nil, err
+		}
+		backend.Path = path
+	}
+
+	c := *cfg
+	memory := *cfg.Memory
+	memory.Backend = &backend
+	c.Memory = &memory
+	return &c, nil
+}
+
+// defaultHugePageSize returns the default huge page size in bytes, from
+// /proc/meminfo under root, or 0 if the kernel has no huge pages.
+func defaultHugePageSize(root string) int64 {
+	f, err := os.Open(filepath.Join(root, "proc/meminfo"))
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(scanner.Text(), "Hugepagesize:")
+		if !ok {
+			continue
+		}
+		// The size is given as "2048 kB"
+		size, err := parseByteSize(strings.TrimSuffix(strings.Join(strings.Fields(value), ""), "B"))
+		if err != nil {
+			return 0
+		}
+		return size
+	}
+	return 0
+}
+
+// hugetlbfsMount returns the mountpoint of a hugetlbfs of pageSize bytes
+// pages, from /proc/mounts under root. Mounts without a pagesize option use
+// the default size.
+func hugetlbfsMount(root string, pageSize, defaultSize int64) (string, error) {
+	f, err := os.Open(filepath.Join(root, "proc/mounts"))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[2] != "hugetlbfs" {
+			continue
+		}
+
+		size := defaultSize
+		for _, opt := range strings.Split(fields[3], ",") {
+			if value, ok := strings.CutPrefix(opt, "pagesize="); ok {
+				if size, err = parseByteSize(value); err != nil {
+					size = 0
+				}
+			}
+		}
+		if size == pageSize {
+			return mountPathUnescaper.Replace(fields[1]), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no hugetlbfs mount with %d kB pages", pageSize>>10)
+}
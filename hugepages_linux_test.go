@@ -0,0 +1,86 @@
+//go:build linux
+
+package qemuctl
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveHugePages(t *testing.T) {
+	root := t.TempDir()
+	write := func(path, content string) {
+		t.Helper()
+		full := filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("proc/meminfo", "MemTotal:       65536000 kB\nHugepagesize:       2048 kB\n")
+	write("proc/mounts", "proc /proc proc rw 0 0\n"+
+		"hugetlbfs /dev/hugepages hugetlbfs rw,relatime,pagesize=2M 0 0\n"+
+		"none /mnt/huge\\0401G hugetlbfs rw,pagesize=1024M 0 0\n")
+	write("sys/kernel/mm/hugepages/hugepages-2048kB/nr_hugepages", "1024\n")
+	write("sys/kernel/mm/hugepages/hugepages-2048kB/free_hugepages", "1024\n")
+	write("sys/kernel/mm/hugepages/hugepages-1048576kB/nr_hugepages", "4\n")
+	write("sys/kernel/mm/hugepages/hugepages-1048576kB/free_hugepages", "2\n")
+
+	resolve := func(size int, backend MemoryBackendConfig) (*MemoryBackendConfig, error) {
+		t.Helper()
+		cfg := &VMConfig{Memory: &MemoryConfig{Size: size, Backend: &backend}}
+		resolved, err := cfg.resolveHugePages(root)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Memory.Backend.Path != backend.Path || cfg.Memory.Backend.HugePageSize != backend.HugePageSize {
+			t.Error("resolveHugePages modified the configuration")
+		}
+		return resolved.Memory.Backend, nil
+	}
+
+	got, err := resolve(2048, MemoryBackendConfig{Type: "file", HugePages: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Path != "/dev/hugepages" || got.HugePageSize != 2<<20 {
+		t.Errorf("default size resolved to %s with %d byte pages", got.Path, got.HugePageSize)
+	}
+
+	got, err = resolve(2048, MemoryBackendConfig{Type: "file", HugePages: true, HugePageSize: 1 << 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Path != "/mnt/huge 1G" {
+		t.Errorf("1G pages resolved to %q", got.Path)
+	}
+
+	got, err = resolve(1024, MemoryBackendConfig{Type: "memfd", HugePages: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Path != "" || got.HugePageSize != 2<<20 {
+		t.Errorf("memfd resolved to %+v", got)
+	}
+
+	var unavailable *HugePagesUnavailableError
+	_, err = resolve(4096, MemoryBackendConfig{Type: "memfd", HugePages: true, HugePageSize: 1 << 30})
+	if !errors.As(err, &unavailable) || unavailable.Needed != 4 || unavailable.Free != 2 {
+		t.Errorf("4 GiB of 1G pages: err = %v", err)
+	}
+
+	if _, err := resolve(1024, MemoryBackendConfig{Type: "memfd", HugePages: true, HugePageSize: 16 << 30}); err == nil {
+		t.Error("resolveHugePages accepted a page size without a pool")
+	}
+
+	// Without huge pages, the configuration is used as is
+	cfg := &VMConfig{Memory: &MemoryConfig{Size: 1024}}
+	if resolved, err := cfg.resolveHugePages(t.TempDir()); err != nil || resolved != cfg {
+		t.Errorf("resolveHugePages without huge pages = %p, %v", resolved, err)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package qemuctl
+
+import "errors"
+
+// resolveHugePages rejects huge pages, which are only supported on Linux.
+func (cfg *VMConfig) resolveHugePages(root string) (*VMConfig, error) {
+	if cfg.Memory == nil || cfg.Memory.Backend == nil || !cfg.Memory.Backend.HugePages {
+		return cfg, nil
+	}
+	return nil, errors.New("huge pages are only supported on Linux")
+}
@@ -0,0 +1,57 @@
+package qemuctl
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestVMBuilderHugePages(t *testing.T) {
+	cfg := &VMConfig{Memory: &MemoryConfig{
+		Size:    4096,
+		Backend: &MemoryBackendConfig{Type: "memfd", Share: true, HugePages: true, HugePageSize: 1 << 30},
+	}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	args := NewVMBuilder(cfg).Build("test", "")
+	joined := strings.Join(args, " ")
+	want := "-object memory-backend-memfd,id=mem0,size=4096M,share=on,hugetlb=on,hugetlbsize=1073741824"
+	if !strings.Contains(joined, want) {
+		t.Errorf("args missing %q:\n%s", want, joined)
+	}
+
+	parsed, err := ParseArgs(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed.Memory.Backend, cfg.Memory.Backend) {
+		t.Errorf("parsed memory backend = %+v, want %+v", parsed.Memory.Backend, cfg.Memory.Backend)
+	}
+}
+
+func TestValidateHugePages(t *testing.T) {
+	for _, backend := range []*MemoryBackendConfig{
+		{Type: "ram", HugePages: true},
+		{Type: "memfd", HugePageSize: 2 << 20},
+		{Type: "memfd", HugePages: true, HugePageSize: 3 << 20},
+		{Type: "file", HugePages: true, HugePageSize: -1},
+	} {
+		cfg := &VMConfig{Memory: &MemoryConfig{Size: 1024, Backend: backend}}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Validate accepted %+v", backend)
+		}
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	for s, want := range map[string]int64{"2M": 2 << 20, "1G": 1 << 30, "64k": 64 << 10, "4096": 4096} {
+		if got, err := parseByteSize(s); err != nil || got != want {
+			t.Errorf("parseByteSize(%q) = %d, %v, want %d", s, got, err, want)
+		}
+	}
+	if _, err := parseByteSize("2X"); err == nil {
+		t.Error("parseByteSize accepted 2X")
+	}
+}
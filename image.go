@@ -0,0 +1,433 @@
+package qemuctl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CreateImageOptions configures CreateImage.
+type CreateImageOptions struct {
+	// Format is the image format (default "qcow2").
+	Format string
+
+	// Size is the virtual size in bytes. It may be zero with a backing file.
+	Size int64
+
+	// BackingFile creates an overlay on top of this image.
+	BackingFile string
+
+	// BackingFormat is the format of BackingFile (required by recent qemu-img).
+	BackingFormat string
+
+	// Preallocation is the preallocation mode ("off", "metadata", "falloc", "full").
+	Preallocation string
+
+	// ClusterSize is the qcow2 cluster size in bytes.
+	ClusterSize int64
+
+	// Options are additional format-specific options (-o key=value).
+	Options map[string]string
+
+	// QemuImgPath overrides the qemu-img binary path.
+	QemuImgPath string
+}
+
+// ConvertImageOptions configures ConvertImage.
+type ConvertImageOptions struct {
+	// SourceFormat is the source image format (probed if empty).
+	SourceFormat string
+
+	// Format is the output format (default "qcow2").
+	Format string
+
+	// Compress compresses the output (qcow2 only).
+	Compress bool
+
+	// Coroutines is the number of parallel coroutines (-m).
+	Coroutines int
+
+	// Options are additional format-specific options (-o key=value).
+	Options map[string]string
+
+	// QemuImgPath overrides the qemu-img binary path.
+	QemuImgPath string
+}
+
+// ResizeImageOptions configures ResizeImage.
+type ResizeImageOptions struct {
+	// Format is the image format (probed if empty).
+	Format string
+
+	// Shrink allows reducing the image size.
+	Shrink bool
+
+	// Preallocation is the preallocation mode for the new area.
+	Preallocation string
+
+	// QemuImgPath overrides the qemu-img binary path.
+	QemuImgPath string
+}
+
+// ImageInfoOptions configures ImageInfo.
+type ImageInfoOptions struct {
+	// Format is the image format (probed if empty).
+	Format string
+
+	// QemuImgPath overrides the qemu-img binary path.
+	QemuImgPath string
+}
+
+// CheckImageOptions configures CheckImage.
+type CheckImageOptions struct {
+	// Format is the image format (probed if empty).
+	Format string
+
+	// Repair repairs the image ("leaks" or "all").
+	Repair string
+
+	// QemuImgPath overrides the qemu-img binary path.
+	QemuImgPath string
+}
+
+// DiskImageInfo is the output of qemu-img info.
+type DiskImageInfo struct {
+	Filename              string           `json:"filename"`
+	Format                string           `json:"format"`
+	VirtualSize           int64            `json:"virtual-size"`
+	ActualSize            int64            `json:"actual-size"`
+	ClusterSize           int64            `json:"cluster-size,omitempty"`
+	DirtyFlag             bool             `json:"dirty-flag,omitempty"`
+	Encrypted             bool             `json:"encrypted,omitempty"`
+	BackingFilename       string           `json:"backing-filename,omitempty"`
+	FullBackingFilename   string           `json:"full-backing-filename,omitempty"`
+	BackingFilenameFormat string           `json:"backing-filename-format,omitempty"`
+	Snapshots             []*ImageSnapshot `json:"snapshots,omitempty"`
+	FormatSpecific        json.RawMessage  `json:"format-specific,omitempty"`
+}
+
+// ImageSnapshot is an internal snapshot stored in an image.
+type ImageSnapshot struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	VMStateSize int64  `json:"vm-state-size"`
+	DateSec     int64  `json:"date-sec"`
+	DateNsec    int64  `json:"date-nsec"`
+	VMClockSec  int64  `json:"vm-clock-sec"`
+	VMClockNsec int64  `json:"vm-clock-nsec"`
+}
+
+// Date returns the time the snapshot was taken.
+func (s *ImageSnapshot) Date() time.Time {
+	return time.Unix(s.DateSec, s.DateNsec)
+}
+
+// VMClock returns the guest clock at the time of the snapshot.
+func (s *ImageSnapshot) VMClock() time.Duration {
+	return time.Duration(s.VMClockSec)*time.Second + time.Duration(s.VMClockNsec)
+}
+
+// ImageSnapshotOptions configures the offline snapshot functions.
+type ImageSnapshotOptions struct {
+	// Format is the image format (probed if empty).
+	Format string
+
+	// QemuImgPath overrides the qemu-img binary path.
+	QemuImgPath string
+}
+
+// ImageCheckResult is the output of qemu-img check.
+type ImageCheckResult struct {
+	Filename           string `json:"filename"`
+	Format             string `json:"format"`
+	CheckErrors        int    `json:"check-errors"`
+	Corruptions        int    `json:"corruptions,omitempty"`
+	Leaks              int    `json:"leaks,omitempty"`
+	CorruptionsFixed   int    `json:"corruptions-fixed,omitempty"`
+	LeaksFixed         int    `json:"leaks-fixed,omitempty"`
+	ImageEndOffset     int64  `json:"image-end-offset,omitempty"`
+	TotalClusters      int64  `json:"total-clusters,omitempty"`
+	AllocatedClusters  int64  `json:"allocated-clusters,omitempty"`
+	FragmentedClusters int64  `json:"fragmented-clusters,omitempty"`
+	CompressedClusters int64  `json:"compressed-clusters,omitempty"`
+}
+
+// Clean returns true if no errors, corruptions or leaks were found.
+func (r *ImageCheckResult) Clean() bool {
+	return r.CheckErrors == 0 && r.Corruptions == 0 && r.Leaks == 0
+}
+
+// QemuImgError is returned when qemu-img fails.
+type QemuImgError struct {
+	Command  string
+	ExitCode int
+	Stderr   string
+}
+
+func (e *QemuImgError) Error() string {
+	return fmt.Sprintf("qemu-img %s failed (exit %d): %s", e.Command, e.ExitCode, e.Stderr)
+}
+
+// CreateImage creates a new disk image at path.
+func CreateImage(ctx context.Context, path string, opts *CreateImageOptions) error {
+	if opts == nil {
+		opts = &CreateImageOptions{}
+	}
+	_, err := runQemuImg(ctx, opts.QemuImgPath, createImageArgs(path, opts))
+	return err
+}
+
+// ConvertImage converts src into a new image at dst.
+func ConvertImage(ctx context.Context, src, dst string, opts *ConvertImageOptions) error {
+	if opts == nil {
+		opts = &ConvertImageOptions{}
+	}
+	_, err := runQemuImg(ctx, opts.QemuImgPath, convertImageArgs(src, dst, opts))
+	return err
+}
+
+// ResizeImage sets the virtual size of the image at path to size bytes.
+// Images in use by a running VM must be resized through QMP instead.
+func ResizeImage(ctx context.Context, path string, size int64, opts *ResizeImageOptions) error {
+	if opts == nil {
+		opts = &ResizeImageOptions{}
+	}
+	_, err := runQemuImg(ctx, opts.QemuImgPath, resizeImageArgs(path, size, opts))
+	return err
+}
+
+// ImageInfo returns information about the image at path.
+func ImageInfo(ctx context.Context, path string, opts *ImageInfoOptions) (*DiskImageInfo, error) {
+	if opts == nil {
+		opts = &ImageInfoOptions{}
+	}
+
+	args := []string{"info", "--output=json"}
+	if opts.Format != "" {
+		args = append(args, "-f", opts.Format)
+	}
+	args = append(args, path)
+
+	out, err := runQemuImg(ctx, opts.QemuImgPath, args)
+	if err != nil {
+		return nil, err
+	}
+
+	var info DiskImageInfo
+	if err := json.Unmarshal(out, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse qemu-img info output: %w", err)
+	}
+	return &info, nil
+}
+
+// CheckImage checks the image at path for consistency. Corruptions and
+// leaks are reported in the result rather than as an error.
+func CheckImage(ctx context.Context, path string, opts *CheckImageOptions) (*ImageCheckResult, error) {
+	if opts == nil {
+		opts = &CheckImageOptions{}
+	}
+
+	args := []string{"check", "--output=json"}
+	if opts.Format != "" {
+		args = append(args, "-f", opts.Format)
+	}
+	if opts.Repair != "" {
+		args = append(args, "-r", opts.Repair)
+	}
+	args = append(args, path)
+
+	out, err := runQemuImg(ctx, opts.QemuImgPath, args)
+	if err != nil {
+		// Exit codes 2 (corruptions) and 3 (leaks) still produce a report
+		var imgErr *QemuImgError
+		if !errors.As(err, &imgErr) || (imgErr.ExitCode != 2 && imgErr.ExitCode != 3) {
+			return nil, err
+		}
+	}
+
+	var result ImageCheckResult
+	if err := json.Unmarshal(out, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse qemu-img check output: %w", err)
+	}
+	return &result, nil
+}
+
+// ListImageSnapshots returns the internal snapshots of a qcow2 image.
+// The list is read from qemu-img info since qemu-img snapshot -l has no
+// JSON output.
+func ListImageSnapshots(ctx context.Context, path string, opts *ImageSnapshotOptions) ([]*ImageSnapshot, error) {
+	if opts == nil {
+		opts = &ImageSnapshotOptions{}
+	}
+	info, err := ImageInfo(ctx, path, &ImageInfoOptions{Format: opts.Format, QemuImgPath: opts.QemuImgPath})
+	if err != nil {
+		return nil, err
+	}
+	return info.Snapshots, nil
+}
+
+// CreateImageSnapshot creates an internal snapshot in a qcow2 image.
+// The image must not be in use by a running VM.
+func CreateImageSnapshot(ctx context.Context, path, name string, opts *ImageSnapshotOptions) error {
+	return imageSnapshot(ctx, path, "-c", name, opts)
+}
+
+// ApplyImageSnapshot reverts a qcow2 image to an internal snapshot.
+// The image must not be in use by a running VM.
+func ApplyImageSnapshot(ctx context.Context, path, name string, opts *ImageSnapshotOptions) error {
+	return imageSnapshot(ctx, path, "-a", name, opts)
+}
+
+// DeleteImageSnapshot deletes an internal snapshot from a qcow2 image.
+// The image must not be in use by a running VM.
+func DeleteImageSnapshot(ctx context.Context, path, name string, opts *ImageSnapshotOptions) error {
+	return imageSnapshot(ctx, path, "-d", name, opts)
+}
+
+// imageSnapshot runs qemu-img snapshot with the given action flag.
+func imageSnapshot(ctx context.Context, path, action, name string, opts *ImageSnapshotOptions) error {
+	if opts == nil {
+		opts = &ImageSnapshotOptions{}
+	}
+	if name == "" {
+		return fmt.Errorf("snapshot name is required")
+	}
+	_, err := runQemuImg(ctx, opts.QemuImgPath, imageSnapshotArgs(path, action, name, opts))
+	return err
+}
+
+// imageSnapshotArgs builds the qemu-img snapshot arguments.
+func imageSnapshotArgs(path, action, name string, opts *ImageSnapshotOptions) []string {
+	args := []string{"snapshot"}
+	if opts.Format != "" {
+		args = append(args, "-f", opts.Format)
+	}
+	return append(args, action, name, path)
+}
+
+// createImageArgs builds the qemu-img create arguments.
+func createImageArgs(path string, opts *CreateImageOptions) []string {
+	format := opts.Format
+	if format == "" {
+		format = "qcow2"
+	}
+
+	args := []string{"create", "-f", format}
+	if opts.BackingFile != "" {
+		args = append(args, "-b", opts.BackingFile)
+		if opts.BackingFormat != "" {
+			args = append(args, "-F", opts.BackingFormat)
+		}
+	}
+
+	o := make(map[string]string, len(opts.Options)+2)
+	for k, v := range opts.Options {
+		o[k] = v
+	}
+	if opts.Preallocation != "" {
+		o["preallocation"] = opts.Preallocation
+	}
+	if opts.ClusterSize > 0 {
+		o["cluster_size"] = strconv.FormatInt(opts.ClusterSize, 10)
+	}
+	if s := imageOptionString(o); s != "" {
+		args = append(args, "-o", s)
+	}
+
+	args = append(args, path)
+	if opts.Size > 0 {
+		args = append(args, strconv.FormatInt(opts.Size, 10))
+	}
+	return args
+}
+
+// convertImageArgs builds the qemu-img convert arguments.
+func convertImageArgs(src, dst string, opts *ConvertImageOptions) []string {
+	format := opts.Format
+	if format == "" {
+		format = "qcow2"
+	}
+
+	args := []string{"convert"}
+	if opts.SourceFormat != "" {
+		args = append(args, "-f", opts.SourceFormat)
+	}
+	args = append(args, "-O", format)
+	if opts.Compress {
+		args = append(args, "-c")
+	}
+	if opts.Coroutines > 0 {
+		args = append(args, "-m", strconv.Itoa(opts.Coroutines))
+	}
+	if s := imageOptionString(opts.Options); s != "" {
+		args = append(args, "-o", s)
+	}
+	return append(args, src, dst)
+}
+
+// resizeImageArgs builds the qemu-img resize arguments.
+func resizeImageArgs(path string, size int64, opts *ResizeImageOptions) []string {
+	args := []string{"resize"}
+	if opts.Format != "" {
+		args = append(args, "-f", opts.Format)
+	}
+	if opts.Shrink {
+		args = append(args, "--shrink")
+	}
+	if opts.Preallocation != "" {
+		args = append(args, "--preallocation="+opts.Preallocation)
+	}
+	return append(args, path, strconv.FormatInt(size, 10))
+}
+
+// imageOptionString encodes -o options in a stable order.
+func imageOptionString(opts map[string]string) string {
+	if len(opts) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(opts))
+	for k := range opts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, k+"="+strings.ReplaceAll(opts[k], ",", ",,"))
+	}
+	return strings.Join(parts, ",")
+}
+
+// runQemuImg runs qemu-img and returns its standard output.
+func runQemuImg(ctx context.Context, customPath string, args []string) ([]byte, error) {
+	path, err := LocateQemuImg(customPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return stdout.Bytes(), &QemuImgError{
+				Command:  args[0],
+				ExitCode: exitErr.ExitCode(),
+				Stderr:   strings.TrimSpace(stderr.String()),
+			}
+		}
+		return nil, fmt.Errorf("failed to run qemu-img: %w", err)
+	}
+
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,129 @@
+package qemuctl
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCreateImageArgs(t *testing.T) {
+	args := createImageArgs("/tmp/disk.qcow2", &CreateImageOptions{
+		Size:          10 << 30,
+		BackingFile:   "/tmp/base.qcow2",
+		BackingFormat: "qcow2",
+		Preallocation: "metadata",
+		Options:       map[string]string{"lazy_refcounts": "on"},
+	})
+	argsStr := strings.Join(args, " ")
+
+	expected := "create -f qcow2 -b /tmp/base.qcow2 -F qcow2 -o lazy_refcounts=on,preallocation=metadata /tmp/disk.qcow2 10737418240"
+	if argsStr != expected {
+		t.Errorf("expected %q, got %q", expected, argsStr)
+	}
+}
+
+func TestConvertImageArgs(t *testing.T) {
+	args := convertImageArgs("in.raw", "out.qcow2", &ConvertImageOptions{
+		SourceFormat: "raw",
+		Compress:     true,
+		Coroutines:   8,
+	})
+	argsStr := strings.Join(args, " ")
+
+	expected := "convert -f raw -O qcow2 -c -m 8 in.raw out.qcow2"
+	if argsStr != expected {
+		t.Errorf("expected %q, got %q", expected, argsStr)
+	}
+}
+
+func TestResizeImageArgs(t *testing.T) {
+	args := resizeImageArgs("disk.qcow2", 1<<30, &ResizeImageOptions{Format: "qcow2", Shrink: true})
+	argsStr := strings.Join(args, " ")
+
+	expected := "resize -f qcow2 --shrink disk.qcow2 1073741824"
+	if argsStr != expected {
+		t.Errorf("expected %q, got %q", expected, argsStr)
+	}
+}
+
+// fakeQemuImg writes a shell script standing in for qemu-img.
+func fakeQemuImg(t *testing.T, output string, exitCode int) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "qemu-img")
+	script := "#!/bin/sh\ncat <<'EOF'\n" + output + "\nEOF\necho 'some warning' >&2\nexit " + strconv.Itoa(exitCode) + "\n"
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestImageInfo(t *testing.T) {
+	path := fakeQemuImg(t, `{
+    "virtual-size": 10737418240,
+    "filename": "disk.qcow2",
+    "cluster-size": 65536,
+    "format": "qcow2",
+    "actual-size": 200704,
+    "backing-filename": "base.qcow2",
+    "snapshots": [{"id": "1", "name": "clean", "vm-state-size": 0, "date-sec": 1700000000}],
+    "dirty-flag": false
+}`, 0)
+
+	info, err := ImageInfo(context.Background(), "disk.qcow2", &ImageInfoOptions{QemuImgPath: path})
+	if err != nil {
+		t.Fatalf("ImageInfo error: %v", err)
+	}
+	if info.VirtualSize != 10<<30 || info.Format != "qcow2" || info.BackingFilename != "base.qcow2" {
+		t.Errorf("unexpected info: %+v", info)
+	}
+	if len(info.Snapshots) != 1 || info.Snapshots[0].Name != "clean" {
+		t.Errorf("unexpected snapshots: %+v", info.Snapshots)
+	}
+
+	snapshots, err := ListImageSnapshots(context.Background(), "disk.qcow2", &ImageSnapshotOptions{QemuImgPath: path})
+	if err != nil {
+		t.Fatalf("ListImageSnapshots error: %v", err)
+	}
+	if len(snapshots) != 1 || snapshots[0].Date().Unix() != 1700000000 {
+		t.Errorf("unexpected snapshots: %+v", snapshots)
+	}
+}
+
+func TestImageSnapshotArgs(t *testing.T) {
+	args := imageSnapshotArgs("disk.qcow2", "-c", "before-upgrade", &ImageSnapshotOptions{Format: "qcow2"})
+	argsStr := strings.Join(args, " ")
+
+	expected := "snapshot -f qcow2 -c before-upgrade disk.qcow2"
+	if argsStr != expected {
+		t.Errorf("expected %q, got %q", expected, argsStr)
+	}
+
+	if err := CreateImageSnapshot(context.Background(), "disk.qcow2", "", nil); err == nil {
+		t.Error("expected error for empty snapshot name")
+	}
+}
+
+func TestCheckImage(t *testing.T) {
+	// Exit code 3 means leaks were found; the report is still returned
+	path := fakeQemuImg(t, `{"filename": "disk.qcow2", "format": "qcow2", "check-errors": 0, "leaks": 4}`, 3)
+
+	result, err := CheckImage(context.Background(), "disk.qcow2", &CheckImageOptions{QemuImgPath: path})
+	if err != nil {
+		t.Fatalf("CheckImage error: %v", err)
+	}
+	if result.Leaks != 4 || result.Clean() {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	// Other failures are errors
+	path = fakeQemuImg(t, "", 1)
+	_, err = CheckImage(context.Background(), "disk.qcow2", &CheckImageOptions{QemuImgPath: path})
+	var imgErr *QemuImgError
+	if !errors.As(err, &imgErr) || imgErr.ExitCode != 1 || imgErr.Stderr != "some warning" {
+		t.Errorf("expected QemuImgError, got: %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+package qemuctl
+
+import (
+	"fmt"
+	"time"
+)
+
+// Mouse buttons, for SendMouseButton.
+const (
+	MouseLeft      = "left"
+	MouseMiddle    = "middle"
+	MouseRight     = "right"
+	MouseWheelUp   = "wheel-up"
+	MouseWheelDown = "wheel-down"
+	MouseSide      = "side"
+	MouseExtra     = "extra"
+)
+
+// PointerAbsoluteMax is the coordinate of the right and bottom edges of
+// the screen for SendPointerAbsolute; the point at pixel x of a screen w
+// pixels wide is x*PointerAbsoluteMax/(w-1).
+const PointerAbsoluteMax = 0x7fff
+
+// SendMouseMove moves the mouse by dx, dy, as a relative pointing device
+// such as a PS/2 or USB mouse does. The guest applies its own pointer
+// acceleration, so use SendPointerAbsolute to reach a given point.
+func (i *Instance) SendMouseMove(dx, dy int) error {
+	return i.sendInputEvents(
+		map[string]any{"type": "rel", "data": map[string]any{"axis": "x", "value": dx}},
+		map[string]any{"type": "rel", "data": map[string]any{"axis": "y", "value": dy}},
+	)
+}
+
+// SendPointerAbsolute moves the pointer to x, y, from 0 to
+// PointerAbsoluteMax on each axis. The guest needs an absolute pointing
+// device, such as the usb-tablet added by VMConfig.WithUSBTablet.
+func (i *Instance) SendPointerAbsolute(x, y int) error {
+	return i.sendInputEvents(
+		map[string]any{"type": "abs", "data": map[string]any{"axis": "x", "value": x}},
+		map[string]any{"type": "abs", "data": map[string]any{"axis": "y", "value": y}},
+	)
+}
+
+// SendMouseButton presses (down true) or releases a mouse button. A click
+// is a press followed by a release; the wheel buttons scroll one step per
+// press.
+func (i *Instance) SendMouseButton(button string, down bool) error {
+	return i.sendInputEvents(
+		map[string]any{"type": "btn", "data": map[string]any{"down": down, "button": button}},
+	)
+}
+
+// SendKeysWithHold presses keys, in QEMU qcode format (e.g. "ctrl",
+// "alt", "f2"), in order, holds them for hold and releases them in reverse
+// order. SendKey releases keys after 100ms; holding them longer helps with
+// firmware that polls the keyboard slowly, such as a boot menu key held at
+// power-on.
+func (i *Instance) SendKeysWithHold(hold time.Duration, keys ...string) error {
+	events := make([]map[string]any, len(keys))
+	for n, key := range keys {
+		events[n] = keyEvent(key, true)
+	}
+	if err := i.sendInputEvents(events...); err != nil {
+		return err
+	}
+
+	time.Sleep(hold)
+
+	for n, key := range keys {
+		events[len(keys)-1-n] = keyEvent(key, false)
+	}
+	return i.sendInputEvents(events...)
+}
+
+// keyEvent returns the input event pressing or releasing a key.
+func keyEvent(qcode string, down bool) map[string]any {
+	return map[string]any{"type": "key", "data": map[string]any{
+		"down": down,
+		"key":  map[string]any{"type": "qcode", "data": qcode},
+	}}
+}
+
+// sendInputEvents sends input events to the guest with input-send-event,
+// which QEMU routes to the device that has focus.
+func (i *Instance) sendInputEvents(events ...map[string]any) error {
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return ErrNotConnected
+	}
+
+	_, err := qmp.Execute("input-send-event", map[string]any{
+		"events": events,
+	})
+	return err
+}
+
+// shiftedKeys maps the characters typed with shift on a US keyboard to
+// their key.
+var shiftedKeys = map[rune]string{
+	'!': "1", '@': "2", '#': "3", '$': "4", '%': "5", '^': "6", '&': "7", '*': "8", '(': "9", ')': "0",
+	'_': "minus", '+': "equal", '{': "bracket_left", '}': "bracket_right", '|': "backslash",
+	':': "semicolon", '"': "apostrophe", '~': "grave_accent", '<': "comma", '>': "dot", '?': "slash",
+}
+
+// plainKeys maps the other non-alphanumeric characters to their key.
+var plainKeys = map[rune]string{
+	' ': "spc", '\n': "ret", '\t': "tab",
+	'-': "minus", '=': "equal", '[': "bracket_left", ']': "bracket_right", '\\': "backslash",
+	';': "semicolon", '\'': "apostrophe", '`': "grave_accent", ',': "comma", '.': "dot", '/': "slash",
+}
+
+// charKeys returns the qcodes typing r on a US keyboard: the key, preceded
+// by "shift" if needed.
+func charKeys(r rune) ([]string, bool) {
+	switch {
+	case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+		return []string{string(r)}, true
+	case r >= 'A' && r <= 'Z':
+		return []string{"shift", string(r - 'A' + 'a')}, true
+	}
+	if key, ok := plainKeys[r]; ok {
+		return []string{key}, true
+	}
+	if key, ok := shiftedKeys[r]; ok {
+		return []string{"shift", key}, true
+	}
+	return nil, false
+}
+
+// TypeText types s on the guest keyboard, one character at a time with
+// delay between them, for boot parameters or installer prompts. It
+// assumes the guest uses a US keyboard layout; "\n" presses Enter. Nothing
+// is typed if s contains a character without a key.
+func (i *Instance) TypeText(s string, delay time.Duration) error {
+	var presses [][]string
+	for _, r := range s {
+		keys, ok := charKeys(r)
+		if !ok {
+			return fmt.Errorf("no key types %q", r)
+		}
+		presses = append(presses, keys)
+	}
+
+	for n, keys := range presses {
+		if n > 0 {
+			time.Sleep(delay)
+		}
+		if err := i.SendKey(keys...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package qemuctl
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInputInjection(t *testing.T) {
+	fake := newFakeQMP(t)
+	fake.handle("input-send-event", func(map[string]any) (any, *qmpError) {
+		return map[string]any{}, nil
+	})
+	inst := fake.attach()
+
+	if err := inst.SendMouseMove(10, -5); err != nil {
+		t.Fatal(err)
+	}
+	if err := inst.SendPointerAbsolute(PointerAbsoluteMax/2, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := inst.SendMouseButton(MouseLeft, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := inst.SendKeysWithHold(0, "ctrl", "alt", "f2"); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, args := range fake.commands("input-send-event") {
+		data, _ := json.Marshal(args["events"])
+		got = append(got, string(data))
+	}
+	want := []string{
+		`[{"data":{"axis":"x","value":10},"type":"rel"},{"data":{"axis":"y","value":-5},"type":"rel"}]`,
+		`[{"data":{"axis":"x","value":16383},"type":"abs"},{"data":{"axis":"y","value":0},"type":"abs"}]`,
+		`[{"data":{"button":"left","down":true},"type":"btn"}]`,
+		`[{"data":{"down":true,"key":{"data":"ctrl","type":"qcode"}},"type":"key"},` +
+			`{"data":{"down":true,"key":{"data":"alt","type":"qcode"}},"type":"key"},` +
+			`{"data":{"down":true,"key":{"data":"f2","type":"qcode"}},"type":"key"}]`,
+		`[{"data":{"down":false,"key":{"data":"f2","type":"qcode"}},"type":"key"},` +
+			`{"data":{"down":false,"key":{"data":"alt","type":"qcode"}},"type":"key"},` +
+			`{"data":{"down":false,"key":{"data":"ctrl","type":"qcode"}},"type":"key"}]`,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("sent %d input-send-event commands, want %d:\n%v", len(got), len(want), got)
+	}
+	for n := range want {
+		if got[n] != want[n] {
+			t.Errorf("events %d = %s\nwant %s", n, got[n], want[n])
+		}
+	}
+}
+
+func TestTypeText(t *testing.T) {
+	fake := newFakeQMP(t)
+	fake.handle("send-key", func(map[string]any) (any, *qmpError) {
+		return map[string]any{}, nil
+	})
+	inst := fake.attach()
+
+	if err := inst.TypeText("é", 0); err == nil {
+		t.Error("TypeText() accepted a character without a key")
+	}
+	if n := len(fake.commands("send-key")); n != 0 {
+		t.Fatalf("send-key sent %d times for untypable text", n)
+	}
+
+	if err := inst.TypeText("Ab 1!\n", time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, args := range fake.commands("send-key") {
+		var keys []string
+		for _, k := range args["keys"].([]any) {
+			keys = append(keys, k.(map[string]any)["data"].(string))
+		}
+		got = append(got, strings.Join(keys, "+"))
+	}
+	want := []string{"shift+a", "b", "spc", "1", "shift+1", "ret"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("keys = %v, want %v", got, want)
+	}
+}
@@ -3,8 +3,11 @@ package qemuctl
 import (
 	"context"
 	"crypto/rand"
+	"crypto/tls"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"os/exec"
@@ -12,6 +15,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -24,7 +28,35 @@ type Instance struct {
 	pid        int
 	process    *os.Process
 	config     *Config
+	vmConfig   *VMConfig
 	socketPath string
+	args       []string
+	warnings   *warningCollector
+	history    instanceHistory
+
+	// accel is the accelerator chosen at start
+	accel string
+
+	// tpm is the swtpm process of instances started with a TPM
+	tpm *helperProcess
+
+	// passt are the passt processes launched for PasstNetBackend NICs
+	passt []*helperProcess
+
+	// cgroup is the cgroup of the QEMU process, removed at cleanup if
+	// ownCgroup is set
+	cgroup    string
+	ownCgroup bool
+
+	agent   *GuestAgent
+	agentMu sync.Mutex
+
+	// clipboard talks to spice-vdagent for SetGuestClipboard
+	clipboard   *vdagentClient
+	clipboardMu sync.Mutex
+
+	timings   BootTimings
+	timingsMu sync.Mutex
 
 	qmp     *QMP
 	qmpMu   sync.Mutex
@@ -33,6 +65,39 @@ type Instance struct {
 
 	onStateChange func(State)
 	onEvent       func(*Event)
+
+	lastPanic *GuestPanickedEvent
+	onPanic   func(*GuestPanickedEvent)
+	panicMu   sync.Mutex
+
+	lastIOError *BlockIOErrorEvent
+	onIOError   func(*BlockIOErrorEvent)
+	ioErrorSeq  map[string]uint64
+	ioStopped   bool
+	ioErrorMu   sync.Mutex
+
+	onRxFilter func(*RxFilterInfo)
+	rxFilterMu sync.Mutex
+
+	caps   *Capabilities
+	capsMu sync.Mutex
+
+	// forwards are the user-mode host forwards, seeded from vmConfig on
+	// first use
+	forwards       []PortForward
+	forwardsSeeded bool
+	forwardsMu     sync.Mutex
+
+	// bootOnce waits for the resets after SetBootOnce
+	bootOnce   *Subscription
+	bootOnceMu sync.Mutex
+
+	// supervisor is set for instances started by a Supervisor
+	supervisor *Supervisor
+
+	// stopRequested is set when the instance is stopped on purpose, so
+	// that a restart policy does not bring it back
+	stopRequested atomic.Bool
 }
 
 // Name returns the instance name.
@@ -48,11 +113,39 @@ func (i *Instance) PID() int {
 	return i.pid
 }
 
-// SocketPath returns the path to the QMP control socket.
+// Accel returns the accelerator the instance was started with, such as
+// "kvm", or "tcg" after a fallback (see VMConfig.AccelFallback). It is
+// empty for attached instances and when QEMU picked its default.
+func (i *Instance) Accel() string {
+	return i.accel
+}
+
+// QemuVersion returns the version of the QEMU process, as announced in the
+// QMP greeting, or the zero version if the instance is not connected.
+func (i *Instance) QemuVersion() QemuVersion {
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return QemuVersion{}
+	}
+	return qmp.Version()
+}
+
+// SocketPath returns the path to the QMP control socket, or an empty
+// string for instances attached over TCP.
 func (i *Instance) SocketPath() string {
 	return i.socketPath
 }
 
+// VMConfig returns the configuration the instance was started with. For
+// instances attached with AttachByPID, it is reconstructed from the QEMU
+// command line. It returns nil if the configuration is unknown.
+func (i *Instance) VMConfig() *VMConfig {
+	return i.vmConfig
+}
+
 // State returns the current state of the instance.
 func (i *Instance) State() State {
 	i.stateMu.RLock()
@@ -67,6 +160,13 @@ func (i *Instance) setState(s State) {
 	i.state = s
 	i.stateMu.Unlock()
 
+	if s == StateRunning {
+		i.markBoot(func(b *BootTimings) *time.Time { return &b.FirstResume }, time.Now())
+	}
+
+	if old != s {
+		i.history.recordState(s)
+	}
 	if old != s && i.onStateChange != nil {
 		i.onStateChange(s)
 	}
@@ -105,13 +205,29 @@ func (i *Instance) QMP() *QMP {
 	return i.qmp
 }
 
+// ExecuteBatch sends several QMP commands at once and returns their
+// results in order. See QMP.ExecuteBatch.
+func (i *Instance) ExecuteBatch(cmds []BatchCommand) ([]BatchResult, error) {
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return nil, ErrNotConnected
+	}
+	return qmp.ExecuteBatch(cmds, 30*time.Second)
+}
+
 // Start launches a new QEMU instance with the given configuration.
 func Start(cfg *Config) (*Instance, error) {
 	return StartContext(context.Background(), cfg)
 }
 
 // StartContext launches a new QEMU instance with context support.
-func StartContext(ctx context.Context, cfg *Config) (*Instance, error) {
+func StartContext(ctx context.Context, cfg *Config) (inst *Instance, err error) {
+	ctx, span := startSpan(ctx, "qemuctl.start")
+	defer func() { endInstanceSpan(span, inst, err) }()
+
 	if cfg == nil {
 		cfg = DefaultConfig()
 	}
@@ -119,6 +235,7 @@ func StartContext(ctx context.Context, cfg *Config) (*Instance, error) {
 	if err := cfg.Validate(); err != nil {
 		return nil, fmt.Errorf("invalid config: %w", err)
 	}
+	cfg, accel := cfg.resolveAccel()
 
 	// Generate name if not provided
 	name := cfg.Name
@@ -139,51 +256,64 @@ func StartContext(ctx context.Context, cfg *Config) (*Instance, error) {
 	}
 
 	socketPath := filepath.Join(socketDir, name+".sock")
+	if err := checkSocketPath(socketPath); err != nil {
+		return nil, err
+	}
 
 	// Remove stale socket
 	os.Remove(socketPath)
 
 	// Build command line
 	args := buildArgs(cfg, name, socketPath)
+	if v, err := DetectQemuVersion(qemuPath); err == nil {
+		args = translateArgs(args, v)
+	}
 
 	// Create and start process
-	cmd := exec.CommandContext(ctx, qemuPath, args...)
-	cmd.Dir = "/"
-	cmd.Stdin = nil
-	cmd.Stdout = nil
-	cmd.Stderr = nil
-
-	// Set process group so we can kill all children
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Setpgid: true,
+	cmd := qemuCommand(ctx, qemuPath, args, cfg.Daemonize)
+	if err := applyRunAs(cmd, cfg.RunAsUser, cfg.RunAsGroup, cfg.Chroot != ""); err != nil {
+		return nil, err
 	}
 
-	if err := cmd.Start(); err != nil {
-		return nil, fmt.Errorf("failed to start QEMU: %w", err)
+	processStart := time.Now()
+	warnings, err := startQemu(cmd, cfg.Daemonize, cfg.OutputLog, cfg.Output)
+	if err != nil {
+		return nil, err
 	}
 
-	inst := &Instance{
+	inst = &Instance{
 		name:       name,
 		process:    cmd.Process,
 		config:     cfg,
 		socketPath: socketPath,
+		args:       append([]string{qemuPath}, args...),
+		warnings:   warnings,
+		accel:      accel,
 		state:      StatePrelaunch,
+		timings:    BootTimings{ProcessStart: processStart},
 	}
 
 	// Wait for socket to be available
-	if err := waitForSocket(ctx, socketPath, 10*time.Second); err != nil {
+	if err := waitForSocket(ctx, socketPath, 10*time.Second, warnings.exited()); err != nil {
 		cmd.Process.Kill()
-		return nil, fmt.Errorf("QEMU failed to create socket: %w", err)
+		return nil, warnings.startError(fmt.Errorf("QEMU failed to create socket: %w", err))
 	}
 
 	// Connect QMP
 	qmp, err := newQMP(socketPath)
 	if err != nil {
 		cmd.Process.Kill()
-		return nil, fmt.Errorf("failed to connect QMP: %w", err)
+		return nil, warnings.startError(fmt.Errorf("failed to connect QMP: %w", err))
 	}
 
 	inst.qmp = qmp
+	inst.markBoot(func(b *BootTimings) *time.Time { return &b.QMPReady }, time.Now())
+	qmp.addEventHook(inst.recordBootEvent)
+	qmp.addEventHook(inst.recordPanic)
+	qmp.addEventHook(inst.recordIOError)
+	qmp.addEventHook(inst.recordAgentPort)
+	qmp.addEventHook(inst.recordRxFilter)
+	qmp.addEventHook(inst.history.recordEvent)
 	qmp.SetStateChangeCallback(func(s State) {
 		inst.setState(s)
 	})
@@ -196,13 +326,24 @@ func StartContext(ctx context.Context, cfg *Config) (*Instance, error) {
 	return inst, nil
 }
 
-// Attach connects to an existing QEMU instance by its control socket.
+// Attach connects to an existing QEMU instance by its control socket. The
+// socket may also be given as "unix:path", or as "tcp:host:port" for a
+// monitor exposed on TCP (see AttachTLS for encrypted monitors).
 func Attach(socketPath string) (*Instance, error) {
 	return AttachContext(context.Background(), socketPath)
 }
 
 // AttachContext connects to an existing QEMU instance with context support.
-func AttachContext(ctx context.Context, socketPath string) (*Instance, error) {
+func AttachContext(ctx context.Context, socketPath string) (inst *Instance, err error) {
+	ctx, span := startSpan(ctx, "qemuctl.attach")
+	defer func() { endInstanceSpan(span, inst, err) }()
+
+	// Monitors exposed on TCP are given as "tcp:host:port"
+	if address, ok := strings.CutPrefix(socketPath, "tcp:"); ok {
+		return attachRemote(ctx, address, nil)
+	}
+	socketPath = strings.TrimPrefix(socketPath, "unix:")
+
 	// Verify socket exists
 	if _, err := os.Stat(socketPath); err != nil {
 		return nil, fmt.Errorf("socket not found: %w", err)
@@ -217,6 +358,37 @@ func AttachContext(ctx context.Context, socketPath string) (*Instance, error) {
 	name := filepath.Base(socketPath)
 	name = strings.TrimSuffix(name, ".sock")
 
+	return newAttachedInstance(name, socketPath, qmp), nil
+}
+
+// AttachTLS connects to a QEMU instance whose QMP monitor is exposed on
+// TCP with TLS (a socket chardev with tls-creds). The address is
+// "host:port" and config must trust the certificate QEMU presents; its
+// ServerName defaults to the host.
+func AttachTLS(address string, config *tls.Config) (*Instance, error) {
+	return AttachTLSContext(context.Background(), address, config)
+}
+
+// AttachTLSContext connects to a QMP monitor over TLS with context support.
+func AttachTLSContext(ctx context.Context, address string, config *tls.Config) (*Instance, error) {
+	if config == nil {
+		config = &tls.Config{}
+	}
+	return attachRemote(ctx, strings.TrimPrefix(address, "tcp:"), config)
+}
+
+// attachRemote connects to a QMP monitor on a TCP address. Remote instances
+// have no socket path and are named after their address.
+func attachRemote(ctx context.Context, address string, tlsConfig *tls.Config) (*Instance, error) {
+	qmp, err := dialQMP(ctx, address, tlsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect QMP: %w", err)
+	}
+	return newAttachedInstance(address, "", qmp), nil
+}
+
+// newAttachedInstance wraps a QMP connection to an existing instance.
+func newAttachedInstance(name, socketPath string, qmp *QMP) *Instance {
 	inst := &Instance{
 		name:       name,
 		socketPath: socketPath,
@@ -224,6 +396,11 @@ func AttachContext(ctx context.Context, socketPath string) (*Instance, error) {
 		state:      StateUnknown,
 	}
 
+	qmp.addEventHook(inst.recordPanic)
+	qmp.addEventHook(inst.recordIOError)
+	qmp.addEventHook(inst.recordAgentPort)
+	qmp.addEventHook(inst.recordRxFilter)
+	qmp.addEventHook(inst.history.recordEvent)
 	qmp.SetStateChangeCallback(func(s State) {
 		inst.setState(s)
 	})
@@ -233,7 +410,7 @@ func AttachContext(ctx context.Context, socketPath string) (*Instance, error) {
 		// Non-fatal
 	}
 
-	return inst, nil
+	return inst
 }
 
 // AttachByPID connects to an existing QEMU instance by its process ID.
@@ -266,6 +443,13 @@ func AttachByPIDContext(ctx context.Context, pid int) (*Instance, error) {
 		inst.process = proc
 	}
 	inst.pid = pid
+	inst.args = args
+	inst.cgroup, _ = CgroupOf(pid)
+
+	// Reconstruct the configuration; failure is not fatal for attaching
+	if cfg, err := ParseArgs(args); err == nil {
+		inst.vmConfig = cfg
+	}
 
 	// Try to find name from -name argument
 	for i := 0; i < len(args)-1; i++ {
@@ -405,7 +589,12 @@ func (i *Instance) Stop(timeout time.Duration) error {
 // It sends an ACPI power button event (system_powerdown) and waits for the
 // guest to shut down. If the guest doesn't respond within the timeout,
 // it forcefully terminates the QEMU process.
-func (i *Instance) StopContext(ctx context.Context, timeout time.Duration) error {
+func (i *Instance) StopContext(ctx context.Context, timeout time.Duration) (err error) {
+	i.stopRequested.Store(true)
+
+	ctx, span := startSpan(ctx, "qemuctl.stop", AttrVMName, i.name)
+	defer func() { endSpan(span, err) }()
+
 	i.qmpMu.Lock()
 	qmp := i.qmp
 	i.qmpMu.Unlock()
@@ -416,7 +605,7 @@ func (i *Instance) StopContext(ctx context.Context, timeout time.Duration) error
 	}
 
 	// Send ACPI power button event
-	_, err := qmp.Execute("system_powerdown", nil)
+	_, err = qmp.ExecuteContext(ctx, "system_powerdown", nil)
 	if err != nil {
 		// QMP command failed, force stop
 		i.ForceStop()
@@ -460,6 +649,8 @@ func (i *Instance) StopContext(ctx context.Context, timeout time.Duration) error
 // Shutdown sends a powerdown request to the guest (ACPI power button).
 // Unlike Stop, this does not wait or force kill.
 func (i *Instance) Shutdown() error {
+	i.stopRequested.Store(true)
+
 	i.qmpMu.Lock()
 	qmp := i.qmp
 	i.qmpMu.Unlock()
@@ -474,6 +665,20 @@ func (i *Instance) Shutdown() error {
 
 // ForceStop immediately terminates the QEMU process.
 func (i *Instance) ForceStop() error {
+	i.stopRequested.Store(true)
+
+	_, span := startSpan(context.Background(), "qemuctl.force_stop", AttrVMName, i.name)
+	err := i.forceStop()
+	endSpan(span, err)
+	return err
+}
+
+// forceStop kills QEMU without marking the stop as requested, for a
+// restart policy to apply.
+func (i *Instance) forceStop() error {
+	if i.ownCgroup {
+		killCgroup(i.cgroup)
+	}
 	if i.process != nil {
 		// Kill the process group
 		syscall.Kill(-i.process.Pid, syscall.SIGKILL)
@@ -488,6 +693,8 @@ func (i *Instance) ForceStop() error {
 
 // Quit sends the quit command to QEMU (immediate exit).
 func (i *Instance) Quit() error {
+	i.stopRequested.Store(true)
+
 	i.qmpMu.Lock()
 	qmp := i.qmp
 	i.qmpMu.Unlock()
@@ -535,14 +742,32 @@ func (i *Instance) cleanup() {
 	if i.socketPath != "" {
 		os.Remove(i.socketPath)
 	}
+
+	if i.tpm != nil {
+		i.tpm.stop()
+	}
+	for _, p := range i.passt {
+		p.stop()
+	}
+
+	if i.ownCgroup {
+		removeCgroup(i.cgroup)
+	}
 }
 
 // Wait waits for the QEMU process to exit.
 func (i *Instance) Wait() error {
+	_, err := i.wait()
+	return err
+}
+
+// wait waits for the QEMU process to exit and returns its exit status,
+// which is unknown (nil) for attached instances.
+func (i *Instance) wait() (*os.ProcessState, error) {
 	if i.process != nil {
-		_, err := i.process.Wait()
+		state, err := i.process.Wait()
 		i.cleanup()
-		return err
+		return state, err
 	}
 
 	// For attached instances, poll until dead
@@ -550,7 +775,7 @@ func (i *Instance) Wait() error {
 		time.Sleep(100 * time.Millisecond)
 	}
 	i.cleanup()
-	return nil
+	return nil, nil
 }
 
 // WaitContext waits for the QEMU process with context support.
@@ -583,18 +808,22 @@ func buildArgs(cfg *Config, name, socketPath string) []string {
 		args = append(args, "-no-user-config", "-nodefaults")
 	}
 
+	if cfg.Chroot != "" {
+		args = append(args, "-run-with", "chroot="+cfg.Chroot)
+	}
+	args = append(args, buildSandboxArgs(cfg.Sandbox)...)
+
 	// Machine
+	profile := profileFor(cfg.Arch)
 	machine := cfg.Machine
 	if machine == "" {
-		if cfg.Arch == "" || cfg.Arch == "amd64" || cfg.Arch == "386" {
-			machine = "q35"
-		}
+		machine = profile.machine
 	}
 
 	if machine != "" {
 		machineArg := machine
 		if cfg.KVM == nil || *cfg.KVM {
-			machineArg += ",accel=kvm"
+			machineArg += ",accel=" + hostAccel()
 		}
 		args = append(args, "-machine", machineArg)
 	}
@@ -619,6 +848,9 @@ func buildArgs(cfg *Config, name, socketPath string) []string {
 	// QMP monitor socket
 	args = append(args, "-chardev", fmt.Sprintf("socket,id=qmp,path=%s,server=on,wait=off", socketPath))
 	args = append(args, "-mon", "chardev=qmp,id=monitor,mode=control")
+	if cfg.Daemonize {
+		args = append(args, "-pidfile", pidFilePath(socketPath))
+	}
 
 	// Drives
 	for idx, drive := range cfg.Drives {
@@ -673,7 +905,7 @@ func buildArgs(cfg *Config, name, socketPath string) []string {
 		// Device
 		model := net.Model
 		if model == "" {
-			model = "virtio-net-pci"
+			model = profile.virtio("virtio-net")
 		}
 		deviceArg := fmt.Sprintf("%s,netdev=%s", model, id)
 		if net.MACAddr != "" {
@@ -716,14 +948,68 @@ func buildArgs(cfg *Config, name, socketPath string) []string {
 	return args
 }
 
-// waitForSocket waits for the QMP socket to become available.
-func waitForSocket(ctx context.Context, path string, timeout time.Duration) error {
+// qemuCommand returns the command running QEMU, in a process group of its
+// own so that all its children can be killed. A daemonized QEMU gets a
+// session of its own, of which it also leads the group, and ctx only
+// bounds its start.
+func qemuCommand(ctx context.Context, qemuPath string, args []string, daemonize bool) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, qemuPath, args...)
+	if daemonize {
+		cmd = exec.Command(qemuPath, args...)
+	}
+	cmd.Dir = "/"
+	cmd.Stdin = nil
+	cmd.Stdout = nil
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid: !daemonize,
+		Setsid:  daemonize,
+	}
+	return cmd
+}
+
+// startQemu starts cmd, copying its output to the log file at outputLog
+// and to output, and returns the collector of its warnings. A daemonized
+// QEMU writes straight to the log, or /dev/null, so that it can outlive
+// the managing process; output is not used then.
+func startQemu(cmd *exec.Cmd, daemonize bool, outputLog string, output io.Writer) (*warningCollector, error) {
+	var warnings *warningCollector
+	if daemonize {
+		var err error
+		if warnings, err = collectLogWarnings(cmd, outputLog); err != nil {
+			return nil, err
+		}
+	} else {
+		out, err := openProcessOutput(outputLog, output)
+		if err != nil {
+			return nil, err
+		}
+		if warnings, err = collectWarnings(cmd, out); err != nil {
+			if out != nil {
+				out.Close()
+			}
+			return nil, err
+		}
+	}
+
+	err := cmd.Start()
+	warnings.closeChildFiles()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start QEMU: %w", err)
+	}
+	return warnings, nil
+}
+
+// waitForSocket waits for the QMP socket to become available, giving up
+// early once exited is closed.
+func waitForSocket(ctx context.Context, path string, timeout time.Duration, exited <-chan struct{}) error {
 	deadline := time.Now().Add(timeout)
 
 	for time.Now().Before(deadline) {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
+		case <-exited:
+			return errors.New("QEMU exited")
 		default:
 		}
 
@@ -0,0 +1,160 @@
+package qemuctl
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ResumeWhenSpaceAvailable timings. The settle time leaves a guest still out
+// of space time to fail its retried requests and stop again.
+var (
+	ioErrorRetryInterval = 5 * time.Second
+	ioErrorSettleTime    = time.Second
+)
+
+// SetIOErrorCallback sets a callback called when a disk error pauses the
+// guest, once the instance has moved to StatePaused. Which errors pause the
+// guest is set by DiskConfig.WriteError and ReadError; by default only
+// writes failing for lack of space on the host do, with NoSpace set. The
+// callback runs once per pause, with the first failed request; QEMU
+// reports each of them.
+func (i *Instance) SetIOErrorCallback(cb func(*BlockIOErrorEvent)) {
+	i.ioErrorMu.Lock()
+	defer i.ioErrorMu.Unlock()
+	i.onIOError = cb
+}
+
+// LastIOError returns the last disk error that paused the guest, or nil if
+// none did since the instance was started or attached.
+func (i *Instance) LastIOError() *BlockIOErrorEvent {
+	i.ioErrorMu.Lock()
+	defer i.ioErrorMu.Unlock()
+	return i.lastIOError
+}
+
+// recordIOError handles BLOCK_IO_ERROR events that stopped the guest, and
+// RESUME events ending the pause.
+func (i *Instance) recordIOError(event *Event) {
+	if event.Name == "RESUME" {
+		i.ioErrorMu.Lock()
+		i.ioStopped = false
+		i.ioErrorMu.Unlock()
+		return
+	}
+	if event.Name != "BLOCK_IO_ERROR" {
+		return
+	}
+	p, _ := event.Payload().(*BlockIOErrorEvent)
+	if p == nil || p.Action != "stop" {
+		return
+	}
+
+	// QEMU sends the STOP event after this one
+	i.setState(StatePaused)
+
+	i.ioErrorMu.Lock()
+	i.lastIOError = p
+	if i.ioErrorSeq == nil {
+		i.ioErrorSeq = make(map[string]uint64)
+	}
+	i.ioErrorSeq[p.NodeName]++
+	if p.Device != "" && p.Device != p.NodeName {
+		i.ioErrorSeq[p.Device]++
+	}
+	cb := i.onIOError
+	if i.ioStopped {
+		cb = nil
+	}
+	i.ioStopped = true
+	i.ioErrorMu.Unlock()
+
+	if cb != nil {
+		cb(p)
+	}
+}
+
+// ioErrors returns the number of errors that stopped the guest on node,
+// a node name or drive ID.
+func (i *Instance) ioErrors(node string) uint64 {
+	i.ioErrorMu.Lock()
+	defer i.ioErrorMu.Unlock()
+	return i.ioErrorSeq[node]
+}
+
+// ResumeWhenSpaceAvailable resumes a guest paused by a disk error on node,
+// a node name or drive ID as reported by BlockIOErrorEvent, once the
+// operator has freed space on the host. QEMU retries the failed requests on
+// resume; while they keep failing on node the guest stops again, and
+// ResumeWhenSpaceAvailable tries again every few seconds until the guest
+// keeps running or ctx is done. It returns nil right away if the guest is
+// running, and fails if the guest is paused for another reason or stops on
+// an error on another node.
+func (i *Instance) ResumeWhenSpaceAvailable(ctx context.Context, node string) error {
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return ErrNotConnected
+	}
+
+	status, err := queryRunStatus(qmp)
+	for err == nil && status == "io-error" {
+		seq := i.ioErrors(node)
+		if _, err := qmp.Execute("cont", nil); err != nil {
+			return err
+		}
+		if err := sleepContext(ctx, ioErrorSettleTime); err != nil {
+			return err
+		}
+
+		status, err = queryRunStatus(qmp)
+		if err != nil || status != "io-error" {
+			break
+		}
+		if i.ioErrors(node) == seq {
+			if last := i.LastIOError(); last != nil {
+				return fmt.Errorf("guest stopped on a %s error on %s", last.Operation, last.NodeName)
+			}
+			return fmt.Errorf("guest stopped on a disk error")
+		}
+		if err := sleepContext(ctx, ioErrorRetryInterval); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case err != nil:
+		return err
+	case status != "running":
+		return fmt.Errorf("guest is %s, not stopped on a disk error", status)
+	}
+	i.setState(StateRunning)
+	return nil
+}
+
+// queryRunStatus returns the run state reported by query-status.
+func queryRunStatus(qmp *QMP) (string, error) {
+	result, err := qmp.Execute("query-status", nil)
+	if err != nil {
+		return "", err
+	}
+	var status struct {
+		Status string `json:"status"`
+	}
+	if err := unmarshalJSON(result, &status); err != nil {
+		return "", err
+	}
+	return status.Status, nil
+}
+
+// sleepContext waits for d or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
@@ -0,0 +1,119 @@
+package qemuctl
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDiskErrorActions(t *testing.T) {
+	disk := &DiskConfig{
+		ID:         "disk0",
+		Backend:    &FileDiskBackend{Path: "/var/lib/qemu/disk.qcow2", Format: "qcow2"},
+		WriteError: DiskErrorENOSPC,
+		ReadError:  DiskErrorReport,
+	}
+	args := strings.Join(buildDiskArgs(disk, x86Profile, nil, nil), " ")
+	if !strings.Contains(args, "werror=enospc") || !strings.Contains(args, "rerror=report") {
+		t.Errorf("disk args = %s, want werror=enospc and rerror=report", args)
+	}
+
+	parsed, err := ParseArgs([]string{"-blockdev", `{"driver":"file","filename":"/var/lib/qemu/disk.qcow2","node-name":"disk0-file"}`,
+		"-blockdev", `{"driver":"qcow2","file":"disk0-file","node-name":"disk0-format"}`,
+		"-device", "virtio-blk-pci,drive=disk0-format,id=disk0-device,werror=stop,rerror=ignore"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := parsed.Disks[0]; d.WriteError != DiskErrorStop || d.ReadError != DiskErrorIgnore {
+		t.Errorf("parsed error actions = %q/%q, want stop/ignore", d.WriteError, d.ReadError)
+	}
+
+	for _, d := range []*DiskConfig{
+		{ID: "disk0", WriteError: "pause"},
+		{ID: "disk0", Interface: "nvme", WriteError: DiskErrorStop},
+	} {
+		cfg := &VMConfig{Disks: []*DiskConfig{d}}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Validate() accepted %+v", d)
+		}
+	}
+}
+
+func TestResumeWhenSpaceAvailable(t *testing.T) {
+	ioErrorRetryInterval = 10 * time.Millisecond
+	ioErrorSettleTime = 20 * time.Millisecond
+	t.Cleanup(func() {
+		ioErrorRetryInterval = 5 * time.Second
+		ioErrorSettleTime = time.Second
+	})
+
+	fake := newFakeQMP(t)
+	var status atomic.Value
+	status.Store("io-error")
+	fake.handle("query-status", func(map[string]any) (any, *qmpError) {
+		return map[string]any{"status": status.Load(), "running": status.Load() == "running"}, nil
+	})
+
+	// The first retry still finds the disk full
+	outOfSpace := map[string]any{
+		"device": "", "node-name": "disk0-format", "operation": "write",
+		"action": "stop", "nospace": true, "reason": "No space left on device",
+	}
+	var conts atomic.Int32
+	fake.handle("cont", func(map[string]any) (any, *qmpError) {
+		fake.sendEvent("RESUME", nil)
+		if conts.Add(1) == 1 {
+			fake.sendEvent("BLOCK_IO_ERROR", outOfSpace)
+			fake.sendEvent("STOP", nil)
+			return nil, nil
+		}
+		status.Store("running")
+		return nil, nil
+	})
+	inst := fake.attach()
+
+	errs := make(chan *BlockIOErrorEvent, 4)
+	inst.SetIOErrorCallback(func(e *BlockIOErrorEvent) {
+		if inst.State() != StatePaused {
+			t.Errorf("state in I/O error callback = %v, want paused", inst.State())
+		}
+		errs <- e
+	})
+	fake.sendEvent("BLOCK_IO_ERROR", outOfSpace)
+	fake.sendEvent("BLOCK_IO_ERROR", outOfSpace)
+	fake.sendEvent("STOP", nil)
+	select {
+	case e := <-errs:
+		if !e.NoSpace || e.NodeName != "disk0-format" || e.Operation != "write" {
+			t.Errorf("I/O error = %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("I/O error callback not called")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := inst.ResumeWhenSpaceAvailable(ctx, "disk0-format"); err != nil {
+		t.Fatalf("ResumeWhenSpaceAvailable() = %v", err)
+	}
+	if n := len(errs); n != 1 {
+		t.Errorf("I/O error callback called %d times while retrying, want once per pause", n)
+	}
+	if n := conts.Load(); n != 2 {
+		t.Errorf("cont sent %d times, want 2", n)
+	}
+	if inst.State() != StateRunning {
+		t.Errorf("state = %v, want running", inst.State())
+	}
+	if inst.LastIOError() == nil {
+		t.Error("LastIOError() = nil after an I/O error")
+	}
+
+	// A guest paused by the user is left alone
+	status.Store("paused")
+	if err := inst.ResumeWhenSpaceAvailable(ctx, "disk0-format"); err == nil {
+		t.Error("ResumeWhenSpaceAvailable() resumed a guest paused by the user")
+	}
+}
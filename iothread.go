@@ -0,0 +1,141 @@
+package qemuctl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// IOThreadConfig configures an I/O thread. Disks assigned to it with
+// DiskConfig.IOThread process their requests on it instead of the main
+// QEMU thread.
+type IOThreadConfig struct {
+	// ID is the object ID disks refer to.
+	ID string `json:"id"`
+
+	// PollMaxNs is the longest the thread busy-polls for new requests
+	// before sleeping, in nanoseconds. Zero keeps QEMU's default (32µs).
+	PollMaxNs int64 `json:"poll_max_ns,omitempty"`
+
+	// PollGrow and PollShrink are the factors by which the polling time
+	// adapts; zero keeps QEMU's defaults.
+	PollGrow   int64 `json:"poll_grow,omitempty"`
+	PollShrink int64 `json:"poll_shrink,omitempty"`
+
+	// NoPoll disables polling, trading latency for host CPU time.
+	NoPoll bool `json:"no_poll,omitempty"`
+}
+
+// buildIOThreadArgs builds the -object argument of an I/O thread.
+func buildIOThreadArgs(cfg *IOThreadConfig) []string {
+	parts := []string{"iothread", "id=" + cfg.ID}
+	switch {
+	case cfg.NoPoll:
+		parts = append(parts, "poll-max-ns=0")
+	case cfg.PollMaxNs > 0:
+		parts = append(parts, "poll-max-ns="+strconv.FormatInt(cfg.PollMaxNs, 10))
+	}
+	if cfg.PollGrow > 0 {
+		parts = append(parts, "poll-grow="+strconv.FormatInt(cfg.PollGrow, 10))
+	}
+	if cfg.PollShrink > 0 {
+		parts = append(parts, "poll-shrink="+strconv.FormatInt(cfg.PollShrink, 10))
+	}
+	return []string{"-object", strings.Join(parts, ",")}
+}
+
+// ioThreadFromOpts rebuilds an IOThreadConfig from its -object options.
+func ioThreadFromOpts(o *qemuOpts) *IOThreadConfig {
+	cfg := &IOThreadConfig{ID: o.get("id")}
+	if v := o.get("poll-max-ns"); v != "" {
+		cfg.PollMaxNs, _ = strconv.ParseInt(v, 10, 64)
+		cfg.NoPoll = cfg.PollMaxNs == 0
+	}
+	cfg.PollGrow, _ = strconv.ParseInt(o.get("poll-grow"), 10, 64)
+	cfg.PollShrink, _ = strconv.ParseInt(o.get("poll-shrink"), 10, 64)
+	return cfg
+}
+
+// checkIOThreads checks that I/O threads have unique IDs and that disks
+// and SCSI controllers only use declared ones.
+func (cfg *VMConfig) checkIOThreads() error {
+	ids := make(map[string]bool, len(cfg.IOThreads))
+	for _, t := range cfg.IOThreads {
+		if t.ID == "" {
+			return fmt.Errorf("I/O thread without an ID")
+		}
+		if ids[t.ID] {
+			return fmt.Errorf("duplicate I/O thread %s", t.ID)
+		}
+		ids[t.ID] = true
+	}
+	for _, disk := range cfg.Disks {
+		if disk.IOThread == "" {
+			continue
+		}
+		if !ids[disk.IOThread] {
+			return fmt.Errorf("disk %s: unknown I/O thread %s", disk.ID, disk.IOThread)
+		}
+		if disk.Interface != "" && disk.Interface != "virtio" {
+			return fmt.Errorf("disk %s: only virtio disks can use an I/O thread", disk.ID)
+		}
+	}
+	for _, c := range cfg.SCSIControllers {
+		if c.IOThread != "" && !ids[c.IOThread] {
+			return fmt.Errorf("SCSI controller %s: unknown I/O thread %s", c.ID, c.IOThread)
+		}
+	}
+	return nil
+}
+
+// IOThreadInfo describes a running I/O thread.
+type IOThreadInfo struct {
+	ID          string `json:"id"`
+	ThreadID    int    `json:"thread-id"`
+	PollMaxNs   int64  `json:"poll-max-ns"`
+	PollGrow    int64  `json:"poll-grow"`
+	PollShrink  int64  `json:"poll-shrink"`
+	AioMaxBatch int64  `json:"aio-max-batch,omitempty"`
+}
+
+// QueryIOThreads returns the I/O threads with their host thread IDs, for
+// pinning them to host CPUs, and their polling settings.
+func (i *Instance) QueryIOThreads() ([]IOThreadInfo, error) {
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return nil, ErrNotConnected
+	}
+
+	result, err := qmp.Execute("query-iothreads", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var threads []IOThreadInfo
+	if err := unmarshalJSON(result, &threads); err != nil {
+		return nil, err
+	}
+	return threads, nil
+}
+
+// SetIOThreadPollMaxNs changes the maximum polling time of a running I/O
+// thread, in nanoseconds; zero disables polling.
+func (i *Instance) SetIOThreadPollMaxNs(id string, ns int64) error {
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return ErrNotConnected
+	}
+
+	_, err := qmp.Execute("qom-set", map[string]any{
+		"path":     "/objects/" + id,
+		"property": "poll-max-ns",
+		"value":    ns,
+	})
+	return err
+}
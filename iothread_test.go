@@ -0,0 +1,80 @@
+package qemuctl
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestVMBuilderIOThreads(t *testing.T) {
+	cfg := &VMConfig{
+		IOThreads: []*IOThreadConfig{{ID: "io0", PollMaxNs: 65536}, {ID: "io1", NoPoll: true}},
+		Disks: []*DiskConfig{
+			{ID: "disk0", Backend: &FileDiskBackend{Path: "/images/a.qcow2", Format: "qcow2"}, IOThread: "io0"},
+		},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	args := NewVMBuilder(cfg).Build("test", "")
+	joined := strings.Join(args, " ")
+	for _, want := range []string{
+		"-object iothread,id=io0,poll-max-ns=65536",
+		"-object iothread,id=io1,poll-max-ns=0",
+		"virtio-blk-pci,drive=disk0-format,id=disk0-device,bus=pcie.0,addr=0x3,iothread=io0",
+	} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("args missing %q:\n%s", want, joined)
+		}
+	}
+	if strings.Index(joined, "iothread,id=io0") > strings.Index(joined, "virtio-blk-pci") {
+		t.Error("I/O thread objects must come before the disks")
+	}
+
+	parsed, err := ParseArgs(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed.IOThreads, cfg.IOThreads) {
+		t.Errorf("parsed I/O threads = %+v, want %+v", parsed.IOThreads, cfg.IOThreads)
+	}
+	if parsed.Disks[0].IOThread != "io0" {
+		t.Errorf("parsed disk I/O thread = %q, want io0", parsed.Disks[0].IOThread)
+	}
+
+	for _, bad := range []*VMConfig{
+		{Disks: []*DiskConfig{{ID: "disk0", IOThread: "io9"}}},
+		{IOThreads: []*IOThreadConfig{{ID: "io0"}}, Disks: []*DiskConfig{{ID: "disk0", Interface: "ide", IOThread: "io0"}}},
+		{IOThreads: []*IOThreadConfig{{ID: "io0"}, {ID: "io0"}}},
+	} {
+		if err := bad.Validate(); err == nil {
+			t.Errorf("Validate() accepted %+v", bad)
+		}
+	}
+}
+
+func TestIOThreadRuntime(t *testing.T) {
+	fake := newFakeQMP(t)
+	fake.handle("query-iothreads", func(map[string]any) (any, *qmpError) {
+		return []map[string]any{{"id": "io0", "thread-id": 4242, "poll-max-ns": 32768, "poll-grow": 0, "poll-shrink": 0, "aio-max-batch": 0}}, nil
+	})
+	fake.handle("qom-set", func(map[string]any) (any, *qmpError) { return nil, nil })
+	inst := fake.attach()
+
+	threads, err := inst.QueryIOThreads()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(threads) != 1 || threads[0].ThreadID != 4242 || threads[0].PollMaxNs != 32768 {
+		t.Errorf("QueryIOThreads() = %+v", threads)
+	}
+
+	if err := inst.SetIOThreadPollMaxNs("io0", 0); err != nil {
+		t.Fatal(err)
+	}
+	args := fake.commands("qom-set")[0]
+	if args["path"] != "/objects/io0" || args["property"] != "poll-max-ns" || args["value"] != float64(0) {
+		t.Errorf("qom-set arguments = %v", args)
+	}
+}
@@ -0,0 +1,396 @@
+package qemuctl
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// jobPollInterval is how often tracked jobs are refreshed with query-jobs,
+// in case a status change event was missed.
+const jobPollInterval = time.Second
+
+// jobCounter generates unique job IDs.
+var jobCounter atomic.Uint64
+
+// JobStatus is the state of a QMP job.
+type JobStatus string
+
+const (
+	JobCreated   JobStatus = "created"
+	JobRunning   JobStatus = "running"
+	JobPaused    JobStatus = "paused"
+	JobReady     JobStatus = "ready"
+	JobStandby   JobStatus = "standby"
+	JobWaiting   JobStatus = "waiting"
+	JobPending   JobStatus = "pending"
+	JobAborting  JobStatus = "aborting"
+	JobConcluded JobStatus = "concluded"
+	JobNull      JobStatus = "null" // dismissed or gone
+)
+
+// JobInfo describes a job as reported by query-jobs.
+type JobInfo struct {
+	ID     string    `json:"id"`
+	Type   string    `json:"type"`
+	Status JobStatus `json:"status"`
+
+	// CurrentProgress and TotalProgress are in arbitrary units; only their
+	// ratio is meaningful.
+	CurrentProgress int64 `json:"current-progress"`
+	TotalProgress   int64 `json:"total-progress"`
+
+	// Error is set once a job has concluded with an error.
+	Error string `json:"error,omitempty"`
+}
+
+// JobStatusChangeEvent is the payload of JOB_STATUS_CHANGE.
+type JobStatusChangeEvent struct {
+	ID     string    `json:"id"`
+	Status JobStatus `json:"status"`
+}
+
+// JobError is the error of a job that concluded unsuccessfully.
+type JobError struct {
+	ID      string
+	Message string
+}
+
+func (e *JobError) Error() string {
+	return fmt.Sprintf("job %s failed: %s", e.ID, e.Message)
+}
+
+// Job tracks a QMP job (backup, mirror, commit, stream, blockdev-create,
+// snapshot, ...) through JOB_STATUS_CHANGE events, with periodic
+// query-jobs refreshes as a fallback.
+//
+// Done is closed when the job concludes or disappears; Err then reports
+// its outcome. A concluded job stays listed by QEMU until it is dismissed,
+// either automatically (the auto-dismiss job option) or with Dismiss.
+type Job struct {
+	id   string
+	qmp  *QMP
+	sub  *Subscription
+	done chan struct{}
+
+	mu       sync.Mutex
+	info     JobInfo
+	err      error
+	finished bool
+
+	// Error reported by BLOCK_JOB_COMPLETED or BLOCK_JOB_CANCELLED, for
+	// jobs dismissed automatically before query-jobs could see them conclude
+	eventErr error
+}
+
+// StartJob runs a job-creating QMP command, such as blockdev-backup,
+// blockdev-mirror or blockdev-create, and returns the job it started. A
+// unique "job-id" argument is added unless args has one.
+func (i *Instance) StartJob(command string, args map[string]any) (*Job, error) {
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return nil, ErrNotConnected
+	}
+
+	jobArgs := make(map[string]any, len(args)+1)
+	for k, v := range args {
+		jobArgs[k] = v
+	}
+	id, _ := jobArgs["job-id"].(string)
+	if id == "" {
+		id = command + "-" + strconv.FormatUint(jobCounter.Add(1), 10)
+		jobArgs["job-id"] = id
+	}
+
+	// Subscribe first so no status change can be missed
+	job := newJob(qmp, id)
+	if _, err := qmp.Execute(command, jobArgs); err != nil {
+		job.sub.Unsubscribe()
+		return nil, err
+	}
+
+	job.Refresh()
+	go job.watch()
+	return job, nil
+}
+
+// TrackJob returns a Job tracking an existing job, such as one started
+// before attaching to the instance.
+func (i *Instance) TrackJob(id string) (*Job, error) {
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return nil, ErrNotConnected
+	}
+
+	job := newJob(qmp, id)
+	if err := job.Refresh(); err != nil {
+		job.sub.Unsubscribe()
+		return nil, err
+	}
+	if job.Status() == JobNull {
+		job.sub.Unsubscribe()
+		return nil, fmt.Errorf("job %s not found", id)
+	}
+
+	go job.watch()
+	return job, nil
+}
+
+// Jobs returns all jobs known to QEMU.
+func (i *Instance) Jobs() ([]JobInfo, error) {
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return nil, ErrNotConnected
+	}
+	return queryJobs(qmp)
+}
+
+// queryJobs runs query-jobs.
+func queryJobs(qmp *QMP) ([]JobInfo, error) {
+	result, err := qmp.Execute("query-jobs", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []JobInfo
+	if err := unmarshalJSON(result, &jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// newJob returns a job subscribed to the events concerning it.
+func newJob(qmp *QMP, id string) *Job {
+	return &Job{
+		id:   id,
+		qmp:  qmp,
+		sub:  qmp.Subscribe("JOB_STATUS_CHANGE", "BLOCK_JOB_COMPLETED", "BLOCK_JOB_CANCELLED"),
+		done: make(chan struct{}),
+		info: JobInfo{ID: id, Status: JobCreated},
+	}
+}
+
+// ID returns the job ID.
+func (j *Job) ID() string {
+	return j.id
+}
+
+// Type returns the job type ("backup", "mirror", "create", ...), or an
+// empty string until QEMU has reported it.
+func (j *Job) Type() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.info.Type
+}
+
+// Status returns the last known job status.
+func (j *Job) Status() JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.info.Status
+}
+
+// Progress returns the last known progress of the job. Call Refresh first
+// for an up-to-date value.
+func (j *Job) Progress() (current, total int64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.info.CurrentProgress, j.info.TotalProgress
+}
+
+// Info returns the last known job details.
+func (j *Job) Info() JobInfo {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.info
+}
+
+// Done returns a channel closed when the job has concluded or disappeared.
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+// Err returns nil while the job runs or if it succeeded, a *JobError if it
+// failed, or the connection error if the monitor connection was closed.
+func (j *Job) Err() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.err
+}
+
+// Wait blocks until the job is done and returns Err. It returns early with
+// the context error if ctx is done; the job keeps running.
+func (j *Job) Wait(ctx context.Context) error {
+	select {
+	case <-j.done:
+		return j.Err()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Pause pauses the job.
+func (j *Job) Pause() error {
+	return j.command("job-pause")
+}
+
+// Resume resumes a paused job.
+func (j *Job) Resume() error {
+	return j.command("job-resume")
+}
+
+// Cancel cancels the job. A mirror job cancelled once ready completes
+// successfully without pivoting to the target.
+func (j *Job) Cancel() error {
+	return j.command("job-cancel")
+}
+
+// Finalize completes a job in the pending state, for jobs started with
+// auto-finalize disabled.
+func (j *Job) Finalize() error {
+	return j.command("job-finalize")
+}
+
+// Dismiss removes a concluded job from QEMU, for jobs started with
+// auto-dismiss disabled and for blockdev-create jobs.
+func (j *Job) Dismiss() error {
+	// Pick up the outcome before QEMU forgets it
+	select {
+	case <-j.done:
+	default:
+		j.Refresh()
+	}
+
+	if err := j.command("job-dismiss"); err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	j.info.Status = JobNull
+	j.mu.Unlock()
+	j.finish(nil)
+	return nil
+}
+
+// command runs a job control command.
+func (j *Job) command(name string) error {
+	_, err := j.qmp.Execute(name, map[string]any{"id": j.id})
+	return err
+}
+
+// Refresh updates the job details with query-jobs.
+func (j *Job) Refresh() error {
+	jobs, err := queryJobs(j.qmp)
+	if err != nil {
+		return err
+	}
+
+	for _, info := range jobs {
+		if info.ID != j.id {
+			continue
+		}
+		j.mu.Lock()
+		j.info = info
+		j.mu.Unlock()
+
+		if info.Status == JobConcluded {
+			j.finish(nil)
+		}
+		return nil
+	}
+
+	// Gone: dismissed, automatically or not
+	j.mu.Lock()
+	j.info.Status = JobNull
+	j.mu.Unlock()
+	j.finish(nil)
+	return nil
+}
+
+// finish marks the job done. A nil err is replaced by the error the job
+// concluded with, if any.
+func (j *Job) finish(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.finished {
+		return
+	}
+
+	if err == nil {
+		if j.info.Error != "" {
+			err = &JobError{ID: j.id, Message: j.info.Error}
+		} else {
+			err = j.eventErr
+		}
+	}
+	j.err = err
+	j.finished = true
+	close(j.done)
+}
+
+// watch follows the job until it is done.
+func (j *Job) watch() {
+	defer j.sub.Unsubscribe()
+
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-j.done:
+			return
+		case event, ok := <-j.sub.C:
+			if !ok {
+				j.finish(j.qmp.closedErr())
+				return
+			}
+			if !j.handleEvent(event) {
+				continue
+			}
+		case <-ticker.C:
+		}
+		j.Refresh()
+	}
+}
+
+// handleEvent records an event concerning the job. It reports whether the
+// job should be refreshed.
+func (j *Job) handleEvent(event *Event) bool {
+	switch event.Name {
+	case "JOB_STATUS_CHANGE":
+		var p JobStatusChangeEvent
+		if event.Decode(&p) != nil || p.ID != j.id {
+			return false
+		}
+		j.mu.Lock()
+		j.info.Status = p.Status
+		j.mu.Unlock()
+		return p.Status == JobConcluded || p.Status == JobNull
+
+	case "BLOCK_JOB_COMPLETED", "BLOCK_JOB_CANCELLED":
+		var p BlockJobCompletedEvent
+		if event.Decode(&p) != nil || p.Device != j.id {
+			return false
+		}
+		j.mu.Lock()
+		if p.Error != "" {
+			j.eventErr = &JobError{ID: j.id, Message: p.Error}
+		} else if event.Name == "BLOCK_JOB_CANCELLED" {
+			j.eventErr = &JobError{ID: j.id, Message: "cancelled"}
+		}
+		j.mu.Unlock()
+	}
+	return false
+}
@@ -0,0 +1,172 @@
+package qemuctl
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeJobs keeps the job list served by query-jobs.
+type fakeJobs struct {
+	mu   sync.Mutex
+	jobs map[string]map[string]any
+}
+
+func handleJobs(fake *fakeQMP) *fakeJobs {
+	fj := &fakeJobs{jobs: make(map[string]map[string]any)}
+	fake.handle("blockdev-backup", func(args map[string]any) (any, *qmpError) {
+		fj.set(args["job-id"].(string), "running", "")
+		return map[string]any{}, nil
+	})
+	fake.handle("query-jobs", func(map[string]any) (any, *qmpError) {
+		fj.mu.Lock()
+		defer fj.mu.Unlock()
+		list := []any{}
+		for _, job := range fj.jobs {
+			list = append(list, job)
+		}
+		return list, nil
+	})
+	for _, cmd := range []string{"job-pause", "job-resume", "job-cancel", "job-finalize"} {
+		fake.handle(cmd, func(map[string]any) (any, *qmpError) {
+			return map[string]any{}, nil
+		})
+	}
+	fake.handle("job-dismiss", func(args map[string]any) (any, *qmpError) {
+		fj.mu.Lock()
+		defer fj.mu.Unlock()
+		delete(fj.jobs, args["id"].(string))
+		return map[string]any{}, nil
+	})
+	return fj
+}
+
+func (fj *fakeJobs) set(id, status, errMsg string) {
+	fj.mu.Lock()
+	defer fj.mu.Unlock()
+	job := map[string]any{
+		"id": id, "type": "backup", "status": status,
+		"current-progress": 512, "total-progress": 1024,
+	}
+	if errMsg != "" {
+		job["error"] = errMsg
+	}
+	fj.jobs[id] = job
+}
+
+func waitJob(t *testing.T, job *Job) error {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := job.Wait(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("timed out waiting for job")
+	}
+	return err
+}
+
+func TestJobLifecycle(t *testing.T) {
+	fake := newFakeQMP(t)
+	fj := handleJobs(fake)
+	inst := fake.attach()
+
+	job, err := inst.StartJob("blockdev-backup", map[string]any{"device": "disk0", "target": "backup0"})
+	if err != nil {
+		t.Fatalf("StartJob failed: %v", err)
+	}
+	if job.Type() != "backup" || job.Status() != JobRunning {
+		t.Errorf("job = %+v, want running backup", job.Info())
+	}
+	if cur, total := job.Progress(); cur != 512 || total != 1024 {
+		t.Errorf("Progress() = %d/%d, want 512/1024", cur, total)
+	}
+
+	if err := job.Pause(); err != nil {
+		t.Fatalf("Pause failed: %v", err)
+	}
+	if args := fake.commands("job-pause"); len(args) != 1 || args[0]["id"] != job.ID() {
+		t.Errorf("job-pause calls = %v", args)
+	}
+
+	fj.set(job.ID(), "concluded", "")
+	fake.sendEvent("JOB_STATUS_CHANGE", map[string]any{"id": job.ID(), "status": "concluded"})
+	if err := waitJob(t, job); err != nil {
+		t.Errorf("Wait = %v, want nil", err)
+	}
+
+	if err := job.Dismiss(); err != nil {
+		t.Fatalf("Dismiss failed: %v", err)
+	}
+	if job.Status() != JobNull {
+		t.Errorf("status after Dismiss = %q, want null", job.Status())
+	}
+	if jobs, _ := inst.Jobs(); len(jobs) != 0 {
+		t.Errorf("Jobs() = %v, want none", jobs)
+	}
+}
+
+func TestJobFailure(t *testing.T) {
+	fake := newFakeQMP(t)
+	fj := handleJobs(fake)
+	inst := fake.attach()
+
+	job, err := inst.StartJob("blockdev-backup", map[string]any{"job-id": "bk0"})
+	if err != nil {
+		t.Fatalf("StartJob failed: %v", err)
+	}
+	if job.ID() != "bk0" {
+		t.Errorf("ID() = %q, want bk0", job.ID())
+	}
+
+	fj.set("bk0", "concluded", "No space left on device")
+	fake.sendEvent("JOB_STATUS_CHANGE", map[string]any{"id": "bk0", "status": "concluded"})
+
+	var jobErr *JobError
+	if err := waitJob(t, job); !errors.As(err, &jobErr) || jobErr.Message != "No space left on device" {
+		t.Errorf("Wait = %v, want JobError", err)
+	}
+}
+
+func TestJobAutoDismissed(t *testing.T) {
+	fake := newFakeQMP(t)
+	fj := handleJobs(fake)
+	inst := fake.attach()
+
+	job, err := inst.StartJob("blockdev-backup", nil)
+	if err != nil {
+		t.Fatalf("StartJob failed: %v", err)
+	}
+
+	// The job fails and disappears before query-jobs sees it conclude
+	fake.sendEvent("BLOCK_JOB_COMPLETED", map[string]any{"device": job.ID(), "type": "backup", "error": "I/O error"})
+	fj.mu.Lock()
+	delete(fj.jobs, job.ID())
+	fj.mu.Unlock()
+	fake.sendEvent("JOB_STATUS_CHANGE", map[string]any{"id": job.ID(), "status": "null"})
+
+	var jobErr *JobError
+	if err := waitJob(t, job); !errors.As(err, &jobErr) || jobErr.Message != "I/O error" {
+		t.Errorf("Wait = %v, want JobError", err)
+	}
+}
+
+func TestTrackJob(t *testing.T) {
+	fake := newFakeQMP(t)
+	fj := handleJobs(fake)
+	inst := fake.attach()
+
+	if _, err := inst.TrackJob("missing"); err == nil {
+		t.Error("TrackJob of a missing job succeeded")
+	}
+
+	fj.set("existing", "running", "")
+	job, err := inst.TrackJob("existing")
+	if err != nil {
+		t.Fatalf("TrackJob failed: %v", err)
+	}
+	if job.Status() != JobRunning {
+		t.Errorf("status = %q, want running", job.Status())
+	}
+}
@@ -0,0 +1,1393 @@
+package qemuctl
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// libvirtDomain is the subset of the libvirt domain XML schema understood
+// by the importer.
+type libvirtDomain struct {
+	XMLName  xml.Name         `xml:"domain"`
+	Type     string           `xml:"type,attr"`
+	Name     string           `xml:"name"`
+	UUID     string           `xml:"uuid,omitempty"`
+	Memory   libvirtMemory    `xml:"memory"`
+	VCPU     int              `xml:"vcpu"`
+	OS       libvirtOS        `xml:"os"`
+	Features *libvirtFeatures `xml:"features,omitempty"`
+	CPU      *libvirtCPU      `xml:"cpu,omitempty"`
+	Clock    *libvirtClock    `xml:"clock,omitempty"`
+	Devices  libvirtDevices   `xml:"devices"`
+}
+
+type libvirtFeatures struct {
+	SMM *libvirtSMM `xml:"smm,omitempty"`
+}
+
+type libvirtSMM struct {
+	State string `xml:"state,attr,omitempty"`
+}
+
+type libvirtMemory struct {
+	Unit  string `xml:"unit,attr,omitempty"`
+	Value uint64 `xml:",chardata"`
+}
+
+type libvirtOS struct {
+	Type    libvirtOSType  `xml:"type"`
+	Loader  *libvirtLoader `xml:"loader,omitempty"`
+	NVRAM   *libvirtNVRAM  `xml:"nvram,omitempty"`
+	Kernel  string         `xml:"kernel,omitempty"`
+	Initrd  string         `xml:"initrd,omitempty"`
+	Cmdline string         `xml:"cmdline,omitempty"`
+	Boot    []libvirtBoot  `xml:"boot"`
+	Menu    *libvirtMenu   `xml:"bootmenu,omitempty"`
+}
+
+type libvirtOSType struct {
+	Arch    string `xml:"arch,attr,omitempty"`
+	Machine string `xml:"machine,attr,omitempty"`
+	Value   string `xml:",chardata"`
+}
+
+type libvirtLoader struct {
+	ReadOnly string `xml:"readonly,attr,omitempty"`
+	Secure   string `xml:"secure,attr,omitempty"`
+	Type     string `xml:"type,attr,omitempty"`
+	Path     string `xml:",chardata"`
+}
+
+type libvirtNVRAM struct {
+	Template string `xml:"template,attr,omitempty"`
+	Path     string `xml:",chardata"`
+}
+
+type libvirtBoot struct {
+	Dev string `xml:"dev,attr"`
+}
+
+type libvirtMenu struct {
+	Enable string `xml:"enable,attr"`
+}
+
+type libvirtCPU struct {
+	Mode     string              `xml:"mode,attr,omitempty"`
+	Model    string              `xml:"model,omitempty"`
+	Topology *libvirtTopology    `xml:"topology,omitempty"`
+	Features []libvirtCPUFeature `xml:"feature"`
+}
+
+type libvirtTopology struct {
+	Sockets int `xml:"sockets,attr"`
+	Cores   int `xml:"cores,attr"`
+	Threads int `xml:"threads,attr"`
+}
+
+type libvirtCPUFeature struct {
+	Policy string `xml:"policy,attr"`
+	Name   string `xml:"name,attr"`
+}
+
+type libvirtClock struct {
+	Offset string `xml:"offset,attr"`
+}
+
+type libvirtDevices struct {
+	Emulator    string              `xml:"emulator,omitempty"`
+	Disks       []libvirtDisk       `xml:"disk"`
+	Controllers []libvirtController `xml:"controller"`
+	Interfaces  []libvirtInterface  `xml:"interface"`
+	Serials     []libvirtSerial     `xml:"serial"`
+	Channels    []libvirtChannel    `xml:"channel"`
+	Inputs      []libvirtInput      `xml:"input"`
+	RedirDevs   []libvirtRedirDev   `xml:"redirdev"`
+	Graphics    []libvirtGraphics   `xml:"graphics"`
+	Videos      []libvirtVideo      `xml:"video"`
+	MemBalloon  *libvirtMemBalloon  `xml:"memballoon,omitempty"`
+}
+
+type libvirtDisk struct {
+	Type     string             `xml:"type,attr"`
+	Device   string             `xml:"device,attr"`
+	Driver   *libvirtDiskDriver `xml:"driver,omitempty"`
+	Source   *libvirtDiskSource `xml:"source,omitempty"`
+	Target   libvirtDiskTarget  `xml:"target"`
+	ReadOnly *struct{}          `xml:"readonly,omitempty"`
+	Serial   string             `xml:"serial,omitempty"`
+	Boot     *libvirtBootOrder  `xml:"boot,omitempty"`
+	Address  *libvirtAddress    `xml:"address,omitempty"`
+}
+
+// libvirtAddress is a drive address on a disk controller.
+type libvirtAddress struct {
+	Type       string `xml:"type,attr"`
+	Controller int    `xml:"controller,attr"`
+	Bus        int    `xml:"bus,attr"`
+	Target     int    `xml:"target,attr"`
+	Unit       int    `xml:"unit,attr"`
+}
+
+type libvirtController struct {
+	Type   string                   `xml:"type,attr"`
+	Index  int                      `xml:"index,attr"`
+	Model  string                   `xml:"model,attr,omitempty"`
+	Driver *libvirtControllerDriver `xml:"driver,omitempty"`
+}
+
+type libvirtControllerDriver struct {
+	Queues int `xml:"queues,attr,omitempty"`
+}
+
+type libvirtDiskDriver struct {
+	Name    string `xml:"name,attr,omitempty"`
+	Type    string `xml:"type,attr,omitempty"`
+	Cache   string `xml:"cache,attr,omitempty"`
+	Discard string `xml:"discard,attr,omitempty"`
+
+	ErrorPolicy  string `xml:"error_policy,attr,omitempty"`
+	RErrorPolicy string `xml:"rerror_policy,attr,omitempty"`
+	IO           string `xml:"io,attr,omitempty"`
+	DetectZeroes string `xml:"detect_zeroes,attr,omitempty"`
+}
+
+type libvirtDiskSource struct {
+	File     string              `xml:"file,attr,omitempty"`
+	Dev      string              `xml:"dev,attr,omitempty"`
+	Protocol string              `xml:"protocol,attr,omitempty"`
+	Name     string              `xml:"name,attr,omitempty"`
+	Hosts    []libvirtSourceHost `xml:"host"`
+}
+
+type libvirtSourceHost struct {
+	Name      string `xml:"name,attr,omitempty"`
+	Port      string `xml:"port,attr,omitempty"`
+	Transport string `xml:"transport,attr,omitempty"`
+	Socket    string `xml:"socket,attr,omitempty"`
+}
+
+type libvirtDiskTarget struct {
+	Dev string `xml:"dev,attr"`
+	Bus string `xml:"bus,attr,omitempty"`
+}
+
+type libvirtBootOrder struct {
+	Order int `xml:"order,attr"`
+}
+
+type libvirtInterface struct {
+	Type    string                   `xml:"type,attr"`
+	MAC     *libvirtMAC              `xml:"mac,omitempty"`
+	Source  *libvirtInterfaceSource  `xml:"source,omitempty"`
+	Backend *libvirtInterfaceBackend `xml:"backend,omitempty"`
+	Target  *libvirtInterfaceTarget  `xml:"target,omitempty"`
+	Model   *libvirtModel            `xml:"model,omitempty"`
+	Driver  *libvirtInterfaceDriver  `xml:"driver,omitempty"`
+	MTU     *libvirtMTU              `xml:"mtu,omitempty"`
+	Boot    *libvirtBootOrder        `xml:"boot,omitempty"`
+}
+
+type libvirtInterfaceDriver struct {
+	RxQueueSize int                 `xml:"rx_queue_size,attr,omitempty"`
+	TxQueueSize int                 `xml:"tx_queue_size,attr,omitempty"`
+	Host        *libvirtNetOffloads `xml:"host,omitempty"`
+	Guest       *libvirtNetOffloads `xml:"guest,omitempty"`
+}
+
+type libvirtNetOffloads struct {
+	CSum string `xml:"csum,attr,omitempty"`
+	GSO  string `xml:"gso,attr,omitempty"`
+	TSO4 string `xml:"tso4,attr,omitempty"`
+	TSO6 string `xml:"tso6,attr,omitempty"`
+}
+
+type libvirtMTU struct {
+	Size int `xml:"size,attr"`
+}
+
+type libvirtMAC struct {
+	Address string `xml:"address,attr"`
+}
+
+type libvirtInterfaceSource struct {
+	Network string `xml:"network,attr,omitempty"`
+	Bridge  string `xml:"bridge,attr,omitempty"`
+}
+
+// libvirtInterfaceBackend selects the user networking implementation,
+// "passt" or the built-in one if absent.
+type libvirtInterfaceBackend struct {
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type libvirtInterfaceTarget struct {
+	Dev string `xml:"dev,attr"`
+}
+
+type libvirtModel struct {
+	Type string `xml:"type,attr"`
+}
+
+type libvirtSerial struct {
+	Type   string                `xml:"type,attr"`
+	Source *libvirtChannelSource `xml:"source,omitempty"`
+}
+
+type libvirtChannel struct {
+	Type   string                `xml:"type,attr"`
+	Source *libvirtChannelSource `xml:"source,omitempty"`
+	Target libvirtChannelTarget  `xml:"target"`
+}
+
+type libvirtChannelSource struct {
+	Mode      string            `xml:"mode,attr,omitempty"`
+	Path      string            `xml:"path,attr,omitempty"`
+	Clipboard *libvirtClipboard `xml:"clipboard,omitempty"`
+}
+
+type libvirtClipboard struct {
+	CopyPaste string `xml:"copypaste,attr"`
+}
+
+type libvirtChannelTarget struct {
+	Type string `xml:"type,attr"`
+	Name string `xml:"name,attr,omitempty"`
+}
+
+type libvirtInput struct {
+	Type string `xml:"type,attr"`
+	Bus  string `xml:"bus,attr,omitempty"`
+}
+
+type libvirtRedirDev struct {
+	Bus  string `xml:"bus,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type libvirtGraphics struct {
+	Type     string                  `xml:"type,attr"`
+	Port     int                     `xml:"port,attr,omitempty"`
+	AutoPort string                  `xml:"autoport,attr,omitempty"`
+	Listen   string                  `xml:"listen,attr,omitempty"`
+	Passwd   string                  `xml:"passwd,attr,omitempty"`
+	Listens  []libvirtGraphicsListen `xml:"listen"`
+}
+
+type libvirtGraphicsListen struct {
+	Type    string `xml:"type,attr"`
+	Address string `xml:"address,attr,omitempty"`
+	Socket  string `xml:"socket,attr,omitempty"`
+}
+
+type libvirtVideo struct {
+	Model libvirtVideoModel `xml:"model"`
+}
+
+type libvirtVideoModel struct {
+	Type  string `xml:"type,attr"`
+	VRAM  int    `xml:"vram,attr,omitempty"`
+	Heads int    `xml:"heads,attr,omitempty"`
+}
+
+type libvirtMemBalloon struct {
+	Model string `xml:"model,attr"`
+}
+
+// ParseLibvirtXML converts a libvirt domain XML definition into a VMConfig.
+// Machine, CPU, memory, firmware, boot, disks, network interfaces, graphics,
+// video, guest agent channels and the memory balloon are translated; other
+// elements are ignored.
+func ParseLibvirtXML(data []byte) (*VMConfig, error) {
+	var dom libvirtDomain
+	if err := xml.Unmarshal(data, &dom); err != nil {
+		return nil, fmt.Errorf("failed to parse libvirt domain XML: %w", err)
+	}
+
+	cfg := &VMConfig{
+		Name:       dom.Name,
+		UUID:       dom.UUID,
+		NoDefaults: true,
+	}
+
+	if dom.OS.Type.Arch != "" {
+		cfg.Arch = goarchFromQemu(dom.OS.Type.Arch)
+		if cfg.Arch == "" {
+			return nil, &UnsupportedArchError{Arch: dom.OS.Type.Arch}
+		}
+	}
+	if dom.Devices.Emulator != "" {
+		cfg.QemuPath = dom.Devices.Emulator
+	}
+
+	// Machine
+	cfg.Machine = &MachineConfig{Type: dom.OS.Type.Machine}
+	switch dom.Type {
+	case "kvm":
+		cfg.Machine.Accel = "kvm"
+	case "qemu":
+		cfg.Machine.Accel = "tcg"
+	case "hvf":
+		cfg.Machine.Accel = "hvf"
+	}
+
+	// Memory
+	mem, err := libvirtMemoryMB(dom.Memory)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Memory = &MemoryConfig{Size: mem}
+
+	// CPU
+	cfg.CPU = libvirtCPUConfig(dom.CPU, dom.VCPU)
+
+	// Firmware
+	if dom.OS.Loader != nil && dom.OS.Loader.Path != "" {
+		cfg.EFI = &EFIConfig{Code: strings.TrimSpace(dom.OS.Loader.Path), SecureBoot: dom.OS.Loader.Secure == "yes"}
+		cfg.Machine.Pflash0 = "pflash0"
+		if dom.OS.NVRAM != nil {
+			cfg.EFI.Vars = strings.TrimSpace(dom.OS.NVRAM.Path)
+			cfg.EFI.VarsTemplate = dom.OS.NVRAM.Template
+			if cfg.EFI.Vars != "" {
+				cfg.Machine.Pflash1 = "pflash1"
+			}
+		}
+	}
+
+	// Boot
+	cfg.Boot = libvirtBootConfig(&dom.OS)
+
+	// Clock
+	if dom.Clock != nil {
+		switch dom.Clock.Offset {
+		case "utc", "localtime":
+			cfg.RTC = &RTCConfig{Base: dom.Clock.Offset, DriftFix: "slew"}
+		}
+	}
+
+	// SCSI controllers
+	for _, c := range dom.Devices.Controllers {
+		if c.Type != "scsi" || c.Model != "virtio-scsi" {
+			continue
+		}
+		ctrl := &SCSIControllerConfig{ID: libvirtSCSIControllerID(c.Index)}
+		if c.Driver != nil {
+			ctrl.NumQueues = c.Driver.Queues
+		}
+		cfg.SCSIControllers = append(cfg.SCSIControllers, ctrl)
+	}
+
+	// Disks and CD-ROMs
+	for _, d := range dom.Devices.Disks {
+		switch d.Device {
+		case "cdrom":
+			cdrom := &CDROMConfig{}
+			if d.Source != nil {
+				cdrom.Path = d.Source.File
+			}
+			if d.Boot != nil {
+				cdrom.BootIndex = d.Boot.Order
+			}
+			cfg.CDROMs = append(cfg.CDROMs, cdrom)
+		case "disk", "":
+			disk, err := libvirtDiskConfig(&d, len(cfg.Disks))
+			if err != nil {
+				return nil, err
+			}
+			if a := d.Address; a != nil && a.Type == "drive" && disk.Interface == "scsi" {
+				disk.SCSI = &SCSIAddress{Target: a.Target, LUN: a.Unit}
+				if a.Controller != 0 {
+					disk.SCSI.Controller = libvirtSCSIControllerID(a.Controller)
+				}
+			}
+			cfg.Disks = append(cfg.Disks, disk)
+		}
+	}
+
+	// Network interfaces
+	for idx, iface := range dom.Devices.Interfaces {
+		network, err := libvirtNetworkConfig(&iface, idx)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Networks = append(cfg.Networks, network)
+	}
+
+	// Graphics and video
+	cfg.Display = libvirtDisplayConfig(dom.Devices.Graphics, dom.Devices.Videos)
+
+	// Serial ports
+	for _, s := range dom.Devices.Serials {
+		serial := &SerialConfig{Type: s.Type}
+		if s.Source != nil {
+			serial.Path = s.Source.Path
+			serial.Server = s.Source.Mode == "bind"
+		}
+		cfg.Serials = append(cfg.Serials, serial)
+	}
+
+	// Channels
+	for _, ch := range dom.Devices.Channels {
+		switch {
+		case ch.Type == "unix" && ch.Target.Name == "org.qemu.guest_agent.0" && ch.Source != nil:
+			cfg.WithGuestAgent(ch.Source.Path)
+		case ch.Type == "unix" && ch.Target.Name == spiceAgentChannel && ch.Source != nil:
+			cfg.WithClipboard(ch.Source.Path)
+		case ch.Type == "spicevmc":
+			cfg.WithSpiceAgent()
+		case ch.Type == "qemu-vdagent":
+			cfg.WithQemuVDAgent()
+			if ch.Source == nil || ch.Source.Clipboard == nil || ch.Source.Clipboard.CopyPaste != "yes" {
+				cfg.Chardevs[len(cfg.Chardevs)-1].Clipboard = false
+			}
+		}
+	}
+
+	// Input devices
+	for _, in := range dom.Devices.Inputs {
+		if in.Type == "tablet" && in.Bus == "usb" {
+			cfg.WithUSBTablet()
+		}
+	}
+
+	// USB redirection
+	for _, r := range dom.Devices.RedirDevs {
+		if r.Bus == "usb" && r.Type == "spicevmc" {
+			if cfg.USBRedir == nil {
+				cfg.USBRedir = &USBRedirConfig{}
+			}
+			cfg.USBRedir.Channels++
+		}
+	}
+
+	// Memory balloon
+	if dom.Devices.MemBalloon != nil && dom.Devices.MemBalloon.Model == "virtio" {
+		cfg.Balloon = &BalloonConfig{Enabled: true}
+	}
+
+	return cfg, nil
+}
+
+// LoadLibvirtXML reads a libvirt domain XML file and converts it into a VMConfig.
+func LoadLibvirtXML(path string) (*VMConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read libvirt domain XML: %w", err)
+	}
+	return ParseLibvirtXML(data)
+}
+
+// goarchFromQemu converts a QEMU architecture name to a GOARCH value.
+func goarchFromQemu(qemuArch string) string {
+	switch qemuArch {
+	case "x86_64":
+		return "amd64"
+	case "i686", "i386":
+		return "386"
+	case "aarch64":
+		return "arm64"
+	case "armv7l", "arm":
+		return "arm"
+	case "ppc64":
+		return "ppc64"
+	case "ppc64le":
+		return "ppc64le"
+	}
+	for goarch, name := range archToQemu {
+		if name == qemuArch {
+			return goarch
+		}
+	}
+	return ""
+}
+
+// libvirtMemoryMB converts a libvirt memory element to megabytes.
+func libvirtMemoryMB(m libvirtMemory) (int, error) {
+	var bytes uint64
+	switch strings.ToLower(m.Unit) {
+	case "b", "bytes":
+		bytes = m.Value
+	case "k", "kib", "":
+		bytes = m.Value << 10
+	case "kb":
+		bytes = m.Value * 1000
+	case "m", "mib":
+		bytes = m.Value << 20
+	case "mb":
+		bytes = m.Value * 1000 * 1000
+	case "g", "gib":
+		bytes = m.Value << 30
+	case "gb":
+		bytes = m.Value * 1000 * 1000 * 1000
+	default:
+		return 0, fmt.Errorf("unsupported memory unit %q", m.Unit)
+	}
+	return int(bytes >> 20), nil
+}
+
+// libvirtCPUConfig converts the cpu and vcpu elements to a CPUConfig.
+func libvirtCPUConfig(cpu *libvirtCPU, vcpus int) *CPUConfig {
+	cfg := &CPUConfig{Model: "host", Sockets: 1, Cores: 1, Threads: 1}
+	if vcpus > 1 {
+		cfg.Cores = vcpus
+	}
+
+	if cpu == nil {
+		return cfg
+	}
+
+	switch cpu.Mode {
+	case "custom":
+		if cpu.Model != "" {
+			cfg.Model = cpu.Model
+		}
+	case "maximum":
+		cfg.Model = "max"
+	}
+
+	if cpu.Topology != nil && cpu.Topology.Sockets > 0 && cpu.Topology.Cores > 0 && cpu.Topology.Threads > 0 {
+		cfg.Sockets = cpu.Topology.Sockets
+		cfg.Cores = cpu.Topology.Cores
+		cfg.Threads = cpu.Topology.Threads
+	}
+
+	for _, f := range cpu.Features {
+		switch f.Policy {
+		case "require", "force":
+			cfg.Features = append(cfg.Features, "+"+f.Name)
+		case "disable", "forbid":
+			cfg.Features = append(cfg.Features, "-"+f.Name)
+		}
+	}
+
+	return cfg
+}
+
+// libvirtBootConfig converts the os boot elements to a BootConfig.
+func libvirtBootConfig(o *libvirtOS) *BootConfig {
+	boot := &BootConfig{
+		Kernel: o.Kernel,
+		Initrd: o.Initrd,
+		Append: o.Cmdline,
+	}
+
+	for _, b := range o.Boot {
+		switch b.Dev {
+		case "hd":
+			boot.Order += "c"
+		case "cdrom":
+			boot.Order += "d"
+		case "network":
+			boot.Order += "n"
+		case "fd":
+			boot.Order += "a"
+		}
+	}
+
+	if o.Menu != nil {
+		menu := o.Menu.Enable == "yes"
+		boot.Menu = &menu
+	}
+
+	if boot.Order == "" && boot.Menu == nil && boot.Kernel == "" {
+		return nil
+	}
+	return boot
+}
+
+// libvirtDiskConfig converts a disk element to a DiskConfig.
+func libvirtDiskConfig(d *libvirtDisk, index int) (*DiskConfig, error) {
+	disk := &DiskConfig{
+		ID:       fmt.Sprintf("disk%d", index),
+		ReadOnly: d.ReadOnly != nil,
+		Serial:   d.Serial,
+	}
+
+	switch d.Target.Bus {
+	case "virtio", "":
+		disk.Interface = "virtio"
+	case "sata", "ide":
+		disk.Interface = "ide"
+	case "scsi":
+		disk.Interface = "scsi"
+	case "nvme":
+		disk.Interface = "nvme"
+	default:
+		return nil, fmt.Errorf("disk %s: unsupported bus %q", d.Target.Dev, d.Target.Bus)
+	}
+
+	format := "raw"
+	if d.Driver != nil {
+		if d.Driver.Type != "" {
+			format = d.Driver.Type
+		}
+		disk.Cache = d.Driver.Cache
+		disk.Discard = d.Driver.Discard
+		disk.WriteError = diskErrorFromLibvirt(d.Driver.ErrorPolicy)
+		disk.ReadError = diskErrorFromLibvirt(d.Driver.RErrorPolicy)
+		disk.AIO = d.Driver.IO
+		disk.DetectZeroes = d.Driver.DetectZeroes
+	}
+
+	if d.Boot != nil {
+		disk.BootIndex = d.Boot.Order
+	}
+
+	if d.Source == nil {
+		return nil, fmt.Errorf("disk %s: missing source", d.Target.Dev)
+	}
+
+	switch d.Type {
+	case "file":
+		disk.Backend = &FileDiskBackend{Path: d.Source.File, Format: format}
+	case "block":
+		disk.Backend = &FileDiskBackend{Path: d.Source.Dev, Format: format}
+	case "network":
+		backend, err := libvirtNetworkDisk(d.Source)
+		if err != nil {
+			return nil, fmt.Errorf("disk %s: %w", d.Target.Dev, err)
+		}
+		disk.Backend = backend
+	default:
+		return nil, fmt.Errorf("disk %s: unsupported type %q", d.Target.Dev, d.Type)
+	}
+
+	return disk, nil
+}
+
+// libvirtNetworkDisk converts a network disk source to a DiskBackend.
+func libvirtNetworkDisk(src *libvirtDiskSource) (DiskBackend, error) {
+	var host libvirtSourceHost
+	if len(src.Hosts) > 0 {
+		host = src.Hosts[0]
+	}
+
+	switch src.Protocol {
+	case "nbd":
+		backend := &NBDDiskBackend{Export: src.Name}
+		if host.Transport == "unix" {
+			backend.SocketPath = host.Socket
+		} else {
+			backend.Host = host.Name
+			backend.Port, _ = strconv.Atoi(host.Port)
+		}
+		return backend, nil
+	case "rbd":
+		pool, image, ok := strings.Cut(src.Name, "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid rbd source name %q", src.Name)
+		}
+		return &RBDDiskBackend{Pool: pool, Image: image}, nil
+	case "iscsi":
+		target, lun, _ := strings.Cut(src.Name, "/")
+		backend := &ISCSIDiskBackend{Target: target, Portal: host.Name}
+		if host.Port != "" {
+			backend.Portal += ":" + host.Port
+		}
+		backend.Lun, _ = strconv.Atoi(lun)
+		return backend, nil
+	default:
+		return nil, fmt.Errorf("unsupported network protocol %q", src.Protocol)
+	}
+}
+
+// libvirtNetworkConfig converts an interface element to a NetworkConfig.
+func libvirtNetworkConfig(iface *libvirtInterface, index int) (*NetworkConfig, error) {
+	network := &NetworkConfig{
+		ID:    fmt.Sprintf("net%d", index),
+		Model: "virtio-net-pci",
+	}
+
+	if iface.MAC != nil {
+		network.MACAddr = iface.MAC.Address
+	}
+	if iface.Boot != nil {
+		network.BootIndex = iface.Boot.Order
+	}
+	if iface.Model != nil {
+		switch iface.Model.Type {
+		case "virtio":
+			network.Model = "virtio-net-pci"
+		default:
+			network.Model = iface.Model.Type
+		}
+	}
+	if iface.MTU != nil {
+		network.MTU = iface.MTU.Size
+	}
+	if d := iface.Driver; d != nil {
+		network.RxQueueSize = d.RxQueueSize
+		network.TxQueueSize = d.TxQueueSize
+		if h := d.Host; h != nil {
+			offloads := &NICOffloads{
+				Checksum: libvirtToggle(h.CSum),
+				GSO:      libvirtToggle(h.GSO),
+				TSO:      libvirtToggle(h.TSO4),
+			}
+			if offloads.Checksum != nil || offloads.GSO != nil || offloads.TSO != nil {
+				network.Offloads = offloads
+			}
+		}
+	}
+
+	switch iface.Type {
+	case "user":
+		if iface.Backend != nil && iface.Backend.Type == "passt" {
+			network.Backend = &PasstNetBackend{}
+		} else {
+			network.Backend = &UserNetBackend{}
+		}
+	case "bridge":
+		if iface.Source == nil || iface.Source.Bridge == "" {
+			return nil, fmt.Errorf("interface %d: bridge without source bridge", index)
+		}
+		network.Backend = &BridgeNetBackend{Bridge: iface.Source.Bridge}
+	case "network":
+		// Libvirt-managed networks are backed by a bridge; "default" is virbr0.
+		bridge := "virbr0"
+		if iface.Source != nil {
+			if iface.Source.Bridge != "" {
+				bridge = iface.Source.Bridge
+			} else if iface.Source.Network != "" && iface.Source.Network != "default" {
+				bridge = iface.Source.Network
+			}
+		}
+		network.Backend = &BridgeNetBackend{Bridge: bridge}
+	case "ethernet":
+		tap := &TapNetBackend{Script: "no", DownScript: "no"}
+		if iface.Target != nil {
+			tap.Ifname = iface.Target.Dev
+		}
+		network.Backend = tap
+	default:
+		return nil, fmt.Errorf("interface %d: unsupported type %q", index, iface.Type)
+	}
+
+	return network, nil
+}
+
+// libvirtDisplayConfig converts graphics and video elements to a DisplayConfig.
+func libvirtDisplayConfig(graphics []libvirtGraphics, videos []libvirtVideo) *DisplayConfig {
+	display := &DisplayConfig{Type: "none"}
+
+	if len(graphics) > 0 {
+		g := graphics[0]
+		listen := g.Listen
+		var socket string
+		for _, l := range g.Listens {
+			if l.Type == "address" && l.Address != "" {
+				listen = l.Address
+			}
+			if l.Type == "socket" {
+				socket = l.Socket
+			}
+		}
+
+		switch g.Type {
+		case "vnc":
+			vnc := &VNCConfig{Password: g.Passwd}
+			switch {
+			case socket != "":
+				vnc.Listen = "unix:" + socket
+			case g.Port >= 5900:
+				vnc.Listen = fmt.Sprintf("%s:%d", listen, g.Port-5900)
+			default:
+				if listen == "" {
+					listen = "127.0.0.1"
+				}
+				vnc.Listen = listen + ":0"
+			}
+			display.Type = "vnc"
+			display.VNC = vnc
+		case "spice":
+			spice := &SpiceDisplayConfig{Password: g.Passwd}
+			if g.Port > 0 {
+				spice.Port = g.Port
+			}
+			if socket != "" {
+				spice.Unix = true
+			}
+			if g.Passwd == "" {
+				spice.DisableTicketing = true
+			}
+			display.Type = "spice"
+			display.Spice = spice
+		}
+	}
+
+	if len(videos) > 0 {
+		switch videos[0].Model.Type {
+		case "qxl":
+			display.Video = &VideoConfig{Type: "qxl-vga", MaxOutputs: videos[0].Model.Heads}
+		case "virtio":
+			display.Video = &VideoConfig{Type: "virtio-vga"}
+		case "vga":
+			display.Video = &VideoConfig{Type: "vga"}
+		case "cirrus":
+			display.Video = &VideoConfig{Type: "cirrus-vga"}
+		case "bochs":
+			display.Video = &VideoConfig{Type: "bochs-display"}
+		}
+	}
+
+	return display
+}
+
+// ToLibvirtXML converts the configuration into a libvirt domain XML
+// definition suitable for "virsh define". Backends and devices without a
+// libvirt equivalent cause an error rather than being silently dropped.
+func (cfg *VMConfig) ToLibvirtXML() (string, error) {
+	dom := libvirtDomain{
+		Type: "kvm",
+		Name: cfg.Name,
+		UUID: cfg.UUID,
+	}
+
+	arch := cfg.Arch
+	if arch == "" {
+		arch = runtime.GOARCH
+	}
+	qemuArch, ok := archToQemu[arch]
+	if !ok {
+		return "", &UnsupportedArchError{Arch: arch}
+	}
+	if arch == "ppc64le" {
+		qemuArch = "ppc64le"
+	}
+	dom.OS.Type = libvirtOSType{Arch: qemuArch, Value: "hvm"}
+
+	if cfg.QemuPath != "" {
+		if info, err := os.Stat(cfg.QemuPath); err == nil && !info.IsDir() {
+			dom.Devices.Emulator = cfg.QemuPath
+		}
+	}
+
+	// Machine
+	if cfg.Machine != nil {
+		dom.OS.Type.Machine = cfg.Machine.Type
+		if cfg.Machine.Accel != "" && cfg.Machine.Accel != "kvm" {
+			dom.Type = "qemu"
+		}
+	} else if arch == "amd64" || arch == "386" {
+		dom.OS.Type.Machine = "q35"
+	}
+
+	// Memory
+	memSize := 512
+	if cfg.Memory != nil && cfg.Memory.Size > 0 {
+		memSize = cfg.Memory.Size
+	}
+	dom.Memory = libvirtMemory{Unit: "MiB", Value: uint64(memSize)}
+
+	// CPU
+	dom.VCPU = 1
+	dom.CPU = &libvirtCPU{Mode: "host-passthrough"}
+	if cfg.CPU != nil {
+		sockets, cores, threads := max(cfg.CPU.Sockets, 1), max(cfg.CPU.Cores, 1), max(cfg.CPU.Threads, 1)
+		dom.VCPU = sockets * cores * threads
+		dom.CPU.Topology = &libvirtTopology{Sockets: sockets, Cores: cores, Threads: threads}
+
+		switch cfg.CPU.Model {
+		case "host", "":
+		case "max":
+			dom.CPU.Mode = "maximum"
+		default:
+			dom.CPU.Mode = "custom"
+			dom.CPU.Model = cfg.CPU.Model
+		}
+
+		for _, f := range cfg.CPU.Features {
+			switch {
+			case strings.HasPrefix(f, "+"):
+				dom.CPU.Features = append(dom.CPU.Features, libvirtCPUFeature{Policy: "require", Name: f[1:]})
+			case strings.HasPrefix(f, "-"):
+				dom.CPU.Features = append(dom.CPU.Features, libvirtCPUFeature{Policy: "disable", Name: f[1:]})
+			}
+		}
+	}
+
+	// Firmware
+	if cfg.EFI != nil && cfg.EFI.Code != "" {
+		dom.OS.Loader = &libvirtLoader{ReadOnly: "yes", Type: "pflash", Path: cfg.EFI.Code}
+		if cfg.EFI.Vars != "" {
+			dom.OS.NVRAM = &libvirtNVRAM{Template: cfg.EFI.VarsTemplate, Path: cfg.EFI.Vars}
+		}
+		if cfg.EFI.SecureBoot {
+			dom.OS.Loader.Secure = "yes"
+			dom.Features = &libvirtFeatures{SMM: &libvirtSMM{State: "on"}}
+		}
+	}
+
+	// Clock
+	dom.Clock = &libvirtClock{Offset: "utc"}
+	if cfg.RTC != nil && cfg.RTC.Base == "localtime" {
+		dom.Clock.Offset = "localtime"
+	}
+
+	// SCSI controllers
+	scsiIndex := make(map[string]int)
+	for idx, c := range libvirtSCSIControllers(cfg) {
+		scsiIndex[c.ID] = idx
+		ctrl := libvirtController{Type: "scsi", Index: idx, Model: "virtio-scsi"}
+		if c.NumQueues > 0 {
+			ctrl.Driver = &libvirtControllerDriver{Queues: c.NumQueues}
+		}
+		dom.Devices.Controllers = append(dom.Devices.Controllers, ctrl)
+	}
+
+	// Disks
+	var targets libvirtTargets
+	for _, disk := range cfg.Disks {
+		d, err := libvirtDiskFromConfig(disk, &targets)
+		if err != nil {
+			return "", err
+		}
+		if disk.Interface == "scsi" && disk.SCSI != nil {
+			d.Address = &libvirtAddress{
+				Type:       "drive",
+				Controller: scsiIndex[disk.SCSI.controller()],
+				Target:     disk.SCSI.Target,
+				Unit:       disk.SCSI.LUN,
+			}
+		}
+		dom.Devices.Disks = append(dom.Devices.Disks, d)
+	}
+
+	// CD-ROMs
+	for _, cdrom := range cfg.CDROMs {
+		d := libvirtDisk{
+			Type:     "file",
+			Device:   "cdrom",
+			Driver:   &libvirtDiskDriver{Name: "qemu", Type: "raw"},
+			Target:   libvirtDiskTarget{Dev: targets.next("sd"), Bus: "sata"},
+			ReadOnly: &struct{}{},
+		}
+		if cdrom.Path != "" {
+			d.Source = &libvirtDiskSource{File: cdrom.Path}
+		}
+		if cdrom.BootIndex > 0 {
+			d.Boot = &libvirtBootOrder{Order: cdrom.BootIndex}
+		}
+		dom.Devices.Disks = append(dom.Devices.Disks, d)
+	}
+
+	// Network interfaces
+	for _, network := range cfg.Networks {
+		iface, err := libvirtInterfaceFromConfig(network)
+		if err != nil {
+			return "", err
+		}
+		dom.Devices.Interfaces = append(dom.Devices.Interfaces, iface)
+	}
+
+	// Boot: libvirt rejects <os><boot> mixed with per-device boot order
+	perDeviceBoot := false
+	for _, d := range dom.Devices.Disks {
+		perDeviceBoot = perDeviceBoot || d.Boot != nil
+	}
+	for _, iface := range dom.Devices.Interfaces {
+		perDeviceBoot = perDeviceBoot || iface.Boot != nil
+	}
+	if cfg.Boot != nil {
+		dom.OS.Kernel = cfg.Boot.Kernel
+		dom.OS.Initrd = cfg.Boot.Initrd
+		dom.OS.Cmdline = cfg.Boot.Append
+		if !perDeviceBoot {
+			for _, c := range cfg.Boot.Order {
+				switch c {
+				case 'c':
+					dom.OS.Boot = append(dom.OS.Boot, libvirtBoot{Dev: "hd"})
+				case 'd':
+					dom.OS.Boot = append(dom.OS.Boot, libvirtBoot{Dev: "cdrom"})
+				case 'n':
+					dom.OS.Boot = append(dom.OS.Boot, libvirtBoot{Dev: "network"})
+				case 'a':
+					dom.OS.Boot = append(dom.OS.Boot, libvirtBoot{Dev: "fd"})
+				}
+			}
+		}
+		if cfg.Boot.Menu != nil {
+			dom.OS.Menu = &libvirtMenu{Enable: "no"}
+			if *cfg.Boot.Menu {
+				dom.OS.Menu.Enable = "yes"
+			}
+		}
+	}
+
+	// Serial ports
+	for _, serial := range cfg.Serials {
+		s := libvirtSerial{Type: serial.Type}
+		switch serial.Type {
+		case "socket":
+			mode := "connect"
+			if serial.Server {
+				mode = "bind"
+			}
+			s.Type = "unix"
+			s.Source = &libvirtChannelSource{Mode: mode, Path: serial.Path}
+		case "file", "pipe", "dev":
+			s.Source = &libvirtChannelSource{Path: serial.Path}
+		}
+		dom.Devices.Serials = append(dom.Devices.Serials, s)
+	}
+
+	// Channels (virtio-serial ports)
+	if cfg.VirtioSerial != nil {
+		chardevs := make(map[string]*ChardevConfig)
+		for _, ch := range cfg.Chardevs {
+			chardevs[ch.ID] = ch
+		}
+		for _, port := range cfg.VirtioSerial.Ports {
+			ch, ok := chardevs[port.Chardev]
+			if !ok {
+				continue
+			}
+			target := libvirtChannelTarget{Type: "virtio", Name: port.Name}
+			switch ch.Backend {
+			case "socket":
+				mode := "connect"
+				if ch.Server {
+					mode = "bind"
+				}
+				dom.Devices.Channels = append(dom.Devices.Channels, libvirtChannel{
+					Type:   "unix",
+					Source: &libvirtChannelSource{Mode: mode, Path: ch.Path},
+					Target: target,
+				})
+			case "spicevmc":
+				dom.Devices.Channels = append(dom.Devices.Channels, libvirtChannel{
+					Type:   "spicevmc",
+					Target: target,
+				})
+			case ChardevQemuVDAgent:
+				lch := libvirtChannel{Type: ChardevQemuVDAgent, Target: target}
+				if ch.Clipboard {
+					lch.Source = &libvirtChannelSource{Clipboard: &libvirtClipboard{CopyPaste: "yes"}}
+				}
+				dom.Devices.Channels = append(dom.Devices.Channels, lch)
+			}
+		}
+	}
+
+	// Input devices
+	for _, dev := range cfg.USBDevices {
+		switch dev.Type {
+		case "usb-tablet":
+			dom.Devices.Inputs = append(dom.Devices.Inputs, libvirtInput{Type: "tablet", Bus: "usb"})
+		case "usb-mouse":
+			dom.Devices.Inputs = append(dom.Devices.Inputs, libvirtInput{Type: "mouse", Bus: "usb"})
+		case "usb-kbd":
+			dom.Devices.Inputs = append(dom.Devices.Inputs, libvirtInput{Type: "keyboard", Bus: "usb"})
+		}
+	}
+	if cfg.USBRedir != nil {
+		for i := 0; i < cfg.USBRedir.channels(); i++ {
+			dom.Devices.RedirDevs = append(dom.Devices.RedirDevs, libvirtRedirDev{Bus: "usb", Type: "spicevmc"})
+		}
+	}
+
+	// Graphics and video
+	if cfg.Display != nil {
+		g, err := libvirtGraphicsFromConfig(cfg.Display)
+		if err != nil {
+			return "", err
+		}
+		if g != nil {
+			dom.Devices.Graphics = append(dom.Devices.Graphics, *g)
+		}
+
+		if cfg.Display.Video != nil {
+			model := libvirtVideoModel{Heads: cfg.Display.Video.MaxOutputs}
+			switch cfg.Display.Video.Type {
+			case "qxl-vga":
+				model.Type = "qxl"
+			case "virtio-vga", "virtio-gpu-pci":
+				model.Type = "virtio"
+			case "vga":
+				model.Type = "vga"
+			case "cirrus-vga":
+				model.Type = "cirrus"
+			case "bochs-display":
+				model.Type = "bochs"
+			default:
+				return "", fmt.Errorf("video device %q has no libvirt equivalent", cfg.Display.Video.Type)
+			}
+			dom.Devices.Videos = append(dom.Devices.Videos, libvirtVideo{Model: model})
+		}
+	}
+
+	// Memory balloon
+	dom.Devices.MemBalloon = &libvirtMemBalloon{Model: "none"}
+	if cfg.Balloon != nil && cfg.Balloon.Enabled {
+		dom.Devices.MemBalloon.Model = "virtio"
+	}
+
+	data, err := xml.MarshalIndent(&dom, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal libvirt domain XML: %w", err)
+	}
+	return string(data) + "\n", nil
+}
+
+// libvirtDiskName returns the guest device name (vda, vdb, ...) for index.
+func libvirtDiskName(prefix string, index int) string {
+	name := ""
+	for n := index; ; n = n/26 - 1 {
+		name = string(rune('a'+n%26)) + name
+		if n < 26 {
+			break
+		}
+	}
+	return prefix + name
+}
+
+// libvirtSCSIControllerID names the SCSI controller at a libvirt index.
+func libvirtSCSIControllerID(index int) string {
+	return "scsi" + strconv.Itoa(index)
+}
+
+// libvirtSCSIControllers orders the SCSI controllers by libvirt index, the
+// default controller first since libvirt puts disks without an address on
+// controller 0.
+func libvirtSCSIControllers(cfg *VMConfig) []*SCSIControllerConfig {
+	var ordered []*SCSIControllerConfig
+	for _, c := range cfg.scsiControllers() {
+		if c.ID == defaultSCSIController {
+			ordered = append([]*SCSIControllerConfig{c}, ordered...)
+		} else {
+			ordered = append(ordered, c)
+		}
+	}
+	return ordered
+}
+
+// libvirtErrorPolicy converts a disk error action to libvirt's name, which
+// spells out "enospace".
+func libvirtErrorPolicy(action string) string {
+	if action == DiskErrorENOSPC {
+		return "enospace"
+	}
+	return action
+}
+
+// diskErrorFromLibvirt converts a libvirt error policy to a disk error
+// action.
+func diskErrorFromLibvirt(policy string) string {
+	if policy == "enospace" {
+		return DiskErrorENOSPC
+	}
+	return policy
+}
+
+// libvirtTargets allocates the guest device names of disks: vd* for
+// virtio disks, and sd* for ide and scsi disks and CD-ROMs, which share
+// the prefix.
+type libvirtTargets struct {
+	vd, sd int
+}
+
+// next returns the next free device name with prefix "vd" or "sd".
+func (t *libvirtTargets) next(prefix string) string {
+	n := &t.sd
+	if prefix == "vd" {
+		n = &t.vd
+	}
+	name := libvirtDiskName(prefix, *n)
+	*n++
+	return name
+}
+
+// libvirtDiskFromConfig converts a DiskConfig to a libvirt disk element,
+// named from targets.
+func libvirtDiskFromConfig(disk *DiskConfig, targets *libvirtTargets) (libvirtDisk, error) {
+	d := libvirtDisk{
+		Device: "disk",
+		Driver: &libvirtDiskDriver{
+			Name:         "qemu",
+			Type:         "raw",
+			Cache:        disk.Cache,
+			Discard:      disk.Discard,
+			ErrorPolicy:  libvirtErrorPolicy(disk.WriteError),
+			RErrorPolicy: libvirtErrorPolicy(disk.ReadError),
+			IO:           disk.AIO,
+			DetectZeroes: disk.DetectZeroes,
+		},
+		Serial: disk.Serial,
+	}
+
+	switch disk.Interface {
+	case "virtio", "":
+		d.Target = libvirtDiskTarget{Dev: targets.next("vd"), Bus: "virtio"}
+	case "ide":
+		d.Target = libvirtDiskTarget{Dev: targets.next("sd"), Bus: "sata"}
+	case "scsi":
+		d.Target = libvirtDiskTarget{Dev: targets.next("sd"), Bus: "scsi"}
+	default:
+		return d, fmt.Errorf("disk %s: interface %q has no libvirt equivalent", disk.ID, disk.Interface)
+	}
+
+	if disk.ReadOnly {
+		d.ReadOnly = &struct{}{}
+	}
+	if disk.BootIndex > 0 {
+		d.Boot = &libvirtBootOrder{Order: disk.BootIndex}
+	}
+
+	switch b := disk.Backend.(type) {
+	case *FileDiskBackend:
+		if b.Format != "" {
+			d.Driver.Type = b.Format
+		}
+		if strings.HasPrefix(b.Path, "/dev/") {
+			d.Type = "block"
+			d.Source = &libvirtDiskSource{Dev: b.Path}
+		} else {
+			d.Type = "file"
+			d.Source = &libvirtDiskSource{File: b.Path}
+		}
+	case *NBDDiskBackend:
+		d.Type = "network"
+		d.Source = &libvirtDiskSource{Protocol: "nbd", Name: b.Export}
+		if b.SocketPath != "" {
+			d.Source.Hosts = []libvirtSourceHost{{Transport: "unix", Socket: b.SocketPath}}
+		} else {
+			host := libvirtSourceHost{Name: b.Host}
+			if b.Port > 0 {
+				host.Port = strconv.Itoa(b.Port)
+			}
+			d.Source.Hosts = []libvirtSourceHost{host}
+		}
+	case *RBDDiskBackend:
+		d.Type = "network"
+		d.Source = &libvirtDiskSource{Protocol: "rbd", Name: b.Pool + "/" + b.Image}
+	case *ISCSIDiskBackend:
+		d.Type = "network"
+		d.Source = &libvirtDiskSource{Protocol: "iscsi", Name: fmt.Sprintf("%s/%d", b.Target, b.Lun)}
+		host, port, found := strings.Cut(b.Portal, ":")
+		h := libvirtSourceHost{Name: host}
+		if found {
+			h.Port = port
+		}
+		d.Source.Hosts = []libvirtSourceHost{h}
+	default:
+		return d, fmt.Errorf("disk %s: backend has no libvirt equivalent", disk.ID)
+	}
+
+	return d, nil
+}
+
+// libvirtInterfaceFromConfig converts a NetworkConfig to a libvirt interface element.
+func libvirtInterfaceFromConfig(network *NetworkConfig) (libvirtInterface, error) {
+	iface := libvirtInterface{}
+
+	if network.MACAddr != "" {
+		iface.MAC = &libvirtMAC{Address: network.MACAddr}
+	}
+	if network.BootIndex > 0 {
+		iface.Boot = &libvirtBootOrder{Order: network.BootIndex}
+	}
+
+	switch network.Model {
+	case "virtio-net-pci", "":
+		iface.Model = &libvirtModel{Type: "virtio"}
+	default:
+		iface.Model = &libvirtModel{Type: network.Model}
+	}
+	if network.MTU > 0 {
+		iface.MTU = &libvirtMTU{Size: network.MTU}
+	}
+	if network.RxQueueSize > 0 || network.TxQueueSize > 0 || network.Offloads != nil {
+		iface.Driver = &libvirtInterfaceDriver{RxQueueSize: network.RxQueueSize, TxQueueSize: network.TxQueueSize}
+		if o := network.Offloads; o != nil {
+			host := &libvirtNetOffloads{CSum: libvirtOnOff(o.Checksum), GSO: libvirtOnOff(o.GSO), TSO4: libvirtOnOff(o.TSO), TSO6: libvirtOnOff(o.TSO)}
+			guest := &libvirtNetOffloads{CSum: libvirtOnOff(o.Checksum), TSO4: libvirtOnOff(o.TSO), TSO6: libvirtOnOff(o.TSO)}
+			iface.Driver.Host, iface.Driver.Guest = host, guest
+		}
+	}
+
+	switch b := network.Backend.(type) {
+	case *UserNetBackend:
+		iface.Type = "user"
+	case *PasstNetBackend:
+		iface.Type = "user"
+		iface.Backend = &libvirtInterfaceBackend{Type: "passt"}
+	case *BridgeNetBackend:
+		iface.Type = "bridge"
+		iface.Source = &libvirtInterfaceSource{Bridge: b.Bridge}
+	case *TapNetBackend:
+		if b.Bridge != "" {
+			iface.Type = "bridge"
+			iface.Source = &libvirtInterfaceSource{Bridge: b.Bridge}
+		} else {
+			iface.Type = "ethernet"
+			if b.Ifname != "" {
+				iface.Target = &libvirtInterfaceTarget{Dev: b.Ifname}
+			}
+		}
+	default:
+		return iface, fmt.Errorf("network %s: backend has no libvirt equivalent", network.ID)
+	}
+
+	return iface, nil
+}
+
+// libvirtOnOff converts an optional toggle to a libvirt on/off attribute.
+func libvirtOnOff(v *bool) string {
+	switch {
+	case v == nil:
+		return ""
+	case *v:
+		return "on"
+	}
+	return "off"
+}
+
+// libvirtToggle converts a libvirt on/off attribute to an optional toggle.
+func libvirtToggle(s string) *bool {
+	if s == "" {
+		return nil
+	}
+	v := s == "on"
+	return &v
+}
+
+// libvirtGraphicsFromConfig converts a DisplayConfig to a libvirt graphics element.
+func libvirtGraphicsFromConfig(display *DisplayConfig) (*libvirtGraphics, error) {
+	switch display.Type {
+	case "vnc":
+		if display.VNC == nil || display.VNC.Listen == "" || display.VNC.Listen == "none" {
+			return nil, nil
+		}
+		g := &libvirtGraphics{Type: "vnc"}
+		listen := display.VNC.Listen
+		if path, ok := strings.CutPrefix(listen, "unix:"); ok {
+			g.Listens = []libvirtGraphicsListen{{Type: "socket", Socket: path}}
+			return g, nil
+		}
+		host, disp, ok := strings.Cut(listen, ":")
+		n, err := strconv.Atoi(disp)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("invalid VNC listen address %q", listen)
+		}
+		g.Port = 5900 + n
+		g.Listen = host
+		return g, nil
+	case "spice":
+		if display.Spice == nil {
+			return nil, nil
+		}
+		g := &libvirtGraphics{Type: "spice", Passwd: display.Spice.Password}
+		if display.Spice.Port > 0 {
+			g.Port = display.Spice.Port
+		} else {
+			g.AutoPort = "yes"
+		}
+		return g, nil
+	case "sdl", "gtk":
+		return &libvirtGraphics{Type: display.Type}, nil
+	}
+	return nil, nil
+}
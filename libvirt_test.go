@@ -0,0 +1,283 @@
+package qemuctl
+
+import (
+	"strings"
+	"testing"
+)
+
+const testLibvirtXML = `<domain type='kvm'>
+  <name>web01</name>
+  <uuid>6f1e2f7a-4a38-4c52-9a4b-6a3c1a5d2e11</uuid>
+  <memory unit='KiB'>2097152</memory>
+  <vcpu placement='static'>4</vcpu>
+  <os>
+    <type arch='x86_64' machine='pc-q35-8.2'>hvm</type>
+    <loader readonly='yes' type='pflash'>/usr/share/OVMF/OVMF_CODE.fd</loader>
+    <nvram template='/usr/share/OVMF/OVMF_VARS.fd'>/var/lib/libvirt/qemu/nvram/web01_VARS.fd</nvram>
+    <boot dev='hd'/>
+    <boot dev='cdrom'/>
+  </os>
+  <cpu mode='host-passthrough'>
+    <topology sockets='1' cores='2' threads='2'/>
+    <feature policy='disable' name='svm'/>
+  </cpu>
+  <clock offset='utc'/>
+  <devices>
+    <emulator>/usr/bin/qemu-system-x86_64</emulator>
+    <disk type='file' device='disk'>
+      <driver name='qemu' type='qcow2' cache='none' discard='unmap'/>
+      <source file='/var/lib/libvirt/images/web01.qcow2'/>
+      <target dev='vda' bus='virtio'/>
+      <serial>WEB01</serial>
+    </disk>
+    <disk type='network' device='disk'>
+      <driver name='qemu' type='raw'/>
+      <source protocol='rbd' name='vms/web01-data'>
+        <host name='ceph1' port='6789'/>
+      </source>
+      <target dev='vdb' bus='virtio'/>
+    </disk>
+    <disk type='file' device='cdrom'>
+      <source file='/isos/install.iso'/>
+      <target dev='sda' bus='sata'/>
+      <readonly/>
+    </disk>
+    <interface type='bridge'>
+      <mac address='52:54:00:aa:bb:cc'/>
+      <source bridge='br0'/>
+      <model type='virtio'/>
+    </interface>
+    <interface type='network'>
+      <source network='default'/>
+      <model type='e1000'/>
+    </interface>
+    <interface type='user'>
+      <backend type='passt'/>
+      <model type='virtio'/>
+    </interface>
+    <channel type='unix'>
+      <source mode='bind' path='/var/lib/libvirt/qemu/web01.agent'/>
+      <target type='virtio' name='org.qemu.guest_agent.0'/>
+    </channel>
+    <input type='tablet' bus='usb'/>
+    <graphics type='vnc' port='5901' listen='127.0.0.1'/>
+    <video>
+      <model type='virtio' heads='1'/>
+    </video>
+    <memballoon model='virtio'/>
+  </devices>
+</domain>`
+
+func TestParseLibvirtXML(t *testing.T) {
+	cfg, err := ParseLibvirtXML([]byte(testLibvirtXML))
+	if err != nil {
+		t.Fatalf("ParseLibvirtXML error: %v", err)
+	}
+
+	if cfg.Name != "web01" {
+		t.Errorf("expected name web01, got %q", cfg.Name)
+	}
+	if cfg.Arch != "amd64" {
+		t.Errorf("expected arch amd64, got %q", cfg.Arch)
+	}
+	if cfg.Machine.Type != "pc-q35-8.2" || cfg.Machine.Accel != "kvm" {
+		t.Errorf("unexpected machine: %+v", cfg.Machine)
+	}
+	if cfg.Memory.Size != 2048 {
+		t.Errorf("expected 2048MB memory, got %d", cfg.Memory.Size)
+	}
+	if cfg.CPU.Model != "host" || cfg.CPU.Cores != 2 || cfg.CPU.Threads != 2 {
+		t.Errorf("unexpected cpu: %+v", cfg.CPU)
+	}
+	if len(cfg.CPU.Features) != 1 || cfg.CPU.Features[0] != "-svm" {
+		t.Errorf("unexpected cpu features: %v", cfg.CPU.Features)
+	}
+	if cfg.EFI == nil || cfg.EFI.VarsTemplate != "/usr/share/OVMF/OVMF_VARS.fd" {
+		t.Errorf("unexpected efi: %+v", cfg.EFI)
+	}
+	if cfg.Boot == nil || cfg.Boot.Order != "cd" {
+		t.Errorf("unexpected boot: %+v", cfg.Boot)
+	}
+
+	if len(cfg.Disks) != 2 {
+		t.Fatalf("expected 2 disks, got %d", len(cfg.Disks))
+	}
+	file, ok := cfg.Disks[0].Backend.(*FileDiskBackend)
+	if !ok || file.Format != "qcow2" || cfg.Disks[0].Cache != "none" || cfg.Disks[0].Serial != "WEB01" {
+		t.Errorf("unexpected first disk: %+v", cfg.Disks[0])
+	}
+	rbd, ok := cfg.Disks[1].Backend.(*RBDDiskBackend)
+	if !ok || rbd.Pool != "vms" || rbd.Image != "web01-data" {
+		t.Errorf("unexpected rbd disk: %#v", cfg.Disks[1].Backend)
+	}
+	if len(cfg.CDROMs) != 1 || cfg.CDROMs[0].Path != "/isos/install.iso" {
+		t.Errorf("unexpected cdroms: %+v", cfg.CDROMs)
+	}
+
+	if len(cfg.Networks) != 3 {
+		t.Fatalf("expected 3 networks, got %d", len(cfg.Networks))
+	}
+	if br, ok := cfg.Networks[0].Backend.(*BridgeNetBackend); !ok || br.Bridge != "br0" {
+		t.Errorf("unexpected first network backend: %#v", cfg.Networks[0].Backend)
+	}
+	if cfg.Networks[0].MACAddr != "52:54:00:aa:bb:cc" {
+		t.Errorf("unexpected mac: %q", cfg.Networks[0].MACAddr)
+	}
+	if br, ok := cfg.Networks[1].Backend.(*BridgeNetBackend); !ok || br.Bridge != "virbr0" {
+		t.Errorf("unexpected second network backend: %#v", cfg.Networks[1].Backend)
+	}
+	if cfg.Networks[1].Model != "e1000" {
+		t.Errorf("expected e1000 model, got %q", cfg.Networks[1].Model)
+	}
+	if _, ok := cfg.Networks[2].Backend.(*PasstNetBackend); !ok {
+		t.Errorf("unexpected third network backend: %#v", cfg.Networks[2].Backend)
+	}
+
+	if cfg.Display.Type != "vnc" || cfg.Display.VNC.Listen != "127.0.0.1:1" {
+		t.Errorf("unexpected display: %+v", cfg.Display)
+	}
+	if cfg.Balloon == nil || !cfg.Balloon.Enabled {
+		t.Error("expected balloon")
+	}
+	if len(cfg.USBDevices) != 1 {
+		t.Error("expected usb tablet")
+	}
+
+	args := strings.Join(NewVMBuilder(cfg).Build(cfg.Name, "/tmp/test.sock"), " ")
+	for _, want := range []string{"pc-q35-8.2", "bus=pcie.0", "org.qemu.guest_agent.0", "ich9-ahci"} {
+		if !strings.Contains(args, want) {
+			t.Errorf("expected args to contain %q, got: %s", want, args)
+		}
+	}
+}
+
+func TestParseLibvirtXMLErrors(t *testing.T) {
+	if _, err := ParseLibvirtXML([]byte("<domain")); err == nil {
+		t.Error("expected error for malformed XML")
+	}
+
+	badArch := `<domain type='kvm'><name>x</name><memory>1024</memory><os><type arch='sparc64'>hvm</type></os></domain>`
+	if _, err := ParseLibvirtXML([]byte(badArch)); err == nil {
+		t.Error("expected error for unsupported arch")
+	}
+}
+
+func TestToLibvirtXML(t *testing.T) {
+	cfg := DefaultVMConfig()
+	cfg.Name = "db01"
+	cfg.Arch = "amd64"
+	cfg.CPU.Cores = 4
+	cfg.Memory.Size = 4096
+	cfg.Disks = []*DiskConfig{
+		{ID: "disk0", Backend: &FileDiskBackend{Path: "/var/lib/images/db01.qcow2", Format: "qcow2"}, Cache: "none"},
+		{ID: "disk1", Backend: &NBDDiskBackend{Host: "10.0.0.5", Port: 10809, Export: "data"}},
+	}
+	cfg.Networks = []*NetworkConfig{
+		{ID: "net0", Backend: &TapNetBackend{Bridge: "br0"}, MACAddr: "52:54:00:11:22:33", MTU: 9000, RxQueueSize: 1024, Offloads: &NICOffloads{TSO: new(bool)}},
+	}
+	cfg.Boot = &BootConfig{Order: "cn"}
+	cfg.EFI = &EFIConfig{Code: "/usr/share/OVMF/OVMF_CODE.secboot.fd", Vars: "/var/lib/qemu/db01_VARS.fd", SecureBoot: true}
+	cfg.Display = &DisplayConfig{Type: "vnc", VNC: &VNCConfig{Listen: "0.0.0.0:2"}}
+	cfg.WithGuestAgent("/run/qga-db01.sock")
+
+	out, err := cfg.ToLibvirtXML()
+	if err != nil {
+		t.Fatalf("ToLibvirtXML error: %v", err)
+	}
+
+	for _, want := range []string{
+		`<domain type="kvm">`,
+		`<name>db01</name>`,
+		`<memory unit="MiB">4096</memory>`,
+		`<vcpu>4</vcpu>`,
+		`<type arch="x86_64" machine="q35">hvm</type>`,
+		`<loader readonly="yes" secure="yes" type="pflash">`,
+		`<smm state="on"></smm>`,
+		`<boot dev="hd"></boot>`,
+		`<boot dev="network"></boot>`,
+		`<source file="/var/lib/images/db01.qcow2"></source>`,
+		`<target dev="vdb" bus="virtio"></target>`,
+		`<source protocol="nbd" name="data">`,
+		`<source bridge="br0"></source>`,
+		`<mtu size="9000"></mtu>`,
+		`<driver rx_queue_size="1024">`,
+		`<guest tso4="off" tso6="off"></guest>`,
+		`<graphics type="vnc" port="5902" listen="0.0.0.0">`,
+		`name="org.qemu.guest_agent.0"`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected XML to contain %q, got:\n%s", want, out)
+		}
+	}
+
+	// The exported XML must import back to an equivalent config
+	back, err := ParseLibvirtXML([]byte(out))
+	if err != nil {
+		t.Fatalf("ParseLibvirtXML error: %v", err)
+	}
+	if back.Name != "db01" || back.Memory.Size != 4096 || back.CPU.Cores != 4 {
+		t.Errorf("unexpected round-tripped config: %+v", back)
+	}
+	if nbd, ok := back.Disks[1].Backend.(*NBDDiskBackend); !ok || nbd.Port != 10809 || nbd.Export != "data" {
+		t.Errorf("unexpected nbd disk: %#v", back.Disks[1].Backend)
+	}
+	if back.Display.VNC == nil || back.Display.VNC.Listen != "0.0.0.0:2" {
+		t.Errorf("unexpected display: %+v", back.Display)
+	}
+	if n := back.Networks[0]; n.MTU != 9000 || n.RxQueueSize != 1024 || n.Offloads == nil || n.Offloads.TSO == nil || *n.Offloads.TSO {
+		t.Errorf("unexpected network: %+v", n)
+	}
+	if back.EFI == nil || !back.EFI.SecureBoot {
+		t.Errorf("unexpected efi: %+v", back.EFI)
+	}
+}
+
+func TestToLibvirtXMLTargets(t *testing.T) {
+	cfg := DefaultVMConfig()
+	cfg.Name = "mixed"
+	cfg.Disks = []*DiskConfig{
+		{ID: "disk0", Backend: &FileDiskBackend{Path: "/var/lib/images/root.img"}, Interface: "virtio"},
+		{ID: "disk1", Backend: &FileDiskBackend{Path: "/var/lib/images/legacy.img"}, Interface: "ide"},
+		{ID: "disk2", Backend: &FileDiskBackend{Path: "/var/lib/images/data.img"}, Interface: "virtio"},
+	}
+	cfg.CDROMs = []*CDROMConfig{{Path: "/var/lib/images/install.iso"}}
+
+	out, err := cfg.ToLibvirtXML()
+	if err != nil {
+		t.Fatalf("ToLibvirtXML error: %v", err)
+	}
+	var got []string
+	for _, line := range strings.Split(out, "\n") {
+		if strings.Contains(line, "<target dev=") {
+			got = append(got, strings.TrimSpace(line))
+		}
+	}
+	want := []string{
+		`<target dev="vda" bus="virtio"></target>`,
+		`<target dev="sda" bus="sata"></target>`,
+		`<target dev="vdb" bus="virtio"></target>`,
+		`<target dev="sdb" bus="sata"></target>`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("targets:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestToLibvirtXMLUnsupported(t *testing.T) {
+	cfg := &VMConfig{
+		Name:     "x",
+		Networks: []*NetworkConfig{{ID: "net0", Backend: &VDENetBackend{Sock: "/tmp/vde"}}},
+	}
+	if _, err := cfg.ToLibvirtXML(); err == nil {
+		t.Error("expected error for VDE backend")
+	}
+}
+
+func TestLibvirtDiskName(t *testing.T) {
+	tests := map[int]string{0: "vda", 1: "vdb", 25: "vdz", 26: "vdaa", 27: "vdab"}
+	for idx, want := range tests {
+		if got := libvirtDiskName("vd", idx); got != want {
+			t.Errorf("libvirtDiskName(%d): expected %q, got %q", idx, want, got)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package qemuctl
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without sending the command while the circuit
+// breaker is open after repeated timeouts.
+var ErrCircuitOpen = errors.New("QMP circuit breaker open: monitor is not responding")
+
+// CommandLimits configures admission control for QMP commands, protecting
+// callers from piling up on a wedged monitor. Zero values disable the
+// corresponding limit. Out-of-band commands are never limited.
+type CommandLimits struct {
+	// MaxInFlight caps the number of commands awaiting a response. Further
+	// commands wait for a slot, within their timeout.
+	MaxInFlight int
+
+	// Rate caps the number of commands sent per second.
+	Rate float64
+
+	// Burst is the number of commands that may be sent at once before Rate
+	// applies (default 1).
+	Burst int
+
+	// BreakerThreshold is the number of consecutive timeouts that open the
+	// circuit breaker.
+	BreakerThreshold int
+
+	// BreakerCooldown is how long the breaker stays open before commands
+	// are let through again (default 30s). A timeout at that point opens
+	// it again; a response closes it.
+	BreakerCooldown time.Duration
+}
+
+// commandLimiter enforces CommandLimits.
+type commandLimiter struct {
+	limits CommandLimits
+	slots  chan struct{}
+
+	mu        sync.Mutex
+	tokens    float64
+	last      time.Time
+	timeouts  int
+	openUntil time.Time
+}
+
+// SetLimits sets the command limits, or removes them if limits is nil.
+// Commands already waiting keep the limits they started with.
+func (q *QMP) SetLimits(limits *CommandLimits) {
+	var l *commandLimiter
+	if limits != nil {
+		l = &commandLimiter{limits: *limits, last: time.Now()}
+		if l.limits.Burst <= 0 {
+			l.limits.Burst = 1
+		}
+		if l.limits.BreakerCooldown <= 0 {
+			l.limits.BreakerCooldown = 30 * time.Second
+		}
+		l.tokens = float64(l.limits.Burst)
+		if l.limits.MaxInFlight > 0 {
+			l.slots = make(chan struct{}, l.limits.MaxInFlight)
+		}
+	}
+	q.limiter.Store(l)
+}
+
+// admit waits until a command may be sent, until deadline at most. The
+// returned function must be called with whether the command timed out.
+func (q *QMP) admit(deadline time.Time) (func(timedOut bool), error) {
+	l := q.limiter.Load()
+	if l == nil {
+		return func(bool) {}, nil
+	}
+
+	if err := l.checkBreaker(); err != nil {
+		return nil, err
+	}
+
+	if l.slots != nil {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		select {
+		case l.slots <- struct{}{}:
+		case <-timer.C:
+			return nil, fmt.Errorf("timeout waiting for a QMP command slot")
+		case <-q.closeCh:
+			return nil, q.closedErr()
+		}
+	}
+
+	if err := l.waitToken(deadline, q.closeCh); err != nil {
+		if l.slots != nil {
+			<-l.slots
+		}
+		return nil, err
+	}
+
+	return func(timedOut bool) {
+		if l.slots != nil {
+			<-l.slots
+		}
+		l.record(timedOut)
+	}, nil
+}
+
+// checkBreaker fails if the circuit breaker is open.
+func (l *commandLimiter) checkBreaker() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if time.Now().Before(l.openUntil) {
+		return ErrCircuitOpen
+	}
+	return nil
+}
+
+// record updates the circuit breaker with the outcome of a command.
+func (l *commandLimiter) record(timedOut bool) {
+	if l.limits.BreakerThreshold <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !timedOut {
+		l.timeouts = 0
+		return
+	}
+	l.timeouts++
+	if l.timeouts >= l.limits.BreakerThreshold {
+		l.openUntil = time.Now().Add(l.limits.BreakerCooldown)
+	}
+}
+
+// waitToken takes a token from the rate limiter bucket, waiting for one
+// to be available until deadline.
+func (l *commandLimiter) waitToken(deadline time.Time, closeCh <-chan struct{}) error {
+	if l.limits.Rate <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.limits.Rate, float64(l.limits.Burst))
+	l.last = now
+	// Take the token now, possibly going negative, so concurrent callers
+	// queue up behind each other
+	l.tokens--
+	wait := time.Duration(-l.tokens / l.limits.Rate * float64(time.Second))
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	if now.Add(wait).After(deadline) {
+		l.mu.Lock()
+		l.tokens++
+		l.mu.Unlock()
+		return fmt.Errorf("QMP command rate limit exceeded")
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-closeCh:
+		return errQMPClosed
+	}
+}
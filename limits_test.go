@@ -0,0 +1,93 @@
+package qemuctl
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQMPCircuitBreaker(t *testing.T) {
+	fake := newFakeQMP(t)
+	release := make(chan struct{})
+	fake.handle("hang", func(map[string]any) (any, *qmpError) {
+		<-release
+		return map[string]any{}, nil
+	})
+	qmp := fake.attach().QMP()
+	qmp.SetLimits(&CommandLimits{BreakerThreshold: 2, BreakerCooldown: 200 * time.Millisecond})
+
+	for n := 0; n < 2; n++ {
+		_, err := qmp.ExecuteWithTimeout("hang", nil, 20*time.Millisecond)
+		var timeoutErr *TimeoutError
+		if !errors.As(err, &timeoutErr) {
+			t.Fatalf("hang %d error = %v, want timeout", n, err)
+		}
+	}
+
+	if _, err := qmp.Execute("query-status", nil); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("error with open breaker = %v, want ErrCircuitOpen", err)
+	}
+
+	// The monitor recovers, and commands go through after the cooldown
+	close(release)
+	time.Sleep(250 * time.Millisecond)
+	if _, err := qmp.Execute("query-status", nil); err != nil {
+		t.Errorf("command after cooldown failed: %v", err)
+	}
+}
+
+func TestQMPMaxInFlight(t *testing.T) {
+	fake := newFakeQMP(t)
+	release := make(chan struct{})
+	fake.handle("slow", func(map[string]any) (any, *qmpError) {
+		<-release
+		return map[string]any{}, nil
+	})
+	qmp := fake.attach().QMP()
+	qmp.SetLimits(&CommandLimits{MaxInFlight: 1})
+
+	slowDone := make(chan error, 1)
+	go func() {
+		_, err := qmp.Execute("slow", nil)
+		slowDone <- err
+	}()
+
+	// Wait until the slow command holds the only slot
+	for len(fake.commands("slow")) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	_, err := qmp.ExecuteWithTimeout("query-status", nil, 50*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "slot") {
+		t.Errorf("second command error = %v, want slot timeout", err)
+	}
+	if n := len(fake.commands("query-status")); n != 1 {
+		t.Errorf("query-status reached the server %d times, want 1 (attach only)", n)
+	}
+
+	close(release)
+	if err := <-slowDone; err != nil {
+		t.Errorf("slow command failed: %v", err)
+	}
+	if _, err := qmp.Execute("query-status", nil); err != nil {
+		t.Errorf("command after slot release failed: %v", err)
+	}
+}
+
+func TestQMPRateLimit(t *testing.T) {
+	fake := newFakeQMP(t)
+	qmp := fake.attach().QMP()
+	qmp.SetLimits(&CommandLimits{Rate: 50, Burst: 1})
+
+	start := time.Now()
+	for n := 0; n < 6; n++ {
+		if _, err := qmp.Execute("query-status", nil); err != nil {
+			t.Fatalf("command %d failed: %v", n, err)
+		}
+	}
+	// The first command uses the burst, the other five wait 20ms each
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("6 commands at 50/s took %v, want at least 100ms", elapsed)
+	}
+}
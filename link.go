@@ -0,0 +1,119 @@
+package qemuctl
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// RxFilterInfo is the receive filter of a NIC, as set by the guest driver.
+type RxFilterInfo struct {
+	// Name is the NIC ID.
+	Name string `json:"name"`
+
+	// Promiscuous reports whether the NIC receives all packets.
+	Promiscuous bool `json:"promiscuous"`
+
+	// Multicast and Unicast are the receive modes: "normal", "none" or
+	// "all".
+	Multicast string `json:"multicast"`
+	Unicast   string `json:"unicast"`
+
+	// VLAN is the VLAN receive mode.
+	VLAN string `json:"vlan"`
+
+	// BroadcastAllowed reports whether the NIC receives broadcasts.
+	BroadcastAllowed bool `json:"broadcast-allowed"`
+
+	// MulticastOverflow and UnicastOverflow are set when the address
+	// tables are full.
+	MulticastOverflow bool `json:"multicast-overflow"`
+	UnicastOverflow   bool `json:"unicast-overflow"`
+
+	// MainMAC is the MAC address the guest set on the NIC.
+	MainMAC string `json:"main-mac"`
+
+	// VLANTable lists the VLANs the NIC receives.
+	VLANTable []int `json:"vlan-table"`
+
+	// UnicastTable and MulticastTable list the additional addresses the
+	// NIC receives.
+	UnicastTable   []string `json:"unicast-table"`
+	MulticastTable []string `json:"multicast-table"`
+}
+
+// SetLinkState sets the link of a NIC up or down, as if its cable was
+// plugged or unplugged, to test how the guest fails over. nicID is the
+// NIC ID or the ID of its netdev; NICs of a NetworkConfig have the ID
+// "<ID>-device" and the netdev ID ID.
+func (i *Instance) SetLinkState(nicID string, up bool) error {
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return ErrNotConnected
+	}
+
+	_, err := qmp.Execute("set_link", map[string]any{"name": nicID, "up": up})
+	return err
+}
+
+// QueryRxFilter returns the receive filter of the NIC with ID nicID. QEMU
+// sends NIC_RX_FILTER_CHANGED only once until the filter is queried, so
+// this also re-arms the event for the NIC.
+func (i *Instance) QueryRxFilter(nicID string) (*RxFilterInfo, error) {
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return nil, ErrNotConnected
+	}
+
+	resp, err := qmp.Execute("query-rx-filter", map[string]any{"name": nicID})
+	if err != nil {
+		return nil, err
+	}
+
+	var filters []*RxFilterInfo
+	if err := json.Unmarshal(resp, &filters); err != nil {
+		return nil, fmt.Errorf("failed to parse rx filter: %w", err)
+	}
+	if len(filters) == 0 {
+		return nil, fmt.Errorf("no rx filter for NIC %s", nicID)
+	}
+	return filters[0], nil
+}
+
+// SetRxFilterCallback sets a callback called with the new receive filter
+// of a NIC each time the guest changes it, such as when a bonding driver
+// moves its MAC address on failover. The filter is queried for each
+// NIC_RX_FILTER_CHANGED event, which keeps the events coming. NICs
+// without an ID are not reported.
+func (i *Instance) SetRxFilterCallback(cb func(*RxFilterInfo)) {
+	i.rxFilterMu.Lock()
+	defer i.rxFilterMu.Unlock()
+	i.onRxFilter = cb
+}
+
+// recordRxFilter handles NIC_RX_FILTER_CHANGED events.
+func (i *Instance) recordRxFilter(event *Event) {
+	p, _ := event.Payload().(*NicRxFilterChangedEvent)
+	if p == nil || p.Name == "" {
+		return
+	}
+
+	i.rxFilterMu.Lock()
+	cb := i.onRxFilter
+	i.rxFilterMu.Unlock()
+	if cb == nil {
+		return
+	}
+
+	// Hooks run on the QMP reader, which the query needs
+	go func() {
+		if filter, err := i.QueryRxFilter(p.Name); err == nil {
+			cb(filter)
+		}
+	}()
+}
@@ -0,0 +1,68 @@
+package qemuctl
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSetLinkState(t *testing.T) {
+	fake := newFakeQMP(t)
+	fake.handle("set_link", func(map[string]any) (any, *qmpError) { return map[string]any{}, nil })
+	inst := fake.attach()
+
+	if err := inst.SetLinkState("net0-device", false); err != nil {
+		t.Fatalf("SetLinkState failed: %v", err)
+	}
+	if err := inst.SetLinkState("net0-device", true); err != nil {
+		t.Fatalf("SetLinkState failed: %v", err)
+	}
+
+	want := []map[string]any{{"name": "net0-device", "up": false}, {"name": "net0-device", "up": true}}
+	if got := fake.commands("set_link"); !reflect.DeepEqual(got, want) {
+		t.Errorf("set_link = %v, want %v", got, want)
+	}
+}
+
+func TestRxFilterCallback(t *testing.T) {
+	fake := newFakeQMP(t)
+	fake.handle("query-rx-filter", func(args map[string]any) (any, *qmpError) {
+		return []map[string]any{{
+			"name":              args["name"],
+			"promiscuous":       false,
+			"multicast":         "normal",
+			"unicast":           "normal",
+			"vlan":              "normal",
+			"broadcast-allowed": true,
+			"main-mac":          "52:54:00:12:34:57",
+			"vlan-table":        []int{},
+			"unicast-table":     []string{},
+			"multicast-table":   []string{"01:00:5e:00:00:01"},
+		}}, nil
+	})
+	inst := fake.attach()
+
+	filters := make(chan *RxFilterInfo, 1)
+	inst.SetRxFilterCallback(func(f *RxFilterInfo) { filters <- f })
+	fake.sendEvent("NIC_RX_FILTER_CHANGED", map[string]any{"name": "net0-device", "path": "/machine/peripheral/net0-device/virtio-backend"})
+
+	select {
+	case f := <-filters:
+		if f.Name != "net0-device" || f.MainMAC != "52:54:00:12:34:57" || !f.BroadcastAllowed || len(f.MulticastTable) != 1 {
+			t.Errorf("filter = %+v", f)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("rx filter callback not called")
+	}
+
+	// NICs without an ID cannot be queried
+	fake.sendEvent("NIC_RX_FILTER_CHANGED", map[string]any{"path": "/machine/unattached/device[3]"})
+	select {
+	case f := <-filters:
+		t.Errorf("callback called for a NIC without an ID: %+v", f)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if n := len(fake.commands("query-rx-filter")); n != 1 {
+		t.Errorf("query-rx-filter sent %d times, want 1", n)
+	}
+}
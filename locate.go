@@ -11,6 +11,9 @@ import (
 // ErrQemuNotFound is returned when QEMU cannot be located.
 var ErrQemuNotFound = errors.New("QEMU binary not found")
 
+// ErrQemuImgNotFound is returned when qemu-img cannot be located.
+var ErrQemuImgNotFound = errors.New("qemu-img binary not found")
+
 // qemuSearchPaths are additional paths to search for QEMU binaries.
 var qemuSearchPaths = []string{
 	"/pkg/main/app-emulation.qemu.core/bin",
@@ -51,34 +54,51 @@ func LocateQemu(arch string, customPath string) (string, error) {
 		return "", &UnsupportedArchError{Arch: arch}
 	}
 
-	binaryName := "qemu-system-" + qemuArch
+	path, ok := locateBinary("qemu-system-"+qemuArch, customPath)
+	if !ok {
+		return "", ErrQemuNotFound
+	}
+	return path, nil
+}
+
+// LocateQemuImg finds the qemu-img tool using the same search order as
+// LocateQemu. customPath may be the binary itself or its directory.
+func LocateQemuImg(customPath string) (string, error) {
+	path, ok := locateBinary("qemu-img", customPath)
+	if !ok {
+		return "", ErrQemuImgNotFound
+	}
+	return path, nil
+}
 
+// locateBinary searches customPath, PATH and qemuSearchPaths for binaryName.
+func locateBinary(binaryName, customPath string) (string, bool) {
 	// 1. Check custom path
 	if customPath != "" {
 		if info, err := os.Stat(customPath); err == nil && !info.IsDir() {
-			return customPath, nil
+			return customPath, true
 		}
 		// Check if it's a directory containing the binary
 		fullPath := filepath.Join(customPath, binaryName)
 		if info, err := os.Stat(fullPath); err == nil && !info.IsDir() {
-			return fullPath, nil
+			return fullPath, true
 		}
 	}
 
 	// 2. Search in PATH
 	if path, err := exec.LookPath(binaryName); err == nil {
-		return path, nil
+		return path, true
 	}
 
 	// 3. Search in known paths
 	for _, dir := range qemuSearchPaths {
 		fullPath := filepath.Join(dir, binaryName)
 		if info, err := os.Stat(fullPath); err == nil && !info.IsDir() {
-			return fullPath, nil
+			return fullPath, true
 		}
 	}
 
-	return "", ErrQemuNotFound
+	return "", false
 }
 
 // UnsupportedArchError is returned when the architecture is not supported.
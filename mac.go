@@ -0,0 +1,36 @@
+package qemuctl
+
+import (
+	"crypto/sha256"
+	"net"
+	"strconv"
+)
+
+// StableMAC returns a MAC address derived from a VM name and a NIC index,
+// so that a VM keeps its addresses, and its DHCP leases, across restarts
+// while VMs with different names get different ones. The address is a
+// locally administered unicast address.
+func StableMAC(name string, index int) string {
+	sum := sha256.Sum256([]byte(name + "\x00" + strconv.Itoa(index)))
+	mac := net.HardwareAddr(sum[:6])
+	// Locally administered, unicast
+	mac[0] = mac[0]&^0x01 | 0x02
+	return mac.String()
+}
+
+// WithStableMACs sets the MAC address of each NIC without one to
+// StableMAC of the VM name and the index of the NIC in Networks. Unlike
+// QEMU's default addresses, which are the same for every VM, they do not
+// collide on a shared bridge. Without a name, which StartVM would
+// generate at random, the addresses are left to QEMU.
+func (cfg *VMConfig) WithStableMACs() *VMConfig {
+	if cfg.Name == "" {
+		return cfg
+	}
+	for n, nc := range cfg.Networks {
+		if nc.MACAddr == "" {
+			nc.MACAddr = StableMAC(cfg.Name, n)
+		}
+	}
+	return cfg
+}
@@ -0,0 +1,34 @@
+package qemuctl
+
+import (
+	"net"
+	"testing"
+)
+
+func TestStableMAC(t *testing.T) {
+	mac := StableMAC("web1", 0)
+	if mac != StableMAC("web1", 0) {
+		t.Error("StableMAC is not deterministic")
+	}
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		t.Fatalf("invalid MAC %q: %v", mac, err)
+	}
+	if hw[0]&0x02 == 0 || hw[0]&0x01 != 0 {
+		t.Errorf("%s is not a locally administered unicast address", mac)
+	}
+	if mac == StableMAC("web1", 1) || mac == StableMAC("web2", 0) {
+		t.Error("different NICs got the same MAC")
+	}
+
+	cfg := DefaultVMConfig()
+	cfg.Name = "web1"
+	cfg.Networks = []*NetworkConfig{
+		{ID: "net0", Backend: &UserNetBackend{}},
+		{ID: "net1", Backend: &UserNetBackend{}, MACAddr: "52:54:00:aa:bb:cc"},
+	}
+	cfg.WithStableMACs()
+	if cfg.Networks[0].MACAddr != mac || cfg.Networks[1].MACAddr != "52:54:00:aa:bb:cc" {
+		t.Errorf("MACs = %s, %s", cfg.Networks[0].MACAddr, cfg.Networks[1].MACAddr)
+	}
+}
@@ -0,0 +1,127 @@
+package qemuctl
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MachineInfo is a machine type supported by a QEMU binary.
+type MachineInfo struct {
+	// Name is the versioned machine type, e.g. "pc-q35-8.2".
+	Name string `json:"name"`
+
+	// Alias is the unversioned name pointing to it, e.g. "q35", if any.
+	Alias string `json:"alias,omitempty"`
+
+	// Description is the human-readable description.
+	Description string `json:"description"`
+
+	// Default marks the machine used when -machine is not given.
+	Default bool `json:"default,omitempty"`
+
+	// Deprecated marks machine types due for removal.
+	Deprecated bool `json:"deprecated,omitempty"`
+}
+
+// machineCacheEntry remembers the machines of a binary until it changes.
+type machineCacheEntry struct {
+	modTime  time.Time
+	machines []MachineInfo
+}
+
+var (
+	machineCache   = make(map[string]machineCacheEntry)
+	machineCacheMu sync.Mutex
+)
+
+// ListMachines runs the QEMU binary at qemuPath with "-machine help" and
+// returns its machine types, in the order QEMU lists them. Results are
+// cached until the binary changes. Use FindMachine to check the machine of
+// a configuration before starting it.
+func ListMachines(qemuPath string) ([]MachineInfo, error) {
+	info, err := os.Stat(qemuPath)
+	if err != nil {
+		return nil, err
+	}
+
+	machineCacheMu.Lock()
+	entry, ok := machineCache[qemuPath]
+	machineCacheMu.Unlock()
+	if ok && entry.modTime.Equal(info.ModTime()) {
+		return entry.machines, nil
+	}
+
+	out, err := exec.Command(qemuPath, "-machine", "help").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list QEMU machines: %w", err)
+	}
+
+	machines := parseMachineHelp(string(out))
+	if len(machines) == 0 {
+		return nil, fmt.Errorf("no machine types found in the output of %s -machine help", qemuPath)
+	}
+
+	machineCacheMu.Lock()
+	machineCache[qemuPath] = machineCacheEntry{modTime: info.ModTime(), machines: machines}
+	machineCacheMu.Unlock()
+
+	return machines, nil
+}
+
+// FindMachine returns the machine type named name, matching either its
+// name or its alias.
+func FindMachine(machines []MachineInfo, name string) (MachineInfo, bool) {
+	for _, m := range machines {
+		if m.Name == name || (m.Alias != "" && m.Alias == name) {
+			return m, true
+		}
+	}
+	return MachineInfo{}, false
+}
+
+// parseMachineHelp parses the output of "-machine help":
+//
+//	Supported machines are:
+//	q35                  Standard PC (Q35 + ICH9, 2009) (alias of pc-q35-8.2)
+//	pc-q35-8.2           Standard PC (Q35 + ICH9, 2009)
+//	pc-q35-2.4           Standard PC (Q35 + ICH9, 2009) (deprecated)
+//
+// Alias lines come first and are folded into the machine they point to.
+func parseMachineHelp(out string) []MachineInfo {
+	var machines []MachineInfo
+	aliases := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		name, desc, ok := strings.Cut(line, " ")
+		if !ok || strings.HasSuffix(line, ":") {
+			continue
+		}
+		desc = strings.TrimSpace(desc)
+
+		if _, target, ok := strings.Cut(desc, " (alias of "); ok {
+			aliases[strings.TrimSuffix(target, ")")] = name
+			continue
+		}
+
+		m := MachineInfo{Name: name}
+		if d, ok := strings.CutSuffix(desc, " (deprecated)"); ok {
+			m.Deprecated = true
+			desc = d
+		}
+		if d, ok := strings.CutSuffix(desc, " (default)"); ok {
+			m.Default = true
+			desc = d
+		}
+		m.Description = desc
+		machines = append(machines, m)
+	}
+
+	for i := range machines {
+		machines[i].Alias = aliases[machines[i].Name]
+	}
+	return machines
+}
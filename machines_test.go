@@ -0,0 +1,48 @@
+package qemuctl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const machineHelp = `Supported machines are:
+microvm              microvm (i386)
+pc                   Standard PC (i440FX + PIIX, 1996) (alias of pc-i440fx-8.2)
+pc-i440fx-8.2        Standard PC (i440FX + PIIX, 1996) (default)
+pc-i440fx-2.4        Standard PC (i440FX + PIIX, 1996) (deprecated)
+q35                  Standard PC (Q35 + ICH9, 2009) (alias of pc-q35-8.2)
+pc-q35-8.2           Standard PC (Q35 + ICH9, 2009)
+none                 empty machine
+`
+
+func TestListMachines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "qemu-system-x86_64")
+	script := "#!/bin/sh\n[ \"$1 $2\" = \"-machine help\" ] || exit 1\ncat <<'EOF'\n" + machineHelp + "EOF\n"
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	machines, err := ListMachines(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(machines) != 5 {
+		t.Fatalf("got %d machines, want 5: %+v", len(machines), machines)
+	}
+
+	want := MachineInfo{Name: "pc-i440fx-8.2", Alias: "pc", Description: "Standard PC (i440FX + PIIX, 1996)", Default: true}
+	if machines[1] != want {
+		t.Errorf("machines[1] = %+v, want %+v", machines[1], want)
+	}
+	if !machines[2].Deprecated || machines[2].Default {
+		t.Errorf("machines[2] = %+v, want deprecated", machines[2])
+	}
+
+	if m, ok := FindMachine(machines, "q35"); !ok || m.Name != "pc-q35-8.2" {
+		t.Errorf("FindMachine(q35) = %+v, %v", m, ok)
+	}
+	if _, ok := FindMachine(machines, "virt"); ok {
+		t.Error("FindMachine(virt) found a machine")
+	}
+}
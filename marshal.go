@@ -0,0 +1,400 @@
+package qemuctl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// diskBackendTypes maps backend type names to constructors used when
+// decoding a DiskConfig from JSON.
+var diskBackendTypes = map[string]func() DiskBackend{
+	"file":  func() DiskBackend { return &FileDiskBackend{} },
+	"nbd":   func() DiskBackend { return &NBDDiskBackend{} },
+	"rbd":   func() DiskBackend { return &RBDDiskBackend{} },
+	"iscsi": func() DiskBackend { return &ISCSIDiskBackend{} },
+}
+
+// networkBackendTypes maps backend type names to constructors used when
+// decoding a NetworkConfig from JSON.
+var networkBackendTypes = map[string]func() NetworkBackend{
+	"user":   func() NetworkBackend { return &UserNetBackend{} },
+	"tap":    func() NetworkBackend { return &TapNetBackend{} },
+	"socket": func() NetworkBackend { return &SocketNetBackend{} },
+	"stream": func() NetworkBackend { return &StreamNetBackend{} },
+	"vde":    func() NetworkBackend { return &VDENetBackend{} },
+	"bridge": func() NetworkBackend { return &BridgeNetBackend{} },
+	"passt":  func() NetworkBackend { return &PasstNetBackend{} },
+
+	"vmnet-shared":  func() NetworkBackend { return &VmnetSharedNetBackend{} },
+	"vmnet-bridged": func() NetworkBackend { return &VmnetBridgedNetBackend{} },
+}
+
+var backendTypesMu sync.RWMutex
+
+// RegisterDiskBackend registers a custom disk backend type so it can be
+// decoded from JSON. The name must match the backend's Type() value.
+func RegisterDiskBackend(name string, fn func() DiskBackend) {
+	backendTypesMu.Lock()
+	defer backendTypesMu.Unlock()
+	diskBackendTypes[name] = fn
+}
+
+// RegisterNetworkBackend registers a custom network backend type so it can be
+// decoded from JSON. The name must match the backend's Type() value.
+func RegisterNetworkBackend(name string, fn func() NetworkBackend) {
+	backendTypesMu.Lock()
+	defer backendTypesMu.Unlock()
+	networkBackendTypes[name] = fn
+}
+
+// marshalBackend encodes a backend as a JSON object with an added "type" key.
+func marshalBackend(typ string, v any) (json.RawMessage, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		obj = make(map[string]json.RawMessage)
+	}
+
+	typeJSON, _ := json.Marshal(typ)
+	obj["type"] = typeJSON
+
+	return json.Marshal(obj)
+}
+
+// backendType extracts the "type" key from an encoded backend.
+func backendType(data json.RawMessage) (string, error) {
+	var tagged struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &tagged); err != nil {
+		return "", err
+	}
+	if tagged.Type == "" {
+		return "", fmt.Errorf("backend is missing a type")
+	}
+	return tagged.Type, nil
+}
+
+// MarshalJSON encodes the disk configuration with a type-tagged backend.
+func (d DiskConfig) MarshalJSON() ([]byte, error) {
+	type plain DiskConfig
+	aux := struct {
+		*plain
+		Backend json.RawMessage `json:"backend,omitempty"`
+	}{plain: (*plain)(&d)}
+
+	if d.Backend != nil {
+		backend, err := marshalBackend(d.Backend.Type(), d.Backend)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal disk backend: %w", err)
+		}
+		aux.Backend = backend
+	}
+
+	return json.Marshal(aux)
+}
+
+// UnmarshalJSON decodes a disk configuration, instantiating the backend
+// registered for its type.
+func (d *DiskConfig) UnmarshalJSON(data []byte) error {
+	type plain DiskConfig
+	aux := struct {
+		*plain
+		Backend json.RawMessage `json:"backend,omitempty"`
+	}{plain: (*plain)(d)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	d.Backend = nil
+	if len(aux.Backend) == 0 || string(aux.Backend) == "null" {
+		return nil
+	}
+
+	typ, err := backendType(aux.Backend)
+	if err != nil {
+		return fmt.Errorf("invalid disk backend: %w", err)
+	}
+
+	backendTypesMu.RLock()
+	fn, ok := diskBackendTypes[typ]
+	backendTypesMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("unknown disk backend type %q", typ)
+	}
+
+	backend := fn()
+	if err := json.Unmarshal(aux.Backend, backend); err != nil {
+		return fmt.Errorf("invalid %s disk backend: %w", typ, err)
+	}
+	d.Backend = backend
+
+	return nil
+}
+
+// MarshalJSON encodes the network configuration with a type-tagged backend.
+func (n NetworkConfig) MarshalJSON() ([]byte, error) {
+	type plain NetworkConfig
+	aux := struct {
+		*plain
+		Backend json.RawMessage `json:"backend,omitempty"`
+	}{plain: (*plain)(&n)}
+
+	if n.Backend != nil {
+		backend, err := marshalBackend(n.Backend.Type(), n.Backend)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal network backend: %w", err)
+		}
+		aux.Backend = backend
+	}
+
+	return json.Marshal(aux)
+}
+
+// UnmarshalJSON decodes a network configuration, instantiating the backend
+// registered for its type.
+func (n *NetworkConfig) UnmarshalJSON(data []byte) error {
+	type plain NetworkConfig
+	aux := struct {
+		*plain
+		Backend json.RawMessage `json:"backend,omitempty"`
+	}{plain: (*plain)(n)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	n.Backend = nil
+	if len(aux.Backend) == 0 || string(aux.Backend) == "null" {
+		return nil
+	}
+
+	typ, err := backendType(aux.Backend)
+	if err != nil {
+		return fmt.Errorf("invalid network backend: %w", err)
+	}
+
+	backendTypesMu.RLock()
+	fn, ok := networkBackendTypes[typ]
+	backendTypesMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("unknown network backend type %q", typ)
+	}
+
+	backend := fn()
+	if err := json.Unmarshal(aux.Backend, backend); err != nil {
+		return fmt.Errorf("invalid %s network backend: %w", typ, err)
+	}
+	n.Backend = backend
+
+	return nil
+}
+
+// inheritsKey names the base profile of a VM config file.
+const inheritsKey = "inherits"
+
+// ParseVMConfig decodes a VMConfig from JSON. Configs that inherit from a
+// base profile must be read with LoadVMConfig, which resolves the profile
+// path.
+func ParseVMConfig(data []byte) (*VMConfig, error) {
+	if bytes.Contains(data, []byte(`"`+inheritsKey+`"`)) {
+		var obj map[string]json.RawMessage
+		if json.Unmarshal(data, &obj) == nil && obj[inheritsKey] != nil {
+			return nil, fmt.Errorf("failed to parse VM config: %q is only supported by LoadVMConfig", inheritsKey)
+		}
+	}
+
+	cfg := &VMConfig{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse VM config: %w", err)
+	}
+	return cfg, nil
+}
+
+// LoadVMConfig reads a VMConfig from a JSON file.
+//
+// The file may name a base profile with "inherits", relative to its own
+// directory. The file is deep-merged over the profile, which may itself
+// inherit from another: objects are merged key by key, arrays of objects
+// with an "id" (such as disks and networks) are merged by id, other values
+// and empty arrays replace the profile's, and null removes a value set by
+// the profile.
+func LoadVMConfig(path string) (*VMConfig, error) {
+	merged, err := loadConfigTree(path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return nil, fmt.Errorf("failed to merge VM config: %w", err)
+	}
+	cfg := &VMConfig{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse VM config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// loadConfigTree reads a config file as a JSON object and merges it over
+// the profile it inherits from. seen holds the files being loaded, to
+// detect inheritance cycles.
+func loadConfigTree(path string, seen []string) (map[string]any, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read VM config: %w", err)
+	}
+	for _, p := range seen {
+		if p == abs {
+			return nil, fmt.Errorf("VM config %s inherits from itself", abs)
+		}
+	}
+
+	data, err := os.ReadFile(abs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read VM config: %w", err)
+	}
+	// Numbers are kept as json.Number so that large sizes survive the merge
+	var obj map[string]any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&obj); err != nil {
+		return nil, fmt.Errorf("failed to parse VM config %s: %w", abs, err)
+	}
+	if obj == nil {
+		obj = make(map[string]any)
+	}
+
+	base, ok := obj[inheritsKey]
+	if !ok {
+		return obj, nil
+	}
+	delete(obj, inheritsKey)
+	if base == nil {
+		return obj, nil
+	}
+	basePath, ok := base.(string)
+	if !ok || basePath == "" {
+		return nil, fmt.Errorf("invalid %q in VM config %s: must be a file path", inheritsKey, abs)
+	}
+	if !filepath.IsAbs(basePath) {
+		basePath = filepath.Join(filepath.Dir(abs), basePath)
+	}
+
+	parent, err := loadConfigTree(basePath, append(seen, abs))
+	if err != nil {
+		return nil, err
+	}
+	return mergeJSON(parent, obj).(map[string]any), nil
+}
+
+// mergeJSON deep-merges the decoded JSON value over onto base.
+func mergeJSON(base, over any) any {
+	switch o := over.(type) {
+	case map[string]any:
+		b, ok := base.(map[string]any)
+		if !ok {
+			return stripNulls(o)
+		}
+		out := make(map[string]any, len(b)+len(o))
+		for k, v := range b {
+			out[k] = v
+		}
+		for k, v := range o {
+			if v == nil {
+				delete(out, k)
+				continue
+			}
+			out[k] = mergeJSON(b[k], v)
+		}
+		return out
+	case []any:
+		b, ok := base.([]any)
+		if !ok || len(o) == 0 || !hasIDs(b) || !hasIDs(o) {
+			return o
+		}
+		out := make([]any, len(b), len(b)+len(o))
+		copy(out, b)
+		index := make(map[any]int, len(b))
+		for idx, v := range b {
+			index[v.(map[string]any)["id"]] = idx
+		}
+		for _, v := range o {
+			id := v.(map[string]any)["id"]
+			if idx, ok := index[id]; ok {
+				out[idx] = mergeJSON(out[idx], v)
+			} else {
+				out = append(out, v)
+			}
+		}
+		return out
+	}
+	return over
+}
+
+// stripNulls removes null values from an object that has nothing to
+// override, so that they fall back to defaults.
+func stripNulls(obj map[string]any) map[string]any {
+	for k, v := range obj {
+		if v == nil {
+			delete(obj, k)
+		}
+	}
+	return obj
+}
+
+// hasIDs reports whether all elements of a JSON array are objects with an
+// "id" key.
+func hasIDs(arr []any) bool {
+	for _, v := range arr {
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return false
+		}
+		if _, ok := obj["id"].(string); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// SaveFile writes the configuration to a JSON file.
+func (cfg *VMConfig) SaveFile(path string) error {
+	data, err := json.MarshalIndent(cfg, "", "\t")
+	if err != nil {
+		return fmt.Errorf("failed to marshal VM config: %w", err)
+	}
+
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write VM config: %w", err)
+	}
+	return nil
+}
+
+// blockdevBufPool holds buffers reused when encoding -blockdev options.
+var blockdevBufPool = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// blockdevJSON encodes -blockdev options as JSON. It produces the same
+// output as json.Marshal without the intermediate byte slice allocation.
+func blockdevJSON(opts map[string]any) string {
+	buf := blockdevBufPool.Get().(*bytes.Buffer)
+	defer blockdevBufPool.Put(buf)
+	buf.Reset()
+
+	if err := json.NewEncoder(buf).Encode(opts); err != nil {
+		return ""
+	}
+	return string(bytes.TrimSuffix(buf.Bytes(), []byte{'\n'}))
+}
@@ -0,0 +1,116 @@
+package qemuctl
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+)
+
+// mdevDevicesDir is where the kernel lists mediated devices.
+const mdevDevicesDir = "/sys/bus/mdev/devices/"
+
+// MdevConfig passes a mediated device, a slice of a host device such as a
+// NVIDIA vGPU or an Intel GVT-g virtual GPU, through to the guest with
+// vfio-pci. The device is created beforehand with CreateMdev. Like other
+// VFIO devices, it blocks live migration.
+type MdevConfig struct {
+	// ID is the device ID.
+	ID string `json:"id,omitempty"`
+
+	// UUID is the UUID of the mediated device under /sys/bus/mdev/devices.
+	UUID string `json:"uuid"`
+
+	// Display exposes the vGPU output as a QEMU display, for VNC or SPICE
+	// consoles. GVT-g and NVIDIA vGPUs support it.
+	Display bool `json:"display,omitempty"`
+}
+
+// MdevType is a type of mediated device a host device can create.
+type MdevType struct {
+	// Parent is the host device, e.g. "0000:00:02.0".
+	Parent string `json:"parent"`
+
+	// Type is the type ID passed to CreateMdev, e.g. "i915-GVTg_V5_4" or
+	// "nvidia-259".
+	Type string `json:"type"`
+
+	// Name is the vendor's name for the type, e.g. "GRID T4-2Q".
+	Name string `json:"name,omitempty"`
+
+	// Description details the type, such as its memory or resolution.
+	Description string `json:"description,omitempty"`
+
+	// DeviceAPI is the API the device exposes, "vfio-pci" for GPUs.
+	DeviceAPI string `json:"device_api,omitempty"`
+
+	// Available is how many more devices of this type can be created.
+	Available int `json:"available"`
+}
+
+// validate checks that the device has a well-formed UUID.
+func (cfg *MdevConfig) validate() error {
+	if !isUUID(cfg.UUID) {
+		return fmt.Errorf("mediated device %s: invalid UUID %q", cfg.ID, cfg.UUID)
+	}
+	return nil
+}
+
+// mdevDeviceOpts returns the vfio-pci options of a mediated device.
+func mdevDeviceOpts(cfg *MdevConfig) string {
+	opts := ",sysfsdev=" + mdevDevicesDir + cfg.UUID
+	if cfg.Display {
+		opts += ",display=on"
+	}
+	return opts
+}
+
+// mdevFromOpts rebuilds an MdevConfig from its -device options, or returns
+// nil if the device is not a mediated device.
+func mdevFromOpts(o *qemuOpts) *MdevConfig {
+	uuid, ok := strings.CutPrefix(o.get("sysfsdev"), mdevDevicesDir)
+	if !ok {
+		return nil
+	}
+	return &MdevConfig{ID: o.get("id"), UUID: uuid, Display: o.bool("display")}
+}
+
+// buildMdevs builds the vfio-pci devices of mediated devices.
+func (b *VMBuilder) buildMdevs() {
+	for i, mdev := range b.config.Mdevs {
+		id := mdev.ID
+		if id == "" {
+			id = fmt.Sprintf("mdev%d", i)
+		}
+		b.args = append(b.args, "-device", "vfio-pci,id="+id+mdevDeviceOpts(mdev)+
+			",bus="+b.pciAlloc.Bus()+",addr="+b.pciAlloc.Alloc())
+	}
+}
+
+// newUUID returns a random version 4 UUID.
+func newUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// isUUID reports whether s is a UUID in its canonical textual form.
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i, c := range s {
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,103 @@
+//go:build linux
+
+package qemuctl
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ListMdevTypes returns the mediated device types of the host devices
+// under /sys/class/mdev_bus, with the number of devices each can still
+// create.
+func ListMdevTypes() ([]MdevType, error) {
+	return listMdevTypes("/")
+}
+
+// CreateMdev creates a mediated device of type typ on the host device
+// parent and returns its UUID, for MdevConfig.UUID. It needs root.
+func CreateMdev(parent, typ string) (string, error) {
+	return createMdev("/", parent, typ)
+}
+
+// RemoveMdev removes the mediated device with the given UUID. The device
+// must not be in use by a VM.
+func RemoveMdev(uuid string) error {
+	return removeMdev("/", uuid)
+}
+
+// listMdevTypes reads the mediated device types under root.
+func listMdevTypes(root string) ([]MdevType, error) {
+	busDir := filepath.Join(root, "sys/class/mdev_bus")
+	parents, err := os.ReadDir(busDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	var types []MdevType
+	for _, parent := range parents {
+		typesDir := filepath.Join(busDir, parent.Name(), "mdev_supported_types")
+		entries, err := os.ReadDir(typesDir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			dir := filepath.Join(typesDir, entry.Name())
+			types = append(types, MdevType{
+				Parent:      parent.Name(),
+				Type:        entry.Name(),
+				Name:        readSysString(filepath.Join(dir, "name")),
+				Description: readSysString(filepath.Join(dir, "description")),
+				DeviceAPI:   readSysString(filepath.Join(dir, "device_api")),
+				Available:   readSysInt(filepath.Join(dir, "available_instances")),
+			})
+		}
+	}
+	return types, nil
+}
+
+// createMdev creates a mediated device under root.
+func createMdev(root, parent, typ string) (string, error) {
+	path := filepath.Join(root, "sys/class/mdev_bus", parent, "mdev_supported_types", typ, "create")
+	uuid := newUUID()
+	if err := writeSysFile(path, uuid); err != nil {
+		return "", fmt.Errorf("failed to create mediated device of type %s on %s: %w", typ, parent, err)
+	}
+	return uuid, nil
+}
+
+// removeMdev removes a mediated device under root.
+func removeMdev(root, uuid string) error {
+	if !isUUID(uuid) {
+		return fmt.Errorf("invalid mediated device UUID %q", uuid)
+	}
+	path := filepath.Join(root, mdevDevicesDir, uuid, "remove")
+	if err := writeSysFile(path, "1"); err != nil {
+		return fmt.Errorf("failed to remove mediated device %s: %w", uuid, err)
+	}
+	return nil
+}
+
+// writeSysFile writes value to an existing sysfs attribute.
+func writeSysFile(path, value string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(value); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readSysString reads a sysfs attribute, returning "" on error.
+func readSysString(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
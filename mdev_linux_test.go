@@ -0,0 +1,71 @@
+//go:build linux
+
+package qemuctl
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMdevSysfs(t *testing.T) {
+	root := t.TempDir()
+	write := func(path, content string) {
+		t.Helper()
+		full := filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	typeDir := "sys/class/mdev_bus/0000:00:02.0/mdev_supported_types/i915-GVTg_V5_4/"
+	write(typeDir+"name", "GVTg_V5_4\n")
+	write(typeDir+"description", "low_gm_size: 128MB\nhigh_gm_size: 512MB\n")
+	write(typeDir+"device_api", "vfio-pci\n")
+	write(typeDir+"available_instances", "2\n")
+	write(typeDir+"create", "")
+
+	types, err := listMdevTypes(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []MdevType{{
+		Parent:      "0000:00:02.0",
+		Type:        "i915-GVTg_V5_4",
+		Name:        "GVTg_V5_4",
+		Description: "low_gm_size: 128MB\nhigh_gm_size: 512MB",
+		DeviceAPI:   "vfio-pci",
+		Available:   2,
+	}}
+	if !reflect.DeepEqual(types, want) {
+		t.Errorf("listMdevTypes() = %+v, want %+v", types, want)
+	}
+
+	uuid, err := createMdev(root, "0000:00:02.0", "i915-GVTg_V5_4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, typeDir, "create")); string(data) != uuid {
+		t.Errorf("create = %q, want %q", data, uuid)
+	}
+	if _, err := createMdev(root, "0000:00:02.0", "i915-GVTg_V5_8"); err == nil {
+		t.Error("createMdev() accepted an unknown type")
+	}
+
+	write("sys/bus/mdev/devices/"+uuid+"/remove", "")
+	if err := removeMdev(root, uuid); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "sys/bus/mdev/devices", uuid, "remove")); string(data) != "1" {
+		t.Errorf("remove = %q, want 1", data)
+	}
+
+	// No mediated device support at all is not an error
+	if types, err := listMdevTypes(t.TempDir()); err != nil || len(types) != 0 {
+		t.Errorf("listMdevTypes() without mdev_bus = %v, %v", types, err)
+	}
+}
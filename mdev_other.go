@@ -0,0 +1,24 @@
+//go:build !linux
+
+package qemuctl
+
+import "errors"
+
+// errMdevUnsupported is returned by the mediated device helpers outside
+// Linux.
+var errMdevUnsupported = errors.New("mediated devices are only supported on Linux")
+
+// ListMdevTypes is only supported on Linux.
+func ListMdevTypes() ([]MdevType, error) {
+	return nil, errMdevUnsupported
+}
+
+// CreateMdev is only supported on Linux.
+func CreateMdev(parent, typ string) (string, error) {
+	return "", errMdevUnsupported
+}
+
+// RemoveMdev is only supported on Linux.
+func RemoveMdev(uuid string) error {
+	return errMdevUnsupported
+}
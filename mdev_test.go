@@ -0,0 +1,53 @@
+package qemuctl
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestVMBuilderMdevs(t *testing.T) {
+	cfg := &VMConfig{Mdevs: []*MdevConfig{
+		{ID: "vgpu0", UUID: "a297db4a-f4c2-11e6-90f6-d3b88d6c9525", Display: true},
+	}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	args := NewVMBuilder(cfg).Build("test", "")
+	joined := strings.Join(args, " ")
+	want := "vfio-pci,id=vgpu0,sysfsdev=/sys/bus/mdev/devices/a297db4a-f4c2-11e6-90f6-d3b88d6c9525,display=on,bus=pcie.0,addr="
+	if !strings.Contains(joined, want) {
+		t.Errorf("args missing %q:\n%s", want, joined)
+	}
+
+	parsed, err := ParseArgs(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed.Mdevs, cfg.Mdevs) {
+		t.Errorf("parsed mediated devices = %+v, want %+v", parsed.Mdevs, cfg.Mdevs)
+	}
+
+	cfg.OnlyMigratable = true
+	var notMigratable *NotMigratableError
+	if err := cfg.Validate(); !errors.As(err, &notMigratable) {
+		t.Errorf("Validate() with OnlyMigratable = %v, want NotMigratableError", err)
+	}
+
+	bad := &VMConfig{Mdevs: []*MdevConfig{{ID: "vgpu0", UUID: "../../../dev/mem"}}}
+	if err := bad.Validate(); err == nil {
+		t.Error("Validate() accepted an invalid mediated device UUID")
+	}
+}
+
+func TestNewUUID(t *testing.T) {
+	a, b := newUUID(), newUUID()
+	if !isUUID(a) || a == b {
+		t.Errorf("newUUID() = %q, %q", a, b)
+	}
+	if a[14] != '4' {
+		t.Errorf("newUUID() = %q, want a version 4 UUID", a)
+	}
+}
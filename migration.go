@@ -0,0 +1,339 @@
+package qemuctl
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Migration capabilities, for SetMigrationCapabilities.
+const (
+	// MigrationCapDirtyBitmaps migrates the dirty bitmaps of block nodes,
+	// so incremental backup chains continue on the destination host.
+	MigrationCapDirtyBitmaps = "dirty-bitmaps"
+
+	// MigrationCapAutoConverge throttles the vCPUs of a guest dirtying
+	// memory faster than it can be sent, so the migration completes.
+	// MigrationParameters.CPUThrottleInitial and CPUThrottleIncrement
+	// tune the throttling.
+	MigrationCapAutoConverge = "auto-converge"
+
+	// MigrationCapPostcopyRAM allows switching a migration to post-copy,
+	// where the guest runs on the destination and fetches the memory not
+	// yet sent on demand.
+	MigrationCapPostcopyRAM = "postcopy-ram"
+
+	// MigrationCapMultifd sends memory over
+	// MigrationParameters.MultifdChannels connections in parallel, and
+	// enables MultifdCompression.
+	MigrationCapMultifd = "multifd"
+
+	// MigrationCapEvents sends MIGRATION events on status changes.
+	MigrationCapEvents = "events"
+
+	// MigrationCapXBZRLE sends pages dirtied again as deltas from a cache
+	// of MigrationParameters.XBZRLECacheSize bytes.
+	MigrationCapXBZRLE = "xbzrle"
+)
+
+// Migration statuses, as reported by MigrationStatus.
+const (
+	MigrationStatusNone            = "none"
+	MigrationStatusSetup           = "setup"
+	MigrationStatusActive          = "active"
+	MigrationStatusPostcopyActive  = "postcopy-active"
+	MigrationStatusPostcopyPaused  = "postcopy-paused"
+	MigrationStatusPostcopyRecover = "postcopy-recover"
+	MigrationStatusCompleted       = "completed"
+	MigrationStatusFailed          = "failed"
+	MigrationStatusCancelled       = "cancelled"
+)
+
+// migrationPollInterval is how often WaitMigration polls the migration
+// status.
+var migrationPollInterval = 500 * time.Millisecond
+
+// MigrationInfo is the status of the current or last migration.
+type MigrationInfo struct {
+	// Status is one of the MigrationStatus constants, or another status
+	// QEMU reports, such as "pre-switchover".
+	Status string `json:"status"`
+
+	// TotalTime is the time since the migration started, in
+	// milliseconds.
+	TotalTime int64 `json:"total-time,omitempty"`
+
+	// ExpectedDowntime is the estimated downtime of an active migration,
+	// and Downtime the actual one of a completed migration, in
+	// milliseconds.
+	ExpectedDowntime int64 `json:"expected-downtime,omitempty"`
+	Downtime         int64 `json:"downtime,omitempty"`
+
+	// RAM are the memory transfer statistics.
+	RAM *MigrationRAMStats `json:"ram,omitempty"`
+
+	// ErrorDesc describes why the migration failed.
+	ErrorDesc string `json:"error-desc,omitempty"`
+}
+
+// MigrationRAMStats are the memory transfer statistics of a migration.
+type MigrationRAMStats struct {
+	// Transferred, Remaining and Total are in bytes.
+	Transferred int64 `json:"transferred"`
+	Remaining   int64 `json:"remaining"`
+	Total       int64 `json:"total"`
+
+	// DirtyPagesRate is the rate the guest dirties pages at, per second.
+	DirtyPagesRate int64 `json:"dirty-pages-rate,omitempty"`
+
+	// PostcopyRequests is the number of pages the destination requested
+	// during post-copy.
+	PostcopyRequests int64 `json:"postcopy-requests,omitempty"`
+}
+
+// Migrate starts migrating the VM to uri: "tcp:host:port", "unix:path" or
+// "exec:command". The destination must have been started with
+// -incoming. Migrate returns once the migration has started; see
+// WaitMigration and MigrationStatus.
+func (i *Instance) Migrate(uri string) error {
+	return i.migrate(uri, false)
+}
+
+// migrate runs the migrate command, resuming a paused post-copy migration
+// if resume is set.
+func (i *Instance) migrate(uri string, resume bool) error {
+	if uri == "" {
+		return fmt.Errorf("migration needs a URI")
+	}
+
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return ErrNotConnected
+	}
+
+	args := map[string]any{"uri": uri}
+	if resume {
+		args["resume"] = true
+	}
+	_, err := qmp.Execute("migrate", args)
+	return err
+}
+
+// MigrationStatus returns the status of the current or last migration.
+func (i *Instance) MigrationStatus() (*MigrationInfo, error) {
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return nil, ErrNotConnected
+	}
+
+	result, err := qmp.Execute("query-migrate", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var info MigrationInfo
+	if err := unmarshalJSON(result, &info); err != nil {
+		return nil, err
+	}
+	if info.Status == "" {
+		info.Status = MigrationStatusNone
+	}
+	return &info, nil
+}
+
+// WaitMigration waits until the migration completes. It returns
+// ErrPostcopyPaused if a post-copy migration lost its connection, which
+// MigrateRecover and MigrateResume resume, and an error if the migration
+// failed or was cancelled.
+func (i *Instance) WaitMigration(ctx context.Context) (*MigrationInfo, error) {
+	for {
+		info, err := i.MigrationStatus()
+		if err != nil {
+			return nil, err
+		}
+		switch info.Status {
+		case MigrationStatusCompleted:
+			return info, nil
+		case MigrationStatusPostcopyPaused:
+			return info, ErrPostcopyPaused
+		case MigrationStatusFailed:
+			return info, fmt.Errorf("migration failed: %s", info.ErrorDesc)
+		case MigrationStatusCancelled, MigrationStatusNone:
+			return info, fmt.Errorf("migration %s", info.Status)
+		}
+		if err := sleepContext(ctx, migrationPollInterval); err != nil {
+			return info, err
+		}
+	}
+}
+
+// migrationCapability is an entry of migrate-set-capabilities and
+// query-migrate-capabilities.
+type migrationCapability struct {
+	Capability string `json:"capability"`
+	State      bool   `json:"state"`
+}
+
+// SetMigrationCapabilities enables or disables migration capabilities.
+// Capabilities must be set identically on the source and the destination
+// before migrating.
+func (i *Instance) SetMigrationCapabilities(caps map[string]bool) error {
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return ErrNotConnected
+	}
+
+	list := make([]migrationCapability, 0, len(caps))
+	for name, state := range caps {
+		list = append(list, migrationCapability{Capability: name, State: state})
+	}
+	_, err := qmp.Execute("migrate-set-capabilities", map[string]any{"capabilities": list})
+	return err
+}
+
+// MigrationCapabilities returns the state of all migration capabilities.
+func (i *Instance) MigrationCapabilities() (map[string]bool, error) {
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return nil, ErrNotConnected
+	}
+
+	result, err := qmp.Execute("query-migrate-capabilities", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []migrationCapability
+	if err := unmarshalJSON(result, &list); err != nil {
+		return nil, err
+	}
+	caps := make(map[string]bool, len(list))
+	for _, c := range list {
+		caps[c.Capability] = c.State
+	}
+	return caps, nil
+}
+
+// MigrationParameters are the tunables of migrations. Zero fields are left
+// unchanged by SetMigrationParameters.
+type MigrationParameters struct {
+	// MaxBandwidth is the bandwidth limit of the migration, in bytes per
+	// second.
+	MaxBandwidth int64 `json:"max-bandwidth,omitempty"`
+
+	// DowntimeLimit is the longest the guest may be paused to send the
+	// last dirty memory, in milliseconds. QEMU's default is 300.
+	DowntimeLimit int64 `json:"downtime-limit,omitempty"`
+
+	// MultifdChannels is the number of connections memory is sent over,
+	// with MigrationCapMultifd.
+	MultifdChannels int `json:"multifd-channels,omitempty"`
+
+	// MultifdCompression is the compression of multifd channels: "none",
+	// "zlib" or "zstd".
+	MultifdCompression string `json:"multifd-compression,omitempty"`
+
+	// XBZRLECacheSize is the size of the cache of MigrationCapXBZRLE, in
+	// bytes.
+	XBZRLECacheSize int64 `json:"xbzrle-cache-size,omitempty"`
+
+	// CPUThrottleInitial and CPUThrottleIncrement are the initial vCPU
+	// throttling of MigrationCapAutoConverge and its increments, in
+	// percent.
+	CPUThrottleInitial   int `json:"cpu-throttle-initial,omitempty"`
+	CPUThrottleIncrement int `json:"cpu-throttle-increment,omitempty"`
+
+	// MaxPostcopyBandwidth is the bandwidth limit of the post-copy phase,
+	// in bytes per second. Zero is unlimited.
+	MaxPostcopyBandwidth int64 `json:"max-postcopy-bandwidth,omitempty"`
+}
+
+// args returns the migrate-set-parameters arguments.
+func (p *MigrationParameters) args() (map[string]any, error) {
+	args := make(map[string]any)
+	for _, v := range []struct {
+		name  string
+		value int64
+	}{
+		{"max-bandwidth", p.MaxBandwidth},
+		{"downtime-limit", p.DowntimeLimit},
+		{"multifd-channels", int64(p.MultifdChannels)},
+		{"xbzrle-cache-size", p.XBZRLECacheSize},
+		{"cpu-throttle-initial", int64(p.CPUThrottleInitial)},
+		{"cpu-throttle-increment", int64(p.CPUThrottleIncrement)},
+		{"max-postcopy-bandwidth", p.MaxPostcopyBandwidth},
+	} {
+		switch {
+		case v.value < 0:
+			return nil, fmt.Errorf("negative migration parameter %s", v.name)
+		case v.value > 0:
+			args[v.name] = v.value
+		}
+	}
+	switch p.MultifdCompression {
+	case "":
+	case "none", "zlib", "zstd":
+		args["multifd-compression"] = p.MultifdCompression
+	default:
+		return nil, fmt.Errorf("unknown multifd compression %q", p.MultifdCompression)
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("no migration parameter set")
+	}
+	return args, nil
+}
+
+// SetMigrationParameters sets the migration parameters that are not zero
+// in p. They apply to the next migration, and bandwidth and downtime
+// limits also to the running one.
+func (i *Instance) SetMigrationParameters(p *MigrationParameters) error {
+	args, err := p.args()
+	if err != nil {
+		return err
+	}
+
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return ErrNotConnected
+	}
+
+	_, err = qmp.Execute("migrate-set-parameters", args)
+	return err
+}
+
+// MigrationParameters returns the current migration parameters.
+func (i *Instance) MigrationParameters() (*MigrationParameters, error) {
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return nil, ErrNotConnected
+	}
+
+	result, err := qmp.Execute("query-migrate-parameters", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var p MigrationParameters
+	if err := unmarshalJSON(result, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
@@ -0,0 +1,55 @@
+package qemuctl
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMigrationParameters(t *testing.T) {
+	fake := newFakeQMP(t)
+	fake.handle("migrate-set-parameters", func(map[string]any) (any, *qmpError) { return map[string]any{}, nil })
+	fake.handle("query-migrate-parameters", func(map[string]any) (any, *qmpError) {
+		return map[string]any{
+			"max-bandwidth":       134217728,
+			"downtime-limit":      300,
+			"multifd-channels":    2,
+			"multifd-compression": "none",
+			"announce-rounds":     5,
+		}, nil
+	})
+	inst := fake.attach()
+
+	err := inst.SetMigrationParameters(&MigrationParameters{
+		MaxBandwidth:       1 << 30,
+		DowntimeLimit:      500,
+		MultifdChannels:    4,
+		MultifdCompression: "zstd",
+	})
+	if err != nil {
+		t.Fatalf("SetMigrationParameters failed: %v", err)
+	}
+	want := []map[string]any{{
+		"max-bandwidth":       float64(1 << 30),
+		"downtime-limit":      float64(500),
+		"multifd-channels":    float64(4),
+		"multifd-compression": "zstd",
+	}}
+	if got := fake.commands("migrate-set-parameters"); !reflect.DeepEqual(got, want) {
+		t.Errorf("migrate-set-parameters = %v, want %v", got, want)
+	}
+
+	for _, p := range []*MigrationParameters{{}, {DowntimeLimit: -1}, {MultifdCompression: "lz4"}} {
+		if err := inst.SetMigrationParameters(p); err == nil {
+			t.Errorf("SetMigrationParameters(%+v) succeeded", p)
+		}
+	}
+
+	got, err := inst.MigrationParameters()
+	if err != nil {
+		t.Fatalf("MigrationParameters failed: %v", err)
+	}
+	wantParams := &MigrationParameters{MaxBandwidth: 134217728, DowntimeLimit: 300, MultifdChannels: 2, MultifdCompression: "none"}
+	if !reflect.DeepEqual(got, wantParams) {
+		t.Errorf("MigrationParameters = %+v, want %+v", got, wantParams)
+	}
+}
@@ -0,0 +1,58 @@
+package qemuctl
+
+// NamespaceConfig configures Linux namespace isolation for the QEMU process.
+// Setting up namespaces requires root (CAP_SYS_ADMIN).
+type NamespaceConfig struct {
+	// Mount runs QEMU in a new private mount namespace.
+	// It is implied by PrivateDev, PrivateTmp and BindMounts.
+	Mount bool `json:"mount,omitempty"`
+
+	// PrivateDev replaces /dev with a minimal tmpfs containing only the
+	// device nodes QEMU needs (null, zero, random, urandom, kvm, net/tun,
+	// vhost-net, vhost-vsock) plus any listed in Devices.
+	PrivateDev bool `json:"private_dev,omitempty"`
+
+	// Devices lists additional host device nodes to expose in the private /dev.
+	Devices []string `json:"devices,omitempty"`
+
+	// PrivateTmp mounts an empty tmpfs on /tmp.
+	PrivateTmp bool `json:"private_tmp,omitempty"`
+
+	// BindMounts are bind-mounted into the mount namespace, typically to
+	// expose disk images at stable paths.
+	BindMounts []BindMount `json:"bind_mounts,omitempty"`
+
+	// NetNS is the path of an existing network namespace to join
+	// (e.g., "/var/run/netns/vm0"), usually holding a pre-created tap or veth.
+	NetNS string `json:"net_ns,omitempty"`
+}
+
+// BindMount describes a bind mount inside the QEMU mount namespace.
+type BindMount struct {
+	// Source is the host path.
+	Source string `json:"source"`
+
+	// Target is the path inside the namespace. Defaults to Source.
+	Target string `json:"target,omitempty"`
+
+	// ReadOnly remounts the bind mount read-only.
+	ReadOnly bool `json:"read_only,omitempty"`
+}
+
+// defaultNamespaceDevices are the device nodes exposed in a private /dev.
+var defaultNamespaceDevices = []string{
+	"/dev/null",
+	"/dev/zero",
+	"/dev/full",
+	"/dev/random",
+	"/dev/urandom",
+	"/dev/kvm",
+	"/dev/net/tun",
+	"/dev/vhost-net",
+	"/dev/vhost-vsock",
+}
+
+// needsMount reports whether the configuration requires a mount namespace.
+func (n *NamespaceConfig) needsMount() bool {
+	return n.Mount || n.PrivateDev || n.PrivateTmp || len(n.BindMounts) > 0
+}
@@ -0,0 +1,157 @@
+//go:build linux
+
+package qemuctl
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// applyNamespaces rewrites cmd so QEMU runs inside the configured namespaces.
+// Mounts are prepared by a small shell wrapper that runs in the new mount
+// namespace and then execs QEMU; joining a network namespace uses nsenter.
+func applyNamespaces(cmd *exec.Cmd, ns *NamespaceConfig) error {
+	if ns == nil {
+		return nil
+	}
+
+	qemuArgs := cmd.Args[1:]
+	argv := []string{cmd.Path}
+	argv = append(argv, qemuArgs...)
+
+	if ns.needsMount() {
+		script, err := buildNamespaceScript(ns)
+		if err != nil {
+			return err
+		}
+
+		sh, err := exec.LookPath("sh")
+		if err != nil {
+			return fmt.Errorf("namespace setup requires sh: %w", err)
+		}
+
+		argv = append([]string{sh, "-c", script}, argv...)
+
+		if cmd.SysProcAttr == nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		// Unsharing the mount namespace also makes / recursively private.
+		cmd.SysProcAttr.Unshareflags |= syscall.CLONE_NEWNS
+	}
+
+	if ns.NetNS != "" {
+		if _, err := os.Stat(ns.NetNS); err != nil {
+			return fmt.Errorf("network namespace not found: %w", err)
+		}
+
+		nsenter, err := exec.LookPath("nsenter")
+		if err != nil {
+			return fmt.Errorf("joining a network namespace requires nsenter: %w", err)
+		}
+
+		argv = append([]string{nsenter, "--net=" + ns.NetNS, "--"}, argv...)
+	}
+
+	cmd.Path = argv[0]
+	cmd.Args = argv
+	return nil
+}
+
+// buildNamespaceScript builds the shell script that prepares the mount
+// namespace. The QEMU binary and its arguments are passed as $0 and $@.
+func buildNamespaceScript(ns *NamespaceConfig) (string, error) {
+	var lines []string
+	lines = append(lines, "set -e")
+
+	if ns.PrivateDev {
+		devices := append(append([]string{}, defaultNamespaceDevices...), ns.Devices...)
+
+		var nodes []string
+		for _, dev := range devices {
+			node, err := mknodCommand(dev)
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return "", err
+			}
+			nodes = append(nodes, node)
+		}
+
+		lines = append(lines,
+			"mount -t tmpfs -o mode=0755,nosuid,noexec dev /dev",
+			"mkdir -p /dev/net /dev/shm /dev/pts",
+			"mount -t tmpfs -o mode=1777,nosuid,nodev shm /dev/shm",
+			"ln -s /proc/self/fd /dev/fd",
+		)
+		lines = append(lines, nodes...)
+	}
+
+	if ns.PrivateTmp {
+		lines = append(lines, "mount -t tmpfs -o mode=1777,nosuid,nodev tmp /tmp")
+	}
+
+	for _, bm := range ns.BindMounts {
+		if bm.Source == "" {
+			return "", fmt.Errorf("bind mount without source")
+		}
+		target := bm.Target
+		if target == "" {
+			target = bm.Source
+		}
+
+		info, err := os.Stat(bm.Source)
+		if err != nil {
+			return "", fmt.Errorf("bind mount source: %w", err)
+		}
+		if info.IsDir() {
+			lines = append(lines, "mkdir -p "+shellQuote(target))
+		} else {
+			lines = append(lines,
+				"mkdir -p "+shellQuote(filepath.Dir(target)),
+				"[ -e "+shellQuote(target)+" ] || : > "+shellQuote(target))
+		}
+
+		lines = append(lines, "mount --bind "+shellQuote(bm.Source)+" "+shellQuote(target))
+		if bm.ReadOnly {
+			lines = append(lines, "mount -o remount,bind,ro "+shellQuote(target))
+		}
+	}
+
+	lines = append(lines, `exec "$0" "$@"`)
+	return strings.Join(lines, "\n"), nil
+}
+
+// mknodCommand returns a shell command recreating the given host device node.
+func mknodCommand(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || info.Mode()&os.ModeDevice == 0 {
+		return "", fmt.Errorf("%s is not a device node", path)
+	}
+
+	kind := "b"
+	if info.Mode()&os.ModeCharDevice != 0 {
+		kind = "c"
+	}
+
+	dev := uint64(st.Rdev)
+	major := (dev>>8)&0xfff | (dev>>32)&^0xfff
+	minor := dev&0xff | (dev>>12)&^0xff
+
+	return fmt.Sprintf("mknod -m %o %s %s %d %d",
+		info.Mode().Perm(), shellQuote(path), kind, major, minor), nil
+}
+
+// shellQuote quotes s for safe use in a POSIX shell command.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
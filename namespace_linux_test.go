@@ -0,0 +1,74 @@
+//go:build linux
+
+package qemuctl
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestBuildNamespaceScript(t *testing.T) {
+	img, err := os.CreateTemp(t.TempDir(), "disk-*.qcow2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img.Close()
+
+	ns := &NamespaceConfig{
+		PrivateDev: true,
+		PrivateTmp: true,
+		BindMounts: []BindMount{
+			{Source: img.Name(), Target: "/images/disk's.qcow2", ReadOnly: true},
+		},
+	}
+
+	script, err := buildNamespaceScript(ns)
+	if err != nil {
+		t.Fatalf("buildNamespaceScript error: %v", err)
+	}
+
+	for _, want := range []string{
+		"mount -t tmpfs -o mode=0755,nosuid,noexec dev /dev",
+		"mknod -m 666 '/dev/null' c 1 3",
+		"mount -t tmpfs -o mode=1777,nosuid,nodev tmp /tmp",
+		`mount --bind '` + img.Name() + `' '/images/disk'\''s.qcow2'`,
+		`mount -o remount,bind,ro '/images/disk'\''s.qcow2'`,
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("expected script to contain %q, got:\n%s", want, script)
+		}
+	}
+	if !strings.HasSuffix(script, `exec "$0" "$@"`) {
+		t.Errorf("expected script to exec QEMU, got:\n%s", script)
+	}
+}
+
+func TestApplyNamespaces(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+
+	cmd := exec.Command("/usr/bin/qemu-system-x86_64", "-m", "512")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if err := applyNamespaces(cmd, &NamespaceConfig{Mount: true}); err != nil {
+		t.Fatalf("applyNamespaces error: %v", err)
+	}
+
+	if cmd.SysProcAttr.Unshareflags&syscall.CLONE_NEWNS == 0 {
+		t.Error("expected CLONE_NEWNS in Unshareflags")
+	}
+	if cmd.Args[1] != "-c" {
+		t.Errorf("expected shell wrapper, got %v", cmd.Args)
+	}
+	if got := strings.Join(cmd.Args[3:], " "); got != "/usr/bin/qemu-system-x86_64 -m 512" {
+		t.Errorf("unexpected wrapped args: %s", got)
+	}
+
+	if err := applyNamespaces(exec.Command("true"), &NamespaceConfig{NetNS: "/nonexistent/netns"}); err == nil {
+		t.Error("expected error for missing network namespace")
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package qemuctl
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// applyNamespaces is unsupported on this platform.
+func applyNamespaces(cmd *exec.Cmd, ns *NamespaceConfig) error {
+	if ns == nil {
+		return nil
+	}
+	return fmt.Errorf("namespace isolation is only supported on Linux")
+}
@@ -0,0 +1,258 @@
+package qemuctl
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// NBD protocol constants, from the NBD protocol specification.
+const (
+	nbdMagic         = 0x4e42444d41474943 // "NBDMAGIC"
+	nbdOptMagic      = 0x49484156454f5054 // "IHAVEOPT"
+	nbdRequestMagic  = 0x25609513
+	nbdReplyMagic    = 0x67446698
+	nbdFlagFixed     = 1 << 0
+	nbdFlagNoZeroes  = 1 << 1
+	nbdOptExportName = 1
+	nbdCmdRead       = 0
+	nbdCmdDisconnect = 2
+
+	// nbdMaxRead is the largest read request sent, well under the 32 MiB
+	// QEMU accepts.
+	nbdMaxRead = 4 << 20
+)
+
+// StartNBDServer starts the NBD server of the running VM on a Unix socket.
+// A QEMU process runs at most one NBD server.
+func (i *Instance) StartNBDServer(socketPath string) error {
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return ErrNotConnected
+	}
+
+	_, err := qmp.Execute("nbd-server-start", map[string]any{
+		"addr": map[string]any{"type": "unix", "path": socketPath},
+	})
+	return err
+}
+
+// StopNBDServer stops the NBD server, removing its exports.
+func (i *Instance) StopNBDServer() error {
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return ErrNotConnected
+	}
+
+	_, err := qmp.Execute("nbd-server-stop", nil)
+	return err
+}
+
+// AddNBDExport exports block node node read-only on the NBD server, under
+// the export name name.
+func (i *Instance) AddNBDExport(node, name string) error {
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return ErrNotConnected
+	}
+
+	_, err := qmp.Execute("block-export-add", map[string]any{
+		"type":      "nbd",
+		"id":        name,
+		"node-name": node,
+		"name":      name,
+		"writable":  false,
+	})
+	return err
+}
+
+// RemoveNBDExport removes an export added with AddNBDExport.
+func (i *Instance) RemoveNBDExport(name string) error {
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return ErrNotConnected
+	}
+
+	_, err := qmp.Execute("block-export-del", map[string]any{"id": name})
+	return err
+}
+
+// NBDClient reads an NBD export, such as one added with AddNBDExport or
+// served by qemu-nbd. It implements io.ReaderAt.
+type NBDClient struct {
+	mu     sync.Mutex
+	conn   net.Conn
+	size   int64
+	handle uint64
+}
+
+// DialNBD connects to the export name of the NBD server listening on a
+// Unix socket.
+func DialNBD(ctx context.Context, socketPath, name string) (*NBDClient, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	c := &NBDClient{conn: conn}
+	if err := c.handshake(name); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("NBD handshake failed: %w", err)
+	}
+	return c, nil
+}
+
+// handshake negotiates the export with the fixed newstyle handshake.
+func (c *NBDClient) handshake(name string) error {
+	var greeting struct {
+		Magic    uint64
+		OptMagic uint64
+		Flags    uint16
+	}
+	if err := binary.Read(c.conn, binary.BigEndian, &greeting); err != nil {
+		return err
+	}
+	if greeting.Magic != nbdMagic || greeting.OptMagic != nbdOptMagic {
+		return errors.New("not a newstyle NBD server")
+	}
+	if greeting.Flags&nbdFlagFixed == 0 {
+		return errors.New("server does not support the fixed newstyle handshake")
+	}
+	clientFlags := uint32(nbdFlagFixed)
+	noZeroes := greeting.Flags&nbdFlagNoZeroes != 0
+	if noZeroes {
+		clientFlags |= nbdFlagNoZeroes
+	}
+
+	msg := binary.BigEndian.AppendUint32(nil, clientFlags)
+	msg = binary.BigEndian.AppendUint64(msg, nbdOptMagic)
+	msg = binary.BigEndian.AppendUint32(msg, nbdOptExportName)
+	msg = binary.BigEndian.AppendUint32(msg, uint32(len(name)))
+	msg = append(msg, name...)
+	if _, err := c.conn.Write(msg); err != nil {
+		return err
+	}
+
+	// The server closes the connection if the export does not exist
+	var export struct {
+		Size  uint64
+		Flags uint16
+	}
+	if err := binary.Read(c.conn, binary.BigEndian, &export); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("export %q not found", name)
+		}
+		return err
+	}
+	if !noZeroes {
+		if _, err := io.CopyN(io.Discard, c.conn, 124); err != nil {
+			return err
+		}
+	}
+	c.size = int64(export.Size)
+	return nil
+}
+
+// Size returns the export size in bytes.
+func (c *NBDClient) Size() int64 {
+	return c.size
+}
+
+// ReadAt reads len(p) bytes from the export at offset off.
+func (c *NBDClient) ReadAt(p []byte, off int64) (int, error) {
+	if off >= c.size {
+		return 0, io.EOF
+	}
+	want := len(p)
+	if rest := c.size - off; int64(want) > rest {
+		want = int(rest)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for n < want {
+		chunk := want - n
+		if chunk > nbdMaxRead {
+			chunk = nbdMaxRead
+		}
+		if err := c.read(p[n:n+chunk], off+int64(n)); err != nil {
+			return n, err
+		}
+		n += chunk
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// read runs a read command.
+func (c *NBDClient) read(p []byte, off int64) error {
+	c.handle++
+	if err := c.request(nbdCmdRead, uint64(off), uint32(len(p))); err != nil {
+		return err
+	}
+
+	var reply struct {
+		Magic  uint32
+		Error  uint32
+		Handle uint64
+	}
+	if err := binary.Read(c.conn, binary.BigEndian, &reply); err != nil {
+		return err
+	}
+	switch {
+	case reply.Magic != nbdReplyMagic:
+		return fmt.Errorf("unexpected NBD reply magic %#x", reply.Magic)
+	case reply.Handle != c.handle:
+		return fmt.Errorf("unexpected NBD reply handle %d", reply.Handle)
+	case reply.Error != 0:
+		return fmt.Errorf("NBD read at %d failed with error %d", off, reply.Error)
+	}
+	_, err := io.ReadFull(c.conn, p)
+	return err
+}
+
+// request sends a command.
+func (c *NBDClient) request(cmd uint16, off uint64, length uint32) error {
+	msg := binary.BigEndian.AppendUint32(nil, nbdRequestMagic)
+	msg = binary.BigEndian.AppendUint16(msg, 0)
+	msg = binary.BigEndian.AppendUint16(msg, cmd)
+	msg = binary.BigEndian.AppendUint64(msg, c.handle)
+	msg = binary.BigEndian.AppendUint64(msg, off)
+	msg = binary.BigEndian.AppendUint32(msg, length)
+	_, err := c.conn.Write(msg)
+	return err
+}
+
+// Close disconnects from the server.
+func (c *NBDClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handle++
+	c.request(nbdCmdDisconnect, 0, 0)
+	return c.conn.Close()
+}
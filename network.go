@@ -2,25 +2,154 @@ package qemuctl
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 )
 
 // NetworkConfig configures a network device.
 type NetworkConfig struct {
 	// ID is the netdev/device ID.
-	ID string
+	ID string `json:"id,omitempty"`
 
 	// Backend configures the network backend.
-	Backend NetworkBackend
+	Backend NetworkBackend `json:"backend,omitempty"`
 
 	// Model is the NIC model ("virtio-net-pci", "e1000", "rtl8139").
-	Model string
+	Model string `json:"model,omitempty"`
 
 	// MACAddr is the MAC address.
-	MACAddr string
+	MACAddr string `json:"mac_addr,omitempty"`
 
 	// BootIndex sets the boot priority for network boot.
-	BootIndex int
+	BootIndex int `json:"boot_index,omitempty"`
+
+	// MTU is the MTU advertised to the guest (host_mtu), for jumbo-frame
+	// networks. The backend must carry frames of that size. virtio-net only.
+	MTU int `json:"mtu,omitempty"`
+
+	// Offloads toggles virtio-net offloads.
+	Offloads *NICOffloads `json:"offloads,omitempty"`
+
+	// RxQueueSize and TxQueueSize are the virtio-net ring sizes, a power
+	// of two from 256 to 1024. Zero keeps QEMU's default of 256. Larger
+	// rings absorb bursts at high packet rates.
+	RxQueueSize int `json:"rx_queue_size,omitempty"`
+	TxQueueSize int `json:"tx_queue_size,omitempty"`
+
+	// CaptureFile, if set, is a pcap file QEMU writes the packets of the
+	// NIC to, in both directions, through a filter-dump object. See
+	// Instance.StartCapture to capture at runtime.
+	CaptureFile string `json:"capture_file,omitempty"`
+}
+
+// NICOffloads toggles virtio-net offloads. Unset fields keep QEMU's
+// default, which is on.
+type NICOffloads struct {
+	// Checksum is checksum offload in both directions (csum, guest_csum).
+	Checksum *bool `json:"checksum,omitempty"`
+
+	// GSO is generic segmentation offload of guest packets (gso).
+	GSO *bool `json:"gso,omitempty"`
+
+	// TSO is TCP segmentation offload for IPv4 and IPv6 in both
+	// directions (host_tso4, host_tso6, guest_tso4, guest_tso6). It needs
+	// checksum offload.
+	TSO *bool `json:"tso,omitempty"`
+}
+
+// validate checks the backend and virtio-net options of the NIC.
+func (cfg *NetworkConfig) validate() error {
+	switch b := cfg.Backend.(type) {
+	case *VmnetSharedNetBackend:
+		if n := countSet(b.StartAddress, b.EndAddress, b.SubnetMask); n != 0 && n != 3 {
+			return fmt.Errorf("network %s: vmnet-shared needs the start address, end address and subnet mask together", cfg.ID)
+		}
+	case *VmnetBridgedNetBackend:
+		if b.Ifname == "" {
+			return fmt.Errorf("network %s: vmnet-bridged needs a host interface", cfg.ID)
+		}
+	case *PasstNetBackend:
+		if err := b.validate(cfg.ID); err != nil {
+			return err
+		}
+	case *TapNetBackend:
+		if err := b.validate(cfg.ID); err != nil {
+			return err
+		}
+	}
+
+	virtio := cfg.Model == "" || strings.HasPrefix(cfg.Model, "virtio-net")
+	switch {
+	case !virtio && (cfg.MTU != 0 || cfg.Offloads != nil || cfg.RxQueueSize != 0 || cfg.TxQueueSize != 0):
+		return fmt.Errorf("network %s: MTU, offloads and queue sizes need a virtio-net NIC, not %s", cfg.ID, cfg.Model)
+	case cfg.MTU != 0 && (cfg.MTU < 68 || cfg.MTU > 65535):
+		return fmt.Errorf("network %s: MTU %d out of range 68-65535", cfg.ID, cfg.MTU)
+	case !validQueueSize(cfg.RxQueueSize):
+		return fmt.Errorf("network %s: rx queue size %d is not a power of two from 256 to 1024", cfg.ID, cfg.RxQueueSize)
+	case !validQueueSize(cfg.TxQueueSize):
+		return fmt.Errorf("network %s: tx queue size %d is not a power of two from 256 to 1024", cfg.ID, cfg.TxQueueSize)
+	}
+	if o := cfg.Offloads; o != nil && o.TSO != nil && *o.TSO && o.Checksum != nil && !*o.Checksum {
+		return fmt.Errorf("network %s: TSO needs checksum offload", cfg.ID)
+	}
+	return nil
+}
+
+// countSet returns the number of non-empty values.
+func countSet(values ...string) int {
+	n := 0
+	for _, v := range values {
+		if v != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// netdevID returns the netdev ID of the network, which defaults to "net0".
+func (cfg *NetworkConfig) netdevID() string {
+	if cfg.ID == "" {
+		return "net0"
+	}
+	return cfg.ID
+}
+
+func validQueueSize(n int) bool {
+	return n == 0 || (n >= 256 && n <= 1024 && n&(n-1) == 0)
+}
+
+// virtioNetOpts returns the device options for the MTU, offloads and ring
+// sizes.
+func (cfg *NetworkConfig) virtioNetOpts() []string {
+	var opts []string
+	if cfg.MTU > 0 {
+		opts = append(opts, "host_mtu="+strconv.Itoa(cfg.MTU))
+	}
+	if o := cfg.Offloads; o != nil {
+		onOff := func(v bool) string {
+			if v {
+				return "on"
+			}
+			return "off"
+		}
+		if o.Checksum != nil {
+			opts = append(opts, "csum="+onOff(*o.Checksum), "guest_csum="+onOff(*o.Checksum))
+		}
+		if o.GSO != nil {
+			opts = append(opts, "gso="+onOff(*o.GSO))
+		}
+		if o.TSO != nil {
+			v := onOff(*o.TSO)
+			opts = append(opts, "host_tso4="+v, "host_tso6="+v, "guest_tso4="+v, "guest_tso6="+v)
+		}
+	}
+	if cfg.RxQueueSize > 0 {
+		opts = append(opts, "rx_queue_size="+strconv.Itoa(cfg.RxQueueSize))
+	}
+	if cfg.TxQueueSize > 0 {
+		opts = append(opts, "tx_queue_size="+strconv.Itoa(cfg.TxQueueSize))
+	}
+	return opts
 }
 
 // NetworkBackend is the interface for network backends.
@@ -35,22 +164,22 @@ type NetworkBackend interface {
 // UserNetBackend provides user-mode networking (NAT).
 type UserNetBackend struct {
 	// Hostfwd configures port forwarding (e.g., "tcp::2222-:22").
-	Hostfwd []string
+	Hostfwd []string `json:"hostfwd,omitempty"`
 
 	// Net is the guest network (e.g., "10.0.2.0/24").
-	Net string
+	Net string `json:"net,omitempty"`
 
 	// Host is the host address in guest network.
-	Host string
+	Host string `json:"host,omitempty"`
 
 	// DNS is the DNS server address.
-	DNS string
+	DNS string `json:"dns,omitempty"`
 
 	// DHCPStart is the first DHCP address.
-	DHCPStart string
+	DHCPStart string `json:"dhcp_start,omitempty"`
 
 	// Restrict isolates guest from host.
-	Restrict bool
+	Restrict bool `json:"restrict,omitempty"`
 }
 
 func (u *UserNetBackend) Type() string { return "user" }
@@ -85,29 +214,74 @@ func (u *UserNetBackend) BuildNetdevArgs(id string) []string {
 // TapNetBackend provides TAP device networking.
 type TapNetBackend struct {
 	// Ifname is the TAP interface name.
-	Ifname string
+	Ifname string `json:"ifname,omitempty"`
 
 	// Bridge is the bridge to attach to.
-	Bridge string
+	Bridge string `json:"bridge,omitempty"`
 
 	// Script is the interface up script ("no" to disable).
-	Script string
+	Script string `json:"script,omitempty"`
 
 	// DownScript is the interface down script ("no" to disable).
-	DownScript string
+	DownScript string `json:"down_script,omitempty"`
 
 	// VHost enables vhost-net acceleration.
-	VHost bool
+	VHost bool `json:"vhost,omitempty"`
 
 	// Queues is the number of queues (for multiqueue).
-	Queues int
+	Queues int `json:"queues,omitempty"`
 
 	// FD is a pre-opened TAP file descriptor.
-	FD int
+	FD int `json:"fd,omitempty"`
+
+	// FDs are pre-opened descriptors of the queues of a multi-queue TAP
+	// interface.
+	FDs []int `json:"fds,omitempty"`
+
+	// Tap is an interface created by CreateTap, whose descriptors are
+	// passed to QEMU when the VM starts, in place of FD or FDs.
+	Tap *Tap `json:"-"`
 }
 
 func (t *TapNetBackend) Type() string { return "tap" }
 
+// queues returns the number of queues of the backend.
+func (t *TapNetBackend) queues() int {
+	switch {
+	case t.Tap != nil:
+		return len(t.Tap.Files)
+	case len(t.FDs) > 0:
+		return len(t.FDs)
+	}
+	return t.Queues
+}
+
+// validate rejects options that QEMU does not accept with pre-opened
+// descriptors.
+func (t *TapNetBackend) validate(id string) error {
+	opened := 0
+	if t.FD > 0 {
+		opened++
+	}
+	if len(t.FDs) > 0 {
+		opened++
+	}
+	if t.Tap != nil {
+		opened++
+	}
+	switch {
+	case opened == 0:
+		return nil
+	case opened > 1:
+		return fmt.Errorf("network %s: tap fd, fds and Tap are exclusive", id)
+	case t.Ifname != "" || t.Bridge != "" || t.Script != "" || t.DownScript != "" || t.Queues != 0:
+		return fmt.Errorf("network %s: tap ifname, bridge, scripts and queues cannot be set with pre-opened descriptors", id)
+	case t.Tap != nil && len(t.Tap.Files) == 0:
+		return fmt.Errorf("network %s: tap %s has no open queue", id, t.Tap.Name)
+	}
+	return nil
+}
+
 func (t *TapNetBackend) BuildNetdevArgs(id string) []string {
 	var parts []string
 	parts = append(parts, "tap")
@@ -134,6 +308,13 @@ func (t *TapNetBackend) BuildNetdevArgs(id string) []string {
 	if t.FD > 0 {
 		parts = append(parts, fmt.Sprintf("fd=%d", t.FD))
 	}
+	if len(t.FDs) > 0 {
+		fds := make([]string, len(t.FDs))
+		for n, fd := range t.FDs {
+			fds[n] = strconv.Itoa(fd)
+		}
+		parts = append(parts, "fds="+strings.Join(fds, ":"))
+	}
 
 	return []string{"-netdev", strings.Join(parts, ",")}
 }
@@ -141,13 +322,13 @@ func (t *TapNetBackend) BuildNetdevArgs(id string) []string {
 // SocketNetBackend provides socket-based networking.
 type SocketNetBackend struct {
 	// Path is the Unix socket path.
-	Path string
+	Path string `json:"path,omitempty"`
 
 	// Server makes this end the server.
-	Server bool
+	Server bool `json:"server,omitempty"`
 
 	// Reconnect interval in seconds (client mode).
-	Reconnect int
+	Reconnect int `json:"reconnect,omitempty"`
 }
 
 func (s *SocketNetBackend) Type() string { return "socket" }
@@ -171,19 +352,19 @@ func (s *SocketNetBackend) BuildNetdevArgs(id string) []string {
 // StreamNetBackend provides stream socket networking (QEMU 7.2+).
 type StreamNetBackend struct {
 	// Path is the Unix socket path.
-	Path string
+	Path string `json:"path,omitempty"`
 
 	// Host is the TCP host.
-	Host string
+	Host string `json:"host,omitempty"`
 
 	// Port is the TCP port.
-	Port int
+	Port int `json:"port,omitempty"`
 
 	// Server makes this end the server.
-	Server bool
+	Server bool `json:"server,omitempty"`
 
 	// Reconnect interval in seconds (client mode).
-	Reconnect int
+	Reconnect int `json:"reconnect,omitempty"`
 }
 
 func (s *StreamNetBackend) Type() string { return "stream" }
@@ -220,16 +401,16 @@ func (s *StreamNetBackend) BuildNetdevArgs(id string) []string {
 // VDENetBackend provides VDE networking.
 type VDENetBackend struct {
 	// Sock is the VDE socket path.
-	Sock string
+	Sock string `json:"sock,omitempty"`
 
 	// Port is the VDE port number.
-	Port int
+	Port int `json:"port,omitempty"`
 
 	// Group is the VDE group.
-	Group string
+	Group string `json:"group,omitempty"`
 
 	// Mode is the VDE socket mode.
-	Mode string
+	Mode string `json:"mode,omitempty"`
 }
 
 func (v *VDENetBackend) Type() string { return "vde" }
@@ -258,10 +439,10 @@ func (v *VDENetBackend) BuildNetdevArgs(id string) []string {
 // BridgeNetBackend provides bridge helper networking.
 type BridgeNetBackend struct {
 	// Bridge is the bridge name.
-	Bridge string
+	Bridge string `json:"bridge,omitempty"`
 
 	// Helper is the bridge helper path.
-	Helper string
+	Helper string `json:"helper,omitempty"`
 }
 
 func (b *BridgeNetBackend) Type() string { return "bridge" }
@@ -281,43 +462,123 @@ func (b *BridgeNetBackend) BuildNetdevArgs(id string) []string {
 	return []string{"-netdev", strings.Join(parts, ",")}
 }
 
+// VmnetSharedNetBackend provides NAT networking through the macOS vmnet
+// framework (QEMU 7.1+, macOS 11+). QEMU needs root or the
+// com.apple.vm.networking entitlement.
+type VmnetSharedNetBackend struct {
+	// StartAddress and EndAddress are the DHCP range, and SubnetMask its
+	// mask. All three are set together, or left to vmnet's defaults.
+	StartAddress string `json:"start_address,omitempty"`
+	EndAddress   string `json:"end_address,omitempty"`
+	SubnetMask   string `json:"subnet_mask,omitempty"`
+
+	// NAT66Prefix is the IPv6 prefix, e.g. "fd00:1::".
+	NAT66Prefix string `json:"nat66_prefix,omitempty"`
+
+	// Isolated keeps the guest from reaching other vmnet guests.
+	Isolated bool `json:"isolated,omitempty"`
+}
+
+func (v *VmnetSharedNetBackend) Type() string { return "vmnet-shared" }
+
+func (v *VmnetSharedNetBackend) BuildNetdevArgs(id string) []string {
+	var parts []string
+	parts = append(parts, "vmnet-shared")
+	parts = append(parts, "id="+id)
+
+	if v.StartAddress != "" {
+		parts = append(parts, "start-address="+v.StartAddress)
+	}
+	if v.EndAddress != "" {
+		parts = append(parts, "end-address="+v.EndAddress)
+	}
+	if v.SubnetMask != "" {
+		parts = append(parts, "subnet-mask="+v.SubnetMask)
+	}
+	if v.NAT66Prefix != "" {
+		parts = append(parts, "nat66-prefix="+v.NAT66Prefix)
+	}
+	if v.Isolated {
+		parts = append(parts, "isolated=on")
+	}
+
+	return []string{"-netdev", strings.Join(parts, ",")}
+}
+
+// VmnetBridgedNetBackend bridges the guest onto a host interface through
+// the macOS vmnet framework (QEMU 7.1+, macOS 11+). QEMU needs root or the
+// com.apple.vm.networking entitlement.
+type VmnetBridgedNetBackend struct {
+	// Ifname is the host interface, e.g. "en0".
+	Ifname string `json:"ifname,omitempty"`
+
+	// Isolated keeps the guest from reaching other vmnet guests.
+	Isolated bool `json:"isolated,omitempty"`
+}
+
+func (v *VmnetBridgedNetBackend) Type() string { return "vmnet-bridged" }
+
+func (v *VmnetBridgedNetBackend) BuildNetdevArgs(id string) []string {
+	var parts []string
+	parts = append(parts, "vmnet-bridged")
+	parts = append(parts, "id="+id)
+	parts = append(parts, "ifname="+v.Ifname)
+
+	if v.Isolated {
+		parts = append(parts, "isolated=on")
+	}
+
+	return []string{"-netdev", strings.Join(parts, ",")}
+}
+
 // buildNetworkArgs builds all arguments for a network configuration.
-func buildNetworkArgs(cfg *NetworkConfig, pciAlloc *pciSlotAllocator) []string {
+func buildNetworkArgs(cfg *NetworkConfig, profile *archProfile, pciAlloc *pciSlotAllocator, ccwAlloc *ccwDevnoAllocator) []string {
 	if cfg == nil || cfg.Backend == nil {
 		return nil
 	}
 
 	var args []string
 
-	id := cfg.ID
-	if id == "" {
-		id = "net0"
-	}
+	id := cfg.netdevID()
 
 	// Build netdev
 	args = append(args, cfg.Backend.BuildNetdevArgs(id)...)
 
 	// Build device
+	virtioNet := profile.virtio("virtio-net")
 	model := cfg.Model
 	if model == "" {
-		model = "virtio-net-pci"
+		model = virtioNet
 	}
 
-	deviceParts := []string{model}
-	deviceParts = append(deviceParts, "netdev="+id)
+	deviceParts := make([]string, 0, 7)
+	deviceParts = append(deviceParts, model, "netdev="+id)
 	deviceParts = append(deviceParts, "id="+id+"-device")
 
 	if cfg.MACAddr != "" {
 		deviceParts = append(deviceParts, "mac="+cfg.MACAddr)
 	}
+	deviceParts = append(deviceParts, cfg.virtioNetOpts()...)
+
+	// A multi-queue backend needs a multi-queue NIC, with a vector for
+	// each rx and tx queue plus config and control
+	if tap, ok := cfg.Backend.(*TapNetBackend); ok && model == virtioNet && tap.queues() > 1 {
+		deviceParts = append(deviceParts, "mq=on")
+		if profile.virtioOnPCI() {
+			deviceParts = append(deviceParts, "vectors="+strconv.Itoa(2*tap.queues()+2))
+		}
+	}
 
-	if pciAlloc != nil && (model == "virtio-net-pci" || model == "e1000" || model == "e1000e" || model == "rtl8139") {
+	switch {
+	case pciAlloc != nil && ((model == virtioNet && profile.virtioOnPCI()) || model == "e1000" || model == "e1000e" || model == "rtl8139"):
 		deviceParts = append(deviceParts, "bus="+pciAlloc.Bus())
 		deviceParts = append(deviceParts, "addr="+pciAlloc.Alloc())
+	case ccwAlloc != nil && model == virtioNet && profile.virtioOnCCW():
+		deviceParts = append(deviceParts, "devno="+ccwAlloc.Alloc())
 	}
 
 	if cfg.BootIndex > 0 {
-		deviceParts = append(deviceParts, fmt.Sprintf("bootindex=%d", cfg.BootIndex))
+		deviceParts = append(deviceParts, "bootindex="+strconv.Itoa(cfg.BootIndex))
 	}
 
 	args = append(args, "-device", strings.Join(deviceParts, ","))
@@ -0,0 +1,43 @@
+package qemuctl
+
+// SetPanicCallback sets a callback called with the details of each guest
+// panic, once the instance has moved to StateCrashed. Panics are reported
+// by a pvpanic device (see VMConfig.Panic) or Hyper-V crash MSRs.
+func (i *Instance) SetPanicCallback(cb func(*GuestPanickedEvent)) {
+	i.panicMu.Lock()
+	defer i.panicMu.Unlock()
+	i.onPanic = cb
+}
+
+// LastPanic returns the details of the last guest panic, or nil if the
+// guest has not panicked since the instance was started or attached.
+func (i *Instance) LastPanic() *GuestPanickedEvent {
+	i.panicMu.Lock()
+	defer i.panicMu.Unlock()
+	return i.lastPanic
+}
+
+// recordPanic handles GUEST_PANICKED events.
+func (i *Instance) recordPanic(event *Event) {
+	if event.Name != "GUEST_PANICKED" {
+		return
+	}
+
+	p, _ := event.Payload().(*GuestPanickedEvent)
+	if p == nil {
+		p = &GuestPanickedEvent{}
+	}
+
+	// Report the crash before the callback runs, rather than after the
+	// event hooks
+	i.setState(StateCrashed)
+
+	i.panicMu.Lock()
+	i.lastPanic = p
+	cb := i.onPanic
+	i.panicMu.Unlock()
+
+	if cb != nil {
+		cb(p)
+	}
+}
@@ -0,0 +1,76 @@
+package qemuctl
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVMBuilderWithPanic(t *testing.T) {
+	cfg := &VMConfig{
+		Machine: &MachineConfig{Type: "q35", Accel: "tcg"},
+		Panic:   &PanicConfig{Action: "pause"},
+	}
+
+	args := NewVMBuilder(cfg).Build("test-vm", "/tmp/test.sock")
+	argsStr := strings.Join(args, " ")
+	if !strings.Contains(argsStr, "-action panic=pause") {
+		t.Errorf("expected panic action, got: %s", argsStr)
+	}
+	if !strings.Contains(argsStr, "-device pvpanic-pci,id=pvpanic0,bus=pcie.0,addr=") {
+		t.Errorf("expected pvpanic-pci device, got: %s", argsStr)
+	}
+
+	parsed, err := ParseArgs(args)
+	if err != nil {
+		t.Fatalf("ParseArgs error: %v", err)
+	}
+	if parsed.Panic == nil || parsed.Panic.Device != "pvpanic-pci" || parsed.Panic.Action != "pause" {
+		t.Errorf("unexpected parsed panic config: %+v", parsed.Panic)
+	}
+
+	cfg.Panic = &PanicConfig{Device: "pvpanic-isa"}
+	argsStr = strings.Join(NewVMBuilder(cfg).Build("test-vm", "/tmp/test.sock"), " ")
+	if !strings.Contains(argsStr, "-device pvpanic,id=pvpanic0") || strings.Contains(argsStr, "-action") {
+		t.Errorf("unexpected args for pvpanic-isa: %s", argsStr)
+	}
+}
+
+func TestGuestPanicked(t *testing.T) {
+	fake := newFakeQMP(t)
+	inst := fake.attach()
+
+	panics := make(chan *GuestPanickedEvent, 1)
+	inst.SetPanicCallback(func(p *GuestPanickedEvent) {
+		if inst.State() != StateCrashed {
+			t.Errorf("state in panic callback = %v, want crashed", inst.State())
+		}
+		panics <- p
+	})
+
+	fake.sendEvent("GUEST_PANICKED", map[string]any{
+		"action": "pause",
+		"info":   map[string]any{"type": "hyper-v", "arg1": 0x1e},
+	})
+
+	select {
+	case p := <-panics:
+		if p.Action != "pause" || p.Info == nil || p.Info.Arg1 != 0x1e {
+			t.Errorf("panic details = %+v", p)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("panic callback not called")
+	}
+	if inst.LastPanic() == nil {
+		t.Error("LastPanic() = nil after a panic")
+	}
+
+	// With the shutdown panic action, the following SHUTDOWN keeps the
+	// instance crashed
+	fake.sendEvent("SHUTDOWN", map[string]any{"guest": true, "reason": "guest-panic"})
+	fake.sendEvent("RTC_CHANGE", map[string]any{"offset": 0})
+	time.Sleep(50 * time.Millisecond)
+	if inst.State() != StateCrashed {
+		t.Errorf("state after panic shutdown = %v, want crashed", inst.State())
+	}
+}
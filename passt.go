@@ -0,0 +1,111 @@
+package qemuctl
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// PasstNetBackend connects the NIC to passt, which gives unprivileged VMs
+// user-mode networking with better performance than the built-in one, and
+// the guest the addresses, routes and DNS servers of the host. QEMU talks to
+// passt over a stream socket (QEMU 7.2+).
+type PasstNetBackend struct {
+	// Socket is the Unix socket of a passt instance already running. If
+	// empty, passt is launched for the VM, with its socket next to the QMP
+	// socket, and exits with QEMU.
+	Socket string `json:"socket,omitempty"`
+
+	// PasstPath is the passt binary ("passt" if empty).
+	PasstPath string `json:"passt_path,omitempty"`
+
+	// TCPPorts and UDPPorts are the host ports forwarded to the guest, in
+	// passt syntax, such as "2222:22" or "8000-8010".
+	TCPPorts []string `json:"tcp_ports,omitempty"`
+	UDPPorts []string `json:"udp_ports,omitempty"`
+
+	// Args are extra passt arguments.
+	Args []string `json:"args,omitempty"`
+}
+
+func (p *PasstNetBackend) Type() string { return "passt" }
+
+func (p *PasstNetBackend) BuildNetdevArgs(id string) []string {
+	return (&StreamNetBackend{Path: p.Socket}).BuildNetdevArgs(id)
+}
+
+// launched reports whether qemuctl launches passt for the backend.
+func (p *PasstNetBackend) launched() bool {
+	return p.Socket == ""
+}
+
+// validate checks that options for launching passt are not set for an
+// existing instance.
+func (p *PasstNetBackend) validate(id string) error {
+	if !p.launched() && (p.PasstPath != "" || len(p.TCPPorts) > 0 || len(p.UDPPorts) > 0 || len(p.Args) > 0) {
+		return fmt.Errorf("network %s: passt options set for the existing passt socket %s", id, p.Socket)
+	}
+	return nil
+}
+
+// passtSocketPath returns the socket of the passt launched for the netdev
+// id of the instance with QMP socket socketPath.
+func passtSocketPath(socketPath, id string) string {
+	return strings.TrimSuffix(socketPath, ".sock") + "-passt-" + id + ".sock"
+}
+
+// passtArgs returns the passt arguments. passt stays in the foreground,
+// so that it can be stopped with the instance, and exits once QEMU
+// disconnects.
+func (p *PasstNetBackend) passtArgs() []string {
+	args := []string{"--foreground", "--one-off", "--socket", p.Socket}
+	for _, port := range p.TCPPorts {
+		args = append(args, "--tcp-ports", port)
+	}
+	for _, port := range p.UDPPorts {
+		args = append(args, "--udp-ports", port)
+	}
+	return append(args, p.Args...)
+}
+
+// resolvePasst sets the socket of the passt instances to launch, next to
+// the QMP socket. It returns a copy of the configuration and the backends
+// to launch.
+func (cfg *VMConfig) resolvePasst(socketPath string) (*VMConfig, []*PasstNetBackend) {
+	var launch []*PasstNetBackend
+	var networks []*NetworkConfig
+	for n, net := range cfg.Networks {
+		b, ok := net.Backend.(*PasstNetBackend)
+		if !ok || !b.launched() {
+			continue
+		}
+		if networks == nil {
+			networks = append([]*NetworkConfig(nil), cfg.Networks...)
+		}
+
+		id := net.netdevID()
+		backend := *b
+		backend.Socket = passtSocketPath(socketPath, id)
+		c := *net
+		c.Backend = &backend
+		networks[n] = &c
+		launch = append(launch, &backend)
+	}
+	if networks == nil {
+		return cfg, nil
+	}
+
+	c := *cfg
+	c.Networks = networks
+	return &c, launch
+}
+
+// startPasst launches passt for a backend and waits for its socket.
+func startPasst(ctx context.Context, b *PasstNetBackend) (*helperProcess, error) {
+	path := b.PasstPath
+	if path == "" {
+		path = "passt"
+	}
+	return startHelper(ctx, "passt", exec.Command(path, b.passtArgs()...), b.Socket)
+}
@@ -0,0 +1,92 @@
+package qemuctl
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPasstNetBackend(t *testing.T) {
+	cfg := DefaultVMConfig()
+	cfg.Networks = []*NetworkConfig{
+		{ID: "net0", Backend: &PasstNetBackend{TCPPorts: []string{"2222:22"}}},
+		{ID: "net1", Backend: &PasstNetBackend{Socket: "/run/passt/shared.sock"}},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	resolved, launch := cfg.resolvePasst("/run/qemu/vm.sock")
+	if len(launch) != 1 || launch[0].Socket != "/run/qemu/vm-passt-net0.sock" {
+		t.Fatalf("launch = %+v", launch)
+	}
+	if cfg.Networks[0].Backend.(*PasstNetBackend).Socket != "" {
+		t.Error("resolvePasst modified the configuration")
+	}
+
+	argsStr := strings.Join(NewVMBuilder(resolved).Build("vm", "/run/qemu/vm.sock"), " ")
+	for _, want := range []string{
+		"-netdev stream,id=net0,server=off,addr.type=unix,addr.path=/run/qemu/vm-passt-net0.sock",
+		"-netdev stream,id=net1,server=off,addr.type=unix,addr.path=/run/passt/shared.sock",
+	} {
+		if !strings.Contains(argsStr, want) {
+			t.Errorf("expected %q in: %s", want, argsStr)
+		}
+	}
+
+	wantArgs := []string{"--foreground", "--one-off", "--socket", "/run/qemu/vm-passt-net0.sock", "--tcp-ports", "2222:22"}
+	if got := launch[0].passtArgs(); !reflect.DeepEqual(got, wantArgs) {
+		t.Errorf("passtArgs = %q, want %q", got, wantArgs)
+	}
+
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded VMConfig
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.Networks, cfg.Networks) {
+		t.Errorf("JSON round trip = %+v", decoded.Networks[0].Backend)
+	}
+
+	cfg.Networks[1].Backend = &PasstNetBackend{Socket: "/run/passt/shared.sock", TCPPorts: []string{"80"}}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate accepted ports for an existing passt socket")
+	}
+}
+
+func TestStartPasst(t *testing.T) {
+	dir := t.TempDir()
+	passt := filepath.Join(dir, "passt")
+	// The socket follows --socket
+	script := "#!/bin/sh\nwhile [ \"$1\" != --socket ]; do shift; done\n: > \"$2\"\nexec sleep 60\n"
+	if err := os.WriteFile(passt, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	b := &PasstNetBackend{Socket: filepath.Join(dir, "vm-passt-net0.sock"), PasstPath: passt}
+	p, err := startPasst(context.Background(), b)
+	if err != nil {
+		t.Fatalf("startPasst failed: %v", err)
+	}
+	(&Instance{passt: []*helperProcess{p}}).cleanup()
+	select {
+	case <-p.done:
+	default:
+		t.Error("passt still running after cleanup")
+	}
+	if _, err := os.Stat(b.Socket); !os.IsNotExist(err) {
+		t.Error("passt socket not removed")
+	}
+
+	os.WriteFile(passt, []byte("#!/bin/sh\necho 'no routable interface' >&2\nexit 1\n"), 0o755)
+	if _, err := startPasst(context.Background(), b); err == nil || !strings.Contains(err.Error(), "no routable interface") {
+		t.Errorf("startPasst = %v, want passt error", err)
+	}
+}
@@ -0,0 +1,86 @@
+package qemuctl
+
+import "fmt"
+
+// EnablePostcopy enables MigrationCapPostcopyRAM on the source and the
+// destination of a migration, which must both support it. It must be
+// called before Migrate.
+func EnablePostcopy(source, dest *Instance) error {
+	for _, inst := range []*Instance{source, dest} {
+		caps, err := inst.MigrationCapabilities()
+		if err != nil {
+			return err
+		}
+		if _, ok := caps[MigrationCapPostcopyRAM]; !ok {
+			return fmt.Errorf("%s does not support post-copy migration", inst.Name())
+		}
+	}
+	for _, inst := range []*Instance{source, dest} {
+		if err := inst.SetMigrationCapabilities(map[string]bool{MigrationCapPostcopyRAM: true}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MigrateStartPostcopy switches the running migration of the source to
+// post-copy: the guest resumes on the destination at once, and fetches
+// the memory not yet sent on demand. It bounds the migration of guests
+// dirtying memory faster than it can be sent, but a connection loss then
+// pauses the guest until the migration is recovered (see
+// ErrPostcopyPaused).
+func (i *Instance) MigrateStartPostcopy() error {
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return ErrNotConnected
+	}
+
+	_, err := qmp.Execute("migrate-start-postcopy", nil)
+	return err
+}
+
+// MigratePause pauses a post-copy migration of the source, such as to move
+// it to another network. It is run out-of-band when the monitor allows,
+// as the monitor may be stuck on the broken connection.
+func (i *Instance) MigratePause() error {
+	return i.executeMaybeOOB("migrate-pause", nil)
+}
+
+// MigrateRecover makes the destination of a paused post-copy migration
+// listen on uri for the source to reconnect with MigrateResume.
+func (i *Instance) MigrateRecover(uri string) error {
+	if uri == "" {
+		return fmt.Errorf("migration recovery needs a URI")
+	}
+	return i.executeMaybeOOB("migrate-recover", map[string]any{"uri": uri})
+}
+
+// MigrateResume reconnects the source of a paused post-copy migration to
+// the destination at uri, set up by MigrateRecover, and resumes the
+// migration.
+func (i *Instance) MigrateResume(uri string) error {
+	return i.migrate(uri, true)
+}
+
+// executeMaybeOOB runs a command out-of-band if the monitor offered it,
+// and in-band otherwise.
+func (i *Instance) executeMaybeOOB(command string, args map[string]any) error {
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return ErrNotConnected
+	}
+
+	var err error
+	if qmp.OOBEnabled() {
+		_, err = qmp.ExecuteOOB(command, args)
+	} else {
+		_, err = qmp.Execute(command, args)
+	}
+	return err
+}
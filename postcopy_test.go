@@ -0,0 +1,116 @@
+package qemuctl
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// handleMigrationCaps makes the fake monitor report the postcopy-ram
+// capability if supported, and accept capability changes.
+func handleMigrationCaps(fake *fakeQMP, supported bool) {
+	fake.handle("query-migrate-capabilities", func(map[string]any) (any, *qmpError) {
+		caps := []any{map[string]any{"capability": "xbzrle", "state": false}}
+		if supported {
+			caps = append(caps, map[string]any{"capability": "postcopy-ram", "state": false})
+		}
+		return caps, nil
+	})
+	fake.handle("migrate-set-capabilities", func(map[string]any) (any, *qmpError) { return map[string]any{}, nil })
+}
+
+func TestEnablePostcopy(t *testing.T) {
+	srcFake, dstFake := newFakeQMP(t), newFakeQMP(t)
+	handleMigrationCaps(srcFake, true)
+	handleMigrationCaps(dstFake, true)
+	src, dst := srcFake.attach(), dstFake.attach()
+
+	if err := EnablePostcopy(src, dst); err != nil {
+		t.Fatalf("EnablePostcopy failed: %v", err)
+	}
+	for _, fake := range []*fakeQMP{srcFake, dstFake} {
+		calls := fake.commands("migrate-set-capabilities")
+		if len(calls) != 1 {
+			t.Fatalf("migrate-set-capabilities sent %d times", len(calls))
+		}
+		if c := calls[0]["capabilities"].([]any)[0].(map[string]any); c["capability"] != "postcopy-ram" || c["state"] != true {
+			t.Errorf("capabilities = %v", c)
+		}
+	}
+
+	oldFake := newFakeQMP(t)
+	handleMigrationCaps(oldFake, false)
+	if err := EnablePostcopy(src, oldFake.attach()); err == nil || !strings.Contains(err.Error(), "post-copy") {
+		t.Errorf("EnablePostcopy = %v, want unsupported", err)
+	}
+	if n := len(srcFake.commands("migrate-set-capabilities")); n != 1 {
+		t.Errorf("source capabilities changed although the destination lacks post-copy")
+	}
+}
+
+func TestPostcopyMigration(t *testing.T) {
+	defer func(d time.Duration) { migrationPollInterval = d }(migrationPollInterval)
+	migrationPollInterval = time.Millisecond
+
+	fake := newFakeQMP(t)
+	statuses := []string{"active", "postcopy-active", "postcopy-paused", "postcopy-recover", "postcopy-active", "completed"}
+	fake.handle("query-migrate", func(map[string]any) (any, *qmpError) {
+		status := statuses[0]
+		if len(statuses) > 1 {
+			statuses = statuses[1:]
+		}
+		return map[string]any{"status": status, "ram": map[string]any{"transferred": 1024, "remaining": 0, "total": 4096, "postcopy-requests": 3}}, nil
+	})
+	for _, cmd := range []string{"migrate", "migrate-start-postcopy", "migrate-recover"} {
+		fake.handle(cmd, func(map[string]any) (any, *qmpError) { return map[string]any{}, nil })
+	}
+	inst := fake.attach()
+
+	if err := inst.Migrate("tcp:10.0.0.2:4444"); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+	if err := inst.MigrateStartPostcopy(); err != nil {
+		t.Fatalf("MigrateStartPostcopy failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	info, err := inst.WaitMigration(ctx)
+	if !errors.Is(err, ErrPostcopyPaused) || info.Status != MigrationStatusPostcopyPaused {
+		t.Fatalf("WaitMigration = %+v, %v, want a paused migration", info, err)
+	}
+
+	if err := inst.MigrateRecover("tcp:0:4445"); err != nil {
+		t.Fatalf("MigrateRecover failed: %v", err)
+	}
+	if err := inst.MigrateResume("tcp:10.0.0.2:4445"); err != nil {
+		t.Fatalf("MigrateResume failed: %v", err)
+	}
+	info, err = inst.WaitMigration(ctx)
+	if err != nil || info.RAM == nil || info.RAM.PostcopyRequests != 3 {
+		t.Errorf("WaitMigration = %+v, %v", info, err)
+	}
+
+	want := []map[string]any{{"uri": "tcp:10.0.0.2:4444"}, {"uri": "tcp:10.0.0.2:4445", "resume": true}}
+	if got := fake.commands("migrate"); !reflect.DeepEqual(got, want) {
+		t.Errorf("migrate = %v, want %v", got, want)
+	}
+	if got := fake.commands("migrate-recover"); len(got) != 1 || got[0]["uri"] != "tcp:0:4445" {
+		t.Errorf("migrate-recover = %v", got)
+	}
+}
+
+func TestWaitMigrationFailed(t *testing.T) {
+	fake := newFakeQMP(t)
+	fake.handle("query-migrate", func(map[string]any) (any, *qmpError) {
+		return map[string]any{"status": "failed", "error-desc": "Unable to write to socket: Broken pipe"}, nil
+	})
+	inst := fake.attach()
+
+	if _, err := inst.WaitMigration(context.Background()); err == nil || !strings.Contains(err.Error(), "Broken pipe") {
+		t.Errorf("WaitMigration = %v, want the migration error", err)
+	}
+}
@@ -0,0 +1,298 @@
+package qemuctl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// provisionTimeout is the default time allowed for an installation.
+const provisionTimeout = time.Hour
+
+// provisionPollInterval is how often the guest agent is probed while
+// waiting for an installation to complete.
+const provisionPollInterval = 5 * time.Second
+
+// provisionAgentTimeout bounds each guest agent probe.
+const provisionAgentTimeout = 5 * time.Second
+
+// ProvisionState is a step of a Provisioner.
+type ProvisionState string
+
+const (
+	ProvisionPending    ProvisionState = "pending"
+	ProvisionBooting    ProvisionState = "booting"    // starting the VM on the install media
+	ProvisionInstalling ProvisionState = "installing" // waiting for the installation to complete
+	ProvisionEjecting   ProvisionState = "ejecting"   // removing the install media
+	ProvisionRebooting  ProvisionState = "rebooting"  // rebooting to the installed disk
+	ProvisionDone       ProvisionState = "provisioned"
+	ProvisionFailed     ProvisionState = "failed"
+)
+
+// Provisioner installs a guest from an install ISO or over the network and
+// reboots it to its disk, the usual flow of building a golden image:
+//
+//  1. the VM boots the install media (CD-ROM or PXE)
+//  2. installation completes, detected by a console message or by the
+//     guest agent starting to respond
+//  3. CD-ROM media are ejected
+//  4. the guest reboots to the installed disk
+//  5. the VM is marked provisioned
+//
+// Without an explicit boot order, the VM boots from the disk first and
+// falls back to the install media while the disk is blank.
+type Provisioner struct {
+	// Config is the VM to install, with the install ISO as a CD-ROM unless
+	// NetworkBoot is set.
+	Config *VMConfig
+
+	// NetworkBoot installs over PXE instead of from a CD-ROM.
+	NetworkBoot bool
+
+	// CompletionPattern, if set, matches the serial console output that
+	// signals the end of the installation. ConsoleSerial is the index of
+	// the serial port to watch, which must be a unix socket server (see
+	// Instance.CaptureSerial).
+	CompletionPattern *regexp.Regexp
+	ConsoleSerial     int
+
+	// WaitAgent considers the installation complete once the guest agent
+	// responds. Config must have a guest agent channel.
+	WaitAgent bool
+
+	// Timeout bounds the whole installation. Defaults to one hour.
+	Timeout time.Duration
+
+	// MarkerPath, if set, is a file created once the VM is provisioned.
+	// When it exists, Run boots the VM from disk without installing.
+	MarkerPath string
+
+	// Start starts the VM. Defaults to StartVMContext; Supervisor.Start
+	// can be used to account for the VM's resources.
+	Start func(ctx context.Context, cfg *VMConfig) (*Instance, error)
+
+	// OnState is called on each state change.
+	OnState func(ProvisionState)
+
+	mu      sync.Mutex
+	state   ProvisionState
+	console *ConsoleLog
+}
+
+// State returns the current provisioning step.
+func (p *Provisioner) State() ProvisionState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.state == "" {
+		return ProvisionPending
+	}
+	return p.state
+}
+
+// Console returns the captured console log while waiting for
+// CompletionPattern, or nil.
+func (p *Provisioner) Console() *ConsoleLog {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.console
+}
+
+// Run provisions the VM and returns the instance running the installed
+// system. On failure the VM is killed.
+func (p *Provisioner) Run(ctx context.Context) (*Instance, error) {
+	if p.Config == nil {
+		return nil, errors.New("provisioner has no VM configuration")
+	}
+	if p.CompletionPattern == nil && !p.WaitAgent {
+		return nil, errors.New("provisioner needs a completion pattern or a guest agent to wait for")
+	}
+	start := p.Start
+	if start == nil {
+		start = StartVMContext
+	}
+
+	if p.MarkerPath != "" {
+		if _, err := os.Stat(p.MarkerPath); err == nil {
+			cfg := *p.Config
+			cfg.CDROMs = nil
+			inst, err := start(ctx, &cfg)
+			if err != nil {
+				return nil, err
+			}
+			p.setState(ProvisionDone)
+			return inst, nil
+		}
+	}
+
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = provisionTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	p.setState(ProvisionBooting)
+	inst, err := start(ctx, p.installConfig())
+	if err != nil {
+		return p.fail(nil, fmt.Errorf("failed to boot installer: %w", err))
+	}
+
+	p.setState(ProvisionInstalling)
+	if err := p.waitInstalled(ctx, inst); err != nil {
+		return p.fail(inst, fmt.Errorf("installation did not complete: %w", err))
+	}
+
+	if !p.NetworkBoot {
+		p.setState(ProvisionEjecting)
+		for idx, cdrom := range p.Config.CDROMs {
+			if cdrom.Path == "" {
+				continue
+			}
+			if err := inst.EjectCDROM(idx); err != nil {
+				return p.fail(inst, fmt.Errorf("failed to eject install media: %w", err))
+			}
+		}
+	}
+
+	p.setState(ProvisionRebooting)
+	if _, err := inst.RebootSmart(ctx); err != nil {
+		return p.fail(inst, fmt.Errorf("failed to reboot to disk: %w", err))
+	}
+
+	if p.MarkerPath != "" {
+		stamp := time.Now().UTC().Format(time.RFC3339) + "\n"
+		if err := os.WriteFile(p.MarkerPath, []byte(stamp), 0o644); err != nil {
+			return p.fail(inst, fmt.Errorf("failed to write provisioning marker: %w", err))
+		}
+	}
+	p.setState(ProvisionDone)
+	return inst, nil
+}
+
+// installConfig returns the configuration booting the install media.
+func (p *Provisioner) installConfig() *VMConfig {
+	cfg := p.Config
+	if cfg.Boot != nil && (cfg.Boot.Order != "" || cfg.Boot.Kernel != "") {
+		return cfg
+	}
+
+	c := *cfg
+	boot := BootConfig{}
+	if cfg.Boot != nil {
+		boot = *cfg.Boot
+	}
+	if p.NetworkBoot {
+		boot.Order = "cn"
+	} else {
+		boot.Order = "cd"
+	}
+	c.Boot = &boot
+	return &c
+}
+
+// waitInstalled waits for the first installation completion signal.
+func (p *Provisioner) waitInstalled(ctx context.Context, inst *Instance) error {
+	qmp := inst.QMP()
+	if qmp == nil {
+		return ErrNotConnected
+	}
+	sub := qmp.Subscribe("SHUTDOWN")
+	defer sub.Unsubscribe()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan error, 2)
+
+	if p.CompletionPattern != nil {
+		log, err := inst.CaptureSerial(p.ConsoleSerial, 0)
+		if err != nil {
+			return err
+		}
+		p.mu.Lock()
+		p.console = log
+		p.mu.Unlock()
+		go func() { done <- waitConsoleMatch(ctx, log, p.CompletionPattern) }()
+	}
+	if p.WaitAgent {
+		agent := inst.GuestAgent()
+		if agent == nil {
+			return errors.New("no guest agent channel configured")
+		}
+		go func() { done <- waitAgentReady(ctx, agent) }()
+	}
+
+	select {
+	case err := <-done:
+		return err
+	case _, ok := <-sub.C:
+		if !ok {
+			return qmp.closedErr()
+		}
+		return errors.New("guest shut down")
+	}
+}
+
+// waitConsoleMatch waits for the console output to match re.
+func waitConsoleMatch(ctx context.Context, log *ConsoleLog, re *regexp.Regexp) error {
+	for {
+		data, _, notify, closed := log.readFrom(0)
+		if re.Match(data) {
+			return nil
+		}
+		if closed {
+			return errors.New("console closed")
+		}
+
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// waitAgentReady waits for the guest agent to respond to pings.
+func waitAgentReady(ctx context.Context, agent *GuestAgent) error {
+	ticker := time.NewTicker(provisionPollInterval)
+	defer ticker.Stop()
+
+	for {
+		pingCtx, cancel := context.WithTimeout(ctx, provisionAgentTimeout)
+		err := agent.Ping(pingCtx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// fail marks provisioning failed and kills the VM.
+func (p *Provisioner) fail(inst *Instance, err error) (*Instance, error) {
+	if inst != nil {
+		inst.ForceStop()
+	}
+	p.setState(ProvisionFailed)
+	return nil, err
+}
+
+// setState records a state change and reports it.
+func (p *Provisioner) setState(state ProvisionState) {
+	p.mu.Lock()
+	p.state = state
+	cb := p.OnState
+	p.mu.Unlock()
+
+	if cb != nil {
+		cb(state)
+	}
+}
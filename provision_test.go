@@ -0,0 +1,171 @@
+package qemuctl
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestProvisionerCDROM(t *testing.T) {
+	fake := newFakeQMP(t)
+	fake.handle("eject", func(map[string]any) (any, *qmpError) { return map[string]any{}, nil })
+	fake.handle("system_reset", func(map[string]any) (any, *qmpError) { return map[string]any{}, nil })
+
+	serial := filepath.Join(t.TempDir(), "serial.sock")
+	ln, err := net.Listen("unix", serial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("Installing packages...\n"))
+		time.Sleep(50 * time.Millisecond)
+		conn.Write([]byte("Installation complete\n"))
+		time.Sleep(time.Second)
+	}()
+
+	var states []ProvisionState
+	var booted *VMConfig
+	marker := filepath.Join(t.TempDir(), "provisioned")
+	p := &Provisioner{
+		Config: &VMConfig{
+			CDROMs:  []*CDROMConfig{{Path: "/iso/install.iso"}},
+			Serials: []*SerialConfig{{Type: "socket", Path: serial, Server: true}},
+		},
+		CompletionPattern: regexp.MustCompile(`Installation complete`),
+		MarkerPath:        marker,
+		Start: func(ctx context.Context, cfg *VMConfig) (*Instance, error) {
+			booted = cfg
+			inst := fake.attach()
+			inst.vmConfig = cfg
+			return inst, nil
+		},
+		OnState: func(s ProvisionState) { states = append(states, s) },
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	inst, err := p.Run(ctx)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	inst.QMP().Close()
+
+	want := []ProvisionState{ProvisionBooting, ProvisionInstalling, ProvisionEjecting, ProvisionRebooting, ProvisionDone}
+	if len(states) != len(want) {
+		t.Fatalf("states = %v, want %v", states, want)
+	}
+	for i := range want {
+		if states[i] != want[i] {
+			t.Fatalf("states = %v, want %v", states, want)
+		}
+	}
+	if booted.Boot == nil || booted.Boot.Order != "cd" {
+		t.Errorf("install boot config = %+v, want order cd", booted.Boot)
+	}
+	if p.Config.Boot != nil {
+		t.Errorf("caller config modified")
+	}
+	if calls := fake.commands("eject"); len(calls) != 1 || calls[0]["id"] != "cdrom0-device" {
+		t.Errorf("eject calls = %v", calls)
+	}
+	if n := len(fake.commands("system_reset")); n != 1 {
+		t.Errorf("system_reset called %d times, want 1", n)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("marker not written: %v", err)
+	}
+
+	// Provisioned VMs boot without the install media
+	states = nil
+	if _, err := p.Run(ctx); err != nil {
+		t.Fatalf("second Run failed: %v", err)
+	}
+	if len(booted.CDROMs) != 0 || len(states) != 1 || states[0] != ProvisionDone {
+		t.Errorf("second Run booted %+v with states %v", booted, states)
+	}
+}
+
+func TestProvisionerAgentNetworkBoot(t *testing.T) {
+	fake := newFakeQMP(t)
+	agent := newFakeGuestAgent(t, nil, func(mode string) {
+		fake.sendEvent("RESET", map[string]any{"guest": true, "reason": "guest-reset"})
+	})
+
+	var booted *VMConfig
+	p := &Provisioner{
+		Config:      DefaultVMConfig().WithGuestAgent(agent),
+		NetworkBoot: true,
+		WaitAgent:   true,
+		Start: func(ctx context.Context, cfg *VMConfig) (*Instance, error) {
+			booted = cfg
+			inst := fake.attach()
+			inst.vmConfig = cfg
+			return inst, nil
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := p.Run(ctx); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if p.State() != ProvisionDone {
+		t.Errorf("State = %v, want %v", p.State(), ProvisionDone)
+	}
+	if booted.Boot == nil || booted.Boot.Order != "cn" {
+		t.Errorf("install boot config = %+v, want order cn", booted.Boot)
+	}
+	if n := len(fake.commands("eject")); n != 0 {
+		t.Errorf("eject called %d times for a network install", n)
+	}
+}
+
+func TestProvisionerGuestShutdown(t *testing.T) {
+	fake := newFakeQMP(t)
+	serial := filepath.Join(t.TempDir(), "serial.sock")
+	ln, err := net.Listen("unix", serial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		// The console is captured once the provisioner watches for events
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fake.sendEvent("SHUTDOWN", map[string]any{"guest": true, "reason": "guest-shutdown"})
+		time.Sleep(time.Second)
+	}()
+
+	p := &Provisioner{
+		Config: &VMConfig{
+			Serials: []*SerialConfig{{Type: "socket", Path: serial, Server: true}},
+		},
+		CompletionPattern: regexp.MustCompile(`done`),
+		Timeout:           5 * time.Second,
+		Start: func(ctx context.Context, cfg *VMConfig) (*Instance, error) {
+			inst := fake.attach()
+			inst.vmConfig = cfg
+			return inst, nil
+		},
+	}
+
+	if _, err := p.Run(context.Background()); err == nil {
+		t.Fatal("Run succeeded after the guest shut down")
+	}
+	if p.State() != ProvisionFailed {
+		t.Errorf("State = %v, want %v", p.State(), ProvisionFailed)
+	}
+}
@@ -1,9 +1,14 @@
 package qemuctl
 
 import (
+	"errors"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestLocateQemu(t *testing.T) {
@@ -138,7 +143,8 @@ func TestParseQMPStatus(t *testing.T) {
 		{"prelaunch", StatePrelaunch},
 		{"inmigrate", StatePrelaunch},
 		{"internal-error", StateCrashed},
-		{"io-error", StateCrashed},
+		{"io-error", StatePaused},
+		{"postmigrate", StatePaused},
 		{"unknown-status", StateUnknown},
 	}
 
@@ -222,6 +228,16 @@ func TestDefaultSocketDir(t *testing.T) {
 	}
 }
 
+func TestCheckSocketPath(t *testing.T) {
+	if err := checkSocketPath("/run/qemu/vm.sock"); err != nil {
+		t.Errorf("checkSocketPath rejected a short path: %v", err)
+	}
+	long := "/Users/someone/Library/Caches/qemuctl/" + strings.Repeat("x", 80) + ".sock"
+	if err := checkSocketPath(long); err == nil {
+		t.Error("checkSocketPath accepted a path too long for sun_path")
+	}
+}
+
 func TestBuildArgs(t *testing.T) {
 	cfg := &Config{
 		Name:   "test-vm",
@@ -354,6 +370,275 @@ func TestFindSocketFromArgs(t *testing.T) {
 	}
 }
 
+func TestParseArgs(t *testing.T) {
+	args := []string{
+		"/usr/bin/qemu-system-x86_64",
+		"-name", "guest=web01,debug-threads=on",
+		"-machine", "pc-q35-8.2,usb=off,dump-guest-core=off",
+		"-accel", "kvm",
+		"-cpu", "host,+aes,migratable=on",
+		"-m", "size=4G",
+		"-smp", "4,sockets=1,cores=2,threads=2",
+		"-chardev", "socket,id=charmonitor,path=/run/qemu/web01.sock,server=on,wait=off",
+		"-mon", "chardev=charmonitor,mode=control",
+		"-drive", "file=/var/lib/images/web01.qcow2,format=qcow2,if=none,id=drive0,cache=none",
+		"-device", "virtio-blk-pci,drive=drive0,id=virtio-disk0,bootindex=1",
+		"-netdev", "user,id=hostnet0,hostfwd=tcp::2222-:22,hostfwd=tcp::8080-:80",
+		"-device", "virtio-net-pci,netdev=hostnet0,mac=52:54:00:12:34:56",
+		"-vnc", "127.0.0.1:0",
+		"-msg", "timestamp=on",
+	}
+
+	cfg, err := ParseArgs(args)
+	if err != nil {
+		t.Fatalf("ParseArgs error: %v", err)
+	}
+
+	if cfg.Name != "web01" {
+		t.Errorf("expected name web01, got %q", cfg.Name)
+	}
+	if cfg.Arch != "amd64" {
+		t.Errorf("expected arch amd64, got %q", cfg.Arch)
+	}
+	if cfg.Machine == nil || cfg.Machine.Type != "pc-q35-8.2" || cfg.Machine.Accel != "kvm" {
+		t.Errorf("unexpected machine: %+v", cfg.Machine)
+	}
+	if cfg.Memory == nil || cfg.Memory.Size != 4096 {
+		t.Errorf("unexpected memory: %+v", cfg.Memory)
+	}
+	if cfg.CPU == nil || cfg.CPU.Model != "host" || cfg.CPU.Cores != 2 || cfg.CPU.Threads != 2 {
+		t.Errorf("unexpected cpu: %+v", cfg.CPU)
+	}
+	if len(cfg.CPU.Features) != 2 {
+		t.Errorf("expected 2 cpu features, got %v", cfg.CPU.Features)
+	}
+
+	if len(cfg.Disks) != 1 {
+		t.Fatalf("expected 1 disk, got %d", len(cfg.Disks))
+	}
+	disk := cfg.Disks[0]
+	file, ok := disk.Backend.(*FileDiskBackend)
+	if !ok || file.Path != "/var/lib/images/web01.qcow2" || file.Format != "qcow2" {
+		t.Errorf("unexpected disk backend: %#v", disk.Backend)
+	}
+	if disk.Interface != "virtio" || disk.BootIndex != 1 || disk.Cache != "none" {
+		t.Errorf("unexpected disk: %+v", disk)
+	}
+
+	if len(cfg.Networks) != 1 {
+		t.Fatalf("expected 1 network, got %d", len(cfg.Networks))
+	}
+	user, ok := cfg.Networks[0].Backend.(*UserNetBackend)
+	if !ok || len(user.Hostfwd) != 2 {
+		t.Errorf("unexpected network backend: %#v", cfg.Networks[0].Backend)
+	}
+	if cfg.Networks[0].MACAddr != "52:54:00:12:34:56" {
+		t.Errorf("unexpected mac: %q", cfg.Networks[0].MACAddr)
+	}
+
+	if cfg.Display == nil || cfg.Display.VNC == nil || cfg.Display.VNC.Listen != "127.0.0.1:0" {
+		t.Errorf("unexpected display: %+v", cfg.Display)
+	}
+	if len(cfg.Chardevs) != 0 {
+		t.Errorf("monitor chardev should not be kept, got %d chardevs", len(cfg.Chardevs))
+	}
+	if len(cfg.ExtraArgs) != 2 || cfg.ExtraArgs[0] != "-msg" {
+		t.Errorf("unexpected extra args: %v", cfg.ExtraArgs)
+	}
+}
+
+func TestParseArgsRoundTrip(t *testing.T) {
+	cfg := DefaultVMConfig()
+	cfg.Name = "test-vm"
+	cfg.Disks = []*DiskConfig{
+		{
+			ID:        "disk0",
+			Backend:   &FileDiskBackend{Path: "/var/lib/qemu/test.qcow2", Format: "qcow2"},
+			BootIndex: 1,
+		},
+		{
+			ID:      "disk1",
+			Backend: &NBDDiskBackend{Host: "10.0.0.1", Port: 10809, Export: "data"},
+		},
+	}
+	cfg.Networks = []*NetworkConfig{
+		{
+			ID:      "net0",
+			Backend: &UserNetBackend{Hostfwd: []string{"tcp::2222-:22"}},
+		},
+	}
+
+	args := NewVMBuilder(cfg).Build("test-vm", "/tmp/test.sock")
+	parsed, err := ParseArgs(append([]string{"qemu-system-x86_64"}, args...))
+	if err != nil {
+		t.Fatalf("ParseArgs error: %v", err)
+	}
+
+	want := strings.Join(args, " ")
+	got := strings.Join(NewVMBuilder(parsed).Build("test-vm", "/tmp/test.sock"), " ")
+	if want != got {
+		t.Errorf("args differ after round trip:\nwant: %s\ngot:  %s", want, got)
+	}
+}
+
+func TestParseWarning(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected string
+		ok       bool
+	}{
+		{
+			line:     "qemu-system-x86_64: warning: host doesn't support requested feature: CPUID.01H:ECX.vmx [bit 5]",
+			expected: "host doesn't support requested feature: CPUID.01H:ECX.vmx [bit 5]",
+			ok:       true,
+		},
+		{
+			line:     "qemu-system-x86_64: -chardev socket,id=c0,path=/tmp/s,reconnect=1: warning: 'reconnect' option is deprecated, use 'reconnect-ms' instead",
+			expected: "-chardev socket,id=c0,path=/tmp/s,reconnect=1: 'reconnect' option is deprecated, use 'reconnect-ms' instead",
+			ok:       true,
+		},
+		{
+			line: "qemu-system-x86_64: -drive file=missing.img: Could not open 'missing.img'",
+			ok:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		msg, ok := parseWarning(tt.line)
+		if ok != tt.ok || msg != tt.expected {
+			t.Errorf("parseWarning(%q) = %q, %v; expected %q, %v", tt.line, msg, ok, tt.expected, tt.ok)
+		}
+	}
+}
+
+func TestCollectWarnings(t *testing.T) {
+	cmd := exec.Command("sh", "-c", `echo "qemu: warning: first" >&2; echo "noise" >&2; echo "qemu: warning: second" >&2`)
+	w, err := collectWarnings(cmd, nil)
+	if err != nil {
+		t.Fatalf("collectWarnings error: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Skipf("sh not available: %v", err)
+	}
+	cmd.Process.Wait()
+	time.Sleep(stderrSettleTime)
+
+	inst := &Instance{warnings: w}
+	got := inst.Warnings()
+	if len(got) != 2 || got[0] != "first" || got[1] != "second" {
+		t.Errorf("unexpected warnings: %v", got)
+	}
+
+	// Attached instances have no captured stderr
+	if w := (&Instance{}).Warnings(); w != nil {
+		t.Errorf("expected nil warnings, got %v", w)
+	}
+}
+
+func TestCollectOutput(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "qemu.log")
+	var buf syncBuffer
+	out, err := openProcessOutput(logPath, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command("sh", "-c", `echo "VNC server running on ::1:5900"; echo "qemu: warning: first" >&2`)
+	w, err := collectWarnings(cmd, out)
+	if err != nil {
+		t.Fatalf("collectWarnings error: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Skipf("sh not available: %v", err)
+	}
+	cmd.Process.Wait()
+	<-w.exited()
+
+	// stdout may still be copying once stderr is done
+	want := []string{"VNC server running on ::1:5900", "qemu: warning: first"}
+	deadline := time.Now().Add(5 * time.Second)
+	for strings.Count(buf.String(), "\n") < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, line := range want {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("writer missing %q: %q", line, buf.String())
+		}
+	}
+	data, _ := os.ReadFile(logPath)
+	if string(data) != buf.String() {
+		t.Errorf("log file has %q, writer %q", data, buf.String())
+	}
+	if got := w.list(); len(got) != 1 || got[0] != "first" {
+		t.Errorf("unexpected warnings: %v", got)
+	}
+}
+
+func TestStartVMError(t *testing.T) {
+	dir := t.TempDir()
+	qemu := filepath.Join(dir, "qemu-system-x86_64")
+	script := "#!/bin/sh\necho \"qemu-system-x86_64: -drive file=missing.img: Could not open 'missing.img'\" >&2\nexit 1\n"
+	if err := os.WriteFile(qemu, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	logPath := filepath.Join(dir, "qemu.log")
+
+	start := time.Now()
+	_, err := StartVM(&VMConfig{
+		Name:          "bad",
+		Arch:          "amd64",
+		QemuPath:      qemu,
+		SocketDir:     dir,
+		AccelFallback: AccelFallbackTCG,
+		OutputLog:     logPath,
+	})
+	var startErr *StartError
+	if !errors.As(err, &startErr) {
+		t.Fatalf("expected a StartError, got %v", err)
+	}
+	if len(startErr.Output) != 1 || !strings.Contains(err.Error(), "Could not open 'missing.img'") {
+		t.Errorf("error lacks QEMU output: %v", err)
+	}
+	// The exit is noticed without waiting for the socket timeout
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("StartVM took %v", d)
+	}
+	if data, _ := os.ReadFile(logPath); !strings.Contains(string(data), "missing.img") {
+		t.Errorf("output log has %q", data)
+	}
+}
+
+func TestStartTranslatesArgs(t *testing.T) {
+	dir := t.TempDir()
+	qemu := filepath.Join(dir, "qemu-system-x86_64")
+	argsPath := filepath.Join(dir, "args")
+	script := "#!/bin/sh\nif [ \"$1\" = -version ]; then echo 'QEMU emulator version 9.2.0'; exit 0; fi\n" +
+		"printf '%s\\n' \"$@\" > " + argsPath + "\nexit 1\n"
+	if err := os.WriteFile(qemu, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a chroot, options are still translated for the QEMU version
+	kvm := false
+	cfg := DefaultConfig()
+	cfg.Name = "compat"
+	cfg.Arch = "amd64"
+	cfg.QemuPath = qemu
+	cfg.SocketDir = dir
+	cfg.KVM = &kvm
+	cfg.ExtraArgs = []string{"-chardev", "socket,id=c0,path=/tmp/c0.sock,reconnect=5"}
+	if _, err := Start(cfg); err == nil {
+		t.Fatal("Start succeeded with a failing QEMU")
+	}
+	data, err := os.ReadFile(argsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "socket,id=c0,path=/tmp/c0.sock,reconnect-ms=5000\n") {
+		t.Errorf("reconnect not translated for QEMU 9.2:\n%s", data)
+	}
+}
+
 // matchError checks if err matches the target error type.
 func matchError(err error, target any) bool {
 	switch target.(type) {
@@ -0,0 +1,439 @@
+// Package qemuctltest provides test doubles for code built on qemuctl.
+//
+// Controller implements qemuctl.VMController entirely in memory: lifecycle
+// methods update its state, every call is recorded, and errors can be
+// injected per method:
+//
+//	vm := qemuctltest.NewController("web", nil)
+//	vm.SetError("Pause", errors.New("boom"))
+//
+//	err := myPackage.Suspend(vm) // takes a qemuctl.VMController
+//	if vm.CallCount("Pause") != 1 {
+//		t.Error("Pause was not called")
+//	}
+//
+// CheckGolden compares the command line a configuration builds with a
+// golden file.
+//
+// To test against the QMP protocol itself, use the qmpmock package instead.
+package qemuctltest
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/KarpelesLab/qemuctl"
+)
+
+// Call is a method call recorded by a Controller.
+type Call struct {
+	Method string
+	Args   []any
+}
+
+// Controller is a fake qemuctl.VMController. It starts in the running
+// state. Pause and Continue switch between paused and running; Shutdown,
+// Stop, StopContext, ForceStop and Quit end the fake VM as Exit does.
+//
+// The exported fields hold canned results and must be set before the
+// controller is shared between goroutines.
+type Controller struct {
+	// PIDValue is returned by PID.
+	PIDValue int
+
+	// Socket is returned by SocketPath.
+	Socket string
+
+	// WarningList is returned by Warnings.
+	WarningList []string
+
+	// Timings is returned by BootTimings.
+	Timings qemuctl.BootTimings
+
+	// BlockDevices is returned by QueryBlockDevices.
+	BlockDevices []qemuctl.BlockInfo
+
+	// BlockNodes is returned by QueryNamedBlockNodes.
+	BlockNodes []qemuctl.BlockNode
+
+	// Schema is returned by QueryQMPSchema.
+	Schema []qemuctl.QMPSchemaEntry
+
+	// MonitorOutput maps human monitor commands to their output.
+	MonitorOutput map[string]string
+
+	name   string
+	config *qemuctl.VMConfig
+	events chan *qemuctl.Event
+	exited chan struct{}
+
+	mu       sync.Mutex
+	state    qemuctl.State
+	exitErr  error
+	onState  func(qemuctl.State)
+	onEvent  func(*qemuctl.Event)
+	errs     map[string]error
+	calls    []Call
+	exitOnce sync.Once
+}
+
+var _ qemuctl.VMController = (*Controller)(nil)
+
+// NewController returns a running fake VM. cfg is returned by VMConfig and
+// may be nil.
+func NewController(name string, cfg *qemuctl.VMConfig) *Controller {
+	return &Controller{
+		name:   name,
+		config: cfg,
+		state:  qemuctl.StateRunning,
+		events: make(chan *qemuctl.Event, 100),
+		exited: make(chan struct{}),
+		errs:   make(map[string]error),
+	}
+}
+
+// SetError makes method fail with err from now on. A nil err clears it. A
+// failing method is still recorded but has no other effect.
+func (c *Controller) SetError(method string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil {
+		delete(c.errs, method)
+		return
+	}
+	c.errs[method] = err
+}
+
+// Calls returns every recorded call, in order.
+func (c *Controller) Calls() []Call {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Call(nil), c.calls...)
+}
+
+// CallCount returns how many times method was called.
+func (c *Controller) CallCount(method string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, call := range c.calls {
+		if call.Method == method {
+			n++
+		}
+	}
+	return n
+}
+
+// SetState changes the state, calling the state change callback if it
+// differs from the current one.
+func (c *Controller) SetState(s qemuctl.State) {
+	c.mu.Lock()
+	old := c.state
+	c.state = s
+	cb := c.onState
+	c.mu.Unlock()
+
+	if old != s && cb != nil {
+		cb(s)
+	}
+}
+
+// SendEvent delivers an event to the event callback and the Events
+// channel. The event is dropped from the channel if it is full, as with a
+// real instance, and not sent at all once the fake VM has exited.
+func (c *Controller) SendEvent(name string, data map[string]any) {
+	event := &qemuctl.Event{Name: name, Data: data, Timestamp: time.Now()}
+
+	c.mu.Lock()
+	cb := c.onEvent
+	select {
+	case <-c.exited:
+		c.mu.Unlock()
+		return
+	default:
+	}
+	select {
+	case c.events <- event:
+	default:
+	}
+	c.mu.Unlock()
+
+	if cb != nil {
+		cb(event)
+	}
+}
+
+// Exit ends the fake VM: the state becomes shutdown, the Events channel is
+// closed and Wait returns err. Later calls have no effect.
+func (c *Controller) Exit(err error) {
+	c.exitOnce.Do(func() {
+		c.SetState(qemuctl.StateShutdown)
+
+		c.mu.Lock()
+		c.exitErr = err
+		close(c.exited)
+		close(c.events)
+		c.mu.Unlock()
+	})
+}
+
+// record logs a call and returns the error injected for it, or
+// ErrNotConnected if the fake VM has exited.
+func (c *Controller) record(method string, args ...any) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.calls = append(c.calls, Call{Method: method, Args: args})
+	if err := c.errs[method]; err != nil {
+		return err
+	}
+	select {
+	case <-c.exited:
+		return qemuctl.ErrNotConnected
+	default:
+	}
+	return nil
+}
+
+// Name returns the instance name.
+func (c *Controller) Name() string { return c.name }
+
+// PID returns PIDValue.
+func (c *Controller) PID() int { return c.PIDValue }
+
+// SocketPath returns Socket.
+func (c *Controller) SocketPath() string { return c.Socket }
+
+// VMConfig returns the configuration given to NewController.
+func (c *Controller) VMConfig() *qemuctl.VMConfig { return c.config }
+
+// Warnings returns WarningList.
+func (c *Controller) Warnings() []string { return c.WarningList }
+
+// BootTimings returns Timings.
+func (c *Controller) BootTimings() qemuctl.BootTimings { return c.Timings }
+
+// State returns the current state.
+func (c *Controller) State() qemuctl.State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+// QueryState is recorded and otherwise does nothing.
+func (c *Controller) QueryState() error {
+	return c.record("QueryState")
+}
+
+// SetStateChangeCallback sets the callback called by SetState.
+func (c *Controller) SetStateChangeCallback(cb func(qemuctl.State)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onState = cb
+}
+
+// SetEventCallback sets the callback called by SendEvent.
+func (c *Controller) SetEventCallback(cb func(*qemuctl.Event)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onEvent = cb
+}
+
+// Events returns the channel fed by SendEvent.
+func (c *Controller) Events() <-chan *qemuctl.Event { return c.events }
+
+// Continue switches the state to running.
+func (c *Controller) Continue() error {
+	return c.transition("Continue", qemuctl.StateRunning)
+}
+
+// Pause switches the state to paused.
+func (c *Controller) Pause() error {
+	return c.transition("Pause", qemuctl.StatePaused)
+}
+
+// Reset is recorded and otherwise does nothing.
+func (c *Controller) Reset() error {
+	return c.record("Reset")
+}
+
+// Shutdown ends the fake VM as if the guest powered off.
+func (c *Controller) Shutdown() error {
+	return c.stop("Shutdown")
+}
+
+// Stop ends the fake VM.
+func (c *Controller) Stop(timeout time.Duration) error {
+	return c.stop("Stop", timeout)
+}
+
+// StopContext ends the fake VM.
+func (c *Controller) StopContext(ctx context.Context, timeout time.Duration) error {
+	return c.stop("StopContext", timeout)
+}
+
+// ForceStop ends the fake VM.
+func (c *Controller) ForceStop() error {
+	return c.stop("ForceStop")
+}
+
+// Quit ends the fake VM.
+func (c *Controller) Quit() error {
+	return c.stop("Quit")
+}
+
+// Wait blocks until the fake VM exits and returns the error given to Exit.
+func (c *Controller) Wait() error {
+	return c.WaitContext(context.Background())
+}
+
+// WaitContext is like Wait but returns early if ctx is done.
+func (c *Controller) WaitContext(ctx context.Context) error {
+	select {
+	case <-c.exited:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.exitErr
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ExecuteBatch returns an empty object for each command.
+func (c *Controller) ExecuteBatch(cmds []qemuctl.BatchCommand) ([]qemuctl.BatchResult, error) {
+	if err := c.record("ExecuteBatch", cmds); err != nil {
+		return nil, err
+	}
+	results := make([]qemuctl.BatchResult, len(cmds))
+	for idx := range results {
+		results[idx].Return = json.RawMessage("{}")
+	}
+	return results, nil
+}
+
+// HumanMonitorCommand returns MonitorOutput[cmd].
+func (c *Controller) HumanMonitorCommand(cmd string) (string, error) {
+	if err := c.record("HumanMonitorCommand", cmd); err != nil {
+		return "", err
+	}
+	return c.MonitorOutput[cmd], nil
+}
+
+// SendKey is recorded and otherwise does nothing.
+func (c *Controller) SendKey(keys ...string) error {
+	return c.record("SendKey", keys)
+}
+
+// Screendump is recorded and otherwise does nothing.
+func (c *Controller) Screendump(filename string) error {
+	return c.record("Screendump", filename)
+}
+
+// QueryBlockDevices returns BlockDevices.
+func (c *Controller) QueryBlockDevices() ([]qemuctl.BlockInfo, error) {
+	if err := c.record("QueryBlockDevices"); err != nil {
+		return nil, err
+	}
+	return c.BlockDevices, nil
+}
+
+// QueryNamedBlockNodes returns BlockNodes.
+func (c *Controller) QueryNamedBlockNodes(flat bool) ([]qemuctl.BlockNode, error) {
+	if err := c.record("QueryNamedBlockNodes", flat); err != nil {
+		return nil, err
+	}
+	return c.BlockNodes, nil
+}
+
+// QueryQMPSchema returns Schema.
+func (c *Controller) QueryQMPSchema() ([]qemuctl.QMPSchemaEntry, error) {
+	if err := c.record("QueryQMPSchema"); err != nil {
+		return nil, err
+	}
+	return c.Schema, nil
+}
+
+// SetIOThrottle is recorded and otherwise does nothing.
+func (c *Controller) SetIOThrottle(device string, bps, iops uint64) error {
+	return c.record("SetIOThrottle", device, bps, iops)
+}
+
+// BlockdevAdd is recorded and otherwise does nothing.
+func (c *Controller) BlockdevAdd(options map[string]any) error {
+	return c.record("BlockdevAdd", options)
+}
+
+// BlockdevDel is recorded and otherwise does nothing.
+func (c *Controller) BlockdevDel(nodeName string) error {
+	return c.record("BlockdevDel", nodeName)
+}
+
+// BlockdevCreate is recorded and otherwise does nothing.
+func (c *Controller) BlockdevCreate(ctx context.Context, options map[string]any) error {
+	return c.record("BlockdevCreate", options)
+}
+
+// CreateImage is recorded and otherwise does nothing.
+func (c *Controller) CreateImage(ctx context.Context, nodeName string, backend qemuctl.DiskBackend, opts *qemuctl.LiveImageOptions) error {
+	return c.record("CreateImage", nodeName, backend, opts)
+}
+
+// AddVNCClient is recorded and closes conn.
+func (c *Controller) AddVNCClient(conn net.Conn, skipAuth bool) error {
+	return c.addClient("AddVNCClient", conn, skipAuth)
+}
+
+// AddSpiceClient is recorded and closes conn.
+func (c *Controller) AddSpiceClient(conn net.Conn, skipAuth bool) error {
+	return c.addClient("AddSpiceClient", conn, skipAuth)
+}
+
+// SetVNCPassword is recorded and otherwise does nothing.
+func (c *Controller) SetVNCPassword(password string) error {
+	return c.record("SetVNCPassword", password)
+}
+
+// SetSpicePassword is recorded and otherwise does nothing.
+func (c *Controller) SetSpicePassword(password string) error {
+	return c.record("SetSpicePassword", password)
+}
+
+// ExpireVNCPassword is recorded and otherwise does nothing.
+func (c *Controller) ExpireVNCPassword(expireTime string) error {
+	return c.record("ExpireVNCPassword", expireTime)
+}
+
+// ExpireSpicePassword is recorded and otherwise does nothing.
+func (c *Controller) ExpireSpicePassword(expireTime string) error {
+	return c.record("ExpireSpicePassword", expireTime)
+}
+
+// transition records a call and switches to state s if it succeeds.
+func (c *Controller) transition(method string, s qemuctl.State) error {
+	if err := c.record(method); err != nil {
+		return err
+	}
+	c.SetState(s)
+	return nil
+}
+
+// stop records a call and ends the fake VM if it succeeds.
+func (c *Controller) stop(method string, args ...any) error {
+	if err := c.record(method, args...); err != nil {
+		return err
+	}
+	c.Exit(nil)
+	return nil
+}
+
+// addClient records a call and closes conn, since the fake has no display
+// to hand it to.
+func (c *Controller) addClient(method string, conn net.Conn, skipAuth bool) error {
+	if err := c.record(method, skipAuth); err != nil {
+		return err
+	}
+	return conn.Close()
+}
@@ -0,0 +1,106 @@
+package qemuctltest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/KarpelesLab/qemuctl"
+)
+
+// suspend is an example of code written against qemuctl.VMController.
+func suspend(vm qemuctl.VMController) error {
+	if vm.State() != qemuctl.StateRunning {
+		return nil
+	}
+	return vm.Pause()
+}
+
+func TestControllerLifecycle(t *testing.T) {
+	vm := NewController("test", nil)
+
+	var states []qemuctl.State
+	vm.SetStateChangeCallback(func(s qemuctl.State) { states = append(states, s) })
+
+	if err := suspend(vm); err != nil {
+		t.Fatalf("suspend failed: %v", err)
+	}
+	if vm.State() != qemuctl.StatePaused {
+		t.Errorf("state = %v, want paused", vm.State())
+	}
+	if err := vm.Continue(); err != nil {
+		t.Fatalf("Continue failed: %v", err)
+	}
+	if err := vm.Stop(time.Second); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+
+	want := []qemuctl.State{qemuctl.StatePaused, qemuctl.StateRunning, qemuctl.StateShutdown}
+	if len(states) != len(want) {
+		t.Fatalf("states = %v, want %v", states, want)
+	}
+	for idx := range want {
+		if states[idx] != want[idx] {
+			t.Errorf("states[%d] = %v, want %v", idx, states[idx], want[idx])
+		}
+	}
+
+	if err := vm.Wait(); err != nil {
+		t.Errorf("Wait = %v, want nil", err)
+	}
+	if err := vm.Pause(); !errors.Is(err, qemuctl.ErrNotConnected) {
+		t.Errorf("Pause after exit = %v, want ErrNotConnected", err)
+	}
+	if _, ok := <-vm.Events(); ok {
+		t.Error("Events channel not closed after exit")
+	}
+}
+
+func TestControllerErrors(t *testing.T) {
+	vm := NewController("test", nil)
+	boom := errors.New("boom")
+	vm.SetError("Pause", boom)
+
+	if err := suspend(vm); err != boom {
+		t.Errorf("suspend = %v, want %v", err, boom)
+	}
+	if vm.State() != qemuctl.StateRunning {
+		t.Errorf("state = %v, want running after failed Pause", vm.State())
+	}
+	if n := vm.CallCount("Pause"); n != 1 {
+		t.Errorf("Pause called %d times, want 1", n)
+	}
+
+	vm.SetError("Pause", nil)
+	if err := vm.Pause(); err != nil {
+		t.Errorf("Pause after clearing error = %v", err)
+	}
+}
+
+func TestControllerEventsAndWait(t *testing.T) {
+	vm := NewController("test", nil)
+	vm.MonitorOutput = map[string]string{"info status": "VM status: running"}
+
+	vm.SendEvent("RTC_CHANGE", map[string]any{"offset": 1})
+	if event := <-vm.Events(); event.Name != "RTC_CHANGE" {
+		t.Errorf("event = %q, want RTC_CHANGE", event.Name)
+	}
+
+	out, err := vm.HumanMonitorCommand("info status")
+	if err != nil || out != "VM status: running" {
+		t.Errorf("HumanMonitorCommand = %q, %v", out, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := vm.WaitContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("WaitContext = %v, want deadline exceeded", err)
+	}
+
+	exitErr := errors.New("exit status 1")
+	vm.Exit(exitErr)
+	if err := vm.Wait(); err != exitErr {
+		t.Errorf("Wait = %v, want %v", err, exitErr)
+	}
+}
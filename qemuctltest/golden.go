@@ -0,0 +1,43 @@
+package qemuctltest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/KarpelesLab/qemuctl"
+)
+
+// UpdateGoldenEnv is the environment variable that makes CheckGolden
+// rewrite golden files instead of comparing against them.
+const UpdateGoldenEnv = "QEMUCTL_UPDATE_GOLDEN"
+
+// CheckGolden compares a QEMU command line, in the form of
+// qemuctl.CanonicalArgs, with the golden file at path and fails the test
+// if they differ. Run the tests with QEMUCTL_UPDATE_GOLDEN=1 to create or
+// update the golden files:
+//
+//	args := qemuctl.NewVMBuilder(cfg).Build("web", "/run/web.sock")
+//	qemuctltest.CheckGolden(t, "testdata/web.args", args)
+func CheckGolden(t testing.TB, path string, args []string) {
+	t.Helper()
+	got := qemuctl.CanonicalArgs(args)
+
+	if os.Getenv(UpdateGoldenEnv) != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create golden directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatalf("failed to write golden file: %v", err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read golden file (set %s=1 to create it): %v", UpdateGoldenEnv, err)
+	}
+	if got != string(want) {
+		t.Errorf("command line differs from %s (set %s=1 to update):\ngot:\n%swant:\n%s", path, UpdateGoldenEnv, got, want)
+	}
+}
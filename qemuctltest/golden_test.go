@@ -0,0 +1,33 @@
+package qemuctltest
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/KarpelesLab/qemuctl"
+)
+
+func TestCheckGolden(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "testdata", "vm.args")
+	args := qemuctl.NewVMBuilder(qemuctl.DefaultVMConfig()).Build("vm", "/run/vm.sock")
+
+	t.Setenv(UpdateGoldenEnv, "1")
+	CheckGolden(t, path, args)
+
+	t.Setenv(UpdateGoldenEnv, "")
+	CheckGolden(t, path, args)
+
+	rec := &failRecorder{TB: t}
+	CheckGolden(rec, path, append(args, "-S"))
+	if !rec.failed {
+		t.Error("CheckGolden accepted a different command line")
+	}
+}
+
+// failRecorder records test failures instead of reporting them.
+type failRecorder struct {
+	testing.TB
+	failed bool
+}
+
+func (r *failRecorder) Errorf(string, ...any) { r.failed = true }
@@ -2,9 +2,14 @@ package qemuctl
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"syscall"
@@ -15,13 +20,19 @@ import (
 type QMP struct {
 	conn       net.Conn
 	connMu     sync.Mutex
-	reader     *bufio.Reader
 	cmdCounter atomic.Uint64
+	oob        atomic.Bool
+	version    atomic.Pointer[QemuVersion]
+	limiter    atomic.Pointer[commandLimiter]
+	wireLog    atomic.Pointer[func(WireDirection, []byte)]
 
 	// Command response routing
 	pending   map[string]chan *qmpResponse
 	pendingMu sync.Mutex
 
+	// Batched writes, see writeLoop
+	writeCh chan *qmpWrite
+
 	// Event handling
 	eventCh chan *Event
 	closeCh chan struct{}
@@ -29,6 +40,22 @@ type QMP struct {
 	// Callbacks
 	onStateChange func(State)
 	onEvent       func(*Event)
+
+	// Internal event hooks, called before the public callbacks
+	hooks   []func(*Event)
+	hooksMu sync.Mutex
+
+	// Event subscriptions, see Subscribe
+	subs       map[*Subscription]struct{}
+	subsMu     sync.Mutex
+	subsClosed bool
+
+	// Reconnection, see SetReconnect
+	dial        func() (net.Conn, error)
+	reconnect   *ReconnectConfig
+	reconnectMu sync.Mutex
+	stopCh      chan struct{}
+	stopOnce    sync.Once
 }
 
 // Event represents a QMP event from QEMU.
@@ -38,9 +65,28 @@ type Event struct {
 	Timestamp time.Time
 }
 
+// qmpReadBufferSize is the read buffer size of QMP connections, large
+// enough that big responses (query-qmp-schema, query-named-block-nodes)
+// are read in few syscalls.
+const qmpReadBufferSize = 64 << 10
+
+// largeResponseTimeout is the timeout for commands whose responses can
+// reach several megabytes.
+const largeResponseTimeout = 2 * time.Minute
+
+// maxWriteBatch bounds the bytes coalesced into a single write.
+const maxWriteBatch = 64 << 10
+
+// qmpWrite is a pending write of one or more encoded commands.
+type qmpWrite struct {
+	data []byte
+	done chan error
+}
+
 // QMP message types
 type qmpCommand struct {
-	Execute   string         `json:"execute"`
+	Execute   string         `json:"execute,omitempty"`
+	ExecOOB   string         `json:"exec-oob,omitempty"`
 	Arguments map[string]any `json:"arguments,omitempty"`
 	ID        string         `json:"id,omitempty"`
 }
@@ -52,6 +98,9 @@ type qmpResponse struct {
 	Data      map[string]any  `json:"data,omitempty"`
 	Timestamp *qmpTimestamp   `json:"timestamp,omitempty"`
 	ID        string          `json:"id,omitempty"`
+
+	// err fails the command without a reply, e.g. on connection loss
+	err error
 }
 
 type qmpError struct {
@@ -74,32 +123,112 @@ func (e *QMPError) Error() string {
 	return fmt.Sprintf("QMP error [%s]: %s", e.Class, e.Description)
 }
 
+// TimeoutError is returned when QEMU does not answer a command in time.
+type TimeoutError struct {
+	Command string
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("command %q timeout after %v", e.Command, e.Timeout)
+}
+
 // newQMP creates a new QMP connection to the given socket path.
 func newQMP(socketPath string) (*QMP, error) {
+	dial := func() (net.Conn, error) {
+		return net.DialTimeout("unix", socketPath, reconnectDialTimeout)
+	}
+
 	conn, err := net.Dial("unix", socketPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to QMP socket: %w", err)
 	}
+	return newQMPConn(conn, dial)
+}
+
+// dialQMP creates a QMP connection to a TCP address, wrapped in TLS if
+// tlsConfig is not nil. The context bounds the connection and handshake.
+func dialQMP(ctx context.Context, address string, tlsConfig *tls.Config) (*QMP, error) {
+	if tlsConfig != nil && tlsConfig.ServerName == "" {
+		host, _, _ := net.SplitHostPort(address)
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName = host
+	}
+
+	dial := func() (net.Conn, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), reconnectDialTimeout)
+		defer cancel()
+		return dialTCP(ctx, address, tlsConfig)
+	}
+
+	conn, err := dialTCP(ctx, address, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	// Remote peers may stall, so bound the greeting and negotiation too
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
 
+	q, err := newQMPConn(conn, dial)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return q, nil
+}
+
+// dialTCP connects to a TCP address and performs the TLS handshake if
+// tlsConfig is not nil.
+func dialTCP(ctx context.Context, address string, tlsConfig *tls.Config) (net.Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to QMP address: %w", err)
+	}
+	if tlsConfig == nil {
+		return conn, nil
+	}
+
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("QMP TLS handshake failed: %w", err)
+	}
+	return tlsConn, nil
+}
+
+// newQMPConn performs the QMP handshake on an established connection.
+// dial opens a new connection to the same monitor for reconnection.
+func newQMPConn(conn net.Conn, dial func() (net.Conn, error)) (*QMP, error) {
 	q := &QMP{
 		conn:    conn,
+		dial:    dial,
 		pending: make(map[string]chan *qmpResponse),
+		writeCh: make(chan *qmpWrite),
 		eventCh: make(chan *Event, 100),
 		closeCh: make(chan struct{}),
+		stopCh:  make(chan struct{}),
 	}
 
+	// Responses are decoded straight from the connection rather than split
+	// into lines first, so multi-megabyte replies are only copied once
+	dec := newQMPDecoder(conn)
+
 	// Read QMP greeting (must be done before starting event loop)
-	if err := q.readGreeting(); err != nil {
+	greeting, err := q.readGreeting(dec)
+	if err != nil {
 		conn.Close()
 		return nil, err
 	}
 
 	// Start event loop
-	q.reader = bufio.NewReader(conn)
-	go q.eventLoop()
+	go q.eventLoop(dec)
+	go q.writeLoop()
 
 	// Send qmp_capabilities to enter command mode
-	if err := q.negotiate(); err != nil {
+	if err := q.negotiate(greeting); err != nil {
 		q.Close()
 		return nil, err
 	}
@@ -107,48 +236,80 @@ func newQMP(socketPath string) (*QMP, error) {
 	return q, nil
 }
 
-// readGreeting reads the initial QMP greeting message.
-func (q *QMP) readGreeting() error {
-	// Read one byte at a time to avoid buffering data the event loop needs
-	var buf []byte
-	b := make([]byte, 1)
-	for {
-		n, err := q.conn.Read(b)
-		if err != nil {
-			return fmt.Errorf("failed to read QMP greeting: %w", err)
-		}
-		if n > 0 {
-			buf = append(buf, b[0])
-			if b[0] == '\n' {
-				break
-			}
+// newQMPDecoder returns a JSON decoder reading QMP messages from conn.
+func newQMPDecoder(conn net.Conn) *json.Decoder {
+	return json.NewDecoder(bufio.NewReaderSize(conn, qmpReadBufferSize))
+}
+
+// qmpGreeting is the message QEMU sends when a client connects.
+type qmpGreeting struct {
+	QMP struct {
+		Version struct {
+			Qemu    QemuVersion `json:"qemu"`
+			Package string      `json:"package"`
+		} `json:"version"`
+		Capabilities []string `json:"capabilities"`
+	} `json:"QMP"`
+}
+
+// version returns the QEMU version announced by the greeting.
+func (g *qmpGreeting) version() QemuVersion {
+	v := g.QMP.Version.Qemu
+	v.Package = strings.TrimSpace(g.QMP.Version.Package)
+	return v
+}
+
+// hasCapability reports whether the server offers a capability.
+func (g *qmpGreeting) hasCapability(name string) bool {
+	for _, c := range g.QMP.Capabilities {
+		if c == name {
+			return true
 		}
 	}
+	return false
+}
 
-	var greeting struct {
-		QMP struct {
-			Version struct {
-				Qemu struct {
-					Major int `json:"major"`
-					Minor int `json:"minor"`
-					Micro int `json:"micro"`
-				} `json:"qemu"`
-			} `json:"version"`
-			Capabilities []string `json:"capabilities"`
-		} `json:"QMP"`
+// capabilitiesArgs returns the qmp_capabilities arguments enabling the
+// optional capabilities we support.
+func (g *qmpGreeting) capabilitiesArgs() map[string]any {
+	if !g.hasCapability("oob") {
+		return nil
 	}
+	return map[string]any{"enable": []string{"oob"}}
+}
 
-	if err := json.Unmarshal(buf, &greeting); err != nil {
-		return fmt.Errorf("failed to parse QMP greeting: %w", err)
+// readGreeting reads the initial QMP greeting message.
+func (q *QMP) readGreeting(dec *json.Decoder) (*qmpGreeting, error) {
+	greeting := &qmpGreeting{}
+	if err := q.decode(dec, greeting); err != nil {
+		return nil, fmt.Errorf("failed to read QMP greeting: %w", err)
 	}
+	return greeting, nil
+}
 
+// negotiate sends qmp_capabilities to enter command mode, enabling
+// out-of-band execution if offered.
+func (q *QMP) negotiate(greeting *qmpGreeting) error {
+	if _, err := q.Execute("qmp_capabilities", greeting.capabilitiesArgs()); err != nil {
+		return err
+	}
+	q.oob.Store(greeting.hasCapability("oob"))
+	q.setVersion(greeting.version())
 	return nil
 }
 
-// negotiate sends qmp_capabilities to enter command mode.
-func (q *QMP) negotiate() error {
-	_, err := q.Execute("qmp_capabilities", nil)
-	return err
+// setVersion records the QEMU version of the current connection.
+func (q *QMP) setVersion(v QemuVersion) {
+	q.version.Store(&v)
+}
+
+// Version returns the QEMU version announced when the connection was
+// established, or the zero version if QEMU did not report it.
+func (q *QMP) Version() QemuVersion {
+	if v := q.version.Load(); v != nil {
+		return *v
+	}
+	return QemuVersion{}
 }
 
 // Execute sends a QMP command and waits for the response.
@@ -157,52 +318,73 @@ func (q *QMP) Execute(command string, args map[string]any) (json.RawMessage, err
 }
 
 // ExecuteWithTimeout sends a QMP command with a custom timeout.
+//
+// It is safe to call from multiple goroutines: commands are pipelined on
+// the connection and responses are routed back by command ID.
 func (q *QMP) ExecuteWithTimeout(command string, args map[string]any, timeout time.Duration) (json.RawMessage, error) {
-	cmdID := fmt.Sprintf("cmd-%d", q.cmdCounter.Add(1))
+	return q.execute(context.Background(), qmpCommand{Execute: command, Arguments: args}, timeout)
+}
 
-	cmd := qmpCommand{
-		Execute:   command,
-		Arguments: args,
-		ID:        cmdID,
+// ExecuteContext sends a command and waits for its response until ctx is
+// done, or for 30 seconds if ctx has no deadline. The command is traced as
+// a child of the span in ctx (see SetTracer).
+func (q *QMP) ExecuteContext(ctx context.Context, command string, args map[string]any) (json.RawMessage, error) {
+	timeout := 30 * time.Second
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
 	}
+	return q.execute(ctx, qmpCommand{Execute: command, Arguments: args}, timeout)
+}
 
-	// Create response channel
-	respCh := make(chan *qmpResponse, 1)
-
-	// Register pending command
-	q.pendingMu.Lock()
-	if q.pending == nil {
-		q.pendingMu.Unlock()
-		return nil, fmt.Errorf("QMP connection closed")
+// ExecuteOOB runs a command out-of-band: QEMU executes it right away, even
+// while in-band commands are still queued or running. Only commands that
+// allow it can be used, such as migrate-pause, migrate-recover or
+// x-oob-test. It returns ErrOOBNotSupported if the monitor did not offer
+// out-of-band execution.
+func (q *QMP) ExecuteOOB(command string, args map[string]any) (json.RawMessage, error) {
+	if !q.oob.Load() {
+		return nil, ErrOOBNotSupported
 	}
-	q.pending[cmdID] = respCh
-	q.pendingMu.Unlock()
+	return q.execute(context.Background(), qmpCommand{ExecOOB: command, Arguments: args}, 30*time.Second)
+}
 
-	// Cleanup on exit
-	defer func() {
-		q.pendingMu.Lock()
-		delete(q.pending, cmdID)
-		q.pendingMu.Unlock()
-	}()
+// OOBEnabled reports whether out-of-band execution was negotiated.
+func (q *QMP) OOBEnabled() bool {
+	return q.oob.Load()
+}
 
-	// Send command
-	q.connMu.Lock()
-	if q.conn == nil {
-		q.connMu.Unlock()
-		return nil, fmt.Errorf("QMP connection closed")
-	}
+// execute sends a command and waits for its response, or until ctx is
+// done.
+func (q *QMP) execute(ctx context.Context, cmd qmpCommand, timeout time.Duration) (_ json.RawMessage, err error) {
+	cmd.ID = q.nextID()
+	command := cmd.Execute + cmd.ExecOOB
+
+	ctx, span := startSpan(ctx, "qmp."+command, AttrQMPCommand, command)
+	defer func() { endSpan(span, err) }()
 
 	data, err := json.Marshal(cmd)
 	if err != nil {
-		q.connMu.Unlock()
 		return nil, fmt.Errorf("failed to marshal command: %w", err)
 	}
 
-	if _, err := q.conn.Write(append(data, '\n')); err != nil {
-		q.connMu.Unlock()
-		return nil, fmt.Errorf("failed to write command: %w", err)
+	done := func(bool) {}
+	if cmd.ExecOOB == "" {
+		if done, err = q.admit(time.Now().Add(timeout)); err != nil {
+			return nil, err
+		}
+	}
+
+	respCh, err := q.register(cmd.ID)
+	if err != nil {
+		done(false)
+		return nil, err
+	}
+	defer q.unregister(cmd.ID)
+
+	if err := q.write(append(data, '\n')); err != nil {
+		done(false)
+		return nil, err
 	}
-	q.connMu.Unlock()
 
 	// Wait for response
 	timer := time.NewTimer(timeout)
@@ -210,54 +392,251 @@ func (q *QMP) ExecuteWithTimeout(command string, args map[string]any, timeout ti
 
 	select {
 	case resp := <-respCh:
-		if resp.Error != nil {
-			return nil, &QMPError{
-				Class:       resp.Error.Class,
-				Description: resp.Error.Desc,
-			}
-		}
-		return resp.Return, nil
+		done(false)
+		return resp.result()
 	case <-timer.C:
-		return nil, fmt.Errorf("command %q timeout after %v", command, timeout)
+		done(true)
+		return nil, &TimeoutError{Command: command, Timeout: timeout}
+	case <-ctx.Done():
+		done(false)
+		return nil, ctx.Err()
 	case <-q.closeCh:
-		return nil, fmt.Errorf("QMP connection closed")
+		done(false)
+		return nil, q.closedErr()
 	}
 }
 
-// ExecuteWithFd sends a QMP command with a file descriptor via SCM_RIGHTS.
-func (q *QMP) ExecuteWithFd(command string, args map[string]any, fd int) (json.RawMessage, error) {
-	cmdID := fmt.Sprintf("cmd-%d", q.cmdCounter.Add(1))
+// BatchCommand is a command sent with ExecuteBatch.
+type BatchCommand struct {
+	Execute   string
+	Arguments map[string]any
+}
 
-	cmd := qmpCommand{
-		Execute:   command,
-		Arguments: args,
-		ID:        cmdID,
+// BatchResult is the outcome of one command sent with ExecuteBatch.
+type BatchResult struct {
+	Return json.RawMessage
+	Err    error
+}
+
+// ExecuteBatch sends several commands in a single write and waits for all
+// of their responses. QEMU runs them in order, but each command succeeds or
+// fails on its own; per-command errors are reported in the results, while
+// the returned error covers the batch as a whole (write failure, timeout,
+// closed connection).
+func (q *QMP) ExecuteBatch(cmds []BatchCommand, timeout time.Duration) (_ []BatchResult, err error) {
+	_, span := startSpan(context.Background(), "qmp.batch", AttrQMPCommand, "batch")
+	defer func() { endSpan(span, err) }()
+
+	ids := make([]string, len(cmds))
+	chans := make([]chan *qmpResponse, len(cmds))
+	var data []byte
+
+	defer func() {
+		for idx, id := range ids {
+			if chans[idx] != nil {
+				q.unregister(id)
+			}
+		}
+	}()
+
+	for idx, c := range cmds {
+		ids[idx] = q.nextID()
+		cmdData, err := json.Marshal(qmpCommand{
+			Execute:   c.Execute,
+			Arguments: c.Arguments,
+			ID:        ids[idx],
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal command %q: %w", c.Execute, err)
+		}
+		data = append(append(data, cmdData...), '\n')
+
+		if chans[idx], err = q.register(ids[idx]); err != nil {
+			return nil, err
+		}
+	}
+
+	// A batch counts as a single command against the limits
+	done, err := q.admit(time.Now().Add(timeout))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > 0 {
+		if err := q.write(data); err != nil {
+			done(false)
+			return nil, err
+		}
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	results := make([]BatchResult, len(cmds))
+	for idx, ch := range chans {
+		select {
+		case resp := <-ch:
+			results[idx].Return, results[idx].Err = resp.result()
+		case <-timer.C:
+			done(true)
+			return results, fmt.Errorf("command batch timeout after %v", timeout)
+		case <-q.closeCh:
+			done(false)
+			return results, q.closedErr()
+		}
 	}
+	done(false)
+	return results, nil
+}
+
+// nextID returns a new command ID.
+func (q *QMP) nextID() string {
+	return "cmd-" + strconv.FormatUint(q.cmdCounter.Add(1), 10)
+}
 
-	// Create response channel
+// register creates the response channel for a command ID.
+func (q *QMP) register(cmdID string) (chan *qmpResponse, error) {
 	respCh := make(chan *qmpResponse, 1)
 
-	// Register pending command
 	q.pendingMu.Lock()
+	defer q.pendingMu.Unlock()
 	if q.pending == nil {
-		q.pendingMu.Unlock()
-		return nil, fmt.Errorf("QMP connection closed")
+		return nil, q.closedErr()
 	}
 	q.pending[cmdID] = respCh
+	return respCh, nil
+}
+
+// unregister removes the response channel for a command ID.
+func (q *QMP) unregister(cmdID string) {
+	q.pendingMu.Lock()
+	delete(q.pending, cmdID)
 	q.pendingMu.Unlock()
+}
 
-	// Cleanup on exit
-	defer func() {
-		q.pendingMu.Lock()
-		delete(q.pending, cmdID)
-		q.pendingMu.Unlock()
-	}()
+// result converts a command response to its return value or error.
+func (r *qmpResponse) result() (json.RawMessage, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.Error != nil {
+		return nil, &QMPError{
+			Class:       r.Error.Class,
+			Description: r.Error.Desc,
+		}
+	}
+	return r.Return, nil
+}
+
+// write queues encoded commands for the write loop and waits until they
+// have been written.
+func (q *QMP) write(data []byte) error {
+	w := &qmpWrite{data: data, done: make(chan error, 1)}
+
+	select {
+	case q.writeCh <- w:
+	case <-q.closeCh:
+		return q.closedErr()
+	}
+
+	select {
+	case err := <-w.done:
+		return err
+	case <-q.closeCh:
+		return q.closedErr()
+	}
+}
+
+// writeLoop writes queued commands to the connection. Commands queued
+// while a write is in progress are coalesced into the next write, so bursts
+// from concurrent callers cost one syscall instead of one per command.
+func (q *QMP) writeLoop() {
+	var buf []byte
+	var batch []*qmpWrite
+
+	for {
+		select {
+		case w := <-q.writeCh:
+			buf = append(buf[:0], w.data...)
+			batch = append(batch[:0], w)
+		case <-q.closeCh:
+			return
+		}
+
+	drain:
+		for len(buf) < maxWriteBatch {
+			select {
+			case w := <-q.writeCh:
+				buf = append(buf, w.data...)
+				batch = append(batch, w)
+			default:
+				break drain
+			}
+		}
+
+		err := q.writeConn(buf)
+		if err == nil {
+			q.logSent(buf)
+		}
+		for idx, w := range batch {
+			w.done <- err
+			batch[idx] = nil
+		}
+	}
+}
+
+// writeConn writes data to the connection.
+func (q *QMP) writeConn(data []byte) error {
+	q.connMu.Lock()
+	defer q.connMu.Unlock()
+
+	if q.conn == nil {
+		return q.closedErr()
+	}
+	if _, err := q.conn.Write(data); err != nil {
+		return fmt.Errorf("failed to write command: %w", err)
+	}
+	return nil
+}
+
+// ExecuteWithFd sends a QMP command with a file descriptor via SCM_RIGHTS.
+func (q *QMP) ExecuteWithFd(command string, args map[string]any, fd int) (_ json.RawMessage, err error) {
+	_, span := startSpan(context.Background(), "qmp."+command, AttrQMPCommand, command)
+	defer func() { endSpan(span, err) }()
+
+	done, err := q.admit(time.Now().Add(30 * time.Second))
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := q.executeWithFd(command, args, fd)
+	var timeoutErr *TimeoutError
+	done(errors.As(err, &timeoutErr))
+	return result, err
+}
+
+// executeWithFd sends a command with a file descriptor and waits for the
+// response.
+func (q *QMP) executeWithFd(command string, args map[string]any, fd int) (json.RawMessage, error) {
+	cmdID := q.nextID()
+
+	cmd := qmpCommand{
+		Execute:   command,
+		Arguments: args,
+		ID:        cmdID,
+	}
+
+	respCh, err := q.register(cmdID)
+	if err != nil {
+		return nil, err
+	}
+	defer q.unregister(cmdID)
 
 	// Send command with SCM_RIGHTS
 	q.connMu.Lock()
 	if q.conn == nil {
 		q.connMu.Unlock()
-		return nil, fmt.Errorf("QMP connection closed")
+		return nil, q.closedErr()
 	}
 
 	unixConn, ok := q.conn.(*net.UnixConn)
@@ -291,6 +670,7 @@ func (q *QMP) ExecuteWithFd(command string, args map[string]any, fd int) (json.R
 	if sendErr != nil {
 		return nil, fmt.Errorf("failed to send fd via SCM_RIGHTS: %w", sendErr)
 	}
+	q.logSent(cmdData)
 
 	// Wait for response
 	timer := time.NewTimer(30 * time.Second)
@@ -298,25 +678,22 @@ func (q *QMP) ExecuteWithFd(command string, args map[string]any, fd int) (json.R
 
 	select {
 	case resp := <-respCh:
-		if resp.Error != nil {
-			return nil, &QMPError{
-				Class:       resp.Error.Class,
-				Description: resp.Error.Desc,
-			}
-		}
-		return resp.Return, nil
+		return resp.result()
 	case <-timer.C:
-		return nil, fmt.Errorf("command %q timeout", command)
+		return nil, &TimeoutError{Command: command, Timeout: 30 * time.Second}
 	case <-q.closeCh:
-		return nil, fmt.Errorf("QMP connection closed")
+		return nil, q.closedErr()
 	}
 }
 
-// eventLoop reads and dispatches QMP events and command responses.
-func (q *QMP) eventLoop() {
+// eventLoop reads and dispatches QMP events and command responses. When
+// the connection is lost it fails in-flight commands and, if enabled,
+// reconnects before resuming.
+func (q *QMP) eventLoop(dec *json.Decoder) {
 	defer func() {
 		close(q.closeCh)
 		close(q.eventCh)
+		q.closeSubscriptions()
 
 		// Clear pending commands
 		q.pendingMu.Lock()
@@ -325,14 +702,31 @@ func (q *QMP) eventLoop() {
 	}()
 
 	for {
-		line, err := q.reader.ReadBytes('\n')
-		if err != nil {
+		q.readLoop(dec)
+
+		// Drop the connection first so no command can be written to it
+		// after the pending ones have been failed
+		q.dropConn()
+		q.failPending(ErrConnectionLost)
+
+		if dec = q.redial(); dec == nil {
 			return
 		}
+	}
+}
 
+// readLoop dispatches messages until the connection fails.
+func (q *QMP) readLoop(dec *json.Decoder) {
+	for {
 		var resp qmpResponse
-		if err := json.Unmarshal(line, &resp); err != nil {
-			continue
+		if err := q.decode(dec, &resp); err != nil {
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &typeErr) {
+				// The message was consumed, only its shape was unexpected
+				continue
+			}
+			// Syntax or read error: the stream cannot be resynchronized
+			return
 		}
 
 		if resp.ID != "" {
@@ -357,6 +751,13 @@ func (q *QMP) eventLoop() {
 				event.Timestamp = time.Now()
 			}
 
+			q.hooksMu.Lock()
+			hooks := q.hooks
+			q.hooksMu.Unlock()
+			for _, hook := range hooks {
+				hook(event)
+			}
+
 			// Handle state change events
 			q.handleEvent(event)
 
@@ -366,6 +767,8 @@ func (q *QMP) eventLoop() {
 			default:
 			}
 
+			q.dispatch(event)
+
 			// Call event callback if set
 			if q.onEvent != nil {
 				q.onEvent(event)
@@ -384,6 +787,12 @@ func (q *QMP) handleEvent(event *Event) {
 	switch event.Name {
 	case "SHUTDOWN":
 		newState = StateShutdown
+		// Shutting down is the panic action; keep reporting the crash
+		if reason, _ := event.Data["reason"].(string); reason == "guest-panic" {
+			newState = StateCrashed
+		}
+	case "GUEST_PANICKED":
+		newState = StateCrashed
 	case "RESET":
 		newState = StateRunning
 	case "STOP":
@@ -401,6 +810,13 @@ func (q *QMP) handleEvent(event *Event) {
 	q.onStateChange(newState)
 }
 
+// addEventHook registers an internal event handler.
+func (q *QMP) addEventHook(fn func(*Event)) {
+	q.hooksMu.Lock()
+	defer q.hooksMu.Unlock()
+	q.hooks = append(q.hooks[:len(q.hooks):len(q.hooks)], fn)
+}
+
 // Events returns the event channel.
 func (q *QMP) Events() <-chan *Event {
 	return q.eventCh
@@ -416,8 +832,10 @@ func (q *QMP) SetStateChangeCallback(cb func(State)) {
 	q.onStateChange = cb
 }
 
-// Close closes the QMP connection.
+// Close closes the QMP connection and stops any reconnection attempts.
 func (q *QMP) Close() error {
+	q.stopOnce.Do(func() { close(q.stopCh) })
+
 	q.connMu.Lock()
 	defer q.connMu.Unlock()
 
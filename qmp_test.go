@@ -0,0 +1,403 @@
+package qemuctl
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/KarpelesLab/qemuctl/qmpmock"
+)
+
+// fakeQMP wraps a qmpmock server for testing Instance methods.
+type fakeQMP struct {
+	*qmpmock.Server
+	t    *testing.T
+	path string
+}
+
+// newFakeQMP starts a fake QMP server on a socket in a temporary directory.
+func newFakeQMP(t *testing.T) *fakeQMP {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "qmp.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	return startFakeQMP(t, ln, path)
+}
+
+// newFakeQMPTCP starts a fake QMP server on a loopback TCP port. The
+// address attach uses is "tcp:host:port".
+func newFakeQMPTCP(t *testing.T) *fakeQMP {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	return startFakeQMP(t, ln, "tcp:"+ln.Addr().String())
+}
+
+// startFakeQMP serves QMP on ln.
+func startFakeQMP(t *testing.T, ln net.Listener, path string) *fakeQMP {
+	t.Helper()
+
+	f := &fakeQMP{Server: qmpmock.Serve(ln), t: t, path: path}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+// setCapabilities sets the capabilities offered in the greeting of later
+// connections.
+func (f *fakeQMP) setCapabilities(caps ...string) {
+	f.SetCapabilities(caps...)
+}
+
+// handle sets the handler for a command.
+func (f *fakeQMP) handle(command string, fn func(args map[string]any) (any, *qmpError)) {
+	f.Handle(command, func(args map[string]any) (any, error) {
+		ret, qerr := fn(args)
+		if qerr != nil {
+			return nil, &qmpmock.Error{Class: qerr.Class, Desc: qerr.Desc}
+		}
+		return ret, nil
+	})
+}
+
+// attach connects an Instance to the fake server.
+func (f *fakeQMP) attach() *Instance {
+	f.t.Helper()
+	inst, err := AttachContext(context.Background(), f.path)
+	if err != nil {
+		f.t.Fatalf("failed to attach: %v", err)
+	}
+	qmp := inst.qmp
+	f.t.Cleanup(func() { qmp.Close() })
+	return inst
+}
+
+// commands returns the arguments of every call to command, in order.
+func (f *fakeQMP) commands(command string) []map[string]any {
+	var args []map[string]any
+	for _, c := range f.CallsTo(command) {
+		args = append(args, c.Arguments)
+	}
+	return args
+}
+
+// sendEvent sends an event to the connected client.
+func (f *fakeQMP) sendEvent(name string, data map[string]any) {
+	if err := f.SendEvent(name, data); err != nil {
+		f.t.Fatal(err)
+	}
+}
+
+// dropConnection closes the client connection. The server keeps accepting
+// new connections.
+func (f *fakeQMP) dropConnection() {
+	f.DropConnection()
+}
+
+func TestQMPExecuteBatch(t *testing.T) {
+	fake := newFakeQMP(t)
+	fake.handle("echo", func(args map[string]any) (any, *qmpError) {
+		return args, nil
+	})
+	inst := fake.attach()
+
+	results, err := inst.ExecuteBatch([]BatchCommand{
+		{Execute: "echo", Arguments: map[string]any{"n": 1}},
+		{Execute: "no-such-command"},
+		{Execute: "echo", Arguments: map[string]any{"n": 3}},
+	})
+	if err != nil {
+		t.Fatalf("ExecuteBatch failed: %v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+	if results[0].Err != nil || string(results[0].Return) != `{"n":1}` {
+		t.Errorf("result 0 = %s, %v", results[0].Return, results[0].Err)
+	}
+	var qerr *QMPError
+	if !errors.As(results[1].Err, &qerr) || qerr.Class != "CommandNotFound" {
+		t.Errorf("result 1 error = %v, want CommandNotFound", results[1].Err)
+	}
+	if results[2].Err != nil || string(results[2].Return) != `{"n":3}` {
+		t.Errorf("result 2 = %s, %v", results[2].Return, results[2].Err)
+	}
+}
+
+func TestQMPConcurrentExecute(t *testing.T) {
+	fake := newFakeQMP(t)
+	fake.handle("echo", func(args map[string]any) (any, *qmpError) {
+		return args, nil
+	})
+	qmp := fake.attach().QMP()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 200)
+	for n := 0; n < 200; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			ret, err := qmp.Execute("echo", map[string]any{"n": n})
+			if err != nil {
+				errs <- err
+				return
+			}
+			if want := fmt.Sprintf(`{"n":%d}`, n); string(ret) != want {
+				errs <- fmt.Errorf("got %s, want %s", ret, want)
+			}
+		}(n)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+	if calls := len(fake.commands("echo")); calls != 200 {
+		t.Errorf("server saw %d commands, want 200", calls)
+	}
+}
+
+func TestAttachTCP(t *testing.T) {
+	fake := newFakeQMPTCP(t)
+	inst := fake.attach()
+
+	if inst.SocketPath() != "" {
+		t.Errorf("SocketPath() = %q, want empty for TCP", inst.SocketPath())
+	}
+	if want := strings.TrimPrefix(fake.path, "tcp:"); inst.Name() != want {
+		t.Errorf("Name() = %q, want %q", inst.Name(), want)
+	}
+	if inst.State() != StateRunning {
+		t.Errorf("State() = %v, want running", inst.State())
+	}
+}
+
+func TestInstanceQemuVersion(t *testing.T) {
+	fake := newFakeQMP(t)
+	fake.SetVersion(9, 1, 2)
+	fake.SetPackage("Debian 1:9.1.2+ds-1 ")
+	inst := fake.attach()
+
+	want := QemuVersion{Major: 9, Minor: 1, Micro: 2, Package: "Debian 1:9.1.2+ds-1"}
+	if v := inst.QemuVersion(); v != want {
+		t.Errorf("QemuVersion() = %+v, want %+v", v, want)
+	}
+	if !inst.QemuVersion().AtLeast(9, 1) {
+		t.Error("QemuVersion().AtLeast(9, 1) = false")
+	}
+}
+
+func TestAttachTLS(t *testing.T) {
+	cert, pool := testCertificate(t)
+
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	ln := tls.NewListener(tcp, &tls.Config{Certificates: []tls.Certificate{cert}})
+	startFakeQMP(t, ln, "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The certificate is issued for "localhost", not the IP we dial
+	if _, err := AttachTLSContext(ctx, ln.Addr().String(), &tls.Config{RootCAs: pool}); err == nil {
+		t.Error("expected certificate name mismatch")
+	}
+
+	inst, err := AttachTLSContext(ctx, ln.Addr().String(), &tls.Config{RootCAs: pool, ServerName: "localhost"})
+	if err != nil {
+		t.Fatalf("AttachTLSContext failed: %v", err)
+	}
+	defer inst.QMP().Close()
+
+	if inst.State() != StateRunning {
+		t.Errorf("State() = %v, want running", inst.State())
+	}
+}
+
+// testCertificate returns a self-signed certificate for "localhost" and a
+// pool that trusts it.
+func testCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
+
+func TestQMPLargeResponse(t *testing.T) {
+	fake := newFakeQMP(t)
+
+	// Enough nodes to span many read buffers
+	nodes := make([]map[string]any, 20000)
+	for n := range nodes {
+		nodes[n] = map[string]any{
+			"node-name": fmt.Sprintf("node%d", n),
+			"drv":       "qcow2",
+			"file":      fmt.Sprintf("/var/lib/images/disk%d.qcow2", n),
+			"image": map[string]any{
+				"filename":     fmt.Sprintf("/var/lib/images/disk%d.qcow2", n),
+				"format":       "qcow2",
+				"virtual-size": 10 << 30,
+			},
+		}
+	}
+	fake.handle("query-named-block-nodes", func(map[string]any) (any, *qmpError) {
+		return nodes, nil
+	})
+	inst := fake.attach()
+
+	got, err := inst.QueryNamedBlockNodes(false)
+	if err != nil {
+		t.Fatalf("QueryNamedBlockNodes failed: %v", err)
+	}
+	if len(got) != len(nodes) {
+		t.Fatalf("got %d nodes, want %d", len(got), len(nodes))
+	}
+	if last := got[len(got)-1]; last.NodeName != "node19999" || last.Image == nil || last.Image.VirtualSize != 10<<30 {
+		t.Errorf("unexpected last node: %+v", last)
+	}
+
+	// The connection must still be usable afterwards
+	if err := inst.QueryState(); err != nil {
+		t.Errorf("QueryState after large response failed: %v", err)
+	}
+}
+
+func TestQMPConnectionLost(t *testing.T) {
+	fake := newFakeQMP(t)
+	fake.handle("drop", func(map[string]any) (any, *qmpError) {
+		fake.dropConnection()
+		return nil, nil
+	})
+	qmp := fake.attach().QMP()
+
+	if _, err := qmp.Execute("drop", nil); !errors.Is(err, ErrConnectionLost) {
+		t.Fatalf("in-flight command error = %v, want ErrConnectionLost", err)
+	}
+	if _, err := qmp.Execute("query-status", nil); !errors.Is(err, ErrConnectionLost) {
+		t.Errorf("later command error = %v, want ErrConnectionLost", err)
+	}
+
+	qmp.Close()
+	if _, err := qmp.Execute("query-status", nil); err == nil || errors.Is(err, ErrConnectionLost) {
+		t.Errorf("command after Close error = %v, want closed", err)
+	}
+}
+
+func TestQMPReconnect(t *testing.T) {
+	fake := newFakeQMP(t)
+	fake.handle("drop", func(map[string]any) (any, *qmpError) {
+		fake.dropConnection()
+		return nil, nil
+	})
+	inst := fake.attach()
+	inst.QMP().SetReconnect(&ReconnectConfig{InitialDelay: 10 * time.Millisecond})
+
+	// The VM is paused while we are disconnected
+	fake.handle("query-status", func(map[string]any) (any, *qmpError) {
+		return map[string]any{"status": "paused", "running": false}, nil
+	})
+
+	if _, err := inst.QMP().Execute("drop", nil); !errors.Is(err, ErrConnectionLost) {
+		t.Fatalf("in-flight command error = %v, want ErrConnectionLost", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for inst.State() != StatePaused {
+		if time.Now().After(deadline) {
+			t.Fatalf("state not resynchronized after reconnect, got %v", inst.State())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err := inst.QMP().Execute("query-status", nil); err != nil {
+		t.Errorf("command after reconnect failed: %v", err)
+	}
+	if n := len(fake.commands("qmp_capabilities")); n != 2 {
+		t.Errorf("qmp_capabilities sent %d times, want 2", n)
+	}
+}
+
+func TestQMPExecuteOOB(t *testing.T) {
+	fake := newFakeQMP(t)
+	fake.handle("migrate-pause", func(map[string]any) (any, *qmpError) {
+		return map[string]any{}, nil
+	})
+	qmp := fake.attach().QMP()
+
+	caps := fake.commands("qmp_capabilities")
+	if len(caps) != 1 || fmt.Sprint(caps[0]["enable"]) != "[oob]" {
+		t.Errorf("qmp_capabilities arguments = %v, want oob enabled", caps)
+	}
+	if !qmp.OOBEnabled() {
+		t.Fatal("OOBEnabled() = false")
+	}
+
+	if _, err := qmp.ExecuteOOB("migrate-pause", nil); err != nil {
+		t.Fatalf("ExecuteOOB failed: %v", err)
+	}
+	calls := fake.Calls()
+	last := calls[len(calls)-1]
+	if last.Command != "migrate-pause" || !last.OOB {
+		t.Errorf("last call = %+v, want out-of-band migrate-pause", last)
+	}
+}
+
+func TestQMPExecuteOOBUnsupported(t *testing.T) {
+	fake := newFakeQMP(t)
+	fake.setCapabilities()
+	qmp := fake.attach().QMP()
+
+	if caps := fake.commands("qmp_capabilities"); len(caps) != 1 || caps[0] != nil {
+		t.Errorf("qmp_capabilities arguments = %v, want none", caps)
+	}
+	if _, err := qmp.ExecuteOOB("migrate-pause", nil); !errors.Is(err, ErrOOBNotSupported) {
+		t.Errorf("ExecuteOOB error = %v, want ErrOOBNotSupported", err)
+	}
+}
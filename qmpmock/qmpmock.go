@@ -0,0 +1,300 @@
+// Package qmpmock provides a scriptable QMP server for testing code built on
+// qemuctl without QEMU or KVM.
+//
+// The server sends a QMP greeting to each client, answers commands with
+// canned or computed responses, records every command it receives and can
+// inject events:
+//
+//	srv, err := qmpmock.NewServer(filepath.Join(t.TempDir(), "qmp.sock"))
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	defer srv.Close()
+//
+//	srv.HandleReturn("query-block", []any{})
+//	srv.HandleError("device_del", "DeviceNotFound", "Device 'net1' not found")
+//
+//	inst, err := qemuctl.Attach(srv.SocketPath())
+//	...
+//	srv.SendEvent("DEVICE_DELETED", map[string]any{"device": "net0"})
+package qmpmock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Handler computes the response to a command. Returning an *Error sends
+// that QMP error; any other error is sent as a GenericError.
+type Handler func(args map[string]any) (any, error)
+
+// Error is a QMP error response.
+type Error struct {
+	Class string `json:"class"`
+	Desc  string `json:"desc"`
+}
+
+func (e *Error) Error() string {
+	return e.Class + ": " + e.Desc
+}
+
+// Call is a command received by the server.
+type Call struct {
+	Command   string
+	Arguments map[string]any
+
+	// OOB is true for commands sent with exec-oob.
+	OOB bool
+}
+
+// Server is a mock QMP server. It serves one client at a time and accepts
+// a new client when the previous one disconnects.
+type Server struct {
+	ln   net.Listener
+	path string
+
+	mu       sync.Mutex
+	version  [3]int
+	pkg      string
+	caps     []string
+	handlers map[string]Handler
+	calls    []Call
+	conn     net.Conn
+	enc      *json.Encoder
+}
+
+// NewServer starts a server on a unix socket at socketPath.
+func NewServer(socketPath string) (*Server, error) {
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on QMP socket: %w", err)
+	}
+	s := Serve(ln)
+	s.path = socketPath
+	return s, nil
+}
+
+// Serve starts a server on an existing listener, e.g. a TCP or TLS one.
+func Serve(ln net.Listener) *Server {
+	s := &Server{
+		ln:       ln,
+		version:  [3]int{8, 2, 0},
+		caps:     []string{"oob"},
+		handlers: make(map[string]Handler),
+	}
+
+	s.HandleReturn("qmp_capabilities", map[string]any{})
+	s.HandleReturn("query-status", map[string]any{"status": "running", "running": true})
+
+	go s.serve()
+	return s
+}
+
+// SocketPath returns the unix socket path given to NewServer.
+func (s *Server) SocketPath() string {
+	return s.path
+}
+
+// Addr returns the listener address.
+func (s *Server) Addr() net.Addr {
+	return s.ln.Addr()
+}
+
+// Close stops the server and disconnects the client.
+func (s *Server) Close() error {
+	err := s.ln.Close()
+	s.DropConnection()
+	return err
+}
+
+// SetVersion sets the QEMU version announced in the greeting.
+func (s *Server) SetVersion(major, minor, micro int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version = [3]int{major, minor, micro}
+}
+
+// SetPackage sets the package string announced in the greeting, empty by
+// default.
+func (s *Server) SetPackage(pkg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pkg = pkg
+}
+
+// SetCapabilities sets the capabilities announced in the greeting
+// (default "oob"). It applies to clients connecting afterwards.
+func (s *Server) SetCapabilities(caps ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.caps = caps
+}
+
+// Handle sets the handler for a command. Commands without a handler fail
+// with CommandNotFound. qmp_capabilities and query-status (running) are
+// handled by default.
+func (s *Server) Handle(command string, h Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[command] = h
+}
+
+// HandleReturn makes a command always return ret.
+func (s *Server) HandleReturn(command string, ret any) {
+	s.Handle(command, func(map[string]any) (any, error) {
+		return ret, nil
+	})
+}
+
+// HandleError makes a command always fail with the given QMP error.
+func (s *Server) HandleError(command, class, desc string) {
+	s.Handle(command, func(map[string]any) (any, error) {
+		return nil, &Error{Class: class, Desc: desc}
+	})
+}
+
+// Calls returns the commands received so far, in order.
+func (s *Server) Calls() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Call(nil), s.calls...)
+}
+
+// CallsTo returns the calls to command, in order.
+func (s *Server) CallsTo(command string) []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var calls []Call
+	for _, c := range s.calls {
+		if c.Command == command {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// SendEvent sends an event to the connected client.
+func (s *Server) SendEvent(name string, data map[string]any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.enc == nil {
+		return errors.New("no client connected")
+	}
+
+	now := time.Now()
+	return s.enc.Encode(map[string]any{
+		"event": name,
+		"data":  data,
+		"timestamp": map[string]any{
+			"seconds":      now.Unix(),
+			"microseconds": now.Nanosecond() / 1000,
+		},
+	})
+}
+
+// DropConnection disconnects the current client, simulating a lost
+// connection. The server keeps accepting new clients.
+func (s *Server) DropConnection() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		s.conn.Close()
+	}
+}
+
+// command is a QMP command as sent by clients.
+type command struct {
+	Execute   string         `json:"execute"`
+	ExecOOB   string         `json:"exec-oob"`
+	Arguments map[string]any `json:"arguments"`
+	ID        any            `json:"id"`
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.serveConn(conn)
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	enc := json.NewEncoder(conn)
+	s.mu.Lock()
+	s.conn = conn
+	s.enc = enc
+	err := enc.Encode(map[string]any{
+		"QMP": map[string]any{
+			"version": map[string]any{
+				"qemu":    map[string]any{"major": s.version[0], "minor": s.version[1], "micro": s.version[2]},
+				"package": s.pkg,
+			},
+			"capabilities": append([]string{}, s.caps...),
+		},
+	})
+	s.mu.Unlock()
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		s.mu.Lock()
+		if s.conn == conn {
+			s.conn = nil
+			s.enc = nil
+		}
+		s.mu.Unlock()
+	}()
+
+	dec := json.NewDecoder(conn)
+	for {
+		var cmd command
+		if err := dec.Decode(&cmd); err != nil {
+			return
+		}
+
+		name := cmd.Execute
+		if cmd.ExecOOB != "" {
+			name = cmd.ExecOOB
+		}
+
+		s.mu.Lock()
+		s.calls = append(s.calls, Call{Command: name, Arguments: cmd.Arguments, OOB: cmd.ExecOOB != ""})
+		h := s.handlers[name]
+		s.mu.Unlock()
+
+		resp := map[string]any{}
+		if cmd.ID != nil {
+			resp["id"] = cmd.ID
+		}
+		if h == nil {
+			resp["error"] = &Error{Class: "CommandNotFound", Desc: "The command " + name + " has not been found"}
+		} else if ret, err := h(cmd.Arguments); err != nil {
+			var qerr *Error
+			if !errors.As(err, &qerr) {
+				qerr = &Error{Class: "GenericError", Desc: err.Error()}
+			}
+			resp["error"] = qerr
+		} else {
+			if ret == nil {
+				ret = map[string]any{}
+			}
+			resp["return"] = ret
+		}
+
+		s.mu.Lock()
+		err := enc.Encode(resp)
+		s.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
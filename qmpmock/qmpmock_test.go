@@ -0,0 +1,90 @@
+package qmpmock_test
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/KarpelesLab/qemuctl"
+	"github.com/KarpelesLab/qemuctl/qmpmock"
+)
+
+func newServer(t *testing.T) *qmpmock.Server {
+	t.Helper()
+	srv, err := qmpmock.NewServer(filepath.Join(t.TempDir(), "qmp.sock"))
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+func TestServerResponses(t *testing.T) {
+	srv := newServer(t)
+	srv.HandleReturn("query-name", map[string]any{"name": "test"})
+	srv.HandleError("device_del", "DeviceNotFound", "Device 'net1' not found")
+
+	inst, err := qemuctl.Attach(srv.SocketPath())
+	if err != nil {
+		t.Fatalf("Attach failed: %v", err)
+	}
+	defer inst.QMP().Close()
+
+	ret, err := inst.QMP().Execute("query-name", nil)
+	if err != nil {
+		t.Fatalf("query-name failed: %v", err)
+	}
+	var name struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(ret, &name); err != nil || name.Name != "test" {
+		t.Errorf("query-name = %s, want name %q", ret, "test")
+	}
+
+	_, err = inst.QMP().Execute("device_del", map[string]any{"id": "net1"})
+	var qerr *qemuctl.QMPError
+	if !errors.As(err, &qerr) || qerr.Class != "DeviceNotFound" {
+		t.Errorf("device_del error = %v, want DeviceNotFound", err)
+	}
+
+	_, err = inst.QMP().Execute("query-missing", nil)
+	if !errors.As(err, &qerr) || qerr.Class != "CommandNotFound" {
+		t.Errorf("unknown command error = %v, want CommandNotFound", err)
+	}
+
+	calls := srv.CallsTo("device_del")
+	if len(calls) != 1 || calls[0].Arguments["id"] != "net1" {
+		t.Errorf("device_del calls = %+v", calls)
+	}
+}
+
+func TestServerEvents(t *testing.T) {
+	srv := newServer(t)
+
+	inst, err := qemuctl.Attach(srv.SocketPath())
+	if err != nil {
+		t.Fatalf("Attach failed: %v", err)
+	}
+	defer inst.QMP().Close()
+
+	sub, err := inst.Subscribe("SHUTDOWN")
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	if err := srv.SendEvent("SHUTDOWN", map[string]any{"guest": true}); err != nil {
+		t.Fatalf("SendEvent failed: %v", err)
+	}
+
+	select {
+	case event := <-sub.C:
+		if event.Data["guest"] != true {
+			t.Errorf("event data = %v", event.Data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+}
@@ -0,0 +1,168 @@
+package qemuctl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Readiness check timings.
+const (
+	defaultReadinessInterval = time.Second
+
+	// readinessAttemptTimeout bounds each guest agent and port attempt.
+	readinessAttemptTimeout = 5 * time.Second
+
+	// readinessPortGrace is how long a port connection must stay open to
+	// count as accepted.
+	readinessPortGrace = 200 * time.Millisecond
+)
+
+// ReadinessCheck is what WaitForGuest waits for. Every condition set must
+// hold; at least one must be set.
+type ReadinessCheck struct {
+	// Agent waits for the guest agent to answer guest-ping. The VM must
+	// have a guest agent channel (see VMConfig.WithGuestAgent).
+	Agent bool
+
+	// Port waits for the guest TCP port to accept connections through
+	// the host side of its user-mode forward, from UserNetBackend.Hostfwd
+	// or AddPortForward.
+	Port int
+
+	// Pattern waits for Console, such as a console log fed by
+	// CaptureSerial or SerialLogConfig.Writer, to match. Output buffered
+	// before the call counts.
+	Console *ConsoleLog
+	Pattern *regexp.Regexp
+
+	// Interval is the delay between agent and port attempts (1s if zero).
+	Interval time.Duration
+}
+
+// WaitForGuest blocks until the guest is ready according to check, ctx is
+// done, or QEMU exits, in which case the error wraps ErrNotRunning. It
+// saves scripts from sleeping arbitrary durations after Start.
+func (i *Instance) WaitForGuest(ctx context.Context, check ReadinessCheck) error {
+	if !check.Agent && check.Port == 0 && check.Pattern == nil {
+		return fmt.Errorf("readiness check has no condition")
+	}
+	if (check.Pattern == nil) != (check.Console == nil) {
+		return fmt.Errorf("readiness check needs both a console and a pattern")
+	}
+
+	var agent *GuestAgent
+	if check.Agent {
+		if agent = i.GuestAgent(); agent == nil {
+			return fmt.Errorf("no guest agent configured")
+		}
+	}
+	var addr string
+	if check.Port != 0 {
+		var err error
+		if addr, err = forwardedPort(i.PortForwards(), check.Port); err != nil {
+			return err
+		}
+	}
+	interval := check.Interval
+	if interval <= 0 {
+		interval = defaultReadinessInterval
+	}
+
+	agentReady, portReady, consoleReady := !check.Agent, addr == "", check.Pattern == nil
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for probe := true; ; {
+		if probe && !agentReady {
+			attemptCtx, cancel := context.WithTimeout(ctx, readinessAttemptTimeout)
+			agentReady = agent.Ping(attemptCtx) == nil
+			cancel()
+		}
+		if probe && !portReady {
+			portReady = probePort(ctx, addr)
+		}
+
+		var notify <-chan struct{}
+		if !consoleReady {
+			data, _, written, closed := check.Console.readFrom(0)
+			consoleReady = check.Pattern.Match(data)
+			if !consoleReady && closed {
+				return fmt.Errorf("console closed before %q appeared", check.Pattern)
+			}
+			notify = written
+		}
+
+		if agentReady && portReady && consoleReady {
+			return nil
+		}
+		if i.PID() > 0 && !i.isProcessAlive() {
+			return fmt.Errorf("%w: exited while waiting for the guest", ErrNotRunning)
+		}
+
+		var pending []string
+		if !agentReady {
+			pending = append(pending, "guest agent")
+		}
+		if !portReady {
+			pending = append(pending, "port "+strconv.Itoa(check.Port))
+		}
+		if !consoleReady {
+			pending = append(pending, "console")
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("guest not ready, waiting for %s: %w", strings.Join(pending, ", "), ctx.Err())
+		case <-ticker.C:
+			probe = true
+		case <-notify:
+			probe = false
+		}
+	}
+}
+
+// probePort reports whether a service accepts connections at addr. User
+// networking accepts forwarded connections on behalf of the guest and
+// closes them if the guest refuses, so the connection must also stay open
+// or see data for a moment.
+func probePort(ctx context.Context, addr string) bool {
+	dialer := net.Dialer{Timeout: readinessAttemptTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(readinessPortGrace))
+	_, err = conn.Read(make([]byte, 1))
+	var netErr net.Error
+	return err == nil || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// forwardedPort returns the host address that one of forwards, as
+// returned by Instance.PortForwards, leads to the guest TCP port.
+func forwardedPort(forwards []PortForward, guestPort int) (string, error) {
+	for _, fwd := range forwards {
+		host, err := parseHostfwd(fwd.Rule)
+		if err != nil || host.proto != "tcp" {
+			continue
+		}
+		_, guest, _ := strings.Cut(fwd.Rule, "-")
+		port, err := strconv.Atoi(guest[strings.LastIndexByte(guest, ':')+1:])
+		if err != nil || port != guestPort {
+			continue
+		}
+		addr := host.addr
+		if addr == "" {
+			addr = "127.0.0.1"
+		}
+		return net.JoinHostPort(addr, strconv.Itoa(host.port)), nil
+	}
+	return "", fmt.Errorf("guest port %d is not forwarded", guestPort)
+}
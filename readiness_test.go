@@ -0,0 +1,143 @@
+package qemuctl
+
+import (
+	"context"
+	"errors"
+	"net"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// listenForward starts a TCP service on loopback and returns a
+// configuration forwarding guest port 22 to it. banner, if not empty, is
+// sent on each connection; otherwise connections are closed at once, as
+// user networking does when the guest refuses them.
+func listenForward(t *testing.T, banner string) *VMConfig {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			if banner != "" {
+				conn.Write([]byte(banner))
+			}
+			conn.Close()
+		}
+	}()
+
+	port := ln.Addr().(*net.TCPAddr).Port
+	cfg := DefaultVMConfig()
+	cfg.Networks = []*NetworkConfig{{
+		ID:      "net0",
+		Backend: &UserNetBackend{Hostfwd: []string{"tcp:127.0.0.1:" + strconv.Itoa(port) + "-:22"}},
+	}}
+	return cfg
+}
+
+func TestForwardedPort(t *testing.T) {
+	forwards := []PortForward{
+		{Netdev: "net0", Rule: "udp::5353-:53"},
+		{Netdev: "net0", Rule: "tcp::2222-:22"},
+		{Netdev: "net0", Rule: "tcp:10.0.0.1:8080-10.0.2.15:80"},
+	}
+
+	for port, want := range map[int]string{22: "127.0.0.1:2222", 80: "10.0.0.1:8080"} {
+		if got, err := forwardedPort(forwards, port); err != nil || got != want {
+			t.Errorf("forwardedPort(%d) = %q, %v, want %q", port, got, err, want)
+		}
+	}
+	if _, err := forwardedPort(forwards, 53); err == nil {
+		t.Error("UDP forward used for a TCP port")
+	}
+}
+
+func TestWaitForGuestPort(t *testing.T) {
+	inst := &Instance{vmConfig: listenForward(t, "SSH-2.0-OpenSSH\r\n")}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := inst.WaitForGuest(ctx, ReadinessCheck{Port: 22}); err != nil {
+		t.Errorf("WaitForGuest = %v", err)
+	}
+
+	// Connections closed at once are refused by the guest
+	inst = &Instance{vmConfig: listenForward(t, "")}
+	ctx, cancel = context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	err := inst.WaitForGuest(ctx, ReadinessCheck{Port: 22, Interval: 50 * time.Millisecond})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("WaitForGuest = %v, want a deadline error", err)
+	}
+}
+
+func TestWaitForGuestRuntimeForward(t *testing.T) {
+	fake := newFakeQMP(t)
+	handleHostfwd(fake)
+	inst := fake.attach()
+	inst.vmConfig = DefaultVMConfig()
+
+	// A forward added while the VM runs is used too
+	rule := listenForward(t, "SSH-2.0-OpenSSH\r\n").Networks[0].Backend.(*UserNetBackend).Hostfwd[0]
+	if err := inst.AddPortForward("net0", rule); err != nil {
+		t.Fatalf("AddPortForward failed: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := inst.WaitForGuest(ctx, ReadinessCheck{Port: 22}); err != nil {
+		t.Errorf("WaitForGuest = %v", err)
+	}
+}
+
+func TestWaitForGuestConsole(t *testing.T) {
+	console := NewConsoleLog(0)
+	console.Write([]byte("Booting...\n"))
+	time.AfterFunc(50*time.Millisecond, func() { console.Write([]byte("debian login: ")) })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	inst := &Instance{}
+	err := inst.WaitForGuest(ctx, ReadinessCheck{Console: console, Pattern: regexp.MustCompile(`login: $`), Interval: time.Hour})
+	if err != nil {
+		t.Errorf("WaitForGuest = %v", err)
+	}
+
+	console.Close()
+	err = inst.WaitForGuest(ctx, ReadinessCheck{Console: console, Pattern: regexp.MustCompile(`never`)})
+	if err == nil {
+		t.Error("WaitForGuest succeeded on a closed console")
+	}
+}
+
+func TestWaitForGuestAgent(t *testing.T) {
+	inst := &Instance{vmConfig: DefaultVMConfig().WithGuestAgent(newFakeGuestAgent(t, nil))}
+	defer inst.GuestAgent().Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := inst.WaitForGuest(ctx, ReadinessCheck{Agent: true}); err != nil {
+		t.Errorf("WaitForGuest = %v", err)
+	}
+}
+
+func TestWaitForGuestInvalid(t *testing.T) {
+	inst := &Instance{vmConfig: DefaultVMConfig()}
+	for _, check := range []ReadinessCheck{
+		{},
+		{Pattern: regexp.MustCompile("login:")},
+		{Agent: true},
+		{Port: 22},
+	} {
+		if err := inst.WaitForGuest(context.Background(), check); err == nil {
+			t.Errorf("WaitForGuest(%+v) succeeded", check)
+		}
+	}
+}
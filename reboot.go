@@ -0,0 +1,72 @@
+package qemuctl
+
+import (
+	"context"
+	"time"
+)
+
+// rebootGracePeriod is how long RebootSmart waits for the guest to reboot
+// itself before resetting it.
+const rebootGracePeriod = 60 * time.Second
+
+// rebootAgentTimeout bounds the guest-shutdown request, so a missing or
+// hung agent does not eat into the grace period.
+const rebootAgentTimeout = 10 * time.Second
+
+// RebootMethod is how RebootSmart rebooted the guest.
+type RebootMethod string
+
+const (
+	// RebootGuestAgent means the guest rebooted cleanly on request of the
+	// guest agent.
+	RebootGuestAgent RebootMethod = "guest-agent"
+
+	// RebootReset means the VM was hard reset with system_reset.
+	RebootReset RebootMethod = "system_reset"
+)
+
+// RebootSmart reboots the guest, cleanly if possible. It asks the guest
+// agent for a reboot (guest-shutdown with mode=reboot) and waits up to a
+// grace period of 60 seconds for the guest to reset itself. If there is no
+// guest agent, the request fails or the guest does not reboot in time, the
+// VM is hard reset with system_reset. It reports which method was used.
+func (i *Instance) RebootSmart(ctx context.Context) (RebootMethod, error) {
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return "", ErrNotConnected
+	}
+
+	if agent := i.GuestAgent(); agent != nil {
+		// Subscribe before asking so the RESET event cannot be missed
+		sub := qmp.Subscribe("RESET")
+		defer sub.Unsubscribe()
+
+		agentCtx, cancel := context.WithTimeout(ctx, rebootAgentTimeout)
+		err := agent.notify(agentCtx, "guest-shutdown", map[string]any{"mode": "reboot"})
+		cancel()
+
+		if err == nil {
+			timer := time.NewTimer(rebootGracePeriod)
+			defer timer.Stop()
+
+			select {
+			case _, ok := <-sub.C:
+				if ok {
+					return RebootGuestAgent, nil
+				}
+				return "", qmp.closedErr()
+			case <-timer.C:
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+		}
+	}
+
+	if err := i.Reset(); err != nil {
+		return "", err
+	}
+	return RebootReset, nil
+}
@@ -0,0 +1,56 @@
+package qemuctl
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRebootSmartGuestAgent(t *testing.T) {
+	fake := newFakeQMP(t)
+	inst := fake.attach()
+
+	modes := make(chan string, 1)
+	path := newFakeGuestAgent(t, nil, func(mode string) {
+		modes <- mode
+		fake.sendEvent("RESET", map[string]any{"guest": true, "reason": "guest-reset"})
+	})
+	inst.vmConfig = DefaultVMConfig().WithGuestAgent(path)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	method, err := inst.RebootSmart(ctx)
+	if err != nil {
+		t.Fatalf("RebootSmart failed: %v", err)
+	}
+	if method != RebootGuestAgent {
+		t.Errorf("method = %q, want %q", method, RebootGuestAgent)
+	}
+	if mode := <-modes; mode != "reboot" {
+		t.Errorf("guest-shutdown mode = %q, want reboot", mode)
+	}
+	if n := len(fake.commands("system_reset")); n != 0 {
+		t.Errorf("system_reset called %d times, want 0", n)
+	}
+}
+
+func TestRebootSmartFallback(t *testing.T) {
+	fake := newFakeQMP(t)
+	fake.handle("system_reset", func(map[string]any) (any, *qmpError) {
+		return map[string]any{}, nil
+	})
+	inst := fake.attach()
+
+	// No guest agent configured
+	method, err := inst.RebootSmart(context.Background())
+	if err != nil {
+		t.Fatalf("RebootSmart failed: %v", err)
+	}
+	if method != RebootReset {
+		t.Errorf("method = %q, want %q", method, RebootReset)
+	}
+	if n := len(fake.commands("system_reset")); n != 1 {
+		t.Errorf("system_reset called %d times, want 1", n)
+	}
+}
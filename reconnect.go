@@ -0,0 +1,188 @@
+package qemuctl
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"time"
+)
+
+// ErrConnectionLost is returned for commands that were in flight, or sent,
+// while the QMP connection was down.
+var ErrConnectionLost = errors.New("QMP connection lost")
+
+// errQMPClosed is returned for commands sent after Close.
+var errQMPClosed = errors.New("QMP connection closed")
+
+// reconnectDialTimeout bounds each reconnection attempt, including the
+// QMP handshake.
+const reconnectDialTimeout = 10 * time.Second
+
+// ReconnectConfig configures automatic reconnection of a QMP connection.
+type ReconnectConfig struct {
+	// InitialDelay is the delay before the first attempt (default 100ms).
+	InitialDelay time.Duration
+
+	// MaxDelay caps the exponential backoff between attempts (default 5s).
+	MaxDelay time.Duration
+
+	// MaxAttempts limits the attempts per outage. Zero means no limit.
+	MaxAttempts int
+}
+
+// SetReconnect enables automatic reconnection when the connection is lost,
+// or disables it if cfg is nil (the default). While disconnected, commands
+// fail with ErrConnectionLost. Once reconnected, the VM state is queried
+// again so state callbacks see any change that happened meanwhile.
+func (q *QMP) SetReconnect(cfg *ReconnectConfig) {
+	q.reconnectMu.Lock()
+	defer q.reconnectMu.Unlock()
+	q.reconnect = cfg
+}
+
+// closedErr returns the error for commands that cannot be sent.
+func (q *QMP) closedErr() error {
+	select {
+	case <-q.stopCh:
+		return errQMPClosed
+	default:
+		return ErrConnectionLost
+	}
+}
+
+// dropConn closes the current connection after it failed.
+func (q *QMP) dropConn() {
+	q.connMu.Lock()
+	defer q.connMu.Unlock()
+	if q.conn != nil {
+		q.conn.Close()
+		q.conn = nil
+	}
+}
+
+// failPending fails all commands waiting for a response.
+func (q *QMP) failPending(err error) {
+	q.pendingMu.Lock()
+	defer q.pendingMu.Unlock()
+	for _, ch := range q.pending {
+		select {
+		case ch <- &qmpResponse{err: err}:
+		default:
+		}
+	}
+}
+
+// redial reconnects with exponential backoff. It returns the decoder of the
+// new connection, or nil if reconnection is disabled, gave up or the
+// connection was closed.
+func (q *QMP) redial() *json.Decoder {
+	q.reconnectMu.Lock()
+	cfg := q.reconnect
+	q.reconnectMu.Unlock()
+
+	if cfg == nil || q.dial == nil {
+		return nil
+	}
+
+	delay := cfg.InitialDelay
+	if delay <= 0 {
+		delay = 100 * time.Millisecond
+	}
+	maxDelay := cfg.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = 5 * time.Second
+	}
+
+	for attempt := 1; cfg.MaxAttempts <= 0 || attempt <= cfg.MaxAttempts; attempt++ {
+		timer := time.NewTimer(delay)
+		select {
+		case <-q.stopCh:
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+
+		if conn, dec := q.reconnectOnce(); conn != nil {
+			q.connMu.Lock()
+			select {
+			case <-q.stopCh:
+				// Closed while reconnecting
+				q.connMu.Unlock()
+				conn.Close()
+				return nil
+			default:
+			}
+			q.conn = conn
+			q.connMu.Unlock()
+
+			go q.resync()
+			return dec
+		}
+
+		delay = min(delay*2, maxDelay)
+	}
+	return nil
+}
+
+// reconnectOnce dials the monitor and enters command mode.
+func (q *QMP) reconnectOnce() (net.Conn, *json.Decoder) {
+	conn, err := q.dial()
+	if err != nil {
+		return nil, nil
+	}
+	conn.SetDeadline(time.Now().Add(reconnectDialTimeout))
+
+	dec := newQMPDecoder(conn)
+	greeting, err := q.readGreeting(dec)
+	if err != nil {
+		conn.Close()
+		return nil, nil
+	}
+
+	// The event loop is not running yet, so negotiate synchronously
+	data, _ := json.Marshal(qmpCommand{
+		Execute:   "qmp_capabilities",
+		Arguments: greeting.capabilitiesArgs(),
+		ID:        "reconnect",
+	})
+	if _, err := conn.Write(append(data, '\n')); err != nil {
+		conn.Close()
+		return nil, nil
+	}
+	q.logSent(data)
+	for {
+		var resp qmpResponse
+		if err := q.decode(dec, &resp); err != nil {
+			conn.Close()
+			return nil, nil
+		}
+		if resp.ID != "reconnect" {
+			continue
+		}
+		if resp.Error != nil {
+			conn.Close()
+			return nil, nil
+		}
+		break
+	}
+
+	conn.SetDeadline(time.Time{})
+	q.oob.Store(greeting.hasCapability("oob"))
+	q.setVersion(greeting.version())
+	return conn, dec
+}
+
+// resync reports the current VM state after a reconnection.
+func (q *QMP) resync() {
+	result, err := q.Execute("query-status", nil)
+	if err != nil || q.onStateChange == nil {
+		return
+	}
+
+	var status struct {
+		Status string `json:"status"`
+	}
+	if unmarshalJSON(result, &status) == nil {
+		q.onStateChange(parseQMPStatus(status.Status))
+	}
+}
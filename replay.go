@@ -0,0 +1,99 @@
+package qemuctl
+
+import "fmt"
+
+// Record/replay modes.
+const (
+	// ReplayRecord records the non-deterministic inputs of an execution:
+	// device input, network packets, clock reads and interrupt timing.
+	ReplayRecord = "record"
+
+	// ReplayReplay runs the guest again from a recording, executing the
+	// same instructions with the same inputs.
+	ReplayReplay = "replay"
+)
+
+// ReplayConfig runs the guest in deterministic record/replay mode, so a
+// heisenbug caught once in a recording can be replayed as often as needed,
+// e.g. with a debugger attached. It needs the tcg accelerator and a CPU
+// model other than "host", and makes the guest much slower. Disks go
+// through a blkreplay filter and networks through a filter-replay object,
+// both added by the builder; disks must be in the same state when
+// replaying as when recording, which Snapshot ensures.
+type ReplayConfig struct {
+	// Mode is ReplayRecord or ReplayReplay.
+	Mode string `json:"mode"`
+
+	// File is the recording.
+	File string `json:"file"`
+
+	// Snapshot is the name of a VM snapshot saved when recording starts
+	// and loaded when replaying starts. It needs a qcow2 disk.
+	Snapshot string `json:"snapshot,omitempty"`
+
+	// Shift is the icount shift, the number of nanoseconds of virtual
+	// time per instruction as a power of two. It defaults to "auto",
+	// which follows the host clock.
+	Shift string `json:"shift,omitempty"`
+}
+
+// checkReplay checks that record/replay can run the configuration.
+func (cfg *VMConfig) checkReplay() error {
+	rr := cfg.Replay
+	switch {
+	case rr.Mode != ReplayRecord && rr.Mode != ReplayReplay:
+		return fmt.Errorf("unknown record/replay mode %q", rr.Mode)
+	case rr.File == "":
+		return fmt.Errorf("record/replay needs a file")
+	case cfg.requestedAccel() != AccelTCG:
+		return fmt.Errorf("record/replay needs the tcg accelerator; set Machine.Accel to %q", AccelTCG)
+	case cfg.CPU == nil || cfg.CPU.Model == "" || cfg.CPU.Model == "host":
+		return fmt.Errorf("record/replay runs under tcg, which needs a CPU model other than host")
+	}
+
+	if rr.Snapshot != "" {
+		for _, disk := range cfg.Disks {
+			if f, ok := disk.Backend.(*FileDiskBackend); ok && f.Format == "qcow2" {
+				return nil
+			}
+		}
+		return fmt.Errorf("record/replay snapshot %s needs a qcow2 disk", rr.Snapshot)
+	}
+	return nil
+}
+
+// buildReplay builds the -icount option of record/replay.
+func (b *VMBuilder) buildReplay() {
+	rr := b.config.Replay
+	if rr == nil {
+		return
+	}
+
+	shift := rr.Shift
+	if shift == "" {
+		shift = "auto"
+	}
+	opts := "shift=" + shift + ",rr=" + rr.Mode + ",rrfile=" + rr.File
+	if rr.Snapshot != "" {
+		opts += ",rrsnapshot=" + rr.Snapshot
+	}
+	b.args = append(b.args, "-icount", opts)
+}
+
+// replayFromOpts rebuilds a ReplayConfig from -icount options, or returns
+// nil if they do not enable record/replay.
+func replayFromOpts(o *qemuOpts) *ReplayConfig {
+	if o.get("rr") == "" {
+		return nil
+	}
+	rr := &ReplayConfig{
+		Mode:     o.get("rr"),
+		File:     o.get("rrfile"),
+		Snapshot: o.get("rrsnapshot"),
+		Shift:    o.get("shift"),
+	}
+	if rr.Shift == "auto" {
+		rr.Shift = ""
+	}
+	return rr
+}
@@ -0,0 +1,74 @@
+package qemuctl
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func replayConfig() *VMConfig {
+	return &VMConfig{
+		Machine:  &MachineConfig{Type: "q35", Accel: AccelTCG},
+		CPU:      &CPUConfig{Model: "max", Sockets: 1, Cores: 1, Threads: 1},
+		Disks:    []*DiskConfig{{ID: "disk0", Backend: &FileDiskBackend{Path: "/images/web.qcow2", Format: "qcow2"}}},
+		Networks: []*NetworkConfig{{ID: "net0", Backend: &UserNetBackend{}}},
+		Replay:   &ReplayConfig{Mode: ReplayRecord, File: "/var/lib/qemu/web.rr", Snapshot: "rr-start"},
+	}
+}
+
+func TestVMBuilderReplay(t *testing.T) {
+	cfg := replayConfig()
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	args := NewVMBuilder(cfg).Build("test", "")
+	joined := strings.Join(args, " ")
+	for _, want := range []string{
+		"-icount shift=auto,rr=record,rrfile=/var/lib/qemu/web.rr,rrsnapshot=rr-start",
+		"-blockdev driver=blkreplay,node-name=disk0-replay,image=disk0-format",
+		"drive=disk0-replay,id=disk0-device",
+		"-object filter-replay,id=net0-replay,netdev=net0",
+	} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("args missing %q:\n%s", want, joined)
+		}
+	}
+
+	parsed, err := ParseArgs(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed.Replay, cfg.Replay) {
+		t.Errorf("parsed replay config = %+v, want %+v", parsed.Replay, cfg.Replay)
+	}
+	if len(parsed.Disks) != 1 || parsed.Disks[0].ID != "disk0" {
+		t.Errorf("parsed disks = %+v", parsed.Disks)
+	}
+	if strings.Contains(strings.Join(parsed.ExtraArgs, " "), "replay") {
+		t.Errorf("replay filters kept in extra args: %v", parsed.ExtraArgs)
+	}
+
+	// Without record/replay, disks are not filtered
+	cfg.Replay = nil
+	if joined := strings.Join(NewVMBuilder(cfg).Build("test", ""), " "); strings.Contains(joined, "replay") {
+		t.Errorf("replay options without record/replay:\n%s", joined)
+	}
+}
+
+func TestValidateReplay(t *testing.T) {
+	for name, change := range map[string]func(*VMConfig){
+		"mode":     func(cfg *VMConfig) { cfg.Replay.Mode = "rewind" },
+		"file":     func(cfg *VMConfig) { cfg.Replay.File = "" },
+		"kvm":      func(cfg *VMConfig) { cfg.Machine.Accel = AccelKVM },
+		"default":  func(cfg *VMConfig) { cfg.Machine = nil },
+		"host cpu": func(cfg *VMConfig) { cfg.CPU.Model = "host" },
+		"snapshot": func(cfg *VMConfig) { cfg.Disks[0].Backend = &FileDiskBackend{Path: "/images/web.raw", Format: "raw"} },
+	} {
+		cfg := replayConfig()
+		change(cfg)
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s: Validate accepted %+v", name, cfg.Replay)
+		}
+	}
+}
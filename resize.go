@@ -0,0 +1,63 @@
+package qemuctl
+
+import (
+	"context"
+	"fmt"
+)
+
+// ResizeDisk sets the virtual size of disk diskID of the running VM to
+// size bytes. The guest sees the new capacity straight away, but must
+// still grow its partitions and file systems. Disks of stopped VMs are
+// resized with ResizeDiskImage.
+func (i *Instance) ResizeDisk(diskID string, size int64) error {
+	disk := i.disk(diskID)
+	if disk == nil {
+		return fmt.Errorf("disk %s not configured", diskID)
+	}
+
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return ErrNotConnected
+	}
+
+	_, err := qmp.Execute("block_resize", map[string]any{
+		"node-name": disk.nodeName(),
+		"size":      size,
+	})
+	return err
+}
+
+// disk returns the disk with ID id in the VM configuration, or nil.
+func (i *Instance) disk(id string) *DiskConfig {
+	if i.vmConfig == nil {
+		return nil
+	}
+	for _, disk := range i.vmConfig.Disks {
+		if disk.ID == id || (disk.ID == "" && id == "drive0") {
+			return disk
+		}
+	}
+	return nil
+}
+
+// ResizeDiskImage sets the virtual size of the image of disk to size bytes
+// with qemu-img, for VMs that are not running. Only file backends can be
+// resized this way; their Format is used unless opts sets one.
+func ResizeDiskImage(ctx context.Context, disk *DiskConfig, size int64, opts *ResizeImageOptions) error {
+	file, ok := disk.Backend.(*FileDiskBackend)
+	if !ok {
+		return fmt.Errorf("disk %s: only file backends can be resized offline", disk.ID)
+	}
+
+	o := ResizeImageOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.Format == "" {
+		o.Format = file.Format
+	}
+	return ResizeImage(ctx, file.Path, size, &o)
+}
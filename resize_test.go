@@ -0,0 +1,54 @@
+package qemuctl
+
+import (
+	"context"
+	"testing"
+)
+
+func TestResizeDisk(t *testing.T) {
+	fake := newFakeQMP(t)
+	fake.handle("block_resize", func(map[string]any) (any, *qmpError) {
+		return map[string]any{}, nil
+	})
+	inst := fake.attach()
+	inst.vmConfig = &VMConfig{Disks: []*DiskConfig{
+		{ID: "root", Backend: &FileDiskBackend{Path: "root.qcow2", Format: "qcow2"},
+			Throttle: &ThrottleConfig{Group: "limits"}},
+		{ID: "san", Backend: &ISCSIDiskBackend{Portal: "10.0.0.1", Target: "iqn.2024-01.example:san"}},
+	}}
+
+	if err := inst.ResizeDisk("root", 40<<30); err != nil {
+		t.Fatal(err)
+	}
+	if err := inst.ResizeDisk("san", 1<<40); err != nil {
+		t.Fatal(err)
+	}
+	if err := inst.ResizeDisk("data", 1<<30); err == nil {
+		t.Error("ResizeDisk() accepted an unknown disk")
+	}
+
+	calls := fake.commands("block_resize")
+	if len(calls) != 2 {
+		t.Fatalf("block_resize sent %d times, want 2", len(calls))
+	}
+	// The format node, not the throttle filter above it
+	if calls[0]["node-name"] != "root-format" || calls[0]["size"] != float64(40<<30) {
+		t.Errorf("block_resize arguments = %v", calls[0])
+	}
+	if calls[1]["node-name"] != "san-iscsi" {
+		t.Errorf("block_resize arguments = %v", calls[1])
+	}
+}
+
+func TestResizeDiskImage(t *testing.T) {
+	path := fakeQemuImg(t, "Image resized.", 0)
+	disk := &DiskConfig{ID: "root", Backend: &FileDiskBackend{Path: "root.qcow2", Format: "qcow2"}}
+	if err := ResizeDiskImage(context.Background(), disk, 40<<30, &ResizeImageOptions{QemuImgPath: path}); err != nil {
+		t.Fatal(err)
+	}
+
+	disk = &DiskConfig{ID: "nbd", Backend: &NBDDiskBackend{Host: "localhost", Export: "root"}}
+	if err := ResizeDiskImage(context.Background(), disk, 40<<30, &ResizeImageOptions{QemuImgPath: path}); err == nil {
+		t.Error("ResizeDiskImage() accepted an NBD disk")
+	}
+}
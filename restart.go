@@ -0,0 +1,260 @@
+package qemuctl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Restart modes, for RestartPolicy.Mode.
+const (
+	// RestartAlways restarts VMs whenever they exit, unless stopped.
+	RestartAlways = "always"
+
+	// RestartOnFailure restarts VMs when QEMU exits with an error or the
+	// guest panicked.
+	RestartOnFailure = "on-failure"
+)
+
+// Restart policy defaults.
+const (
+	defaultRestartBackoff    = time.Second
+	defaultRestartMaxBackoff = 5 * time.Minute
+	defaultRestartResetAfter = 10 * time.Minute
+)
+
+// ErrRestartCanceled is reported by the LifecycleGaveUp event of a restart
+// canceled with CancelRestart or by its start context.
+var ErrRestartCanceled = errors.New("restart canceled")
+
+// LifecycleEventType is the kind of a LifecycleEvent.
+type LifecycleEventType string
+
+const (
+	// LifecycleExited is sent when the QEMU process of a VM exits.
+	LifecycleExited LifecycleEventType = "exited"
+
+	// LifecycleRestarting is sent before waiting to restart a VM.
+	LifecycleRestarting LifecycleEventType = "restarting"
+
+	// LifecycleRestarted is sent once a VM is running again.
+	LifecycleRestarted LifecycleEventType = "restarted"
+
+	// LifecycleRestartFailed is sent when QEMU could not be started again.
+	LifecycleRestartFailed LifecycleEventType = "restart-failed"
+
+	// LifecycleGaveUp is sent when a VM is not restarted anymore, after
+	// MaxRetries attempts or when the restart is canceled.
+	LifecycleGaveUp LifecycleEventType = "gave-up"
+)
+
+// LifecycleEvent reports the exit and restart of a supervised VM.
+type LifecycleEvent struct {
+	Type LifecycleEventType
+	VM   string
+
+	// Instance is the instance that exited, or the new instance for
+	// LifecycleRestarted. It is nil for the other events.
+	Instance *Instance
+
+	// Failed reports whether the VM exited with an error or after a guest
+	// panic, for LifecycleExited.
+	Failed bool
+
+	// Attempt is the number of restart attempts since the VM last ran for
+	// ResetAfter.
+	Attempt int
+
+	// Delay is the backoff before the restart, for LifecycleRestarting.
+	Delay time.Duration
+
+	// Err is the exit, start or cancellation error, if any.
+	Err error
+}
+
+// RestartPolicy is the policy a Supervisor applies when a VM it manages
+// exits without being stopped through its Instance (Stop, Shutdown, Quit
+// or ForceStop), including when the guest panics. A restarted VM is a new
+// Instance with the same name and configuration.
+type RestartPolicy struct {
+	// Mode is RestartAlways or RestartOnFailure.
+	Mode string
+
+	// MaxRetries is the number of consecutive restarts after which the VM
+	// is left stopped. Zero retries forever.
+	MaxRetries int
+
+	// Backoff is the delay before the first restart, doubled on each
+	// consecutive attempt up to MaxBackoff (1s and the larger of 5m and
+	// Backoff if zero).
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// ResetAfter is how long a VM must run for the attempts to be reset
+	// (10m if zero).
+	ResetAfter time.Duration
+
+	// OnEvent is called on each exit and restart of a VM.
+	OnEvent func(event *LifecycleEvent)
+}
+
+// validate checks the mode and durations.
+func (p *RestartPolicy) validate() error {
+	switch {
+	case p.Mode != RestartAlways && p.Mode != RestartOnFailure:
+		return fmt.Errorf("restart: invalid mode %q", p.Mode)
+	case p.MaxRetries < 0 || p.Backoff < 0 || p.MaxBackoff < 0 || p.ResetAfter < 0:
+		return fmt.Errorf("restart: negative limit")
+	}
+	return nil
+}
+
+// delay returns the backoff before the given restart attempt, from 1.
+func (p *RestartPolicy) delay(attempt int) time.Duration {
+	d := p.Backoff
+	if d == 0 {
+		d = defaultRestartBackoff
+	}
+	limit := p.MaxBackoff
+	if limit == 0 {
+		limit = max(defaultRestartMaxBackoff, d)
+	}
+	for n := 1; n < attempt && d < limit; n++ {
+		d *= 2
+	}
+	return min(d, limit)
+}
+
+// resetAfter returns the uptime after which attempts are reset.
+func (p *RestartPolicy) resetAfter() time.Duration {
+	if p.ResetAfter == 0 {
+		return defaultRestartResetAfter
+	}
+	return p.ResetAfter
+}
+
+// emit calls OnEvent, if any.
+func (p *RestartPolicy) emit(event *LifecycleEvent) {
+	if p != nil && p.OnEvent != nil {
+		p.OnEvent(event)
+	}
+}
+
+// SetRestartPolicy sets the policy applied to VMs started afterwards, or
+// disables restarts if policy is nil.
+func (s *Supervisor) SetRestartPolicy(policy *RestartPolicy) error {
+	if policy != nil {
+		if err := policy.validate(); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.restart = policy
+	return nil
+}
+
+// CancelRestart cancels the pending restart of the VM name, which is left
+// stopped. It reports whether a restart was waiting for its backoff.
+func (s *Supervisor) CancelRestart(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cancel, ok := s.pending[name]
+	if ok {
+		close(cancel)
+		delete(s.pending, name)
+	}
+	return ok
+}
+
+// supervise waits for inst to exit and restarts it according to its
+// restart policy, until it is stopped or the policy gives up.
+func (s *Supervisor) supervise(ctx context.Context, cfg *VMConfig, inst *Instance, res *Reservation) {
+	policy := res.restart
+	attempt := 0
+	for {
+		started := time.Now()
+		failed, err := s.exited(inst, res)
+		name := inst.Name()
+		policy.emit(&LifecycleEvent{Type: LifecycleExited, VM: name, Instance: inst, Failed: failed, Attempt: attempt, Err: err})
+
+		if policy == nil || inst.stopRequested.Load() || ctx.Err() != nil || (!failed && policy.Mode == RestartOnFailure) {
+			return
+		}
+		if time.Since(started) >= policy.resetAfter() {
+			attempt = 0
+		}
+
+		// The VM keeps its name, which is generated when cfg has none
+		c := *cfg
+		c.Name = name
+		for {
+			if policy.MaxRetries > 0 && attempt >= policy.MaxRetries {
+				policy.emit(&LifecycleEvent{Type: LifecycleGaveUp, VM: name, Attempt: attempt})
+				return
+			}
+			attempt++
+
+			delay := policy.delay(attempt)
+			policy.emit(&LifecycleEvent{Type: LifecycleRestarting, VM: name, Attempt: attempt, Delay: delay})
+			if !s.backoff(ctx, name, delay) {
+				policy.emit(&LifecycleEvent{Type: LifecycleGaveUp, VM: name, Attempt: attempt, Err: ErrRestartCanceled})
+				return
+			}
+
+			inst, res, err = s.launch(ctx, &c, policy)
+			if err == nil {
+				policy.emit(&LifecycleEvent{Type: LifecycleRestarted, VM: name, Instance: inst, Attempt: attempt})
+				break
+			}
+			policy.emit(&LifecycleEvent{Type: LifecycleRestartFailed, VM: name, Attempt: attempt, Err: err})
+		}
+	}
+}
+
+// backoff waits before restarting the VM name, and reports false if the
+// restart was canceled.
+func (s *Supervisor) backoff(ctx context.Context, name string, delay time.Duration) bool {
+	cancel := make(chan struct{})
+	s.mu.Lock()
+	s.pending[name] = cancel
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		if s.pending[name] == cancel {
+			delete(s.pending, name)
+		}
+		s.mu.Unlock()
+	}()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-cancel:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// panicked handles a guest panic. Crash capture runs first; then, unless
+// it resumed the guest, a guest left paused is killed for its restart
+// policy to restart it.
+func (s *Supervisor) panicked(inst *Instance, res *Reservation, event *Event) {
+	handled, restarted := s.handleCrash(inst, event)
+	if restarted || res.restart == nil {
+		return
+	}
+
+	p, _ := event.Payload().(*GuestPanickedEvent)
+	if handled || (p != nil && p.Action == "pause") {
+		inst.forceStop()
+	}
+}
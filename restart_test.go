@@ -0,0 +1,212 @@
+package qemuctl
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// fakeStarts makes s run script in a shell instead of QEMU, and returns
+// the number of starts.
+func fakeStarts(t *testing.T, s *Supervisor, script string) *int {
+	t.Helper()
+	starts := new(int)
+	s.startVM = func(ctx context.Context, cfg *VMConfig) (*Instance, error) {
+		cmd := exec.Command("sh", "-c", script)
+		if err := cmd.Start(); err != nil {
+			return nil, err
+		}
+		*starts++
+		return &Instance{name: cfg.Name, process: cmd.Process}, nil
+	}
+	return starts
+}
+
+// nextLifecycleEvent waits for a lifecycle event.
+func nextLifecycleEvent(t *testing.T, events chan *LifecycleEvent) *LifecycleEvent {
+	t.Helper()
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("no lifecycle event")
+		return nil
+	}
+}
+
+func TestRestartPolicyDelay(t *testing.T) {
+	p := &RestartPolicy{Mode: RestartAlways, Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	for _, tc := range []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+		{5, 5 * time.Second},
+	} {
+		if got := p.delay(tc.attempt); got != tc.want {
+			t.Errorf("delay(%d) = %v, want %v", tc.attempt, got, tc.want)
+		}
+	}
+
+	if got := (&RestartPolicy{}).delay(20); got != defaultRestartMaxBackoff {
+		t.Errorf("default delay(20) = %v", got)
+	}
+}
+
+func TestSetRestartPolicyInvalid(t *testing.T) {
+	s := NewSupervisor(Resources{}, OvercommitRatios{})
+	for _, p := range []*RestartPolicy{
+		{},
+		{Mode: "sometimes"},
+		{Mode: RestartAlways, MaxRetries: -1},
+		{Mode: RestartAlways, Backoff: -time.Second},
+	} {
+		if err := s.SetRestartPolicy(p); err == nil {
+			t.Errorf("SetRestartPolicy(%+v) succeeded", p)
+		}
+	}
+}
+
+func TestRestartOnFailure(t *testing.T) {
+	s := NewSupervisor(Resources{}, OvercommitRatios{})
+	starts := fakeStarts(t, s, "exit 1")
+	events := make(chan *LifecycleEvent, 16)
+	s.SetRestartPolicy(&RestartPolicy{
+		Mode:       RestartOnFailure,
+		MaxRetries: 2,
+		Backoff:    time.Millisecond,
+		OnEvent:    func(e *LifecycleEvent) { events <- e },
+	})
+
+	if _, err := s.Start(context.Background(), &VMConfig{Name: "vm"}); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	want := []LifecycleEventType{
+		LifecycleExited, LifecycleRestarting, LifecycleRestarted,
+		LifecycleExited, LifecycleRestarting, LifecycleRestarted,
+		LifecycleExited, LifecycleGaveUp,
+	}
+	for n, typ := range want {
+		e := nextLifecycleEvent(t, events)
+		if e.Type != typ || e.VM != "vm" {
+			t.Fatalf("event %d = %+v, want %s", n, e, typ)
+		}
+		if typ == LifecycleExited && !e.Failed {
+			t.Errorf("event %d did not fail", n)
+		}
+	}
+	if *starts != 3 {
+		t.Errorf("started %d times, want 3", *starts)
+	}
+	if c := s.Committed(); c != (Resources{}) {
+		t.Errorf("Committed = %+v after giving up", c)
+	}
+}
+
+func TestRestartNotNeeded(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		mode   string
+		script string
+		stop   bool
+	}{
+		{"clean exit", RestartOnFailure, "exit 0", false},
+		{"stopped", RestartAlways, "sleep 10", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewSupervisor(Resources{}, OvercommitRatios{})
+			starts := fakeStarts(t, s, tc.script)
+			events := make(chan *LifecycleEvent, 16)
+			s.SetRestartPolicy(&RestartPolicy{
+				Mode:    tc.mode,
+				Backoff: time.Millisecond,
+				OnEvent: func(e *LifecycleEvent) { events <- e },
+			})
+
+			inst, err := s.Start(context.Background(), &VMConfig{Name: "vm"})
+			if err != nil {
+				t.Fatalf("Start failed: %v", err)
+			}
+			if tc.stop {
+				inst.ForceStop()
+			}
+
+			if e := nextLifecycleEvent(t, events); e.Type != LifecycleExited || e.Failed != tc.stop {
+				t.Errorf("event = %+v", e)
+			}
+			select {
+			case e := <-events:
+				t.Errorf("unexpected event %+v", e)
+			case <-time.After(100 * time.Millisecond):
+			}
+			if *starts != 1 {
+				t.Errorf("started %d times", *starts)
+			}
+		})
+	}
+}
+
+func TestCancelRestart(t *testing.T) {
+	s := NewSupervisor(Resources{}, OvercommitRatios{})
+	fakeStarts(t, s, "exit 1")
+	events := make(chan *LifecycleEvent, 16)
+	s.SetRestartPolicy(&RestartPolicy{
+		Mode:    RestartAlways,
+		Backoff: time.Hour,
+		OnEvent: func(e *LifecycleEvent) { events <- e },
+	})
+
+	if _, err := s.Start(context.Background(), &VMConfig{Name: "vm"}); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	nextLifecycleEvent(t, events)
+	if e := nextLifecycleEvent(t, events); e.Type != LifecycleRestarting || e.Delay != time.Hour {
+		t.Fatalf("event = %+v, want restarting", e)
+	}
+
+	if !s.CancelRestart("vm") {
+		t.Fatal("CancelRestart found no pending restart")
+	}
+	if e := nextLifecycleEvent(t, events); e.Type != LifecycleGaveUp || !errors.Is(e.Err, ErrRestartCanceled) {
+		t.Errorf("event = %+v, want canceled", e)
+	}
+	if s.CancelRestart("vm") {
+		t.Error("CancelRestart succeeded twice")
+	}
+}
+
+func TestRestartPausedPanic(t *testing.T) {
+	fake := newFakeQMP(t)
+	s := NewSupervisor(Resources{}, OvercommitRatios{})
+	inst := fake.attach()
+
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	inst.process = cmd.Process
+	s.track(inst, &Reservation{s: s, restart: &RestartPolicy{Mode: RestartOnFailure}})
+
+	fake.sendEvent("GUEST_PANICKED", map[string]any{"action": "pause"})
+
+	done := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		cmd.Process.Kill()
+		t.Fatal("paused guest not killed")
+	}
+	if inst.stopRequested.Load() {
+		t.Error("kill marked as a requested stop")
+	}
+}
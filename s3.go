@@ -0,0 +1,343 @@
+package qemuctl
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultS3PartSize is the default multipart upload part size. S3 allows
+// at most s3MaxParts parts, so this covers backups of up to 160 GiB of
+// unknown size.
+const defaultS3PartSize = 16 << 20
+
+// s3MaxParts is the maximum number of parts of a multipart upload.
+const s3MaxParts = 10000
+
+// S3Target is a BackupTarget storing backups in an S3-compatible object
+// storage bucket. Backups are streamed with multipart uploads, holding one
+// part in memory at a time; requests are signed with AWS Signature
+// Version 4.
+type S3Target struct {
+	// Endpoint is the service URL, such as "https://s3.eu-west-1.amazonaws.com"
+	// or "http://minio:9000".
+	Endpoint string
+
+	// Region is the signing region. Defaults to "us-east-1".
+	Region string
+
+	// Bucket is the bucket name, Prefix is prepended to object keys.
+	Bucket string
+	Prefix string
+
+	// AccessKey and SecretKey are the credentials; SessionToken is set for
+	// temporary credentials.
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+
+	// PartSize is the multipart upload part size in bytes. Smaller objects
+	// are uploaded in a single request. Defaults to 16 MiB; S3 requires at
+	// least 5 MiB. It is raised to fit objects of known size in the 10000
+	// parts S3 allows; uploads of unknown size fail past that.
+	PartSize int64
+
+	// VirtualHosted addresses the bucket as a subdomain of the endpoint
+	// instead of as the first path element.
+	VirtualHosted bool
+
+	// Client is the HTTP client. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// S3Error is an error response from the object storage service.
+type S3Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *S3Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("S3 request failed with status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("S3 request failed with status %d: %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Put uploads the data read from r as object key. The size of r is known
+// if it has a Size method, like *bytes.Reader or *io.SectionReader, or is a
+// regular file.
+func (t *S3Target) Put(ctx context.Context, key string, r io.Reader) error {
+	partSize := t.PartSize
+	if partSize <= 0 {
+		partSize = defaultS3PartSize
+	}
+	if size := readerSize(r); size > partSize*s3MaxParts {
+		partSize = (size + s3MaxParts - 1) / s3MaxParts
+	}
+	key = t.Prefix + key
+
+	part := make([]byte, partSize)
+	n, err := io.ReadFull(r, part)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		_, err = t.do(ctx, http.MethodPut, key, nil, part[:n])
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	result, err := t.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return err
+	}
+	var upload struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.Unmarshal(result, &upload); err != nil || upload.UploadID == "" {
+		return fmt.Errorf("invalid multipart upload response: %s", result)
+	}
+
+	if err := t.uploadParts(ctx, key, upload.UploadID, r, part); err != nil {
+		// Free the parts already stored
+		t.do(context.WithoutCancel(ctx), http.MethodDelete, key, url.Values{"uploadId": {upload.UploadID}}, nil)
+		return err
+	}
+	return nil
+}
+
+// s3Part is a part of a CompleteMultipartUpload request.
+type s3Part struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// uploadParts uploads the first part, already read into part, and the
+// rest of r, then completes the upload.
+func (t *S3Target) uploadParts(ctx context.Context, key, uploadID string, r io.Reader, part []byte) error {
+	var parts []s3Part
+	n := len(part)
+	for number := 1; n > 0; number++ {
+		if number > s3MaxParts {
+			return fmt.Errorf("object larger than %d parts of %d bytes, the S3 multipart upload limit", s3MaxParts, len(part))
+		}
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+		req, err := t.request(ctx, http.MethodPut, key, query, part[:n])
+		if err != nil {
+			return err
+		}
+		resp, err := t.send(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		parts = append(parts, s3Part{PartNumber: number, ETag: resp.Header.Get("ETag")})
+
+		var rerr error
+		n, rerr = io.ReadFull(r, part)
+		if rerr != nil && rerr != io.EOF && rerr != io.ErrUnexpectedEOF {
+			return rerr
+		}
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []s3Part `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	result, err := t.do(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, body)
+	if err != nil {
+		return err
+	}
+	// CompleteMultipartUpload can fail after a 200 status
+	if s3err := parseS3Error(http.StatusOK, result); s3err.Code != "" {
+		return s3err
+	}
+	return nil
+}
+
+// readerSize returns the size of the data read from r, or -1 if unknown.
+func readerSize(r io.Reader) int64 {
+	switch r := r.(type) {
+	case interface{ Size() int64 }:
+		return r.Size()
+	case *os.File:
+		if fi, err := r.Stat(); err == nil && fi.Mode().IsRegular() {
+			return fi.Size()
+		}
+	}
+	return -1
+}
+
+// do sends a request and returns the response body.
+func (t *S3Target) do(ctx context.Context, method, key string, query url.Values, body []byte) ([]byte, error) {
+	req, err := t.request(ctx, method, key, query, body)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.send(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// send sends a request, turning error statuses into an *S3Error. The body
+// of successful responses is left to the caller.
+func (t *S3Target) send(req *http.Request) (*http.Response, error) {
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		return nil, parseS3Error(resp.StatusCode, data)
+	}
+	return resp, nil
+}
+
+// parseS3Error decodes an S3 error document.
+func parseS3Error(status int, data []byte) *S3Error {
+	s3err := &S3Error{StatusCode: status}
+	if bytes.Contains(data, []byte("<Error>")) {
+		xml.Unmarshal(data, s3err)
+	}
+	return s3err
+}
+
+// request builds a signed request.
+func (t *S3Target) request(ctx context.Context, method, key string, query url.Values, body []byte) (*http.Request, error) {
+	endpoint, err := url.Parse(t.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint: %w", err)
+	}
+	u := *endpoint
+	if t.VirtualHosted {
+		u.Host = t.Bucket + "." + u.Host
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + key
+	} else {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + t.Bucket + "/" + key
+	}
+	u.RawPath = s3EscapePath(u.Path)
+	u.RawQuery = s3Query(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	t.sign(req, body, time.Now().UTC())
+	return req, nil
+}
+
+// sign adds the AWS Signature Version 4 headers to req.
+func (t *S3Target) sign(req *http.Request, body []byte, now time.Time) {
+	region := t.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	payloadHash := sha256Hex(body)
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if t.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", t.SessionToken)
+	}
+
+	headers := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if t.SessionToken != "" {
+		headers = append(headers, "x-amz-security-token")
+	}
+	var canonicalHeaders strings.Builder
+	for _, h := range headers {
+		value := req.Header.Get(h)
+		if h == "host" {
+			value = req.URL.Host
+		}
+		canonicalHeaders.WriteString(h + ":" + strings.TrimSpace(value) + "\n")
+	}
+	signedHeaders := strings.Join(headers, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+t.SecretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		t.AccessKey, scope, signedHeaders, signature))
+}
+
+// s3EscapePath escapes a path the way Signature Version 4 expects.
+func s3EscapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for idx, s := range segments {
+		segments[idx] = s3Escape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// s3Query encodes a query string with sorted keys, as Signature Version 4
+// expects.
+func s3Query(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, s3Escape(k)+"="+s3Escape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Escape percent-encodes everything but unreserved characters.
+func s3Escape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
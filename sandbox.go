@@ -0,0 +1,205 @@
+package qemuctl
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Sandbox actions, for SandboxConfig.
+const (
+	SandboxAllow = "allow"
+	SandboxDeny  = "deny"
+
+	// SandboxChildren also lets processes spawned by QEMU elevate their
+	// privileges, for ElevatePrivileges only.
+	SandboxChildren = "children"
+)
+
+// SandboxConfig enables QEMU's seccomp filter (-sandbox on), which kills
+// QEMU when it makes a system call a guest escape would need. Each field
+// denies a class of system calls on top of the default filter; empty
+// fields keep QEMU's defaults.
+type SandboxConfig struct {
+	// Obsolete denies obsolete system calls (SandboxDeny).
+	Obsolete string `json:"obsolete,omitempty"`
+
+	// ElevatePrivileges denies set*uid and set*gid (SandboxDeny), or
+	// allows them to spawned processes only (SandboxChildren).
+	ElevatePrivileges string `json:"elevate_privileges,omitempty"`
+
+	// Spawn denies fork and execve (SandboxDeny). Tap scripts and the
+	// bridge helper then cannot run.
+	Spawn string `json:"spawn,omitempty"`
+
+	// ResourceControl denies changing process affinity and scheduler
+	// priority (SandboxDeny).
+	ResourceControl string `json:"resource_control,omitempty"`
+}
+
+// StrictSandbox returns a SandboxConfig denying every optional class of
+// system calls.
+func StrictSandbox() *SandboxConfig {
+	return &SandboxConfig{
+		Obsolete:          SandboxDeny,
+		ElevatePrivileges: SandboxDeny,
+		Spawn:             SandboxDeny,
+		ResourceControl:   SandboxDeny,
+	}
+}
+
+// validate checks the sandbox actions.
+func (cfg *SandboxConfig) validate() error {
+	for _, opt := range []struct{ name, value string }{
+		{"obsolete", cfg.Obsolete},
+		{"elevateprivileges", cfg.ElevatePrivileges},
+		{"spawn", cfg.Spawn},
+		{"resourcecontrol", cfg.ResourceControl},
+	} {
+		switch opt.value {
+		case "", SandboxAllow, SandboxDeny:
+		case SandboxChildren:
+			if opt.name == "elevateprivileges" {
+				continue
+			}
+			fallthrough
+		default:
+			return fmt.Errorf("sandbox: invalid %s action %q", opt.name, opt.value)
+		}
+	}
+	return nil
+}
+
+// buildSandboxArgs builds the -sandbox argument.
+func buildSandboxArgs(cfg *SandboxConfig) []string {
+	if cfg == nil {
+		return nil
+	}
+
+	parts := []string{"on"}
+	if cfg.Obsolete != "" {
+		parts = append(parts, "obsolete="+cfg.Obsolete)
+	}
+	if cfg.ElevatePrivileges != "" {
+		parts = append(parts, "elevateprivileges="+cfg.ElevatePrivileges)
+	}
+	if cfg.Spawn != "" {
+		parts = append(parts, "spawn="+cfg.Spawn)
+	}
+	if cfg.ResourceControl != "" {
+		parts = append(parts, "resourcecontrol="+cfg.ResourceControl)
+	}
+	return []string{"-sandbox", strings.Join(parts, ",")}
+}
+
+// sandboxFromOpts converts parsed -sandbox options, or returns nil if the
+// sandbox is off.
+func sandboxFromOpts(o *qemuOpts) *SandboxConfig {
+	if o.First != "on" {
+		return nil
+	}
+	return &SandboxConfig{
+		Obsolete:          o.get("obsolete"),
+		ElevatePrivileges: o.get("elevateprivileges"),
+		Spawn:             o.get("spawn"),
+		ResourceControl:   o.get("resourcecontrol"),
+	}
+}
+
+// checkSpawn rejects networks that need QEMU to spawn a process when the
+// sandbox denies it.
+func (cfg *VMConfig) checkSpawn() error {
+	if cfg.Sandbox == nil || cfg.Sandbox.Spawn != SandboxDeny {
+		return nil
+	}
+	for _, net := range cfg.Networks {
+		switch b := net.Backend.(type) {
+		case *BridgeNetBackend:
+			return fmt.Errorf("sandbox: network %s needs the bridge helper, but spawning is denied", net.ID)
+		case *TapNetBackend:
+			if (b.Script != "" && b.Script != "no") || (b.DownScript != "" && b.DownScript != "no") {
+				return fmt.Errorf("sandbox: network %s runs tap scripts, but spawning is denied", net.ID)
+			}
+		}
+	}
+	return nil
+}
+
+// checkRunAs rejects a group without a user.
+func checkRunAs(userName, groupName string) error {
+	if groupName != "" && userName == "" {
+		return fmt.Errorf("run-as group %s set without a user", groupName)
+	}
+	return nil
+}
+
+// applyRunAs makes cmd run as user and group (names or numeric IDs), with
+// the supplementary groups of the user. It does nothing if user is empty.
+// Only root can start processes as another user; chroot keeps the
+// capability QEMU needs to chroot.
+func applyRunAs(cmd *exec.Cmd, userName, groupName string, chroot bool) error {
+	if userName == "" {
+		return nil
+	}
+	cred, err := lookupCredential(userName, groupName)
+	if err != nil {
+		return err
+	}
+	if int(cred.Uid) == os.Getuid() && int(cred.Gid) == os.Getgid() {
+		return nil
+	}
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("running QEMU as user %s requires root", userName)
+	}
+	return setCredential(cmd, cred, chroot)
+}
+
+// lookupCredential resolves a user and group. A numeric user without a
+// passwd entry needs an explicit group.
+func lookupCredential(userName, groupName string) (*syscall.Credential, error) {
+	cred := &syscall.Credential{}
+
+	u, err := user.Lookup(userName)
+	if err != nil {
+		u, err = user.LookupId(userName)
+	}
+	switch {
+	case err == nil:
+		uid, _ := strconv.ParseUint(u.Uid, 10, 32)
+		gid, _ := strconv.ParseUint(u.Gid, 10, 32)
+		cred.Uid, cred.Gid = uint32(uid), uint32(gid)
+		groups, _ := u.GroupIds()
+		for _, g := range groups {
+			if id, err := strconv.ParseUint(g, 10, 32); err == nil {
+				cred.Groups = append(cred.Groups, uint32(id))
+			}
+		}
+	case groupName != "":
+		uid, err := strconv.ParseUint(userName, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("unknown user %s", userName)
+		}
+		cred.Uid = uint32(uid)
+	default:
+		return nil, fmt.Errorf("unknown user %s: set a group for users without a passwd entry", userName)
+	}
+
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			g, err = user.LookupGroupId(groupName)
+		}
+		var gid uint64
+		if err == nil {
+			gid, _ = strconv.ParseUint(g.Gid, 10, 32)
+		} else if gid, err = strconv.ParseUint(groupName, 10, 32); err != nil {
+			return nil, fmt.Errorf("unknown group %s", groupName)
+		}
+		cred.Gid = uint32(gid)
+	}
+	return cred, nil
+}
@@ -0,0 +1,24 @@
+//go:build linux
+
+package qemuctl
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// capSysChroot is CAP_SYS_CHROOT from linux/capability.h.
+const capSysChroot = 18
+
+// setCredential makes cmd run with cred. For a chroot, QEMU keeps
+// CAP_SYS_CHROOT as an ambient capability, and only that one.
+func setCredential(cmd *exec.Cmd, cred *syscall.Credential, chroot bool) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = cred
+	if chroot {
+		cmd.SysProcAttr.AmbientCaps = append(cmd.SysProcAttr.AmbientCaps, capSysChroot)
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+
+package qemuctl
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+)
+
+// setCredential makes cmd run with cred. Without ambient capabilities, a
+// process started as another user cannot chroot.
+func setCredential(cmd *exec.Cmd, cred *syscall.Credential, chroot bool) error {
+	if chroot {
+		return errors.New("chroot is only supported with RunAsUser on Linux")
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = cred
+	return nil
+}
@@ -0,0 +1,113 @@
+package qemuctl
+
+import (
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestVMBuilderSandbox(t *testing.T) {
+	cfg := &VMConfig{Sandbox: StrictSandbox()}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	args := NewVMBuilder(cfg).Build("test", "")
+	joined := strings.Join(args, " ")
+	want := "-sandbox on,obsolete=deny,elevateprivileges=deny,spawn=deny,resourcecontrol=deny"
+	if !strings.Contains(joined, want) {
+		t.Errorf("args missing %q:\n%s", want, joined)
+	}
+
+	parsed, err := ParseArgs(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed.Sandbox, cfg.Sandbox) {
+		t.Errorf("parsed sandbox = %+v, want %+v", parsed.Sandbox, cfg.Sandbox)
+	}
+}
+
+func TestBuildArgsSandbox(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Chroot = "/var/empty"
+	cfg.Sandbox = &SandboxConfig{Spawn: SandboxDeny}
+	joined := strings.Join(buildArgs(cfg, "test", "/tmp/test.sock"), " ")
+	for _, want := range []string{"-run-with chroot=/var/empty", "-sandbox on,spawn=deny"} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("args missing %q:\n%s", want, joined)
+		}
+	}
+}
+
+func TestVMBuilderRunAsPassesFiles(t *testing.T) {
+	cfg := &VMConfig{
+		RunAsUser: "qemu",
+		Disks:     []*DiskConfig{{ID: "disk0", Backend: &FileDiskBackend{Path: "/var/lib/vms/web.qcow2", Format: "qcow2"}}},
+	}
+	joined := strings.Join(NewVMBuilder(cfg).Build("test", ""), " ")
+	for _, want := range []string{"opaque=rw:/var/lib/vms/web.qcow2", `"filename":"/dev/fdset/0"`} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("args missing %q:\n%s", want, joined)
+		}
+	}
+	if strings.Contains(joined, "-run-with") {
+		t.Errorf("args chroot without a chroot directory:\n%s", joined)
+	}
+}
+
+func TestValidateSandbox(t *testing.T) {
+	for _, cfg := range []*VMConfig{
+		{Sandbox: &SandboxConfig{Spawn: SandboxChildren}},
+		{Sandbox: &SandboxConfig{Obsolete: "on"}},
+		{Sandbox: StrictSandbox(), Networks: []*NetworkConfig{{ID: "net0", Backend: &BridgeNetBackend{Bridge: "br0"}}}},
+		{Sandbox: StrictSandbox(), Networks: []*NetworkConfig{{ID: "net0", Backend: &TapNetBackend{Script: "/etc/qemu-ifup"}}}},
+		{RunAsGroup: "kvm"},
+		{RunAsUser: "qemu", Namespaces: &NamespaceConfig{PrivateTmp: true}},
+		{RunAsUser: "qemu", TPM: &TPMConfig{StateDir: "/var/lib/tpm"}},
+	} {
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Validate accepted %+v", cfg)
+		}
+	}
+
+	cfg := &VMConfig{
+		Sandbox:  &SandboxConfig{Spawn: SandboxDeny, ElevatePrivileges: SandboxChildren},
+		Networks: []*NetworkConfig{{ID: "net0", Backend: &TapNetBackend{Ifname: "tap0", Script: "no", DownScript: "no"}}},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLookupCredential(t *testing.T) {
+	cred, err := lookupCredential("root", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cred.Uid != 0 || cred.Gid != 0 {
+		t.Errorf("root credential = %+v", cred)
+	}
+
+	// Users without a passwd entry, as in containers, need a group
+	cred, err = lookupCredential("54321", "54322")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cred.Uid != 54321 || cred.Gid != 54322 || len(cred.Groups) != 0 {
+		t.Errorf("numeric credential = %+v", cred)
+	}
+	if _, err := lookupCredential("54321", ""); err == nil {
+		t.Error("lookupCredential accepted a numeric user without a group")
+	}
+	if _, err := lookupCredential("no-such-user", "0"); err == nil {
+		t.Error("lookupCredential accepted an unknown user name")
+	}
+
+	// Running as oneself needs no privileges
+	cmd := exec.Command("true")
+	if err := applyRunAs(cmd, "", "", false); err != nil || cmd.SysProcAttr != nil {
+		t.Errorf("applyRunAs without a user = %v, %+v", err, cmd.SysProcAttr)
+	}
+}
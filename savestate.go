@@ -0,0 +1,144 @@
+package qemuctl
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// Monitor names of the descriptors of the state file.
+const (
+	saveFdName    = "qemuctl-save"
+	restoreFdName = "qemuctl-restore"
+)
+
+// SaveToFile saves the whole state of the VM, memory and devices, to a
+// file, so that it can be suspended across host reboots and resumed with
+// RestoreFromFile. The guest is paused first, so that memory is written
+// once, and stays paused once saved; Quit then ends the process. On
+// failure, a guest that was running is resumed.
+//
+// Disk contents are not saved: the disks must be left untouched until the
+// VM is restored.
+func (i *Instance) SaveToFile(ctx context.Context, path string) error {
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return ErrNotConnected
+	}
+
+	status, err := queryRunStatus(qmp)
+	if err != nil {
+		return err
+	}
+	if status == "running" {
+		if _, err := qmp.Execute("stop", nil); err != nil {
+			return err
+		}
+	}
+
+	if err := i.saveToFile(ctx, qmp, path); err != nil {
+		os.Remove(path)
+		if status == "running" {
+			qmp.Execute("cont", nil)
+		}
+		return err
+	}
+	return nil
+}
+
+// saveToFile migrates the VM to the file at path, through a descriptor
+// passed to QEMU.
+func (i *Instance) saveToFile(ctx context.Context, qmp *QMP, path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := qmp.ExecuteWithFd("getfd", map[string]any{"fdname": saveFdName}, int(f.Fd())); err != nil {
+		return fmt.Errorf("failed to pass state file: %w", err)
+	}
+	if err := i.Migrate("fd:" + saveFdName); err != nil {
+		qmp.Execute("closefd", map[string]any{"fdname": saveFdName})
+		return err
+	}
+	if _, err := i.WaitMigration(ctx); err != nil {
+		qmp.Execute("migrate_cancel", nil)
+		return fmt.Errorf("failed to save VM state: %w", err)
+	}
+
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// RestoreFromFile starts a VM from a state file written by SaveToFile and
+// resumes the guest. cfg must describe the same hardware as the saved VM,
+// with the same disks, left untouched since the save.
+func RestoreFromFile(ctx context.Context, cfg *VMConfig, path string) (*Instance, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("restoring a VM needs its configuration")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	c := *cfg
+	c.Incoming = "defer"
+	inst, err := StartVMContext(ctx, &c)
+	if err != nil {
+		return nil, err
+	}
+	if err := inst.restoreFrom(ctx, f); err != nil {
+		inst.ForceStop()
+		return nil, err
+	}
+	return inst, nil
+}
+
+// restoreFrom loads the state of a VM waiting for a deferred incoming
+// migration from f, and resumes the guest.
+func (i *Instance) restoreFrom(ctx context.Context, f *os.File) error {
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return ErrNotConnected
+	}
+
+	if _, err := qmp.ExecuteWithFd("getfd", map[string]any{"fdname": restoreFdName}, int(f.Fd())); err != nil {
+		return fmt.Errorf("failed to pass state file: %w", err)
+	}
+	if _, err := qmp.Execute("migrate-incoming", map[string]any{"uri": "fd:" + restoreFdName}); err != nil {
+		qmp.Execute("closefd", map[string]any{"fdname": restoreFdName})
+		return err
+	}
+
+	// The incoming migration is done when the VM leaves inmigrate; QEMU
+	// exits if it fails to load the state
+	for {
+		status, err := queryRunStatus(qmp)
+		if err != nil {
+			return fmt.Errorf("failed to restore VM state: %w", err)
+		}
+		if status != "inmigrate" {
+			break
+		}
+		if err := sleepContext(ctx, migrationPollInterval); err != nil {
+			return err
+		}
+	}
+
+	// The guest was saved paused
+	if _, err := qmp.Execute("cont", nil); err != nil {
+		return err
+	}
+	return i.QueryState()
+}
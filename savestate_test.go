@@ -0,0 +1,123 @@
+package qemuctl
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// handleOK makes the fake monitor accept commands with an empty result.
+func handleOK(fake *fakeQMP, commands ...string) {
+	for _, cmd := range commands {
+		fake.handle(cmd, func(map[string]any) (any, *qmpError) { return map[string]any{}, nil })
+	}
+}
+
+// calledCommands returns the commands the fake monitor received, except
+// the status queries.
+func calledCommands(fake *fakeQMP) []string {
+	var cmds []string
+	for _, c := range fake.Calls() {
+		if c.Command != "qmp_capabilities" && c.Command != "query-status" && c.Command != "query-migrate" {
+			cmds = append(cmds, c.Command)
+		}
+	}
+	return cmds
+}
+
+func TestSaveToFile(t *testing.T) {
+	defer func(d time.Duration) { migrationPollInterval = d }(migrationPollInterval)
+	migrationPollInterval = time.Millisecond
+
+	fake := newFakeQMP(t)
+	handleOK(fake, "stop", "cont", "getfd", "closefd", "migrate", "migrate_cancel")
+	fake.handle("query-migrate", func(map[string]any) (any, *qmpError) {
+		return map[string]any{"status": "completed"}, nil
+	})
+	inst := fake.attach()
+
+	path := filepath.Join(t.TempDir(), "vm.state")
+	if err := inst.SaveToFile(context.Background(), path); err != nil {
+		t.Fatalf("SaveToFile failed: %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("state file not created: %v", err)
+	}
+	if got, want := calledCommands(fake), []string{"stop", "getfd", "migrate"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("commands = %q, want %q", got, want)
+	}
+	if m := fake.commands("migrate"); m[0]["uri"] != "fd:qemuctl-save" {
+		t.Errorf("migrate = %v", m)
+	}
+
+	// A failed save resumes the guest and removes the file
+	fake.handle("query-migrate", func(map[string]any) (any, *qmpError) {
+		return map[string]any{"status": "failed", "error-desc": "No space left on device"}, nil
+	})
+	if err := inst.SaveToFile(context.Background(), path); err == nil || !strings.Contains(err.Error(), "No space left") {
+		t.Errorf("SaveToFile = %v, want the migration error", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("state file left after a failed save")
+	}
+	if got := calledCommands(fake)[3:]; !reflect.DeepEqual(got, []string{"stop", "getfd", "migrate", "migrate_cancel", "cont"}) {
+		t.Errorf("commands after failure = %q", got)
+	}
+}
+
+func TestRestoreFrom(t *testing.T) {
+	defer func(d time.Duration) { migrationPollInterval = d }(migrationPollInterval)
+	migrationPollInterval = time.Millisecond
+
+	fake := newFakeQMP(t)
+	handleOK(fake, "getfd", "closefd", "migrate-incoming", "cont")
+	inst := fake.attach()
+
+	statuses := []string{"inmigrate", "inmigrate", "paused", "running"}
+	fake.handle("query-status", func(map[string]any) (any, *qmpError) {
+		status := statuses[0]
+		if len(statuses) > 1 {
+			statuses = statuses[1:]
+		}
+		return map[string]any{"status": status, "running": status == "running"}, nil
+	})
+
+	f, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := inst.restoreFrom(context.Background(), f); err != nil {
+		t.Fatalf("restoreFrom failed: %v", err)
+	}
+	if got, want := calledCommands(fake), []string{"getfd", "migrate-incoming", "cont"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("commands = %q, want %q", got, want)
+	}
+	if m := fake.commands("migrate-incoming"); m[0]["uri"] != "fd:qemuctl-restore" {
+		t.Errorf("migrate-incoming = %v", m)
+	}
+	if inst.State() != StateRunning {
+		t.Errorf("state = %v, want running", inst.State())
+	}
+}
+
+func TestVMBuilderIncoming(t *testing.T) {
+	cfg := DefaultVMConfig()
+	cfg.Incoming = "defer"
+	args := NewVMBuilder(cfg).Build("vm", "/run/qemu/vm.sock")
+	if !strings.Contains(strings.Join(args, " "), "-incoming defer") {
+		t.Errorf("expected -incoming defer in: %s", strings.Join(args, " "))
+	}
+
+	parsed, err := ParseArgs(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Incoming != "defer" {
+		t.Errorf("parsed Incoming = %q", parsed.Incoming)
+	}
+}
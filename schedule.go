@@ -0,0 +1,450 @@
+package qemuctl
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// scheduleFile holds the pending scheduled operations in the scheduler
+// directory.
+const scheduleFile = "schedule.json"
+
+// scheduledStopTimeout is the graceful shutdown timeout of scheduled stops.
+const scheduledStopTimeout = 2 * time.Minute
+
+// schedulerIdle is how long the scheduler sleeps with nothing scheduled.
+const schedulerIdle = time.Hour
+
+var (
+	// ErrNotSupervised is returned when scheduling operations on an
+	// instance that was not started by a Supervisor.
+	ErrNotSupervised = errors.New("instance is not managed by a supervisor")
+
+	// ErrSchedulerDisabled is returned when scheduling operations before
+	// Supervisor.EnableScheduler.
+	ErrSchedulerDisabled = errors.New("supervisor scheduler not enabled")
+)
+
+// ScheduledOpKind is the kind of a scheduled operation.
+type ScheduledOpKind string
+
+const (
+	// ScheduledStop gracefully stops the VM, killing it after two minutes.
+	ScheduledStop ScheduledOpKind = "stop"
+
+	// ScheduledSnapshot saves an internal snapshot of the running VM
+	// (savevm), tagged "<vm>-<YYYYMMDD-HHMMSS>".
+	ScheduledSnapshot ScheduledOpKind = "snapshot"
+)
+
+// ScheduledOp is an operation scheduled on a Supervisor.
+type ScheduledOp struct {
+	ID   string          `json:"id"`
+	Kind ScheduledOpKind `json:"kind"`
+
+	// VM is the instance name. SocketPath is used to reach the instance
+	// when it is not managed by the supervisor, such as after a restart of
+	// the managing process.
+	VM         string `json:"vm"`
+	SocketPath string `json:"socket_path,omitempty"`
+
+	// Cron is the schedule of recurring operations; one-shot operations
+	// have none and are removed once run.
+	Cron string `json:"cron,omitempty"`
+
+	// Next is when the operation runs next.
+	Next time.Time `json:"next"`
+
+	// LastRun and LastError record the previous run of recurring
+	// operations.
+	LastRun   time.Time `json:"last_run"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// scheduledOp is a pending operation with its parsed schedule.
+type scheduledOp struct {
+	ScheduledOp
+	cron *cronSchedule
+}
+
+// scheduler runs the scheduled operations of a Supervisor and persists
+// them to a file so they survive restarts of the managing process.
+type scheduler struct {
+	s    *Supervisor
+	path string
+	wake chan struct{}
+	stop chan struct{}
+	wg   sync.WaitGroup
+
+	mu  sync.Mutex
+	ops map[string]*scheduledOp
+}
+
+// EnableScheduler starts running scheduled operations, persisting them in
+// dir (the default socket directory if empty). Operations left pending in
+// dir by a previous process are loaded: one-shot operations that are
+// overdue run immediately, recurring ones resume at their next time.
+func (s *Supervisor) EnableScheduler(dir string) error {
+	if dir == "" {
+		var err error
+		if dir, err = defaultSocketDir(); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create scheduler directory: %w", err)
+	}
+
+	sc := &scheduler{
+		s:    s,
+		path: filepath.Join(dir, scheduleFile),
+		wake: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+		ops:  make(map[string]*scheduledOp),
+	}
+	if err := sc.load(time.Now()); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sched != nil {
+		return errors.New("supervisor scheduler already enabled")
+	}
+	s.sched = sc
+
+	sc.wg.Add(1)
+	go sc.run()
+	return nil
+}
+
+// DisableScheduler stops running scheduled operations and waits for those
+// in progress. Pending operations stay persisted for the next
+// EnableScheduler.
+func (s *Supervisor) DisableScheduler() {
+	s.mu.Lock()
+	sc := s.sched
+	s.sched = nil
+	s.mu.Unlock()
+
+	if sc != nil {
+		close(sc.stop)
+		sc.wg.Wait()
+	}
+}
+
+// ScheduledOps returns the pending operations, soonest first.
+func (s *Supervisor) ScheduledOps() []ScheduledOp {
+	sc := s.scheduler()
+	if sc == nil {
+		return nil
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	list := make([]ScheduledOp, 0, len(sc.ops))
+	for _, op := range sc.ops {
+		list = append(list, op.ScheduledOp)
+	}
+	sort.Slice(list, func(a, b int) bool { return list[a].Next.Before(list[b].Next) })
+	return list
+}
+
+// CancelScheduled removes a pending operation.
+func (s *Supervisor) CancelScheduled(id string) error {
+	sc := s.scheduler()
+	if sc == nil {
+		return ErrSchedulerDisabled
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if _, ok := sc.ops[id]; !ok {
+		return fmt.Errorf("scheduled operation %s not found", id)
+	}
+	delete(sc.ops, id)
+	return sc.saveLocked()
+}
+
+// scheduler returns the scheduler, or nil if it is not enabled.
+func (s *Supervisor) scheduler() *scheduler {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sched
+}
+
+// ScheduleStop schedules a graceful stop of the instance at the given
+// time. The instance must have been started by a Supervisor with its
+// scheduler enabled.
+func (i *Instance) ScheduleStop(at time.Time) (ScheduledOp, error) {
+	return i.schedule(ScheduledStop, at, "")
+}
+
+// ScheduleSnapshot schedules recurring internal snapshots of the instance
+// following a five-field cron expression ("minute hour day month weekday")
+// or a macro such as "@daily", in local time. The instance must have been
+// started by a Supervisor with its scheduler enabled.
+func (i *Instance) ScheduleSnapshot(cron string) (ScheduledOp, error) {
+	return i.schedule(ScheduledSnapshot, time.Time{}, cron)
+}
+
+// schedule adds an operation to the supervisor's scheduler.
+func (i *Instance) schedule(kind ScheduledOpKind, at time.Time, cron string) (ScheduledOp, error) {
+	if i.supervisor == nil {
+		return ScheduledOp{}, ErrNotSupervised
+	}
+	sc := i.supervisor.scheduler()
+	if sc == nil {
+		return ScheduledOp{}, ErrSchedulerDisabled
+	}
+
+	op := &scheduledOp{ScheduledOp: ScheduledOp{
+		ID:         newScheduleID(),
+		Kind:       kind,
+		VM:         i.Name(),
+		SocketPath: i.SocketPath(),
+		Cron:       cron,
+		Next:       at,
+	}}
+	if cron != "" {
+		sched, err := parseCron(cron)
+		if err != nil {
+			return ScheduledOp{}, err
+		}
+		op.cron = sched
+		if op.Next = sched.next(time.Now()); op.Next.IsZero() {
+			return ScheduledOp{}, fmt.Errorf("cron expression %q never matches", cron)
+		}
+	}
+
+	if err := sc.add(op); err != nil {
+		return ScheduledOp{}, err
+	}
+	return op.ScheduledOp, nil
+}
+
+// newScheduleID returns a random operation ID.
+func newScheduleID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// add stores a new operation and wakes the scheduler.
+func (sc *scheduler) add(op *scheduledOp) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	sc.ops[op.ID] = op
+	if err := sc.saveLocked(); err != nil {
+		delete(sc.ops, op.ID)
+		return err
+	}
+
+	select {
+	case sc.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// run sleeps until the next operation is due and runs it, until stopped.
+func (sc *scheduler) run() {
+	defer sc.wg.Done()
+
+	for {
+		wait := schedulerIdle
+		sc.mu.Lock()
+		for _, op := range sc.ops {
+			wait = min(wait, time.Until(op.Next))
+		}
+		sc.mu.Unlock()
+
+		timer := time.NewTimer(max(wait, 0))
+		select {
+		case <-sc.stop:
+			timer.Stop()
+			return
+		case <-sc.wake:
+			timer.Stop()
+		case now := <-timer.C:
+			sc.runDue(now)
+		}
+	}
+}
+
+// runDue starts the operations due at now, removing one-shot operations
+// and rescheduling recurring ones.
+func (sc *scheduler) runDue(now time.Time) {
+	sc.mu.Lock()
+	var due []ScheduledOp
+	for id, op := range sc.ops {
+		if op.Next.After(now) {
+			continue
+		}
+		due = append(due, op.ScheduledOp)
+		if op.cron == nil {
+			delete(sc.ops, id)
+		} else {
+			op.Next = op.cron.next(now)
+		}
+	}
+	if len(due) > 0 {
+		sc.saveLocked()
+	}
+	sc.mu.Unlock()
+
+	for _, op := range due {
+		sc.wg.Add(1)
+		go func(op ScheduledOp) {
+			defer sc.wg.Done()
+			sc.finish(op.ID, now, sc.execute(op))
+		}(op)
+	}
+}
+
+// finish records the outcome of a recurring operation.
+func (sc *scheduler) finish(id string, at time.Time, err error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	op, ok := sc.ops[id]
+	if !ok {
+		return
+	}
+	op.LastRun = at
+	op.LastError = ""
+	if err != nil {
+		op.LastError = err.Error()
+	}
+	sc.saveLocked()
+}
+
+// execute runs an operation on its instance.
+func (sc *scheduler) execute(op ScheduledOp) error {
+	inst, release, err := sc.instance(op)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	switch op.Kind {
+	case ScheduledStop:
+		ctx, cancel := context.WithTimeout(context.Background(), scheduledStopTimeout+time.Minute)
+		defer cancel()
+		return inst.StopContext(ctx, scheduledStopTimeout)
+
+	case ScheduledSnapshot:
+		tag := op.VM + "-" + time.Now().Format("20060102-150405")
+		out, err := inst.HumanMonitorCommand("savevm " + tag)
+		if err != nil {
+			return err
+		}
+		// savevm reports failures as monitor output
+		if out != "" {
+			return fmt.Errorf("savevm failed: %s", out)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown scheduled operation %q", op.Kind)
+}
+
+// instance finds the instance an operation applies to: a running instance
+// of the supervisor or, failing that, one attached through its socket.
+// release closes the connection of attached instances.
+func (sc *scheduler) instance(op ScheduledOp) (*Instance, func(), error) {
+	sc.s.mu.Lock()
+	for inst := range sc.s.instances {
+		if inst.Name() == op.VM {
+			sc.s.mu.Unlock()
+			return inst, func() {}, nil
+		}
+	}
+	sc.s.mu.Unlock()
+
+	if op.SocketPath == "" {
+		return nil, nil, fmt.Errorf("VM %s is not running", op.VM)
+	}
+	inst, err := Attach(op.SocketPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("VM %s: %w", op.VM, err)
+	}
+	return inst, func() {
+		if qmp := inst.QMP(); qmp != nil {
+			qmp.Close()
+		}
+	}, nil
+}
+
+// load reads the operations persisted by a previous process.
+func (sc *scheduler) load(now time.Time) error {
+	data, err := os.ReadFile(sc.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read scheduled operations: %w", err)
+	}
+
+	var ops []ScheduledOp
+	if err := json.Unmarshal(data, &ops); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", sc.path, err)
+	}
+
+	for _, op := range ops {
+		entry := &scheduledOp{ScheduledOp: op}
+		if op.Cron != "" {
+			if entry.cron, err = parseCron(op.Cron); err != nil {
+				return fmt.Errorf("scheduled operation %s: %w", op.ID, err)
+			}
+			// Runs missed while no process was managing the VM are skipped
+			if entry.Next.Before(now) {
+				entry.Next = entry.cron.next(now)
+			}
+		}
+		sc.ops[op.ID] = entry
+	}
+	return nil
+}
+
+// saveLocked persists the pending operations, replacing the file
+// atomically.
+func (sc *scheduler) saveLocked() error {
+	list := make([]ScheduledOp, 0, len(sc.ops))
+	for _, op := range sc.ops {
+		list = append(list, op.ScheduledOp)
+	}
+	sort.Slice(list, func(a, b int) bool { return list[a].ID < list[b].ID })
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal scheduled operations: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(sc.path), "."+scheduleFile+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write scheduled operations: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write scheduled operations: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write scheduled operations: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), sc.path); err != nil {
+		return fmt.Errorf("failed to write scheduled operations: %w", err)
+	}
+	return nil
+}
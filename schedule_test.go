@@ -0,0 +1,133 @@
+package qemuctl
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// supervisedFake attaches to fake and registers the instance with a new
+// supervisor, as Supervisor.Start would.
+func supervisedFake(t *testing.T, fake *fakeQMP) (*Supervisor, *Instance) {
+	t.Helper()
+	s := NewSupervisor(Resources{}, OvercommitRatios{})
+	inst := fake.attach()
+	s.track(inst, &Reservation{s: s})
+	return s, inst
+}
+
+func TestScheduleNotSupervised(t *testing.T) {
+	fake := newFakeQMP(t)
+	inst := fake.attach()
+
+	if _, err := inst.ScheduleStop(time.Now()); !errors.Is(err, ErrNotSupervised) {
+		t.Errorf("ScheduleStop = %v, want ErrNotSupervised", err)
+	}
+
+	_, inst = supervisedFake(t, newFakeQMP(t))
+	if _, err := inst.ScheduleStop(time.Now()); !errors.Is(err, ErrSchedulerDisabled) {
+		t.Errorf("ScheduleStop = %v, want ErrSchedulerDisabled", err)
+	}
+}
+
+func TestScheduleStop(t *testing.T) {
+	fake := newFakeQMP(t)
+	fake.handle("system_powerdown", func(map[string]any) (any, *qmpError) {
+		return map[string]any{}, nil
+	})
+	s, inst := supervisedFake(t, fake)
+	if err := s.EnableScheduler(t.TempDir()); err != nil {
+		t.Fatalf("EnableScheduler failed: %v", err)
+	}
+	defer s.DisableScheduler()
+
+	op, err := inst.ScheduleStop(time.Now().Add(50 * time.Millisecond))
+	if err != nil {
+		t.Fatalf("ScheduleStop failed: %v", err)
+	}
+	if ops := s.ScheduledOps(); len(ops) != 1 || ops[0].ID != op.ID || ops[0].Kind != ScheduledStop {
+		t.Errorf("ScheduledOps() = %+v", ops)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(fake.commands("system_powerdown")) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(fake.commands("system_powerdown")) != 1 {
+		t.Fatal("scheduled stop did not run")
+	}
+	if ops := s.ScheduledOps(); len(ops) != 0 {
+		t.Errorf("one-shot operation still pending: %+v", ops)
+	}
+}
+
+func TestSchedulePersistence(t *testing.T) {
+	dir := t.TempDir()
+	fake := newFakeQMP(t)
+	s, inst := supervisedFake(t, fake)
+	if err := s.EnableScheduler(dir); err != nil {
+		t.Fatalf("EnableScheduler failed: %v", err)
+	}
+
+	if _, err := inst.ScheduleSnapshot("not a cron"); err == nil {
+		t.Error("ScheduleSnapshot accepted an invalid cron expression")
+	}
+	snap, err := inst.ScheduleSnapshot("@daily")
+	if err != nil {
+		t.Fatalf("ScheduleSnapshot failed: %v", err)
+	}
+	stop, err := inst.ScheduleStop(time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("ScheduleStop failed: %v", err)
+	}
+	if err := s.CancelScheduled(stop.ID); err != nil {
+		t.Fatalf("CancelScheduled failed: %v", err)
+	}
+	s.DisableScheduler()
+
+	// A new manager process picks up the pending snapshot
+	s2 := NewSupervisor(Resources{}, OvercommitRatios{})
+	if err := s2.EnableScheduler(dir); err != nil {
+		t.Fatalf("EnableScheduler failed: %v", err)
+	}
+	defer s2.DisableScheduler()
+
+	ops := s2.ScheduledOps()
+	if len(ops) != 1 {
+		t.Fatalf("reloaded %d operations, want 1: %+v", len(ops), ops)
+	}
+	if ops[0].ID != snap.ID || ops[0].Cron != "@daily" || !ops[0].Next.Equal(snap.Next) {
+		t.Errorf("reloaded %+v, want %+v", ops[0], snap)
+	}
+	if ops[0].SocketPath != inst.SocketPath() {
+		t.Errorf("SocketPath = %q, want %q", ops[0].SocketPath, inst.SocketPath())
+	}
+}
+
+func TestScheduledSnapshotAttaches(t *testing.T) {
+	fake := newFakeQMP(t)
+	fake.handle("human-monitor-command", func(map[string]any) (any, *qmpError) {
+		return "", nil
+	})
+
+	// The VM is not managed by this supervisor, as after a restart
+	s := NewSupervisor(Resources{}, OvercommitRatios{})
+	if err := s.EnableScheduler(t.TempDir()); err != nil {
+		t.Fatalf("EnableScheduler failed: %v", err)
+	}
+	sc := s.scheduler()
+	err := sc.execute(ScheduledOp{Kind: ScheduledSnapshot, VM: "web", SocketPath: fake.path})
+	s.DisableScheduler()
+	if err != nil {
+		t.Fatalf("execute failed: %v", err)
+	}
+
+	calls := fake.commands("human-monitor-command")
+	if len(calls) != 1 {
+		t.Fatalf("got %d monitor commands, want 1", len(calls))
+	}
+	if cmd, _ := calls[0]["command-line"].(string); !strings.HasPrefix(cmd, "savevm web-") {
+		t.Errorf("command = %q, want savevm web-<time>", cmd)
+	}
+}
@@ -0,0 +1,94 @@
+package qemuctl
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeScreendump answers screendump by writing data to the temp file
+// Screenshot passed to QEMU, found in TMPDIR.
+func fakeScreendump(t *testing.T, fake *fakeQMP, data []byte) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	fake.handle("add-fd", func(map[string]any) (any, *qmpError) {
+		return map[string]any{"fdset-id": 3, "fd": 42}, nil
+	})
+	fake.handle("remove-fd", func(map[string]any) (any, *qmpError) {
+		return map[string]any{}, nil
+	})
+	fake.handle("screendump", func(map[string]any) (any, *qmpError) {
+		files, _ := filepath.Glob(filepath.Join(tmp, "qemuctl-screendump-*"))
+		if len(files) != 1 {
+			return nil, &qmpError{Class: "GenericError", Desc: "no screendump file"}
+		}
+		if err := os.WriteFile(files[0], data, 0600); err != nil {
+			return nil, &qmpError{Class: "GenericError", Desc: err.Error()}
+		}
+		return map[string]any{}, nil
+	})
+}
+
+func TestScreenshot(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	src.Set(1, 0, color.RGBA{R: 0x10, G: 0x20, B: 0x30, A: 0xff})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := newFakeQMP(t)
+	fakeScreendump(t, fake, buf.Bytes())
+	inst := fake.attach()
+
+	img, err := inst.ScreenshotHead("video1", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != 2 || img.Bounds().Dy() != 1 {
+		t.Errorf("image size = %v, want 2x1", img.Bounds())
+	}
+	if r, g, b, _ := img.At(1, 0).RGBA(); r>>8 != 0x10 || g>>8 != 0x20 || b>>8 != 0x30 {
+		t.Errorf("pixel = %v", img.At(1, 0))
+	}
+
+	dumps := fake.commands("screendump")
+	if len(dumps) != 1 {
+		t.Fatalf("screendump sent %d times", len(dumps))
+	}
+	if d := dumps[0]; d["filename"] != "/dev/fdset/3" || d["format"] != "png" || d["device"] != "video1" || d["head"] != float64(1) {
+		t.Errorf("screendump arguments = %v", d)
+	}
+	if removed := fake.commands("remove-fd"); len(removed) != 1 || removed[0]["fdset-id"] != float64(3) {
+		t.Errorf("remove-fd arguments = %v", removed)
+	}
+	if files, _ := filepath.Glob(filepath.Join(os.Getenv("TMPDIR"), "*")); len(files) != 0 {
+		t.Errorf("temporary files left: %v", files)
+	}
+}
+
+func TestScreenshotPPM(t *testing.T) {
+	fake := newFakeQMP(t)
+	fake.SetVersion(6, 2, 0)
+	fakeScreendump(t, fake, []byte("P6\n2 1\n255\n\x00\x00\x00\x10\x20\x30"))
+	inst := fake.attach()
+
+	img, err := inst.Screenshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := img.At(1, 0); got != (color.RGBA{R: 0x10, G: 0x20, B: 0x30, A: 0xff}) {
+		t.Errorf("pixel = %v", got)
+	}
+	if d := fake.commands("screendump")[0]; d["format"] != nil || d["device"] != nil {
+		t.Errorf("screendump arguments = %v, want no format or device", d)
+	}
+
+	if _, err := decodePPM(bytes.NewReader([]byte("P6\n2 1\n255\n\x00\x00"))); err == nil {
+		t.Error("decodePPM() accepted a truncated image")
+	}
+}
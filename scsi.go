@@ -0,0 +1,145 @@
+package qemuctl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// defaultSCSIController is the ID of the controller SCSI disks without an
+// address attach to. The builder adds it when SCSI disks need it and it is
+// not configured.
+const defaultSCSIController = "scsi0"
+
+// SCSIControllerConfig configures a virtio-scsi controller. Disks with
+// Interface "scsi" attach to it as LUNs.
+type SCSIControllerConfig struct {
+	// ID is the controller ID disks refer to.
+	ID string `json:"id"`
+
+	// NumQueues is the number of request queues, usually one per vCPU.
+	// Zero keeps QEMU's default.
+	NumQueues int `json:"num_queues,omitempty"`
+
+	// IOThread is the ID of the VMConfig.IOThreads entry processing the
+	// requests of all the controller's disks.
+	IOThread string `json:"iothread,omitempty"`
+}
+
+// SCSIAddress places a SCSI disk on a controller.
+type SCSIAddress struct {
+	// Controller is the controller ID, defaulting to "scsi0".
+	Controller string `json:"controller,omitempty"`
+
+	// Target is the SCSI target (0-255).
+	Target int `json:"target,omitempty"`
+
+	// LUN is the logical unit number (0-16383).
+	LUN int `json:"lun,omitempty"`
+}
+
+// controller returns the ID of the controller of a SCSI address, which
+// may be nil.
+func (a *SCSIAddress) controller() string {
+	if a == nil || a.Controller == "" {
+		return defaultSCSIController
+	}
+	return a.Controller
+}
+
+// scsiControllers returns the controllers of a configuration, with the
+// default one added if SCSI disks need it.
+func (cfg *VMConfig) scsiControllers() []*SCSIControllerConfig {
+	for _, c := range cfg.SCSIControllers {
+		if c.ID == defaultSCSIController {
+			return cfg.SCSIControllers
+		}
+	}
+	for _, disk := range cfg.Disks {
+		if disk.Interface == "scsi" && disk.SCSI.controller() == defaultSCSIController {
+			return append(cfg.SCSIControllers[:len(cfg.SCSIControllers):len(cfg.SCSIControllers)],
+				&SCSIControllerConfig{ID: defaultSCSIController})
+		}
+	}
+	return cfg.SCSIControllers
+}
+
+// checkSCSI checks that SCSI disks refer to configured controllers and do
+// not share an address.
+func (cfg *VMConfig) checkSCSI() error {
+	controllers := make(map[string]bool)
+	for _, c := range cfg.scsiControllers() {
+		if c.ID == "" {
+			return fmt.Errorf("SCSI controller without an ID")
+		}
+		if controllers[c.ID] {
+			return fmt.Errorf("duplicate SCSI controller %s", c.ID)
+		}
+		controllers[c.ID] = true
+	}
+
+	used := make(map[SCSIAddress]string)
+	for _, disk := range cfg.Disks {
+		if disk.SCSI == nil {
+			continue
+		}
+		if disk.Interface != "scsi" {
+			return fmt.Errorf("disk %s: SCSI address on a %s disk", disk.ID, disk.Interface)
+		}
+		addr := SCSIAddress{Controller: disk.SCSI.controller(), Target: disk.SCSI.Target, LUN: disk.SCSI.LUN}
+		switch {
+		case !controllers[addr.Controller]:
+			return fmt.Errorf("disk %s: unknown SCSI controller %s", disk.ID, addr.Controller)
+		case addr.Target < 0 || addr.Target > 255:
+			return fmt.Errorf("disk %s: SCSI target %d out of range", disk.ID, addr.Target)
+		case addr.LUN < 0 || addr.LUN > 16383:
+			return fmt.Errorf("disk %s: LUN %d out of range", disk.ID, addr.LUN)
+		case used[addr] != "":
+			return fmt.Errorf("disks %s and %s have the same SCSI address", used[addr], disk.ID)
+		}
+		used[addr] = disk.ID
+	}
+	return nil
+}
+
+// buildSCSIControllers builds the virtio-scsi controllers.
+func (b *VMBuilder) buildSCSIControllers() {
+	for _, c := range b.config.scsiControllers() {
+		dev := b.profile.virtio("virtio-scsi") + ",id=" + c.ID
+		switch {
+		case b.profile.virtioOnPCI():
+			dev += ",bus=" + b.pciAlloc.Bus() + ",addr=" + b.pciAlloc.Alloc()
+		case b.profile.virtioOnCCW():
+			dev += ",devno=" + b.ccwAlloc.Alloc()
+		}
+		if c.NumQueues > 0 {
+			dev += ",num_queues=" + strconv.Itoa(c.NumQueues)
+		}
+		if c.IOThread != "" {
+			dev += ",iothread=" + c.IOThread
+		}
+		b.args = append(b.args, "-device", dev)
+	}
+}
+
+// scsiDeviceOpts returns the bus options of a SCSI disk.
+func scsiDeviceOpts(addr *SCSIAddress) string {
+	opts := ",bus=" + addr.controller() + ".0"
+	if addr != nil {
+		opts += ",scsi-id=" + strconv.Itoa(addr.Target) + ",lun=" + strconv.Itoa(addr.LUN)
+	}
+	return opts
+}
+
+// scsiAddressFromOpts rebuilds the address of a SCSI disk from its device
+// options, nil if it has the default one.
+func scsiAddressFromOpts(o *qemuOpts) *SCSIAddress {
+	controller := strings.TrimSuffix(o.get("bus"), ".0")
+	if o.get("scsi-id") == "" && o.get("lun") == "" && (controller == "" || controller == defaultSCSIController) {
+		return nil
+	}
+	if controller == defaultSCSIController {
+		controller = ""
+	}
+	return &SCSIAddress{Controller: controller, Target: o.int("scsi-id"), LUN: o.int("lun")}
+}
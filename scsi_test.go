@@ -0,0 +1,111 @@
+package qemuctl
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestVMBuilderSCSIController(t *testing.T) {
+	disk := func(id string, addr *SCSIAddress) *DiskConfig {
+		return &DiskConfig{ID: id, Backend: &FileDiskBackend{Path: "/images/" + id + ".raw", Format: "raw"}, Interface: "scsi", SCSI: addr}
+	}
+
+	// SCSI disks without a controller get the default one
+	cfg := &VMConfig{Disks: []*DiskConfig{disk("disk0", nil)}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	joined := strings.Join(NewVMBuilder(cfg).Build("test", ""), " ")
+	for _, want := range []string{
+		"-device virtio-scsi-pci,id=scsi0,bus=pcie.0,addr=0x3",
+		"-device scsi-hd,drive=disk0-format,id=disk0-device,bus=scsi0.0",
+	} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("args missing %q:\n%s", want, joined)
+		}
+	}
+	if strings.Index(joined, "virtio-scsi-pci") > strings.Index(joined, "scsi-hd") {
+		t.Error("the controller must come before its disks")
+	}
+
+	// Several LUNs on a controller with its own I/O thread
+	cfg = &VMConfig{
+		IOThreads:       []*IOThreadConfig{{ID: "io0"}},
+		SCSIControllers: []*SCSIControllerConfig{{ID: "scsi0", NumQueues: 4, IOThread: "io0"}},
+		Disks:           []*DiskConfig{disk("disk0", &SCSIAddress{LUN: 0}), disk("disk1", &SCSIAddress{LUN: 1})},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	args := NewVMBuilder(cfg).Build("test", "")
+	joined = strings.Join(args, " ")
+	for _, want := range []string{
+		"virtio-scsi-pci,id=scsi0,bus=pcie.0,addr=0x3,num_queues=4,iothread=io0",
+		"scsi-hd,drive=disk0-format,id=disk0-device,bus=scsi0.0,scsi-id=0,lun=0",
+		"scsi-hd,drive=disk1-format,id=disk1-device,bus=scsi0.0,scsi-id=0,lun=1",
+	} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("args missing %q:\n%s", want, joined)
+		}
+	}
+
+	parsed, err := ParseArgs(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed.SCSIControllers, cfg.SCSIControllers) {
+		t.Errorf("parsed controllers = %+v, want %+v", parsed.SCSIControllers, cfg.SCSIControllers)
+	}
+	if len(parsed.Disks) != 2 || !reflect.DeepEqual(parsed.Disks[1].SCSI, &SCSIAddress{LUN: 1}) {
+		t.Errorf("parsed disks = %+v", parsed.Disks)
+	}
+
+	for _, bad := range []*VMConfig{
+		{Disks: []*DiskConfig{disk("disk0", &SCSIAddress{Controller: "scsi1"})}},
+		{Disks: []*DiskConfig{disk("disk0", &SCSIAddress{LUN: 2}), disk("disk1", &SCSIAddress{LUN: 2})}},
+		{Disks: []*DiskConfig{disk("disk0", &SCSIAddress{LUN: 20000})}},
+		{Disks: []*DiskConfig{{ID: "disk0", SCSI: &SCSIAddress{}}}},
+		{SCSIControllers: []*SCSIControllerConfig{{ID: "scsi0", IOThread: "io9"}}},
+	} {
+		if err := bad.Validate(); err == nil {
+			t.Errorf("Validate() accepted %+v", bad)
+		}
+	}
+}
+
+func TestLibvirtSCSIController(t *testing.T) {
+	cfg := &VMConfig{
+		Name:            "db",
+		Arch:            "amd64",
+		SCSIControllers: []*SCSIControllerConfig{{ID: "scsi0", NumQueues: 2}},
+		Disks: []*DiskConfig{
+			{ID: "disk0", Backend: &FileDiskBackend{Path: "/images/a.raw"}, Interface: "scsi"},
+			{ID: "disk1", Backend: &FileDiskBackend{Path: "/images/b.raw"}, Interface: "scsi", SCSI: &SCSIAddress{Target: 1, LUN: 3}},
+		},
+	}
+	x, err := cfg.ToLibvirtXML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<controller type="scsi" index="0" model="virtio-scsi">`,
+		`<driver queues="2"></driver>`,
+		`<address type="drive" controller="0" bus="0" target="1" unit="3"></address>`,
+	} {
+		if !strings.Contains(x, want) {
+			t.Errorf("XML missing %s:\n%s", want, x)
+		}
+	}
+
+	back, err := ParseLibvirtXML([]byte(x))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(back.SCSIControllers, cfg.SCSIControllers) {
+		t.Errorf("controllers = %+v, want %+v", back.SCSIControllers, cfg.SCSIControllers)
+	}
+	if back.Disks[0].SCSI != nil || !reflect.DeepEqual(back.Disks[1].SCSI, cfg.Disks[1].SCSI) {
+		t.Errorf("disk addresses = %+v, %+v", back.Disks[0].SCSI, back.Disks[1].SCSI)
+	}
+}
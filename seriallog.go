@@ -0,0 +1,277 @@
+package qemuctl
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Serial log defaults.
+const (
+	defaultSerialLogMaxSize  = 10 << 20
+	defaultSerialLogMaxFiles = 5
+)
+
+// serialLogTimeFormat is the timestamp starting each logged line.
+const serialLogTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// SerialLogConfig records all output of a serial port, whatever its
+// backend, so that boot problems of headless VMs can be diagnosed after the
+// fact. Each line is prefixed with the UTC time it was received. QEMU
+// copies the output to a FIFO next to the QMP socket, read by the
+// launcher, so logs are only recorded for VMs started with StartVM.
+type SerialLogConfig struct {
+	// Path is the log file. When it reaches MaxSize, it is renamed to
+	// Path.1, Path.1 to Path.2 and so on. Output is appended to an
+	// existing file, so restarts of the VM go to the same log.
+	Path string `json:"path,omitempty"`
+
+	// MaxSize is the size at which the log is rotated (10 MiB if 0).
+	MaxSize int64 `json:"max_size,omitempty"`
+
+	// MaxFiles is the number of rotated files kept (5 if 0).
+	MaxFiles int `json:"max_files,omitempty"`
+
+	// Writer also receives the timestamped output, from another
+	// goroutine. Writes block the copy, and errors stop them.
+	Writer io.Writer `json:"-"`
+}
+
+// validate checks that the log has a destination.
+func (cfg *SerialLogConfig) validate(i int) error {
+	switch {
+	case cfg.Path == "" && cfg.Writer == nil:
+		return fmt.Errorf("serial port %d: log has no path or writer", i)
+	case cfg.MaxSize < 0 || cfg.MaxFiles < 0:
+		return fmt.Errorf("serial port %d: negative log size or file count", i)
+	}
+	return nil
+}
+
+// serialLogFIFO returns the FIFO the serial port at index i is logged to.
+func serialLogFIFO(socketPath string, i int) string {
+	return strings.TrimSuffix(socketPath, ".sock") + "-serial" + strconv.Itoa(i) + ".fifo"
+}
+
+// logFilePath returns the path QEMU should open for writing a log, like
+// filePath but with the write-only descriptor QEMU asks for.
+func (b *VMBuilder) logFilePath(path string) string {
+	if !b.passFiles() {
+		return path
+	}
+
+	set := b.nextFdset()
+	fd := 3 + len(b.passedFiles)
+	b.passedFiles = append(b.passedFiles, passedFile{Path: path, Flag: os.O_WRONLY, Set: set})
+	b.args = append(b.args, "-add-fd", fmt.Sprintf("fd=%d,set=%d,opaque=wo:%s", fd, set, path))
+	return fmt.Sprintf("/dev/fdset/%d", set)
+}
+
+// serialLog reads the FIFO of a logged serial port.
+type serialLog struct {
+	cfg  *SerialLogConfig
+	fifo string
+	r    *os.File
+	// w keeps the FIFO open for writing until QEMU has opened it, so
+	// that reads do not end early
+	w *os.File
+}
+
+// serialLogs are the logs of a VM being started.
+type serialLogs []*serialLog
+
+// openSerialLogs creates the FIFOs of the logged serial ports and opens
+// them, so that QEMU, and OpenPassedFiles for a chroot, do not block
+// opening them.
+func openSerialLogs(cfg *VMConfig, socketPath string) (serialLogs, error) {
+	var logs serialLogs
+	for i, serial := range cfg.Serials {
+		if serial.Log == nil {
+			continue
+		}
+		log := &serialLog{cfg: serial.Log, fifo: serialLogFIFO(socketPath, i)}
+		logs = append(logs, log)
+
+		os.Remove(log.fifo)
+		if err := syscall.Mkfifo(log.fifo, 0600); err != nil {
+			logs.close()
+			return nil, fmt.Errorf("failed to create serial log FIFO: %w", &os.PathError{Op: "mkfifo", Path: log.fifo, Err: err})
+		}
+		var err error
+		if log.r, err = os.OpenFile(log.fifo, os.O_RDONLY|syscall.O_NONBLOCK, 0); err == nil {
+			log.w, err = os.OpenFile(log.fifo, os.O_WRONLY, 0)
+		}
+		if err != nil {
+			logs.close()
+			return nil, fmt.Errorf("failed to open serial log FIFO: %w", err)
+		}
+	}
+	return logs, nil
+}
+
+// start copies the output of the serial ports to their logs, once QEMU
+// has opened the FIFOs. Each copy ends when QEMU exits.
+func (logs serialLogs) start() {
+	for _, log := range logs {
+		os.Remove(log.fifo)
+		log.w.Close()
+		go log.copy()
+	}
+}
+
+// close releases the FIFOs of a VM that failed to start.
+func (logs serialLogs) close() {
+	for _, log := range logs {
+		os.Remove(log.fifo)
+		if log.r != nil {
+			log.r.Close()
+		}
+		if log.w != nil {
+			log.w.Close()
+		}
+	}
+}
+
+// copy writes the serial output to the log destinations until EOF.
+func (log *serialLog) copy() {
+	defer log.r.Close()
+
+	var dst []io.Writer
+	if log.cfg.Path != "" {
+		f := &rotatingFile{path: log.cfg.Path, maxSize: log.cfg.MaxSize, maxFiles: log.cfg.MaxFiles}
+		defer f.Close()
+		dst = append(dst, f)
+	}
+	if log.cfg.Writer != nil {
+		dst = append(dst, log.cfg.Writer)
+	}
+
+	w := &timestampWriter{w: &bestEffortWriter{dst: dst}, lineStart: true}
+	io.Copy(w, log.r)
+}
+
+// timestampWriter prefixes each line with the current time.
+type timestampWriter struct {
+	w         io.Writer
+	lineStart bool
+}
+
+func (t *timestampWriter) Write(p []byte) (int, error) {
+	stamp := time.Now().UTC().Format(serialLogTimeFormat) + " "
+
+	var buf []byte
+	for rest := p; len(rest) > 0; {
+		if t.lineStart {
+			buf = append(buf, stamp...)
+		}
+		line := rest
+		if n := bytes.IndexByte(rest, '\n'); n >= 0 {
+			line = rest[:n+1]
+		}
+		buf = append(buf, line...)
+		rest = rest[len(line):]
+		t.lineStart = line[len(line)-1] == '\n'
+	}
+	if _, err := t.w.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// bestEffortWriter writes to each destination until it fails, so that a
+// failing file or writer does not stop the others, nor the FIFO from
+// being drained.
+type bestEffortWriter struct {
+	dst []io.Writer
+}
+
+func (b *bestEffortWriter) Write(p []byte) (int, error) {
+	for n, w := range b.dst {
+		if w == nil {
+			continue
+		}
+		if _, err := w.Write(p); err != nil {
+			b.dst[n] = nil
+		}
+	}
+	return len(p), nil
+}
+
+// rotatingFile appends to a file, renaming it aside when it grows past
+// maxSize.
+type rotatingFile struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	f    *os.File
+	size int64
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	maxSize := r.maxSize
+	if maxSize <= 0 {
+		maxSize = defaultSerialLogMaxSize
+	}
+
+	if r.f == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	if r.size > 0 && r.size+int64(len(p)) > maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// open opens the log for appending.
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, info.Size()
+	return nil
+}
+
+// rotate shifts the rotated files by one, dropping the oldest, and starts
+// a new log.
+func (r *rotatingFile) rotate() error {
+	maxFiles := r.maxFiles
+	if maxFiles <= 0 {
+		maxFiles = defaultSerialLogMaxFiles
+	}
+
+	r.f.Close()
+	r.f = nil
+	for n := maxFiles - 1; n > 0; n-- {
+		os.Rename(r.path+"."+strconv.Itoa(n), r.path+"."+strconv.Itoa(n+1))
+	}
+	if err := os.Rename(r.path, r.path+".1"); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+func (r *rotatingFile) Close() error {
+	if r.f == nil {
+		return nil
+	}
+	return r.f.Close()
+}
@@ -0,0 +1,151 @@
+package qemuctl
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTimestampWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := &timestampWriter{w: &buf, lineStart: true}
+	io.WriteString(w, "SeaBIOS\r\nBooting ")
+	io.WriteString(w, "from disk\n\nLinux")
+
+	stamp := `\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{3}Z `
+	want := regexp.MustCompile(`^` + stamp + "SeaBIOS\r\n" + stamp + "Booting from disk\n" + stamp + "\n" + stamp + "Linux$")
+	if !want.MatchString(buf.String()) {
+		t.Errorf("unexpected log:\n%q", buf.String())
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "console.log")
+	os.WriteFile(path, []byte("previous run\n"), 0644)
+
+	f := &rotatingFile{path: path, maxSize: 20, maxFiles: 2}
+	for _, line := range []string{"line 1\n", "line 2\n", "line 3\n", "line 4\n", "line 5\n", "line 6\n"} {
+		if _, err := io.WriteString(f, line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.Close()
+
+	for name, want := range map[string]string{
+		"console.log":   "line 6\n",
+		"console.log.1": "line 4\nline 5\n",
+		"console.log.2": "line 2\nline 3\n",
+	} {
+		data, err := os.ReadFile(filepath.Join(filepath.Dir(path), name))
+		if err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v; want %q", name, data, err, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("more than 2 rotated files kept: %v", err)
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for the copying goroutine.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestSerialLogs(t *testing.T) {
+	dir := t.TempDir()
+	socketPath := filepath.Join(dir, "web.sock")
+	logPath := filepath.Join(dir, "web-console.log")
+	var out syncBuffer
+	cfg := &VMConfig{Serials: []*SerialConfig{
+		{Type: "pty"},
+		{Type: "pty", Log: &SerialLogConfig{Path: logPath, Writer: &out}},
+	}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	args := strings.Join(NewVMBuilder(cfg).Build("web", socketPath), " ")
+	fifo := filepath.Join(dir, "web-serial1.fifo")
+	if !strings.Contains(args, "-chardev pty,id=serial1,logfile="+fifo) || strings.Contains(args, "serial0,logfile") {
+		t.Errorf("args missing serial log:\n%s", args)
+	}
+
+	logs, err := openSerialLogs(cfg, socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// QEMU opens the FIFO, and the launcher starts reading once it is up
+	qemu, err := os.OpenFile(fifo, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs.start()
+	if _, err := os.Stat(fifo); !os.IsNotExist(err) {
+		t.Errorf("FIFO not removed: %v", err)
+	}
+	io.WriteString(qemu, "login: ")
+	qemu.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.HasSuffix(out.String(), "login: ") && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !strings.HasSuffix(out.String(), "Z login: ") {
+		t.Errorf("writer got %q", out.String())
+	}
+	for time.Now().Before(deadline) {
+		if data, _ := os.ReadFile(logPath); string(data) == out.String() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	data, _ := os.ReadFile(logPath)
+	t.Errorf("log file has %q, want %q", data, out.String())
+}
+
+func TestSerialLogChroot(t *testing.T) {
+	cfg := &VMConfig{
+		Chroot:  "/var/lib/qemu/web",
+		Serials: []*SerialConfig{{Type: "pty", Log: &SerialLogConfig{Path: "/var/log/web.log"}}},
+	}
+	b := NewVMBuilder(cfg)
+	args := strings.Join(b.Build("web", "/run/qemuctl/web.sock"), " ")
+	if !strings.Contains(args, "-add-fd fd=3,set=0,opaque=wo:/run/qemuctl/web-serial0.fifo") ||
+		!strings.Contains(args, "-chardev pty,id=serial0,logfile=/dev/fdset/0") {
+		t.Errorf("args missing passed serial log:\n%s", args)
+	}
+	if len(b.passedFiles) != 1 || b.passedFiles[0].Flag != os.O_WRONLY {
+		t.Errorf("passed files = %+v", b.passedFiles)
+	}
+}
+
+func TestSerialLogValidate(t *testing.T) {
+	for _, log := range []*SerialLogConfig{
+		{},
+		{Path: "/var/log/web.log", MaxSize: -1},
+	} {
+		cfg := &VMConfig{Serials: []*SerialConfig{{Type: "pty", Log: log}}}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Validate accepted %+v", log)
+		}
+	}
+}
@@ -47,7 +47,7 @@ func parseQMPStatus(status string) State {
 	switch status {
 	case "running":
 		return StateRunning
-	case "paused":
+	case "paused", "io-error", "finish-migrate", "postmigrate":
 		return StatePaused
 	case "shutdown":
 		return StateShutdown
@@ -57,7 +57,7 @@ func parseQMPStatus(status string) State {
 		return StatePrelaunch
 	case "inmigrate":
 		return StatePrelaunch
-	case "internal-error", "io-error":
+	case "internal-error", "guest-panicked":
 		return StateCrashed
 	default:
 		return StateUnknown
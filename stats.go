@@ -0,0 +1,168 @@
+package qemuctl
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Statistics targets, for QueryStats.
+const (
+	StatsTargetVM        = "vm"
+	StatsTargetVCPU      = "vcpu"
+	StatsTargetCryptodev = "cryptodev"
+)
+
+// StatsProviderKVM is the provider of KVM statistics, such as "exits" and
+// "halt_poll_success_ns" for vCPUs or "pages_4k" for the VM.
+const StatsProviderKVM = "kvm"
+
+// StatsFilter restricts QueryStats to a provider and, optionally, some of
+// its statistics.
+type StatsFilter struct {
+	Provider string   `json:"provider"`
+	Names    []string `json:"names,omitempty"`
+}
+
+// StatsResult holds the statistics of a provider for one object: the VM,
+// or the vCPU at QOMPath.
+type StatsResult struct {
+	Provider string `json:"provider"`
+	QOMPath  string `json:"qom-path,omitempty"`
+	Stats    []Stat `json:"stats"`
+}
+
+// Value returns the scalar value of the named statistic.
+func (r *StatsResult) Value(name string) (uint64, bool) {
+	for _, s := range r.Stats {
+		if s.Name == name && s.Histogram == nil {
+			return s.Value, true
+		}
+	}
+	return 0, false
+}
+
+// Stat is a statistic value. Scalars are in Value (booleans as 0 or 1),
+// histograms in Histogram; see StatsSchema for their meaning.
+type Stat struct {
+	Name      string
+	Value     uint64
+	Histogram []uint64
+}
+
+// UnmarshalJSON decodes the number, boolean or array value of a statistic.
+func (s *Stat) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Name  string          `json:"name"`
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	s.Name = raw.Name
+
+	var b bool
+	switch {
+	case json.Unmarshal(raw.Value, &s.Value) == nil:
+	case json.Unmarshal(raw.Value, &s.Histogram) == nil:
+	case json.Unmarshal(raw.Value, &b) == nil:
+		if b {
+			s.Value = 1
+		}
+	default:
+		return fmt.Errorf("unsupported value for statistic %q: %s", raw.Name, raw.Value)
+	}
+	return nil
+}
+
+// StatsSchema describes the statistics a provider offers for a target.
+type StatsSchema struct {
+	Provider string            `json:"provider"`
+	Target   string            `json:"target"`
+	Stats    []StatsSchemaItem `json:"stats"`
+}
+
+// StatsSchemaItem describes a statistic.
+type StatsSchemaItem struct {
+	Name string `json:"name"`
+
+	// Type is "cumulative", "instant", "peak", "linear-histogram" or
+	// "log2-histogram".
+	Type string `json:"type"`
+
+	// Unit is "bytes", "seconds", "cycles" or "boolean"; empty for plain
+	// counts.
+	Unit string `json:"unit,omitempty"`
+
+	// Values are in Unit times Base to the power of Exponent, such as
+	// nanoseconds for seconds with base 10 and exponent -9.
+	Base     int `json:"base,omitempty"`
+	Exponent int `json:"exponent"`
+
+	// BucketSize is the bucket width of linear histograms.
+	BucketSize int `json:"bucket-size,omitempty"`
+}
+
+// QueryStats returns the statistics of target (StatsTargetVM,
+// StatsTargetVCPU or StatsTargetCryptodev), one result per provider and
+// object; vCPU results are per vCPU. Without filters, all providers and
+// statistics are returned. Requires QEMU 7.1+; older releases fail with an
+// error wrapping errors.ErrUnsupported.
+func (i *Instance) QueryStats(target string, filters ...StatsFilter) ([]StatsResult, error) {
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return nil, ErrNotConnected
+	}
+
+	if err := i.requireCommand("query-stats"); err != nil {
+		return nil, err
+	}
+
+	args := map[string]any{"target": target}
+	if len(filters) > 0 {
+		args["providers"] = filters
+	}
+	result, err := qmp.Execute("query-stats", args)
+	if err != nil {
+		return nil, err
+	}
+
+	var stats []StatsResult
+	if err := unmarshalJSON(result, &stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// QueryStatsSchemas describes the statistics QueryStats returns, for all
+// providers or only provider if not empty.
+func (i *Instance) QueryStatsSchemas(provider string) ([]StatsSchema, error) {
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return nil, ErrNotConnected
+	}
+
+	if err := i.requireCommand("query-stats-schemas"); err != nil {
+		return nil, err
+	}
+
+	var args map[string]any
+	if provider != "" {
+		args = map[string]any{"provider": provider}
+	}
+	result, err := qmp.Execute("query-stats-schemas", args)
+	if err != nil {
+		return nil, err
+	}
+
+	var schemas []StatsSchema
+	if err := unmarshalJSON(result, &schemas); err != nil {
+		return nil, err
+	}
+	return schemas, nil
+}
@@ -0,0 +1,55 @@
+package qemuctl
+
+import "testing"
+
+func TestQueryStats(t *testing.T) {
+	fake := newFakeQMP(t)
+	fake.handle("query-stats", func(args map[string]any) (any, *qmpError) {
+		return []any{
+			map[string]any{"provider": "kvm", "qom-path": "/machine/unattached/device[0]", "stats": []any{
+				map[string]any{"name": "exits", "value": 1234},
+				map[string]any{"name": "halt_poll_success_hist", "value": []any{1, 2, 3}},
+				map[string]any{"name": "guest_mode", "value": true},
+			}},
+		}, nil
+	})
+	fake.handle("query-stats-schemas", func(args map[string]any) (any, *qmpError) {
+		return []any{map[string]any{"provider": "kvm", "target": "vcpu", "stats": []any{
+			map[string]any{"name": "halt_poll_success_ns", "type": "cumulative", "unit": "seconds", "base": 10, "exponent": -9},
+		}}}, nil
+	})
+	inst := fake.attach()
+
+	stats, err := inst.QueryStats(StatsTargetVCPU, StatsFilter{Provider: StatsProviderKVM, Names: []string{"exits"}})
+	if err != nil {
+		t.Fatalf("QueryStats failed: %v", err)
+	}
+	calls := fake.commands("query-stats")
+	providers, _ := calls[0]["providers"].([]any)
+	if calls[0]["target"] != "vcpu" || len(providers) != 1 {
+		t.Errorf("query-stats arguments = %v", calls[0])
+	}
+	if len(stats) != 1 || stats[0].QOMPath != "/machine/unattached/device[0]" {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if v, ok := stats[0].Value("exits"); !ok || v != 1234 {
+		t.Errorf("exits = %d, %v", v, ok)
+	}
+	if v, _ := stats[0].Value("guest_mode"); v != 1 {
+		t.Errorf("guest_mode = %d", v)
+	}
+	if h := stats[0].Stats[1].Histogram; len(h) != 3 || h[2] != 3 {
+		t.Errorf("histogram = %v", h)
+	}
+	if _, ok := stats[0].Value("halt_poll_success_hist"); ok {
+		t.Error("Value returned a histogram")
+	}
+
+	schemas, err := inst.QueryStatsSchemas(StatsProviderKVM)
+	if err != nil {
+		t.Fatalf("QueryStatsSchemas failed: %v", err)
+	}
+	if len(schemas) != 1 || schemas[0].Stats[0].Exponent != -9 || schemas[0].Stats[0].Unit != "seconds" {
+		t.Errorf("schemas = %+v", schemas)
+	}
+}
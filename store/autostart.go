@@ -0,0 +1,176 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/KarpelesLab/qemuctl"
+)
+
+// AutostartOptions configures RunAutostart.
+type AutostartOptions struct {
+	// Stagger is the delay between two consecutive VM starts, to avoid
+	// every guest booting at once.
+	Stagger time.Duration
+
+	// OnStart is called after each start attempt.
+	OnStart func(name string, inst *qemuctl.Instance, err error)
+}
+
+// RunAutostart starts all VMs flagged for autostart in the default store.
+// It is meant to run once at host boot, e.g. from a oneshot systemd unit
+// with KillMode=process so the VMs outlive it.
+func RunAutostart(ctx context.Context, opts *AutostartOptions) (map[string]*qemuctl.Instance, error) {
+	s, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return s.RunAutostart(ctx, opts)
+}
+
+// RunAutostart starts all VMs flagged for autostart, each after the VMs it
+// depends on. A VM whose dependencies failed to start is skipped. It
+// returns the started instances by name, and the errors of the VMs that
+// could not be started.
+func (s *Store) RunAutostart(ctx context.Context, opts *AutostartOptions) (map[string]*qemuctl.Instance, error) {
+	if opts == nil {
+		opts = &AutostartOptions{}
+	}
+
+	defs, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	order, errs := autostartOrder(defs)
+
+	started := make(map[string]*qemuctl.Instance)
+	failed := make(map[string]bool)
+	for name := range errs {
+		failed[name] = true
+	}
+
+	first := true
+	for _, def := range order {
+		if dep := failedDependency(def, failed); dep != "" {
+			failed[def.Name] = true
+			errs[def.Name] = fmt.Errorf("dependency %s was not started", dep)
+			continue
+		}
+
+		delay := time.Duration(def.StartDelay) * time.Second
+		if !first {
+			delay += opts.Stagger
+		}
+		first = false
+
+		if err := sleepContext(ctx, delay); err != nil {
+			errs[def.Name] = err
+			break
+		}
+
+		inst, err := s.StartDefined(ctx, def.Name)
+		if opts.OnStart != nil {
+			opts.OnStart(def.Name, inst, err)
+		}
+		if err != nil {
+			failed[def.Name] = true
+			errs[def.Name] = err
+			continue
+		}
+		started[def.Name] = inst
+	}
+
+	var all []error
+	for _, def := range defs {
+		if err := errs[def.Name]; err != nil {
+			all = append(all, fmt.Errorf("%s: %w", def.Name, err))
+		}
+	}
+	return started, errors.Join(all...)
+}
+
+// autostartOrder returns the VMs to start, dependencies first, and the
+// errors of VMs that cannot be ordered (unknown dependency or cycle).
+func autostartOrder(defs []*Definition) ([]*Definition, map[string]error) {
+	byName := make(map[string]*Definition, len(defs))
+	for _, def := range defs {
+		byName[def.Name] = def
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int)
+	errs := make(map[string]error)
+	var order []*Definition
+
+	var visit func(def *Definition) error
+	visit = func(def *Definition) error {
+		switch state[def.Name] {
+		case visiting:
+			return fmt.Errorf("dependency cycle through %s", def.Name)
+		case done:
+			return errs[def.Name]
+		}
+
+		state[def.Name] = visiting
+		var err error
+		for _, dep := range def.DependsOn {
+			depDef, ok := byName[dep]
+			if !ok {
+				err = fmt.Errorf("%w: dependency %s", ErrNotDefined, dep)
+				break
+			}
+			if err = visit(depDef); err != nil {
+				break
+			}
+		}
+		state[def.Name] = done
+
+		if err != nil {
+			errs[def.Name] = err
+			return err
+		}
+		order = append(order, def)
+		return nil
+	}
+
+	for _, def := range defs {
+		if def.Autostart {
+			visit(def)
+		}
+	}
+	return order, errs
+}
+
+// failedDependency returns the first dependency of def that failed.
+func failedDependency(def *Definition, failed map[string]bool) string {
+	for _, dep := range def.DependsOn {
+		if failed[dep] {
+			return dep
+		}
+	}
+	return ""
+}
+
+// sleepContext waits for d or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,53 @@
+package store
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAutostartOrder(t *testing.T) {
+	defs := []*Definition{
+		{Name: "app", Autostart: true, DependsOn: []string{"db", "cache"}},
+		{Name: "cache", Autostart: true},
+		{Name: "db", DependsOn: []string{"storage"}},
+		{Name: "idle"},
+		{Name: "loop-a", Autostart: true, DependsOn: []string{"loop-b"}},
+		{Name: "loop-b", DependsOn: []string{"loop-a"}},
+		{Name: "orphan", Autostart: true, DependsOn: []string{"missing"}},
+		{Name: "storage"},
+	}
+
+	order, errs := autostartOrder(defs)
+
+	var names []string
+	pos := make(map[string]int)
+	for idx, def := range order {
+		names = append(names, def.Name)
+		pos[def.Name] = idx
+	}
+
+	// Dependencies are started even without the autostart flag
+	for _, name := range []string{"app", "cache", "db", "storage"} {
+		if _, ok := pos[name]; !ok {
+			t.Errorf("%s missing from start order %v", name, names)
+		}
+	}
+	if pos["storage"] > pos["db"] || pos["db"] > pos["app"] || pos["cache"] > pos["app"] {
+		t.Errorf("dependencies not started first: %v", names)
+	}
+	if _, ok := pos["idle"]; ok {
+		t.Errorf("idle VM should not be started: %v", names)
+	}
+
+	if errs["loop-a"] == nil {
+		t.Error("expected cycle error for loop-a")
+	}
+	if !errors.Is(errs["orphan"], ErrNotDefined) {
+		t.Errorf("orphan error = %v, want ErrNotDefined", errs["orphan"])
+	}
+	for _, name := range []string{"loop-a", "loop-b", "orphan"} {
+		if _, ok := pos[name]; ok {
+			t.Errorf("%s should not be started: %v", name, names)
+		}
+	}
+}
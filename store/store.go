@@ -0,0 +1,304 @@
+// Package store persists VM definitions so they survive restarts of the
+// managing process and the host, similar to libvirt's persistent domains.
+//
+// Each definition is a JSON document holding a qemuctl.VMConfig, stored as
+// <name>.json in the store directory:
+//
+//	s, err := store.Default()
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	if err := s.Define("web", cfg); err != nil {
+//		log.Fatal(err)
+//	}
+//	inst, err := s.StartDefined(ctx, "web")
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/KarpelesLab/qemuctl"
+)
+
+// ErrNotDefined is returned when no definition exists with the given name.
+var ErrNotDefined = errors.New("VM not defined")
+
+// ErrInvalidName is returned for names that cannot be used as file names.
+var ErrInvalidName = errors.New("invalid VM name")
+
+// systemDir is where root stores definitions.
+const systemDir = "/etc/qemuctl/vms"
+
+// Definition is a persisted VM.
+type Definition struct {
+	// Name identifies the definition and names started instances.
+	Name string `json:"name"`
+
+	// Autostart marks the VM to be started by RunAutostart.
+	Autostart bool `json:"autostart,omitempty"`
+
+	// DependsOn lists VMs that RunAutostart must start before this one,
+	// whether or not they are flagged for autostart themselves.
+	DependsOn []string `json:"depends_on,omitempty"`
+
+	// StartDelay is how many seconds RunAutostart waits before starting
+	// this VM, e.g. to let a dependency finish booting.
+	StartDelay int `json:"start_delay,omitempty"`
+
+	// Config is the VM configuration.
+	Config *qemuctl.VMConfig `json:"config"`
+}
+
+// Store persists VM definitions in a directory.
+type Store struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// New returns a store that keeps definitions in dir. The directory is
+// created on first write.
+func New(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+// Default returns the store in /etc/qemuctl/vms when running as root, and
+// in the user's configuration directory ($XDG_CONFIG_HOME/qemuctl/vms)
+// otherwise.
+func Default() (*Store, error) {
+	if os.Geteuid() == 0 {
+		return New(systemDir), nil
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find configuration directory: %w", err)
+	}
+	return New(filepath.Join(dir, "qemuctl", "vms")), nil
+}
+
+// Dir returns the directory holding the definitions.
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// Define creates or replaces the definition for name. Replacing a
+// definition keeps its autostart settings.
+func (s *Store) Define(name string, cfg *qemuctl.VMConfig) error {
+	if cfg == nil {
+		return errors.New("VM config is nil")
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	def := &Definition{Name: name, Config: cfg}
+	if old, err := s.read(name); err == nil {
+		def.Autostart = old.Autostart
+		def.DependsOn = old.DependsOn
+		def.StartDelay = old.StartDelay
+	} else if !errors.Is(err, ErrNotDefined) {
+		return err
+	}
+	return s.write(def)
+}
+
+// Undefine removes the definition for name. Running instances are not
+// affected.
+func (s *Store) Undefine(name string) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.Remove(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%w: %s", ErrNotDefined, name)
+		}
+		return fmt.Errorf("failed to remove VM definition: %w", err)
+	}
+	return nil
+}
+
+// Get returns the definition for name.
+func (s *Store) Get(name string) (*Definition, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read(name)
+}
+
+// List returns all definitions, sorted by name.
+func (s *Store) List() ([]*Definition, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list VM definitions: %w", err)
+	}
+
+	var defs []*Definition
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || entry.IsDir() || validName(name) != nil {
+			continue
+		}
+		def, err := s.read(name)
+		if err != nil {
+			return nil, err
+		}
+		defs = append(defs, def)
+	}
+
+	sort.Slice(defs, func(a, b int) bool { return defs[a].Name < defs[b].Name })
+	return defs, nil
+}
+
+// SetAutostart sets whether the VM is started when the host boots.
+func (s *Store) SetAutostart(name string, autostart bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	def, err := s.read(name)
+	if err != nil {
+		return err
+	}
+	def.Autostart = autostart
+	return s.write(def)
+}
+
+// SetDependencies sets the VMs that must be started before name and the
+// delay in seconds before starting it at boot.
+func (s *Store) SetDependencies(name string, dependsOn []string, startDelay int) error {
+	for _, dep := range dependsOn {
+		if err := validName(dep); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	def, err := s.read(name)
+	if err != nil {
+		return err
+	}
+	def.DependsOn = dependsOn
+	def.StartDelay = startDelay
+	return s.write(def)
+}
+
+// StartDefined starts the VM defined as name. The instance is named after
+// the definition.
+func (s *Store) StartDefined(ctx context.Context, name string) (*qemuctl.Instance, error) {
+	def, err := s.Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := def.Config
+	cfg.Name = def.Name
+	return qemuctl.StartVMContext(ctx, cfg)
+}
+
+// read loads a definition. The caller holds s.mu.
+func (s *Store) read(name string) (*Definition, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s", ErrNotDefined, name)
+		}
+		return nil, fmt.Errorf("failed to read VM definition: %w", err)
+	}
+
+	def := &Definition{}
+	if err := json.Unmarshal(data, def); err != nil {
+		return nil, fmt.Errorf("failed to parse VM definition %s: %w", name, err)
+	}
+	// The file name is authoritative
+	def.Name = name
+	if def.Config == nil {
+		def.Config = &qemuctl.VMConfig{}
+	}
+	return def, nil
+}
+
+// write saves a definition atomically. The caller holds s.mu.
+func (s *Store) write(def *Definition) error {
+	path, err := s.path(def.Name)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(def, "", "\t")
+	if err != nil {
+		return fmt.Errorf("failed to marshal VM definition: %w", err)
+	}
+
+	// Definitions can hold secrets and display passwords, so only the
+	// owner may read them, as with libvirt's domain XML
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return fmt.Errorf("failed to create store directory: %w", err)
+	}
+
+	// Write to a temporary file, created 0600, first so a crash never
+	// leaves a partial definition behind
+	tmp, err := os.CreateTemp(s.dir, "."+def.Name+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write VM definition: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write VM definition: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write VM definition: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write VM definition: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write VM definition: %w", err)
+	}
+	return nil
+}
+
+// path returns the file holding the definition for name.
+func (s *Store) path(name string) (string, error) {
+	if err := validName(name); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.dir, name+".json"), nil
+}
+
+// validName checks that name is usable as a file name.
+func validName(name string) error {
+	if name == "" || strings.HasPrefix(name, ".") || strings.ContainsAny(name, "/\\\x00") {
+		return fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/KarpelesLab/qemuctl"
+)
+
+func TestStore(t *testing.T) {
+	s := New(filepath.Join(t.TempDir(), "vms"))
+
+	defs, err := s.List()
+	if err != nil || len(defs) != 0 {
+		t.Fatalf("List on empty store = %v, %v", defs, err)
+	}
+
+	cfg := qemuctl.DefaultVMConfig()
+	cfg.Memory.Size = 2048
+	if err := s.Define("web", cfg); err != nil {
+		t.Fatalf("Define failed: %v", err)
+	}
+	if err := s.Define("db", qemuctl.DefaultVMConfig()); err != nil {
+		t.Fatalf("Define failed: %v", err)
+	}
+
+	def, err := s.Get("web")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if def.Name != "web" || def.Config.Memory == nil || def.Config.Memory.Size != 2048 {
+		t.Errorf("unexpected definition: %+v", def)
+	}
+
+	// Redefining keeps the autostart flag
+	if err := s.SetAutostart("web", true); err != nil {
+		t.Fatalf("SetAutostart failed: %v", err)
+	}
+	cfg.Memory.Size = 4096
+	if err := s.Define("web", cfg); err != nil {
+		t.Fatalf("Define failed: %v", err)
+	}
+	if def, _ := s.Get("web"); !def.Autostart || def.Config.Memory.Size != 4096 {
+		t.Errorf("redefined VM = autostart %v, memory %d", def.Autostart, def.Config.Memory.Size)
+	}
+
+	defs, err = s.List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(defs) != 2 || defs[0].Name != "db" || defs[1].Name != "web" {
+		t.Errorf("List returned %d definitions: %+v", len(defs), defs)
+	}
+
+	if err := s.Undefine("db"); err != nil {
+		t.Fatalf("Undefine failed: %v", err)
+	}
+	if _, err := s.Get("db"); !errors.Is(err, ErrNotDefined) {
+		t.Errorf("Get after Undefine = %v, want ErrNotDefined", err)
+	}
+	if err := s.Undefine("db"); !errors.Is(err, ErrNotDefined) {
+		t.Errorf("second Undefine = %v, want ErrNotDefined", err)
+	}
+
+	// No temporary files are left behind
+	entries, _ := os.ReadDir(s.Dir())
+	if len(entries) != 1 || entries[0].Name() != "web.json" {
+		t.Errorf("unexpected store contents: %v", entries)
+	}
+
+	// Definitions may hold secrets, only the owner can read them
+	for path, want := range map[string]os.FileMode{s.Dir(): 0700, filepath.Join(s.Dir(), "web.json"): 0600} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != want {
+			t.Errorf("%s mode = %v, want %v", path, fi.Mode().Perm(), want)
+		}
+	}
+}
+
+func TestStoreInvalidName(t *testing.T) {
+	s := New(t.TempDir())
+	for _, name := range []string{"", ".hidden", "../escape", "a/b"} {
+		if err := s.Define(name, qemuctl.DefaultVMConfig()); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("Define(%q) = %v, want ErrInvalidName", name, err)
+		}
+	}
+}
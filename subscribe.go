@@ -0,0 +1,110 @@
+package qemuctl
+
+import "sync/atomic"
+
+// subscriptionBuffer is the channel capacity of each subscription.
+const subscriptionBuffer = 64
+
+// Subscription delivers the events matching a name filter on its own
+// channel. A subscriber that falls behind only loses its own events.
+type Subscription struct {
+	// C receives the matching events. It is closed by Unsubscribe or when
+	// the connection is closed.
+	C <-chan *Event
+
+	ch      chan *Event
+	names   map[string]bool
+	q       *QMP
+	dropped atomic.Uint64
+}
+
+// Subscribe returns a subscription to the named events, or to all events
+// if no name is given. Call Unsubscribe when done.
+func (q *QMP) Subscribe(names ...string) *Subscription {
+	ch := make(chan *Event, subscriptionBuffer)
+	sub := &Subscription{C: ch, ch: ch, q: q}
+	if len(names) > 0 {
+		sub.names = make(map[string]bool, len(names))
+		for _, name := range names {
+			sub.names[name] = true
+		}
+	}
+
+	q.subsMu.Lock()
+	defer q.subsMu.Unlock()
+	if q.subsClosed {
+		close(ch)
+		return sub
+	}
+	if q.subs == nil {
+		q.subs = make(map[*Subscription]struct{})
+	}
+	q.subs[sub] = struct{}{}
+	return sub
+}
+
+// Unsubscribe stops delivery and closes C. It is safe to call more than
+// once.
+func (s *Subscription) Unsubscribe() {
+	q := s.q
+	q.subsMu.Lock()
+	defer q.subsMu.Unlock()
+	if _, ok := q.subs[s]; ok {
+		delete(q.subs, s)
+		close(s.ch)
+	}
+}
+
+// Dropped returns the number of events discarded because C was full.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// dispatch delivers an event to the matching subscriptions.
+func (q *QMP) dispatch(event *Event) {
+	q.subsMu.Lock()
+	defer q.subsMu.Unlock()
+	for sub := range q.subs {
+		if sub.names != nil && !sub.names[event.Name] {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
+
+// closeSubscriptions closes all subscriptions when the connection ends.
+func (q *QMP) closeSubscriptions() {
+	q.subsMu.Lock()
+	defer q.subsMu.Unlock()
+	for sub := range q.subs {
+		close(sub.ch)
+	}
+	q.subs = nil
+	q.subsClosed = true
+}
+
+// Subscribe returns a subscription to the named events of the instance, or
+// to all events if no name is given:
+//
+//	sub, err := inst.Subscribe("BLOCK_JOB_COMPLETED", "BLOCK_JOB_ERROR")
+//	if err != nil {
+//		return err
+//	}
+//	defer sub.Unsubscribe()
+//	for event := range sub.C {
+//		...
+//	}
+func (i *Instance) Subscribe(names ...string) (*Subscription, error) {
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return nil, ErrNotConnected
+	}
+	return qmp.Subscribe(names...), nil
+}
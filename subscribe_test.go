@@ -0,0 +1,71 @@
+package qemuctl
+
+import (
+	"testing"
+	"time"
+)
+
+// nextEvent waits for an event on a subscription.
+func nextEvent(t *testing.T, sub *Subscription) *Event {
+	t.Helper()
+	select {
+	case event, ok := <-sub.C:
+		if !ok {
+			t.Fatal("subscription closed")
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for event")
+	}
+	return nil
+}
+
+func TestSubscribe(t *testing.T) {
+	fake := newFakeQMP(t)
+	inst := fake.attach()
+
+	jobs, err := inst.Subscribe("BLOCK_JOB_COMPLETED", "BLOCK_JOB_ERROR")
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	all, _ := inst.Subscribe()
+	slow, _ := inst.Subscribe("DEVICE_DELETED")
+
+	for n := 0; n < subscriptionBuffer+10; n++ {
+		fake.sendEvent("DEVICE_DELETED", map[string]any{"device": "dev0"})
+	}
+	fake.sendEvent("BLOCK_JOB_COMPLETED", map[string]any{"device": "job0"})
+
+	if event := nextEvent(t, jobs); event.Name != "BLOCK_JOB_COMPLETED" || event.Data["device"] != "job0" {
+		t.Errorf("jobs subscription got %s %v", event.Name, event.Data)
+	}
+
+	// The unfiltered subscriber is not held back by the slow one, though
+	// it is limited by its own buffer too
+	for n := 0; n < subscriptionBuffer; n++ {
+		nextEvent(t, all)
+	}
+	if all.Dropped() == 0 || slow.Dropped() == 0 {
+		t.Errorf("expected dropped events, got all=%d slow=%d", all.Dropped(), slow.Dropped())
+	}
+
+	jobs.Unsubscribe()
+	jobs.Unsubscribe()
+	if _, ok := <-jobs.C; ok {
+		t.Error("channel still open after Unsubscribe")
+	}
+
+	// Closing the connection closes the remaining subscriptions
+	inst.QMP().Close()
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-slow.C:
+			if !ok {
+				return
+			}
+		case <-deadline:
+			t.Fatal("subscription not closed with the connection")
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package qemuctl
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// defaultMemorySize is the guest memory the builder uses when none is
+// configured, in megabytes.
+const defaultMemorySize = 512
+
+// Resources is an amount of host resources.
+type Resources struct {
+	// Memory is in megabytes.
+	Memory int64 `json:"memory"`
+
+	// CPUs is the number of virtual CPUs.
+	CPUs int `json:"cpus"`
+
+	// Disk is in bytes.
+	Disk int64 `json:"disk"`
+}
+
+func (r Resources) add(o Resources) Resources {
+	return Resources{Memory: r.Memory + o.Memory, CPUs: r.CPUs + o.CPUs, Disk: r.Disk + o.Disk}
+}
+
+func (r Resources) sub(o Resources) Resources {
+	return Resources{Memory: r.Memory - o.Memory, CPUs: r.CPUs - o.CPUs, Disk: r.Disk - o.Disk}
+}
+
+// OvercommitRatios scale the host capacity a Supervisor may commit, e.g. a
+// CPU ratio of 4 allows four vCPUs per host CPU. Zero means 1 (no
+// overcommit).
+type OvercommitRatios struct {
+	Memory float64 `json:"memory,omitempty"`
+	CPU    float64 `json:"cpu,omitempty"`
+	Disk   float64 `json:"disk,omitempty"`
+}
+
+// InsufficientResourcesError is returned when a reservation does not fit in
+// the available capacity.
+type InsufficientResourcesError struct {
+	Resource  string
+	Requested int64
+	Available int64
+}
+
+func (e *InsufficientResourcesError) Error() string {
+	return fmt.Sprintf("insufficient %s: requested %d, available %d", e.Resource, e.Requested, e.Available)
+}
+
+// Supervisor manages instances on a host and accounts for the resources
+// committed to them, so a simple scheduler can decide whether a VM fits
+// without external state.
+type Supervisor struct {
+	capacity Resources
+	ratios   OvercommitRatios
+
+	mu           sync.Mutex
+	committed    Resources
+	reservations map[*Reservation]struct{}
+	instances    map[*Instance]*Reservation
+	sched        *scheduler
+	crash        *CrashCapture
+	restart      *RestartPolicy
+
+	// pending holds the restarts waiting for their backoff, by VM name
+	pending map[string]chan struct{}
+
+	// startVM starts QEMU, replaced in tests
+	startVM func(ctx context.Context, cfg *VMConfig) (*Instance, error)
+}
+
+// Reservation is an amount of resources committed on a Supervisor.
+type Reservation struct {
+	// Name identifies the reservation, typically the VM name.
+	Name string
+
+	// Resources is the reserved amount.
+	Resources Resources
+
+	// claims are the MAC addresses and host ports of the VM
+	claims []netClaim
+
+	// restart is the restart policy of the VM
+	restart *RestartPolicy
+
+	s *Supervisor
+}
+
+// NewSupervisor returns a supervisor for a host with the given capacity.
+func NewSupervisor(capacity Resources, ratios OvercommitRatios) *Supervisor {
+	return &Supervisor{
+		capacity:     capacity,
+		ratios:       ratios,
+		reservations: make(map[*Reservation]struct{}),
+		instances:    make(map[*Instance]*Reservation),
+		pending:      make(map[string]chan struct{}),
+		startVM:      StartVMContext,
+	}
+}
+
+// Capacity returns the total resources that may be committed, after
+// applying the overcommit ratios.
+func (s *Supervisor) Capacity() Resources {
+	return Resources{
+		Memory: int64(float64(s.capacity.Memory) * ratio(s.ratios.Memory)),
+		CPUs:   int(float64(s.capacity.CPUs) * ratio(s.ratios.CPU)),
+		Disk:   int64(float64(s.capacity.Disk) * ratio(s.ratios.Disk)),
+	}
+}
+
+// Committed returns the resources currently reserved.
+func (s *Supervisor) Committed() Resources {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.committed
+}
+
+// Available returns the resources that can still be reserved.
+func (s *Supervisor) Available() Resources {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Capacity().sub(s.committed)
+}
+
+// Reserve commits resources, or returns an InsufficientResourcesError if
+// they do not fit. A zero capacity is not enforced, so a supervisor can
+// account for disk without limiting it, for instance.
+func (s *Supervisor) Reserve(name string, r Resources) (*Reservation, error) {
+	return s.reserve(name, r, nil)
+}
+
+// reserve commits resources along with the network claims of a VM, which
+// must not conflict with the claims of other reservations.
+func (s *Supervisor) reserve(name string, r Resources, claims []netClaim) (*Reservation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkClaims(claims); err != nil {
+		return nil, err
+	}
+
+	capacity := s.Capacity()
+	avail := capacity.sub(s.committed)
+	switch {
+	case capacity.Memory > 0 && r.Memory > avail.Memory:
+		return nil, &InsufficientResourcesError{Resource: "memory", Requested: r.Memory, Available: avail.Memory}
+	case capacity.CPUs > 0 && r.CPUs > avail.CPUs:
+		return nil, &InsufficientResourcesError{Resource: "cpus", Requested: int64(r.CPUs), Available: int64(avail.CPUs)}
+	case capacity.Disk > 0 && r.Disk > avail.Disk:
+		return nil, &InsufficientResourcesError{Resource: "disk", Requested: r.Disk, Available: avail.Disk}
+	}
+
+	res := &Reservation{Name: name, Resources: r, claims: claims, s: s}
+	s.reservations[res] = struct{}{}
+	s.committed = s.committed.add(r)
+	return res, nil
+}
+
+// Release returns the reserved resources. It is safe to call more than
+// once.
+func (r *Reservation) Release() {
+	s := r.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.reservations[r]; ok {
+		delete(s.reservations, r)
+		s.committed = s.committed.sub(r.Resources)
+	}
+}
+
+// Reservations returns the current reservations.
+func (s *Supervisor) Reservations() []*Reservation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]*Reservation, 0, len(s.reservations))
+	for r := range s.reservations {
+		list = append(list, r)
+	}
+	return list
+}
+
+// Start reserves the resources of cfg (see VMResources) and starts the VM.
+// The reservation is released when the instance exits; the supervisor
+// waits for the process, so callers should not call Wait themselves.
+//
+// The MAC addresses and user-mode host forward ports of the VM are held
+// with the reservation. If another managed VM holds one of them, Start
+// returns a ConflictError without starting QEMU.
+//
+// The restart policy set with SetRestartPolicy applies to the VM until it
+// is stopped or ctx is canceled.
+func (s *Supervisor) Start(ctx context.Context, cfg *VMConfig) (*Instance, error) {
+	if cfg == nil {
+		cfg = DefaultVMConfig()
+	}
+
+	s.mu.Lock()
+	policy := s.restart
+	s.mu.Unlock()
+
+	inst, res, err := s.launch(ctx, cfg, policy)
+	if err != nil {
+		return nil, err
+	}
+	go s.supervise(ctx, cfg, inst, res)
+
+	return inst, nil
+}
+
+// launch reserves the resources of cfg and starts the VM.
+func (s *Supervisor) launch(ctx context.Context, cfg *VMConfig, policy *RestartPolicy) (*Instance, *Reservation, error) {
+	claims, err := vmNetClaims(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	res, err := s.reserve(cfg.Name, VMResources(ctx, cfg), claims)
+	if err != nil {
+		return nil, nil, err
+	}
+	res.restart = policy
+
+	inst, err := s.startVM(ctx, s.crashConfig(cfg))
+	if err != nil {
+		res.Release()
+		return nil, nil, err
+	}
+	s.track(inst, res)
+	return inst, res, nil
+}
+
+// exited waits for inst to exit, releases its reservation, and reports
+// whether it failed: QEMU exited with an error, or the guest panicked.
+func (s *Supervisor) exited(inst *Instance, res *Reservation) (bool, error) {
+	state, err := inst.wait()
+	res.Release()
+
+	s.mu.Lock()
+	delete(s.instances, inst)
+	s.mu.Unlock()
+
+	failed := err != nil || (state != nil && !state.Success()) || inst.LastPanic() != nil
+	return failed, err
+}
+
+// track registers an instance started by the supervisor.
+func (s *Supervisor) track(inst *Instance, res *Reservation) {
+	res.Name = inst.Name()
+	inst.supervisor = s
+	if qmp := inst.QMP(); qmp != nil {
+		qmp.addEventHook(func(event *Event) {
+			if event.Name == "GUEST_PANICKED" {
+				go s.panicked(inst, res, event)
+			}
+		})
+	}
+
+	s.mu.Lock()
+	s.instances[inst] = res
+	s.mu.Unlock()
+}
+
+// Instances returns the running instances started by the supervisor.
+func (s *Supervisor) Instances() []*Instance {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]*Instance, 0, len(s.instances))
+	for inst := range s.instances {
+		list = append(list, inst)
+	}
+	return list
+}
+
+// VMResources returns the resources a VM commits: its memory, vCPUs, and
+// the virtual size of its writable file-backed disks (the size on disk if
+// qemu-img is unavailable). Other disk backends are not counted.
+func VMResources(ctx context.Context, cfg *VMConfig) Resources {
+	r := Resources{Memory: defaultMemorySize, CPUs: 1}
+
+	if cfg.Memory != nil && cfg.Memory.Size > 0 {
+		r.Memory = int64(cfg.Memory.Size)
+	}
+
+	if cpu := cfg.CPU; cpu != nil {
+		n := max(cpu.Sockets, 1) * max(cpu.Cores, 1) * max(cpu.Threads, 1)
+		r.CPUs = n
+	}
+
+	for _, disk := range cfg.Disks {
+		file, ok := disk.Backend.(*FileDiskBackend)
+		if !ok || disk.ReadOnly {
+			continue
+		}
+		if info, err := ImageInfo(ctx, file.Path, nil); err == nil {
+			r.Disk += info.VirtualSize
+		} else if st, err := os.Stat(file.Path); err == nil {
+			r.Disk += st.Size()
+		}
+	}
+
+	return r
+}
+
+// ratio returns an overcommit ratio, treating zero as 1.
+func ratio(v float64) float64 {
+	if v <= 0 {
+		return 1
+	}
+	return v
+}
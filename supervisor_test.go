@@ -0,0 +1,125 @@
+package qemuctl
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSupervisorReserve(t *testing.T) {
+	s := NewSupervisor(Resources{Memory: 8192, CPUs: 4}, OvercommitRatios{CPU: 2})
+
+	if got := s.Capacity(); got != (Resources{Memory: 8192, CPUs: 8}) {
+		t.Errorf("Capacity() = %+v", got)
+	}
+
+	web, err := s.Reserve("web", Resources{Memory: 4096, CPUs: 6, Disk: 20 << 30})
+	if err != nil {
+		t.Fatalf("Reserve failed: %v", err)
+	}
+	if got := s.Available(); got != (Resources{Memory: 4096, CPUs: 2, Disk: -20 << 30}) {
+		t.Errorf("Available() = %+v", got)
+	}
+
+	_, err = s.Reserve("db", Resources{Memory: 2048, CPUs: 4})
+	var rerr *InsufficientResourcesError
+	if !errors.As(err, &rerr) || rerr.Resource != "cpus" || rerr.Available != 2 {
+		t.Errorf("Reserve over capacity = %v, want insufficient cpus", err)
+	}
+
+	web.Release()
+	web.Release()
+	if got := s.Committed(); got != (Resources{}) {
+		t.Errorf("Committed() after release = %+v", got)
+	}
+	if _, err := s.Reserve("db", Resources{Memory: 2048, CPUs: 4}); err != nil {
+		t.Errorf("Reserve after release failed: %v", err)
+	}
+	if n := len(s.Reservations()); n != 1 {
+		t.Errorf("%d reservations, want 1", n)
+	}
+}
+
+func TestVMResources(t *testing.T) {
+	disk := filepath.Join(t.TempDir(), "disk.raw")
+	if err := os.WriteFile(disk, make([]byte, 4096), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &VMConfig{
+		CPU:    &CPUConfig{Sockets: 2, Cores: 2},
+		Memory: &MemoryConfig{Size: 1024},
+		Disks: []*DiskConfig{
+			{Backend: &FileDiskBackend{Path: disk}},
+			{Backend: &FileDiskBackend{Path: disk}, ReadOnly: true},
+			{Backend: &NBDDiskBackend{Host: "nbd.example", Export: "data"}},
+		},
+	}
+
+	r := VMResources(context.Background(), cfg)
+	if r.Memory != 1024 || r.CPUs != 4 || r.Disk != 4096 {
+		t.Errorf("VMResources() = %+v", r)
+	}
+
+	if r := VMResources(context.Background(), &VMConfig{}); r != (Resources{Memory: defaultMemorySize, CPUs: 1}) {
+		t.Errorf("VMResources(empty) = %+v", r)
+	}
+}
+
+func TestSupervisorNetConflicts(t *testing.T) {
+	s := NewSupervisor(Resources{}, OvercommitRatios{})
+
+	web := &VMConfig{Networks: []*NetworkConfig{{
+		ID:      "net0",
+		Backend: &UserNetBackend{Hostfwd: []string{"tcp::2222-:22", "udp:127.0.0.1:5353-:53"}},
+		MACAddr: "52:54:00:AA:BB:01",
+	}}}
+	claims, err := vmNetClaims(web)
+	if err != nil {
+		t.Fatalf("vmNetClaims failed: %v", err)
+	}
+	res, err := s.reserve("web", Resources{}, claims)
+	if err != nil {
+		t.Fatalf("reserve failed: %v", err)
+	}
+
+	for _, tt := range []struct {
+		net  *NetworkConfig
+		want string
+	}{
+		{&NetworkConfig{ID: "net0", Backend: &TapNetBackend{}, MACAddr: "52:54:00:aa:bb:01"}, "MAC address 52:54:00:aa:bb:01 is already used by web"},
+		{&NetworkConfig{ID: "net0", Backend: &UserNetBackend{Hostfwd: []string{"tcp:127.0.0.1:2222-:22"}}}, "host port tcp/127.0.0.1:2222 is already used by web"},
+		{&NetworkConfig{ID: "net0", Backend: &UserNetBackend{Hostfwd: []string{":0.0.0.0:5353-:53", "udp::5353-:53"}}}, "host port udp/*:5353 is already used by web"},
+		{&NetworkConfig{ID: "net0", Backend: &UserNetBackend{Hostfwd: []string{"tcp::8080-:80", "tcp:10.0.0.1:8080-:8080"}}}, "host port tcp/10.0.0.1:8080 is used twice"},
+	} {
+		_, err := s.Start(context.Background(), &VMConfig{Name: "db", Networks: []*NetworkConfig{tt.net}})
+		var cerr *ConflictError
+		if !errors.As(err, &cerr) || err.Error() != tt.want {
+			t.Errorf("Start = %v, want %q", err, tt.want)
+		}
+	}
+
+	// Other addresses and protocols do not conflict
+	other := &VMConfig{Networks: []*NetworkConfig{{
+		ID:      "net0",
+		Backend: &UserNetBackend{Hostfwd: []string{"tcp:127.0.0.2:5353-:53", "udp:127.0.0.2:5353-:53"}},
+		MACAddr: "52:54:00:aa:bb:02",
+	}}}
+	claims, _ = vmNetClaims(other)
+	if _, err := s.reserve("db", Resources{}, claims); err != nil {
+		t.Errorf("reserve failed: %v", err)
+	}
+
+	// Claims are released with the reservation
+	res.Release()
+	claims, _ = vmNetClaims(web)
+	if _, err := s.reserve("web2", Resources{}, claims); err != nil {
+		t.Errorf("reserve after release failed: %v", err)
+	}
+
+	if _, err := vmNetClaims(&VMConfig{Networks: []*NetworkConfig{{ID: "net0", Backend: &UserNetBackend{Hostfwd: []string{"tcp:2222:22"}}}}}); err == nil {
+		t.Error("expected an error for an invalid hostfwd rule")
+	}
+}
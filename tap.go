@@ -0,0 +1,86 @@
+package qemuctl
+
+import (
+	"fmt"
+	"os"
+)
+
+// TapOptions configures a TAP interface created by CreateTap.
+type TapOptions struct {
+	// Name is the interface name. If empty, the kernel picks one, such as
+	// "tap0".
+	Name string
+
+	// Bridge is the bridge the interface is enslaved to, if any.
+	Bridge string
+
+	// Queues is the number of queues. More than one creates a multi-queue
+	// interface with a descriptor per queue, for a multi-queue virtio-net
+	// NIC.
+	Queues int
+
+	// MTU is the interface MTU. Zero keeps the kernel default.
+	MTU int
+}
+
+// Tap is a TAP interface created by CreateTap, with a descriptor open on
+// each of its queues. Setting it as TapNetBackend.Tap passes the
+// descriptors to QEMU, which then needs no privileges and no ifup scripts.
+// The interface is removed once QEMU and the Tap have closed all of them.
+type Tap struct {
+	// Name is the interface name.
+	Name string
+
+	// Files are the queue descriptors.
+	Files []*os.File
+}
+
+// Close closes the queue descriptors of the Tap. Once the VM has started,
+// QEMU holds its own copies, so the Tap can be closed.
+func (t *Tap) Close() error {
+	var err error
+	for _, f := range t.Files {
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// validate checks the options of a TAP interface to create.
+func (o TapOptions) validate() error {
+	if len(o.Name) >= 16 {
+		return fmt.Errorf("tap name %q longer than 15 characters", o.Name)
+	}
+	if o.Queues < 0 {
+		return fmt.Errorf("invalid tap queue count %d", o.Queues)
+	}
+	if o.MTU != 0 && (o.MTU < 68 || o.MTU > 65535) {
+		return fmt.Errorf("tap MTU %d out of range 68-65535", o.MTU)
+	}
+	return nil
+}
+
+// passTap registers the queue descriptors of a Tap backend for passing to
+// QEMU and returns a copy of the network using their descriptor numbers.
+func (b *VMBuilder) passTap(net *NetworkConfig) *NetworkConfig {
+	tap, ok := net.Backend.(*TapNetBackend)
+	if !ok || tap.Tap == nil {
+		return net
+	}
+
+	backend := *tap
+	backend.Tap = nil
+	backend.FD, backend.FDs = 0, nil
+	for _, f := range tap.Tap.Files {
+		backend.FDs = append(backend.FDs, 3+len(b.passedFiles))
+		b.passedFiles = append(b.passedFiles, passedFile{File: f, Set: -1})
+	}
+	if len(backend.FDs) == 1 {
+		backend.FD, backend.FDs = backend.FDs[0], nil
+	}
+
+	c := *net
+	c.Backend = &backend
+	return &c
+}
@@ -0,0 +1,168 @@
+//go:build linux
+
+package qemuctl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// iffMultiQueue is IFF_MULTI_QUEUE, which the syscall package does not
+// define.
+const iffMultiQueue = 0x0100
+
+// tunIfreq is struct ifreq as used by TUNSETIFF.
+type tunIfreq struct {
+	Name  [16]byte
+	Flags uint16
+	_     [22]byte
+}
+
+// CreateTap creates a TAP interface, enslaves it to a bridge if set, and
+// brings it up. It needs CAP_NET_ADMIN, unlike the QEMU process the
+// returned Tap is passed to. Closing the Tap before starting a VM with it
+// removes the interface.
+func CreateTap(opts TapOptions) (*Tap, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	queues := opts.Queues
+	if queues == 0 {
+		queues = 1
+	}
+
+	flags := uint16(syscall.IFF_TAP | syscall.IFF_NO_PI | syscall.IFF_VNET_HDR)
+	if queues > 1 {
+		flags |= iffMultiQueue
+	}
+
+	tap := &Tap{Name: opts.Name}
+	for q := 0; q < queues; q++ {
+		f, name, err := openTapQueue(tap.Name, flags)
+		if err != nil {
+			tap.Close()
+			return nil, err
+		}
+		tap.Name = name
+		tap.Files = append(tap.Files, f)
+	}
+
+	if err := setTapLink(tap.Name, opts.Bridge, opts.MTU); err != nil {
+		tap.Close()
+		return nil, err
+	}
+	return tap, nil
+}
+
+// openTapQueue opens a queue of the TAP interface name, creating it if it
+// does not exist, and returns the name of the interface.
+func openTapQueue(name string, flags uint16) (*os.File, string, error) {
+	fd, err := syscall.Open("/dev/net/tun", syscall.O_RDWR|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to open /dev/net/tun: %w", err)
+	}
+
+	req := tunIfreq{Flags: flags}
+	copy(req.Name[:], name)
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TUNSETIFF, uintptr(unsafe.Pointer(&req))); errno != 0 {
+		syscall.Close(fd)
+		return nil, "", fmt.Errorf("failed to create tap %s: %w", name, errno)
+	}
+
+	name = string(req.Name[:bytes.IndexByte(req.Name[:], 0)])
+	return os.NewFile(uintptr(fd), "tap:"+name), name, nil
+}
+
+// setTapLink brings the interface up over rtnetlink, with the bridge as
+// its master and the MTU if set.
+func setTapLink(name, bridge string, mtu int) error {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return fmt.Errorf("failed to find tap %s: %w", name, err)
+	}
+
+	var attrs []byte
+	if bridge != "" {
+		br, err := net.InterfaceByName(bridge)
+		if err != nil {
+			return fmt.Errorf("failed to find bridge %s: %w", bridge, err)
+		}
+		attrs = appendUint32Attr(attrs, syscall.IFLA_MASTER, uint32(br.Index))
+	}
+	if mtu > 0 {
+		attrs = appendUint32Attr(attrs, syscall.IFLA_MTU, uint32(mtu))
+	}
+
+	info := syscall.IfInfomsg{
+		Family: syscall.AF_UNSPEC,
+		Index:  int32(iface.Index),
+		Flags:  syscall.IFF_UP,
+		Change: syscall.IFF_UP,
+	}
+	if err := netlinkRequest(syscall.RTM_NEWLINK, info, attrs); err != nil {
+		return fmt.Errorf("failed to set up tap %s: %w", name, err)
+	}
+	return nil
+}
+
+// appendUint32Attr appends a netlink attribute holding a uint32.
+func appendUint32Attr(b []byte, typ uint16, v uint32) []byte {
+	b = binary.NativeEndian.AppendUint16(b, syscall.SizeofRtAttr+4)
+	b = binary.NativeEndian.AppendUint16(b, typ)
+	return binary.NativeEndian.AppendUint32(b, v)
+}
+
+// netlinkRequest sends a link request to the kernel and waits for its
+// acknowledgement.
+func netlinkRequest(typ uint16, info syscall.IfInfomsg, attrs []byte) error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	size := syscall.SizeofNlMsghdr + syscall.SizeofIfInfomsg + len(attrs)
+	hdr := syscall.NlMsghdr{
+		Len:   uint32(size),
+		Type:  typ,
+		Flags: syscall.NLM_F_REQUEST | syscall.NLM_F_ACK,
+		Seq:   1,
+	}
+	msg := make([]byte, 0, size)
+	msg = append(msg, unsafe.Slice((*byte)(unsafe.Pointer(&hdr)), syscall.SizeofNlMsghdr)...)
+	msg = append(msg, unsafe.Slice((*byte)(unsafe.Pointer(&info)), syscall.SizeofIfInfomsg)...)
+	msg = append(msg, attrs...)
+	if err := syscall.Sendto(fd, msg, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, os.Getpagesize())
+	for {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return err
+		}
+		for _, m := range msgs {
+			if m.Header.Seq != hdr.Seq || m.Header.Type != syscall.NLMSG_ERROR || len(m.Data) < 4 {
+				continue
+			}
+			// The error is a negated errno, zero for the acknowledgement
+			if errno := int32(binary.NativeEndian.Uint32(m.Data)); errno != 0 {
+				return syscall.Errno(-errno)
+			}
+			return nil
+		}
+	}
+}
@@ -0,0 +1,40 @@
+//go:build linux
+
+package qemuctl
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+)
+
+func TestCreateTap(t *testing.T) {
+	tap, err := CreateTap(TapOptions{Name: "qemuctltest0", Queues: 2, MTU: 9000})
+	if errors.Is(err, os.ErrPermission) || errors.Is(err, os.ErrNotExist) {
+		t.Skipf("cannot create tap interfaces: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("CreateTap failed: %v", err)
+	}
+	if tap.Name != "qemuctltest0" || len(tap.Files) != 2 {
+		t.Errorf("tap = %s with %d queues", tap.Name, len(tap.Files))
+	}
+
+	iface, err := net.InterfaceByName(tap.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if iface.MTU != 9000 || iface.Flags&net.FlagUp == 0 {
+		t.Errorf("interface = %+v, want up with MTU 9000", iface)
+	}
+
+	tap.Close()
+	if _, err := net.InterfaceByName(tap.Name); err == nil {
+		t.Error("interface still exists after Close")
+	}
+
+	if _, err := CreateTap(TapOptions{Bridge: "qemuctl-nobr"}); err == nil {
+		t.Error("CreateTap succeeded with a missing bridge")
+	}
+}
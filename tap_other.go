@@ -0,0 +1,10 @@
+//go:build !linux
+
+package qemuctl
+
+import "errors"
+
+// CreateTap is only supported on Linux.
+func CreateTap(opts TapOptions) (*Tap, error) {
+	return nil, errors.New("creating tap interfaces is only supported on Linux")
+}
@@ -0,0 +1,67 @@
+package qemuctl
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestTapNetBackendFDs(t *testing.T) {
+	var files []*os.File
+	for n := 0; n < 2; n++ {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.Close()
+		files = append(files, r)
+	}
+	tap := &Tap{Name: "tap7", Files: files}
+	defer tap.Close()
+
+	cfg := DefaultVMConfig()
+	cfg.Networks = []*NetworkConfig{{ID: "net0", Backend: &TapNetBackend{Tap: tap, VHost: true}}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	b := NewVMBuilder(cfg)
+	argsStr := strings.Join(b.Build("vm", "/run/qemu/vm.sock"), " ")
+	for _, want := range []string{
+		"-netdev tap,id=net0,vhost=on,fds=3:4",
+		"netdev=net0,id=net0-device,mq=on,vectors=6",
+	} {
+		if !strings.Contains(argsStr, want) {
+			t.Errorf("expected %q in: %s", want, argsStr)
+		}
+	}
+
+	passed, err := b.OpenPassedFiles()
+	if err != nil {
+		t.Fatalf("OpenPassedFiles failed: %v", err)
+	}
+	if len(passed) != 2 {
+		t.Fatalf("passed %d files, want 2", len(passed))
+	}
+	for n, f := range passed {
+		if f.Fd() == files[n].Fd() {
+			t.Errorf("queue %d passed without being duplicated", n)
+		}
+		f.Close()
+	}
+	if cfg.Networks[0].Backend.(*TapNetBackend).FDs != nil {
+		t.Error("Build modified the configuration")
+	}
+
+	for _, backend := range []*TapNetBackend{
+		{Tap: tap, Bridge: "br0"},
+		{Tap: tap, FD: 3},
+		{FDs: []int{3, 4}, Queues: 2},
+		{Tap: &Tap{Name: "tap8"}},
+	} {
+		cfg.Networks[0].Backend = backend
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Validate accepted %+v", backend)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package qemuctl
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+)
+
+// tdvfSignature starts the TDVF metadata that TDX builds of OVMF carry to
+// describe their memory layout to the TDX module.
+var tdvfSignature = []byte("TDVF")
+
+// checkTDX checks the configuration of a TDX guest.
+func (cfg *VMConfig) checkTDX() error {
+	tdx := cfg.TDX
+	switch {
+	case cfg.Arch != "" && cfg.Arch != "amd64":
+		return fmt.Errorf("TDX guests must be amd64, not %s", cfg.Arch)
+	case cfg.Machine != nil && cfg.Machine.Type != "" && !isQ35(cfg.Machine.Type):
+		return fmt.Errorf("TDX guests need a q35 machine, not %s", cfg.Machine.Type)
+	case cfg.Machine != nil && cfg.Machine.Accel != "kvm":
+		return fmt.Errorf("TDX guests need the kvm accelerator")
+	case cfg.EFI != nil && cfg.EFI.Code != "":
+		return fmt.Errorf("TDX guests cannot use pflash EFI firmware; set TDX.Firmware instead")
+	case tdx.Firmware == "":
+		return fmt.Errorf("TDX guests need a TDX-enabled firmware")
+	case cfg.Boot != nil && cfg.Boot.Firmware != "":
+		return fmt.Errorf("TDX guests load TDX.Firmware; Boot.Firmware must not be set")
+	}
+	return checkTDXFirmware(tdx.Firmware)
+}
+
+// checkTDXFirmware checks that firmware carries TDVF metadata. Firmware
+// that cannot be read is left for QEMU to report.
+func checkTDXFirmware(firmware string) error {
+	data, err := os.ReadFile(firmware)
+	if err != nil {
+		return nil
+	}
+	if !bytes.Contains(data, tdvfSignature) {
+		return fmt.Errorf("firmware %s is not TDX-enabled (no TDVF metadata); use a TDX build of OVMF such as OVMF.inteltdx.fd", firmware)
+	}
+	return nil
+}
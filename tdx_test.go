@@ -0,0 +1,58 @@
+package qemuctl
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVMBuilderWithTDX(t *testing.T) {
+	firmware := filepath.Join(t.TempDir(), "OVMF.inteltdx.fd")
+	os.WriteFile(firmware, []byte("\x00\x00TDVF\x00\x00"), 0o644)
+
+	cfg := &VMConfig{
+		TDX: &TDXConfig{
+			Firmware:              firmware,
+			QuoteGenerationSocket: "/var/run/tdx-qgs/qgs.socket",
+			SeptVEDisable:         true,
+		},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	argsStr := strings.Join(NewVMBuilder(cfg).Build("test-vm", "/tmp/test.sock"), " ")
+	for _, want := range []string{
+		"-machine q35,accel=kvm,confidential-guest-support=tdx0,kernel-irqchip=split",
+		"-object tdx-guest,id=tdx0,quote-generation-socket.type=unix,quote-generation-socket.path=/var/run/tdx-qgs/qgs.socket,sept-ve-disable=on",
+		"-bios " + firmware,
+	} {
+		if !strings.Contains(argsStr, want) {
+			t.Errorf("expected %q, got: %s", want, argsStr)
+		}
+	}
+
+	cfg.Machine = &MachineConfig{Type: "pc-q35-9.2", Accel: "kvm"}
+	if argsStr := strings.Join(NewVMBuilder(cfg).Build("test-vm", "/tmp/test.sock"), " "); !strings.Contains(argsStr, "-machine pc-q35-9.2,accel=kvm,confidential-guest-support=tdx0") {
+		t.Errorf("expected confidential machine options, got: %s", argsStr)
+	}
+}
+
+func TestValidateTDX(t *testing.T) {
+	plain := filepath.Join(t.TempDir(), "OVMF_CODE.fd")
+	os.WriteFile(plain, []byte("plain firmware"), 0o644)
+
+	for _, cfg := range []*VMConfig{
+		{Arch: "arm64", TDX: &TDXConfig{Firmware: "/fw"}},
+		{Machine: &MachineConfig{Type: "pc", Accel: "kvm"}, TDX: &TDXConfig{Firmware: "/fw"}},
+		{Machine: &MachineConfig{Type: "q35", Accel: "tcg"}, TDX: &TDXConfig{Firmware: "/fw"}},
+		{EFI: &EFIConfig{Code: "/code.fd"}, TDX: &TDXConfig{Firmware: "/fw"}},
+		{TDX: &TDXConfig{}},
+		{TDX: &TDXConfig{Firmware: plain}},
+	} {
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Validate accepted %+v", cfg)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package qemuctl
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// swtpmSocketPath returns the swtpm control socket path for the instance
+// with QMP socket socketPath.
+func swtpmSocketPath(socketPath string) string {
+	return strings.TrimSuffix(socketPath, ".sock") + "-swtpm.sock"
+}
+
+// swtpmArgs returns the swtpm arguments. swtpm terminates by itself once
+// QEMU closes the control connection.
+func swtpmArgs(cfg *TPMConfig, socketPath string) []string {
+	return []string{
+		"socket",
+		"--tpm2",
+		"--tpmstate", "dir=" + cfg.StateDir + ",mode=0600",
+		"--ctrl", "type=unixio,path=" + socketPath + ",mode=0600",
+		"--terminate",
+	}
+}
+
+// startSwtpm starts swtpm for cfg and waits for its control socket.
+func startSwtpm(ctx context.Context, cfg *TPMConfig, socketPath string) (*helperProcess, error) {
+	if err := os.MkdirAll(cfg.StateDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create TPM state directory: %w", err)
+	}
+	path := cfg.SwtpmPath
+	if path == "" {
+		path = "swtpm"
+	}
+	return startHelper(ctx, "swtpm", exec.Command(path, swtpmArgs(cfg, socketPath)...), socketPath)
+}
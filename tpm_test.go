@@ -0,0 +1,74 @@
+package qemuctl
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVMBuilderWithTPM(t *testing.T) {
+	cfg := &VMConfig{
+		Machine: &MachineConfig{Type: "q35", Accel: "tcg"},
+		TPM:     &TPMConfig{},
+	}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate accepted a TPM without state directory")
+	}
+	cfg.TPM.StateDir = "/var/lib/qemu/test-vm/tpm"
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	argsStr := strings.Join(NewVMBuilder(cfg).Build("test-vm", "/run/qemu/test-vm.sock"), " ")
+	want := "-chardev socket,id=chrtpm,path=/run/qemu/test-vm-swtpm.sock -tpmdev emulator,id=tpm0,chardev=chrtpm -device tpm-tis,tpmdev=tpm0"
+	if !strings.Contains(argsStr, want) {
+		t.Errorf("expected TPM arguments, got: %s", argsStr)
+	}
+
+	cfg.Arch = "arm64"
+	cfg.Machine = &MachineConfig{Type: "virt"}
+	if argsStr := strings.Join(NewVMBuilder(cfg).Build("test-vm", "/run/qemu/test-vm.sock"), " "); !strings.Contains(argsStr, "-device tpm-tis-device,tpmdev=tpm0") {
+		t.Errorf("expected tpm-tis-device on arm64, got: %s", argsStr)
+	}
+}
+
+func TestStartSwtpm(t *testing.T) {
+	dir := t.TempDir()
+	socket := filepath.Join(dir, "vm-swtpm.sock")
+	argsFile := filepath.Join(dir, "args")
+	swtpm := filepath.Join(dir, "swtpm")
+	script := "#!/bin/sh\necho \"$@\" > " + argsFile + "\n: > " + socket + "\nexec sleep 60\n"
+	if err := os.WriteFile(swtpm, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &TPMConfig{StateDir: filepath.Join(dir, "state"), SwtpmPath: swtpm}
+	p, err := startSwtpm(context.Background(), cfg, socket)
+	if err != nil {
+		t.Fatalf("startSwtpm failed: %v", err)
+	}
+	if info, err := os.Stat(cfg.StateDir); err != nil || info.Mode().Perm() != 0o700 {
+		t.Errorf("state directory not created: %v", err)
+	}
+	args, _ := os.ReadFile(argsFile)
+	if !strings.Contains(string(args), "socket --tpm2 --tpmstate dir="+cfg.StateDir+",mode=0600 --ctrl type=unixio,path="+socket) {
+		t.Errorf("swtpm args = %s", args)
+	}
+
+	(&Instance{tpm: p}).cleanup()
+	select {
+	case <-p.done:
+	default:
+		t.Error("swtpm still running after cleanup")
+	}
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Error("swtpm socket not removed")
+	}
+
+	os.WriteFile(swtpm, []byte("#!/bin/sh\necho 'bad state' >&2\nexit 1\n"), 0o755)
+	if _, err := startSwtpm(context.Background(), cfg, socket); err == nil || !strings.Contains(err.Error(), "bad state") {
+		t.Errorf("startSwtpm = %v, want swtpm error", err)
+	}
+}
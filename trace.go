@@ -0,0 +1,93 @@
+package qemuctl
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+// Tracer starts spans around QMP commands and VM lifecycle operations, so
+// that their latency shows up in the traces of a larger control plane. It
+// mirrors the subset of the OpenTelemetry API qemuctl needs, without
+// depending on it; see the README for an adapter.
+type Tracer interface {
+	// Start starts a span as a child of the span in ctx, if any, and
+	// returns a context holding the new span.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is an operation traced by a Tracer.
+type Span interface {
+	SetAttribute(key, value string)
+
+	// End ends the span, which failed if err is not nil.
+	End(err error)
+}
+
+// Span attributes.
+const (
+	// AttrVMName is the name of the VM.
+	AttrVMName = "qemuctl.vm"
+
+	// AttrQMPCommand is the QMP command, or "batch" for ExecuteBatch.
+	AttrQMPCommand = "qmp.command"
+
+	// AttrQMPErrorClass is the class of a QMP error, such as
+	// "GenericError", or "Timeout" for commands that got no response.
+	AttrQMPErrorClass = "qmp.error_class"
+)
+
+// tracer is the Tracer set with SetTracer.
+var tracer atomic.Pointer[Tracer]
+
+// SetTracer installs t to trace QMP commands and lifecycle operations
+// (start, attach, stop) from then on, or disables tracing if t is nil.
+// Operations without a context are traced as root spans; use the Context
+// variants, such as QMP.ExecuteContext, to trace them end to end.
+func SetTracer(t Tracer) {
+	if t == nil {
+		tracer.Store(nil)
+		return
+	}
+	tracer.Store(&t)
+}
+
+// noopSpan is used when tracing is disabled.
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(string, string) {}
+func (noopSpan) End(error)                   {}
+
+// startSpan starts a span with attributes given as key and value pairs.
+func startSpan(ctx context.Context, name string, attrs ...string) (context.Context, Span) {
+	t := tracer.Load()
+	if t == nil {
+		return ctx, noopSpan{}
+	}
+	ctx, span := (*t).Start(ctx, name)
+	for n := 0; n+1 < len(attrs); n += 2 {
+		span.SetAttribute(attrs[n], attrs[n+1])
+	}
+	return ctx, span
+}
+
+// endInstanceSpan ends the span of an operation that returned inst.
+func endInstanceSpan(span Span, inst *Instance, err error) {
+	if inst != nil {
+		span.SetAttribute(AttrVMName, inst.Name())
+	}
+	endSpan(span, err)
+}
+
+// endSpan ends span, recording the QMP error class of err.
+func endSpan(span Span, err error) {
+	var qmpErr *QMPError
+	var timeoutErr *TimeoutError
+	switch {
+	case errors.As(err, &qmpErr):
+		span.SetAttribute(AttrQMPErrorClass, qmpErr.Class)
+	case errors.As(err, &timeoutErr):
+		span.SetAttribute(AttrQMPErrorClass, "Timeout")
+	}
+	span.End(err)
+}
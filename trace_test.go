@@ -0,0 +1,122 @@
+package qemuctl
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingTracer records the spans it starts.
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+type recordedSpan struct {
+	t      *recordingTracer
+	name   string
+	parent *recordedSpan
+	attrs  map[string]string
+	ended  bool
+	err    error
+}
+
+type recordedSpanKey struct{}
+
+func (r *recordingTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	parent, _ := ctx.Value(recordedSpanKey{}).(*recordedSpan)
+	s := &recordedSpan{t: r, name: name, parent: parent, attrs: make(map[string]string)}
+
+	r.mu.Lock()
+	r.spans = append(r.spans, s)
+	r.mu.Unlock()
+	return context.WithValue(ctx, recordedSpanKey{}, s), s
+}
+
+func (s *recordedSpan) SetAttribute(key, value string) {
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+	s.attrs[key] = value
+}
+
+func (s *recordedSpan) End(err error) {
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+	s.ended, s.err = true, err
+}
+
+// ended returns a copy of the last ended span named name, or nil.
+func (r *recordingTracer) ended(name string) *recordedSpan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for n := len(r.spans) - 1; n >= 0; n-- {
+		if s := r.spans[n]; s.name == name && s.ended {
+			c := *s
+			return &c
+		}
+	}
+	return nil
+}
+
+// setRecordingTracer installs a recording tracer for the test.
+func setRecordingTracer(t *testing.T) *recordingTracer {
+	r := &recordingTracer{}
+	SetTracer(r)
+	t.Cleanup(func() { SetTracer(nil) })
+	return r
+}
+
+func TestTraceQMPCommands(t *testing.T) {
+	fake := newFakeQMP(t)
+	fake.HandleError("device_del", "DeviceNotFound", "Device 'x' not found")
+	tr := setRecordingTracer(t)
+
+	inst := fake.attach()
+	if s := tr.ended("qemuctl.attach"); s == nil || s.err != nil || s.attrs[AttrVMName] != inst.Name() {
+		t.Errorf("attach span = %+v", s)
+	}
+
+	ctx, parent := tr.Start(context.Background(), "parent")
+	if _, err := inst.QMP().ExecuteContext(ctx, "query-status", nil); err != nil {
+		t.Fatalf("ExecuteContext failed: %v", err)
+	}
+	s := tr.ended("qmp.query-status")
+	if s == nil || s.err != nil || s.attrs[AttrQMPCommand] != "query-status" || s.parent != parent {
+		t.Errorf("query-status span = %+v", s)
+	}
+
+	_, err := inst.QMP().Execute("device_del", map[string]any{"id": "x"})
+	s = tr.ended("qmp.device_del")
+	if err == nil || s == nil || s.err != err || s.attrs[AttrQMPErrorClass] != "DeviceNotFound" || s.parent != nil {
+		t.Errorf("device_del span = %+v, err %v", s, err)
+	}
+}
+
+func TestExecuteContextCanceled(t *testing.T) {
+	fake := newFakeQMP(t)
+	release := make(chan struct{})
+	fake.handle("query-balloon", func(map[string]any) (any, *qmpError) {
+		<-release
+		return map[string]any{}, nil
+	})
+	defer close(release)
+	inst := fake.attach()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, err := inst.QMP().ExecuteContext(ctx, "query-balloon", nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("ExecuteContext = %v, want context.Canceled", err)
+	}
+}
+
+func TestTraceDisabled(t *testing.T) {
+	SetTracer(nil)
+	ctx := context.Background()
+	got, span := startSpan(ctx, "op", AttrVMName, "vm")
+	if got != ctx {
+		t.Error("startSpan changed the context without a tracer")
+	}
+	endSpan(span, nil)
+}
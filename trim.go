@@ -0,0 +1,121 @@
+package qemuctl
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// fstrimTimeout bounds guest-fstrim when the context has no deadline;
+// trimming large filesystems takes longer than other agent calls.
+const fstrimTimeout = 10 * time.Minute
+
+// FstrimResult is the outcome of guest-fstrim for one guest mount point.
+type FstrimResult struct {
+	Path string `json:"path"`
+
+	// Trimmed is the number of bytes trimmed, if the guest reports it.
+	Trimmed int64 `json:"trimmed,omitempty"`
+
+	// Error is set if the mount point could not be trimmed.
+	Error string `json:"error,omitempty"`
+}
+
+// Fstrim discards unused blocks of all mounted guest filesystems, skipping
+// free ranges smaller than minimum bytes (0 for all of them).
+func (g *GuestAgent) Fstrim(ctx context.Context, minimum int64) ([]FstrimResult, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, fstrimTimeout)
+		defer cancel()
+	}
+
+	var args map[string]any
+	if minimum > 0 {
+		args = map[string]any{"minimum": minimum}
+	}
+	result, err := g.Execute(ctx, "guest-fstrim", args)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp struct {
+		Paths []FstrimResult `json:"paths"`
+	}
+	if err := unmarshalJSON(result, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Paths, nil
+}
+
+// TrimReport is the outcome of Instance.TrimGuest.
+type TrimReport struct {
+	// Mounts holds the result for each guest mount point.
+	Mounts []FstrimResult
+
+	// Trimmed is the total number of bytes trimmed, as far as the guest
+	// reports it.
+	Trimmed int64
+
+	// Compaction lists the disks where trimming does not give space back
+	// to the host.
+	Compaction []CompactionHint
+}
+
+// CompactionHint tells how to reclaim the space the guest freed on a disk.
+type CompactionHint struct {
+	// Disk is the disk ID, Path its image file.
+	Disk string
+	Path string
+
+	// Advice describes what to do.
+	Advice string
+}
+
+// TrimGuest trims all guest filesystems through the guest agent
+// (guest-fstrim), so freed blocks are discarded down to thin-provisioned
+// storage. Discard requests only reach the image of disks configured with
+// Discard "unmap"; the report lists the other disks, whose images must be
+// compacted offline (see ConvertImage).
+func (i *Instance) TrimGuest(ctx context.Context) (*TrimReport, error) {
+	agent := i.GuestAgent()
+	if agent == nil {
+		return nil, errors.New("no guest agent channel configured")
+	}
+
+	mounts, err := agent.Fstrim(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+	report := &TrimReport{Mounts: mounts}
+	for _, m := range mounts {
+		report.Trimmed += m.Trimmed
+	}
+	report.Compaction = compactionHints(i.vmConfig)
+	return report, nil
+}
+
+// compactionHints returns hints for the disks that do not pass discard
+// requests to their image.
+func compactionHints(cfg *VMConfig) []CompactionHint {
+	if cfg == nil {
+		return nil
+	}
+
+	var hints []CompactionHint
+	for _, disk := range cfg.Disks {
+		if disk.Discard == "unmap" || disk.ReadOnly {
+			continue
+		}
+		file, ok := disk.Backend.(*FileDiskBackend)
+		if !ok {
+			continue
+		}
+		advice := "discard is not enabled: set Discard to \"unmap\", or compact the image offline with ConvertImage"
+		if file.Format == "qcow2" {
+			advice += " (qemu-img convert -O qcow2)"
+		}
+		hints = append(hints, CompactionHint{Disk: disk.ID, Path: file.Path, Advice: advice})
+	}
+	return hints
+}
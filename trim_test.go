@@ -0,0 +1,32 @@
+package qemuctl
+
+import (
+	"context"
+	"testing"
+)
+
+func TestTrimGuest(t *testing.T) {
+	cfg := DefaultVMConfig().WithGuestAgent(newFakeGuestAgent(t, nil))
+	cfg.Disks = []*DiskConfig{
+		{ID: "disk0", Backend: &FileDiskBackend{Path: "/vm/disk0.qcow2", Format: "qcow2"}, Discard: "unmap"},
+		{ID: "disk1", Backend: &FileDiskBackend{Path: "/vm/disk1.qcow2", Format: "qcow2"}},
+		{ID: "iso", Backend: &FileDiskBackend{Path: "/vm/tools.img"}, ReadOnly: true},
+	}
+	inst := &Instance{vmConfig: cfg}
+	defer inst.GuestAgent().Close()
+
+	report, err := inst.TrimGuest(context.Background())
+	if err != nil {
+		t.Fatalf("TrimGuest failed: %v", err)
+	}
+	if len(report.Mounts) != 2 || report.Mounts[1].Error == "" || report.Trimmed != 1<<20 {
+		t.Errorf("report = %+v", report)
+	}
+	if len(report.Compaction) != 1 || report.Compaction[0].Disk != "disk1" || report.Compaction[0].Path != "/vm/disk1.qcow2" {
+		t.Errorf("compaction hints = %+v", report.Compaction)
+	}
+
+	if _, err := (&Instance{}).TrimGuest(context.Background()); err == nil {
+		t.Error("TrimGuest succeeded without a guest agent")
+	}
+}
@@ -0,0 +1,119 @@
+package qemuctl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// USBHostDeviceConfig passes a USB device of the host through to the
+// guest, selected by vendor and product ID or by its position on the host
+// bus. QEMU needs write access to the device node under /dev/bus/usb.
+// Passed-through devices block live migration.
+type USBHostDeviceConfig struct {
+	// ID is the device ID, needed to detach the device at runtime.
+	ID string `json:"id,omitempty"`
+
+	// VendorID and ProductID select the device by its USB IDs, as listed
+	// by lsusb. The first matching device is used, and plugged again if it
+	// is unplugged and reconnected, e.g. by a firmware flash.
+	VendorID  uint16 `json:"vendor_id,omitempty"`
+	ProductID uint16 `json:"product_id,omitempty"`
+
+	// HostBus and HostAddr select the device by bus number and device
+	// address, to tell apart identical devices. The address changes each
+	// time the device is reconnected.
+	HostBus  int `json:"host_bus,omitempty"`
+	HostAddr int `json:"host_addr,omitempty"`
+}
+
+// validate checks that the device is selected unambiguously.
+func (cfg *USBHostDeviceConfig) validate() error {
+	byID := cfg.VendorID != 0 || cfg.ProductID != 0
+	byAddr := cfg.HostBus != 0 || cfg.HostAddr != 0
+	switch {
+	case !byID && !byAddr:
+		return fmt.Errorf("USB host device %s: no vendor and product ID or host address", cfg.ID)
+	case byID && (cfg.VendorID == 0 || cfg.ProductID == 0):
+		return fmt.Errorf("USB host device %s: vendor ID and product ID must be set together", cfg.ID)
+	case byAddr && (cfg.HostBus == 0 || cfg.HostAddr == 0):
+		return fmt.Errorf("USB host device %s: host bus and address must be set together", cfg.ID)
+	}
+	return nil
+}
+
+// properties returns the usb-host properties selecting the device.
+func (cfg *USBHostDeviceConfig) properties() map[string]any {
+	props := make(map[string]any)
+	if cfg.VendorID != 0 {
+		props["vendorid"] = cfg.VendorID
+		props["productid"] = cfg.ProductID
+	}
+	if cfg.HostBus != 0 {
+		props["hostbus"] = cfg.HostBus
+		props["hostaddr"] = cfg.HostAddr
+	}
+	return props
+}
+
+// usbHostDeviceOpts returns the -device options selecting the device.
+func usbHostDeviceOpts(cfg *USBHostDeviceConfig) string {
+	var opts string
+	if cfg.VendorID != 0 {
+		opts += fmt.Sprintf(",vendorid=0x%04x,productid=0x%04x", cfg.VendorID, cfg.ProductID)
+	}
+	if cfg.HostBus != 0 {
+		opts += ",hostbus=" + strconv.Itoa(cfg.HostBus) + ",hostaddr=" + strconv.Itoa(cfg.HostAddr)
+	}
+	return opts
+}
+
+// usbHostDeviceFromOpts rebuilds a USBHostDeviceConfig from its -device
+// options.
+func usbHostDeviceFromOpts(o *qemuOpts) *USBHostDeviceConfig {
+	cfg := &USBHostDeviceConfig{ID: o.get("id"), HostBus: o.int("hostbus"), HostAddr: o.int("hostaddr")}
+	vendor, _ := strconv.ParseUint(strings.TrimPrefix(o.get("vendorid"), "0x"), 16, 16)
+	product, _ := strconv.ParseUint(strings.TrimPrefix(o.get("productid"), "0x"), 16, 16)
+	cfg.VendorID, cfg.ProductID = uint16(vendor), uint16(product)
+	return cfg
+}
+
+// AttachUSBHostDevice hot-plugs a host USB device into the guest, on any
+// free port of its USB controllers. cfg.ID is required to detach it later.
+func (i *Instance) AttachUSBHostDevice(cfg *USBHostDeviceConfig) error {
+	if cfg.ID == "" {
+		return fmt.Errorf("USB host device without an ID")
+	}
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return ErrNotConnected
+	}
+
+	args := cfg.properties()
+	args["driver"] = "usb-host"
+	args["id"] = cfg.ID
+	_, err := qmp.Execute("device_add", args)
+	return err
+}
+
+// DetachUSBHostDevice unplugs the host USB device with ID id. USB devices
+// are removed at once, without waiting for the guest.
+func (i *Instance) DetachUSBHostDevice(id string) error {
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return ErrNotConnected
+	}
+
+	_, err := qmp.Execute("device_del", map[string]any{"id": id})
+	return err
+}
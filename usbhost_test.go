@@ -0,0 +1,87 @@
+package qemuctl
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestVMBuilderUSBHostDevices(t *testing.T) {
+	cfg := &VMConfig{USBHostDevices: []*USBHostDeviceConfig{
+		{ID: "dongle", VendorID: 0x0529, ProductID: 0x0001},
+		{ID: "flash", HostBus: 3, HostAddr: 12},
+	}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	args := NewVMBuilder(cfg).Build("test", "")
+	joined := strings.Join(args, " ")
+	for _, want := range []string{
+		"qemu-xhci,id=usb0",
+		"usb-host,id=dongle,bus=usb0.0,vendorid=0x0529,productid=0x0001",
+		"usb-host,id=flash,bus=usb0.0,hostbus=3,hostaddr=12",
+	} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("args missing %q:\n%s", want, joined)
+		}
+	}
+
+	parsed, err := ParseArgs(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed.USBHostDevices, cfg.USBHostDevices) {
+		t.Errorf("parsed USB host devices = %+v, want %+v", parsed.USBHostDevices, cfg.USBHostDevices)
+	}
+	if len(parsed.USBDevices) != 0 {
+		t.Errorf("USB host devices parsed as USB devices: %+v", parsed.USBDevices)
+	}
+
+	for _, bad := range []*USBHostDeviceConfig{
+		{ID: "none"},
+		{ID: "half", VendorID: 0x0529},
+		{ID: "bus", HostBus: 3},
+	} {
+		if err := bad.validate(); err == nil {
+			t.Errorf("validate() accepted %+v", bad)
+		}
+	}
+
+	cfg.OnlyMigratable = true
+	var notMigratable *NotMigratableError
+	if err := cfg.Validate(); !errors.As(err, &notMigratable) {
+		t.Errorf("Validate() with OnlyMigratable = %v, want NotMigratableError", err)
+	}
+}
+
+func TestAttachUSBHostDevice(t *testing.T) {
+	fake := newFakeQMP(t)
+	fake.handle("device_add", func(map[string]any) (any, *qmpError) {
+		return map[string]any{}, nil
+	})
+	fake.handle("device_del", func(map[string]any) (any, *qmpError) {
+		return map[string]any{}, nil
+	})
+	inst := fake.attach()
+
+	if err := inst.AttachUSBHostDevice(&USBHostDeviceConfig{VendorID: 0x0529, ProductID: 0x0001}); err == nil {
+		t.Error("AttachUSBHostDevice() accepted a device without an ID")
+	}
+	if err := inst.AttachUSBHostDevice(&USBHostDeviceConfig{ID: "dongle", VendorID: 0x0529, ProductID: 0x0001}); err != nil {
+		t.Fatal(err)
+	}
+	if err := inst.DetachUSBHostDevice("dongle"); err != nil {
+		t.Fatal(err)
+	}
+
+	added := fake.commands("device_add")
+	want := map[string]any{"driver": "usb-host", "id": "dongle", "vendorid": float64(0x0529), "productid": float64(1)}
+	if len(added) != 1 || !reflect.DeepEqual(added[0], want) {
+		t.Errorf("device_add arguments = %v, want %v", added, want)
+	}
+	if deleted := fake.commands("device_del"); len(deleted) != 1 || deleted[0]["id"] != "dongle" {
+		t.Errorf("device_del arguments = %v", deleted)
+	}
+}
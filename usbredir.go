@@ -0,0 +1,56 @@
+package qemuctl
+
+import "fmt"
+
+// defaultUSBRedirChannels is the number of redirection channels when
+// USBRedirConfig.Channels is zero, as virt-manager sets up.
+const defaultUSBRedirChannels = 2
+
+// USBRedirConfig lets SPICE clients redirect their USB devices into the
+// guest. Each channel is a spicevmc chardev with a usb-redir device on the
+// USB controller, and carries one client device at a time. It needs a
+// SPICE display.
+type USBRedirConfig struct {
+	// Channels is the number of devices that can be redirected at once,
+	// 2 by default.
+	Channels int `json:"channels,omitempty"`
+}
+
+// channels returns the number of channels to create.
+func (cfg *USBRedirConfig) channels() int {
+	if cfg.Channels == 0 {
+		return defaultUSBRedirChannels
+	}
+	return cfg.Channels
+}
+
+// checkUSBRedir checks that USB redirection has a SPICE display to go
+// through.
+func (cfg *VMConfig) checkUSBRedir() error {
+	if cfg.USBRedir.Channels < 0 {
+		return fmt.Errorf("negative USB redirection channel count %d", cfg.USBRedir.Channels)
+	}
+	if cfg.Display == nil || (cfg.Display.Type != "spice" && cfg.Display.Spice == nil) {
+		return fmt.Errorf("USB redirection needs a SPICE display")
+	}
+	return nil
+}
+
+// buildUSBRedirArgs builds the chardevs and usb-redir devices of the USB
+// redirection channels.
+func buildUSBRedirArgs(cfg *USBRedirConfig) []string {
+	var args []string
+	for i := 0; i < cfg.channels(); i++ {
+		chardev := fmt.Sprintf("charredir%d", i)
+		args = append(args,
+			"-chardev", "spicevmc,id="+chardev+",name=usbredir",
+			"-device", fmt.Sprintf("usb-redir,chardev=%s,id=redir%d,bus=usb0.0", chardev, i))
+	}
+	return args
+}
+
+// isUSBRedirChardev reports whether ch is the chardev of a USB
+// redirection channel.
+func isUSBRedirChardev(ch *ChardevConfig) bool {
+	return ch != nil && ch.Backend == ChardevSpiceVMC && ch.Name == "usbredir"
+}
@@ -0,0 +1,72 @@
+package qemuctl
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVMBuilderUSBRedir(t *testing.T) {
+	cfg := &VMConfig{
+		Display:  &DisplayConfig{Type: "spice", Spice: &SpiceDisplayConfig{Port: 5930, DisableTicketing: true}},
+		USBRedir: &USBRedirConfig{Channels: 3},
+	}
+	cfg.WithSpiceAgent()
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	args := NewVMBuilder(cfg).Build("test", "")
+	joined := strings.Join(args, " ")
+	for _, want := range []string{
+		"qemu-xhci,id=usb0",
+		"-chardev spicevmc,id=charredir0,name=usbredir -device usb-redir,chardev=charredir0,id=redir0,bus=usb0.0",
+		"-chardev spicevmc,id=charredir2,name=usbredir -device usb-redir,chardev=charredir2,id=redir2,bus=usb0.0",
+	} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("args missing %q:\n%s", want, joined)
+		}
+	}
+	if strings.Contains(joined, "charredir3") {
+		t.Errorf("more than 3 channels:\n%s", joined)
+	}
+
+	parsed, err := ParseArgs(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.USBRedir == nil || parsed.USBRedir.Channels != 3 {
+		t.Errorf("parsed USB redirection = %+v, want 3 channels", parsed.USBRedir)
+	}
+	if len(parsed.USBDevices) != 0 {
+		t.Errorf("redirection channels parsed as USB devices: %+v", parsed.USBDevices)
+	}
+	// Only the SPICE agent chardev is left
+	if len(parsed.Chardevs) != 1 || parsed.Chardevs[0].Name != "vdagent" {
+		t.Errorf("parsed chardevs = %+v", parsed.Chardevs)
+	}
+
+	out, err := cfg.ToLibvirtXML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(out, `<redirdev bus="usb" type="spicevmc">`); n != 3 {
+		t.Errorf("libvirt XML has %d redirdevs, want 3:\n%s", n, out)
+	}
+	back, err := ParseLibvirtXML([]byte(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if back.USBRedir == nil || back.USBRedir.Channels != 3 {
+		t.Errorf("libvirt USB redirection = %+v, want 3 channels", back.USBRedir)
+	}
+
+	// Channels default to 2 and need SPICE
+	cfg.USBRedir.Channels = 0
+	if joined := strings.Join(NewVMBuilder(cfg).Build("test", ""), " "); !strings.Contains(joined, "redir1") || strings.Contains(joined, "redir2") {
+		t.Errorf("default channels:\n%s", joined)
+	}
+	cfg.Display = &DisplayConfig{Type: "vnc", VNC: &VNCConfig{Listen: "none"}}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate() accepted USB redirection without SPICE")
+	}
+}
@@ -56,6 +56,92 @@ func (i *Instance) QueryBlockDevices() ([]BlockInfo, error) {
 	return blocks, nil
 }
 
+// BlockNode describes a node of the block graph.
+type BlockNode struct {
+	NodeName         string `json:"node-name"`
+	Drv              string `json:"drv"`
+	File             string `json:"file"`
+	Ro               bool   `json:"ro"`
+	BackingFile      string `json:"backing_file,omitempty"`
+	BackingFileDepth int    `json:"backing_file_depth"`
+	Encrypted        bool   `json:"encrypted"`
+	Image            *struct {
+		Filename    string `json:"filename"`
+		Format      string `json:"format"`
+		VirtualSize int64  `json:"virtual-size"`
+		ActualSize  int64  `json:"actual-size,omitempty"`
+	} `json:"image,omitempty"`
+	DirtyBitmaps []DirtyBitmap `json:"dirty-bitmaps,omitempty"`
+}
+
+// QueryNamedBlockNodes returns all nodes of the block graph. With flat set,
+// image information omits the backing chain, which keeps the response small
+// for deep chains.
+func (i *Instance) QueryNamedBlockNodes(flat bool) ([]BlockNode, error) {
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return nil, ErrNotConnected
+	}
+
+	var args map[string]any
+	if flat {
+		args = map[string]any{"flat": true}
+	}
+
+	result, err := qmp.ExecuteWithTimeout("query-named-block-nodes", args, largeResponseTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []BlockNode
+	if err := unmarshalJSON(result, &nodes); err != nil {
+		return nil, err
+	}
+
+	return nodes, nil
+}
+
+// QMPSchemaEntry is an entity of the QMP schema, such as a command, event
+// or type. Which fields are set depends on MetaType.
+type QMPSchemaEntry struct {
+	Name     string   `json:"name"`
+	MetaType string   `json:"meta-type"`
+	ArgType  string   `json:"arg-type,omitempty"`
+	RetType  string   `json:"ret-type,omitempty"`
+	Values   []string `json:"values,omitempty"`
+	Features []string `json:"features,omitempty"`
+	Members  []struct {
+		Name string `json:"name"`
+		Type string `json:"type,omitempty"`
+	} `json:"members,omitempty"`
+}
+
+// QueryQMPSchema returns the QMP schema supported by the running QEMU.
+func (i *Instance) QueryQMPSchema() ([]QMPSchemaEntry, error) {
+	i.qmpMu.Lock()
+	qmp := i.qmp
+	i.qmpMu.Unlock()
+
+	if qmp == nil {
+		return nil, ErrNotConnected
+	}
+
+	result, err := qmp.ExecuteWithTimeout("query-qmp-schema", nil, largeResponseTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	var schema []QMPSchemaEntry
+	if err := unmarshalJSON(result, &schema); err != nil {
+		return nil, err
+	}
+
+	return schema, nil
+}
+
 // SetIOThrottle sets I/O throttling for a block device.
 func (i *Instance) SetIOThrottle(device string, bps, iops uint64) error {
 	i.qmpMu.Lock()
@@ -0,0 +1,102 @@
+package qemuctl
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// QemuVersion is a QEMU release version.
+type QemuVersion struct {
+	Major int `json:"major"`
+	Minor int `json:"minor"`
+	Micro int `json:"micro"`
+
+	// Package is the distribution build, e.g. "Debian 1:8.2.2+ds-0ubuntu1",
+	// as reported by QMP. It is empty for upstream builds.
+	Package string `json:"package,omitempty"`
+}
+
+// String returns the version as "major.minor.micro".
+func (v QemuVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Micro)
+}
+
+// IsZero reports whether the version is unknown.
+func (v QemuVersion) IsZero() bool {
+	return v == QemuVersion{}
+}
+
+// AtLeast reports whether v is major.minor or newer.
+func (v QemuVersion) AtLeast(major, minor int) bool {
+	if v.Major != major {
+		return v.Major > major
+	}
+	return v.Minor >= minor
+}
+
+// qemuVersionRe matches the version in "QEMU emulator version 8.2.0 (...)".
+var qemuVersionRe = regexp.MustCompile(`version (\d+)\.(\d+)(?:\.(\d+))?`)
+
+// ParseQemuVersion parses the output of "qemu-system-* -version".
+func ParseQemuVersion(s string) (QemuVersion, error) {
+	m := qemuVersionRe.FindStringSubmatch(s)
+	if m == nil {
+		return QemuVersion{}, fmt.Errorf("no QEMU version found in %q", s)
+	}
+
+	var v QemuVersion
+	v.Major, _ = strconv.Atoi(m[1])
+	v.Minor, _ = strconv.Atoi(m[2])
+	if m[3] != "" {
+		v.Micro, _ = strconv.Atoi(m[3])
+	}
+	return v, nil
+}
+
+// versionCacheEntry remembers the version of a binary until it changes.
+type versionCacheEntry struct {
+	modTime time.Time
+	version QemuVersion
+}
+
+var (
+	versionCache   = make(map[string]versionCacheEntry)
+	versionCacheMu sync.Mutex
+)
+
+// DetectQemuVersion runs the QEMU binary at qemuPath with -version and
+// returns its version. Results are cached until the binary changes.
+func DetectQemuVersion(qemuPath string) (QemuVersion, error) {
+	info, err := os.Stat(qemuPath)
+	if err != nil {
+		return QemuVersion{}, err
+	}
+
+	versionCacheMu.Lock()
+	entry, ok := versionCache[qemuPath]
+	versionCacheMu.Unlock()
+	if ok && entry.modTime.Equal(info.ModTime()) {
+		return entry.version, nil
+	}
+
+	out, err := exec.Command(qemuPath, "-version").Output()
+	if err != nil {
+		return QemuVersion{}, fmt.Errorf("failed to get QEMU version: %w", err)
+	}
+
+	v, err := ParseQemuVersion(string(out))
+	if err != nil {
+		return QemuVersion{}, err
+	}
+
+	versionCacheMu.Lock()
+	versionCache[qemuPath] = versionCacheEntry{modTime: info.ModTime(), version: v}
+	versionCacheMu.Unlock()
+
+	return v, nil
+}
@@ -0,0 +1,302 @@
+package qemuctl
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// websocketGUID is appended to the client key to compute the accept key
+// (RFC 6455, section 1.3).
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// websocketMaxFrame bounds the payload of frames from browsers, which only
+// send small keyboard and pointer messages.
+const websocketMaxFrame = 1 << 20
+
+// WebSocket opcodes.
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+// VNCProxy is an http.Handler bridging WebSocket connections from browser
+// VNC clients such as noVNC to VMs. Each connection names its VM with a
+// token query parameter, as in noVNC's "path=websockify?token=..."
+// setting, and is handed to QEMU with Instance.AddVNCClient, so the VMs
+// need a VNC display but no VNC port:
+//
+//	proxy := qemuctl.NewVNCProxy()
+//	proxy.AddToken(token, inst)
+//	http.Handle("/websockify", proxy)
+//
+// Tokens are the only access control, so they should be random and
+// short-lived.
+type VNCProxy struct {
+	// SkipAuth skips VNC authentication for proxied clients, for VMs with
+	// a VNC password that the proxy users are not given.
+	SkipAuth bool
+
+	// CheckOrigin decides whether to accept a connection from a page of
+	// another origin. If nil, all origins are accepted.
+	CheckOrigin func(r *http.Request) bool
+
+	mu     sync.Mutex
+	tokens map[string]*Instance
+
+	// addClient hands a connection to QEMU, replaced in tests
+	addClient func(inst *Instance, conn net.Conn, skipAuth bool) error
+}
+
+// NewVNCProxy returns a proxy without tokens.
+func NewVNCProxy() *VNCProxy {
+	return &VNCProxy{
+		tokens:    make(map[string]*Instance),
+		addClient: (*Instance).AddVNCClient,
+	}
+}
+
+// AddToken routes connections with token to inst.
+func (p *VNCProxy) AddToken(token string, inst *Instance) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.tokens[token] = inst
+}
+
+// RemoveToken stops accepting connections with token. Connections already
+// established stay open.
+func (p *VNCProxy) RemoveToken(token string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.tokens, token)
+}
+
+// ServeHTTP upgrades the request to a WebSocket and bridges it to the VNC
+// server of the VM its token names.
+func (p *VNCProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	inst := p.tokens[r.URL.Query().Get("token")]
+	p.mu.Unlock()
+	if inst == nil {
+		http.Error(w, "unknown token", http.StatusNotFound)
+		return
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || !headerHas(r.Header, "Connection", "upgrade") ||
+		!headerHas(r.Header, "Upgrade", "websocket") || key == "" {
+		http.Error(w, "WebSocket upgrade required", http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported WebSocket version", http.StatusUpgradeRequired)
+		return
+	}
+	if p.CheckOrigin != nil && !p.CheckOrigin(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	// QEMU takes one end of a socket pair and the proxy copies between
+	// the other end and the WebSocket
+	vnc, qemuEnd, err := socketPair()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = p.addClient(inst, qemuEnd, p.SkipAuth)
+	qemuEnd.Close()
+	if err != nil {
+		vnc.Close()
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		vnc.Close()
+		http.Error(w, "connection cannot be upgraded", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		vnc.Close()
+		return
+	}
+
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + websocketAccept(key) + "\r\n"
+	if headerHas(r.Header, "Sec-WebSocket-Protocol", "binary") {
+		resp += "Sec-WebSocket-Protocol: binary\r\n"
+	}
+	if _, err := rw.WriteString(resp + "\r\n"); err != nil || rw.Flush() != nil {
+		conn.Close()
+		vnc.Close()
+		return
+	}
+
+	ws := &wsConn{conn: conn, r: rw.Reader}
+	go func() {
+		ws.copyTo(vnc)
+		vnc.Close()
+	}()
+	ws.copyFrom(vnc)
+	conn.Close()
+}
+
+// headerHas reports whether the comma-separated header name contains
+// token, ignoring case.
+func headerHas(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// websocketAccept returns the Sec-WebSocket-Accept value for key.
+func websocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// wsConn is the server side of a WebSocket connection carrying binary
+// messages.
+type wsConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	wmu  sync.Mutex
+}
+
+// copyTo writes the payload of the binary messages received to w, until
+// the client closes the connection or an error occurs.
+func (c *wsConn) copyTo(w io.Writer) {
+	for {
+		opcode, payload, err := c.readFrame()
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case wsBinary, wsContinuation:
+			if _, err := w.Write(payload); err != nil {
+				c.writeFrame(wsClose, closePayload(1011))
+				return
+			}
+		case wsPing:
+			c.writeFrame(wsPong, payload)
+		case wsPong:
+		case wsClose:
+			c.writeFrame(wsClose, payload)
+			return
+		default:
+			// Text messages are the base64 encoding of early websockify
+			c.writeFrame(wsClose, closePayload(1003))
+			return
+		}
+	}
+}
+
+// copyFrom sends what it reads from r as binary messages, until r fails.
+func (c *wsConn) copyFrom(r io.Reader) {
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if c.writeFrame(wsBinary, buf[:n]) != nil {
+				return
+			}
+		}
+		if err != nil {
+			c.writeFrame(wsClose, closePayload(1000))
+			return
+		}
+	}
+}
+
+// readFrame reads a frame, which clients must mask.
+func (c *wsConn) readFrame() (opcode byte, payload []byte, err error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode = hdr[0] & 0x0f
+	if hdr[1]&0x80 == 0 {
+		return 0, nil, errors.New("unmasked WebSocket frame")
+	}
+
+	size := uint64(hdr[1] & 0x7f)
+	switch size {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		size = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(ext[:])
+	}
+	if size > websocketMaxFrame {
+		return 0, nil, fmt.Errorf("WebSocket frame of %d bytes", size)
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.r, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload = make([]byte, size)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
+
+// writeFrame writes an unmasked final frame.
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	hdr := make([]byte, 2, 10)
+	hdr[0] = 0x80 | opcode
+	switch {
+	case len(payload) < 126:
+		hdr[1] = byte(len(payload))
+	case len(payload) <= 0xffff:
+		hdr[1] = 126
+		hdr = binary.BigEndian.AppendUint16(hdr, uint16(len(payload)))
+	default:
+		hdr[1] = 127
+		hdr = binary.BigEndian.AppendUint64(hdr, uint64(len(payload)))
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := c.conn.Write(append(hdr, payload...))
+	return err
+}
+
+// closePayload returns the payload of a close frame with a status code.
+func closePayload(code uint16) []byte {
+	return binary.BigEndian.AppendUint16(nil, code)
+}
@@ -0,0 +1,141 @@
+package qemuctl
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// wsDial opens a WebSocket connection to url as a browser would.
+func wsDial(t *testing.T, addr, path string) (net.Conn, *bufio.Reader, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	req := "GET " + path + " HTTP/1.1\r\nHost: " + addr + "\r\n" +
+		"Upgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n" +
+		"Sec-WebSocket-Protocol: binary\r\n\r\n"
+	if _, err := conn.Write([]byte(req)); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, br, resp
+}
+
+// wsWrite sends a masked client frame.
+func wsWrite(t *testing.T, conn net.Conn, opcode byte, payload []byte) {
+	t.Helper()
+	mask := []byte{1, 2, 3, 4}
+	frame := []byte{0x80 | opcode, 0x80 | byte(len(payload))}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// wsRead reads a short unmasked server frame.
+func wsRead(t *testing.T, br *bufio.Reader) (byte, []byte) {
+	t.Helper()
+	var hdr [2]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		t.Fatal(err)
+	}
+	if hdr[1]&0x80 != 0 || hdr[1] >= 126 {
+		t.Fatalf("unexpected frame header %x", hdr)
+	}
+	payload := make([]byte, hdr[1])
+	if _, err := io.ReadFull(br, payload); err != nil {
+		t.Fatal(err)
+	}
+	return hdr[0] & 0x0f, payload
+}
+
+func TestVNCProxy(t *testing.T) {
+	inst := &Instance{name: "web"}
+	proxy := NewVNCProxy()
+	proxy.AddToken("secret", inst)
+
+	// Stand in for QEMU: greet, then echo what the client sends
+	proxy.addClient = func(got *Instance, conn net.Conn, skipAuth bool) error {
+		if got != inst {
+			t.Errorf("client added to %v, want %v", got, inst)
+		}
+		f, err := conn.(*net.UnixConn).File()
+		if err != nil {
+			return err
+		}
+		vnc, err := net.FileConn(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer vnc.Close()
+			vnc.Write([]byte("RFB 003.008\n"))
+			buf := make([]byte, 12)
+			if _, err := io.ReadFull(vnc, buf); err == nil {
+				vnc.Write(buf)
+			}
+		}()
+		return nil
+	}
+
+	srv := httptest.NewServer(proxy)
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "http://")
+
+	conn, br, resp := wsDial(t, addr, "/websockify?token=secret")
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %s", resp.Status)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Sec-WebSocket-Accept = %q", got)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Protocol"); got != "binary" {
+		t.Errorf("Sec-WebSocket-Protocol = %q, want binary", got)
+	}
+
+	if op, payload := wsRead(t, br); op != wsBinary || string(payload) != "RFB 003.008\n" {
+		t.Fatalf("first message = %d %q", op, payload)
+	}
+	wsWrite(t, conn, wsPing, []byte("hi"))
+	if op, payload := wsRead(t, br); op != wsPong || string(payload) != "hi" {
+		t.Errorf("ping answer = %d %q", op, payload)
+	}
+	wsWrite(t, conn, wsBinary, []byte("RFB 003.008\n"))
+	if op, payload := wsRead(t, br); op != wsBinary || string(payload) != "RFB 003.008\n" {
+		t.Errorf("echo = %d %q", op, payload)
+	}
+
+	// QEMU closed the connection
+	if op, payload := wsRead(t, br); op != wsClose || binary.BigEndian.Uint16(payload) != 1000 {
+		t.Errorf("close = %d %x", op, payload)
+	}
+
+	proxy.RemoveToken("secret")
+	if _, _, resp := wsDial(t, addr, "/websockify?token=secret"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("removed token: status = %s", resp.Status)
+	}
+
+	proxy.AddToken("secret", inst)
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/websockify?token=secret", nil)
+	if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("plain GET: %v %v", resp, err)
+	}
+}
@@ -0,0 +1,36 @@
+package qemuctl
+
+import (
+	"errors"
+	"net"
+	"strconv"
+)
+
+// vsockMinCID is the first guest context ID; 0 to 2 are reserved for the
+// hypervisor, local communication and the host.
+const vsockMinCID = 3
+
+// VsockAddr is a vsock socket address.
+type VsockAddr struct {
+	CID  uint32
+	Port uint32
+}
+
+// Network returns "vsock".
+func (a *VsockAddr) Network() string {
+	return "vsock"
+}
+
+// String returns the address as "cid:port".
+func (a *VsockAddr) String() string {
+	return strconv.FormatUint(uint64(a.CID), 10) + ":" + strconv.FormatUint(uint64(a.Port), 10)
+}
+
+// DialVsock connects to port on the guest with the configured vsock CID
+// (see VMConfig.Vsock).
+func (i *Instance) DialVsock(port uint32) (net.Conn, error) {
+	if i.vmConfig == nil || i.vmConfig.Vsock == nil {
+		return nil, errors.New("no vsock device configured")
+	}
+	return DialVsock(i.vmConfig.Vsock.CID, port)
+}
@@ -0,0 +1,80 @@
+//go:build linux && !386
+
+package qemuctl
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// afVsock is AF_VSOCK, which the syscall package does not define.
+const afVsock = 40
+
+// rawSockaddrVM is struct sockaddr_vm.
+type rawSockaddrVM struct {
+	Family    uint16
+	Reserved1 uint16
+	Port      uint32
+	CID       uint32
+	Flags     uint8
+	Zero      [3]uint8
+}
+
+// DialVsock connects to a vsock port of the guest with context ID cid,
+// such as a service listening on a vhost-vsock device (see VsockConfig).
+func DialVsock(cid, port uint32) (net.Conn, error) {
+	fd, err := syscall.Socket(afVsock, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create vsock socket: %w", err)
+	}
+
+	raddr := &VsockAddr{CID: cid, Port: port}
+	sa := rawSockaddrVM{Family: afVsock, Port: port, CID: cid}
+	for {
+		_, _, errno := syscall.Syscall(syscall.SYS_CONNECT, uintptr(fd),
+			uintptr(unsafe.Pointer(&sa)), unsafe.Sizeof(sa))
+		if errno == syscall.EINTR {
+			continue
+		}
+		if errno != 0 {
+			syscall.Close(fd)
+			return nil, fmt.Errorf("failed to connect to vsock %s: %w", raddr, errno)
+		}
+		break
+	}
+
+	laddr := &VsockAddr{}
+	var local rawSockaddrVM
+	size := uint32(unsafe.Sizeof(local))
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_GETSOCKNAME, uintptr(fd),
+		uintptr(unsafe.Pointer(&local)), uintptr(unsafe.Pointer(&size))); errno == 0 {
+		laddr.CID, laddr.Port = local.CID, local.Port
+	}
+
+	// Non-blocking descriptors are registered with the runtime poller, so
+	// deadlines work
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	return &vsockConn{f: os.NewFile(uintptr(fd), "vsock:"+raddr.String()), laddr: laddr, raddr: raddr}, nil
+}
+
+// vsockConn is a connected vsock socket.
+type vsockConn struct {
+	f            *os.File
+	laddr, raddr *VsockAddr
+}
+
+func (c *vsockConn) Read(b []byte) (int, error)         { return c.f.Read(b) }
+func (c *vsockConn) Write(b []byte) (int, error)        { return c.f.Write(b) }
+func (c *vsockConn) Close() error                       { return c.f.Close() }
+func (c *vsockConn) LocalAddr() net.Addr                { return c.laddr }
+func (c *vsockConn) RemoteAddr() net.Addr               { return c.raddr }
+func (c *vsockConn) SetDeadline(t time.Time) error      { return c.f.SetDeadline(t) }
+func (c *vsockConn) SetReadDeadline(t time.Time) error  { return c.f.SetReadDeadline(t) }
+func (c *vsockConn) SetWriteDeadline(t time.Time) error { return c.f.SetWriteDeadline(t) }
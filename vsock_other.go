@@ -0,0 +1,13 @@
+//go:build !linux || 386
+
+package qemuctl
+
+import (
+	"errors"
+	"net"
+)
+
+// DialVsock is only supported on Linux.
+func DialVsock(cid, port uint32) (net.Conn, error) {
+	return nil, errors.New("vsock is not supported on this platform")
+}
@@ -0,0 +1,47 @@
+package qemuctl
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVMBuilderWithVsock(t *testing.T) {
+	cfg := &VMConfig{
+		Machine: &MachineConfig{Type: "q35", Accel: "tcg"},
+		Vsock:   &VsockConfig{CID: 42},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	args := NewVMBuilder(cfg).Build("test-vm", "/tmp/test.sock")
+	argsStr := strings.Join(args, " ")
+	if !strings.Contains(argsStr, "-device vhost-vsock-pci,id=vsock0,guest-cid=42,bus=pcie.0,addr=") {
+		t.Errorf("expected vhost-vsock-pci device, got: %s", argsStr)
+	}
+
+	parsed, err := ParseArgs(args)
+	if err != nil {
+		t.Fatalf("ParseArgs error: %v", err)
+	}
+	if parsed.Vsock == nil || parsed.Vsock.CID != 42 {
+		t.Errorf("unexpected parsed vsock config: %+v", parsed.Vsock)
+	}
+
+	cfg.Vsock.CID = 2
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate accepted reserved CID 2")
+	}
+}
+
+func TestInstanceDialVsockUnconfigured(t *testing.T) {
+	inst := &Instance{vmConfig: &VMConfig{}}
+	if _, err := inst.DialVsock(1024); err == nil {
+		t.Error("DialVsock succeeded without a vsock device")
+	}
+
+	addr := &VsockAddr{CID: 3, Port: 1024}
+	if addr.Network() != "vsock" || addr.String() != "3:1024" {
+		t.Errorf("addr = %s %s", addr.Network(), addr)
+	}
+}
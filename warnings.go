@@ -0,0 +1,362 @@
+package qemuctl
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stderrTailLines is how many lines of stderr are kept for support
+// bundles.
+const stderrTailLines = 200
+
+// startErrorLines is how many lines of stderr a StartError shows.
+const startErrorLines = 10
+
+// logReadLimit is how much of the output log of a daemonized QEMU is
+// read back at most at once.
+const logReadLimit = 1 << 20
+
+// stderrSettleTime is how long strict mode waits for pending stderr output
+// to be read once QEMU has finished initializing.
+const stderrSettleTime = 50 * time.Millisecond
+
+// WarningsError is returned by StartVM in strict mode when QEMU printed
+// warnings, typically about deprecated options.
+type WarningsError struct {
+	Warnings []string
+}
+
+func (e *WarningsError) Error() string {
+	return "QEMU reported warnings: " + strings.Join(e.Warnings, "; ")
+}
+
+// StartError is returned by Start and StartVM when QEMU exits or hangs
+// before its QMP socket is up, typically because of a bad option. Output
+// holds the last lines QEMU printed on stderr, which explain why.
+type StartError struct {
+	Err    error
+	Output []string
+}
+
+func (e *StartError) Error() string {
+	if len(e.Output) == 0 {
+		return e.Err.Error()
+	}
+	return e.Err.Error() + ": " + strings.Join(e.Output, "; ")
+}
+
+func (e *StartError) Unwrap() error {
+	return e.Err
+}
+
+// warningCollector reads QEMU's stderr and keeps the warning lines, and
+// the last lines of output.
+type warningCollector struct {
+	mu       sync.Mutex
+	warnings []string
+	lines    []string
+
+	// out receives a copy of stdout and stderr, if set
+	out *processOutput
+	// done is closed when QEMU exits, as its stderr or the exit pipe of
+	// a daemonized QEMU is closed
+	done chan struct{}
+
+	// logPath is the output log of a daemonized QEMU, read from
+	// logOffset as lines are requested
+	logPath   string
+	logOffset int64
+	// childFiles are the manager's copies of descriptors QEMU inherits
+	childFiles []*os.File
+}
+
+// collectWarnings attaches a warning collector to the stderr of cmd, and
+// copies stdout and stderr to out if it is not nil, closing it once QEMU
+// has closed both. It must be called before cmd is started.
+func collectWarnings(cmd *exec.Cmd, out *processOutput) (*warningCollector, error) {
+	r, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to capture stderr: %w", err)
+	}
+	w := &warningCollector{out: out, done: make(chan struct{})}
+	if out == nil {
+		go w.read(r)
+		return w, nil
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to capture stdout: %w", err)
+	}
+	copied := make(chan struct{})
+	go func() {
+		io.Copy(out, stdout)
+		close(copied)
+	}()
+	go func() {
+		w.read(r)
+		<-copied
+		out.Close()
+	}()
+	return w, nil
+}
+
+// collectLogWarnings attaches a warning collector to a daemonized QEMU,
+// whose stdout and stderr go to the log file at path, or /dev/null if
+// empty, so that QEMU can keep writing them once the managing process is
+// gone. The log is read back as lines are requested. QEMU also inherits
+// the write end of a pipe, which tells when it exits. It must be called
+// before cmd is started, and closeChildFiles right after.
+func collectLogWarnings(cmd *exec.Cmd, path string) (*warningCollector, error) {
+	dst := path
+	if dst == "" {
+		dst = os.DevNull
+	}
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open QEMU output log: %w", err)
+	}
+	w := &warningCollector{done: make(chan struct{})}
+	if path != "" {
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		w.logPath, w.logOffset = path, fi.Size()
+	}
+
+	r, exitW, err := os.Pipe()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	cmd.Stdout, cmd.Stderr = f, f
+	cmd.ExtraFiles = append(cmd.ExtraFiles, exitW)
+	w.childFiles = []*os.File{f, exitW}
+	go func() {
+		// Nothing is written, the read ends once QEMU has exited
+		io.Copy(io.Discard, r)
+		r.Close()
+		close(w.done)
+	}()
+	return w, nil
+}
+
+// closeChildFiles closes the manager's copies of the descriptors passed to
+// a daemonized QEMU, once it has been started or failed to.
+func (w *warningCollector) closeChildFiles() {
+	if w == nil {
+		return
+	}
+	for _, f := range w.childFiles {
+		f.Close()
+	}
+	w.childFiles = nil
+}
+
+// read consumes r until EOF so QEMU never blocks on a full pipe.
+func (w *warningCollector) read(r io.Reader) {
+	defer close(w.done)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if w.out != nil {
+			io.WriteString(w.out, line+"\n")
+		}
+		w.mu.Lock()
+		w.addLine(line)
+		w.mu.Unlock()
+	}
+	// Keep draining if a line was too long for the scanner
+	if w.out != nil {
+		io.Copy(w.out, r)
+	} else {
+		io.Copy(io.Discard, r)
+	}
+}
+
+// addLine records a line of output. w.mu must be held.
+func (w *warningCollector) addLine(line string) {
+	if msg, ok := parseWarning(line); ok {
+		w.warnings = append(w.warnings, msg)
+	}
+	if len(w.lines) == stderrTailLines {
+		w.lines = append(w.lines[:0], w.lines[1:]...)
+	}
+	w.lines = append(w.lines, line)
+}
+
+// readLog records the lines a daemonized QEMU appended to its log since
+// the last call, skipping to the last logReadLimit bytes if it wrote more.
+// A last line without a newline is left for later until QEMU has exited.
+// w.mu must be held.
+func (w *warningCollector) readLog() {
+	if w.logPath == "" {
+		return
+	}
+	f, err := os.Open(w.logPath)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || fi.Size() <= w.logOffset {
+		return
+	}
+	skipped := fi.Size()-w.logOffset > logReadLimit
+	if skipped {
+		w.logOffset = fi.Size() - logReadLimit
+	}
+	data, err := io.ReadAll(io.NewSectionReader(f, w.logOffset, fi.Size()-w.logOffset))
+	if err != nil {
+		return
+	}
+	if skipped {
+		// Resume at the start of a line
+		cut := bytes.IndexByte(data, '\n') + 1
+		w.logOffset += int64(cut)
+		data = data[cut:]
+	}
+
+	end := bytes.LastIndexByte(data, '\n') + 1
+	select {
+	case <-w.done:
+		end = len(data)
+	default:
+	}
+	w.logOffset += int64(end)
+	for _, line := range strings.SplitAfter(string(data[:end]), "\n") {
+		if line != "" {
+			w.addLine(strings.TrimSuffix(line, "\n"))
+		}
+	}
+}
+
+// startError wraps err, a failure to reach QEMU's QMP socket, with the
+// last lines of stderr. QEMU has been killed by then, so its stderr is
+// only read up to the end if it does not take long.
+func (w *warningCollector) startError(err error) error {
+	if w == nil {
+		return err
+	}
+	select {
+	case <-w.done:
+	case <-time.After(stderrSettleTime):
+	}
+	lines := w.tail()
+	if len(lines) > startErrorLines {
+		lines = lines[len(lines)-startErrorLines:]
+	}
+	return &StartError{Err: err, Output: lines}
+}
+
+// exited returns a channel closed when QEMU has closed stderr, or nil if
+// stderr is not read.
+func (w *warningCollector) exited() <-chan struct{} {
+	if w == nil {
+		return nil
+	}
+	return w.done
+}
+
+// processOutput is where QEMU's stdout and stderr are copied: an
+// appended log file and a writer, serialized since both streams are
+// copied concurrently. A failing destination is dropped without stopping
+// the other.
+type processOutput struct {
+	mu   sync.Mutex
+	w    bestEffortWriter
+	file *os.File
+}
+
+// openProcessOutput opens the output log at path, and returns nil if
+// neither path nor w is set.
+func openProcessOutput(path string, w io.Writer) (*processOutput, error) {
+	if path == "" && w == nil {
+		return nil, nil
+	}
+
+	out := &processOutput{}
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open QEMU output log: %w", err)
+		}
+		out.file = f
+		out.w.dst = append(out.w.dst, f)
+	}
+	if w != nil {
+		out.w.dst = append(out.w.dst, w)
+	}
+	return out, nil
+}
+
+func (o *processOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.w.Write(p)
+}
+
+// Close closes the log file.
+func (o *processOutput) Close() error {
+	if o.file == nil {
+		return nil
+	}
+	return o.file.Close()
+}
+
+// list returns a copy of the collected warnings.
+func (w *warningCollector) list() []string {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.readLog()
+	return append([]string(nil), w.warnings...)
+}
+
+// tail returns the last lines QEMU printed on stderr.
+func (w *warningCollector) tail() []string {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.readLog()
+	return append([]string(nil), w.lines...)
+}
+
+// parseWarning extracts the message from a QEMU warning line such as
+// "qemu-system-x86_64: -machine accel=kvm: warning: ... is deprecated".
+func parseWarning(line string) (string, bool) {
+	idx := strings.Index(line, "warning: ")
+	if idx < 0 {
+		return "", false
+	}
+
+	msg := line[idx+len("warning: "):]
+
+	// Keep the option that triggered the warning, drop the binary name
+	prefix := strings.TrimSuffix(strings.TrimSpace(line[:idx]), ":")
+	if _, opt, ok := strings.Cut(prefix, ": "); ok {
+		msg = opt + ": " + msg
+	}
+	return msg, true
+}
+
+// Warnings returns the warnings QEMU printed on stderr, such as notices
+// about deprecated options. It is only available for instances started by
+// this package.
+func (i *Instance) Warnings() []string {
+	return i.warnings.list()
+}
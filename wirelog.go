@@ -0,0 +1,70 @@
+package qemuctl
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// WireDirection tells whether a logged QMP message was sent or received.
+type WireDirection string
+
+const (
+	// WireSend marks messages written to QEMU.
+	WireSend WireDirection = "send"
+	// WireRecv marks messages read from QEMU: greetings, responses and events.
+	WireRecv WireDirection = "recv"
+)
+
+// SetWireLogger installs fn to be called with every QMP message sent or
+// received on the connection, one JSON message per call without the
+// trailing newline. This is intended for protocol debugging and audit
+// trails. Pass nil to remove the logger.
+//
+// fn is called from the connection's read and write goroutines and must
+// not block. raw is only valid for the duration of the call.
+//
+// Messages exchanged before the logger is installed, such as the initial
+// greeting, are not reported.
+func (q *QMP) SetWireLogger(fn func(dir WireDirection, raw []byte)) {
+	if fn == nil {
+		q.wireLog.Store(nil)
+		return
+	}
+	q.wireLog.Store(&fn)
+}
+
+// logSent reports written data to the wire logger, one line at a time.
+func (q *QMP) logSent(data []byte) {
+	fn := q.wireLog.Load()
+	if fn == nil {
+		return
+	}
+	for len(data) > 0 {
+		line, rest, _ := bytes.Cut(data, []byte{'\n'})
+		if len(line) > 0 {
+			(*fn)(WireSend, line)
+		}
+		data = rest
+	}
+}
+
+// decode reads the next message from dec into v, reporting it to the wire
+// logger. The logger is checked once the message has been read, so one
+// installed while the read loop was waiting still sees that message.
+func (q *QMP) decode(dec *json.Decoder, v any) error {
+	return dec.Decode(&wireMessage{q: q, v: v})
+}
+
+// wireMessage unmarshals into v and hands the raw message to the wire
+// logger, without copying it out of the decoder's buffer.
+type wireMessage struct {
+	q *QMP
+	v any
+}
+
+func (m *wireMessage) UnmarshalJSON(raw []byte) error {
+	if fn := m.q.wireLog.Load(); fn != nil {
+		(*fn)(WireRecv, raw)
+	}
+	return json.Unmarshal(raw, m.v)
+}
@@ -0,0 +1,84 @@
+package qemuctl
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestQMPWireLogger(t *testing.T) {
+	fake := newFakeQMP(t)
+	inst := fake.attach()
+
+	var mu sync.Mutex
+	var sent, recv []string
+	inst.QMP().SetWireLogger(func(dir WireDirection, raw []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch dir {
+		case WireSend:
+			sent = append(sent, string(raw))
+		case WireRecv:
+			recv = append(recv, string(raw))
+		}
+	})
+
+	if _, err := inst.QMP().Execute("query-status", nil); err != nil {
+		t.Fatalf("query-status failed: %v", err)
+	}
+	fake.sendEvent("RTC_CHANGE", map[string]any{"offset": 1})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		n := len(recv)
+		mu.Unlock()
+		if n >= 2 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(sent) != 1 || !strings.Contains(sent[0], `"execute":"query-status"`) || strings.HasSuffix(sent[0], "\n") {
+		t.Errorf("sent = %q, want one query-status line", sent)
+	}
+	if len(recv) != 2 || !strings.Contains(recv[0], `"return"`) || !strings.Contains(recv[1], `"RTC_CHANGE"`) {
+		t.Errorf("recv = %q, want response and event", recv)
+	}
+}
+
+func TestQMPWireLoggerBatch(t *testing.T) {
+	fake := newFakeQMP(t)
+	fake.handle("query-name", func(map[string]any) (any, *qmpError) {
+		return map[string]any{"name": "vm"}, nil
+	})
+	inst := fake.attach()
+
+	var mu sync.Mutex
+	var sent []string
+	inst.QMP().SetWireLogger(func(dir WireDirection, raw []byte) {
+		if dir == WireSend {
+			mu.Lock()
+			sent = append(sent, string(raw))
+			mu.Unlock()
+		}
+	})
+
+	if _, err := inst.ExecuteBatch([]BatchCommand{{Execute: "query-status"}, {Execute: "query-name"}}); err != nil {
+		t.Fatalf("ExecuteBatch failed: %v", err)
+	}
+
+	inst.QMP().SetWireLogger(nil)
+	if _, err := inst.QMP().Execute("query-status", nil); err != nil {
+		t.Fatalf("query-status failed: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(sent) != 2 {
+		t.Errorf("logged %d sent messages, want 2: %q", len(sent), sent)
+	}
+}